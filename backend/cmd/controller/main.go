@@ -11,7 +11,9 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
+	appconfig "github.com/devplatform/ldap-manager/internal/config"
 	"github.com/devplatform/ldap-manager/internal/controller"
+	"github.com/devplatform/ldap-manager/pkg/ldaptls"
 )
 
 var (
@@ -26,9 +28,23 @@ func main() {
 	kubeconfig := flag.String("kubeconfig", "", "Path to kubeconfig file")
 	namespace := flag.String("namespace", "dev-platform", "Namespace for OpenLDAP")
 	ldapURL := flag.String("ldap-url", "", "LDAP URL (default: internal service)")
+	tlsMode := flag.String("ldap-tls-mode", "none", "LDAP TLS mode (none, starttls, ldaps)")
+	tlsCAFile := flag.String("ldap-tls-ca-file", "", "PEM CA bundle for verifying the LDAP server")
+	tlsCertFile := flag.String("ldap-tls-cert-file", "", "Client certificate for mutual TLS")
+	tlsKeyFile := flag.String("ldap-tls-key-file", "", "Client key for mutual TLS")
+	tlsServerName := flag.String("ldap-tls-server-name", "", "Server name to verify (default: URL host)")
+	tlsInsecure := flag.Bool("ldap-tls-insecure-skip-verify", false, "Skip LDAP server certificate verification")
 	baseDN := flag.String("base-dn", "dc=devplatform,dc=local", "LDAP Base DN")
 	adminPassword := flag.String("admin-password", "admin123", "LDAP Admin password")
 	configPassword := flag.String("config-password", "config123", "LDAP Config password")
+	reconcileResources := flag.Bool("reconcile-resources", true, "Keep LDAP in line with LDAPDirectory, LDAPUser and LDAPGroup resources")
+	initDataConfigMap := flag.String("init-data-configmap", controller.DefaultInitDataConfigMap, "ConfigMap holding the init data as "+controller.InitDataKey+" (falls back to the defaults)")
+	pruneInitData := flag.Bool("prune-init-data", false, "Delete users, groups and departments removed from the init data")
+	manifestResync := flag.Duration("manifest-resync", controller.DefaultManifestResync, "How often to re-apply the OpenLDAP manifests to correct drift (0 to disable)")
+	pruneManifests := flag.Bool("prune-manifests", false, "Delete objects removed from the OpenLDAP manifests")
+	rolloutTimeout := flag.Duration("rollout-timeout", controller.DefaultRolloutTimeout, "How long to wait for applied StatefulSets and Deployments to roll out (0 to not wait)")
+	directoryResync := flag.Duration("directory-resync", controller.DefaultDirectoryResync, "How often to check LDAP for drift from the directory resources")
+	userAttributesFile := flag.String("user-attributes-file", "", "JSON file of custom user attributes to register in the schema")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 	showVersion := flag.Bool("version", false, "Show version information")
 	flag.Parse()
@@ -55,13 +71,36 @@ func main() {
 
 	// Build controller config
 	cfg := &controller.ControllerConfig{
-		Namespace:      *namespace,
-		LDAPTimeout:    30 * time.Second,
-		BaseDN:         *baseDN,
-		AdminDN:        "cn=admin," + *baseDN,
-		AdminPassword:  *adminPassword,
-		ConfigPassword: *configPassword,
-		InitData:       controller.DefaultInitData(),
+		Namespace:         *namespace,
+		LDAPTimeout:       30 * time.Second,
+		BaseDN:            *baseDN,
+		AdminDN:           "cn=admin," + *baseDN,
+		AdminPassword:     *adminPassword,
+		ConfigPassword:    *configPassword,
+		InitData:          controller.DefaultInitData(),
+		InitDataConfigMap: *initDataConfigMap,
+		PruneInitData:     *pruneInitData,
+		LDAPTLS: ldaptls.Options{
+			Mode:               *tlsMode,
+			CAFile:             *tlsCAFile,
+			CertFile:           *tlsCertFile,
+			KeyFile:            *tlsKeyFile,
+			ServerName:         *tlsServerName,
+			InsecureSkipVerify: *tlsInsecure,
+		},
+		ReconcileResources: *reconcileResources,
+		DirectoryResync:    *directoryResync,
+		ManifestResync:     *manifestResync,
+		PruneManifests:     *pruneManifests,
+		RolloutTimeout:     *rolloutTimeout,
+	}
+
+	if *userAttributesFile != "" {
+		defs, err := appconfig.LoadAttributeDefinitions(*userAttributesFile)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load user attributes")
+		}
+		cfg.UserAttributes = defs
 	}
 
 	if *ldapURL != "" {
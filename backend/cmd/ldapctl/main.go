@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devplatform/ldap-manager/internal/audit"
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/backup"
+	"github.com/devplatform/ldap-manager/internal/bulk"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/ldap"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+)
+
+const usage = `Usage: ldapctl <command> [flags]
+
+Commands:
+  import-users   Create or upsert users from a CSV or LDIF file
+  export-users   Write users as CSV or LDIF
+  backup         Take an LDIF snapshot of the directory
+  list-backups   List the snapshots, newest first
+  restore        Restore a snapshot, or with --dry-run show what would change
+
+LDAP connection settings are read from the same environment variables as the
+server (LDAP_URL, LDAP_BIND_DN, LDAP_BIND_PASSWORD, ...), and snapshots are
+kept in BACKUP_DIR.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import-users":
+		err = importUsers(os.Args[2:])
+	case "export-users":
+		err = exportUsers(os.Args[2:])
+	case "backup":
+		err = backupDirectory(os.Args[2:])
+	case "list-backups":
+		err = listBackups(os.Args[2:])
+	case "restore":
+		err = restoreDirectory(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func importUsers(args []string) error {
+	fs := flag.NewFlagSet("import-users", flag.ExitOnError)
+	format := fs.String("format", "csv", "Input format (csv, ldif)")
+	file := fs.String("file", "-", "Input file, - for stdin")
+	dryRun := fs.Bool("dry-run", false, "Validate and report without writing")
+	upsert := fs.Bool("upsert", false, "Update users that already exist")
+	actor := fs.String("actor", "ldapctl", "Actor recorded in the audit log")
+	fs.Parse(args)
+
+	f, err := bulk.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	data, err := readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	mgr, cleanup, err := connect()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), auth.ContextKeyUser, *actor)
+	report, err := bulk.NewImporter(mgr, logger()).Import(ctx, f, data, bulk.ImportOptions{
+		DryRun: *dryRun,
+		Upsert: *upsert,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := printJSON(report); err != nil {
+		return err
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, report.Total)
+	}
+	return nil
+}
+
+func exportUsers(args []string) error {
+	fs := flag.NewFlagSet("export-users", flag.ExitOnError)
+	format := fs.String("format", "csv", "Output format (csv, ldif)")
+	file := fs.String("file", "-", "Output file, - for stdout")
+	department := fs.String("department", "", "Only export users in this department")
+	fs.Parse(args)
+
+	f, err := bulk.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	mgr, cleanup, err := connect()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	filter := &models.SearchFilter{Department: *department}
+	data, err := bulk.NewImporter(mgr, logger()).Export(context.Background(), f, filter)
+	if err != nil {
+		return err
+	}
+
+	if *file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*file, data, 0o600)
+}
+
+func backupDirectory(args []string) error {
+	cfg := config.Load()
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := fs.String("dir", cfg.BackupDir, "Backup directory")
+	retain := fs.Int("retain", cfg.BackupRetain, "Number of snapshots to keep, 0 for all")
+	fs.Parse(args)
+
+	snapshotter, cleanup, err := openSnapshotter(*dir, *retain)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	snapshot, err := snapshotter.Backup(context.Background())
+	if err != nil {
+		return err
+	}
+	return printJSON(snapshot)
+}
+
+func listBackups(args []string) error {
+	cfg := config.Load()
+	fs := flag.NewFlagSet("list-backups", flag.ExitOnError)
+	dir := fs.String("dir", cfg.BackupDir, "Backup directory")
+	fs.Parse(args)
+
+	store, err := backup.NewStore(*dir)
+	if err != nil {
+		return err
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		fmt.Printf("%s\t%s\t%d\t%s\n", snapshot.Name, snapshot.CreatedAt.Format(time.RFC3339), snapshot.Size, snapshot.SHA256)
+	}
+	return nil
+}
+
+func restoreDirectory(args []string) error {
+	cfg := config.Load()
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dir := fs.String("dir", cfg.BackupDir, "Backup directory")
+	snapshot := fs.String("snapshot", "", "Snapshot to restore (default: the newest)")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without writing")
+	actor := fs.String("actor", "ldapctl", "Actor recorded in the audit log")
+	fs.Parse(args)
+
+	snapshotter, cleanup, err := openSnapshotter(*dir, cfg.BackupRetain)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), auth.ContextKeyUser, *actor)
+	result, err := snapshotter.Restore(ctx, *snapshot, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	printRestoreDiff(os.Stdout, result)
+	return nil
+}
+
+// printRestoreDiff writes the changes of a restore in a diff-like format,
+// with passwords redacted
+func printRestoreDiff(w io.Writer, result *models.RestoreResult) {
+	value := func(name, v string) string {
+		if strings.EqualFold(name, "userPassword") {
+			return "<redacted>"
+		}
+		return v
+	}
+
+	for _, change := range result.Changes {
+		switch change.Operation {
+		case models.RestoreAdd:
+			fmt.Fprintf(w, "+ %s\n", change.DN)
+			for _, name := range sortedKeys(change.After) {
+				for _, v := range change.After[name] {
+					fmt.Fprintf(w, "    %s: %s\n", name, value(name, v))
+				}
+			}
+		case models.RestoreModify:
+			fmt.Fprintf(w, "~ %s\n", change.DN)
+			names := sortedKeys(change.Before)
+			for _, name := range sortedKeys(change.After) {
+				if _, ok := change.Before[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, v := range change.Before[name] {
+					fmt.Fprintf(w, "  - %s: %s\n", name, value(name, v))
+				}
+				for _, v := range change.After[name] {
+					fmt.Fprintf(w, "  + %s: %s\n", name, value(name, v))
+				}
+			}
+		case models.RestoreDelete:
+			fmt.Fprintf(w, "- %s\n", change.DN)
+		}
+	}
+
+	verb := "Restored"
+	if result.DryRun {
+		verb = "Would restore"
+	}
+	fmt.Fprintf(w, "%s %s: %d added, %d modified, %d deleted\n", verb, result.Snapshot, result.Added, result.Modified, result.Deleted)
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// openSnapshotter connects to LDAP and opens the backup store in dir
+func openSnapshotter(dir string, retain int) (*backup.Snapshotter, func(), error) {
+	store, err := backup.NewStore(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mgr, cleanup, err := connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	return backup.NewSnapshotter(mgr, store, 0, retain, logger()), cleanup, nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// connect builds the same decorated LDAP stack as the server so that CLI
+// changes show up in metrics and the audit log
+func connect() (prometheus.LDAPInterface, func(), error) {
+	cfg := config.Load()
+	log := logger()
+
+	ldapMgr, err := ldap.NewManager(cfg, log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+
+	auditStore, err := audit.NewFileStore(cfg.AuditLogDir, "", log)
+	if err != nil {
+		ldapMgr.Close()
+		return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	mgr := audit.NewLDAPAuditor(prometheus.NewLDAPCollector(ldapMgr), auditStore, cfg, log)
+	cleanup := func() {
+		auditStore.Close()
+		ldapMgr.Close()
+	}
+	return mgr, cleanup, nil
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
+
+func logger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(os.Stderr)
+	log.SetLevel(logrus.WarnLevel)
+	log.SetFormatter(&logrus.TextFormatter{TimestampFormat: time.RFC3339})
+	return log
+}
@@ -2,6 +2,8 @@ package main
 
 import (
         "context"
+        "crypto/rand"
+        "encoding/hex"
         "encoding/json"
         "fmt"
         "net/http"
@@ -10,10 +12,14 @@ import (
         "syscall"
         "time"
 
+        "github.com/devplatform/ldap-manager/internal/audit"
         "github.com/devplatform/ldap-manager/internal/auth"
+        "github.com/devplatform/ldap-manager/internal/backup"
         "github.com/devplatform/ldap-manager/internal/config"
+        "github.com/devplatform/ldap-manager/internal/events"
         "github.com/devplatform/ldap-manager/internal/graphql"
         "github.com/devplatform/ldap-manager/internal/ldap"
+        "github.com/devplatform/ldap-manager/internal/lifecycle"
         "github.com/devplatform/ldap-manager/internal/prometheus"
         gql "github.com/graphql-go/graphql"
         promclient "github.com/prometheus/client_golang/prometheus"
@@ -77,13 +83,56 @@ func main() {
                 logger.Info("LDAP connection successful")
         }
 
+        // Keep the pool gauges current; the reaper reports after every pass
+        ldapMgr.SetStatsHook(prometheus.UpdatePoolMetrics)
+        prometheus.UpdatePoolMetrics(ldapMgr.GetStats())
+
         // Wrap LDAP manager with metrics collector
         instrumentedMgr := prometheus.NewLDAPCollector(ldapMgr)
         logger.Info("LDAP manager wrapped with Prometheus metrics collector")
 
+        // Wrap with audit logging so every directory change is recorded
+        auditStore, err := audit.NewFileStore(cfg.AuditLogDir, "", logger)
+        if err != nil {
+                logger.WithError(err).Fatal("Failed to open audit log")
+        }
+        defer auditStore.Close()
+        auditedMgr := audit.NewLDAPAuditor(instrumentedMgr, auditStore, cfg, logger)
+
+        // Disable expired accounts and purge deprovisioned ones in the background
+        if cfg.LifecycleSweepInterval > 0 {
+                sweepCtx, stopSweeper := context.WithCancel(ctx)
+                defer stopSweeper()
+                go lifecycle.NewSweeper(auditedMgr, cfg.LifecycleSweepInterval, logger).Run(sweepCtx)
+        }
+
+        // Back the directory up to LDIF snapshots, on a schedule and on demand
+        backupStore, err := backup.NewStore(cfg.BackupDir)
+        if err != nil {
+                logger.WithError(err).Fatal("Failed to open backup store")
+        }
+        snapshotter := backup.NewSnapshotter(auditedMgr, backupStore, cfg.BackupInterval, cfg.BackupRetain, logger)
+        if cfg.BackupInterval > 0 {
+                backupCtx, stopBackups := context.WithCancel(ctx)
+                defer stopBackups()
+                go snapshotter.Run(backupCtx)
+        }
+
+        // Publish directory changes to subscriptions and webhooks
+        var broker *events.Broker
+        if cfg.EventsEnabled {
+                broker = events.NewBroker(logger)
+                eventsCtx, stopEvents := context.WithCancel(ctx)
+                defer stopEvents()
+                if len(cfg.WebhookURLs) > 0 {
+                        go events.NewWebhookDispatcher(broker, cfg, logger).Run(eventsCtx)
+                }
+                go broker.Run(eventsCtx, ldapMgr, cfg.EventsSource, cfg.EventsPollInterval)
+        }
+
         // Initialize GraphQL schema
         logger.Info("Initializing GraphQL schema")
-        gqlSchema := graphql.NewSchema(instrumentedMgr, cfg, logger)
+        gqlSchema := graphql.NewSchema(auditedMgr, auditStore, broker, snapshotter, cfg, logger)
 
         // Setup HTTP server
         srv := setupHTTPServer(cfg, gqlSchema, ldapMgr, logger)
@@ -154,7 +203,8 @@ func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *lda
                         RequestString:  params.Query,
                         VariableValues: params.Variables,
                         OperationName:  params.OperationName,
-                        Context:        r.Context(),
+                        // Effective access fields share one hierarchy load
+                        Context:        ldap.WithHierarchySnapshot(r.Context()),
                 })
 
                 // Write response
@@ -165,6 +215,98 @@ func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *lda
                 json.NewEncoder(w).Encode(result)
         })
 
+        // GraphQL subscriptions, streamed as server-sent events: one "next"
+        // event per result, then "complete" when the subscription ends
+        mux.HandleFunc("/graphql/stream", func(w http.ResponseWriter, r *http.Request) {
+                // Handle CORS preflight
+                if r.Method == "OPTIONS" {
+                        w.WriteHeader(http.StatusOK)
+                        return
+                }
+
+                // Parse request: a JSON body, or query parameters for EventSource clients
+                var params struct {
+                        Query         string                 `json:"query"`
+                        OperationName string                 `json:"operationName"`
+                        Variables     map[string]interface{} `json:"variables"`
+                }
+
+                if r.Method == http.MethodGet {
+                        params.Query = r.URL.Query().Get("query")
+                        params.OperationName = r.URL.Query().Get("operationName")
+                        if vars := r.URL.Query().Get("variables"); vars != "" {
+                                if err := json.Unmarshal([]byte(vars), &params.Variables); err != nil {
+                                        http.Error(w, err.Error(), http.StatusBadRequest)
+                                        return
+                                }
+                        }
+                } else if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+                        http.Error(w, err.Error(), http.StatusBadRequest)
+                        return
+                }
+
+                // The stream outlives the server write timeout
+                rc := http.NewResponseController(w)
+                if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+                        logger.WithError(err).Warn("Failed to clear write deadline for event stream")
+                }
+
+                ctx, cancel := context.WithCancel(r.Context())
+                results := gql.Subscribe(gql.Params{
+                        Schema:         gqlSchema.GetSchema(),
+                        RequestString:  params.Query,
+                        VariableValues: params.Variables,
+                        OperationName:  params.OperationName,
+                        Context:        ctx,
+                })
+                // The subscription goroutine exits once ctx is cancelled, but
+                // only after a pending result has been read
+                defer func() {
+                        for range results {
+                        }
+                }()
+                defer cancel()
+
+                w.Header().Set("Content-Type", "text/event-stream")
+                w.Header().Set("Cache-Control", "no-cache")
+                w.Header().Set("X-Accel-Buffering", "no")
+                w.WriteHeader(http.StatusOK)
+                rc.Flush()
+
+                keepalive := time.NewTicker(30 * time.Second)
+                defer keepalive.Stop()
+
+                for {
+                        select {
+                        case result, ok := <-results:
+                                if !ok {
+                                        fmt.Fprint(w, "event: complete\ndata:\n\n")
+                                        rc.Flush()
+                                        return
+                                }
+                                if len(result.Errors) > 0 {
+                                        logger.WithField("errors", result.Errors).Warn("GraphQL subscription errors")
+                                }
+                                data, err := json.Marshal(result)
+                                if err != nil {
+                                        logger.WithError(err).Error("Failed to encode subscription result")
+                                        continue
+                                }
+                                if _, err := fmt.Fprintf(w, "event: next\ndata: %s\n\n", data); err != nil {
+                                        return
+                                }
+                                rc.Flush()
+                        case <-keepalive.C:
+                                if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+                                        return
+                                }
+                                rc.Flush()
+                        case <-ctx.Done():
+                                return
+                        }
+                }
+        })
+
         // Health endpoint (liveness probe)
         mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
                 w.Header().Set("Content-Type", "application/json")
@@ -198,11 +340,16 @@ func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *lda
         })
 
         // Apply middleware
-        authMw := auth.NewMiddleware(logger)
+        jwks := auth.NewJWKSProvider(cfg.JWKSEndpoint(), cfg.JWKSCacheTTL, logger)
+        authMw, err := auth.NewMiddleware(cfg, jwks, logger)
+        if err != nil {
+                logger.WithError(err).Fatal("Failed to initialize auth middleware")
+        }
         handler := corsMiddleware(cfg)(mux)
         handler = loggingMiddleware(logger)(handler)
         handler = metricsMiddleware()(handler)
         handler = authMw.ExtractToken(handler)
+        handler = requestIDMiddleware()(handler)
         handler = injectDependencies(handler, gqlSchema, logger)
 
         return &http.Server{
@@ -293,6 +440,25 @@ func metricsMiddleware() func(http.Handler) http.Handler {
         }
 }
 
+// requestIDMiddleware propagates X-Request-ID (set by Istio/Envoy) or
+// generates one, so audit records can be correlated with access logs
+func requestIDMiddleware() func(http.Handler) http.Handler {
+        return func(next http.Handler) http.Handler {
+                return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                        requestID := r.Header.Get("X-Request-ID")
+                        if requestID == "" {
+                                buf := make([]byte, 16)
+                                if _, err := rand.Read(buf); err == nil {
+                                        requestID = hex.EncodeToString(buf)
+                                }
+                        }
+
+                        w.Header().Set("X-Request-ID", requestID)
+                        next.ServeHTTP(w, r.WithContext(audit.WithRequestID(r.Context(), requestID)))
+                })
+        }
+}
+
 func injectDependencies(next http.Handler, gqlSchema *graphql.Schema, logger *logrus.Logger) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
                 // Inject dependencies into context
@@ -316,6 +482,12 @@ func (rw *responseWriter) WriteHeader(code int) {
         rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// the event stream needs for flushing and deadlines
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+        return rw.ResponseWriter
+}
+
 func waitForShutdown(srv *http.Server, ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger) {
         // Create channel to listen for interrupt signals
         quit := make(chan os.Signal, 1)
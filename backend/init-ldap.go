@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/devplatform/ldap-manager/pkg/ldapschema"
 	ldap "github.com/go-ldap/ldap/v3"
 )
 
 func main() {
-	// ─── Step 0: Register custom schema (githubRepository attribute) ───
-	fmt.Println("── Registering custom LDAP schema ──")
+	// ─── Step 0: Connect as config admin and data admin ───
+	fmt.Println("── Connecting to LDAP ──")
 
 	configConn, err := ldap.DialURL("ldap://localhost:30000")
 	if err != nil {
@@ -23,43 +24,6 @@ func main() {
 
 	fmt.Println("✓ Connected to cn=config as admin")
 
-	// Check if custom schema already exists
-	searchReq := ldap.NewSearchRequest(
-		"cn=schema,cn=config",
-		ldap.ScopeSingleLevel,
-		ldap.NeverDerefAliases, 0, 0, false,
-		"(cn=*devplatform)",
-		[]string{"cn"},
-		nil,
-	)
-
-	sr, err := configConn.Search(searchReq)
-	if err == nil && len(sr.Entries) > 0 {
-		fmt.Println("⚠ Custom schema (devplatform) already exists, skipping")
-	} else {
-		addSchemaReq := ldap.NewAddRequest("cn=devplatform,cn=schema,cn=config", nil)
-		addSchemaReq.Attribute("objectClass", []string{"olcSchemaConfig"})
-		addSchemaReq.Attribute("cn", []string{"devplatform"})
-		addSchemaReq.Attribute("olcAttributeTypes", []string{
-			"( 1.3.6.1.4.1.99999.1.1 NAME 'githubRepository' " +
-				"DESC 'Repository URL (GitHub/Gitea)' " +
-				"EQUALITY caseIgnoreMatch " +
-				"SUBSTR caseIgnoreSubstringsMatch " +
-				"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
-		})
-
-		err = configConn.Add(addSchemaReq)
-		if err != nil {
-			log.Fatalf("Failed to register custom schema: %v", err)
-		}
-		fmt.Println("✓ Registered custom schema (githubRepository attribute)")
-	}
-
-	configConn.Close()
-
-	// ─── Step 1: Connect as data admin ───
-	fmt.Println("\n── Initializing LDAP data ──")
-
 	conn, err := ldap.DialURL("ldap://localhost:30000")
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
@@ -73,6 +37,25 @@ func main() {
 
 	fmt.Println("✓ Connected to LDAP as admin")
 
+	// ─── Step 1: Apply pending schema and data migrations ───
+	fmt.Println("\n── Migrating LDAP schema ──")
+
+	migrator := &ldapschema.Migrator{Config: configConn, Data: conn, BaseDN: "dc=devplatform,dc=local"}
+	result, err := migrator.Migrate()
+	for _, migration := range result.Applied {
+		fmt.Printf("✓ Applied migration %d: %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		log.Fatalf("Failed to migrate schema: %v", err)
+	}
+	if result.From == result.To {
+		fmt.Printf("⚠ Schema already at version %d, skipping\n", result.To)
+	}
+
+	configConn.Close()
+
+	fmt.Println("\n── Initializing LDAP data ──")
+
 	// ─── Step 2: Create base OUs ───
 	ous := []struct {
 		dn   string
@@ -204,7 +187,7 @@ func main() {
 	for _, dept := range departments {
 		deptDN := fmt.Sprintf("ou=%s,ou=departments,dc=devplatform,dc=local", dept.name)
 		addDeptReq := ldap.NewAddRequest(deptDN, nil)
-		addDeptReq.Attribute("objectClass", []string{"organizationalUnit"})
+		addDeptReq.Attribute("objectClass", []string{"organizationalUnit", "extensibleObject"})
 		addDeptReq.Attribute("ou", []string{dept.name})
 		addDeptReq.Attribute("description", []string{dept.desc})
 
@@ -0,0 +1,315 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionCreateUser             = "user.create"
+	ActionUpdateUser             = "user.update"
+	ActionDeleteUser             = "user.delete"
+	ActionRestoreUser            = "user.restore"
+	ActionChangePassword         = "user.password_change"
+	ActionResetPassword          = "user.password_reset"
+	ActionDisableUser            = "user.disable"
+	ActionEnableUser             = "user.enable"
+	ActionSetUserExpiry          = "user.expiry_set"
+	ActionDeprovisionUser        = "user.deprovision"
+	ActionAddSSHKey              = "user.ssh_key_add"
+	ActionRemoveSSHKey           = "user.ssh_key_remove"
+	ActionAddUserRepos           = "user.repos_add"
+	ActionRemoveUserRepos        = "user.repos_remove"
+	ActionCreateGroup            = "group.create"
+	ActionDeleteGroup            = "group.delete"
+	ActionAddGroupMember         = "group.member_add"
+	ActionRemoveGroupMember      = "group.member_remove"
+	ActionAssignGroupRepos       = "group.repos_assign"
+	ActionAddGroupRepos          = "group.repos_add"
+	ActionRemoveGroupRepos       = "group.repos_remove"
+	ActionAddSubgroup            = "group.subgroup_add"
+	ActionRemoveSubgroup         = "group.subgroup_remove"
+	ActionCreateDepartment       = "department.create"
+	ActionDeleteDepartment       = "department.delete"
+	ActionAssignDepartmentRepos  = "department.repos_assign"
+	ActionAddDepartmentRepos     = "department.repos_add"
+	ActionRemoveDepartmentRepos  = "department.repos_remove"
+	ActionSetDepartmentParent    = "department.parent_set"
+	ActionSetDepartmentManager   = "department.manager_set"
+	ActionAddDepartmentDeputy    = "department.deputy_add"
+	ActionRemoveDepartmentDeputy = "department.deputy_remove"
+	ActionMigrateGrants          = "directory.grants_migrate"
+	ActionRequestAccess          = "access_request.create"
+	ActionApproveAccess          = "access_request.approve"
+	ActionDenyAccess             = "access_request.deny"
+	ActionRevokeExpiredGrants    = "directory.grants_revoke_expired"
+	ActionRestoreDirectory       = "directory.restore"
+)
+
+// redacted replaces secret attribute values in audit records
+const redacted = "<redacted>"
+
+// AttributeChange is the before/after state of one LDAP attribute
+type AttributeChange struct {
+	Attribute string   `json:"attribute"`
+	Before    []string `json:"before,omitempty"`
+	After     []string `json:"after,omitempty"`
+}
+
+// Record is a single audit log entry describing one directory mutation
+type Record struct {
+	ID        string            `json:"id"`
+	Timestamp time.Time         `json:"timestamp"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	TargetDN  string            `json:"targetDn"`
+	Changes   []AttributeChange `json:"changes,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+	Success   bool              `json:"success"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Filter restricts audit log queries. Empty fields match everything.
+type Filter struct {
+	Actor     string    `json:"actor,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Target    string    `json:"target,omitempty"`    // substring of the target DN
+	Attribute string    `json:"attribute,omitempty"` // records changing this attribute
+	Value     string    `json:"value,omitempty"`     // substring of any before/after value
+	RequestID string    `json:"requestId,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+}
+
+// Store persists audit records
+type Store interface {
+	// Append durably adds a record to the log
+	Append(ctx context.Context, record *Record) error
+
+	// Query returns matching records newest first, plus the total match count
+	Query(ctx context.Context, filter *Filter, limit, offset int) ([]*Record, int, error)
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// REQUEST CONTEXT
+// ═══════════════════════════════════════════════════════════════════════════
+
+type contextKey string
+
+const contextKeyRequestID contextKey = "request_id"
+
+// WithRequestID stores the request ID used to correlate audit records
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKeyRequestID, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored by WithRequestID
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKeyRequestID).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// FILTER MATCHING
+// ═══════════════════════════════════════════════════════════════════════════
+
+// Matches reports whether the record satisfies the filter
+func (f *Filter) Matches(r *Record) bool {
+	if f == nil {
+		return true
+	}
+	if f.Actor != "" && r.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && r.Action != f.Action {
+		return false
+	}
+	if f.Target != "" && !containsFold(r.TargetDN, f.Target) {
+		return false
+	}
+	if f.RequestID != "" && r.RequestID != f.RequestID {
+		return false
+	}
+	if !f.Since.IsZero() && r.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Timestamp.After(f.Until) {
+		return false
+	}
+	if f.Attribute == "" && f.Value == "" {
+		return true
+	}
+
+	for _, change := range r.Changes {
+		if f.Attribute != "" && !equalFold(change.Attribute, f.Attribute) {
+			continue
+		}
+		if f.Value == "" {
+			return true
+		}
+		for _, v := range append(append([]string{}, change.Before...), change.After...) {
+			if containsFold(v, f.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// ATTRIBUTE DIFFS
+// ═══════════════════════════════════════════════════════════════════════════
+
+// Diff returns the attributes whose values differ between before and after.
+// Either side may be nil for creations and deletions.
+func Diff(before, after map[string][]string) []AttributeChange {
+	names := make(map[string]struct{})
+	for name := range before {
+		names[name] = struct{}{}
+	}
+	for name := range after {
+		names[name] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []AttributeChange
+	for _, name := range sorted {
+		b := normalize(before[name])
+		a := normalize(after[name])
+		if equalValues(b, a) {
+			continue
+		}
+		changes = append(changes, AttributeChange{Attribute: name, Before: b, After: a})
+	}
+	return changes
+}
+
+// PasswordChange is the redacted change recorded when a password is set
+func PasswordChange() AttributeChange {
+	return AttributeChange{Attribute: "userPassword", Before: []string{redacted}, After: []string{redacted}}
+}
+
+// UserAttributes flattens a user into its LDAP attributes
+func UserAttributes(u *models.User) map[string][]string {
+	if u == nil {
+		return nil
+	}
+	return map[string][]string{
+		"uid":              single(u.UID),
+		"cn":               single(u.CN),
+		"sn":               single(u.SN),
+		"givenName":        single(u.GivenName),
+		"mail":             single(u.Mail),
+		"departmentNumber": single(u.Department),
+		"uidNumber":        single(strconv.Itoa(u.UIDNumber)),
+		"gidNumber":        single(strconv.Itoa(u.GIDNumber)),
+		"homeDirectory":    single(u.HomeDir),
+		"githubRepository": u.Repositories,
+		"repositoryGrant":  models.GrantValues(u.Grants),
+		"accountStatus":    single(u.Status),
+		"accountExpires":   single(formatTime(u.ExpiresAt)),
+		"deprovisionAt":    single(formatTime(u.DeprovisionAt)),
+	}
+}
+
+// GroupAttributes flattens a group into its LDAP attributes
+func GroupAttributes(g *models.Group) map[string][]string {
+	if g == nil {
+		return nil
+	}
+	return map[string][]string{
+		"cn":               single(g.CN),
+		"description":      single(g.Description),
+		"gidNumber":        single(strconv.Itoa(g.GIDNumber)),
+		"member":           g.Members,
+		"memberGroup":      g.Subgroups,
+		"githubRepository": g.Repositories,
+		"repositoryGrant":  models.GrantValues(g.Grants),
+	}
+}
+
+// DepartmentAttributes flattens a department into its LDAP attributes
+func DepartmentAttributes(d *models.Department) map[string][]string {
+	if d == nil {
+		return nil
+	}
+	return map[string][]string{
+		"ou":               single(d.OU),
+		"description":      single(d.Description),
+		"manager":          single(d.Manager),
+		"departmentDeputy": d.Deputies,
+		"parentDepartment": single(d.Parent),
+		"githubRepository": d.Repositories,
+		"repositoryGrant":  models.GrantValues(d.Grants),
+	}
+}
+
+// AccessRequestAttributes flattens an access request into its LDAP
+// attributes
+func AccessRequestAttributes(r *models.AccessRequest) map[string][]string {
+	if r == nil {
+		return nil
+	}
+	grant := models.RepositoryGrant{Repository: r.Repository, Permission: r.Permission, ExpiresAt: r.ExpiresAt}
+	return map[string][]string{
+		"cn":                    single(r.ID),
+		"description":           single(r.Reason),
+		"repositoryGrant":       single(grant.String()),
+		"accessRequester":       single(r.Requester),
+		"accessRequestDuration": single(strconv.FormatInt(int64(r.Duration/time.Second), 10)),
+		"accessRequestStatus":   single(r.Status),
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func single(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+func normalize(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	out := append([]string{}, values...)
+	sort.Strings(out)
+	return out
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecordID returns a sortable, practically unique record ID
+func newRecordID(ts time.Time, node string, seq uint64) string {
+	return fmt.Sprintf("%d-%s-%d", ts.UnixNano(), node, seq)
+}
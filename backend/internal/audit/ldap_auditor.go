@@ -0,0 +1,734 @@
+package audit
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// LDAPAuditor wraps an LDAPInterface and writes an audit record for every
+// operation that mutates the directory. Reads are passed straight through.
+type LDAPAuditor struct {
+	next   prometheus.LDAPInterface
+	store  Store
+	config *config.Config
+	logger *logrus.Logger
+}
+
+// NewLDAPAuditor creates a new auditing wrapper around an LDAPInterface
+func NewLDAPAuditor(next prometheus.LDAPInterface, store Store, cfg *config.Config, logger *logrus.Logger) *LDAPAuditor {
+	return &LDAPAuditor{
+		next:   next,
+		store:  store,
+		config: cfg,
+		logger: logger,
+	}
+}
+
+// Store returns the underlying audit store for queries
+func (a *LDAPAuditor) Store() Store {
+	return a.store
+}
+
+// record appends an audit record for a finished operation. Failing to audit
+// is logged loudly but never fails the directory operation itself, since the
+// change has already been applied.
+func (a *LDAPAuditor) record(ctx context.Context, action, targetDN string, changes []AttributeChange, opErr error) {
+	rec := &Record{
+		Timestamp: time.Now().UTC(),
+		Actor:     auth.GetUserFromContext(ctx),
+		Action:    action,
+		TargetDN:  targetDN,
+		Changes:   changes,
+		RequestID: RequestIDFromContext(ctx),
+		Success:   opErr == nil,
+	}
+	if rec.Actor == "" {
+		rec.Actor = "system"
+	}
+	if opErr != nil {
+		rec.Error = opErr.Error()
+	}
+
+	if err := a.store.Append(ctx, rec); err != nil {
+		a.logger.WithError(err).WithFields(logrus.Fields{
+			"action": action,
+			"target": targetDN,
+			"actor":  rec.Actor,
+		}).Error("Failed to write audit record")
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// USER OPERATIONS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
+	user, err := a.next.CreateUser(ctx, input)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = append(Diff(nil, a.userAttributes(user)), PasswordChange())
+	}
+	a.record(ctx, ActionCreateUser, a.config.UserDN(input.UID), changes, err)
+
+	return user, err
+}
+
+func (a *LDAPAuditor) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	return a.next.GetUser(ctx, uid)
+}
+
+func (a *LDAPAuditor) ListUsers(ctx context.Context, filter *models.SearchFilter) ([]*models.User, error) {
+	return a.next.ListUsers(ctx, filter)
+}
+
+func (a *LDAPAuditor) ListUsersPage(ctx context.Context, filter *models.SearchFilter, page *models.PageRequest) (*models.UserPage, error) {
+	return a.next.ListUsersPage(ctx, filter, page)
+}
+
+func (a *LDAPAuditor) SearchDirectory(ctx context.Context, query string, limit int) ([]*models.DirectorySearchResult, error) {
+	return a.next.SearchDirectory(ctx, query, limit)
+}
+
+func (a *LDAPAuditor) UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, input.UID)
+	user, err := a.next.UpdateUser(ctx, input)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(user))
+		if input.Password != nil {
+			changes = append(changes, PasswordChange())
+		}
+	}
+	a.record(ctx, ActionUpdateUser, a.config.UserDN(input.UID), changes, err)
+
+	return user, err
+}
+
+func (a *LDAPAuditor) RestoreUser(ctx context.Context, snapshot *models.User) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, snapshot.UID)
+	user, err := a.next.RestoreUser(ctx, snapshot)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(user))
+	}
+	a.record(ctx, ActionRestoreUser, a.config.UserDN(snapshot.UID), changes, err)
+
+	return user, err
+}
+
+func (a *LDAPAuditor) DeleteUser(ctx context.Context, uid string) error {
+	before, _ := a.next.GetUser(ctx, uid)
+	err := a.next.DeleteUser(ctx, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), nil)
+	}
+	a.record(ctx, ActionDeleteUser, a.config.UserDN(uid), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) Authenticate(ctx context.Context, uid, password string) (*models.User, error) {
+	return a.next.Authenticate(ctx, uid, password)
+}
+
+func (a *LDAPAuditor) ChangePassword(ctx context.Context, uid, oldPassword, newPassword string) error {
+	err := a.next.ChangePassword(ctx, uid, oldPassword, newPassword)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = []AttributeChange{PasswordChange()}
+	}
+	a.record(ctx, ActionChangePassword, a.config.UserDN(uid), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) ValidatePassword(ctx context.Context, uid, password string) error {
+	return a.next.ValidatePassword(ctx, uid, password)
+}
+
+func (a *LDAPAuditor) ResetPassword(ctx context.Context, uid string) (string, error) {
+	password, err := a.next.ResetPassword(ctx, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = []AttributeChange{PasswordChange()}
+	}
+	a.record(ctx, ActionResetPassword, a.config.UserDN(uid), changes, err)
+
+	return password, err
+}
+
+func (a *LDAPAuditor) DisableUser(ctx context.Context, uid string) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, uid)
+	user, err := a.next.DisableUser(ctx, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(user))
+	}
+	a.record(ctx, ActionDisableUser, a.config.UserDN(uid), changes, err)
+
+	return user, err
+}
+
+func (a *LDAPAuditor) EnableUser(ctx context.Context, uid string) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, uid)
+	user, err := a.next.EnableUser(ctx, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(user))
+	}
+	a.record(ctx, ActionEnableUser, a.config.UserDN(uid), changes, err)
+
+	return user, err
+}
+
+func (a *LDAPAuditor) SetUserExpiry(ctx context.Context, uid string, expiresAt *time.Time) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, uid)
+	user, err := a.next.SetUserExpiry(ctx, uid, expiresAt)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(user))
+	}
+	a.record(ctx, ActionSetUserExpiry, a.config.UserDN(uid), changes, err)
+
+	return user, err
+}
+
+// DeprovisionUser records the user's own changes plus one "memberOf" change
+// listing the groups it was removed from, so the trail shows exactly which
+// access was taken away
+func (a *LDAPAuditor) DeprovisionUser(ctx context.Context, uid string) (*models.DeprovisionResult, error) {
+	before, _ := a.next.GetUser(ctx, uid)
+	result, err := a.next.DeprovisionUser(ctx, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(result.User))
+		if len(result.RemovedGroups) > 0 {
+			changes = append(changes, AttributeChange{Attribute: "memberOf", Before: normalize(result.RemovedGroups)})
+		}
+	}
+	a.record(ctx, ActionDeprovisionUser, a.config.UserDN(uid), changes, err)
+
+	return result, err
+}
+
+func (a *LDAPAuditor) FindUsersDue(ctx context.Context, now time.Time) (*models.LifecycleDue, error) {
+	return a.next.FindUsersDue(ctx, now)
+}
+
+func (a *LDAPAuditor) ListSSHKeys(ctx context.Context, uid string) ([]*models.SSHKey, error) {
+	return a.next.ListSSHKeys(ctx, uid)
+}
+
+func (a *LDAPAuditor) AddSSHKey(ctx context.Context, uid, authorizedKey string) (*models.SSHKey, error) {
+	key, err := a.next.AddSSHKey(ctx, uid, authorizedKey)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = []AttributeChange{{Attribute: "sshPublicKey", After: []string{key.Fingerprint}}}
+	}
+	a.record(ctx, ActionAddSSHKey, a.config.UserDN(uid), changes, err)
+
+	return key, err
+}
+
+func (a *LDAPAuditor) RemoveSSHKey(ctx context.Context, uid, fingerprint string) error {
+	err := a.next.RemoveSSHKey(ctx, uid, fingerprint)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = []AttributeChange{{Attribute: "sshPublicKey", Before: []string{fingerprint}}}
+	}
+	a.record(ctx, ActionRemoveSSHKey, a.config.UserDN(uid), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) AddUserRepositories(ctx context.Context, uid string, grants []models.RepositoryGrant) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, uid)
+	after, err := a.next.AddUserRepositories(ctx, uid, grants)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(after))
+	}
+	a.record(ctx, ActionAddUserRepos, a.config.UserDN(uid), changes, err)
+
+	return after, err
+}
+
+func (a *LDAPAuditor) RemoveUserRepositories(ctx context.Context, uid string, repos []string) (*models.User, error) {
+	before, _ := a.next.GetUser(ctx, uid)
+	after, err := a.next.RemoveUserRepositories(ctx, uid, repos)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(a.userAttributes(before), a.userAttributes(after))
+	}
+	a.record(ctx, ActionRemoveUserRepos, a.config.UserDN(uid), changes, err)
+
+	return after, err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// GROUP OPERATIONS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) CreateGroup(ctx context.Context, cn, description string) (*models.Group, error) {
+	group, err := a.next.CreateGroup(ctx, cn, description)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(nil, GroupAttributes(group))
+	}
+	a.record(ctx, ActionCreateGroup, a.config.GroupDN(cn), changes, err)
+
+	return group, err
+}
+
+func (a *LDAPAuditor) GetGroup(ctx context.Context, cn string) (*models.Group, error) {
+	return a.next.GetGroup(ctx, cn)
+}
+
+func (a *LDAPAuditor) ListGroups(ctx context.Context) ([]*models.Group, error) {
+	return a.next.ListGroups(ctx)
+}
+
+func (a *LDAPAuditor) ListGroupsPage(ctx context.Context, filter *models.GroupFilter, page *models.PageRequest) (*models.GroupPage, error) {
+	return a.next.ListGroupsPage(ctx, filter, page)
+}
+
+func (a *LDAPAuditor) DeleteGroup(ctx context.Context, cn string) error {
+	before, _ := a.next.GetGroup(ctx, cn)
+	err := a.next.DeleteGroup(ctx, cn)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(GroupAttributes(before), nil)
+	}
+	a.record(ctx, ActionDeleteGroup, a.config.GroupDN(cn), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) AddUserToGroup(ctx context.Context, uid, groupCN string) error {
+	before, _ := a.next.GetGroup(ctx, groupCN)
+	err := a.next.AddUserToGroup(ctx, uid, groupCN)
+
+	var changes []AttributeChange
+	if err == nil {
+		after, _ := a.next.GetGroup(ctx, groupCN)
+		changes = Diff(GroupAttributes(before), GroupAttributes(after))
+	}
+	a.record(ctx, ActionAddGroupMember, a.config.GroupDN(groupCN), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) RemoveUserFromGroup(ctx context.Context, uid, groupCN string) error {
+	before, _ := a.next.GetGroup(ctx, groupCN)
+	err := a.next.RemoveUserFromGroup(ctx, uid, groupCN)
+
+	var changes []AttributeChange
+	if err == nil {
+		after, _ := a.next.GetGroup(ctx, groupCN)
+		changes = Diff(GroupAttributes(before), GroupAttributes(after))
+	}
+	a.record(ctx, ActionRemoveGroupMember, a.config.GroupDN(groupCN), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) AssignRepositoriesToGroup(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error) {
+	before, _ := a.next.GetGroup(ctx, cn)
+	group, err := a.next.AssignRepositoriesToGroup(ctx, cn, grants)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(GroupAttributes(before), GroupAttributes(group))
+	}
+	a.record(ctx, ActionAssignGroupRepos, a.config.GroupDN(cn), changes, err)
+
+	return group, err
+}
+
+func (a *LDAPAuditor) AddGroupRepositories(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error) {
+	before, _ := a.next.GetGroup(ctx, cn)
+	after, err := a.next.AddGroupRepositories(ctx, cn, grants)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(GroupAttributes(before), GroupAttributes(after))
+	}
+	a.record(ctx, ActionAddGroupRepos, a.config.GroupDN(cn), changes, err)
+
+	return after, err
+}
+
+func (a *LDAPAuditor) RemoveGroupRepositories(ctx context.Context, cn string, repos []string) (*models.Group, error) {
+	before, _ := a.next.GetGroup(ctx, cn)
+	after, err := a.next.RemoveGroupRepositories(ctx, cn, repos)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(GroupAttributes(before), GroupAttributes(after))
+	}
+	a.record(ctx, ActionRemoveGroupRepos, a.config.GroupDN(cn), changes, err)
+
+	return after, err
+}
+
+func (a *LDAPAuditor) AddGroupToGroup(ctx context.Context, childCN, parentCN string) error {
+	before, _ := a.next.GetGroup(ctx, parentCN)
+	err := a.next.AddGroupToGroup(ctx, childCN, parentCN)
+
+	var changes []AttributeChange
+	if err == nil {
+		after, _ := a.next.GetGroup(ctx, parentCN)
+		changes = Diff(GroupAttributes(before), GroupAttributes(after))
+	}
+	a.record(ctx, ActionAddSubgroup, a.config.GroupDN(parentCN), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) RemoveGroupFromGroup(ctx context.Context, childCN, parentCN string) error {
+	before, _ := a.next.GetGroup(ctx, parentCN)
+	err := a.next.RemoveGroupFromGroup(ctx, childCN, parentCN)
+
+	var changes []AttributeChange
+	if err == nil {
+		after, _ := a.next.GetGroup(ctx, parentCN)
+		changes = Diff(GroupAttributes(before), GroupAttributes(after))
+	}
+	a.record(ctx, ActionRemoveSubgroup, a.config.GroupDN(parentCN), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) GetGroupEffectiveMembers(ctx context.Context, cn string) ([]string, error) {
+	return a.next.GetGroupEffectiveMembers(ctx, cn)
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// DEPARTMENT OPERATIONS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) CreateDepartment(ctx context.Context, input *models.CreateDepartmentInput) (*models.Department, error) {
+	dept, err := a.next.CreateDepartment(ctx, input)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(nil, DepartmentAttributes(dept))
+	}
+	a.record(ctx, ActionCreateDepartment, a.config.DepartmentDN(input.OU), changes, err)
+
+	return dept, err
+}
+
+func (a *LDAPAuditor) GetDepartment(ctx context.Context, ou string) (*models.Department, error) {
+	return a.next.GetDepartment(ctx, ou)
+}
+
+func (a *LDAPAuditor) ListDepartments(ctx context.Context) ([]*models.Department, error) {
+	return a.next.ListDepartments(ctx)
+}
+
+func (a *LDAPAuditor) ListDepartmentsPage(ctx context.Context, filter *models.DepartmentFilter, page *models.PageRequest) (*models.DepartmentPage, error) {
+	return a.next.ListDepartmentsPage(ctx, filter, page)
+}
+
+func (a *LDAPAuditor) DeleteDepartment(ctx context.Context, ou string) error {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	err := a.next.DeleteDepartment(ctx, ou)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), nil)
+	}
+	a.record(ctx, ActionDeleteDepartment, a.config.DepartmentDN(ou), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) AssignRepositoryToDepartment(ctx context.Context, ou string, grants []models.RepositoryGrant) error {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	err := a.next.AssignRepositoryToDepartment(ctx, ou, grants)
+
+	var changes []AttributeChange
+	if err == nil {
+		after, _ := a.next.GetDepartment(ctx, ou)
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(after))
+	}
+	a.record(ctx, ActionAssignDepartmentRepos, a.config.DepartmentDN(ou), changes, err)
+
+	return err
+}
+
+func (a *LDAPAuditor) AddDepartmentRepositories(ctx context.Context, ou string, grants []models.RepositoryGrant) (*models.Department, error) {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	after, err := a.next.AddDepartmentRepositories(ctx, ou, grants)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(after))
+	}
+	a.record(ctx, ActionAddDepartmentRepos, a.config.DepartmentDN(ou), changes, err)
+
+	return after, err
+}
+
+func (a *LDAPAuditor) RemoveDepartmentRepositories(ctx context.Context, ou string, repos []string) (*models.Department, error) {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	after, err := a.next.RemoveDepartmentRepositories(ctx, ou, repos)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(after))
+	}
+	a.record(ctx, ActionRemoveDepartmentRepos, a.config.DepartmentDN(ou), changes, err)
+
+	return after, err
+}
+
+func (a *LDAPAuditor) GetUsersByDepartment(ctx context.Context, department string) ([]*models.User, error) {
+	return a.next.GetUsersByDepartment(ctx, department)
+}
+
+func (a *LDAPAuditor) SetDepartmentParent(ctx context.Context, ou, parent string) (*models.Department, error) {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	dept, err := a.next.SetDepartmentParent(ctx, ou, parent)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(dept))
+	}
+	a.record(ctx, ActionSetDepartmentParent, a.config.DepartmentDN(ou), changes, err)
+
+	return dept, err
+}
+
+func (a *LDAPAuditor) GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error) {
+	return a.next.GetDepartmentChildren(ctx, ou)
+}
+
+func (a *LDAPAuditor) SetDepartmentManager(ctx context.Context, ou, uid string) (*models.Department, error) {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	dept, err := a.next.SetDepartmentManager(ctx, ou, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(dept))
+	}
+	a.record(ctx, ActionSetDepartmentManager, a.config.DepartmentDN(ou), changes, err)
+
+	return dept, err
+}
+
+func (a *LDAPAuditor) AddDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error) {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	dept, err := a.next.AddDepartmentDeputy(ctx, ou, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(dept))
+	}
+	a.record(ctx, ActionAddDepartmentDeputy, a.config.DepartmentDN(ou), changes, err)
+
+	return dept, err
+}
+
+func (a *LDAPAuditor) RemoveDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error) {
+	before, _ := a.next.GetDepartment(ctx, ou)
+	dept, err := a.next.RemoveDepartmentDeputy(ctx, ou, uid)
+
+	var changes []AttributeChange
+	if err == nil {
+		changes = Diff(DepartmentAttributes(before), DepartmentAttributes(dept))
+	}
+	a.record(ctx, ActionRemoveDepartmentDeputy, a.config.DepartmentDN(ou), changes, err)
+
+	return dept, err
+}
+
+func (a *LDAPAuditor) GetManagedDepartments(ctx context.Context, uid string) ([]*models.Department, error) {
+	return a.next.GetManagedDepartments(ctx, uid)
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// EFFECTIVE ACCESS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) GetEffectiveAccess(ctx context.Context, uid string) (*models.EffectiveAccess, error) {
+	return a.next.GetEffectiveAccess(ctx, uid)
+}
+
+// MigrateRepositoryGrants is recorded once against the base DN; the entries
+// it changes are counted in the result, not diffed
+func (a *LDAPAuditor) MigrateRepositoryGrants(ctx context.Context) (*models.GrantMigrationResult, error) {
+	result, err := a.next.MigrateRepositoryGrants(ctx)
+	a.record(ctx, ActionMigrateGrants, a.config.LDAPBaseDN, nil, err)
+	return result, err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// ACCESS REQUESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) CreateAccessRequest(ctx context.Context, input *models.AccessRequestInput) (*models.AccessRequest, error) {
+	request, err := a.next.CreateAccessRequest(ctx, input)
+
+	targetDN := a.config.UserDN(input.Requester)
+	var changes []AttributeChange
+	if err == nil {
+		targetDN = a.config.AccessRequestDN(request.ID)
+		changes = Diff(nil, AccessRequestAttributes(request))
+	}
+	a.record(ctx, ActionRequestAccess, targetDN, changes, err)
+
+	return request, err
+}
+
+func (a *LDAPAuditor) GetAccessRequest(ctx context.Context, id string) (*models.AccessRequest, error) {
+	return a.next.GetAccessRequest(ctx, id)
+}
+
+func (a *LDAPAuditor) ListAccessRequests(ctx context.Context, filter *models.AccessRequestFilter) ([]*models.AccessRequest, error) {
+	return a.next.ListAccessRequests(ctx, filter)
+}
+
+// ReviewAccessRequest records approvals against the requester, whose grants
+// change, and denials against the request
+func (a *LDAPAuditor) ReviewAccessRequest(ctx context.Context, id, reviewer string, approve bool) (*models.AccessRequest, error) {
+	request, err := a.next.ReviewAccessRequest(ctx, id, reviewer, approve)
+
+	action := ActionDenyAccess
+	targetDN := a.config.AccessRequestDN(id)
+	var changes []AttributeChange
+	if approve {
+		action = ActionApproveAccess
+		if err == nil {
+			targetDN = a.config.UserDN(request.Requester)
+			grant := models.RepositoryGrant{Repository: request.Repository, Permission: request.Permission, ExpiresAt: request.ExpiresAt}
+			changes = []AttributeChange{{Attribute: "repositoryGrant", After: []string{grant.String()}}}
+		}
+	} else if err == nil {
+		changes = []AttributeChange{{Attribute: "accessRequestStatus", Before: []string{models.AccessRequestPending}, After: []string{request.Status}}}
+	}
+	a.record(ctx, action, targetDN, changes, err)
+
+	return request, err
+}
+
+// RevokeExpiredGrants records the grants removed from each entry and every
+// access request it expired, plus a failure against the base DN, so idle
+// sweeps leave no trace
+func (a *LDAPAuditor) RevokeExpiredGrants(ctx context.Context, now time.Time) (*models.GrantSweepResult, error) {
+	result, err := a.next.RevokeExpiredGrants(ctx, now)
+	if result != nil {
+		for _, revoked := range result.Revoked {
+			grants := append([]string(nil), revoked.Grants...)
+			sort.Strings(grants)
+			a.record(ctx, ActionRevokeExpiredGrants, revoked.DN, []AttributeChange{{Attribute: "repositoryGrant", Before: grants}}, nil)
+		}
+		for _, id := range result.ExpiredRequestIDs {
+			changes := []AttributeChange{{Attribute: "accessRequestStatus", Before: []string{models.AccessRequestApproved}, After: []string{models.AccessRequestExpired}}}
+			a.record(ctx, ActionRevokeExpiredGrants, a.config.AccessRequestDN(id), changes, nil)
+		}
+	}
+	if err != nil {
+		a.record(ctx, ActionRevokeExpiredGrants, a.config.LDAPBaseDN, nil, err)
+	}
+	return result, err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// BACKUP & RESTORE
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) ExportDirectory(ctx context.Context) ([]*models.DirectoryEntry, error) {
+	return a.next.ExportDirectory(ctx)
+}
+
+// RestoreDirectory records every entry it wrote, with passwords redacted,
+// or a single failure against the base DN; dry runs are not recorded
+func (a *LDAPAuditor) RestoreDirectory(ctx context.Context, entries []*models.DirectoryEntry, dryRun bool) (*models.RestoreResult, error) {
+	result, err := a.next.RestoreDirectory(ctx, entries, dryRun)
+	if dryRun {
+		return result, err
+	}
+	if err != nil {
+		a.record(ctx, ActionRestoreDirectory, a.config.LDAPBaseDN, nil, err)
+		return result, err
+	}
+
+	for _, change := range result.Changes {
+		a.record(ctx, ActionRestoreDirectory, change.DN, restoreAttributeChanges(change), nil)
+	}
+	return result, nil
+}
+
+// restoreAttributeChanges lists the attributes a restore change touched
+func restoreAttributeChanges(change *models.RestoreChange) []AttributeChange {
+	names := make(map[string]bool)
+	for name := range change.Before {
+		names[name] = true
+	}
+	for name := range change.After {
+		names[name] = true
+	}
+
+	changes := make([]AttributeChange, 0, len(names))
+	for name := range names {
+		if strings.EqualFold(name, "userPassword") {
+			changes = append(changes, PasswordChange())
+			continue
+		}
+		changes = append(changes, AttributeChange{Attribute: name, Before: change.Before[name], After: change.After[name]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Attribute < changes[j].Attribute })
+	return changes
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// HEALTH & STATS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (a *LDAPAuditor) HealthCheck(ctx context.Context) error {
+	return a.next.HealthCheck(ctx)
+}
+
+func (a *LDAPAuditor) GetStats() *models.Stats {
+	return a.next.GetStats()
+}
+
+// userAttributes flattens a user like UserAttributes, adding the custom
+// attributes configured in USER_ATTRIBUTES_FILE under their LDAP names
+func (a *LDAPAuditor) userAttributes(u *models.User) map[string][]string {
+	attrs := UserAttributes(u)
+	if u == nil {
+		return attrs
+	}
+	for _, def := range a.config.UserAttributes {
+		attrs[def.LDAPAttribute] = u.Attributes[def.Field]
+	}
+	return attrs
+}
@@ -0,0 +1,386 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+)
+
+// memoryStore keeps appended records in memory, or fails every append
+// with err
+type memoryStore struct {
+	records []*Record
+	err     error
+}
+
+func (s *memoryStore) Append(ctx context.Context, record *Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memoryStore) Query(ctx context.Context, filter *Filter, limit, offset int) ([]*Record, int, error) {
+	return s.records, len(s.records), nil
+}
+
+// fakeDirectory holds users in memory for the operations under test;
+// the others panic through the nil embedded interface
+type fakeDirectory struct {
+	prometheus.LDAPInterface
+	users map[string]*models.User
+	sweep *models.GrantSweepResult
+	err   error
+}
+
+func (d *fakeDirectory) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	user, ok := d.users[uid]
+	if !ok {
+		return nil, errors.New("no such user")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (d *fakeDirectory) CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	user := &models.User{UID: input.UID, CN: input.CN, SN: input.SN, Mail: input.Mail, Department: input.Department, UIDNumber: 10001, GIDNumber: 10001, Status: models.UserStatusActive}
+	d.users[input.UID] = user
+	return d.GetUser(ctx, input.UID)
+}
+
+func (d *fakeDirectory) UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	user := d.users[input.UID]
+	if input.Mail != nil {
+		user.Mail = *input.Mail
+	}
+	for field, values := range input.Attributes {
+		if user.Attributes == nil {
+			user.Attributes = make(map[string][]string)
+		}
+		user.Attributes[field] = values
+	}
+	return d.GetUser(ctx, input.UID)
+}
+
+func (d *fakeDirectory) DeprovisionUser(ctx context.Context, uid string) (*models.DeprovisionResult, error) {
+	user := d.users[uid]
+	user.Status = models.UserStatusDisabled
+	user.Grants = nil
+	result := &models.DeprovisionResult{RemovedGroups: []string{"ops", "developers"}}
+	result.User, _ = d.GetUser(ctx, uid)
+	return result, nil
+}
+
+func (d *fakeDirectory) RestoreDirectory(ctx context.Context, entries []*models.DirectoryEntry, dryRun bool) (*models.RestoreResult, error) {
+	return &models.RestoreResult{DryRun: dryRun, Changes: []*models.RestoreChange{{
+		Operation: "modify",
+		DN:        "uid=bob,ou=users,dc=example,dc=com",
+		Before:    map[string][]string{"mail": {"bob@example.com"}, "userPassword": {"{SSHA}old"}},
+		After:     map[string][]string{"mail": {"robert@example.com"}, "userPassword": {"{SSHA}new"}},
+	}}}, nil
+}
+
+func (d *fakeDirectory) RevokeExpiredGrants(ctx context.Context, now time.Time) (*models.GrantSweepResult, error) {
+	return d.sweep, d.err
+}
+
+func newTestAuditor(t *testing.T) (*LDAPAuditor, *fakeDirectory, *memoryStore) {
+	t.Helper()
+	dir := &fakeDirectory{users: map[string]*models.User{
+		"bob": {
+			UID:        "bob",
+			CN:         "Bob",
+			Mail:       "bob@example.com",
+			Department: "eng",
+			Status:     models.UserStatusActive,
+			Grants:     []models.RepositoryGrant{{Repository: "api", Permission: models.PermissionWrite}},
+		},
+	}}
+	store := &memoryStore{}
+	cfg := &config.Config{
+		LDAPBaseDN: "dc=example,dc=com",
+		UserAttributes: []models.AttributeDefinition{
+			{Field: "costCenter", LDAPAttribute: "employeeType"},
+		},
+	}
+	return NewLDAPAuditor(dir, store, cfg, testLogger()), dir, store
+}
+
+// actorContext returns a context for requests made by uid
+func actorContext(uid string) context.Context {
+	ctx := context.WithValue(context.Background(), auth.ContextKeyUser, uid)
+	return WithRequestID(ctx, "req-1")
+}
+
+func TestLDAPAuditorUpdateUser(t *testing.T) {
+	a, _, store := newTestAuditor(t)
+
+	mail, password := "robert@example.com", "hunter2-secret"
+	_, err := a.UpdateUser(actorContext("alice"), &models.UpdateUserInput{
+		UID:        "bob",
+		Mail:       &mail,
+		Password:   &password,
+		Attributes: map[string][]string{"costCenter": {"42"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+
+	if len(store.records) != 1 {
+		t.Fatalf("recorded %d records, want 1", len(store.records))
+	}
+	record := store.records[0]
+	if record.Actor != "alice" || record.Action != ActionUpdateUser || record.RequestID != "req-1" || !record.Success ||
+		record.TargetDN != "uid=bob,ou=users,dc=example,dc=com" {
+		t.Errorf("record = %+v", record)
+	}
+
+	// Only the changed attributes, custom ones under their LDAP name, and
+	// the password without its value
+	want := []AttributeChange{
+		{Attribute: "employeeType", After: []string{"42"}},
+		{Attribute: "mail", Before: []string{"bob@example.com"}, After: []string{"robert@example.com"}},
+		PasswordChange(),
+	}
+	if !reflect.DeepEqual(record.Changes, want) {
+		t.Errorf("changes = %+v, want %+v", record.Changes, want)
+	}
+
+	encoded, _ := json.Marshal(record)
+	if strings.Contains(string(encoded), password) {
+		t.Errorf("record leaks the password: %s", encoded)
+	}
+}
+
+func TestLDAPAuditorCreateUser(t *testing.T) {
+	a, _, store := newTestAuditor(t)
+
+	password := "hunter2-secret"
+	_, err := a.CreateUser(context.Background(), &models.CreateUserInput{UID: "carol", CN: "Carol", SN: "C", Mail: "carol@example.com", Password: password})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	record := store.records[0]
+	if record.Actor != "system" {
+		t.Errorf("actor without an authenticated user = %q, want system", record.Actor)
+	}
+	attributes := make(map[string]AttributeChange)
+	for _, change := range record.Changes {
+		if len(change.Before) > 0 && change.Attribute != "userPassword" {
+			t.Errorf("creation has a before value: %+v", change)
+		}
+		attributes[change.Attribute] = change
+	}
+	if got := attributes["mail"].After; !reflect.DeepEqual(got, []string{"carol@example.com"}) {
+		t.Errorf("mail change = %v", got)
+	}
+	if got := attributes["userPassword"]; !reflect.DeepEqual(got, PasswordChange()) {
+		t.Errorf("userPassword change = %+v, want it redacted", got)
+	}
+	// Empty attributes are left out
+	if _, ok := attributes["givenName"]; ok {
+		t.Error("creation records the empty givenName")
+	}
+}
+
+func TestLDAPAuditorRecordsFailures(t *testing.T) {
+	a, dir, store := newTestAuditor(t)
+	dir.err = errors.New("insufficient access rights")
+
+	mail := "robert@example.com"
+	if _, err := a.UpdateUser(actorContext("alice"), &models.UpdateUserInput{UID: "bob", Mail: &mail}); err != dir.err {
+		t.Fatalf("UpdateUser() error = %v, want the directory's", err)
+	}
+
+	record := store.records[0]
+	if record.Success || record.Error != "insufficient access rights" || record.Changes != nil {
+		t.Errorf("record of a failed update = %+v", record)
+	}
+}
+
+func TestLDAPAuditorStoreFailure(t *testing.T) {
+	a, dir, store := newTestAuditor(t)
+	store.err = errors.New("disk full")
+
+	// The change is already applied, so failing to audit it is not an error
+	mail := "robert@example.com"
+	if _, err := a.UpdateUser(actorContext("alice"), &models.UpdateUserInput{UID: "bob", Mail: &mail}); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if dir.users["bob"].Mail != mail {
+		t.Error("UpdateUser() did not reach the directory")
+	}
+}
+
+func TestLDAPAuditorDeprovisionUser(t *testing.T) {
+	a, _, store := newTestAuditor(t)
+
+	if _, err := a.DeprovisionUser(actorContext("alice"), "bob"); err != nil {
+		t.Fatalf("DeprovisionUser() error = %v", err)
+	}
+
+	want := []AttributeChange{
+		{Attribute: "accountStatus", Before: []string{models.UserStatusActive}, After: []string{models.UserStatusDisabled}},
+		{Attribute: "repositoryGrant", Before: []string{"api write"}},
+		{Attribute: "memberOf", Before: []string{"developers", "ops"}},
+	}
+	if got := store.records[0].Changes; !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+}
+
+func TestLDAPAuditorRestoreDirectory(t *testing.T) {
+	a, _, store := newTestAuditor(t)
+
+	if _, err := a.RestoreDirectory(actorContext("alice"), nil, true); err != nil {
+		t.Fatalf("RestoreDirectory() dry run error = %v", err)
+	}
+	if len(store.records) != 0 {
+		t.Fatalf("dry run recorded %d records, want none", len(store.records))
+	}
+
+	if _, err := a.RestoreDirectory(actorContext("alice"), nil, false); err != nil {
+		t.Fatalf("RestoreDirectory() error = %v", err)
+	}
+	if len(store.records) != 1 {
+		t.Fatalf("recorded %d records, want one per change", len(store.records))
+	}
+	record := store.records[0]
+	want := []AttributeChange{
+		{Attribute: "mail", Before: []string{"bob@example.com"}, After: []string{"robert@example.com"}},
+		PasswordChange(),
+	}
+	if record.TargetDN != "uid=bob,ou=users,dc=example,dc=com" || !reflect.DeepEqual(record.Changes, want) {
+		t.Errorf("record = %+v, want changes %+v", record, want)
+	}
+}
+
+func TestLDAPAuditorRevokeExpiredGrants(t *testing.T) {
+	groupDN := "cn=developers,ou=groups,dc=example,dc=com"
+	bobDN := "uid=bob,ou=users,dc=example,dc=com"
+	revoked := []models.RevokedGrants{
+		{DN: groupDN, Grants: []string{"web read 2026-01-02T00:00:00Z", "api admin 2026-01-01T00:00:00Z"}},
+		{DN: bobDN, Grants: []string{"api write 2026-01-01T00:00:00Z"}},
+	}
+
+	tests := []struct {
+		name  string
+		sweep *models.GrantSweepResult
+		err   error
+		want  []*Record
+	}{
+		{
+			name:  "idle sweep",
+			sweep: &models.GrantSweepResult{},
+		},
+		{
+			name:  "one record per entry and request",
+			sweep: &models.GrantSweepResult{Groups: 1, Users: 1, ExpiredRequests: 1, Revoked: revoked, ExpiredRequestIDs: []string{"req42"}},
+			want: []*Record{
+				{TargetDN: groupDN, Success: true, Changes: []AttributeChange{{Attribute: "repositoryGrant", Before: []string{"api admin 2026-01-01T00:00:00Z", "web read 2026-01-02T00:00:00Z"}}}},
+				{TargetDN: bobDN, Success: true, Changes: []AttributeChange{{Attribute: "repositoryGrant", Before: []string{"api write 2026-01-01T00:00:00Z"}}}},
+				{TargetDN: "cn=req42,ou=accessRequests,dc=example,dc=com", Success: true, Changes: []AttributeChange{{Attribute: "accessRequestStatus", Before: []string{models.AccessRequestApproved}, After: []string{models.AccessRequestExpired}}}},
+			},
+		},
+		{
+			name:  "failure after revoking",
+			sweep: &models.GrantSweepResult{Groups: 1, Revoked: revoked[:1]},
+			err:   errors.New("server down"),
+			want: []*Record{
+				{TargetDN: groupDN, Success: true, Changes: []AttributeChange{{Attribute: "repositoryGrant", Before: []string{"api admin 2026-01-01T00:00:00Z", "web read 2026-01-02T00:00:00Z"}}}},
+				{TargetDN: "dc=example,dc=com", Error: "server down"},
+			},
+		},
+		{
+			name: "failure without a result",
+			err:  errors.New("no connection"),
+			want: []*Record{{TargetDN: "dc=example,dc=com", Error: "no connection"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, dir, store := newTestAuditor(t)
+			dir.sweep, dir.err = tt.sweep, tt.err
+
+			if _, err := a.RevokeExpiredGrants(context.Background(), time.Now()); err != tt.err {
+				t.Fatalf("RevokeExpiredGrants() error = %v, want %v", err, tt.err)
+			}
+
+			if len(store.records) != len(tt.want) {
+				t.Fatalf("recorded %d records, want %d", len(store.records), len(tt.want))
+			}
+			for i, record := range store.records {
+				want := tt.want[i]
+				if record.Action != ActionRevokeExpiredGrants || record.Actor != "system" || record.TargetDN != want.TargetDN ||
+					record.Success != want.Success || record.Error != want.Error || !reflect.DeepEqual(record.Changes, want.Changes) {
+					t.Errorf("record %d = %+v, want %+v", i, record, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string][]string
+		after  map[string][]string
+		want   []AttributeChange
+	}{
+		{
+			name:  "creation",
+			after: map[string][]string{"cn": {"Bob"}, "mail": {"bob@example.com"}},
+			want: []AttributeChange{
+				{Attribute: "cn", After: []string{"Bob"}},
+				{Attribute: "mail", After: []string{"bob@example.com"}},
+			},
+		},
+		{
+			name:   "deletion",
+			before: map[string][]string{"cn": {"Bob"}},
+			want:   []AttributeChange{{Attribute: "cn", Before: []string{"Bob"}}},
+		},
+		{
+			name:   "value order is not a change",
+			before: map[string][]string{"member": {"a", "b"}},
+			after:  map[string][]string{"member": {"b", "a"}},
+		},
+		{
+			name:   "empty and missing are the same",
+			before: map[string][]string{"description": {}},
+			after:  map[string][]string{},
+		},
+		{
+			name:   "changed values are sorted",
+			before: map[string][]string{"member": {"c", "a"}, "cn": {"Bob"}},
+			after:  map[string][]string{"member": {"b", "a"}, "cn": {"Bob"}},
+			want:   []AttributeChange{{Attribute: "member", Before: []string{"a", "c"}, After: []string{"a", "b"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileStore is an append-only audit store backed by JSON Lines files.
+//
+// Each process writes to its own audit-<node>.jsonl inside the directory, so
+// several replicas can share one volume without interleaving writes; queries
+// read every audit-*.jsonl file in the directory.
+type FileStore struct {
+	dir    string
+	node   string
+	file   *os.File
+	mu     sync.Mutex
+	seq    uint64
+	logger *logrus.Logger
+}
+
+// NewFileStore opens (or creates) the audit log for this node in dir
+func NewFileStore(dir, node string, logger *logrus.Logger) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	node = strings.NewReplacer("/", "_", "-", "_").Replace(node)
+
+	path := filepath.Join(dir, fmt.Sprintf("audit-%s.jsonl", node))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	logger.WithField("path", path).Info("Audit log opened")
+
+	return &FileStore{
+		dir:    dir,
+		node:   node,
+		file:   file,
+		logger: logger,
+	}, nil
+}
+
+// Append writes the record as one JSON line and syncs it to disk
+func (s *FileStore) Append(ctx context.Context, record *Record) error {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+	if record.ID == "" {
+		record.ID = newRecordID(record.Timestamp, s.node, atomic.AddUint64(&s.seq, 1))
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+// Query scans all audit files in the directory and returns matching records
+// newest first
+func (s *FileStore) Query(ctx context.Context, filter *Filter, limit, offset int) ([]*Record, int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "audit-*.jsonl"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+
+	var matches []*Record
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		records, err := s.readFile(path, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		matches = append(matches, records...)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Timestamp.After(matches[j].Timestamp)
+	})
+
+	total := len(matches)
+	start := offset
+	end := offset + limit
+	if start > total {
+		start = total
+	}
+	if end > total || limit <= 0 {
+		end = total
+	}
+
+	return matches[start:end], total, nil
+}
+
+// readFile returns the records in one audit file that match the filter
+func (s *FileStore) readFile(path string, filter *Filter) ([]*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final line after a crash must not hide the rest of the log
+			s.logger.WithError(err).WithField("path", path).Warn("Skipping malformed audit record")
+			continue
+		}
+		if filter.Matches(&record) {
+			records = append(records, &record)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// Close closes the underlying file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func equalFold(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestStore(t *testing.T, dir, node string) *FileStore {
+	t.Helper()
+	store, err := NewFileStore(dir, node, testLogger())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFileStoreAppend(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir, "ldap-manager-0")
+
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	first := &Record{Timestamp: at, Actor: "alice", Action: ActionCreateUser, TargetDN: "uid=bob,ou=users,dc=example,dc=com", Success: true}
+	second := &Record{Actor: "alice", Action: ActionDeleteUser, TargetDN: "uid=bob,ou=users,dc=example,dc=com", Success: true}
+	for _, record := range []*Record{first, second} {
+		if err := store.Append(context.Background(), record); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	if first.ID == "" || second.ID == "" || first.ID == second.ID {
+		t.Errorf("Append() assigned IDs %q and %q, want distinct ones", first.ID, second.ID)
+	}
+	if second.Timestamp.IsZero() {
+		t.Error("Append() left the timestamp unset")
+	}
+
+	// One JSON object per line, in a file named after the node with the
+	// dashes replaced
+	file, err := os.Open(filepath.Join(dir, "audit-ldap_manager_0.jsonl"))
+	if err != nil {
+		t.Fatalf("opening audit log: %v", err)
+	}
+	defer file.Close()
+
+	var lines []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q is not a record: %v", scanner.Text(), err)
+		}
+		lines = append(lines, record)
+	}
+	if len(lines) != 2 || lines[0].ID != first.ID || lines[1].ID != second.ID {
+		t.Errorf("audit log = %+v, want the two records in order", lines)
+	}
+
+	// Reopening appends instead of truncating
+	store.Close()
+	reopened := newTestStore(t, dir, "ldap-manager-0")
+	if err := reopened.Append(context.Background(), &Record{Actor: "bob", Action: ActionChangePassword}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	_, total, err := reopened.Query(context.Background(), nil, 0, 0)
+	if err != nil || total != 3 {
+		t.Errorf("Query() after reopening = %d records, %v, want 3", total, err)
+	}
+}
+
+func TestFileStoreQuery(t *testing.T) {
+	dir := t.TempDir()
+	replica0 := newTestStore(t, dir, "replica-0")
+	replica1 := newTestStore(t, dir, "replica-1")
+
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	records := []struct {
+		store  *FileStore
+		record *Record
+	}{
+		{replica0, &Record{Timestamp: base, Actor: "alice", Action: ActionCreateUser, TargetDN: "uid=bob,ou=users,dc=example,dc=com", RequestID: "req-1",
+			Changes: []AttributeChange{{Attribute: "mail", After: []string{"bob@example.com"}}}}},
+		{replica1, &Record{Timestamp: base.Add(time.Minute), Actor: "alice", Action: ActionAddGroupMember, TargetDN: "cn=developers,ou=groups,dc=example,dc=com", RequestID: "req-2",
+			Changes: []AttributeChange{{Attribute: "member", After: []string{"uid=bob,ou=users,dc=example,dc=com"}}}}},
+		{replica0, &Record{Timestamp: base.Add(2 * time.Minute), Actor: "carol", Action: ActionUpdateUser, TargetDN: "uid=bob,ou=users,dc=example,dc=com", RequestID: "req-3",
+			Changes: []AttributeChange{{Attribute: "mail", Before: []string{"bob@example.com"}, After: []string{"robert@example.com"}}}}},
+		{replica1, &Record{Timestamp: base.Add(3 * time.Minute), Actor: "carol", Action: ActionDeleteGroup, TargetDN: "cn=developers,ou=groups,dc=example,dc=com", RequestID: "req-4"}},
+	}
+	for _, r := range records {
+		if err := r.store.Append(context.Background(), r.record); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// A torn line left by a crash does not hide the rest of the log
+	torn, err := os.OpenFile(filepath.Join(dir, "audit-replica_1.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn.WriteString(`{"id":"torn","actor":`)
+	torn.Close()
+
+	tests := []struct {
+		name      string
+		filter    *Filter
+		limit     int
+		offset    int
+		wantIDs   []string // request IDs, newest first
+		wantTotal int
+	}{
+		{name: "everything from both replicas", wantIDs: []string{"req-4", "req-3", "req-2", "req-1"}, wantTotal: 4},
+		{name: "actor", filter: &Filter{Actor: "alice"}, wantIDs: []string{"req-2", "req-1"}, wantTotal: 2},
+		{name: "action", filter: &Filter{Action: ActionUpdateUser}, wantIDs: []string{"req-3"}, wantTotal: 1},
+		{name: "target substring ignores case", filter: &Filter{Target: "CN=Developers"}, wantIDs: []string{"req-4", "req-2"}, wantTotal: 2},
+		{name: "attribute", filter: &Filter{Attribute: "MAIL"}, wantIDs: []string{"req-3", "req-1"}, wantTotal: 2},
+		{name: "value before or after", filter: &Filter{Value: "bob@"}, wantIDs: []string{"req-3", "req-1"}, wantTotal: 2},
+		{name: "value of another attribute", filter: &Filter{Attribute: "member", Value: "robert"}, wantTotal: 0},
+		{name: "request ID", filter: &Filter{RequestID: "req-2"}, wantIDs: []string{"req-2"}, wantTotal: 1},
+		{name: "time range", filter: &Filter{Since: base.Add(time.Minute), Until: base.Add(2 * time.Minute)}, wantIDs: []string{"req-3", "req-2"}, wantTotal: 2},
+		{name: "first page", limit: 3, wantIDs: []string{"req-4", "req-3", "req-2"}, wantTotal: 4},
+		{name: "second page", limit: 3, offset: 3, wantIDs: []string{"req-1"}, wantTotal: 4},
+		{name: "past the end", limit: 3, offset: 10, wantTotal: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := replica0.Query(context.Background(), tt.filter, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("Query() total = %d, want %d", total, tt.wantTotal)
+			}
+			var ids []string
+			for _, record := range got {
+				ids = append(ids, record.RequestID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("Query() = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("Query() = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreQueryCanceled(t *testing.T) {
+	store := newTestStore(t, t.TempDir(), "replica-0")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := store.Query(ctx, nil, 0, 0); err == nil {
+		t.Error("Query() with a canceled context succeeded")
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minRefreshInterval limits how often an unknown kid can force a JWKS fetch,
+// so a flood of tokens with bogus key IDs cannot hammer Keycloak
+const minRefreshInterval = 30 * time.Second
+
+// JWK represents a single JSON Web Key
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS represents a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKSProvider fetches and caches signing keys from a JWKS endpoint.
+// Keys are refreshed when the cache expires or when a token references a
+// kid we have not seen yet, which is how Keycloak key rotation shows up.
+type JWKSProvider struct {
+	jwksURL    string
+	keys       map[string]*rsa.PublicKey
+	mu         sync.RWMutex
+	lastFetch  time.Time
+	cacheTTL   time.Duration
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewJWKSProvider creates a JWKS provider for the given endpoint
+func NewJWKSProvider(jwksURL string, cacheTTL time.Duration, logger *logrus.Logger) *JWKSProvider {
+	if cacheTTL <= 0 {
+		cacheTTL = 10 * time.Minute
+	}
+
+	logger.WithField("jwks_url", jwksURL).Info("Initializing JWKS provider")
+
+	return &JWKSProvider{
+		jwksURL:  jwksURL,
+		keys:     make(map[string]*rsa.PublicKey),
+		cacheTTL: cacheTTL,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		logger: logger,
+	}
+}
+
+// GetKey returns the RSA public key for the given key ID
+func (p *JWKSProvider) GetKey(kid string) (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	fresh := time.Since(p.lastFetch) < p.cacheTTL
+	recentlyFetched := time.Since(p.lastFetch) < minRefreshInterval
+	p.mu.RUnlock()
+
+	if ok && fresh {
+		return key, nil
+	}
+
+	// Unknown kid right after a fetch: the key really isn't published
+	if !ok && recentlyFetched {
+		return nil, fmt.Errorf("key not found for kid: %s", kid)
+	}
+
+	if err := p.refresh(); err != nil {
+		// Keep serving a cached key if Keycloak is briefly unreachable
+		if ok {
+			p.logger.WithError(err).Warn("JWKS refresh failed, using cached key")
+			return key, nil
+		}
+		return nil, fmt.Errorf("failed to refresh JWKS: %w", err)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("key not found for kid: %s", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the JWKS and replaces the cached key set
+func (p *JWKSProvider) refresh() error {
+	p.logger.Debug("Fetching JWKS")
+
+	resp, err := p.httpClient.Get(p.jwksURL)
+	if err != nil {
+		return fmt.Errorf("JWKS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var jwks JWKS
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		pubKey, err := parseRSAPublicKey(jwk)
+		if err != nil {
+			p.logger.WithError(err).WithField("kid", jwk.Kid).Warn("Failed to parse JWK")
+			continue
+		}
+		keys[jwk.Kid] = pubKey
+	}
+
+	p.mu.Lock()
+	p.lastFetch = time.Now()
+	if len(keys) > 0 {
+		p.keys = keys
+	}
+	p.mu.Unlock()
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no valid RSA signing keys found in JWKS")
+	}
+
+	p.logger.WithField("key_count", len(keys)).Info("JWKS refreshed")
+	return nil
+}
+
+// parseRSAPublicKey converts a JWK to an *rsa.PublicKey
+func parseRSAPublicKey(jwk JWK) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode modulus: %w", err)
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exponent: %w", err)
+	}
+
+	// Convert exponent bytes to int
+	var e int
+	for _, b := range eBytes {
+		e = e<<8 + int(b)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: e,
+	}, nil
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testKeySize keeps key generation fast; the provider does not check it
+const testKeySize = 1024
+
+// fakeJWKS serves a replaceable key set and counts the fetches
+type fakeJWKS struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+	status  int
+}
+
+func newFakeJWKS(t *testing.T, kids ...string) *fakeJWKS {
+	t.Helper()
+	f := &fakeJWKS{status: http.StatusOK}
+	f.rotate(t, kids...)
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.fetches++
+		if f.status != http.StatusOK {
+			http.Error(w, "unavailable", f.status)
+			return
+		}
+		var jwks JWKS
+		for kid, key := range f.keys {
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// rotate replaces the published keys with new ones for kids
+func (f *fakeJWKS) rotate(t *testing.T, kids ...string) {
+	t.Helper()
+	keys := make(map[string]*rsa.PrivateKey)
+	for _, kid := range kids {
+		key, err := rsa.GenerateKey(rand.Reader, testKeySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = key
+	}
+	f.mu.Lock()
+	f.keys = keys
+	f.mu.Unlock()
+}
+
+func (f *fakeJWKS) key(kid string) *rsa.PrivateKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keys[kid]
+}
+
+func (f *fakeJWKS) setStatus(status int) {
+	f.mu.Lock()
+	f.status = status
+	f.mu.Unlock()
+}
+
+func (f *fakeJWKS) fetchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// expireFetch makes the provider's last fetch look older than age
+func expireFetch(p *JWKSProvider, age time.Duration) {
+	p.mu.Lock()
+	p.lastFetch = time.Now().Add(-age)
+	p.mu.Unlock()
+}
+
+func TestJWKSProviderGetKey(t *testing.T) {
+	server := newFakeJWKS(t, "key-1", "key-2")
+	p := NewJWKSProvider(server.URL, time.Hour, testLogger())
+
+	key, err := p.GetKey("key-1")
+	if err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+	if key.N.Cmp(server.key("key-1").N) != 0 || key.E != server.key("key-1").E {
+		t.Error("GetKey() returned a different key than published")
+	}
+
+	// Both keys came with the first fetch
+	if _, err := p.GetKey("key-2"); err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+
+	// An unknown kid right after a fetch does not hit the endpoint again
+	if _, err := p.GetKey("bogus"); err == nil {
+		t.Error("GetKey() of an unknown kid succeeded")
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times after an unknown kid, want 1", got)
+	}
+}
+
+func TestJWKSProviderRotation(t *testing.T) {
+	server := newFakeJWKS(t, "key-1")
+	p := NewJWKSProvider(server.URL, time.Hour, testLogger())
+
+	if _, err := p.GetKey("key-1"); err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+
+	// Keycloak rotates to a new key; tokens signed with it carry the new kid
+	server.rotate(t, "key-2")
+	expireFetch(p, minRefreshInterval)
+
+	key, err := p.GetKey("key-2")
+	if err != nil {
+		t.Fatalf("GetKey() after rotation error = %v", err)
+	}
+	if key.N.Cmp(server.key("key-2").N) != 0 {
+		t.Error("GetKey() after rotation returned a different key than published")
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+
+	// The retired key is gone with the refresh
+	if _, err := p.GetKey("key-1"); err == nil {
+		t.Error("GetKey() of the retired key succeeded")
+	}
+}
+
+func TestJWKSProviderCacheExpiry(t *testing.T) {
+	server := newFakeJWKS(t, "key-1")
+	p := NewJWKSProvider(server.URL, time.Minute, testLogger())
+
+	cached, err := p.GetKey("key-1")
+	if err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+
+	// An expired cache is refreshed, and kept while Keycloak is unreachable
+	server.setStatus(http.StatusServiceUnavailable)
+	expireFetch(p, time.Minute)
+	key, err := p.GetKey("key-1")
+	if err != nil {
+		t.Fatalf("GetKey() with the endpoint down error = %v", err)
+	}
+	if key != cached {
+		t.Error("GetKey() with the endpoint down did not return the cached key")
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+
+	if _, err := p.GetKey("key-2"); err == nil {
+		t.Error("GetKey() of an unknown kid succeeded with the endpoint down")
+	}
+}
+
+func TestParseRSAPublicKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		jwk     JWK
+		wantE   int
+		wantErr bool
+	}{
+		{name: "standard exponent", jwk: JWK{N: "AQAB", E: "AQAB"}, wantE: 65537},
+		{name: "small exponent", jwk: JWK{N: "AQAB", E: "Aw"}, wantE: 3},
+		{name: "invalid modulus", jwk: JWK{N: "not base64!", E: "AQAB"}, wantErr: true},
+		{name: "invalid exponent", jwk: JWK{N: "AQAB", E: "A=="}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseRSAPublicKey(tt.jwk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRSAPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && key.E != tt.wantE {
+				t.Errorf("parseRSAPublicKey() exponent = %d, want %d", key.E, tt.wantE)
+			}
+		})
+	}
+}
@@ -2,11 +2,13 @@ package auth
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,24 +26,85 @@ const (
 	ContextKeyRoles contextKey = "user_roles"
 )
 
-// Middleware handles JWT token extraction from Keycloak/Istio headers
+// Middleware validates Keycloak JWTs and extracts the caller identity
 type Middleware struct {
-	logger *logrus.Logger
+	jwks           *JWKSProvider
+	issuer         string
+	audience       string
+	clientIDs      map[string]bool
+	trustedProxies []*net.IPNet
+	logger         *logrus.Logger
 }
 
-// NewMiddleware creates a new auth middleware
-func NewMiddleware(logger *logrus.Logger) *Middleware {
-	return &Middleware{
-		logger: logger,
+// NewMiddleware creates a new auth middleware with JWKS validation
+func NewMiddleware(cfg *config.Config, jwks *JWKSProvider, logger *logrus.Logger) (*Middleware, error) {
+	m := &Middleware{
+		jwks:     jwks,
+		issuer:   cfg.JWTIssuerURL(),
+		audience: cfg.JWTAudience,
+		logger:   logger,
 	}
+
+	for _, clientID := range cfg.KeycloakClientIDs {
+		if clientID = strings.TrimSpace(clientID); clientID != "" {
+			if m.clientIDs == nil {
+				m.clientIDs = make(map[string]bool)
+			}
+			m.clientIDs[clientID] = true
+		}
+	}
+
+	if cfg.AuthTrustForwardedHeaders {
+		for _, cidr := range cfg.AuthTrustedProxyCIDRs {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %w", cidr, err)
+			}
+			m.trustedProxies = append(m.trustedProxies, network)
+		}
+
+		if len(m.trustedProxies) == 0 {
+			return nil, fmt.Errorf("AUTH_TRUST_FORWARDED_HEADERS requires AUTH_TRUSTED_PROXY_CIDRS")
+		}
+
+		logger.WithField("cidrs", cfg.AuthTrustedProxyCIDRs).Warn("Trusting forwarded identity headers from proxy CIDRs")
+	}
+
+	return m, nil
 }
 
-// ExtractToken middleware extracts JWT token and user claims from Keycloak
-// Works in two modes:
-// 1. With Istio: Uses Istio-injected headers (X-Forwarded-User, X-Forwarded-Email)
-// 2. Without Istio (Postman): Decodes JWT claims directly
+// ExtractToken middleware validates the bearer token and stores the caller
+// identity in the request context.
+//
+// Requests without an Authorization header continue unauthenticated; it is up
+// to the GraphQL layer to reject them for fields that need an identity.
+// A present but invalid token is always rejected with 401.
+//
+// Istio-injected identity headers (X-Forwarded-User, X-Forwarded-Email,
+// X-Auth-Request-Roles) are only honoured when AUTH_TRUST_FORWARDED_HEADERS
+// is enabled and the request comes from one of AUTH_TRUSTED_PROXY_CIDRS.
+// Otherwise anyone able to reach the pod could impersonate any user.
 func (m *Middleware) ExtractToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, email, roles, ok := m.forwardedIdentity(r); ok {
+			m.logger.WithFields(logrus.Fields{
+				"user":  userID,
+				"email": email,
+				"roles": roles,
+			}).Debug("Extracted user from trusted proxy headers")
+
+			ctx := context.WithValue(r.Context(), ContextKeyToken, bearerToken(r))
+			ctx = context.WithValue(ctx, ContextKeyUser, userID)
+			ctx = context.WithValue(ctx, ContextKeyEmail, email)
+			ctx = context.WithValue(ctx, ContextKeyRoles, roles)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		// Extract JWT token from Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -61,69 +124,49 @@ func (m *Middleware) ExtractToken(next http.Handler) http.Handler {
 
 		token := parts[1]
 
-		// Try to extract user from Istio-injected headers first
-		userID := r.Header.Get("X-Forwarded-User")
-		if userID == "" {
-			userID = r.Header.Get("X-Auth-Request-User")
-		}
-
-		email := r.Header.Get("X-Forwarded-Email")
-		if email == "" {
-			email = r.Header.Get("X-Auth-Request-Email")
+		claims, err := m.validateToken(token)
+		if err != nil {
+			m.logger.WithError(err).Warn("JWT validation failed")
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
 		}
 
-		// Extract roles from header (comma-separated)
-		rolesHeader := r.Header.Get("X-Auth-Request-Roles")
+		var userID, email string
 		var roles []string
-		if rolesHeader != "" {
-			roles = strings.Split(rolesHeader, ",")
-		}
 
-		// If Istio headers not present, decode JWT claims
-		// This happens when testing with Postman (no Istio in the path)
-		if userID == "" {
-			claims, err := m.decodeJWT(token)
-			if err != nil {
-				m.logger.WithError(err).Debug("Failed to decode JWT - allowing request")
-				// Allow request to continue even if JWT decode fails
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			// Extract preferred_username claim (Keycloak standard)
-			if username, ok := claims["preferred_username"].(string); ok {
-				userID = username
-			}
+		// Extract preferred_username claim (Keycloak standard)
+		if username, ok := claims["preferred_username"].(string); ok {
+			userID = username
+		}
 
-			// Extract email claim
-			if emailClaim, ok := claims["email"].(string); ok {
-				email = emailClaim
-			}
+		// Extract email claim
+		if emailClaim, ok := claims["email"].(string); ok {
+			email = emailClaim
+		}
 
-			// Extract realm roles
-			if realmAccess, ok := claims["realm_access"].(map[string]interface{}); ok {
-				if rolesArray, ok := realmAccess["roles"].([]interface{}); ok {
-					for _, role := range rolesArray {
-						if roleStr, ok := role.(string); ok {
-							roles = append(roles, roleStr)
-						}
+		// Extract realm roles
+		if realmAccess, ok := claims["realm_access"].(map[string]interface{}); ok {
+			if rolesArray, ok := realmAccess["roles"].([]interface{}); ok {
+				for _, role := range rolesArray {
+					if roleStr, ok := role.(string); ok {
+						roles = append(roles, roleStr)
 					}
 				}
 			}
+		}
 
-			m.logger.WithFields(logrus.Fields{
-				"user":  userID,
-				"email": email,
-				"roles": roles,
-			}).Debug("Extracted user from JWT claims (Postman/direct mode)")
-		} else {
-			m.logger.WithFields(logrus.Fields{
-				"user":  userID,
-				"email": email,
-				"roles": roles,
-			}).Debug("Extracted user from Istio headers")
+		if userID == "" {
+			m.logger.Warn("No user identity found in token")
+			http.Error(w, "Unable to identify user from token", http.StatusUnauthorized)
+			return
 		}
 
+		m.logger.WithFields(logrus.Fields{
+			"user":  userID,
+			"email": email,
+			"roles": roles,
+		}).Debug("JWT validated via JWKS")
+
 		// Store in context for downstream handlers
 		ctx := context.WithValue(r.Context(), ContextKeyToken, token)
 		ctx = context.WithValue(ctx, ContextKeyUser, userID)
@@ -135,6 +178,139 @@ func (m *Middleware) ExtractToken(next http.Handler) http.Handler {
 	})
 }
 
+// forwardedIdentity returns the identity injected by a trusted proxy, if any
+func (m *Middleware) forwardedIdentity(r *http.Request) (string, string, []string, bool) {
+	if len(m.trustedProxies) == 0 || !m.fromTrustedProxy(r) {
+		return "", "", nil, false
+	}
+
+	userID := r.Header.Get("X-Forwarded-User")
+	if userID == "" {
+		userID = r.Header.Get("X-Auth-Request-User")
+	}
+	if userID == "" {
+		return "", "", nil, false
+	}
+
+	email := r.Header.Get("X-Forwarded-Email")
+	if email == "" {
+		email = r.Header.Get("X-Auth-Request-Email")
+	}
+
+	// Extract roles from header (comma-separated)
+	var roles []string
+	if rolesHeader := r.Header.Get("X-Auth-Request-Roles"); rolesHeader != "" {
+		for _, role := range strings.Split(rolesHeader, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return userID, email, roles, true
+}
+
+// fromTrustedProxy reports whether the direct peer is a configured proxy
+func (m *Middleware) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range m.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateToken verifies the RS256 signature against the JWKS and checks
+// expiry, plus issuer, audience and authorized party when configured
+func (m *Middleware) validateToken(tokenString string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if m.issuer != "" {
+		options = append(options, jwt.WithIssuer(m.issuer))
+	}
+	if m.audience != "" {
+		options = append(options, jwt.WithAudience(m.audience))
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Get key ID from token header
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid in token header")
+		}
+
+		// Look up the public key from JWKS
+		return m.jwks.GetKey(kid)
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("token validation failed: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("failed to extract claims")
+	}
+
+	if err := m.checkAuthorizedParty(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkAuthorizedParty rejects tokens issued to a client other than the
+// configured ones. Keycloak names the client in "azp"; a token without it
+// must list one of the clients in "aud" instead.
+func (m *Middleware) checkAuthorizedParty(claims jwt.MapClaims) error {
+	if len(m.clientIDs) == 0 {
+		return nil
+	}
+
+	if azp, ok := claims["azp"].(string); ok && azp != "" {
+		if !m.clientIDs[azp] {
+			return fmt.Errorf("token issued to unexpected client %q", azp)
+		}
+		return nil
+	}
+
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return fmt.Errorf("invalid aud claim: %w", err)
+	}
+	for _, aud := range audience {
+		if m.clientIDs[aud] {
+			return nil
+		}
+	}
+	return fmt.Errorf("token has no authorized party")
+}
+
+// bearerToken returns the raw bearer token from the request, if any
+func bearerToken(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+		return parts[1]
+	}
+	return ""
+}
+
 // GetTokenFromContext extracts the JWT token from the request context
 func GetTokenFromContext(ctx context.Context) string {
 	if token, ok := ctx.Value(ContextKeyToken).(string); ok {
@@ -167,26 +343,7 @@ func GetRolesFromContext(ctx context.Context) []string {
 	return []string{}
 }
 
-// decodeJWT decodes a JWT token without validation (for Postman testing)
-// In production with Istio, validation is done at the gateway level
-func (m *Middleware) decodeJWT(token string) (map[string]interface{}, error) {
-	// Split JWT into parts (header.payload.signature)
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return nil, http.ErrAbortHandler
-	}
-
-	// Decode the payload (second part)
-	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return nil, err
-	}
-
-	// Parse JSON claims
-	var claims map[string]interface{}
-	if err := json.Unmarshal(payload, &claims); err != nil {
-		return nil, err
-	}
-
-	return claims, nil
+// IsAuthenticated reports whether the request context carries an identity
+func IsAuthenticated(ctx context.Context) bool {
+	return GetUserFromContext(ctx) != ""
 }
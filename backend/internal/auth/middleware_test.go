@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "http://keycloak.test/realms/devplatform"
+	testClientID = "frontend-admin"
+)
+
+func newTestMiddleware(t *testing.T, server *fakeJWKS) *Middleware {
+	t.Helper()
+	cfg := &config.Config{
+		JWTIssuer:         testIssuer,
+		KeycloakClientIDs: []string{testClientID},
+	}
+	m, err := NewMiddleware(cfg, NewJWKSProvider(server.URL, time.Hour, testLogger()), testLogger())
+	if err != nil {
+		t.Fatalf("NewMiddleware() error = %v", err)
+	}
+	return m
+}
+
+// testClaims returns the claims of a valid Keycloak access token
+func testClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                testIssuer,
+		"aud":                "account",
+		"azp":                testClientID,
+		"sub":                "f3b6c1e2",
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.com",
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"user", "admin"}},
+	}
+}
+
+// signToken signs claims with method and key, setting kid unless empty
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed
+}
+
+func TestValidateToken(t *testing.T) {
+	server := newFakeJWKS(t, "key-1")
+	m := newTestMiddleware(t, server)
+	key := server.key("key-1")
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := testClaims()
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+				continue
+			}
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "valid token",
+			token: signToken(t, jwt.SigningMethodRS256, key, "key-1", testClaims()),
+		},
+		{
+			name:  "audience lists the client when azp is missing",
+			token: signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"azp": nil, "aud": []string{"account", testClientID}})),
+		},
+		{
+			name:    "expired",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"exp": nil})),
+			wantErr: true,
+		},
+		{
+			name:    "issued in the future",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"iat": time.Now().Add(time.Hour).Unix()})),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"iss": "http://evil.test/realms/devplatform"})),
+			wantErr: true,
+		},
+		{
+			name:    "issued to another client",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"azp": "gitea-service"})),
+			wantErr: true,
+		},
+		{
+			name:    "no authorized party",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-1", with(jwt.MapClaims{"azp": nil})),
+			wantErr: true,
+		},
+		{
+			name:    "unknown kid",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "key-2", testClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "missing kid",
+			token:   signToken(t, jwt.SigningMethodRS256, key, "", testClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "signed with another key",
+			token:   signToken(t, jwt.SigningMethodRS256, otherKey, "key-1", testClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "RS512 is not accepted",
+			token:   signToken(t, jwt.SigningMethodRS512, key, "key-1", testClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "HS256 keyed with the public key",
+			token:   signToken(t, jwt.SigningMethodHS256, publicKeyBytes, "key-1", testClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "unsigned",
+			token:   signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "key-1", testClaims()),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "not.a.token",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := m.validateToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && claims["preferred_username"] != "jdoe" {
+				t.Errorf("validateToken() claims = %v", claims)
+			}
+		})
+	}
+}
+
+func TestValidateTokenWithoutClientCheck(t *testing.T) {
+	server := newFakeJWKS(t, "key-1")
+	cfg := &config.Config{JWTIssuer: testIssuer}
+	m, err := NewMiddleware(cfg, NewJWKSProvider(server.URL, time.Hour, testLogger()), testLogger())
+	if err != nil {
+		t.Fatalf("NewMiddleware() error = %v", err)
+	}
+
+	claims := testClaims()
+	claims["azp"] = "gitea-service"
+	if _, err := m.validateToken(signToken(t, jwt.SigningMethodRS256, server.key("key-1"), "key-1", claims)); err != nil {
+		t.Errorf("validateToken() without KEYCLOAK_CLIENT_IDS error = %v", err)
+	}
+}
+
+func TestExtractToken(t *testing.T) {
+	server := newFakeJWKS(t, "key-1")
+	m := newTestMiddleware(t, server)
+	valid := signToken(t, jwt.SigningMethodRS256, server.key("key-1"), "key-1", testClaims())
+
+	noUsername := testClaims()
+	delete(noUsername, "preferred_username")
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantUser      string
+		wantRoles     []string
+	}{
+		{name: "anonymous", wantStatus: http.StatusOK},
+		{name: "valid token", authorization: "Bearer " + valid, wantStatus: http.StatusOK, wantUser: "jdoe", wantRoles: []string{"user", "admin"}},
+		{name: "lowercase scheme", authorization: "bearer " + valid, wantStatus: http.StatusOK, wantUser: "jdoe", wantRoles: []string{"user", "admin"}},
+		{name: "not a bearer token", authorization: "Basic amRvZTpzZWNyZXQ=", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", authorization: "Bearer not.a.token", wantStatus: http.StatusUnauthorized},
+		{
+			name:          "token without a username",
+			authorization: "Bearer " + signToken(t, jwt.SigningMethodRS256, server.key("key-1"), "key-1", noUsername),
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser string
+			var gotRoles []string
+			handler := m.ExtractToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = r.Context().Value(ContextKeyUser).(string)
+				gotRoles, _ = r.Context().Value(ContextKeyRoles).([]string)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotUser != tt.wantUser || !reflect.DeepEqual(gotRoles, tt.wantRoles) {
+				t.Errorf("identity = %q %v, want %q %v", gotUser, gotRoles, tt.wantUser, tt.wantRoles)
+			}
+		})
+	}
+}
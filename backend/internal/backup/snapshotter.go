@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devplatform/ldap-manager/internal/bulk"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+)
+
+// Snapshotter backs the directory up to LDIF snapshots in a Store, on a
+// schedule and on demand, and restores them. It works through the LDAP
+// interface, so exports are metered and restores audited like any other
+// operation.
+type Snapshotter struct {
+	ldapMgr  prometheus.LDAPInterface
+	store    *Store
+	interval time.Duration
+	retain   int
+	logger   *logrus.Logger
+
+	// mu keeps a restore from racing a backup of the same process
+	mu sync.Mutex
+}
+
+// NewSnapshotter creates a snapshotter keeping the newest retain snapshots
+// in store, backing up every interval once Run
+func NewSnapshotter(ldapMgr prometheus.LDAPInterface, store *Store, interval time.Duration, retain int, logger *logrus.Logger) *Snapshotter {
+	return &Snapshotter{
+		ldapMgr:  ldapMgr,
+		store:    store,
+		interval: interval,
+		retain:   retain,
+		logger:   logger,
+	}
+}
+
+// Run backs up on every tick until ctx is done. A tick is skipped when the
+// newest snapshot is less than half an interval old, which is the case
+// when another replica sharing the store just took one.
+func (s *Snapshotter) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.logger.WithFields(logrus.Fields{
+		"interval": s.interval,
+		"retain":   s.retain,
+	}).Info("Starting scheduled directory backups")
+
+	for {
+		s.scheduledBackup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Snapshotter) scheduledBackup(ctx context.Context) {
+	latest, err := s.store.Latest()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list backups")
+		return
+	}
+	if latest != nil && time.Since(latest.CreatedAt) < s.interval/2 {
+		return
+	}
+
+	if _, err := s.Backup(ctx); err != nil {
+		s.logger.WithError(err).Error("Scheduled directory backup failed")
+	}
+}
+
+// Backup exports the directory into a new snapshot and drops the oldest
+// ones beyond the retention
+func (s *Snapshotter) Backup(ctx context.Context) (*models.BackupSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backup(ctx, true)
+}
+
+// backup takes a snapshot, and with prune applies the retention
+func (s *Snapshotter) backup(ctx context.Context, prune bool) (*models.BackupSnapshot, error) {
+	entries, err := s.ldapMgr.ExportDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := bulk.WriteLDIFEntries(&buf, entries); err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	snapshot, err := s.store.Save(buf.Bytes(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Entries = len(entries)
+
+	s.logger.WithFields(logrus.Fields{
+		"snapshot": snapshot.Name,
+		"entries":  snapshot.Entries,
+		"size":     snapshot.Size,
+	}).Info("Directory backed up")
+	if !prune {
+		return snapshot, nil
+	}
+
+	deleted, err := s.store.Prune(s.retain)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to delete old backups")
+	}
+	for _, name := range deleted {
+		s.logger.WithField("snapshot", name).Info("Deleted old backup")
+	}
+	return snapshot, nil
+}
+
+// Snapshots lists the snapshots in the store, newest first
+func (s *Snapshotter) Snapshots() ([]*models.BackupSnapshot, error) {
+	return s.store.List()
+}
+
+// Restore brings the directory back to the named snapshot, or the newest
+// one when name is empty. With dryRun it only reports the changes it would
+// make. Otherwise the current state is backed up first, so the restore can
+// itself be undone; the retention is applied by the next backup.
+func (s *Snapshotter) Restore(ctx context.Context, name string, dryRun bool) (*models.RestoreResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" {
+		latest, err := s.store.Latest()
+		if err != nil {
+			return nil, err
+		}
+		if latest == nil {
+			return nil, fmt.Errorf("%w: the store is empty", ErrSnapshotNotFound)
+		}
+		name = latest.Name
+	}
+
+	data, err := s.store.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := bulk.ParseLDIFEntries(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", name, err)
+	}
+
+	if !dryRun {
+		// Without pruning, which could delete the snapshot being restored
+		before, err := s.backup(ctx, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up before restoring: %w", err)
+		}
+		s.logger.WithFields(logrus.Fields{
+			"snapshot": name,
+			"backup":   before.Name,
+		}).Warn("Restoring directory")
+	}
+
+	result, err := s.ldapMgr.RestoreDirectory(ctx, entries, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	result.Snapshot = name
+	return result, nil
+}
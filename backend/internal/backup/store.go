@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+const (
+	snapshotPrefix = "directory-"
+	snapshotSuffix = ".ldif"
+	checksumSuffix = ".sha256"
+
+	// snapshotTimeFormat sorts lexically in time order
+	snapshotTimeFormat = "20060102T150405.000Z"
+)
+
+// ErrSnapshotNotFound is returned for a snapshot the store does not hold
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Store keeps LDIF snapshots in a directory, typically a PersistentVolume.
+// Each snapshot has a checksum file in sha256sum format next to it, written
+// once the snapshot is complete, so snapshots without one are ignored and
+// can be verified by hand with "sha256sum -c".
+type Store struct {
+	dir string
+}
+
+// NewStore opens the store in dir, creating the directory if needed
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save writes data as the snapshot taken at the given time
+func (s *Store) Save(data []byte, at time.Time) (*models.BackupSnapshot, error) {
+	at = at.UTC()
+	name := snapshotPrefix + at.Format(snapshotTimeFormat) + snapshotSuffix
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	// Write to a temporary file first so a crash never leaves a truncated
+	// snapshot under its final name
+	path := filepath.Join(s.dir, name)
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.WriteFile(path+checksumSuffix, []byte(checksum+"  "+name+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot checksum: %w", err)
+	}
+
+	return &models.BackupSnapshot{
+		Name:      name,
+		CreatedAt: at,
+		Size:      int64(len(data)),
+		SHA256:    checksum,
+	}, nil
+}
+
+// List returns the complete snapshots, newest first
+func (s *Store) List() ([]*models.BackupSnapshot, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var snapshots []*models.BackupSnapshot
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		createdAt, ok := snapshotTime(name)
+		if !ok {
+			continue
+		}
+		checksum, err := s.checksum(name)
+		if err != nil {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, &models.BackupSnapshot{
+			Name:      name,
+			CreatedAt: createdAt,
+			Size:      info.Size(),
+			SHA256:    checksum,
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// Latest returns the newest snapshot, or nil when there is none
+func (s *Store) Latest() (*models.BackupSnapshot, error) {
+	snapshots, err := s.List()
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return snapshots[0], nil
+}
+
+// Read returns the content of the named snapshot after verifying its
+// checksum
+func (s *Store) Read(name string) ([]byte, error) {
+	if _, ok := snapshotTime(name); !ok || filepath.Base(name) != name {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+
+	want, err := s.checksum(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("snapshot %s is corrupt: checksum %s, want %s", name, got, want)
+	}
+	return data, nil
+}
+
+// Prune deletes all but the newest keep snapshots and returns the names
+// deleted. A keep of 0 or less keeps everything.
+func (s *Store) Prune(keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for i := keep; i < len(snapshots); i++ {
+		path := filepath.Join(s.dir, snapshots[i].Name)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, fmt.Errorf("failed to delete snapshot %s: %w", snapshots[i].Name, err)
+		}
+		if err := os.Remove(path + checksumSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, fmt.Errorf("failed to delete snapshot %s: %w", snapshots[i].Name, err)
+		}
+		deleted = append(deleted, snapshots[i].Name)
+	}
+	return deleted, nil
+}
+
+// checksum reads the recorded checksum of the named snapshot
+func (s *Store) checksum(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name+checksumSuffix))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+		}
+		return "", fmt.Errorf("failed to read snapshot checksum: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("snapshot %s has an empty checksum file", name)
+	}
+	return fields[0], nil
+}
+
+// snapshotTime parses the time out of a snapshot file name
+func snapshotTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreSaveAndRead(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	snapshot, err := store.Save([]byte("version: 1\n"), at)
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if !snapshot.CreatedAt.Equal(at) || snapshot.Size != 11 || len(snapshot.SHA256) != 64 {
+		t.Errorf("Save() = %+v", snapshot)
+	}
+
+	// The checksum file is in sha256sum format
+	sum, err := os.ReadFile(filepath.Join(store.dir, snapshot.Name+checksumSuffix))
+	if err != nil {
+		t.Fatalf("reading checksum file: %v", err)
+	}
+	if want := snapshot.SHA256 + "  " + snapshot.Name + "\n"; string(sum) != want {
+		t.Errorf("checksum file = %q, want %q", sum, want)
+	}
+
+	data, err := store.Read(snapshot.Name)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(data) != "version: 1\n" {
+		t.Errorf("Read() = %q", data)
+	}
+
+	if _, err := store.Read("../" + snapshot.Name); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Read() outside the store error = %v, want ErrSnapshotNotFound", err)
+	}
+}
+
+func TestStoreDetectsCorruption(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	snapshot, err := store.Save([]byte("version: 1\n"), time.Now())
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(store.dir, snapshot.Name), []byte("version: 2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(snapshot.Name); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Read() of a modified snapshot error = %v, want corrupt", err)
+	}
+}
+
+func TestStoreListAndPrune(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	start := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	var names []string
+	for i := 0; i < 4; i++ {
+		snapshot, err := store.Save([]byte("version: 1\n"), start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		names = append(names, snapshot.Name)
+	}
+	// Snapshots still being written have no checksum file yet
+	if err := os.WriteFile(filepath.Join(store.dir, snapshotPrefix+"20261017T000000.000Z"+snapshotSuffix), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(snapshots) != 4 || snapshots[0].Name != names[3] || snapshots[3].Name != names[0] {
+		t.Fatalf("List() = %v, want the 4 complete snapshots newest first", snapshots)
+	}
+
+	deleted, err := store.Prune(2)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if len(deleted) != 2 || deleted[0] != names[1] || deleted[1] != names[0] {
+		t.Errorf("Prune() deleted %v, want %v and %v", deleted, names[1], names[0])
+	}
+	for _, name := range names[:2] {
+		if _, err := os.Stat(filepath.Join(store.dir, name+checksumSuffix)); !os.IsNotExist(err) {
+			t.Errorf("checksum of %s survived pruning", name)
+		}
+	}
+
+	latest, err := store.Latest()
+	if err != nil || latest == nil || latest.Name != names[3] {
+		t.Errorf("Latest() = %v, %v, want %s", latest, err, names[3])
+	}
+}
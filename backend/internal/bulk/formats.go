@@ -0,0 +1,447 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// Format is a bulk import/export file format
+type Format string
+
+// Supported formats
+const (
+	FormatCSV  Format = "CSV"
+	FormatLDIF Format = "LDIF"
+)
+
+// ParseFormat parses a case-insensitive format name
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToUpper(strings.TrimSpace(s))) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatLDIF:
+		return FormatLDIF, nil
+	}
+	return "", fmt.Errorf("unsupported format %q (want CSV or LDIF)", s)
+}
+
+// repoSeparator separates multiple repositories inside one CSV cell
+const repoSeparator = ";"
+
+// csvColumns are the columns written by export, in order
+var csvColumns = []string{"uid", "cn", "sn", "givenName", "mail", "department", "uidNumber", "gidNumber", "repositories"}
+
+// csvIgnoredColumns may appear in an import (e.g. a previous export) but are
+// assigned by the directory, not taken from the file
+var csvIgnoredColumns = map[string]bool{"uidNumber": true, "gidNumber": true, "homeDirectory": true, "dn": true}
+
+// row is one parsed record of an import file
+type row struct {
+	line  int
+	input *models.CreateUserInput
+	err   error
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// CSV
+// ═══════════════════════════════════════════════════════════════════════════
+
+// parseCSV parses a CSV file with a header row naming the columns
+func parseCSV(data []byte) ([]*row, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		switch name {
+		case "uid", "cn", "sn", "givenName", "mail", "department", "password", "repositories":
+		default:
+			if !csvIgnoredColumns[name] {
+				return nil, fmt.Errorf("unknown CSV column %q", name)
+			}
+		}
+		columns[i] = name
+	}
+
+	var rows []*row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, &row{line: line, err: err})
+			continue
+		}
+		if len(record) != len(columns) {
+			rows = append(rows, &row{line: line, err: fmt.Errorf("expected %d fields, got %d", len(columns), len(record))})
+			continue
+		}
+
+		input := &models.CreateUserInput{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case "uid":
+				input.UID = value
+			case "cn":
+				input.CN = value
+			case "sn":
+				input.SN = value
+			case "givenName":
+				input.GivenName = value
+			case "mail":
+				input.Mail = value
+			case "department":
+				input.Department = value
+			case "password":
+				input.Password = value
+			case "repositories":
+				for _, repo := range strings.Split(value, repoSeparator) {
+					if repo = strings.TrimSpace(repo); repo != "" {
+						input.Repositories = append(input.Repositories, repo)
+					}
+				}
+			}
+		}
+		rows = append(rows, &row{line: line, input: input})
+	}
+
+	return rows, nil
+}
+
+// writeCSV writes users as CSV with the export columns
+func writeCSV(w io.Writer, users []*models.User) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if err := writer.Write([]string{
+			u.UID,
+			u.CN,
+			u.SN,
+			u.GivenName,
+			u.Mail,
+			u.Department,
+			strconv.Itoa(u.UIDNumber),
+			strconv.Itoa(u.GIDNumber),
+			strings.Join(u.Repositories, repoSeparator),
+		}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// LDIF
+// ═══════════════════════════════════════════════════════════════════════════
+
+// ldifRecord is one content record of an LDIF file
+type ldifRecord struct {
+	line  int
+	dn    string
+	attrs map[string][]string
+}
+
+// parseLDIFRecords parses LDIF content records (RFC 2849), handling comments,
+// folded lines and base64 values. Change records are rejected.
+func parseLDIFRecords(data []byte) ([]*ldifRecord, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var records []*ldifRecord
+	var logical []string // unfolded lines of the current record
+	var lineNo, startLine int
+
+	flush := func() error {
+		if len(logical) == 0 {
+			return nil
+		}
+		rec := &ldifRecord{line: startLine, attrs: make(map[string][]string)}
+		for _, l := range logical {
+			name, value, err := parseLDIFLine(l)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", startLine, err)
+			}
+			switch strings.ToLower(name) {
+			case "version":
+				continue
+			case "dn":
+				rec.dn = value
+			case "changetype":
+				return fmt.Errorf("line %d: change records are not supported", startLine)
+			default:
+				rec.attrs[name] = append(rec.attrs[name], value)
+			}
+		}
+		if rec.dn != "" || len(rec.attrs) > 0 {
+			records = append(records, rec)
+		}
+		logical = nil
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		switch {
+		case text == "":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(text, "#"):
+			continue
+		case strings.HasPrefix(text, " ") && len(logical) > 0:
+			logical[len(logical)-1] += text[1:]
+		default:
+			if len(logical) == 0 {
+				startLine = lineNo
+			}
+			logical = append(logical, text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read LDIF: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// parseLDIFLine splits "attr: value" or "attr:: base64"
+func parseLDIFLine(line string) (string, string, error) {
+	idx := strings.Index(line, ":")
+	if idx <= 0 {
+		return "", "", fmt.Errorf("malformed line %q", line)
+	}
+	name := line[:idx]
+	rest := line[idx+1:]
+
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value for %s: %w", name, err)
+		}
+		return name, string(decoded), nil
+	case strings.HasPrefix(rest, "<"):
+		return "", "", fmt.Errorf("URL values are not supported for %s", name)
+	}
+	return name, strings.TrimSpace(rest), nil
+}
+
+// parseLDIF maps LDIF user entries to create inputs
+func parseLDIF(data []byte) ([]*row, error) {
+	records, err := parseLDIFRecords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*row, 0, len(records))
+	for _, rec := range records {
+		first := func(names ...string) string {
+			for _, name := range names {
+				for key, values := range rec.attrs {
+					if strings.EqualFold(key, name) && len(values) > 0 {
+						return values[0]
+					}
+				}
+			}
+			return ""
+		}
+		all := func(name string) []string {
+			for key, values := range rec.attrs {
+				if strings.EqualFold(key, name) {
+					return values
+				}
+			}
+			return nil
+		}
+
+		input := &models.CreateUserInput{
+			UID:        first("uid"),
+			CN:         first("cn"),
+			SN:         first("sn"),
+			GivenName:  first("givenName"),
+			Mail:       first("mail"),
+			Department: first("departmentNumber", "department"),
+			Password:   first("userPassword"),
+		}
+
+		// githubRepository also lists group repositories; exports that
+		// carry grants are restored from those alone
+		r := &row{line: rec.line, input: input}
+		if values := all("repositoryGrant"); len(values) > 0 {
+			for _, value := range values {
+				grant, err := models.ParseRepositoryGrant(value)
+				if err != nil {
+					r.err = err
+					break
+				}
+				input.Grants = append(input.Grants, grant)
+			}
+		} else {
+			input.Repositories = all("githubRepository")
+		}
+
+		// Fall back to the RDN when the uid attribute is omitted
+		if input.UID == "" && strings.HasPrefix(strings.ToLower(rec.dn), "uid=") {
+			input.UID = strings.SplitN(rec.dn[4:], ",", 2)[0]
+		}
+
+		rows = append(rows, r)
+	}
+
+	return rows, nil
+}
+
+// writeLDIF writes users as LDIF content records
+func writeLDIF(w io.Writer, users []*models.User) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "version: 1")
+
+	for _, u := range users {
+		fmt.Fprintln(bw)
+		writeLDIFAttr(bw, "dn", u.DN)
+		for _, oc := range []string{"inetOrgPerson", "posixAccount", "shadowAccount", "extensibleObject"} {
+			writeLDIFAttr(bw, "objectClass", oc)
+		}
+
+		attrs := map[string][]string{
+			"uid":              {u.UID},
+			"cn":               {u.CN},
+			"sn":               {u.SN},
+			"givenName":        {u.GivenName},
+			"mail":             {u.Mail},
+			"departmentNumber": {u.Department},
+			"uidNumber":        {strconv.Itoa(u.UIDNumber)},
+			"gidNumber":        {strconv.Itoa(u.GIDNumber)},
+			"homeDirectory":    {u.HomeDir},
+			"githubRepository": u.Repositories,
+			"repositoryGrant":  models.GrantValues(u.Grants),
+		}
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			for _, value := range attrs[name] {
+				if value != "" {
+					writeLDIFAttr(bw, name, value)
+				}
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ParseLDIFEntries parses LDIF content records into directory entries,
+// such as a backup snapshot
+func ParseLDIFEntries(data []byte) ([]*models.DirectoryEntry, error) {
+	records, err := parseLDIFRecords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.DirectoryEntry, 0, len(records))
+	for _, rec := range records {
+		if rec.dn == "" {
+			return nil, fmt.Errorf("line %d: record has no dn", rec.line)
+		}
+		entries = append(entries, &models.DirectoryEntry{DN: rec.dn, Attributes: rec.attrs})
+	}
+	return entries, nil
+}
+
+// WriteLDIFEntries writes entries as LDIF content records, objectClass
+// first and the other attributes in name order, so the same directory
+// always gives the same file
+func WriteLDIFEntries(w io.Writer, entries []*models.DirectoryEntry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "version: 1")
+
+	for _, entry := range entries {
+		names := make([]string, 0, len(entry.Attributes))
+		for name := range entry.Attributes {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			ci, cj := strings.EqualFold(names[i], "objectClass"), strings.EqualFold(names[j], "objectClass")
+			if ci != cj {
+				return ci
+			}
+			return names[i] < names[j]
+		})
+
+		fmt.Fprintln(bw)
+		writeLDIFAttr(bw, "dn", entry.DN)
+		for _, name := range names {
+			for _, value := range entry.Attributes[name] {
+				writeLDIFAttr(bw, name, value)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeLDIFAttr writes one attribute, base64-encoding values that are not
+// safe strings per RFC 2849
+func writeLDIFAttr(w io.Writer, name, value string) {
+	if ldifSafe(value) {
+		fmt.Fprintf(w, "%s: %s\n", name, value)
+		return
+	}
+	fmt.Fprintf(w, "%s:: %s\n", name, base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+func ldifSafe(value string) bool {
+	if value == "" {
+		return true
+	}
+	if !utf8.ValidString(value) {
+		return false
+	}
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if strings.HasSuffix(value, " ") {
+		return false
+	}
+	for _, r := range value {
+		if r == '\n' || r == '\r' || r == 0 || r > 127 {
+			return false
+		}
+	}
+	return true
+}
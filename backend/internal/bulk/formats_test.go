@@ -0,0 +1,298 @@
+package bulk
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{in: "CSV", want: FormatCSV},
+		{in: " ldif ", want: FormatLDIF},
+		{in: "json", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		want     []*models.CreateUserInput
+		wantErrs []bool // per row
+		wantLine []int
+		wantErr  bool
+	}{
+		{
+			name: "all columns",
+			data: "uid,cn,sn,givenName,mail,department,password,repositories\n" +
+				"jdoe, John Doe ,Doe,John,jdoe@example.com,eng,s3cret!Pass,api; web ;\n",
+			want: []*models.CreateUserInput{{
+				UID: "jdoe", CN: "John Doe", SN: "Doe", GivenName: "John", Mail: "jdoe@example.com",
+				Department: "eng", Password: "s3cret!Pass", Repositories: []string{"api", "web"},
+			}},
+			wantErrs: []bool{false},
+			wantLine: []int{2},
+		},
+		{
+			name: "export with byte order mark and assigned columns",
+			data: "\ufeffuid,cn,sn,mail,uidNumber,gidNumber,repositories\n" +
+				"jdoe,\"Doe, John\",Doe,jdoe@example.com,10001,10001,\n",
+			want:     []*models.CreateUserInput{{UID: "jdoe", CN: "Doe, John", SN: "Doe", Mail: "jdoe@example.com"}},
+			wantErrs: []bool{false},
+			wantLine: []int{2},
+		},
+		{
+			name: "bad rows are reported per line",
+			data: "uid,cn,sn,mail\n" +
+				"jdoe,John Doe,Doe\n" +
+				"asmith,Anna Smith,Smith,asmith@example.com\n" +
+				"bad,\"unterminated,x,y\n",
+			want:     []*models.CreateUserInput{nil, {UID: "asmith", CN: "Anna Smith", SN: "Smith", Mail: "asmith@example.com"}, nil},
+			wantErrs: []bool{true, false, true},
+			wantLine: []int{2, 3, 4},
+		},
+		{
+			name:    "unknown column",
+			data:    "uid,cn,sn,mail,shell\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty file",
+			data:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseCSV([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(rows) != len(tt.wantErrs) {
+				t.Fatalf("parseCSV() returned %d rows, want %d", len(rows), len(tt.wantErrs))
+			}
+			for i, r := range rows {
+				if (r.err != nil) != tt.wantErrs[i] {
+					t.Errorf("row %d error = %v, wantErr %v", i, r.err, tt.wantErrs[i])
+				}
+				if r.line != tt.wantLine[i] {
+					t.Errorf("row %d line = %d, want %d", i, r.line, tt.wantLine[i])
+				}
+				if tt.want[i] != nil && !reflect.DeepEqual(r.input, tt.want[i]) {
+					t.Errorf("row %d = %+v, want %+v", i, r.input, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseLDIFRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []*ldifRecord
+		wantErr string
+	}{
+		{
+			name: "comments, folding and base64",
+			data: "version: 1\r\n" +
+				"# exported users\r\n" +
+				"\r\n" +
+				"dn: uid=jdoe,ou=users,dc=example,dc=com\r\n" +
+				"cn: John\r\n" +
+				"  Doe\r\n" +
+				"description:: SsO8cmdlbg==\r\n" +
+				"# inside a record\r\n" +
+				"mail: jdoe@example.com\r\n" +
+				"mail: john@example.com\r\n" +
+				"\r\n" +
+				"\r\n" +
+				"dn: uid=asmith,ou=users,dc=example,dc=com\r\n" +
+				"uid: asmith\r\n",
+			want: []*ldifRecord{
+				{line: 4, dn: "uid=jdoe,ou=users,dc=example,dc=com", attrs: map[string][]string{
+					"cn":          {"John Doe"},
+					"description": {"Jürgen"},
+					"mail":        {"jdoe@example.com", "john@example.com"},
+				}},
+				{line: 13, dn: "uid=asmith,ou=users,dc=example,dc=com", attrs: map[string][]string{"uid": {"asmith"}}},
+			},
+		},
+		{
+			name:    "change records",
+			data:    "dn: uid=jdoe,ou=users,dc=example,dc=com\nchangetype: delete\n",
+			wantErr: "change records are not supported",
+		},
+		{
+			name:    "URL values",
+			data:    "dn: uid=jdoe,ou=users,dc=example,dc=com\njpegPhoto:< file:///etc/passwd\n",
+			wantErr: "URL values are not supported",
+		},
+		{
+			name:    "invalid base64",
+			data:    "dn: uid=jdoe,ou=users,dc=example,dc=com\ncn:: !!!\n",
+			wantErr: "invalid base64",
+		},
+		{
+			name:    "line without a colon",
+			data:    "\n\ndn: uid=jdoe,ou=users,dc=example,dc=com\ngarbage\n",
+			wantErr: "line 3: malformed line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := parseLDIFRecords([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLDIFRecords() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLDIFRecords() error = %v", err)
+			}
+			if !reflect.DeepEqual(records, tt.want) {
+				t.Errorf("parseLDIFRecords() = %+v, want %+v", records, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLDIF(t *testing.T) {
+	data := "version: 1\n" +
+		"\n" +
+		"dn: uid=jdoe,ou=users,dc=example,dc=com\n" +
+		"objectClass: inetOrgPerson\n" +
+		"cn: John Doe\n" +
+		"sn: Doe\n" +
+		"mail: jdoe@example.com\n" +
+		"departmentNumber: eng\n" +
+		"githubRepository: api\n" +
+		"githubRepository: group-repo\n" +
+		"repositoryGrant: api admin\n" +
+		"\n" +
+		"dn: uid=asmith,ou=users,dc=example,dc=com\n" +
+		"CN: Anna Smith\n" +
+		"sn: Smith\n" +
+		"mail: asmith@example.com\n" +
+		"githubRepository: web\n" +
+		"\n" +
+		"dn: uid=bad,ou=users,dc=example,dc=com\n" +
+		"repositoryGrant: api everything\n"
+
+	rows, err := parseLDIF([]byte(data))
+	if err != nil {
+		t.Fatalf("parseLDIF() error = %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("parseLDIF() returned %d rows, want 3", len(rows))
+	}
+
+	// Grants win over githubRepository, which also lists group repositories
+	want := &models.CreateUserInput{
+		UID: "jdoe", CN: "John Doe", SN: "Doe", Mail: "jdoe@example.com", Department: "eng",
+		Grants: []models.RepositoryGrant{{Repository: "api", Permission: models.PermissionAdmin}},
+	}
+	if rows[0].err != nil || !reflect.DeepEqual(rows[0].input, want) || rows[0].line != 3 {
+		t.Errorf("row 0 = %+v (line %d, error %v), want %+v", rows[0].input, rows[0].line, rows[0].err, want)
+	}
+
+	// The uid comes from the RDN and attribute names ignore case
+	want = &models.CreateUserInput{
+		UID: "asmith", CN: "Anna Smith", SN: "Smith", Mail: "asmith@example.com", Repositories: []string{"web"},
+	}
+	if rows[1].err != nil || !reflect.DeepEqual(rows[1].input, want) {
+		t.Errorf("row 1 = %+v (error %v), want %+v", rows[1].input, rows[1].err, want)
+	}
+
+	if rows[2].err == nil {
+		t.Error("row with an invalid grant has no error")
+	}
+}
+
+func TestLDIFEntriesRoundTrip(t *testing.T) {
+	entries := []*models.DirectoryEntry{
+		{DN: "dc=example,dc=com", Attributes: map[string][]string{
+			"objectClass": {"top", "domain"},
+			"dc":          {"example"},
+		}},
+		{DN: "uid=jdoe,ou=users,dc=example,dc=com", Attributes: map[string][]string{
+			"uid":          {"jdoe"},
+			"objectClass":  {"inetOrgPerson"},
+			"cn":           {"Jürgen Doe"},
+			"description":  {" leading space", "trailing space ", ":colon", "multi\nline"},
+			"userPassword": {"{SSHA}abc"},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteLDIFEntries(&buf, entries); err != nil {
+		t.Fatalf("WriteLDIFEntries() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "dn: uid=jdoe,ou=users,dc=example,dc=com\nobjectClass: inetOrgPerson\ncn:: ") {
+		t.Errorf("objectClass is not written first, or cn is not base64:\n%s", buf.String())
+	}
+
+	parsed, err := ParseLDIFEntries(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseLDIFEntries() error = %v", err)
+	}
+	if !reflect.DeepEqual(parsed, entries) {
+		t.Errorf("ParseLDIFEntries() = %+v, want %+v", parsed, entries)
+	}
+
+	if _, err := ParseLDIFEntries([]byte("cn: orphan\n")); err == nil {
+		t.Error("ParseLDIFEntries() accepted a record without dn")
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	users := []*models.User{{
+		UID: "jdoe", CN: "Doe, John", SN: "Doe", Mail: "jdoe@example.com", Department: "eng",
+		UIDNumber: 10001, GIDNumber: 10001, DN: "uid=jdoe,ou=users,dc=example,dc=com",
+		Repositories: []string{"api", "web"},
+		Grants:       []models.RepositoryGrant{{Repository: "api", Permission: models.PermissionWrite}},
+	}}
+
+	var csvData bytes.Buffer
+	if err := writeCSV(&csvData, users); err != nil {
+		t.Fatalf("writeCSV() error = %v", err)
+	}
+	rows, err := parseCSV(csvData.Bytes())
+	if err != nil || len(rows) != 1 || rows[0].err != nil {
+		t.Fatalf("parseCSV() of an export = %v, %v", rows, err)
+	}
+	want := &models.CreateUserInput{UID: "jdoe", CN: "Doe, John", SN: "Doe", Mail: "jdoe@example.com", Department: "eng", Repositories: []string{"api", "web"}}
+	if !reflect.DeepEqual(rows[0].input, want) {
+		t.Errorf("CSV round trip = %+v, want %+v", rows[0].input, want)
+	}
+
+	var ldifData bytes.Buffer
+	if err := writeLDIF(&ldifData, users); err != nil {
+		t.Fatalf("writeLDIF() error = %v", err)
+	}
+	rows, err = parseLDIF(ldifData.Bytes())
+	if err != nil || len(rows) != 1 || rows[0].err != nil {
+		t.Fatalf("parseLDIF() of an export = %v, %v", rows, err)
+	}
+	want = &models.CreateUserInput{UID: "jdoe", CN: "Doe, John", SN: "Doe", Mail: "jdoe@example.com", Department: "eng", Grants: users[0].Grants}
+	if !reflect.DeepEqual(rows[0].input, want) {
+		t.Errorf("LDIF round trip = %+v, want %+v", rows[0].input, want)
+	}
+}
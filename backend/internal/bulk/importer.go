@@ -0,0 +1,285 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Row actions reported by an import
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionSkip    = "skip"
+	ActionInvalid = "invalid"
+	ActionFailed  = "failed"
+)
+
+// ImportOptions controls how an import is applied
+type ImportOptions struct {
+	// DryRun validates and plans every row without writing anything
+	DryRun bool
+	// Upsert updates users that already exist instead of rejecting the row
+	Upsert bool
+}
+
+// RowResult is the outcome of one input row
+type RowResult struct {
+	Line   int      `json:"line"`
+	UID    string   `json:"uid"`
+	Action string   `json:"action"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportReport summarises an import
+type ImportReport struct {
+	DryRun     bool         `json:"dryRun"`
+	Total      int          `json:"total"`
+	Created    int          `json:"created"`
+	Updated    int          `json:"updated"`
+	Failed     int          `json:"failed"`
+	RolledBack bool         `json:"rolledBack"`
+	Rows       []*RowResult `json:"rows"`
+}
+
+// Importer bulk-imports and exports users through the LDAP interface, so
+// metrics, auditing and validation apply exactly as for single mutations
+type Importer struct {
+	ldapMgr prometheus.LDAPInterface
+	logger  *logrus.Logger
+}
+
+// NewImporter creates a new bulk importer
+func NewImporter(ldapMgr prometheus.LDAPInterface, logger *logrus.Logger) *Importer {
+	return &Importer{
+		ldapMgr: ldapMgr,
+		logger:  logger,
+	}
+}
+
+// plannedRow is a validated row together with the user it would update
+type plannedRow struct {
+	row      *row
+	result   *RowResult
+	existing *models.User
+}
+
+// Import parses data and creates (or, with Upsert, updates) every user in it.
+//
+// All rows are validated before anything is written; a single invalid row
+// aborts the import. If a write fails midway, users created by this import
+// are deleted and updated users are restored to their previous attributes,
+// custom ones included (passwords cannot be restored). Rollback is best effort: LDAP has no
+// multi-entry transactions.
+func (i *Importer) Import(ctx context.Context, format Format, data []byte, opts ImportOptions) (*ImportReport, error) {
+	rows, err := parse(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun, Total: len(rows)}
+	plan, valid := i.plan(ctx, rows, opts, report)
+
+	if !valid || opts.DryRun {
+		return report, nil
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"rows":   len(plan),
+		"upsert": opts.Upsert,
+	}).Info("Applying bulk user import")
+
+	var applied []*plannedRow
+	for idx, planned := range plan {
+		if err := i.apply(ctx, planned); err != nil {
+			planned.result.Action = ActionFailed
+			planned.result.Errors = append(planned.result.Errors, err.Error())
+			report.Failed++
+
+			for _, rest := range plan[idx+1:] {
+				rest.result.Action = ActionSkip
+			}
+
+			i.rollback(ctx, applied)
+			report.RolledBack = true
+			report.Created, report.Updated = 0, 0
+			return report, nil
+		}
+
+		applied = append(applied, planned)
+		if planned.existing != nil {
+			report.Updated++
+		} else {
+			report.Created++
+		}
+	}
+
+	return report, nil
+}
+
+// plan validates every row and decides whether it creates or updates
+func (i *Importer) plan(ctx context.Context, rows []*row, opts ImportOptions, report *ImportReport) ([]*plannedRow, bool) {
+	valid := true
+	seen := make(map[string]int)
+	plan := make([]*plannedRow, 0, len(rows))
+
+	for _, r := range rows {
+		result := &RowResult{Line: r.line}
+		report.Rows = append(report.Rows, result)
+
+		invalid := func(msg string) {
+			result.Action = ActionInvalid
+			result.Errors = append(result.Errors, msg)
+		}
+
+		if r.err != nil {
+			invalid(r.err.Error())
+			report.Failed++
+			valid = false
+			continue
+		}
+		result.UID = r.input.UID
+
+		if line, dup := seen[r.input.UID]; dup {
+			invalid(fmt.Sprintf("duplicate uid, first seen on line %d", line))
+		}
+		seen[r.input.UID] = r.line
+
+		existing, err := i.ldapMgr.GetUser(ctx, r.input.UID)
+		if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+			invalid(fmt.Sprintf("failed to look up user: %v", err))
+		}
+
+		switch {
+		case existing != nil && !opts.Upsert:
+			invalid("user already exists (enable upsert to update it)")
+		case existing != nil:
+			result.Action = ActionUpdate
+			if err := r.input.ValidateProfile(); err != nil {
+				invalid(err.Error())
+			}
+		default:
+			result.Action = ActionCreate
+			if err := r.input.Validate(); err != nil {
+				invalid(err.Error())
+			}
+		}
+
+		// Check passwords now so a dry run reports weak or reused ones the
+		// real import would reject
+		if r.input.Password != "" && len(result.Errors) == 0 {
+			if err := i.ldapMgr.ValidatePassword(ctx, r.input.UID, r.input.Password); err != nil {
+				invalid(err.Error())
+			}
+		}
+
+		if len(result.Errors) > 0 {
+			result.Action = ActionInvalid
+			report.Failed++
+			valid = false
+			continue
+		}
+
+		plan = append(plan, &plannedRow{row: r, result: result, existing: existing})
+	}
+
+	return plan, valid
+}
+
+// apply writes one planned row
+func (i *Importer) apply(ctx context.Context, planned *plannedRow) error {
+	input := planned.row.input
+
+	if planned.existing == nil {
+		_, err := i.ldapMgr.CreateUser(ctx, input)
+		return err
+	}
+
+	update := &models.UpdateUserInput{
+		UID:  input.UID,
+		CN:   &input.CN,
+		SN:   &input.SN,
+		Mail: &input.Mail,
+	}
+	if input.GivenName != "" {
+		update.GivenName = &input.GivenName
+	}
+	if input.Department != "" {
+		update.Department = &input.Department
+	}
+	if input.Password != "" {
+		update.Password = &input.Password
+	}
+	if len(input.Repositories) > 0 || len(input.Grants) > 0 {
+		update.Repositories = input.Repositories
+		update.Grants = input.Grants
+	}
+
+	_, err := i.ldapMgr.UpdateUser(ctx, update)
+	return err
+}
+
+// rollback undoes applied rows in reverse order
+func (i *Importer) rollback(ctx context.Context, applied []*plannedRow) {
+	i.logger.WithField("rows", len(applied)).Warn("Bulk import failed, rolling back")
+
+	for idx := len(applied) - 1; idx >= 0; idx-- {
+		planned := applied[idx]
+		uid := planned.row.input.UID
+
+		var err error
+		if planned.existing == nil {
+			err = i.ldapMgr.DeleteUser(ctx, uid)
+		} else {
+			_, err = i.ldapMgr.RestoreUser(ctx, planned.existing)
+		}
+
+		if err != nil {
+			planned.result.Errors = append(planned.result.Errors, fmt.Sprintf("rollback failed: %v", err))
+			i.logger.WithError(err).WithField("uid", uid).Error("Failed to roll back imported user")
+			continue
+		}
+		planned.result.Action = ActionSkip
+		planned.result.Errors = append(planned.result.Errors, "rolled back")
+	}
+}
+
+// Export writes all users matching filter in the given format.
+// Passwords are never exported.
+func (i *Importer) Export(ctx context.Context, format Format, filter *models.SearchFilter) ([]byte, error) {
+	users, err := i.ldapMgr.ListUsers(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatCSV:
+		err = writeCSV(&buf, users)
+	case FormatLDIF:
+		err = writeLDIF(&buf, users)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to export users: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parse dispatches to the parser for format
+func parse(format Format, data []byte) ([]*row, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(data)
+	case FormatLDIF:
+		return parseLDIF(data)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
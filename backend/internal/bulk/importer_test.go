@@ -0,0 +1,304 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeManager keeps users in memory and fails the writes named in failOn.
+// Operations the importer never calls panic through the nil embedded
+// interface.
+type fakeManager struct {
+	prometheus.LDAPInterface
+	users  map[string]*models.User
+	failOn map[string]bool // "create:uid", "update:uid", "delete:uid", "restore:uid"
+	calls  []string
+}
+
+func newFakeManager(users ...*models.User) *fakeManager {
+	m := &fakeManager{users: make(map[string]*models.User), failOn: make(map[string]bool)}
+	for _, u := range users {
+		m.users[u.UID] = u
+	}
+	return m
+}
+
+func (m *fakeManager) write(op, uid string) error {
+	m.calls = append(m.calls, op+":"+uid)
+	if m.failOn[op+":"+uid] {
+		return errors.New(op + " " + uid + " failed")
+	}
+	return nil
+}
+
+func (m *fakeManager) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	if u, ok := m.users[uid]; ok {
+		copied := *u
+		return &copied, nil
+	}
+	return nil, models.ErrUserNotFound
+}
+
+func (m *fakeManager) ValidatePassword(ctx context.Context, uid, password string) error {
+	if password == "weak" {
+		return errors.New("password is too short")
+	}
+	return nil
+}
+
+func (m *fakeManager) CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
+	if err := m.write("create", input.UID); err != nil {
+		return nil, err
+	}
+	m.users[input.UID] = &models.User{UID: input.UID, CN: input.CN, SN: input.SN, Mail: input.Mail}
+	return m.GetUser(ctx, input.UID)
+}
+
+func (m *fakeManager) UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error) {
+	if err := m.write("update", input.UID); err != nil {
+		return nil, err
+	}
+	u := m.users[input.UID]
+	u.CN, u.SN, u.Mail = *input.CN, *input.SN, *input.Mail
+	return m.GetUser(ctx, input.UID)
+}
+
+func (m *fakeManager) DeleteUser(ctx context.Context, uid string) error {
+	if err := m.write("delete", uid); err != nil {
+		return err
+	}
+	delete(m.users, uid)
+	return nil
+}
+
+func (m *fakeManager) RestoreUser(ctx context.Context, snapshot *models.User) (*models.User, error) {
+	if err := m.write("restore", snapshot.UID); err != nil {
+		return nil, err
+	}
+	restored := *snapshot
+	m.users[snapshot.UID] = &restored
+	return m.GetUser(ctx, snapshot.UID)
+}
+
+func newTestImporter(m *fakeManager) *Importer {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewImporter(m, logger)
+}
+
+const importHeader = "uid,cn,sn,mail,password\n"
+
+// existingUser is in the directory before each import
+func existingUser() *models.User {
+	return &models.User{UID: "bob", CN: "Bob", SN: "Builder", Mail: "bob@example.com"}
+}
+
+func rowActions(report *ImportReport) []string {
+	actions := make([]string, 0, len(report.Rows))
+	for _, r := range report.Rows {
+		actions = append(actions, r.UID+"="+r.Action)
+	}
+	return actions
+}
+
+func TestImportPlan(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		opts        ImportOptions
+		wantActions []string
+		wantFailed  int
+		wantErrors  map[string]string // uid -> substring of its error
+	}{
+		{
+			name: "dry run plans creates and updates",
+			data: importHeader +
+				"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n" +
+				"bob,Bob,Builder,bob@new.example.com,\n",
+			opts:        ImportOptions{DryRun: true, Upsert: true},
+			wantActions: []string{"alice=create", "bob=update"},
+		},
+		{
+			name: "existing user without upsert",
+			data: importHeader +
+				"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n" +
+				"bob,Bob,Builder,bob@example.com,Str0ng!Pass\n",
+			opts:        ImportOptions{DryRun: true},
+			wantActions: []string{"alice=create", "bob=invalid"},
+			wantFailed:  1,
+			wantErrors:  map[string]string{"bob": "already exists"},
+		},
+		{
+			name: "new user without password",
+			data: importHeader +
+				"alice,Alice,Smith,alice@example.com,\n",
+			opts:        ImportOptions{DryRun: true, Upsert: true},
+			wantActions: []string{"alice=invalid"},
+			wantFailed:  1,
+			wantErrors:  map[string]string{"alice": "password is required"},
+		},
+		{
+			name: "weak password and bad mail",
+			data: importHeader +
+				"alice,Alice,Smith,alice@example.com,weak\n" +
+				"carol,Carol,Jones,not-an-address,Str0ng!Pass\n",
+			opts:        ImportOptions{DryRun: true},
+			wantActions: []string{"alice=invalid", "carol=invalid"},
+			wantFailed:  2,
+			wantErrors:  map[string]string{"alice": "too short", "carol": "not a valid address"},
+		},
+		{
+			name: "duplicate uid",
+			data: importHeader +
+				"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n" +
+				"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n",
+			opts:        ImportOptions{DryRun: true},
+			wantActions: []string{"alice=create", "alice=invalid"},
+			wantFailed:  1,
+			wantErrors:  map[string]string{"alice": "first seen on line 2"},
+		},
+		{
+			name: "an invalid row stops a real import",
+			data: importHeader +
+				"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n" +
+				"carol,Carol,Jones,not-an-address,Str0ng!Pass\n",
+			wantActions: []string{"alice=create", "carol=invalid"},
+			wantFailed:  1,
+		},
+		{
+			name: "unparsable row",
+			data: importHeader +
+				"alice,Alice\n",
+			opts:        ImportOptions{DryRun: true},
+			wantActions: []string{"=invalid"},
+			wantFailed:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeManager(existingUser())
+			report, err := newTestImporter(m).Import(context.Background(), FormatCSV, []byte(tt.data), tt.opts)
+			if err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+
+			if got := rowActions(report); !reflect.DeepEqual(got, tt.wantActions) {
+				t.Errorf("row actions = %v, want %v", got, tt.wantActions)
+			}
+			if report.Failed != tt.wantFailed || report.Created != 0 || report.Updated != 0 {
+				t.Errorf("report = %+v, want %d failed and nothing written", report, tt.wantFailed)
+			}
+			if len(m.calls) != 0 {
+				t.Errorf("Import() wrote %v", m.calls)
+			}
+			for _, r := range report.Rows {
+				want, ok := tt.wantErrors[r.UID]
+				if ok && r.Action == ActionInvalid && !strings.Contains(strings.Join(r.Errors, "; "), want) {
+					t.Errorf("%s errors = %v, want %q", r.UID, r.Errors, want)
+				}
+			}
+		})
+	}
+}
+
+func TestImportApply(t *testing.T) {
+	m := newFakeManager(existingUser())
+	data := importHeader +
+		"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n" +
+		"bob,Robert,Builder,robert@example.com,\n"
+
+	report, err := newTestImporter(m).Import(context.Background(), FormatCSV, []byte(data), ImportOptions{Upsert: true})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if report.Created != 1 || report.Updated != 1 || report.Failed != 0 || report.RolledBack {
+		t.Errorf("report = %+v", report)
+	}
+	if want := []string{"create:alice", "update:bob"}; !reflect.DeepEqual(m.calls, want) {
+		t.Errorf("writes = %v, want %v", m.calls, want)
+	}
+	if m.users["bob"].Mail != "robert@example.com" {
+		t.Errorf("bob's mail = %q, want the imported one", m.users["bob"].Mail)
+	}
+}
+
+func TestImportRollback(t *testing.T) {
+	data := importHeader +
+		"alice,Alice,Smith,alice@example.com,Str0ng!Pass\n" +
+		"bob,Robert,Builder,robert@example.com,\n" +
+		"carol,Carol,Jones,carol@example.com,Str0ng!Pass\n" +
+		"dave,Dave,Brown,dave@example.com,Str0ng!Pass\n"
+
+	tests := []struct {
+		name        string
+		failOn      []string
+		wantCalls   []string
+		wantActions []string
+		wantErrors  map[string]string
+	}{
+		{
+			name:   "undoes the applied rows in reverse",
+			failOn: []string{"create:carol"},
+			wantCalls: []string{
+				"create:alice", "update:bob", "create:carol",
+				"restore:bob", "delete:alice",
+			},
+			wantActions: []string{"alice=skip", "bob=skip", "carol=failed", "dave=skip"},
+			wantErrors:  map[string]string{"alice": "rolled back", "bob": "rolled back", "carol": "create carol failed"},
+		},
+		{
+			name:   "reports rows it could not undo",
+			failOn: []string{"create:carol", "delete:alice"},
+			wantCalls: []string{
+				"create:alice", "update:bob", "create:carol",
+				"restore:bob", "delete:alice",
+			},
+			wantActions: []string{"alice=create", "bob=skip", "carol=failed", "dave=skip"},
+			wantErrors:  map[string]string{"alice": "rollback failed: delete alice failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeManager(existingUser())
+			for _, op := range tt.failOn {
+				m.failOn[op] = true
+			}
+
+			report, err := newTestImporter(m).Import(context.Background(), FormatCSV, []byte(data), ImportOptions{Upsert: true})
+			if err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+
+			if !report.RolledBack || report.Created != 0 || report.Updated != 0 || report.Failed != 1 {
+				t.Errorf("report = %+v", report)
+			}
+			if !reflect.DeepEqual(m.calls, tt.wantCalls) {
+				t.Errorf("writes = %v, want %v", m.calls, tt.wantCalls)
+			}
+			if got := rowActions(report); !reflect.DeepEqual(got, tt.wantActions) {
+				t.Errorf("row actions = %v, want %v", got, tt.wantActions)
+			}
+			for _, r := range report.Rows {
+				if want, ok := tt.wantErrors[r.UID]; ok && !strings.Contains(strings.Join(r.Errors, "; "), want) {
+					t.Errorf("%s errors = %v, want %q", r.UID, r.Errors, want)
+				}
+			}
+
+			// bob is back to what he was before the import
+			if !reflect.DeepEqual(m.users["bob"], existingUser()) {
+				t.Errorf("bob after rollback = %+v, want %+v", m.users["bob"], existingUser())
+			}
+		})
+	}
+}
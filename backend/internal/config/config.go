@@ -1,23 +1,63 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
+	"github.com/devplatform/ldap-manager/internal/models"
 	"github.com/kelseyhightower/envconfig"
 )
 
 // Config holds all configuration for the LDAP manager service
 type Config struct {
-	// LDAP configuration
-	LDAPURL             string        `envconfig:"LDAP_URL" required:"true"`
-	LDAPBaseDN          string        `envconfig:"LDAP_BASE_DN" required:"true"`
-	LDAPBindDN          string        `envconfig:"LDAP_BIND_DN" required:"true"`
-	LDAPBindPassword    string        `envconfig:"LDAP_BIND_PASSWORD" required:"true"`
-	LDAPPoolSize        int           `envconfig:"LDAP_POOL_SIZE" default:"10"`
-	LDAPPoolTimeout     time.Duration `envconfig:"LDAP_POOL_TIMEOUT" default:"30s"`
-	LDAPConnTimeout     time.Duration `envconfig:"LDAP_CONN_TIMEOUT" default:"10s"`
-	LDAPMaxConnLifetime time.Duration `envconfig:"LDAP_MAX_CONN_LIFETIME" default:"30m"`
+	// LDAP configuration. LDAP_URL lists the providers (writable servers)
+	// in failover order; LDAP_READ_URLS lists read-only consumers that serve
+	// reads, falling back to the providers when none is available.
+	LDAPURLs         []string      `envconfig:"LDAP_URL" required:"true"`
+	LDAPReadURLs     []string      `envconfig:"LDAP_READ_URLS"`
+	LDAPBaseDN       string        `envconfig:"LDAP_BASE_DN" required:"true"`
+	LDAPBindDN       string        `envconfig:"LDAP_BIND_DN" required:"true"`
+	LDAPBindPassword string        `envconfig:"LDAP_BIND_PASSWORD"` // not needed with LDAP_SASL_EXTERNAL
+	LDAPConnTimeout  time.Duration `envconfig:"LDAP_CONN_TIMEOUT" default:"10s"`
+	LDAPPageSize     int           `envconfig:"LDAP_PAGE_SIZE" default:"500"`
+
+	// Connection pool. The pool keeps at least LDAP_POOL_MIN_SIZE
+	// connections, grows on demand up to LDAP_POOL_SIZE and waits up to
+	// LDAP_POOL_TIMEOUT when exhausted. Every LDAP_POOL_REAP_INTERVAL idle
+	// connections are health-checked; those older than
+	// LDAP_MAX_CONN_LIFETIME, or idle for LDAP_CONN_MAX_IDLE while above the
+	// minimum, are closed.
+	LDAPPoolSize         int           `envconfig:"LDAP_POOL_SIZE" default:"10"`
+	LDAPPoolMinSize      int           `envconfig:"LDAP_POOL_MIN_SIZE" default:"2"`
+	LDAPPoolTimeout      time.Duration `envconfig:"LDAP_POOL_TIMEOUT" default:"30s"`
+	LDAPMaxConnLifetime  time.Duration `envconfig:"LDAP_MAX_CONN_LIFETIME" default:"30m"`
+	LDAPConnMaxIdle      time.Duration `envconfig:"LDAP_CONN_MAX_IDLE" default:"5m"`
+	LDAPPoolReapInterval time.Duration `envconfig:"LDAP_POOL_REAP_INTERVAL" default:"30s"`
+
+	// Failover. A server is skipped for LDAP_BREAKER_COOLDOWN after
+	// LDAP_BREAKER_THRESHOLD consecutive connection failures. Reads stay on
+	// the provider for LDAP_READ_AFTER_WRITE_WINDOW after a write, so they
+	// are not served stale data by a consumer that has not caught up yet.
+	LDAPBreakerThreshold     int           `envconfig:"LDAP_BREAKER_THRESHOLD" default:"3"`
+	LDAPBreakerCooldown      time.Duration `envconfig:"LDAP_BREAKER_COOLDOWN" default:"30s"`
+	LDAPReadAfterWriteWindow time.Duration `envconfig:"LDAP_READ_AFTER_WRITE_WINDOW" default:"5s"`
+
+	// TLS. LDAP_TLS_MODE is none, starttls or ldaps; ldaps:// URLs always
+	// use TLS. LDAP_TLS_CA_FILE replaces the system roots. With a client
+	// certificate, LDAP_SASL_EXTERNAL binds as the certificate's identity
+	// instead of LDAP_BIND_DN. The files are checked every
+	// LDAP_TLS_RELOAD_INTERVAL and pooled connections are replaced once
+	// they change.
+	LDAPTLSMode               string        `envconfig:"LDAP_TLS_MODE" default:"none"`
+	LDAPTLSCAFile             string        `envconfig:"LDAP_TLS_CA_FILE"`
+	LDAPTLSCertFile           string        `envconfig:"LDAP_TLS_CERT_FILE"`
+	LDAPTLSKeyFile            string        `envconfig:"LDAP_TLS_KEY_FILE"`
+	LDAPTLSServerName         string        `envconfig:"LDAP_TLS_SERVER_NAME"`
+	LDAPTLSInsecureSkipVerify bool          `envconfig:"LDAP_TLS_INSECURE_SKIP_VERIFY" default:"false"`
+	LDAPTLSReloadInterval     time.Duration `envconfig:"LDAP_TLS_RELOAD_INTERVAL" default:"1m"`
+	LDAPSASLExternal          bool          `envconfig:"LDAP_SASL_EXTERNAL" default:"false"`
 
 	// Server configuration
 	Port        int    `envconfig:"PORT" default:"8080"`
@@ -30,15 +70,93 @@ type Config struct {
 	JWTExpiration time.Duration `envconfig:"JWT_EXPIRATION" default:"24h"`
 	// to redo as mtls or both
 
+	// Keycloak token validation. Tokens are verified against the realm JWKS;
+	// JWT_ISSUER defaults to the realm URL and must match the "iss" claim the
+	// browser-facing Keycloak puts in tokens, which may differ from KEYCLOAK_URL.
+	// JWT_AUDIENCE is unchecked by default: Keycloak access tokens carry
+	// aud "account" unless the client has an audience mapper, so set it only
+	// once the realm adds this service to the token's audience. Until then
+	// KEYCLOAK_CLIENT_IDS limits tokens to those issued to the listed clients
+	// ("azp" claim), so tokens of other realm clients are not accepted.
+	KeycloakURL   string        `envconfig:"KEYCLOAK_URL" default:"http://keycloak.auth-system.svc.cluster.local:8080"`
+	KeycloakRealm string        `envconfig:"KEYCLOAK_REALM" default:"devplatform"`
+	JWKSURL       string        `envconfig:"JWKS_URL"`
+	JWKSCacheTTL  time.Duration `envconfig:"JWKS_CACHE_TTL" default:"10m"`
+	JWTIssuer     string        `envconfig:"JWT_ISSUER"`
+	JWTAudience   string        `envconfig:"JWT_AUDIENCE"`
+	// Empty disables the authorized party check
+	KeycloakClientIDs []string `envconfig:"KEYCLOAK_CLIENT_IDS" default:"frontend-admin,ldap-manager-service"`
+
+	// Opt-in trust of Istio/oauth2-proxy identity headers (X-Forwarded-User,
+	// X-Forwarded-Email, X-Auth-Request-Roles). Only honoured for requests
+	// whose peer address is inside one of the listed CIDRs, e.g. the
+	// sidecar's 127.0.0.6/32 when the gateway validated the JWT already.
+	AuthTrustForwardedHeaders bool     `envconfig:"AUTH_TRUST_FORWARDED_HEADERS" default:"false"`
+	AuthTrustedProxyCIDRs     []string `envconfig:"AUTH_TRUSTED_PROXY_CIDRS"`
+
 	// CORS configuration
 	CORSOrigins []string `envconfig:"CORS_ORIGINS" default:"*"`
 
 	// Graceful shutdown timeout
 	ShutdownTimeout int `envconfig:"SHUTDOWN_TIMEOUT" default:"30"`
 
-	// Starting UID and GID for auto-increment
+	// Audit log directory. Each replica appends to its own file in it, so a
+	// shared (ReadWriteMany) volume gives every pod the full history.
+	AuditLogDir string `envconfig:"AUDIT_LOG_DIR" default:"./data/audit"`
+
+	// Password policy enforced on every password the manager writes.
+	// PASSWORD_HISTORY counts the current password; 0 or 1 disables reuse
+	// checks. PASSWORD_MAX_AGE of 0 disables expiry.
+	PasswordMinLength  int           `envconfig:"PASSWORD_MIN_LENGTH" default:"12"`
+	PasswordMinClasses int           `envconfig:"PASSWORD_MIN_CLASSES" default:"3"`
+	PasswordHistory    int           `envconfig:"PASSWORD_HISTORY" default:"5"`
+	PasswordMaxAge     time.Duration `envconfig:"PASSWORD_MAX_AGE" default:"2160h"`
+
+	// Account lifecycle. Deprovisioned users are deleted once the grace
+	// period has passed; the sweeper also disables users past expiresAt.
+	// A sweep interval of 0 disables the background sweeper. Disabled
+	// accounts are locked with pwdAccountLockedTime, which needs the
+	// ppolicy overlay; the controller's initializer enables it.
+	DeprovisionGracePeriod time.Duration `envconfig:"DEPROVISION_GRACE_PERIOD" default:"720h"`
+	LifecycleSweepInterval time.Duration `envconfig:"LIFECYCLE_SWEEP_INTERVAL" default:"15m"`
+
+	// Access requests. Users may ask for repository access for at most
+	// ACCESS_REQUEST_MAX_DURATION; the lifecycle sweeper revokes grants
+	// once they expire.
+	AccessRequestMaxDuration time.Duration `envconfig:"ACCESS_REQUEST_MAX_DURATION" default:"720h"`
+
+	// Backups. Every BACKUP_INTERVAL the directory is exported as an LDIF
+	// snapshot into BACKUP_DIR, next to a sha256sum-style checksum file,
+	// keeping the newest BACKUP_RETAIN snapshots. An interval of 0 leaves
+	// only on-demand backups. Replicas sharing the directory skip a backup
+	// when another one took a snapshot recently.
+	BackupDir      string        `envconfig:"BACKUP_DIR" default:"./data/backups"`
+	BackupInterval time.Duration `envconfig:"BACKUP_INTERVAL" default:"24h"`
+	BackupRetain   int           `envconfig:"BACKUP_RETAIN" default:"7"`
+
+	// Directory change events. EVENTS_SOURCE is "syncrepl" (RFC 4533
+	// refreshAndPersist, needs the syncprov overlay), "poll" (modifyTimestamp
+	// poller) or "auto", which falls back to polling when syncrepl is refused.
+	EventsEnabled      bool          `envconfig:"EVENTS_ENABLED" default:"true"`
+	EventsSource       string        `envconfig:"EVENTS_SOURCE" default:"auto"`
+	EventsPollInterval time.Duration `envconfig:"EVENTS_POLL_INTERVAL" default:"30s"`
+
+	// Outbound webhooks receiving every event, signed with HMAC-SHA256 of
+	// "<timestamp>.<body>" under WEBHOOK_SECRET
+	WebhookURLs       []string      `envconfig:"WEBHOOK_URLS"`
+	WebhookSecret     string        `envconfig:"WEBHOOK_SECRET"`
+	WebhookTimeout    time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	WebhookMaxRetries int           `envconfig:"WEBHOOK_MAX_RETRIES" default:"5"`
+
+	// Starting UID and GID for auto-increment. Only used to seed the
+	// allocator entry; afterwards numbers are reserved in LDAP itself.
 	StartingUID int `envconfig:"STARTING_UID" default:"10000"`
 	StartingGID int `envconfig:"STARTING_GID" default:"10000"`
+
+	// Custom user attributes: USER_ATTRIBUTES_FILE is a JSON array of
+	// attribute definitions, loaded into UserAttributes
+	UserAttributesFile string                       `envconfig:"USER_ATTRIBUTES_FILE"`
+	UserAttributes     []models.AttributeDefinition `ignored:"true"`
 }
 
 // Load reads configuration from environment variables
@@ -48,9 +166,61 @@ func Load() *Config {
 		panic(fmt.Sprintf("failed to load configuration: %v", err))
 	}
 
+	if cfg.UserAttributesFile != "" {
+		defs, err := LoadAttributeDefinitions(cfg.UserAttributesFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load configuration: %v", err))
+		}
+		cfg.UserAttributes = defs
+	}
+
 	return &cfg
 }
 
+// LoadAttributeDefinitions reads and validates custom user attribute
+// definitions from a JSON file
+func LoadAttributeDefinitions(path string) ([]models.AttributeDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user attributes: %w", err)
+	}
+
+	var defs []models.AttributeDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse user attributes %s: %w", path, err)
+	}
+	if err := models.ValidateAttributeDefinitions(defs); err != nil {
+		return nil, fmt.Errorf("invalid user attributes %s: %w", path, err)
+	}
+	return defs, nil
+}
+
+// UserAttribute returns the custom attribute definition for a field
+func (c *Config) UserAttribute(field string) (*models.AttributeDefinition, bool) {
+	for i := range c.UserAttributes {
+		if c.UserAttributes[i].Field == field {
+			return &c.UserAttributes[i], true
+		}
+	}
+	return nil, false
+}
+
+// JWKSEndpoint returns the JWKS URL used to verify token signatures
+func (c *Config) JWKSEndpoint() string {
+	if c.JWKSURL != "" {
+		return c.JWKSURL
+	}
+	return fmt.Sprintf("%s/realms/%s/protocol/openid-connect/certs", c.KeycloakURL, c.KeycloakRealm)
+}
+
+// JWTIssuerURL returns the expected "iss" claim of access tokens
+func (c *Config) JWTIssuerURL() string {
+	if c.JWTIssuer != "" {
+		return c.JWTIssuer
+	}
+	return fmt.Sprintf("%s/realms/%s", c.KeycloakURL, c.KeycloakRealm)
+}
+
 // UserDN returns the full DN for a user
 func (c *Config) UserDN(uid string) string {
 	return fmt.Sprintf("uid=%s,ou=users,%s", uid, c.LDAPBaseDN)
@@ -81,6 +251,21 @@ func (c *Config) GroupsDN() string {
 	return fmt.Sprintf("ou=groups,%s", c.LDAPBaseDN)
 }
 
+// AccessRequestsDN returns the base DN for all access requests
+func (c *Config) AccessRequestsDN() string {
+	return fmt.Sprintf("ou=accessRequests,%s", c.LDAPBaseDN)
+}
+
+// AccessRequestDN returns the full DN for an access request
+func (c *Config) AccessRequestDN(id string) string {
+	return fmt.Sprintf("cn=%s,%s", id, c.AccessRequestsDN())
+}
+
+// IDAllocatorDN returns the DN of the POSIX ID allocator entry
+func (c *Config) IDAllocatorDN() string {
+	return fmt.Sprintf("cn=%s,%s", IDAllocatorCN, c.LDAPBaseDN)
+}
+
 // IsDevelopment returns true if running in development mode
 func (c *Config) IsDevelopment() bool {
 	return c.Environment == "development"
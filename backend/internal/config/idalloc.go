@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// IDAllocatorCN is the CN of the directory entry holding the last issued
+// uidNumber/gidNumber, shared by every backend replica and the controller
+const IDAllocatorCN = "idAllocator"
+
+// ErrIDAllocatorMissing is returned by ReserveID when the allocator entry
+// does not exist yet
+var ErrIDAllocatorMissing = errors.New("ID allocator entry does not exist")
+
+// maxIDAllocRetries bounds how often a reservation is retried when another
+// writer (a second replica, the controller) wins the compare-and-swap
+const maxIDAllocRetries = 10
+
+// conflictBackoff and maxConflictBackoff bound the randomised wait before
+// a compare-and-swap is retried, which doubles with every conflict so
+// concurrent writers spread out instead of colliding again
+const (
+	conflictBackoff    = 2 * time.Millisecond
+	maxConflictBackoff = 100 * time.Millisecond
+)
+
+// ReserveID atomically increments the counter attr, uidNumber or
+// gidNumber, on the allocator entry at allocatorDN and returns the
+// reserved value.
+//
+// The increment is a single modify that deletes the value read and adds
+// the incremented one. LDAP applies both changes atomically, so if another
+// writer got there first the delete fails with noSuchAttribute and the
+// value is re-read and tried again after a jittered backoff. This keeps
+// POSIX IDs unique across restarts, replicas and the controller.
+func ReserveID(conn *ldap.Conn, allocatorDN, attr string) (int, error) {
+	for attempt := 0; attempt < maxIDAllocRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ConflictRetryDelay(attempt))
+		}
+
+		current, err := readIDAllocator(conn, allocatorDN, attr)
+		if err != nil {
+			return 0, err
+		}
+
+		next := current + 1
+		modifyRequest := ldap.NewModifyRequest(allocatorDN, nil)
+		modifyRequest.Delete(attr, []string{strconv.Itoa(current)})
+		modifyRequest.Add(attr, []string{strconv.Itoa(next)})
+
+		if err := conn.Modify(modifyRequest); err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+				continue
+			}
+			return 0, fmt.Errorf("failed to reserve %s: %w", attr, err)
+		}
+		return next, nil
+	}
+
+	return 0, fmt.Errorf("failed to reserve %s: too many concurrent allocations", attr)
+}
+
+// readIDAllocator returns the last issued value of a counter attribute
+func readIDAllocator(conn *ldap.Conn, allocatorDN, attr string) (int, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		allocatorDN,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{attr},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return 0, ErrIDAllocatorMissing
+		}
+		return 0, fmt.Errorf("failed to read ID allocator: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return 0, ErrIDAllocatorMissing
+	}
+
+	value, err := strconv.Atoi(result.Entries[0].GetAttributeValue(attr))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s on ID allocator: %w", attr, err)
+	}
+	return value, nil
+}
+
+// ConflictRetryDelay returns how long to wait before retrying a
+// compare-and-swap after its attempt-th conflict: a random delay in the
+// upper half of an exponential backoff
+func ConflictRetryDelay(attempt int) time.Duration {
+	backoff := conflictBackoff << (attempt - 1)
+	if backoff > maxConflictBackoff || backoff <= 0 {
+		backoff = maxConflictBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,15 @@
+package config
+
+import "testing"
+
+func TestConflictRetryDelay(t *testing.T) {
+	if delay := ConflictRetryDelay(1); delay < conflictBackoff/2 || delay > conflictBackoff {
+		t.Errorf("ConflictRetryDelay(1) = %s, want between %s and %s", delay, conflictBackoff/2, conflictBackoff)
+	}
+	// The backoff is capped, also where the shift overflows
+	for _, attempt := range []int{9, 40, 70} {
+		if delay := ConflictRetryDelay(attempt); delay < maxConflictBackoff/2 || delay > maxConflictBackoff {
+			t.Errorf("ConflictRetryDelay(%d) = %s, want between %s and %s", attempt, delay, maxConflictBackoff/2, maxConflictBackoff)
+		}
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/pkg/ldaptls"
 	"github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -20,9 +23,6 @@ const (
 	// ControllerName is the name of this controller
 	ControllerName = "openldap-controller"
 
-	// ManifestFile is the embedded OpenLDAP manifest
-	ManifestFile = "manifests/01-openldap.yaml"
-
 	// DefaultNamespace is the default namespace for OpenLDAP
 	DefaultNamespace = "dev-platform"
 
@@ -79,44 +79,77 @@ type ControllerConfig struct {
 	Namespace      string
 	LDAPTimeout    time.Duration
 	LDAPURL        string
+	LDAPTLS        ldaptls.Options
+	UserAttributes []models.AttributeDefinition
 	BaseDN         string
 	AdminDN        string
 	AdminPassword  string
 	ConfigPassword string
 	InitData       *InitDataSpec
+
+	// InitDataConfigMap names the ConfigMap in Namespace whose
+	// InitDataKey overrides InitData. Changes to it are synced into LDAP,
+	// deleting what it no longer declares if PruneInitData is set.
+	InitDataConfigMap string
+	PruneInitData     bool
+
+	// ReconcileResources keeps the directory in line with the
+	// LDAPDirectory, LDAPUser and LDAPGroup resources in Namespace,
+	// checking for drift every DirectoryResync
+	ReconcileResources bool
+	DirectoryResync    time.Duration
+
+	// ManifestResync is how often the embedded manifests are re-applied to
+	// correct drift, 0 to only apply them at startup. PruneManifests
+	// deletes the objects dropped from them. Once all are applied, changed
+	// StatefulSets and Deployments are waited on for up to RolloutTimeout;
+	// a rollout that does not complete in time is logged, not fatal.
+	ManifestResync time.Duration
+	PruneManifests bool
+	RolloutTimeout time.Duration
 }
 
 // NewController creates a new OpenLDAP controller
 func NewController(config *rest.Config, cfg *ControllerConfig, logger *logrus.Logger) (*Controller, error) {
 	if cfg == nil {
 		cfg = &ControllerConfig{
-			Namespace:     DefaultNamespace,
-			LDAPTimeout:   30 * time.Second,
-			LDAPURL:       DefaultLDAPURL,
-			BaseDN:        DefaultBaseDN,
-			AdminDN:       DefaultAdminDN,
-			AdminPassword: DefaultAdminPassword,
-			InitData:      DefaultInitData(),
+			Namespace:      DefaultNamespace,
+			LDAPTimeout:    30 * time.Second,
+			LDAPURL:        DefaultLDAPURL,
+			BaseDN:         DefaultBaseDN,
+			AdminDN:        DefaultAdminDN,
+			AdminPassword:  DefaultAdminPassword,
+			InitData:       DefaultInitData(),
+			ManifestResync: DefaultManifestResync,
+			RolloutTimeout: DefaultRolloutTimeout,
 		}
 	}
 
+	tlsSource, err := ldaptls.NewSource(cfg.LDAPTLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP TLS configuration: %w", err)
+	}
+
 	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
-	applier, err := NewManifestApplier(config, logger)
+	applier, err := NewManifestApplier(config, cfg.RolloutTimeout, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create manifest applier: %w", err)
 	}
 
+	initializer := NewLDAPInitializer(logger, cfg.LDAPTimeout, tlsSource)
+	initializer.userAttributes = cfg.UserAttributes
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := &Controller{
 		kubeClient:  kubeClient,
 		config:      config,
 		applier:     applier,
-		initializer: NewLDAPInitializer(logger, cfg.LDAPTimeout),
+		initializer: initializer,
 		logger:      logger,
 		namespace:   cfg.Namespace,
 		ctx:         ctx,
@@ -162,9 +195,21 @@ func (c *Controller) Run(cfg *ControllerConfig) error {
 
 	// Step 1: Apply OpenLDAP manifests
 	c.logger.Info("Applying OpenLDAP manifests")
-	if err := c.applier.ApplyManifestFile(c.ctx, ManifestFile); err != nil {
+	changes, err := c.syncManifests(c.ctx, cfg)
+	if err != nil {
 		return fmt.Errorf("failed to apply manifests: %w", err)
 	}
+	c.logger.WithField("changes", len(changes)).Info("OpenLDAP manifests applied")
+
+	// A rollout that takes longer than the timeout is no reason to restart:
+	// initialization below waits for the StatefulSet anyway
+	if err := c.applier.WaitForRollouts(c.ctx, changes); err != nil {
+		c.logger.WithError(err).Warn("OpenLDAP manifests have not rolled out yet")
+	}
+
+	if cfg.ManifestResync > 0 {
+		go c.runManifestResync(cfg)
+	}
 
 	// Step 2: Start informer to watch for StatefulSet readiness
 	go c.informer.Run(c.stopCh)
@@ -189,12 +234,20 @@ func (c *Controller) watchAndInitialize(cfg *ControllerConfig) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
+	var lastInitDataCheck time.Time
 	for {
 		select {
 		case <-c.stopCh:
 			return
 		case <-ticker.C:
 			if c.isInitialized() {
+				// Pick up changes to the init data
+				if time.Since(lastInitDataCheck) >= initDataCheckInterval {
+					lastInitDataCheck = time.Now()
+					if err := c.applyInitData(c.ctx, cfg); err != nil {
+						c.logger.WithError(err).Error("Failed to apply init data")
+					}
+				}
 				continue
 			}
 
@@ -222,43 +275,56 @@ func (c *Controller) watchAndInitialize(cfg *ControllerConfig) {
 			}
 
 			c.setInitialized(true)
+			lastInitDataCheck = time.Now()
 			c.logger.Info("OpenLDAP initialization complete")
+
+			if cfg.ReconcileResources {
+				go c.runDirectoryReconciler(cfg)
+			}
 		}
 	}
 }
 
-// runInitialization runs the LDAP initialization
-func (c *Controller) runInitialization(cfg *ControllerConfig) error {
-	ldapURL := cfg.LDAPURL
-	if ldapURL == "" {
-		ldapURL = DefaultLDAPURL
-	}
+// ldapTarget is the directory the controller initializes and syncs
+type ldapTarget struct {
+	url           string
+	baseDN        string
+	adminDN       string
+	adminPassword string
+}
 
-	baseDN := cfg.BaseDN
-	if baseDN == "" {
-		baseDN = DefaultBaseDN
+// ldapTarget returns the directory cfg points at, with defaults filled in
+func (cfg *ControllerConfig) ldapTarget() ldapTarget {
+	target := ldapTarget{
+		url:           cfg.LDAPURL,
+		baseDN:        cfg.BaseDN,
+		adminDN:       cfg.AdminDN,
+		adminPassword: cfg.AdminPassword,
 	}
-
-	adminDN := cfg.AdminDN
-	if adminDN == "" {
-		adminDN = DefaultAdminDN
+	if target.url == "" {
+		target.url = DefaultLDAPURL
 	}
-
-	adminPassword := cfg.AdminPassword
-	if adminPassword == "" {
-		adminPassword = DefaultAdminPassword
+	if target.baseDN == "" {
+		target.baseDN = DefaultBaseDN
 	}
-
-	initData := cfg.InitData
-	if initData == nil {
-		initData = DefaultInitData()
+	if target.adminDN == "" {
+		target.adminDN = DefaultAdminDN
 	}
+	if target.adminPassword == "" {
+		target.adminPassword = DefaultAdminPassword
+	}
+	return target
+}
+
+// runInitialization runs the LDAP initialization
+func (c *Controller) runInitialization(cfg *ControllerConfig) error {
+	target := cfg.ldapTarget()
 
 	// Wait for LDAP to be ready
 	ctx, cancel := context.WithTimeout(c.ctx, 2*time.Minute)
 	defer cancel()
 
-	if err := c.initializer.WaitForReady(ctx, ldapURL); err != nil {
+	if err := c.initializer.WaitForReady(ctx, target.url); err != nil {
 		return fmt.Errorf("LDAP not ready: %w", err)
 	}
 
@@ -268,7 +334,29 @@ func (c *Controller) runInitialization(cfg *ControllerConfig) error {
 	}
 
 	// Run initialization
-	return c.initializer.Initialize(ctx, ldapURL, adminDN, adminPassword, configPassword, baseDN, initData)
+	c.logger.WithFields(logrus.Fields{
+		"url":    target.url,
+		"baseDN": target.baseDN,
+	}).Info("Starting LDAP initialization")
+	c.initializer.Prepare(target.url, target.adminDN, target.adminPassword, configPassword, target.baseDN)
+	return c.applyInitData(ctx, cfg)
+}
+
+// runDirectoryReconciler reconciles the LDAPDirectory, LDAPUser and
+// LDAPGroup resources until the controller stops
+func (c *Controller) runDirectoryReconciler(cfg *ControllerConfig) {
+	dynamicClient, err := dynamic.NewForConfig(c.config)
+	if err != nil {
+		c.logger.WithError(err).Error("Failed to create dynamic client, not reconciling directory resources")
+		return
+	}
+
+	reconciler := NewDirectoryReconciler(c.kubeClient, dynamicClient, c.initializer, cfg.ldapTarget(), c.namespace, cfg.DirectoryResync, c.logger)
+	if !reconciler.Installed() {
+		c.logger.WithField("apiVersion", DirectoryGroupVersion.String()).Warn("LDAP directory resources are not installed, not reconciling them")
+		return
+	}
+	reconciler.Run(c.ctx)
 }
 
 // handleStatefulSetUpdate handles StatefulSet update events
@@ -306,5 +394,5 @@ func (c *Controller) setInitialized(v bool) {
 // Delete removes all OpenLDAP resources
 func (c *Controller) Delete() error {
 	c.logger.Info("Deleting OpenLDAP resources")
-	return c.applier.DeleteManifestFile(c.ctx, ManifestFile)
+	return c.applier.DeleteEmbeddedManifests(c.ctx)
 }
@@ -0,0 +1,554 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DirectoryGroupVersion is the API group and version of the LDAPDirectory,
+// LDAPUser and LDAPGroup resources
+var DirectoryGroupVersion = schema.GroupVersion{Group: "ldap.devplatform.io", Version: "v1alpha1"}
+
+var (
+	ldapDirectoriesResource = DirectoryGroupVersion.WithResource("ldapdirectories")
+	ldapUsersResource       = DirectoryGroupVersion.WithResource("ldapusers")
+	ldapGroupsResource      = DirectoryGroupVersion.WithResource("ldapgroups")
+)
+
+const (
+	// DefaultDirectoryResync is how often the directory is checked for
+	// drift when no resource changes
+	DefaultDirectoryResync = 5 * time.Minute
+
+	// DirectoryStateKey is the key in StateConfigMap listing the users,
+	// groups and departments the reconciler created, the only ones an
+	// LDAPDirectory with prune set deletes
+	DirectoryStateKey = "directory.json"
+
+	// ConditionReady reports whether a resource's entries match its spec
+	ConditionReady = "Ready"
+
+	// Reasons of the Ready condition
+	ReasonReconciled      = "Reconciled"
+	ReasonReconcileFailed = "ReconcileFailed"
+	ReasonInvalidSpec     = "InvalidSpec"
+)
+
+// ResourceStatus is the status of LDAPDirectory, LDAPUser and LDAPGroup
+// resources
+type ResourceStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+
+	// DN of the entry an LDAPUser or LDAPGroup manages
+	DN string `json:"dn,omitempty"`
+
+	// LastSyncTime is when an LDAPDirectory was last synced
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// DirectoryReconciler keeps the directory in line with the LDAPDirectory,
+// LDAPUser and LDAPGroup resources of a namespace. Every change to a
+// resource, and every resync interval, triggers a full sync, so drift
+// made directly in LDAP is reverted too.
+type DirectoryReconciler struct {
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	initializer   *LDAPInitializer
+	target        ldapTarget
+	namespace     string
+	resync        time.Duration
+	logger        *logrus.Logger
+
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	trigger   chan struct{}
+}
+
+// NewDirectoryReconciler creates a reconciler for the resources in
+// namespace, syncing them to the directory at target
+func NewDirectoryReconciler(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, initializer *LDAPInitializer, target ldapTarget, namespace string, resync time.Duration, logger *logrus.Logger) *DirectoryReconciler {
+	if resync == 0 {
+		resync = DefaultDirectoryResync
+	}
+	return &DirectoryReconciler{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		initializer:   initializer,
+		target:        target,
+		namespace:     namespace,
+		resync:        resync,
+		logger:        logger,
+		informers:     make(map[schema.GroupVersionResource]cache.SharedIndexInformer),
+		trigger:       make(chan struct{}, 1),
+	}
+}
+
+// Installed reports whether the API server serves the directory resources
+func (r *DirectoryReconciler) Installed() bool {
+	_, err := r.kubeClient.Discovery().ServerResourcesForGroupVersion(DirectoryGroupVersion.String())
+	return err == nil
+}
+
+// Run watches the resources and reconciles until ctx is done
+func (r *DirectoryReconciler) Run(ctx context.Context) {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(r.dynamicClient, r.resync, r.namespace, nil)
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { r.enqueue() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Status writes leave the generation alone and need no sync
+			oldU, ok1 := oldObj.(*unstructured.Unstructured)
+			newU, ok2 := newObj.(*unstructured.Unstructured)
+			if !ok1 || !ok2 || oldU.GetGeneration() != newU.GetGeneration() {
+				r.enqueue()
+			}
+		},
+		DeleteFunc: func(interface{}) { r.enqueue() },
+	}
+	for _, gvr := range []schema.GroupVersionResource{ldapDirectoriesResource, ldapUsersResource, ldapGroupsResource} {
+		informer := factory.ForResource(gvr).Informer()
+		informer.AddEventHandler(handler)
+		r.informers[gvr] = informer
+	}
+
+	factory.Start(ctx.Done())
+	for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			r.logger.WithField("resource", gvr.Resource).Error("Failed to sync directory resource cache")
+			return
+		}
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"namespace": r.namespace,
+		"resync":    r.resync,
+	}).Info("Reconciling LDAP directory resources")
+
+	ticker := time.NewTicker(r.resync)
+	defer ticker.Stop()
+
+	r.enqueue()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reconcile(ctx)
+		case <-r.trigger:
+			r.reconcile(ctx)
+		}
+	}
+}
+
+// enqueue requests a sync; requests arriving during one are coalesced
+func (r *DirectoryReconciler) enqueue() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// declaredResource is a resource together with the entry DNs it manages
+type declaredResource struct {
+	gvr     schema.GroupVersionResource
+	obj     *unstructured.Unstructured
+	dns     []string
+	invalid error
+}
+
+// reconcile syncs the directory once and reports the outcome on every
+// resource
+func (r *DirectoryReconciler) reconcile(ctx context.Context) {
+	spec, resources, prune := r.desiredState(ctx)
+	if len(resources) == 0 {
+		// Nothing is declared, the directory is not managed here
+		return
+	}
+
+	valid := true
+	for _, res := range resources {
+		if res.invalid != nil {
+			valid = false
+		}
+	}
+	if prune && !valid {
+		// Pruning now would delete the entries of the invalid resources
+		r.logger.Warn("Not pruning the LDAP directory while a directory resource is invalid")
+		prune = false
+	}
+
+	created, err := r.createdEntries(ctx)
+	if err != nil {
+		// Entries created without the record could never be pruned
+		r.logger.WithError(err).Error("Failed to read the created directory entries, sync postponed")
+		return
+	}
+	opts := SyncOptions{}
+	if prune {
+		opts.Remove = created.spec()
+	}
+
+	result, err := r.initializer.Sync(ctx, r.target.url, r.target.adminDN, r.target.adminPassword, r.target.baseDN, spec, opts)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to sync LDAP directory")
+	} else if err := r.recordCreated(ctx, created, created.update(spec, result, r.target.baseDN)); err != nil {
+		r.logger.WithError(err).Error("Failed to record the created directory entries")
+	}
+
+	for _, res := range resources {
+		r.report(ctx, res, result, err)
+	}
+}
+
+// desiredState builds the InitDataSpec the resources declare
+func (r *DirectoryReconciler) desiredState(ctx context.Context) (*InitDataSpec, []*declaredResource, bool) {
+	spec := &InitDataSpec{}
+	var resources []*declaredResource
+	prune := false
+	baseDN := r.target.baseDN
+
+	for _, obj := range r.list(ldapDirectoriesResource) {
+		res := &declaredResource{gvr: ldapDirectoriesResource, obj: obj}
+		resources = append(resources, res)
+
+		var dir LDAPDirectorySpec
+		if res.invalid = specOf(obj, &dir); res.invalid != nil {
+			continue
+		}
+		prune = prune || dir.Prune
+		spec.OrganizationalUnits = append(spec.OrganizationalUnits, dir.OrganizationalUnits...)
+		spec.Departments = append(spec.Departments, dir.Departments...)
+		for _, ou := range dir.OrganizationalUnits {
+			res.dns = append(res.dns, fmt.Sprintf("ou=%s,%s", ou.Name, baseDN))
+		}
+		for _, dept := range dir.Departments {
+			res.dns = append(res.dns, fmt.Sprintf("ou=%s,ou=departments,%s", dept.Name, baseDN))
+		}
+	}
+
+	for _, obj := range r.list(ldapUsersResource) {
+		res := &declaredResource{gvr: ldapUsersResource, obj: obj}
+		resources = append(resources, res)
+
+		user, err := r.userSpec(ctx, obj)
+		if res.invalid = err; err != nil {
+			continue
+		}
+		spec.Users = append(spec.Users, *user)
+		res.dns = []string{fmt.Sprintf("uid=%s,ou=users,%s", user.UID, baseDN)}
+	}
+
+	for _, obj := range r.list(ldapGroupsResource) {
+		res := &declaredResource{gvr: ldapGroupsResource, obj: obj}
+		resources = append(resources, res)
+
+		var group LDAPGroupSpec
+		if res.invalid = specOf(obj, &group); res.invalid != nil {
+			continue
+		}
+		if group.Name == "" {
+			group.Name = obj.GetName()
+		}
+		spec.Groups = append(spec.Groups, GroupSpec(group))
+		res.dns = []string{fmt.Sprintf("cn=%s,ou=groups,%s", group.Name, baseDN)}
+	}
+
+	return spec, resources, prune
+}
+
+// createdEntries names the users, groups and departments the reconciler
+// created, which pruning may delete once no resource declares them
+type createdEntries struct {
+	Users       []string `json:"users,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Departments []string `json:"departments,omitempty"`
+}
+
+// spec returns the entries as the InitDataSpec SyncOptions.Remove takes
+func (e *createdEntries) spec() *InitDataSpec {
+	spec := &InitDataSpec{}
+	for _, uid := range e.Users {
+		spec.Users = append(spec.Users, UserSpec{UID: uid})
+	}
+	for _, name := range e.Groups {
+		spec.Groups = append(spec.Groups, GroupSpec{Name: name})
+	}
+	for _, name := range e.Departments {
+		spec.Departments = append(spec.Departments, DepartmentSpec{Name: name})
+	}
+	return spec
+}
+
+// update returns e with the entries of spec that result created added and
+// those it deleted removed
+func (e *createdEntries) update(spec *InitDataSpec, result *SyncResult, baseDN string) *createdEntries {
+	var users, groups, departments []string
+	for _, user := range spec.Users {
+		users = append(users, user.UID)
+	}
+	for _, group := range spec.Groups {
+		groups = append(groups, group.Name)
+	}
+	for _, dept := range spec.Departments {
+		departments = append(departments, dept.Name)
+	}
+
+	return &createdEntries{
+		Users:       updateCreated(e.Users, users, result, "uid=%s,ou=users,"+baseDN),
+		Groups:      updateCreated(e.Groups, groups, result, "cn=%s,ou=groups,"+baseDN),
+		Departments: updateCreated(e.Departments, departments, result, "ou=%s,ou=departments,"+baseDN),
+	}
+}
+
+// updateCreated returns the names of created that result did not delete
+// plus those of declared it created, sorted; dnFormat turns a name into
+// its DN
+func updateCreated(created, declared []string, result *SyncResult, dnFormat string) []string {
+	var next []string
+	for _, name := range created {
+		if !containsFold(result.Deleted, fmt.Sprintf(dnFormat, name)) && !containsFold(next, name) {
+			next = append(next, name)
+		}
+	}
+	for _, name := range declared {
+		if containsFold(result.Created, fmt.Sprintf(dnFormat, name)) && !containsFold(next, name) {
+			next = append(next, name)
+		}
+	}
+	sort.Strings(next)
+	return next
+}
+
+// createdEntries reads the entries the reconciler created from
+// StateConfigMap
+func (r *DirectoryReconciler) createdEntries(ctx context.Context) (*createdEntries, error) {
+	cm, err := r.kubeClient.CoreV1().ConfigMaps(r.namespace).Get(ctx, StateConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &createdEntries{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ConfigMap %s: %w", StateConfigMap, err)
+	}
+
+	created := &createdEntries{}
+	if data := cm.Data[DirectoryStateKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), created); err != nil {
+			// Entries created before can no longer be pruned, but the
+			// directory still syncs
+			r.logger.WithError(err).Warn("Ignoring unreadable created directory entries")
+			return &createdEntries{}, nil
+		}
+	}
+	return created, nil
+}
+
+// recordCreated stores next in StateConfigMap unless it equals previous
+func (r *DirectoryReconciler) recordCreated(ctx context.Context, previous, next *createdEntries) error {
+	if reflect.DeepEqual(previous, next) {
+		return nil
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return fmt.Errorf("failed to encode created directory entries: %w", err)
+	}
+	return updateStateConfigMap(ctx, r.kubeClient, r.namespace, func(cm *corev1.ConfigMap) {
+		cm.Data[DirectoryStateKey] = string(data)
+	})
+}
+
+// list returns the cached resources of gvr that are not being deleted
+func (r *DirectoryReconciler) list(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+	var objs []*unstructured.Unstructured
+	for _, item := range r.informers[gvr].GetStore().List() {
+		obj, ok := item.(*unstructured.Unstructured)
+		if ok && obj.GetDeletionTimestamp() == nil {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// userSpec converts an LDAPUser into the UserSpec Sync takes, reading its
+// password from the referenced Secret
+func (r *DirectoryReconciler) userSpec(ctx context.Context, obj *unstructured.Unstructured) (*UserSpec, error) {
+	var spec LDAPUserSpec
+	if err := specOf(obj, &spec); err != nil {
+		return nil, err
+	}
+	if spec.UID == "" {
+		spec.UID = obj.GetName()
+	}
+
+	user := &UserSpec{
+		UID:          spec.UID,
+		CommonName:   spec.CommonName,
+		Surname:      spec.Surname,
+		GivenName:    spec.GivenName,
+		Email:        spec.Email,
+		Department:   spec.Department,
+		Repositories: spec.Repositories,
+	}
+	if ref := spec.PasswordSecretRef; ref != nil {
+		secret, err := r.kubeClient.CoreV1().Secrets(obj.GetNamespace()).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password secret %s: %w", ref.Name, err)
+		}
+		password, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("password secret %s has no key %s", ref.Name, ref.Key)
+		}
+		user.Password = string(password)
+	}
+	return user, nil
+}
+
+// specOf decodes the spec of obj into spec
+func specOf(obj *unstructured.Unstructured, spec interface{}) error {
+	content, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return fmt.Errorf("invalid spec: %w", err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, spec); err != nil {
+		return fmt.Errorf("invalid spec: %w", err)
+	}
+	return nil
+}
+
+// report sets the Ready condition of a resource from the sync result and
+// records events for what changed
+func (r *DirectoryReconciler) report(ctx context.Context, res *declaredResource, result *SyncResult, syncErr error) {
+	condition := metav1.Condition{
+		Type:    ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonReconciled,
+		Message: "Directory entries match the spec",
+	}
+	switch {
+	case res.invalid != nil:
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonInvalidSpec, res.invalid.Error()
+	case syncErr != nil:
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonReconcileFailed, syncErr.Error()
+	default:
+		for _, dn := range res.dns {
+			if err, ok := result.Failed[dn]; ok {
+				condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonReconcileFailed, err.Error()
+				break
+			}
+		}
+	}
+
+	if result != nil {
+		for _, dn := range res.dns {
+			if containsFold(result.Created, dn) {
+				r.event(ctx, res.obj, corev1.EventTypeNormal, "Created", "Created "+dn)
+			}
+			if containsFold(result.Updated, dn) {
+				r.event(ctx, res.obj, corev1.EventTypeNormal, "Updated", "Reverted drift on "+dn)
+			}
+		}
+		if res.gvr == ldapDirectoriesResource {
+			for _, dn := range result.Deleted {
+				r.event(ctx, res.obj, corev1.EventTypeNormal, "Pruned", "Deleted undeclared "+dn)
+			}
+		}
+	}
+
+	status := ResourceStatus{}
+	if current, ok, _ := unstructured.NestedMap(res.obj.Object, "status"); ok {
+		// An unreadable status is rewritten from scratch
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(current, &status)
+	}
+	previous := meta.FindStatusCondition(status.Conditions, ConditionReady)
+	if condition.Status == metav1.ConditionFalse && (previous == nil || previous.Status != metav1.ConditionFalse || previous.Message != condition.Message) {
+		r.event(ctx, res.obj, corev1.EventTypeWarning, condition.Reason, condition.Message)
+	}
+
+	next := status
+	next.Conditions = append([]metav1.Condition(nil), status.Conditions...)
+	condition.ObservedGeneration = res.obj.GetGeneration()
+	meta.SetStatusCondition(&next.Conditions, condition)
+	next.ObservedGeneration = res.obj.GetGeneration()
+	if res.gvr == ldapDirectoriesResource {
+		if syncErr == nil {
+			now := metav1.Now()
+			next.LastSyncTime = &now
+		}
+	} else if len(res.dns) > 0 {
+		next.DN = res.dns[0]
+	}
+	if reflect.DeepEqual(next, status) {
+		return
+	}
+
+	r.updateStatus(ctx, res, next)
+}
+
+// updateStatus writes status to the status subresource of a resource
+func (r *DirectoryReconciler) updateStatus(ctx context.Context, res *declaredResource, status ResourceStatus) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to encode directory resource status")
+		return
+	}
+
+	obj := res.obj.DeepCopy()
+	obj.Object["status"] = content
+	_, err = r.dynamicClient.Resource(res.gvr).Namespace(obj.GetNamespace()).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		// A conflicting write is picked up by the next sync
+		logger := r.logger.WithError(err).WithFields(logrus.Fields{
+			"resource": res.gvr.Resource,
+			"name":     obj.GetName(),
+		})
+		if errors.IsConflict(err) || errors.IsNotFound(err) {
+			logger.Debug("Directory resource changed, status update skipped")
+		} else {
+			logger.Warn("Failed to update directory resource status")
+		}
+	}
+}
+
+// event records a Kubernetes event on a resource
+func (r *DirectoryReconciler) event(ctx context.Context, obj *unstructured.Unstructured, eventType, reason, message string) {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: obj.GetName() + ".",
+			Namespace:    obj.GetNamespace(),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      obj.GetAPIVersion(),
+			Kind:            obj.GetKind(),
+			Name:            obj.GetName(),
+			Namespace:       obj.GetNamespace(),
+			UID:             obj.GetUID(),
+			ResourceVersion: obj.GetResourceVersion(),
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: ControllerName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := r.kubeClient.CoreV1().Events(obj.GetNamespace()).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		r.logger.WithError(err).WithField("reason", reason).Debug("Failed to record event")
+	}
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultInitDataConfigMap is the ConfigMap the init data is read from
+	DefaultInitDataConfigMap = "openldap-init-data"
+
+	// InitDataKey is the key of the InitDataSpec JSON in the init data
+	// ConfigMap, and of the applied one in StateConfigMap
+	InitDataKey = "initData.json"
+
+	// StateConfigMap records the init data and the manifests the
+	// controller applied
+	StateConfigMap = "openldap-controller-state"
+
+	// InitDataHashAnnotation holds the ComputeInitDataHash of the applied
+	// init data on StateConfigMap
+	InitDataHashAnnotation = "ldap.devplatform.io/init-data-hash"
+
+	// initDataCheckInterval is how often the init data is checked for
+	// changes once LDAP is initialized
+	initDataCheckInterval = 30 * time.Second
+)
+
+// applyInitData brings the directory in line with the init data unless
+// that exact init data was applied before, by this or an earlier
+// controller. The first time, missing entries are created and existing
+// ones left alone. After a change, the new init data is synced: attributes
+// and group members are updated, and with cfg.PruneInitData the users,
+// groups and departments only the previous init data declared are deleted.
+func (c *Controller) applyInitData(ctx context.Context, cfg *ControllerConfig) error {
+	initData, err := c.loadInitData(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	hash := ComputeInitDataHash(initData)
+
+	appliedHash, applied, err := c.appliedInitData(ctx)
+	if err != nil {
+		return err
+	}
+	if hash == appliedHash {
+		return nil
+	}
+
+	target := cfg.ldapTarget()
+	if appliedHash == "" {
+		if err := c.initializer.Populate(ctx, target.url, target.adminDN, target.adminPassword, target.baseDN, initData); err != nil {
+			return err
+		}
+	} else {
+		c.logger.WithFields(logrus.Fields{
+			"from": appliedHash,
+			"to":   hash,
+		}).Info("Init data changed, syncing LDAP")
+
+		opts := SyncOptions{}
+		if cfg.PruneInitData {
+			opts.Remove = applied
+		}
+		result, err := c.initializer.Sync(ctx, target.url, target.adminDN, target.adminPassword, target.baseDN, initData, opts)
+		if err != nil {
+			return err
+		}
+		// The hash is only recorded once everything applied, so failed
+		// entries are retried
+		if len(result.Failed) > 0 {
+			for dn, err := range result.Failed {
+				c.logger.WithError(err).WithField("dn", dn).Warn("Failed to apply init data")
+			}
+			return fmt.Errorf("failed to apply init data to %d entries", len(result.Failed))
+		}
+	}
+
+	if err := c.recordInitData(ctx, hash, initData); err != nil {
+		return err
+	}
+	c.logger.WithField("hash", hash).Info("Init data applied")
+	return nil
+}
+
+// loadInitData reads the init data from cfg.InitDataConfigMap, falling back
+// to cfg.InitData, or the defaults, when the ConfigMap does not exist
+func (c *Controller) loadInitData(ctx context.Context, cfg *ControllerConfig) (*InitDataSpec, error) {
+	fallback := cfg.InitData
+	if fallback == nil {
+		fallback = DefaultInitData()
+	}
+	if cfg.InitDataConfigMap == "" {
+		return fallback, nil
+	}
+
+	cm, err := c.kubeClient.CoreV1().ConfigMaps(c.namespace).Get(ctx, cfg.InitDataConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fallback, nil
+		}
+		return nil, fmt.Errorf("failed to read init data ConfigMap %s: %w", cfg.InitDataConfigMap, err)
+	}
+
+	initData, err := parseInitData(cm.Data[InitDataKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid init data in ConfigMap %s: %w", cfg.InitDataConfigMap, err)
+	}
+	if initData == nil {
+		return nil, fmt.Errorf("ConfigMap %s has no %s", cfg.InitDataConfigMap, InitDataKey)
+	}
+	return initData, nil
+}
+
+// appliedInitData returns the hash and the init data recorded in
+// StateConfigMap, or an empty hash if none was applied yet
+func (c *Controller) appliedInitData(ctx context.Context) (string, *InitDataSpec, error) {
+	cm, err := c.kubeClient.CoreV1().ConfigMaps(c.namespace).Get(ctx, StateConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil, nil
+		}
+		return "", nil, fmt.Errorf("failed to read ConfigMap %s: %w", StateConfigMap, err)
+	}
+
+	applied, err := parseInitData(cm.Data[InitDataKey])
+	if err != nil {
+		// Without the previous init data nothing can be removed, but
+		// attribute updates still apply
+		c.logger.WithError(err).Warn("Ignoring unreadable applied init data")
+		applied = nil
+	}
+	return cm.Annotations[InitDataHashAnnotation], applied, nil
+}
+
+// recordInitData stores the hash and, without passwords, the applied init
+// data in StateConfigMap
+func (c *Controller) recordInitData(ctx context.Context, hash string, initData *InitDataSpec) error {
+	data, err := json.Marshal(withoutPasswords(initData))
+	if err != nil {
+		return fmt.Errorf("failed to encode applied init data: %w", err)
+	}
+
+	return c.updateState(ctx, func(cm *corev1.ConfigMap) {
+		cm.Annotations[InitDataHashAnnotation] = hash
+		cm.Data[InitDataKey] = string(data)
+	})
+}
+
+// updateState applies update to StateConfigMap, creating it when missing.
+// update gets a ConfigMap whose annotations and data are not nil.
+func (c *Controller) updateState(ctx context.Context, update func(cm *corev1.ConfigMap)) error {
+	return updateStateConfigMap(ctx, c.kubeClient, c.namespace, update)
+}
+
+// updateStateConfigMap applies update to the StateConfigMap in namespace
+func updateStateConfigMap(ctx context.Context, kubeClient kubernetes.Interface, namespace string, update func(cm *corev1.ConfigMap)) error {
+	configMaps := kubeClient.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(ctx, StateConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        StateConfigMap,
+				Namespace:   namespace,
+				Labels:      map[string]string{"app.kubernetes.io/name": ControllerName},
+				Annotations: make(map[string]string),
+			},
+			Data: make(map[string]string),
+		}
+		update(cm)
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create ConfigMap %s: %w", StateConfigMap, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ConfigMap %s: %w", StateConfigMap, err)
+	}
+
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	update(cm)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update ConfigMap %s: %w", StateConfigMap, err)
+	}
+	return nil
+}
+
+// withoutPasswords returns a copy of initData with the user passwords
+// blanked, which must not end up in a ConfigMap
+func withoutPasswords(initData *InitDataSpec) *InitDataSpec {
+	stripped := *initData
+	stripped.Users = make([]UserSpec, len(initData.Users))
+	for i, user := range initData.Users {
+		user.Password = ""
+		stripped.Users[i] = user
+	}
+	return &stripped
+}
@@ -0,0 +1,20 @@
+package controller
+
+import "testing"
+
+func TestWithoutPasswords(t *testing.T) {
+	initData := &InitDataSpec{
+		Users: []UserSpec{{UID: "alice", Password: "secret"}},
+	}
+
+	stripped := withoutPasswords(initData)
+	if stripped.Users[0].Password != "" {
+		t.Errorf("withoutPasswords() kept password %q", stripped.Users[0].Password)
+	}
+	if stripped.Users[0].UID != "alice" {
+		t.Errorf("withoutPasswords() UID = %q, want alice", stripped.Users[0].UID)
+	}
+	if initData.Users[0].Password != "secret" {
+		t.Error("withoutPasswords() modified its input")
+	}
+}
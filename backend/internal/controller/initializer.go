@@ -6,8 +6,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/pkg/ldapschema"
+	"github.com/devplatform/ldap-manager/pkg/ldaptls"
 	ldap "github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -16,19 +20,35 @@ import (
 type LDAPInitializer struct {
 	logger  *logrus.Logger
 	timeout time.Duration
+	tls     *ldaptls.Source // nil for plaintext
+	// userAttributes are custom user attributes; those with an OID are
+	// registered alongside the devplatform attributes
+	userAttributes []models.AttributeDefinition
 }
 
-// NewLDAPInitializer creates a new LDAP initializer
-func NewLDAPInitializer(logger *logrus.Logger, timeout time.Duration) *LDAPInitializer {
+// NewLDAPInitializer creates a new LDAP initializer. Connections are
+// secured with tlsSource, which may be nil for plaintext.
+func NewLDAPInitializer(logger *logrus.Logger, timeout time.Duration, tlsSource *ldaptls.Source) *LDAPInitializer {
 	if timeout == 0 {
 		timeout = 30 * time.Second
 	}
 	return &LDAPInitializer{
 		logger:  logger,
 		timeout: timeout,
+		tls:     tlsSource,
 	}
 }
 
+// dial connects to ldapURL, reloading rotated certificates first
+func (i *LDAPInitializer) dial(ldapURL string) (*ldap.Conn, error) {
+	if i.tls != nil {
+		if _, err := i.tls.Reload(); err != nil {
+			i.logger.WithError(err).Warn("Failed to reload LDAP TLS certificates, keeping the current ones")
+		}
+	}
+	return i.tls.Dial(ldapURL)
+}
+
 // WaitForReady waits for LDAP to be ready
 func (i *LDAPInitializer) WaitForReady(ctx context.Context, ldapURL string) error {
 	ticker := time.NewTicker(2 * time.Second)
@@ -41,7 +61,7 @@ func (i *LDAPInitializer) WaitForReady(ctx context.Context, ldapURL string) erro
 		case <-ctx.Done():
 			return fmt.Errorf("timeout waiting for LDAP: %w", ctx.Err())
 		case <-ticker.C:
-			conn, err := ldap.DialURL(ldapURL)
+			conn, err := i.dial(ldapURL)
 			if err != nil {
 				i.logger.WithError(err).Debug("LDAP not ready yet")
 				continue
@@ -64,13 +84,37 @@ func (i *LDAPInitializer) Initialize(ctx context.Context, ldapURL, adminDN, admi
 		"baseDN": baseDN,
 	}).Info("Starting LDAP initialization")
 
-	// Step 0: Ensure custom schema (githubRepository attribute) is registered
-	if err := i.ensureCustomSchema(ldapURL, configPassword); err != nil {
-		i.logger.WithError(err).Warn("Failed to ensure custom schema (githubRepository may already exist)")
+	i.Prepare(ldapURL, adminDN, adminPassword, configPassword, baseDN)
+	return i.Populate(ctx, ldapURL, adminDN, adminPassword, baseDN, initData)
+}
+
+// Prepare migrates the directory to the current schema and enables the
+// overlays ldap-manager relies on. Failures are logged, not returned, since
+// the directory is usable without them.
+func (i *LDAPInitializer) Prepare(ldapURL, adminDN, adminPassword, configPassword, baseDN string) {
+	// Step 0: Apply pending schema and data migrations
+	if err := i.migrateSchema(ldapURL, adminDN, adminPassword, configPassword, baseDN); err != nil {
+		i.logger.WithError(err).Warn("Failed to migrate LDAP schema")
+	}
+
+	// Step 0b: Enable syncprov so ldap-manager can watch for changes with
+	// syncrepl; without it the manager falls back to polling
+	if err := i.ensureSyncProv(ldapURL, configPassword, baseDN); err != nil {
+		i.logger.WithError(err).Warn("Failed to enable the syncprov overlay, change events will be polled")
 	}
 
+	// Step 0c: Enable ppolicy so disabled accounts are locked for every LDAP
+	// client, not just this service
+	if err := i.ensurePPolicy(ldapURL, configPassword, baseDN); err != nil {
+		i.logger.WithError(err).Warn("Failed to enable the ppolicy overlay, disabling users will fail")
+	}
+}
+
+// Populate creates the entries of initData that do not exist yet. Existing
+// entries are left alone; Sync updates them.
+func (i *LDAPInitializer) Populate(ctx context.Context, ldapURL, adminDN, adminPassword, baseDN string, initData *InitDataSpec) error {
 	// Connect to LDAP
-	conn, err := ldap.DialURL(ldapURL)
+	conn, err := i.dial(ldapURL)
 	if err != nil {
 		return fmt.Errorf("failed to connect to LDAP: %w", err)
 	}
@@ -119,54 +163,171 @@ func (i *LDAPInitializer) Initialize(ctx context.Context, ldapURL, adminDN, admi
 	return nil
 }
 
-// ensureCustomSchema registers the githubRepository attribute in cn=config
-func (i *LDAPInitializer) ensureCustomSchema(ldapURL, configPassword string) error {
-	i.logger.Info("Ensuring custom LDAP schema (githubRepository attribute)")
+// schemaAttributeTypes returns the custom user attributes that need
+// registering alongside the devplatform attributes
+func (i *LDAPInitializer) schemaAttributeTypes() []ldapschema.AttributeType {
+	var types []ldapschema.AttributeType
+	for _, def := range i.userAttributes {
+		if def.OID != "" {
+			types = append(types, ldapschema.AttributeType{Name: def.LDAPAttribute, Definition: def.SchemaDefinition()})
+		}
+	}
+	return types
+}
+
+// migrateSchema brings the directory up to the latest devplatform schema
+// version, then registers the custom user attributes
+func (i *LDAPInitializer) migrateSchema(ldapURL, adminDN, adminPassword, configPassword, baseDN string) error {
+	i.logger.Info("Migrating LDAP schema")
 
-	conn, err := ldap.DialURL(ldapURL)
+	configConn, err := i.dial(ldapURL)
 	if err != nil {
 		return fmt.Errorf("failed to connect to LDAP for schema: %w", err)
 	}
-	defer conn.Close()
+	defer configConn.Close()
 
 	// Bind as config admin
+	if err := configConn.Bind("cn=admin,cn=config", configPassword); err != nil {
+		return fmt.Errorf("failed to bind as config admin: %w", err)
+	}
+
+	conn, err := i.dial(ldapURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(adminDN, adminPassword); err != nil {
+		return fmt.Errorf("failed to bind as admin: %w", err)
+	}
+
+	migrator := &ldapschema.Migrator{
+		Config: configConn,
+		Data:   conn,
+		BaseDN: baseDN,
+		Extra:  i.schemaAttributeTypes(),
+	}
+	result, err := migrator.Migrate()
+	for _, migration := range result.Applied {
+		i.logger.WithFields(logrus.Fields{
+			"version":     migration.Version,
+			"description": migration.Description,
+		}).Info("Applied schema migration")
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Extra) > 0 {
+		i.logger.WithField("added", result.Extra).Info("Registered custom user attributes")
+	}
+	if result.From == result.To {
+		i.logger.WithField("version", result.To).Info("LDAP schema already up to date")
+	}
+	return nil
+}
+
+// ensureSyncProv loads the syncprov module and adds the overlay to the
+// database holding baseDN, so RFC 4533 syncrepl searches are served
+func (i *LDAPInitializer) ensureSyncProv(ldapURL, configPassword, baseDN string) error {
+	return i.ensureOverlay(ldapURL, configPassword, baseDN, "syncprov", "olcSyncProvConfig", map[string][]string{
+		"olcSpCheckpoint": {"100 10"},
+		"olcSpSessionlog": {"100"},
+	})
+}
+
+// ensurePPolicy loads the ppolicy module and adds the overlay to the
+// database holding baseDN. Disabling a user sets pwdAccountLockedTime, which
+// only the overlay enforces for binds from other clients such as Keycloak.
+func (i *LDAPInitializer) ensurePPolicy(ldapURL, configPassword, baseDN string) error {
+	return i.ensureOverlay(ldapURL, configPassword, baseDN, "ppolicy", "olcPPolicyConfig", nil)
+}
+
+// ensureOverlay loads a module and adds its overlay, with the given
+// settings, to the database holding baseDN unless it is already there
+func (i *LDAPInitializer) ensureOverlay(ldapURL, configPassword, baseDN, overlay, objectClass string, settings map[string][]string) error {
+	conn, err := i.dial(ldapURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to LDAP for %s: %w", overlay, err)
+	}
+	defer conn.Close()
+
 	if err := conn.Bind("cn=admin,cn=config", configPassword); err != nil {
 		return fmt.Errorf("failed to bind as config admin: %w", err)
 	}
 
-	// Check if our custom schema already exists
-	searchReq := ldap.NewSearchRequest(
-		"cn=schema,cn=config",
+	// Load the module unless a module list already does
+	sr, err := conn.Search(ldap.NewSearchRequest(
+		"cn=config",
 		ldap.ScopeSingleLevel,
 		ldap.NeverDerefAliases, 0, 0, false,
-		"(cn=*devplatform)",
-		[]string{"cn"},
+		"(objectClass=olcModuleList)",
+		[]string{"olcModuleLoad"},
 		nil,
-	)
+	))
+	if err != nil {
+		return fmt.Errorf("failed to search module lists: %w", err)
+	}
+	if len(sr.Entries) == 0 {
+		return fmt.Errorf("no module list in cn=config")
+	}
+	loaded := false
+	for _, entry := range sr.Entries {
+		for _, module := range entry.GetAttributeValues("olcModuleLoad") {
+			if strings.Contains(module, overlay) {
+				loaded = true
+			}
+		}
+	}
+	if !loaded {
+		modifyReq := ldap.NewModifyRequest(sr.Entries[0].DN, nil)
+		modifyReq.Add("olcModuleLoad", []string{overlay})
+		if err := conn.Modify(modifyReq); err != nil {
+			return fmt.Errorf("failed to load %s module: %w", overlay, err)
+		}
+		i.logger.WithField("module", overlay).Info("Loaded module")
+	}
 
-	sr, err := conn.Search(searchReq)
+	// Find the database serving baseDN
+	sr, err = conn.Search(ldap.NewSearchRequest(
+		"cn=config",
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(olcSuffix=%s)", ldap.EscapeFilter(baseDN)),
+		[]string{"dn"},
+		nil,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to search databases: %w", err)
+	}
+	if len(sr.Entries) == 0 {
+		return fmt.Errorf("no database with suffix %s", baseDN)
+	}
+	databaseDN := sr.Entries[0].DN
+
+	sr, err = conn.Search(ldap.NewSearchRequest(
+		databaseDN,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(objectClass=%s)", objectClass),
+		[]string{"dn"},
+		nil,
+	))
 	if err == nil && len(sr.Entries) > 0 {
-		i.logger.Info("Custom schema already exists, skipping")
+		i.logger.WithField("overlay", overlay).Info("Overlay already configured, skipping")
 		return nil
 	}
 
-	// Add custom schema with githubRepository attribute
-	addReq := ldap.NewAddRequest("cn=devplatform,cn=schema,cn=config", nil)
-	addReq.Attribute("objectClass", []string{"olcSchemaConfig"})
-	addReq.Attribute("cn", []string{"devplatform"})
-	addReq.Attribute("olcAttributeTypes", []string{
-		"( 1.3.6.1.4.1.99999.1.1 NAME 'githubRepository' " +
-			"DESC 'Repository URL (GitHub/Gitea)' " +
-			"EQUALITY caseIgnoreMatch " +
-			"SUBSTR caseIgnoreSubstringsMatch " +
-			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
-	})
-
+	addReq := ldap.NewAddRequest("olcOverlay="+overlay+","+databaseDN, nil)
+	addReq.Attribute("objectClass", []string{"olcOverlayConfig", objectClass})
+	addReq.Attribute("olcOverlay", []string{overlay})
+	for attr, values := range settings {
+		addReq.Attribute(attr, values)
+	}
 	if err := conn.Add(addReq); err != nil {
-		return fmt.Errorf("failed to add custom schema: %w", err)
+		return fmt.Errorf("failed to add %s overlay: %w", overlay, err)
 	}
 
-	i.logger.Info("Custom schema registered (githubRepository attribute)")
+	i.logger.WithFields(logrus.Fields{"overlay": overlay, "database": databaseDN}).Info("Overlay enabled")
 	return nil
 }
 
@@ -219,6 +380,7 @@ func (i *LDAPInitializer) createDepartment(conn *ldap.Conn, baseDN string, dept
 
 	if len(dept.Repositories) > 0 {
 		addReq.Attribute("githubRepository", dept.Repositories)
+		addReq.Attribute("repositoryGrant", initialGrants(dept.Repositories))
 	}
 
 	err := conn.Add(addReq)
@@ -234,6 +396,12 @@ func (i *LDAPInitializer) createDepartment(conn *ldap.Conn, baseDN string, dept
 	return nil
 }
 
+// initialGrants returns the repositoryGrant values of repositories listed
+// in InitData, which get the default permission
+func initialGrants(repos []string) []string {
+	return models.GrantValues(models.ResolveGrants(models.RepositoryGrants(repos), nil))
+}
+
 // createUser creates a user under ou=users
 func (i *LDAPInitializer) createUser(conn *ldap.Conn, baseDN string, user UserSpec, uidNumber int) error {
 	dn := fmt.Sprintf("uid=%s,ou=users,%s", user.UID, baseDN)
@@ -258,7 +426,10 @@ func (i *LDAPInitializer) createUser(conn *ldap.Conn, baseDN string, user UserSp
 	addReq.Attribute("uidNumber", []string{fmt.Sprintf("%d", uidNumber)})
 	addReq.Attribute("gidNumber", []string{fmt.Sprintf("%d", uidNumber)}) // Same as uidNumber
 	addReq.Attribute("homeDirectory", []string{fmt.Sprintf("/home/%s", user.UID)})
-	addReq.Attribute("userPassword", []string{user.Password})
+	// Users declared without a password cannot bind until one is set
+	if user.Password != "" {
+		addReq.Attribute("userPassword", []string{user.Password})
+	}
 
 	if user.Department != "" {
 		addReq.Attribute("departmentNumber", []string{user.Department})
@@ -266,6 +437,7 @@ func (i *LDAPInitializer) createUser(conn *ldap.Conn, baseDN string, user UserSp
 
 	if len(user.Repositories) > 0 {
 		addReq.Attribute("githubRepository", user.Repositories)
+		addReq.Attribute("repositoryGrant", initialGrants(user.Repositories))
 	}
 
 	err := conn.Add(addReq)
@@ -298,23 +470,8 @@ func (i *LDAPInitializer) createGroup(conn *ldap.Conn, baseDN string, group Grou
 		addReq.Attribute("description", []string{group.Description})
 	}
 
-	// Groups need at least one member
-	members := group.Members
-	if len(members) == 0 {
-		// Add a placeholder member (will be the admin)
-		members = []string{fmt.Sprintf("cn=admin,%s", baseDN)}
-	}
-
-	memberDNs := make([]string, len(members))
-	for i, m := range members {
-		if m == fmt.Sprintf("cn=admin,%s", baseDN) || m[:3] == "cn=" || m[:4] == "uid=" {
-			memberDNs[i] = m
-		} else {
-			memberDNs[i] = fmt.Sprintf("uid=%s,ou=users,%s", m, baseDN)
-		}
-	}
-
-	addReq.Attribute("member", memberDNs)
+	members := memberDNs(baseDN, group.Members)
+	addReq.Attribute("member", members)
 
 	err := conn.Add(addReq)
 	if err != nil {
@@ -328,7 +485,7 @@ func (i *LDAPInitializer) createGroup(conn *ldap.Conn, baseDN string, group Grou
 	i.logger.WithFields(logrus.Fields{
 		"group":     group.Name,
 		"gidNumber": gidNumber,
-		"members":   len(memberDNs),
+		"members":   len(members),
 	}).Info("Created group")
 	return nil
 }
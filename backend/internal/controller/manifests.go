@@ -5,9 +5,13 @@ import (
 	"bytes"
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -15,7 +19,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
@@ -25,16 +28,86 @@ import (
 //go:embed manifests/*.yaml
 var manifestsFS embed.FS
 
-// ManifestApplier applies embedded Kubernetes manifests
+const (
+	// FieldManager owns the fields the controller applies with server-side
+	// apply
+	FieldManager = ControllerName
+
+	// DefaultRolloutTimeout is how long applying a StatefulSet or
+	// Deployment waits for its rollout
+	DefaultRolloutTimeout = 5 * time.Minute
+
+	// rolloutPollInterval is how often a rollout's progress is checked
+	rolloutPollInterval = 2 * time.Second
+
+	// maxDiffValueLength caps the values shown in a logged diff
+	maxDiffValueLength = 80
+)
+
+// Manifest change actions
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// ObjectRef identifies an applied object
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+func (r ObjectRef) String() string {
+	if r.Namespace == "" {
+		return r.Kind + "/" + r.Name
+	}
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// object returns a stub of the referenced object, enough to look it up
+func (r ObjectRef) object() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(r.APIVersion)
+	obj.SetKind(r.Kind)
+	obj.SetNamespace(r.Namespace)
+	obj.SetName(r.Name)
+	return obj
+}
+
+func refOf(obj *unstructured.Unstructured) ObjectRef {
+	return ObjectRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+// ManifestChange is a change applying manifests made to the cluster
+type ManifestChange struct {
+	Object ObjectRef
+	Action string
+	// Diff lists the changed fields of an updated object
+	Diff []string
+}
+
+// ManifestApplier applies embedded Kubernetes manifests with server-side
+// apply, so fields other managers set are left alone and fields changed
+// out of band are set back
 type ManifestApplier struct {
-	config        *rest.Config
-	dynamicClient dynamic.Interface
-	mapper        meta.RESTMapper
-	logger        *logrus.Logger
+	config         *rest.Config
+	dynamicClient  dynamic.Interface
+	mapper         meta.RESTMapper
+	rolloutTimeout time.Duration
+	logger         *logrus.Logger
 }
 
-// NewManifestApplier creates a new manifest applier
-func NewManifestApplier(config *rest.Config, logger *logrus.Logger) (*ManifestApplier, error) {
+// NewManifestApplier creates a new manifest applier. WaitForRollouts waits
+// for applied StatefulSets and Deployments for up to rolloutTimeout; 0 does
+// not wait.
+func NewManifestApplier(config *rest.Config, rolloutTimeout time.Duration, logger *logrus.Logger) (*ManifestApplier, error) {
 	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
@@ -53,13 +126,79 @@ func NewManifestApplier(config *rest.Config, logger *logrus.Logger) (*ManifestAp
 	mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
 
 	return &ManifestApplier{
-		config:        config,
-		dynamicClient: dynamicClient,
-		mapper:        mapper,
-		logger:        logger,
+		config:         config,
+		dynamicClient:  dynamicClient,
+		mapper:         mapper,
+		rolloutTimeout: rolloutTimeout,
+		logger:         logger,
 	}, nil
 }
 
+// ApplyEmbeddedManifests applies every object of the ListEmbeddedManifests
+// files, in file and document order, and returns what it applied and
+// changed. With prune, the objects of previous, the set applied before,
+// that the manifests no longer declare are deleted in reverse order.
+// Namespaces are never pruned, as that would delete everything in them.
+// Rollouts are not waited for, so a StatefulSet that does not become ready
+// never holds back the Services after it; see WaitForRollouts.
+func (m *ManifestApplier) ApplyEmbeddedManifests(ctx context.Context, previous []ObjectRef, prune bool) ([]ObjectRef, []ManifestChange, error) {
+	files, err := ListEmbeddedManifests()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	var applied []ObjectRef
+	var changes []ManifestChange
+	for _, file := range files {
+		objects, err := m.readManifestFile(file)
+		if err != nil {
+			return applied, changes, err
+		}
+		for _, obj := range objects {
+			change, err := m.applyObject(ctx, obj)
+			if err != nil {
+				return applied, changes, fmt.Errorf("failed to apply object %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+			}
+			applied = append(applied, refOf(obj))
+			if change != nil {
+				changes = append(changes, *change)
+			}
+		}
+	}
+
+	if !prune {
+		return applied, changes, nil
+	}
+	for _, ref := range staleObjects(previous, applied) {
+		if ref.Kind == "Namespace" {
+			m.logger.WithField("object", ref.String()).Warn("Namespace no longer in the manifests, not pruning it")
+			continue
+		}
+		if err := m.deleteObject(ctx, ref.object()); err != nil {
+			return applied, changes, fmt.Errorf("failed to prune %s: %w", ref, err)
+		}
+		changes = append(changes, ManifestChange{Object: ref, Action: ActionDeleted})
+	}
+	return applied, changes, nil
+}
+
+// staleObjects returns the objects of previous missing from current, in
+// reverse order so dependents go before what they depend on
+func staleObjects(previous, current []ObjectRef) []ObjectRef {
+	declared := make(map[ObjectRef]bool, len(current))
+	for _, ref := range current {
+		declared[ref] = true
+	}
+
+	var stale []ObjectRef
+	for i := len(previous) - 1; i >= 0; i-- {
+		if !declared[previous[i]] {
+			stale = append(stale, previous[i])
+		}
+	}
+	return stale
+}
+
 // ApplyManifestFile applies all resources from an embedded manifest file
 func (m *ManifestApplier) ApplyManifestFile(ctx context.Context, filename string) error {
 	data, err := manifestsFS.ReadFile(filename)
@@ -70,120 +209,411 @@ func (m *ManifestApplier) ApplyManifestFile(ctx context.Context, filename string
 	return m.ApplyManifests(ctx, data)
 }
 
-// ApplyManifests applies all resources from YAML data
+// ApplyManifests applies all resources from YAML data, then waits for the
+// rollouts it started
 func (m *ManifestApplier) ApplyManifests(ctx context.Context, yamlData []byte) error {
+	objects, err := m.decodeManifests(yamlData, false)
+	if err != nil {
+		return err
+	}
+
+	var changes []ManifestChange
+	for _, obj := range objects {
+		change, err := m.applyObject(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("failed to apply object %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+
+	return m.WaitForRollouts(ctx, changes)
+}
+
+// readManifestFile decodes the objects of an embedded manifest file
+func (m *ManifestApplier) readManifestFile(filename string) ([]*unstructured.Unstructured, error) {
+	data, err := manifestsFS.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest file %s: %w", filename, err)
+	}
+	// Strictly, since an object that is skipped would be pruned
+	return m.decodeManifests(data, true)
+}
+
+// decodeManifests decodes the documents of multi-document YAML data, in
+// order. Documents that fail to decode are an error when strict, and
+// otherwise skipped with a warning.
+func (m *ManifestApplier) decodeManifests(yamlData []byte, strict bool) ([]*unstructured.Unstructured, error) {
+	documents, err := splitDocuments(yamlData)
+	if err != nil {
+		return nil, err
+	}
+
 	decoder := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+	var objects []*unstructured.Unstructured
+	for i, doc := range documents {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decoder.Decode(doc, nil, obj); err != nil {
+			if strict {
+				return nil, fmt.Errorf("failed to decode document %d: %w", i, err)
+			}
+			m.logger.WithError(err).WithField("document", i).Warn("Failed to decode document, skipping")
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// splitDocuments splits YAML data at its --- separators, dropping empty
+// documents
+func splitDocuments(yamlData []byte) ([][]byte, error) {
 	reader := bufio.NewReader(bytes.NewReader(yamlData))
 
 	var documents [][]byte
 	var currentDoc bytes.Buffer
+	flush := func() {
+		if len(bytes.TrimSpace(currentDoc.Bytes())) > 0 {
+			documents = append(documents, append([]byte(nil), currentDoc.Bytes()...))
+		}
+		currentDoc.Reset()
+	}
 
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to read manifest: %w", err)
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
 		}
 
-		lineStr := strings.TrimSpace(string(line))
-
 		// Check for document separator
-		if lineStr == "---" {
-			if currentDoc.Len() > 0 {
-				documents = append(documents, currentDoc.Bytes())
-				currentDoc.Reset()
-			}
-		} else if lineStr != "" && !strings.HasPrefix(lineStr, "#") {
-			currentDoc.Write(line)
-		} else if len(line) > 0 {
+		if strings.TrimSpace(string(line)) == "---" {
+			flush()
+		} else {
 			currentDoc.Write(line)
 		}
 
 		if err == io.EOF {
-			if currentDoc.Len() > 0 {
-				documents = append(documents, currentDoc.Bytes())
-			}
-			break
+			flush()
+			return documents, nil
 		}
 	}
+}
 
-	// Apply each document
-	for i, doc := range documents {
-		if len(bytes.TrimSpace(doc)) == 0 {
-			continue
-		}
+// resourceFor returns the client for obj's resource, defaulting the
+// namespace of namespaced objects that have none
+func (m *ManifestApplier) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
 
-		obj := &unstructured.Unstructured{}
-		_, _, err := decoder.Decode(doc, nil, obj)
+	mapping, err := m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get REST mapping: %w", err)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return m.dynamicClient.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace("default")
+	}
+	return m.dynamicClient.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// applyObject server-side applies a single object. An existing object is
+// first applied as a dry run, and only applied for real when that changes
+// it, so unchanged objects are not written and the returned change lists
+// exactly the fields the apply sets back or updates. A nil change means
+// the object was already up to date.
+func (m *ManifestApplier) applyObject(ctx context.Context, obj *unstructured.Unstructured) (*ManifestChange, error) {
+	dr, err := m.resourceFor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := logrus.Fields{
+		"kind":      obj.GetKind(),
+		"name":      obj.GetName(),
+		"namespace": obj.GetNamespace(),
+	}
+	change := &ManifestChange{Object: refOf(obj), Action: ActionCreated}
+
+	existing, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to get existing object: %w", err)
+	default:
+		preview, err := dr.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+			FieldManager: FieldManager,
+			Force:        true,
+			DryRun:       []string{metav1.DryRunAll},
+		})
 		if err != nil {
-			m.logger.WithError(err).WithField("document", i).Warn("Failed to decode document, skipping")
-			continue
+			return nil, fmt.Errorf("failed to preview apply: %w", err)
 		}
-
-		if err := m.applyObject(ctx, obj); err != nil {
-			return fmt.Errorf("failed to apply object %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+		change.Action = ActionUpdated
+		change.Diff = diffObjects(existing.Object, preview.Object, obj.GetKind() == "Secret")
+		if len(change.Diff) == 0 {
+			m.logger.WithFields(fields).Debug("Resource up to date")
+			return nil, nil
 		}
 	}
 
+	if _, err := dr.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to apply: %w", err)
+	}
+
+	if change.Action == ActionCreated {
+		m.logger.WithFields(fields).Info("Created resource")
+	} else {
+		m.logger.WithFields(fields).WithField("diff", change.Diff).Info("Updated resource")
+	}
+	return change, nil
+}
+
+// WaitForRollouts waits until the StatefulSets and Deployments that
+// changes created or updated have rolled out. They roll out side by side,
+// so all share one rollout timeout. The error names every rollout that
+// did not complete in time.
+func (m *ManifestApplier) WaitForRollouts(ctx context.Context, changes []ManifestChange) error {
+	refs := rollouts(changes)
+	if m.rolloutTimeout <= 0 || len(refs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.rolloutTimeout)
+	defer cancel()
+
+	var incomplete []string
+	for _, ref := range refs {
+		if err := m.waitForRollout(ctx, ref); err != nil {
+			incomplete = append(incomplete, err.Error())
+		}
+	}
+	if len(incomplete) > 0 {
+		return fmt.Errorf("rollouts did not complete within %s: %s", m.rolloutTimeout, strings.Join(incomplete, "; "))
+	}
 	return nil
 }
 
-// applyObject applies a single unstructured object
-func (m *ManifestApplier) applyObject(ctx context.Context, obj *unstructured.Unstructured) error {
-	gvk := obj.GroupVersionKind()
+// rollouts returns the StatefulSets and Deployments changes created or
+// updated, in order
+func rollouts(changes []ManifestChange) []ObjectRef {
+	var refs []ObjectRef
+	for _, change := range changes {
+		if change.Action == ActionDeleted {
+			continue
+		}
+		if change.Object.Kind == "StatefulSet" || change.Object.Kind == "Deployment" {
+			refs = append(refs, change.Object)
+		}
+	}
+	return refs
+}
 
-	mapping, err := m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+// waitForRollout waits until a StatefulSet or Deployment has rolled out,
+// or ctx is done
+func (m *ManifestApplier) waitForRollout(ctx context.Context, ref ObjectRef) error {
+	obj := ref.object()
+	dr, err := m.resourceFor(obj)
 	if err != nil {
-		return fmt.Errorf("failed to get REST mapping: %w", err)
+		return fmt.Errorf("%s: %w", ref, err)
 	}
 
-	var dr dynamic.ResourceInterface
-	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
-		namespace := obj.GetNamespace()
-		if namespace == "" {
-			namespace = "default"
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	fields := logrus.Fields{
+		"kind":      obj.GetKind(),
+		"name":      obj.GetName(),
+		"namespace": obj.GetNamespace(),
+	}
+	m.logger.WithFields(fields).Info("Waiting for rollout")
+
+	waiting := ""
+	for {
+		live, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil && ctx.Err() == nil {
+			m.logger.WithError(err).WithFields(fields).Debug("Failed to read rollout status")
+		} else if err == nil {
+			var done bool
+			done, waiting = rolloutComplete(live)
+			if done {
+				m.logger.WithFields(fields).Info("Rollout complete")
+				return nil
+			}
+			m.logger.WithFields(fields).WithField("status", waiting).Debug("Rollout in progress")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %s", ref, waiting)
+		case <-ticker.C:
 		}
-		dr = m.dynamicClient.Resource(mapping.Resource).Namespace(namespace)
-	} else {
-		dr = m.dynamicClient.Resource(mapping.Resource)
 	}
+}
 
-	// Try to get existing object
-	existing, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create new object
-			_, err = dr.Create(ctx, obj, metav1.CreateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to create: %w", err)
+// rolloutComplete reports whether a StatefulSet or Deployment has rolled
+// out its current spec, and otherwise what it is waiting for. It follows
+// kubectl rollout status.
+func rolloutComplete(obj *unstructured.Unstructured) (bool, string) {
+	status := func(field string) int64 {
+		value, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+		return value
+	}
+
+	if observed := status("observedGeneration"); observed < obj.GetGeneration() {
+		return false, fmt.Sprintf("waiting for generation %d to be observed", obj.GetGeneration())
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		if updated := status("updatedReplicas"); updated < replicas {
+			return false, fmt.Sprintf("%d of %d replicas updated", updated, replicas)
+		}
+		if old := status("replicas") - status("updatedReplicas"); old > 0 {
+			return false, fmt.Sprintf("%d old replicas pending termination", old)
+		}
+		if available := status("availableReplicas"); available < replicas {
+			return false, fmt.Sprintf("%d of %d updated replicas available", available, replicas)
+		}
+		return true, ""
+
+	case "StatefulSet":
+		strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type")
+		if ready := status("readyReplicas"); ready < replicas {
+			return false, fmt.Sprintf("%d of %d replicas ready", ready, replicas)
+		}
+		// OnDelete pods are only updated when deleted by hand
+		if strategy == "OnDelete" {
+			return true, ""
+		}
+		partition, _, _ := unstructured.NestedInt64(obj.Object, "spec", "updateStrategy", "rollingUpdate", "partition")
+		if partition > 0 {
+			if updated := status("updatedReplicas"); updated < replicas-partition {
+				return false, fmt.Sprintf("%d of %d replicas above the partition updated", updated, replicas-partition)
 			}
-			m.logger.WithFields(logrus.Fields{
-				"kind":      gvk.Kind,
-				"name":      obj.GetName(),
-				"namespace": obj.GetNamespace(),
-			}).Info("Created resource")
-			return nil
+			return true, ""
+		}
+		current, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		update, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+		if current != update {
+			return false, fmt.Sprintf("%d of %d replicas updated to revision %s", status("updatedReplicas"), replicas, update)
 		}
-		return fmt.Errorf("failed to get existing object: %w", err)
+		return true, ""
 	}
+	return true, ""
+}
 
-	// Update existing object using server-side apply
-	obj.SetResourceVersion(existing.GetResourceVersion())
-	data, err := obj.MarshalJSON()
-	if err != nil {
-		return fmt.Errorf("failed to marshal object: %w", err)
+// diffObjects lists the fields that differ between the live object and
+// the result of applying to it, as "path: old -> new". Server-managed
+// bookkeeping is ignored. With secret, values under data and stringData
+// are not shown.
+func diffObjects(live, applied map[string]interface{}, secret bool) []string {
+	var diff []string
+	diffValues(&diff, "", withoutBookkeeping(live), withoutBookkeeping(applied), secret)
+	return diff
+}
+
+func diffValues(diff *[]string, path string, old, new interface{}, secret bool) {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make(map[string]bool)
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			oldValue, inOld := oldMap[key]
+			newValue, inNew := newMap[key]
+			switch {
+			case !inOld:
+				*diff = append(*diff, fmt.Sprintf("%s: added %s", child, diffValue(child, newValue, secret)))
+			case !inNew:
+				*diff = append(*diff, fmt.Sprintf("%s: removed", child))
+			default:
+				diffValues(diff, child, oldValue, newValue, secret)
+			}
+		}
+		return
 	}
 
-	_, err = dr.Patch(ctx, obj.GetName(), types.MergePatchType, data, metav1.PatchOptions{})
+	if !reflect.DeepEqual(old, new) {
+		*diff = append(*diff, fmt.Sprintf("%s: %s -> %s", path, diffValue(path, old, secret), diffValue(path, new, secret)))
+	}
+}
+
+// diffValue renders a value for a diff, shortened, or hidden for the data
+// of a Secret
+func diffValue(path string, value interface{}, secret bool) string {
+	if secret && (strings.HasPrefix(path, "data.") || strings.HasPrefix(path, "stringData.")) {
+		return "(redacted)"
+	}
+	data, err := json.Marshal(value)
 	if err != nil {
-		return fmt.Errorf("failed to patch: %w", err)
+		return fmt.Sprintf("%v", value)
 	}
+	if len(data) > maxDiffValueLength {
+		return string(data[:maxDiffValueLength]) + "..."
+	}
+	return string(data)
+}
 
-	m.logger.WithFields(logrus.Fields{
-		"kind":      gvk.Kind,
-		"name":      obj.GetName(),
-		"namespace": obj.GetNamespace(),
-	}).Info("Updated resource")
+// withoutBookkeeping returns a copy of obj without the status and the
+// metadata the API server maintains, which every write changes
+func withoutBookkeeping(obj map[string]interface{}) map[string]interface{} {
+	clean := make(map[string]interface{}, len(obj))
+	for key, value := range obj {
+		if key != "status" {
+			clean[key] = value
+		}
+	}
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		cleanMetadata := make(map[string]interface{}, len(metadata))
+		for key, value := range metadata {
+			switch key {
+			case "managedFields", "resourceVersion", "generation":
+			default:
+				cleanMetadata[key] = value
+			}
+		}
+		clean["metadata"] = cleanMetadata
+	}
+	return clean
+}
 
+// DeleteEmbeddedManifests deletes the objects of every embedded manifest
+// file, last file first
+func (m *ManifestApplier) DeleteEmbeddedManifests(ctx context.Context) error {
+	files, err := ListEmbeddedManifests()
+	if err != nil {
+		return fmt.Errorf("failed to list manifests: %w", err)
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if err := m.DeleteManifestFile(ctx, files[i]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -199,49 +629,14 @@ func (m *ManifestApplier) DeleteManifestFile(ctx context.Context, filename strin
 
 // DeleteManifests deletes all resources from YAML data
 func (m *ManifestApplier) DeleteManifests(ctx context.Context, yamlData []byte) error {
-	decoder := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
-	reader := bufio.NewReader(bytes.NewReader(yamlData))
-
-	var documents [][]byte
-	var currentDoc bytes.Buffer
-
-	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to read manifest: %w", err)
-		}
-
-		lineStr := strings.TrimSpace(string(line))
-		if lineStr == "---" {
-			if currentDoc.Len() > 0 {
-				documents = append(documents, currentDoc.Bytes())
-				currentDoc.Reset()
-			}
-		} else {
-			currentDoc.Write(line)
-		}
-
-		if err == io.EOF {
-			if currentDoc.Len() > 0 {
-				documents = append(documents, currentDoc.Bytes())
-			}
-			break
-		}
+	objects, err := m.decodeManifests(yamlData, false)
+	if err != nil {
+		return err
 	}
 
 	// Delete in reverse order
-	for i := len(documents) - 1; i >= 0; i-- {
-		doc := documents[i]
-		if len(bytes.TrimSpace(doc)) == 0 {
-			continue
-		}
-
-		obj := &unstructured.Unstructured{}
-		_, _, err := decoder.Decode(doc, nil, obj)
-		if err != nil {
-			continue
-		}
-
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
 		if err := m.deleteObject(ctx, obj); err != nil {
 			m.logger.WithError(err).WithFields(logrus.Fields{
 				"kind": obj.GetKind(),
@@ -255,22 +650,9 @@ func (m *ManifestApplier) DeleteManifests(ctx context.Context, yamlData []byte)
 
 // deleteObject deletes a single unstructured object
 func (m *ManifestApplier) deleteObject(ctx context.Context, obj *unstructured.Unstructured) error {
-	gvk := obj.GroupVersionKind()
-
-	mapping, err := m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	dr, err := m.resourceFor(obj)
 	if err != nil {
-		return fmt.Errorf("failed to get REST mapping: %w", err)
-	}
-
-	var dr dynamic.ResourceInterface
-	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
-		namespace := obj.GetNamespace()
-		if namespace == "" {
-			namespace = "default"
-		}
-		dr = m.dynamicClient.Resource(mapping.Resource).Namespace(namespace)
-	} else {
-		dr = m.dynamicClient.Resource(mapping.Resource)
+		return err
 	}
 
 	err = dr.Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
@@ -279,7 +661,7 @@ func (m *ManifestApplier) deleteObject(ctx context.Context, obj *unstructured.Un
 	}
 
 	m.logger.WithFields(logrus.Fields{
-		"kind":      gvk.Kind,
+		"kind":      obj.GetKind(),
 		"name":      obj.GetName(),
 		"namespace": obj.GetNamespace(),
 	}).Info("Deleted resource")
@@ -287,7 +669,8 @@ func (m *ManifestApplier) deleteObject(ctx context.Context, obj *unstructured.Un
 	return nil
 }
 
-// ListEmbeddedManifests returns all embedded manifest files
+// ListEmbeddedManifests returns all embedded manifest files, in the order
+// they are applied
 func ListEmbeddedManifests() ([]string, error) {
 	entries, err := manifestsFS.ReadDir("manifests")
 	if err != nil {
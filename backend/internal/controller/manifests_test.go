@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	data := []byte("---\n# Namespace\nkind: Namespace\n---\n\n---\nkind: Secret\ndata:\n  a: b\n")
+	documents, err := splitDocuments(data)
+	if err != nil {
+		t.Fatalf("splitDocuments() error = %v", err)
+	}
+	want := []string{"# Namespace\nkind: Namespace\n", "kind: Secret\ndata:\n  a: b\n"}
+	if len(documents) != len(want) {
+		t.Fatalf("splitDocuments() returned %d documents, want %d", len(documents), len(want))
+	}
+	for i, doc := range documents {
+		if string(doc) != want[i] {
+			t.Errorf("document %d = %q, want %q", i, doc, want[i])
+		}
+	}
+}
+
+func TestEmbeddedManifestsDecode(t *testing.T) {
+	files, err := ListEmbeddedManifests()
+	if err != nil || len(files) == 0 {
+		t.Fatalf("ListEmbeddedManifests() = %v, %v", files, err)
+	}
+	m := &ManifestApplier{}
+	for _, file := range files {
+		objects, err := m.readManifestFile(file)
+		if err != nil {
+			t.Errorf("readManifestFile(%s) error = %v", file, err)
+			continue
+		}
+		for _, obj := range objects {
+			if obj.GetKind() == "" || obj.GetName() == "" {
+				t.Errorf("%s has an object without kind or name: %v", file, obj.Object)
+			}
+		}
+	}
+}
+
+func TestStaleObjects(t *testing.T) {
+	namespace := ObjectRef{APIVersion: "v1", Kind: "Namespace", Name: "dev-platform"}
+	secret := ObjectRef{APIVersion: "v1", Kind: "Secret", Namespace: "dev-platform", Name: "openldap-secret"}
+	config := ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "dev-platform", Name: "openldap-config"}
+	statefulSet := ObjectRef{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "dev-platform", Name: "openldap"}
+
+	got := staleObjects([]ObjectRef{namespace, secret, config, statefulSet}, []ObjectRef{namespace, config})
+	want := []ObjectRef{statefulSet, secret}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("staleObjects() = %v, want %v", got, want)
+	}
+
+	if got := staleObjects(nil, []ObjectRef{namespace}); got != nil {
+		t.Errorf("staleObjects() without a previous set = %v, want nil", got)
+	}
+}
+
+func TestRollouts(t *testing.T) {
+	statefulSet := ObjectRef{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "dev-platform", Name: "openldap"}
+	deployment := ObjectRef{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "dev-platform", Name: "phpldapadmin"}
+	service := ObjectRef{APIVersion: "v1", Kind: "Service", Namespace: "dev-platform", Name: "openldap"}
+	pruned := ObjectRef{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "dev-platform", Name: "retired"}
+
+	got := rollouts([]ManifestChange{
+		{Object: statefulSet, Action: ActionUpdated},
+		{Object: service, Action: ActionCreated},
+		{Object: deployment, Action: ActionCreated},
+		{Object: pruned, Action: ActionDeleted},
+	})
+	want := []ObjectRef{statefulSet, deployment}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rollouts() = %v, want %v", got, want)
+	}
+}
+
+func TestRolloutComplete(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  map[string]interface{}
+		want bool
+	}{
+		{
+			name: "generation not observed",
+			obj: map[string]interface{}{
+				"kind":     "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(1)},
+				"status":   map[string]interface{}{"observedGeneration": int64(1), "updatedReplicas": int64(1), "replicas": int64(1), "availableReplicas": int64(1)},
+			},
+			want: false,
+		},
+		{
+			name: "deployment with old replicas",
+			obj: map[string]interface{}{
+				"kind":     "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(2)},
+				"status":   map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(2), "replicas": int64(3), "availableReplicas": int64(2)},
+			},
+			want: false,
+		},
+		{
+			name: "deployment rolled out",
+			obj: map[string]interface{}{
+				"kind":     "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(2)},
+				"status":   map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(2), "replicas": int64(2), "availableReplicas": int64(2)},
+			},
+			want: true,
+		},
+		{
+			name: "statefulset on an old revision",
+			obj: map[string]interface{}{
+				"kind":     "StatefulSet",
+				"metadata": map[string]interface{}{"generation": int64(3)},
+				"spec":     map[string]interface{}{"replicas": int64(1)},
+				"status": map[string]interface{}{"observedGeneration": int64(3), "readyReplicas": int64(1),
+					"currentRevision": "openldap-1", "updateRevision": "openldap-2"},
+			},
+			want: false,
+		},
+		{
+			name: "statefulset not ready",
+			obj: map[string]interface{}{
+				"kind":     "StatefulSet",
+				"metadata": map[string]interface{}{"generation": int64(1)},
+				"spec":     map[string]interface{}{},
+				"status":   map[string]interface{}{"observedGeneration": int64(1), "currentRevision": "openldap-1", "updateRevision": "openldap-1"},
+			},
+			want: false,
+		},
+		{
+			name: "statefulset rolled out",
+			obj: map[string]interface{}{
+				"kind":     "StatefulSet",
+				"metadata": map[string]interface{}{"generation": int64(3)},
+				"spec":     map[string]interface{}{"replicas": int64(1)},
+				"status": map[string]interface{}{"observedGeneration": int64(3), "readyReplicas": int64(1),
+					"currentRevision": "openldap-2", "updateRevision": "openldap-2"},
+			},
+			want: true,
+		},
+		{
+			name: "statefulset updated above its partition",
+			obj: map[string]interface{}{
+				"kind":     "StatefulSet",
+				"metadata": map[string]interface{}{"generation": int64(3)},
+				"spec": map[string]interface{}{"replicas": int64(3), "updateStrategy": map[string]interface{}{
+					"type": "RollingUpdate", "rollingUpdate": map[string]interface{}{"partition": int64(2)}}},
+				"status": map[string]interface{}{"observedGeneration": int64(3), "readyReplicas": int64(3), "updatedReplicas": int64(1),
+					"currentRevision": "openldap-1", "updateRevision": "openldap-2"},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, waiting := rolloutComplete(&unstructured.Unstructured{Object: tt.obj})
+			if done != tt.want {
+				t.Errorf("rolloutComplete() = %v (%s), want %v", done, waiting, tt.want)
+			}
+			if !done && waiting == "" {
+				t.Error("rolloutComplete() gave no reason for waiting")
+			}
+		})
+	}
+}
+
+func TestDiffObjects(t *testing.T) {
+	live := map[string]interface{}{
+		"kind": "Secret",
+		"metadata": map[string]interface{}{
+			"name":            "openldap-secret",
+			"resourceVersion": "41",
+			"labels":          map[string]interface{}{"app": "openldap", "team": "platform"},
+		},
+		"data":   map[string]interface{}{"LDAP_ADMIN_PASSWORD": "b2xk"},
+		"status": map[string]interface{}{"phase": "old"},
+	}
+	applied := map[string]interface{}{
+		"kind": "Secret",
+		"metadata": map[string]interface{}{
+			"name":            "openldap-secret",
+			"resourceVersion": "42",
+			"labels":          map[string]interface{}{"app": "openldap", "tier": "ldap"},
+		},
+		"data":   map[string]interface{}{"LDAP_ADMIN_PASSWORD": "bmV3"},
+		"status": map[string]interface{}{"phase": "new"},
+	}
+
+	got := diffObjects(live, applied, true)
+	want := []string{
+		`data.LDAP_ADMIN_PASSWORD: (redacted) -> (redacted)`,
+		`metadata.labels.team: removed`,
+		`metadata.labels.tier: added "ldap"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffObjects() = %q, want %q", got, want)
+	}
+
+	if got := diffObjects(live, live, false); len(got) != 0 {
+		t.Errorf("diffObjects() of equal objects = %q, want none", got)
+	}
+}
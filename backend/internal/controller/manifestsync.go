@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ManifestsKey is the key of the objects applied from the embedded
+	// manifests in StateConfigMap, which pruning compares against
+	ManifestsKey = "manifests.json"
+
+	// DefaultManifestResync is how often the applied manifests are checked
+	// for drift
+	DefaultManifestResync = 5 * time.Minute
+)
+
+// syncManifests applies the embedded manifests and records the objects
+// applied. Without cfg.PruneManifests, objects dropped from the manifests
+// stay recorded, so enabling pruning later still removes them.
+func (c *Controller) syncManifests(ctx context.Context, cfg *ControllerConfig) ([]ManifestChange, error) {
+	previous, err := c.appliedManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, changes, err := c.applier.ApplyEmbeddedManifests(ctx, previous, cfg.PruneManifests)
+	if err != nil {
+		return changes, err
+	}
+	if !cfg.PruneManifests {
+		applied = append(applied, reverseRefs(staleObjects(previous, applied))...)
+	}
+
+	if reflect.DeepEqual(applied, previous) {
+		return changes, nil
+	}
+	if err := c.recordManifests(ctx, applied); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// runManifestResync re-applies the manifests every cfg.ManifestResync
+// until the controller stops, setting back changes made to the applied
+// objects out of band and recreating deleted ones
+func (c *Controller) runManifestResync(cfg *ControllerConfig) {
+	ticker := time.NewTicker(cfg.ManifestResync)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		changes, err := c.syncManifests(c.ctx, cfg)
+		for _, change := range changes {
+			c.logger.WithFields(logrus.Fields{
+				"object": change.Object.String(),
+				"action": change.Action,
+				"diff":   change.Diff,
+			}).Warn("Corrected drift from the manifests")
+		}
+		if err != nil {
+			c.logger.WithError(err).Error("Failed to resync manifests")
+			continue
+		}
+		if err := c.applier.WaitForRollouts(c.ctx, changes); err != nil {
+			c.logger.WithError(err).Warn("Corrected manifests have not rolled out yet")
+		}
+	}
+}
+
+// appliedManifests returns the objects recorded in StateConfigMap, or nil
+// if none were recorded yet
+func (c *Controller) appliedManifests(ctx context.Context) ([]ObjectRef, error) {
+	cm, err := c.kubeClient.CoreV1().ConfigMaps(c.namespace).Get(ctx, StateConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read ConfigMap %s: %w", StateConfigMap, err)
+	}
+
+	data, ok := cm.Data[ManifestsKey]
+	if !ok {
+		return nil, nil
+	}
+	var refs []ObjectRef
+	if err := json.Unmarshal([]byte(data), &refs); err != nil {
+		// Without the previous set nothing can be pruned, but applying
+		// still works
+		c.logger.WithError(err).Warn("Ignoring unreadable applied manifests")
+		return nil, nil
+	}
+	return refs, nil
+}
+
+// recordManifests stores the applied objects in StateConfigMap
+func (c *Controller) recordManifests(ctx context.Context, refs []ObjectRef) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return fmt.Errorf("failed to encode applied manifests: %w", err)
+	}
+	return c.updateState(ctx, func(cm *corev1.ConfigMap) {
+		cm.Data[ManifestsKey] = string(data)
+	})
+}
+
+// reverseRefs returns refs in reverse order
+func reverseRefs(refs []ObjectRef) []ObjectRef {
+	reversed := make([]ObjectRef, len(refs))
+	for i, ref := range refs {
+		reversed[len(refs)-1-i] = ref
+	}
+	return reversed
+}
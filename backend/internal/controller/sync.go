@@ -0,0 +1,542 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// syncPageSize is the page size of the searches reading the live directory
+const syncPageSize = 500
+
+// SyncResult reports the DNs a directory sync created, updated and deleted
+type SyncResult struct {
+	Created []string
+	Updated []string
+	Deleted []string
+
+	// Failed holds the error of each entry that could not be synced
+	Failed map[string]error
+}
+
+// Changed reports whether the sync wrote to the directory
+func (r *SyncResult) Changed() bool {
+	return len(r.Created)+len(r.Updated)+len(r.Deleted) > 0
+}
+
+// SyncOptions selects which entries a sync deletes
+type SyncOptions struct {
+	// Remove lists users, groups and departments to delete unless spec
+	// declares them, such as those a previously applied spec declared or
+	// the directory reconciler created. Entries nobody recorded, like
+	// those made through ldap-manager, are never deleted.
+	Remove *InitDataSpec
+}
+
+// Sync converges the directory on spec. Missing entries are created and
+// declared attributes that drifted are rewritten; attributes spec leaves
+// empty are not managed, so changes made through ldap-manager survive.
+// Passwords are only set when a user is created. Undeclared entries are
+// deleted as opts selects. Errors on single entries are collected in the
+// result rather than returned.
+func (i *LDAPInitializer) Sync(ctx context.Context, ldapURL, adminDN, adminPassword, baseDN string, spec *InitDataSpec, opts SyncOptions) (*SyncResult, error) {
+	conn, err := i.dial(ldapURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(adminDN, adminPassword); err != nil {
+		return nil, fmt.Errorf("failed to bind as admin: %w", err)
+	}
+
+	live, err := i.readDirectory(conn, baseDN)
+	if err != nil {
+		return nil, err
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"users":  len(spec.Users),
+		"groups": len(spec.Groups),
+		"prune":  opts.Remove != nil,
+	}).Debug("Syncing LDAP directory")
+
+	result := &SyncResult{Failed: make(map[string]error)}
+	apply := func(dn string, existing bool, create func() error, modifyRequest *ldap.ModifyRequest) {
+		if ctx.Err() != nil {
+			result.Failed[dn] = ctx.Err()
+			return
+		}
+		switch {
+		case !existing:
+			if err := create(); err != nil {
+				result.Failed[dn] = err
+				return
+			}
+			result.Created = append(result.Created, dn)
+		case modifyRequest != nil:
+			if err := conn.Modify(modifyRequest); err != nil {
+				result.Failed[dn] = fmt.Errorf("failed to update %s: %w", dn, err)
+				return
+			}
+			result.Updated = append(result.Updated, dn)
+		}
+	}
+
+	for _, ou := range spec.OrganizationalUnits {
+		ou := ou
+		dn := fmt.Sprintf("ou=%s,%s", ou.Name, baseDN)
+		_, ok := live.ous[strings.ToLower(ou.Name)]
+		apply(dn, ok, func() error { return i.createOU(conn, baseDN, ou) }, nil)
+	}
+
+	for _, dept := range spec.Departments {
+		dept := dept
+		dn := fmt.Sprintf("ou=%s,ou=departments,%s", dept.Name, baseDN)
+		entry, ok := live.departments[strings.ToLower(dept.Name)]
+		var modifyRequest *ldap.ModifyRequest
+		if ok {
+			modifyRequest = departmentChanges(entry, dept, baseDN)
+		}
+		apply(dn, ok, func() error { return i.createDepartment(conn, baseDN, dept) }, modifyRequest)
+	}
+
+	uidNumber := live.maxUIDNumber
+	for _, user := range spec.Users {
+		user := user
+		dn := fmt.Sprintf("uid=%s,ou=users,%s", user.UID, baseDN)
+		entry, ok := live.users[strings.ToLower(user.UID)]
+		var modifyRequest *ldap.ModifyRequest
+		if ok {
+			modifyRequest = userChanges(entry, user)
+		}
+		apply(dn, ok, func() error {
+			n, err := nextID(conn, baseDN, "uidNumber", &uidNumber)
+			if err != nil {
+				return err
+			}
+			return i.createUser(conn, baseDN, user, n)
+		}, modifyRequest)
+	}
+
+	gidNumber := live.maxGIDNumber
+	for _, group := range spec.Groups {
+		group := group
+		dn := fmt.Sprintf("cn=%s,ou=groups,%s", group.Name, baseDN)
+		entry, ok := live.groups[strings.ToLower(group.Name)]
+		var modifyRequest *ldap.ModifyRequest
+		if ok {
+			modifyRequest = groupChanges(entry, group, baseDN)
+		}
+		apply(dn, ok, func() error {
+			n, err := nextID(conn, baseDN, "gidNumber", &gidNumber)
+			if err != nil {
+				return err
+			}
+			return i.createGroup(conn, baseDN, group, n)
+		}, modifyRequest)
+	}
+
+	if opts.Remove != nil {
+		i.prune(conn, live, spec, opts, result)
+	}
+
+	if result.Changed() || len(result.Failed) > 0 {
+		i.logger.WithFields(logrus.Fields{
+			"created": len(result.Created),
+			"updated": len(result.Updated),
+			"deleted": len(result.Deleted),
+			"failed":  len(result.Failed),
+		}).Info("LDAP directory synced")
+	}
+	return result, nil
+}
+
+// prune deletes the entries pruneCandidates selects
+func (i *LDAPInitializer) prune(conn *ldap.Conn, live *liveDirectory, spec *InitDataSpec, opts SyncOptions, result *SyncResult) {
+	for _, entry := range pruneCandidates(live, spec, opts) {
+		if err := conn.Del(ldap.NewDelRequest(entry.DN, nil)); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			result.Failed[entry.DN] = fmt.Errorf("failed to prune %s: %w", entry.DN, err)
+			continue
+		}
+		i.logger.WithField("dn", entry.DN).Info("Pruned undeclared entry")
+		result.Deleted = append(result.Deleted, entry.DN)
+	}
+}
+
+// pruneCandidates returns the groups, users and departments of live that
+// opts.Remove lists and spec does not declare, groups first so no group is
+// left pointing at a deleted user for longer than needed
+func pruneCandidates(live *liveDirectory, spec *InitDataSpec, opts SyncOptions) []*ldap.Entry {
+	declared := specNames(spec)
+	removed := specNames(opts.Remove)
+
+	var candidates []*ldap.Entry
+	for _, kind := range []struct {
+		entries  map[string]*ldap.Entry
+		declared map[string]bool
+		removed  func(*entryNames) map[string]bool
+	}{
+		{live.groups, declared.groups, func(n *entryNames) map[string]bool { return n.groups }},
+		{live.users, declared.users, func(n *entryNames) map[string]bool { return n.users }},
+		{live.departments, declared.departments, func(n *entryNames) map[string]bool { return n.departments }},
+	} {
+		var names []string
+		for name := range kind.entries {
+			if kind.declared[name] || !kind.removed(removed)[name] {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			candidates = append(candidates, kind.entries[name])
+		}
+	}
+	return candidates
+}
+
+// entryNames holds the lowercased names of the users, groups and
+// departments a spec declares
+type entryNames struct {
+	users       map[string]bool
+	groups      map[string]bool
+	departments map[string]bool
+}
+
+func specNames(spec *InitDataSpec) *entryNames {
+	names := &entryNames{
+		users:       make(map[string]bool),
+		groups:      make(map[string]bool),
+		departments: make(map[string]bool),
+	}
+	if spec == nil {
+		return names
+	}
+	for _, user := range spec.Users {
+		names.users[strings.ToLower(user.UID)] = true
+	}
+	for _, group := range spec.Groups {
+		names.groups[strings.ToLower(group.Name)] = true
+	}
+	for _, dept := range spec.Departments {
+		names.departments[strings.ToLower(dept.Name)] = true
+	}
+	return names
+}
+
+// liveDirectory holds the entries a sync compares spec against, keyed by
+// lowercased name
+type liveDirectory struct {
+	ous          map[string]*ldap.Entry
+	departments  map[string]*ldap.Entry
+	users        map[string]*ldap.Entry
+	groups       map[string]*ldap.Entry
+	maxUIDNumber int
+	maxGIDNumber int
+}
+
+// readDirectory reads the entries Sync manages
+func (i *LDAPInitializer) readDirectory(conn *ldap.Conn, baseDN string) (*liveDirectory, error) {
+	live := &liveDirectory{maxUIDNumber: 10000, maxGIDNumber: 10000}
+	var err error
+
+	if live.ous, err = readEntries(conn, baseDN, "(objectClass=organizationalUnit)", "ou", []string{"ou"}); err != nil {
+		return nil, err
+	}
+	if live.departments, err = readEntries(conn, "ou=departments,"+baseDN, "(objectClass=organizationalUnit)", "ou",
+		[]string{"objectClass", "ou", "description", "manager", "githubRepository", "repositoryGrant"}); err != nil {
+		return nil, err
+	}
+	if live.users, err = readEntries(conn, "ou=users,"+baseDN, "(objectClass=inetOrgPerson)", "uid",
+		[]string{"objectClass", "uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "githubRepository", "repositoryGrant"}); err != nil {
+		return nil, err
+	}
+	if live.groups, err = readEntries(conn, "ou=groups,"+baseDN, "(objectClass=groupOfNames)", "cn",
+		[]string{"cn", "description", "member", "gidNumber"}); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range live.users {
+		if n, err := strconv.Atoi(entry.GetAttributeValue("uidNumber")); err == nil && n > live.maxUIDNumber {
+			live.maxUIDNumber = n
+		}
+	}
+	for _, entry := range live.groups {
+		if n, err := strconv.Atoi(entry.GetAttributeValue("gidNumber")); err == nil && n > live.maxGIDNumber {
+			live.maxGIDNumber = n
+		}
+	}
+	return live, nil
+}
+
+// readEntries returns the entries directly below base matching filter,
+// keyed by the lowercased value of nameAttr. A missing base has no entries.
+func readEntries(conn *ldap.Conn, base, filter, nameAttr string, attributes []string) (map[string]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		base,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+	sr, err := conn.SearchWithPaging(searchRequest, syncPageSize)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return map[string]*ldap.Entry{}, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", base, err)
+	}
+
+	entries := make(map[string]*ldap.Entry, len(sr.Entries))
+	for _, entry := range sr.Entries {
+		if name := entry.GetAttributeValue(nameAttr); name != "" {
+			entries[strings.ToLower(name)] = entry
+		}
+	}
+	return entries, nil
+}
+
+// nextID reserves the next uidNumber or gidNumber. Where ldap-manager's
+// ID allocator entry exists, it is reserved there with config.ReserveID,
+// so both never issue the same number. Otherwise the number after
+// *highest, the highest one in the directory, is issued; ldap-manager
+// seeds its allocator past it later.
+func nextID(conn *ldap.Conn, baseDN, attr string, highest *int) (int, error) {
+	id, err := config.ReserveID(conn, fmt.Sprintf("cn=%s,%s", config.IDAllocatorCN, baseDN), attr)
+	if errors.Is(err, config.ErrIDAllocatorMissing) {
+		*highest++
+		return *highest, nil
+	}
+	return id, err
+}
+
+// userChanges returns the modification bringing a live user in line with
+// spec, or nil when nothing drifted
+func userChanges(entry *ldap.Entry, user UserSpec) *ldap.ModifyRequest {
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	replaceDrifted(modifyRequest, entry, "cn", user.CommonName)
+	replaceDrifted(modifyRequest, entry, "sn", user.Surname)
+	replaceDrifted(modifyRequest, entry, "givenName", user.GivenName)
+	replaceDrifted(modifyRequest, entry, "mail", user.Email)
+	replaceDrifted(modifyRequest, entry, "departmentNumber", user.Department)
+
+	if next, dropped, ok := grantDrift(entry, user.Repositories, false); ok {
+		// githubRepository also lists what the user's groups grant, which
+		// ldap-manager maintains; only the declared repositories move
+		repos := entry.GetAttributeValues("githubRepository")
+		repos = append(removeValues(repos, dropped), user.Repositories...)
+		replaceGrants(modifyRequest, entry, next, dedupeFold(repos))
+	}
+
+	if len(modifyRequest.Changes) == 0 {
+		return nil
+	}
+	return modifyRequest
+}
+
+// departmentChanges returns the modification bringing a live department
+// in line with spec, or nil when nothing drifted
+func departmentChanges(entry *ldap.Entry, dept DepartmentSpec, baseDN string) *ldap.ModifyRequest {
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	replaceDrifted(modifyRequest, entry, "description", dept.Description)
+	if dept.Manager != "" {
+		managerDN := fmt.Sprintf("uid=%s,ou=users,%s", dept.Manager, baseDN)
+		if !strings.EqualFold(entry.GetAttributeValue("manager"), managerDN) {
+			ensureExtensible(modifyRequest, entry)
+			modifyRequest.Replace("manager", []string{managerDN})
+		}
+	}
+
+	if next, _, ok := grantDrift(entry, dept.Repositories, true); ok {
+		replaceGrants(modifyRequest, entry, next, models.GrantedRepositories(next, time.Now()))
+	}
+
+	if len(modifyRequest.Changes) == 0 {
+		return nil
+	}
+	return modifyRequest
+}
+
+// groupChanges returns the modification bringing a live group in line with
+// spec, or nil when nothing drifted. Members are managed when spec lists
+// any, or lists none explicitly.
+func groupChanges(entry *ldap.Entry, group GroupSpec, baseDN string) *ldap.ModifyRequest {
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	replaceDrifted(modifyRequest, entry, "description", group.Description)
+
+	if group.Members != nil {
+		wanted := memberDNs(baseDN, group.Members)
+		if !sameValuesFold(entry.GetAttributeValues("member"), wanted) {
+			modifyRequest.Replace("member", wanted)
+		}
+	}
+
+	if len(modifyRequest.Changes) == 0 {
+		return nil
+	}
+	return modifyRequest
+}
+
+// replaceDrifted replaces attr with want unless want is empty, meaning
+// the attribute is not managed, or the entry already holds it
+func replaceDrifted(modifyRequest *ldap.ModifyRequest, entry *ldap.Entry, attr, want string) {
+	if want != "" && entry.GetAttributeValue(attr) != want {
+		modifyRequest.Replace(attr, []string{want})
+	}
+}
+
+// grantDrift compares the repositories an entry holds permanent grants on
+// with repos. When they differ it returns the grants the entry should
+// hold and the repositories no longer declared. Time-bound grants, such as
+// approved access requests, are kept, and so are the permission levels of
+// repositories that stay. A nil repos is not managed. With legacy set,
+// githubRepository values without a grant count as grants, as ldap-manager
+// treats them on groups and departments.
+func grantDrift(entry *ldap.Entry, repos []string, legacy bool) (next []models.RepositoryGrant, dropped []string, drifted bool) {
+	if repos == nil {
+		return nil, nil, false
+	}
+
+	var permanent, timeBound []models.RepositoryGrant
+	for _, value := range entry.GetAttributeValues("repositoryGrant") {
+		grant, err := models.ParseRepositoryGrant(value)
+		if err != nil {
+			continue
+		}
+		if grant.ExpiresAt != nil {
+			timeBound = append(timeBound, grant)
+		} else {
+			permanent = append(permanent, grant)
+		}
+	}
+	if legacy {
+		covered := make(map[string]bool, len(permanent)+len(timeBound))
+		for _, grant := range append(append([]models.RepositoryGrant{}, permanent...), timeBound...) {
+			covered[strings.ToLower(grant.Repository)] = true
+		}
+		for _, repo := range entry.GetAttributeValues("githubRepository") {
+			if !covered[strings.ToLower(repo)] {
+				permanent = append(permanent, models.RepositoryGrant{Repository: repo, Permission: models.DefaultPermission})
+			}
+		}
+	}
+
+	held := make([]string, 0, len(permanent))
+	for _, grant := range permanent {
+		held = append(held, grant.Repository)
+	}
+	if sameValuesFold(held, repos) {
+		return nil, nil, false
+	}
+
+	for _, repo := range held {
+		if !containsFold(repos, repo) {
+			dropped = append(dropped, repo)
+		}
+	}
+	next = append(timeBound, models.ResolveGrants(models.RepositoryGrants(repos), permanent)...)
+	return next, dropped, true
+}
+
+// replaceGrants rewrites the grants and githubRepository values of entry
+func replaceGrants(modifyRequest *ldap.ModifyRequest, entry *ldap.Entry, grants []models.RepositoryGrant, repos []string) {
+	if len(grants) > 0 {
+		ensureExtensible(modifyRequest, entry)
+	}
+	modifyRequest.Replace("repositoryGrant", models.GrantValues(grants))
+	modifyRequest.Replace("githubRepository", repos)
+}
+
+// ensureExtensible adds extensibleObject to entry, once, unless it is
+// there already, so attributes its structural class lacks can be written
+func ensureExtensible(modifyRequest *ldap.ModifyRequest, entry *ldap.Entry) {
+	if containsFold(entry.GetAttributeValues("objectClass"), "extensibleObject") {
+		return
+	}
+	for _, change := range modifyRequest.Changes {
+		if strings.EqualFold(change.Modification.Type, "objectClass") {
+			return
+		}
+	}
+	modifyRequest.Add("objectClass", []string{"extensibleObject"})
+}
+
+// memberDNs turns group members given as uids or DNs into DNs
+func memberDNs(baseDN string, members []string) []string {
+	// Groups need at least one member, the admin stands in
+	if len(members) == 0 {
+		return []string{fmt.Sprintf("cn=admin,%s", baseDN)}
+	}
+
+	dns := make([]string, len(members))
+	for i, member := range members {
+		if strings.HasPrefix(member, "cn=") || strings.HasPrefix(member, "uid=") {
+			dns[i] = member
+		} else {
+			dns[i] = fmt.Sprintf("uid=%s,ou=users,%s", member, baseDN)
+		}
+	}
+	return dns
+}
+
+// sameValuesFold reports whether a and b hold the same set of values,
+// compared case-insensitively like the directory does
+func sameValuesFold(a, b []string) bool {
+	set := func(values []string) map[string]bool {
+		s := make(map[string]bool, len(values))
+		for _, value := range values {
+			s[strings.ToLower(value)] = true
+		}
+		return s
+	}
+	sa, sb := set(a), set(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+	for value := range sa {
+		if !sb[value] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeValues(values, removed []string) []string {
+	var kept []string
+	for _, value := range values {
+		if !containsFold(removed, value) {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+func dedupeFold(values []string) []string {
+	var unique []string
+	for _, value := range values {
+		if !containsFold(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
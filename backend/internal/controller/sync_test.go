@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testBaseDN = "dc=devplatform,dc=local"
+
+// changes flattens a modify request into "op attr=values" strings
+func changes(modifyRequest *ldap.ModifyRequest) []string {
+	if modifyRequest == nil {
+		return nil
+	}
+	ops := map[uint]string{ldap.AddAttribute: "add", ldap.DeleteAttribute: "delete", ldap.ReplaceAttribute: "replace"}
+	var out []string
+	for _, change := range modifyRequest.Changes {
+		values := append([]string(nil), change.Modification.Vals...)
+		sort.Strings(values)
+		out = append(out, ops[change.Operation]+" "+change.Modification.Type+"="+strings.Join(values, ","))
+	}
+	return out
+}
+
+func TestUserChanges(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	entry := ldap.NewEntry("uid=alice,ou=users,"+testBaseDN, map[string][]string{
+		"objectClass":      {"inetOrgPerson", "extensibleObject"},
+		"cn":               {"Alice"},
+		"sn":               {"Smith"},
+		"mail":             {"alice@old.example"},
+		"departmentNumber": {"engineering"},
+		"repositoryGrant":  {"repo/a admin", "repo/b write", "repo/c read " + expires},
+		"githubRepository": {"repo/a", "repo/b", "repo/c", "repo/group"},
+	})
+
+	tests := []struct {
+		name string
+		user UserSpec
+		want []string
+	}{
+		{
+			name: "in sync",
+			user: UserSpec{CommonName: "Alice", Surname: "Smith", Email: "alice@old.example", Repositories: []string{"repo/a", "repo/b"}},
+		},
+		{
+			name: "unmanaged attributes are left alone",
+			user: UserSpec{CommonName: "Alice"},
+		},
+		{
+			name: "drifted attributes are replaced",
+			user: UserSpec{CommonName: "Alice", Email: "alice@new.example", Department: "sales"},
+			want: []string{"replace mail=alice@new.example", "replace departmentNumber=sales"},
+		},
+		{
+			name: "repositories keep levels and time-bound grants",
+			user: UserSpec{Repositories: []string{"repo/a", "repo/d"}},
+			want: []string{
+				"replace repositoryGrant=repo/a admin,repo/c read " + expires + ",repo/d write",
+				"replace githubRepository=repo/a,repo/c,repo/d,repo/group",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changes(userChanges(entry, tt.user)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDepartmentChanges(t *testing.T) {
+	entry := ldap.NewEntry("ou=engineering,ou=departments,"+testBaseDN, map[string][]string{
+		"objectClass":      {"organizationalUnit"},
+		"ou":               {"engineering"},
+		"description":      {"Engineering"},
+		"manager":          {"uid=alice,ou=users," + testBaseDN},
+		"githubRepository": {"repo/legacy"},
+	})
+
+	if got := changes(departmentChanges(entry, DepartmentSpec{Name: "engineering", Manager: "alice", Repositories: []string{"repo/legacy"}}, testBaseDN)); got != nil {
+		t.Errorf("departmentChanges() in sync = %v, want none", got)
+	}
+
+	got := changes(departmentChanges(entry, DepartmentSpec{Name: "engineering", Manager: "bob", Repositories: []string{"repo/new"}}, testBaseDN))
+	want := []string{
+		"add objectClass=extensibleObject",
+		"replace manager=uid=bob,ou=users," + testBaseDN,
+		"replace repositoryGrant=repo/new write",
+		"replace githubRepository=repo/new",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("departmentChanges() = %v, want %v", got, want)
+	}
+}
+
+func TestGroupChanges(t *testing.T) {
+	entry := ldap.NewEntry("cn=developers,ou=groups,"+testBaseDN, map[string][]string{
+		"cn":     {"developers"},
+		"member": {"uid=alice,ou=users," + testBaseDN, "uid=bob,ou=users," + testBaseDN},
+	})
+
+	tests := []struct {
+		name  string
+		group GroupSpec
+		want  []string
+	}{
+		{name: "members not managed", group: GroupSpec{Name: "developers"}},
+		{name: "same members", group: GroupSpec{Name: "developers", Members: []string{"bob", "uid=ALICE,ou=users," + testBaseDN}}},
+		{
+			name:  "members replaced",
+			group: GroupSpec{Name: "developers", Description: "Devs", Members: []string{"carol"}},
+			want:  []string{"replace description=Devs", "replace member=uid=carol,ou=users," + testBaseDN},
+		},
+		{
+			name:  "empty members leave the admin",
+			group: GroupSpec{Name: "developers", Members: []string{}},
+			want:  []string{"replace member=cn=admin," + testBaseDN},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changes(groupChanges(entry, tt.group, testBaseDN)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecOf(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": DirectoryGroupVersion.String(),
+		"kind":       "LDAPUser",
+		"metadata":   map[string]interface{}{"name": "alice"},
+		"spec": map[string]interface{}{
+			"cn":                "Alice",
+			"sn":                "Smith",
+			"mail":              "alice@example.com",
+			"repositories":      []interface{}{"repo/a"},
+			"passwordSecretRef": map[string]interface{}{"name": "alice", "key": "password"},
+		},
+	}}
+
+	var spec LDAPUserSpec
+	if err := specOf(obj, &spec); err != nil {
+		t.Fatalf("specOf() error = %v", err)
+	}
+	want := LDAPUserSpec{
+		CommonName:        "Alice",
+		Surname:           "Smith",
+		Email:             "alice@example.com",
+		Repositories:      []string{"repo/a"},
+		PasswordSecretRef: &SecretKeyRef{Name: "alice", Key: "password"},
+	}
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("specOf() = %+v, want %+v", spec, want)
+	}
+
+	obj.Object["spec"] = map[string]interface{}{"cn": int64(42)}
+	if err := specOf(obj, &spec); err == nil {
+		t.Error("specOf() accepted a number as cn")
+	}
+}
+
+func TestPruneCandidates(t *testing.T) {
+	live := &liveDirectory{
+		users: map[string]*ldap.Entry{
+			"alice": ldap.NewEntry("uid=alice,ou=users,"+testBaseDN, nil),
+			"bob":   ldap.NewEntry("uid=bob,ou=users,"+testBaseDN, nil),
+			"carol": ldap.NewEntry("uid=carol,ou=users,"+testBaseDN, nil),
+		},
+		groups: map[string]*ldap.Entry{
+			"developers": ldap.NewEntry("cn=developers,ou=groups,"+testBaseDN, nil),
+			"legacy":     ldap.NewEntry("cn=legacy,ou=groups,"+testBaseDN, nil),
+		},
+		departments: map[string]*ldap.Entry{
+			"sales": ldap.NewEntry("ou=sales,ou=departments,"+testBaseDN, nil),
+		},
+	}
+	spec := &InitDataSpec{
+		Users:  []UserSpec{{UID: "Alice"}},
+		Groups: []GroupSpec{{Name: "developers"}},
+	}
+	previous := &InitDataSpec{
+		Users:  []UserSpec{{UID: "alice"}, {UID: "bob"}},
+		Groups: []GroupSpec{{Name: "developers"}, {Name: "legacy"}},
+	}
+
+	tests := []struct {
+		name string
+		opts SyncOptions
+		want []string
+	}{
+		{
+			name: "nothing without a record",
+			opts: SyncOptions{},
+		},
+		{
+			name: "remove only what the previous spec declared",
+			opts: SyncOptions{Remove: previous},
+			want: []string{
+				"cn=legacy,ou=groups," + testBaseDN,
+				"uid=bob,ou=users," + testBaseDN,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, entry := range pruneCandidates(live, spec, tt.opts) {
+				got = append(got, entry.DN)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pruneCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatedEntriesUpdate(t *testing.T) {
+	previous := &createdEntries{Users: []string{"bob", "carol"}, Groups: []string{"legacy"}}
+	spec := &InitDataSpec{
+		Users:       []UserSpec{{UID: "alice"}, {UID: "dave"}},
+		Groups:      []GroupSpec{{Name: "developers"}},
+		Departments: []DepartmentSpec{{Name: "sales"}},
+	}
+	// alice is new, dave already existed, so was not created here; bob
+	// was pruned and pruning legacy failed
+	result := &SyncResult{
+		Created: []string{"uid=alice,ou=users," + testBaseDN, "ou=sales,ou=departments," + testBaseDN},
+		Deleted: []string{"uid=bob,ou=users," + testBaseDN},
+		Failed:  map[string]error{"cn=legacy,ou=groups," + testBaseDN: errors.New("busy")},
+	}
+
+	got := previous.update(spec, result, testBaseDN)
+	want := &createdEntries{Users: []string{"alice", "carol"}, Groups: []string{"legacy"}, Departments: []string{"sales"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("update() = %+v, want %+v", got, want)
+	}
+
+	// What was recorded is what a later prune may remove
+	removed := specNames(got.spec())
+	if !removed.users["carol"] || !removed.groups["legacy"] || !removed.departments["sales"] || removed.users["dave"] {
+		t.Errorf("spec() = %+v, want the recorded entries only", got.spec())
+	}
+}
@@ -47,3 +47,44 @@ type GroupSpec struct {
 	Description string   `json:"description,omitempty"`
 	Members     []string `json:"members,omitempty"`
 }
+
+// LDAPDirectorySpec is the spec of an LDAPDirectory resource, which
+// declares the organizational units and departments of the directory and
+// whether entries no resource declares any more are pruned
+type LDAPDirectorySpec struct {
+	// Prune deletes the users, groups and departments the controller
+	// created for resources once they are no longer declared. Entries made
+	// otherwise, e.g. through ldap-manager or the init data, are kept.
+	Prune bool `json:"prune,omitempty"`
+
+	OrganizationalUnits []OUSpec         `json:"organizationalUnits,omitempty"`
+	Departments         []DepartmentSpec `json:"departments,omitempty"`
+}
+
+// LDAPUserSpec is the spec of an LDAPUser resource. The uid defaults to
+// the resource name; the password is read from a Secret when the user is
+// created and never reconciled afterwards.
+type LDAPUserSpec struct {
+	UID               string        `json:"uid,omitempty"`
+	CommonName        string        `json:"cn"`
+	Surname           string        `json:"sn"`
+	GivenName         string        `json:"givenName,omitempty"`
+	Email             string        `json:"mail"`
+	Department        string        `json:"department,omitempty"`
+	Repositories      []string      `json:"repositories,omitempty"`
+	PasswordSecretRef *SecretKeyRef `json:"passwordSecretRef,omitempty"`
+}
+
+// LDAPGroupSpec is the spec of an LDAPGroup resource. The name defaults
+// to the resource name.
+type LDAPGroupSpec struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Members     []string `json:"members,omitempty"`
+}
+
+// SecretKeyRef selects a key of a Secret in the controller's namespace
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Watcher is the source of directory changes (implemented by ldap.Manager)
+type Watcher interface {
+	Watch(ctx context.Context, source string, pollInterval time.Duration, fn func(*models.DirectoryChange))
+}
+
+// Broker fans events out to in-process subscribers: GraphQL subscriptions
+// and webhook dispatchers. Publishing never blocks; a subscriber that falls
+// behind loses events, which is counted in ldap_events_dropped_total.
+type Broker struct {
+	mu     sync.RWMutex
+	subs   map[int]*subscription
+	nextID int
+	logger *logrus.Logger
+}
+
+type subscription struct {
+	consumer string
+	types    []string
+	ch       chan *Event
+}
+
+// NewBroker creates an empty broker
+func NewBroker(logger *logrus.Logger) *Broker {
+	return &Broker{
+		subs:   make(map[int]*subscription),
+		logger: logger,
+	}
+}
+
+// Run publishes the events derived from every change the watcher reports,
+// until ctx is done
+func (b *Broker) Run(ctx context.Context, watcher Watcher, source string, pollInterval time.Duration) {
+	watcher.Watch(ctx, source, pollInterval, func(change *models.DirectoryChange) {
+		for _, e := range FromChange(change) {
+			b.Publish(e)
+		}
+	})
+}
+
+// Publish hands an event to every subscriber interested in its type
+func (b *Broker) Publish(e *Event) {
+	prometheus.EventsPublishedTotal.WithLabelValues(e.Type).Inc()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs {
+		if !matches(sub.types, e.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			prometheus.EventsDroppedTotal.WithLabelValues(sub.consumer).Inc()
+			b.logger.WithFields(logrus.Fields{
+				"consumer": sub.consumer,
+				"event":    e.ID,
+				"type":     e.Type,
+			}).Warn("Subscriber is not keeping up, event dropped")
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the given event types; an empty
+// list means all events and "group.*" matches every group event. The
+// returned cancel function unregisters it and closes the channel.
+func (b *Broker) Subscribe(consumer string, types []string, buffer int) (<-chan *Event, func()) {
+	sub := &subscription{
+		consumer: consumer,
+		types:    types,
+		ch:       make(chan *Event, buffer),
+	}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+// matches reports whether an event type is selected by a type filter
+func matches(types []string, eventType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(t, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
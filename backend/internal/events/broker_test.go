@@ -0,0 +1,117 @@
+package events
+
+import (
+	"testing"
+)
+
+// drain returns the IDs of the events waiting on ch
+func drain(ch <-chan *Event) []string {
+	var ids []string
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, e.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	broker := NewBroker(testLogger())
+
+	all, cancelAll := broker.Subscribe("all", nil, 10)
+	defer cancelAll()
+	groups, cancelGroups := broker.Subscribe("groups", []string{"group.*"}, 10)
+	defer cancelGroups()
+	created, cancelCreated := broker.Subscribe("created", []string{TypeUserCreated, TypeGroupCreated}, 10)
+	defer cancelCreated()
+
+	for _, e := range []*Event{
+		{ID: "1", Type: TypeUserCreated},
+		{ID: "2", Type: TypeGroupMemberAdded},
+		{ID: "3", Type: TypeGroupCreated},
+		{ID: "4", Type: TypeRepoAssigned},
+	} {
+		broker.Publish(e)
+	}
+
+	tests := []struct {
+		name string
+		ch   <-chan *Event
+		want []string
+	}{
+		{name: "all", ch: all, want: []string{"1", "2", "3", "4"}},
+		{name: "wildcard", ch: groups, want: []string{"2", "3"}},
+		{name: "exact types", ch: created, want: []string{"1", "3"}},
+	}
+	for _, tt := range tests {
+		got := drain(tt.ch)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s received %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s received %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBrokerDropsForSlowSubscribers(t *testing.T) {
+	broker := NewBroker(testLogger())
+	slow, cancelSlow := broker.Subscribe("slow", nil, 1)
+	defer cancelSlow()
+	fast, cancelFast := broker.Subscribe("fast", nil, 10)
+	defer cancelFast()
+
+	// Publishing does not block on the full subscriber
+	broker.Publish(&Event{ID: "1", Type: TypeUserCreated})
+	broker.Publish(&Event{ID: "2", Type: TypeUserUpdated})
+
+	if got := drain(slow); len(got) != 1 || got[0] != "1" {
+		t.Errorf("slow subscriber received %v, want only the first event", got)
+	}
+	if got := drain(fast); len(got) != 2 {
+		t.Errorf("fast subscriber received %v, want both events", got)
+	}
+}
+
+func TestBrokerCancel(t *testing.T) {
+	broker := NewBroker(testLogger())
+	ch, cancel := broker.Subscribe("gone", nil, 10)
+
+	cancel()
+	cancel() // cancelling twice is harmless
+	if _, ok := <-ch; ok {
+		t.Error("channel is open after cancel")
+	}
+
+	// Publishing after cancel must not send on the closed channel
+	broker.Publish(&Event{ID: "1", Type: TypeUserCreated})
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		types     []string
+		eventType string
+		want      bool
+	}{
+		{types: nil, eventType: TypeUserCreated, want: true},
+		{types: []string{TypeUserCreated}, eventType: TypeUserCreated, want: true},
+		{types: []string{TypeUserCreated}, eventType: TypeUserDeleted, want: false},
+		{types: []string{"group.*"}, eventType: TypeGroupMemberRemoved, want: true},
+		{types: []string{"group.*"}, eventType: TypeUserCreated, want: false},
+		{types: []string{"*"}, eventType: TypeRepoUnassigned, want: true},
+	}
+	for _, tt := range tests {
+		if got := matches(tt.types, tt.eventType); got != tt.want {
+			t.Errorf("matches(%v, %q) = %v, want %v", tt.types, tt.eventType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package events
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/audit"
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Event types published for directory changes
+const (
+	TypeUserCreated        = "user.created"
+	TypeUserUpdated        = "user.updated"
+	TypeUserDeleted        = "user.deleted"
+	TypeGroupCreated       = "group.created"
+	TypeGroupUpdated       = "group.updated"
+	TypeGroupDeleted       = "group.deleted"
+	TypeGroupMemberAdded   = "group.member_added"
+	TypeGroupMemberRemoved = "group.member_removed"
+	TypeDepartmentCreated  = "department.created"
+	TypeDepartmentUpdated  = "department.updated"
+	TypeDepartmentDeleted  = "department.deleted"
+	TypeRepoAssigned       = "repo.assigned"
+	TypeRepoUnassigned     = "repo.unassigned"
+)
+
+// Attributes that get their own event types instead of *.updated
+const (
+	memberAttr     = "member"
+	repositoryAttr = "githubRepository"
+)
+
+// Event is a typed directory change delivered to subscribers and webhooks.
+//
+// Every replica watching the directory publishes the same events with the
+// same ID, so consumers should treat the ID as an idempotency key.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Entity    string    `json:"entity"` // user, group or department
+	Key       string    `json:"key"`    // uid, cn or ou
+	DN        string    `json:"dn"`
+
+	// Set on group.member_* events: the uid of the user, or the cn of the
+	// nested group when MemberEntity is "group"
+	Member       string `json:"member,omitempty"`
+	MemberEntity string `json:"memberEntity,omitempty"`
+
+	// Set on repo.* events
+	Repositories []string `json:"repositories,omitempty"`
+
+	// Attribute values for *.created, *.deleted and *.updated events
+	Changes []audit.AttributeChange `json:"changes,omitempty"`
+}
+
+// FromChange turns an observed directory change into events. A creation
+// also yields member_added and repo.assigned events for the initial
+// members and repositories; a deletion yields only the *.deleted event.
+func FromChange(change *models.DirectoryChange) []*Event {
+	var events []*Event
+	newEvent := func(eventType string) *Event {
+		e := &Event{
+			Type:      eventType,
+			Timestamp: time.Now().UTC(),
+			Entity:    change.Entity,
+			Key:       change.Key,
+			DN:        change.DN,
+		}
+		events = append(events, e)
+		return e
+	}
+
+	switch {
+	case change.After == nil:
+		newEvent(change.Entity + ".deleted").Changes = audit.Diff(change.Before, nil)
+		return withIDs(change, events)
+	case change.Before == nil:
+		newEvent(change.Entity + ".created").Changes = audit.Diff(nil, change.After)
+	default:
+		if changes := audit.Diff(without(change.Before), without(change.After)); len(changes) > 0 {
+			newEvent(change.Entity + ".updated").Changes = changes
+		}
+	}
+
+	if change.Entity == models.EntityGroup {
+		added, removed := valueDiff(attribute(change.Before, memberAttr), attribute(change.After, memberAttr))
+		for _, dn := range added {
+			if member, entity, ok := memberOf(dn); ok {
+				e := newEvent(TypeGroupMemberAdded)
+				e.Member, e.MemberEntity = member, entity
+			}
+		}
+		for _, dn := range removed {
+			if member, entity, ok := memberOf(dn); ok {
+				e := newEvent(TypeGroupMemberRemoved)
+				e.Member, e.MemberEntity = member, entity
+			}
+		}
+	}
+
+	added, removed := valueDiff(attribute(change.Before, repositoryAttr), attribute(change.After, repositoryAttr))
+	if len(added) > 0 {
+		newEvent(TypeRepoAssigned).Repositories = added
+	}
+	if len(removed) > 0 {
+		newEvent(TypeRepoUnassigned).Repositories = removed
+	}
+
+	return withIDs(change, events)
+}
+
+// withIDs derives each event's ID from the entry and the change itself, so
+// replicas observing the same change produce the same IDs
+func withIDs(change *models.DirectoryChange, events []*Event) []*Event {
+	for _, e := range events {
+		h := sha256.New()
+		for _, part := range []string{change.EntryUUID, change.CSN, e.Type, e.Member, strings.Join(e.Repositories, ",")} {
+			h.Write([]byte(part))
+			h.Write([]byte{0})
+		}
+		e.ID = hex.EncodeToString(h.Sum(nil))[:32]
+	}
+	return events
+}
+
+// memberOf maps a member DN to a uid or group cn. The placeholder member
+// every group carries is skipped.
+func memberOf(dn string) (string, string, bool) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) != 1 {
+		return "", "", false
+	}
+	rdn := parsed.RDNs[0].Attributes[0]
+	switch {
+	case strings.EqualFold(rdn.Type, "uid"):
+		return rdn.Value, models.EntityUser, true
+	case strings.EqualFold(rdn.Type, "cn") && !strings.EqualFold(rdn.Value, "placeholder"):
+		return rdn.Value, models.EntityGroup, true
+	}
+	return "", "", false
+}
+
+// without drops the attributes reported by their own event types
+func without(attrs map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(attrs))
+	for name, values := range attrs {
+		if strings.EqualFold(name, memberAttr) || strings.EqualFold(name, repositoryAttr) {
+			continue
+		}
+		out[name] = values
+	}
+	return out
+}
+
+// attribute looks up an attribute case-insensitively
+func attribute(attrs map[string][]string, name string) []string {
+	for attr, values := range attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// valueDiff returns the values only in after and only in before, sorted
+func valueDiff(before, after []string) (added, removed []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, v := range before {
+		inBefore[strings.ToLower(v)] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, v := range after {
+		inAfter[strings.ToLower(v)] = true
+		if !inBefore[strings.ToLower(v)] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range before {
+		if !inAfter[strings.ToLower(v)] {
+			removed = append(removed, v)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
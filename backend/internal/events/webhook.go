@@ -0,0 +1,175 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers sent with every webhook delivery
+const (
+	HeaderEvent     = "X-Devplatform-Event"
+	HeaderDelivery  = "X-Devplatform-Delivery"
+	HeaderTimestamp = "X-Devplatform-Timestamp"
+	HeaderSignature = "X-Devplatform-Signature"
+)
+
+// webhookQueueSize is how many events may wait for one slow endpoint
+const webhookQueueSize = 1024
+
+// webhookRetryBackoff is the wait before the first retry; it doubles with
+// every further attempt
+const webhookRetryBackoff = time.Second
+
+// WebhookDispatcher POSTs every event to the configured URLs. Each URL has
+// its own queue and delivers in order; failed deliveries are retried with
+// exponential backoff and dropped after WEBHOOK_MAX_RETRIES attempts.
+//
+// The body is the JSON event. The signature header is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), where
+// timestamp is the Unix time sent in the timestamp header; receivers should
+// reject stale timestamps to prevent replays.
+type WebhookDispatcher struct {
+	broker     *Broker
+	urls       []string
+	secret     []byte
+	maxRetries int
+	backoff    time.Duration
+	client     *http.Client
+	logger     *logrus.Logger
+}
+
+// NewWebhookDispatcher creates a dispatcher for cfg.WebhookURLs
+func NewWebhookDispatcher(broker *Broker, cfg *config.Config, logger *logrus.Logger) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		broker:     broker,
+		urls:       cfg.WebhookURLs,
+		secret:     []byte(cfg.WebhookSecret),
+		maxRetries: cfg.WebhookMaxRetries,
+		backoff:    webhookRetryBackoff,
+		client:     &http.Client{Timeout: cfg.WebhookTimeout},
+		logger:     logger,
+	}
+}
+
+// Run delivers events to every URL until ctx is done
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	if len(d.secret) == 0 {
+		d.logger.Warn("WEBHOOK_SECRET is not set, webhook deliveries are unsigned")
+	}
+
+	done := make(chan struct{})
+	for _, url := range d.urls {
+		events, cancel := d.broker.Subscribe("webhook", nil, webhookQueueSize)
+		go func(url string) {
+			defer func() { done <- struct{}{} }()
+			d.deliverAll(ctx, url, events)
+		}(url)
+		defer cancel()
+	}
+
+	d.logger.WithField("endpoints", len(d.urls)).Info("Webhook dispatcher started")
+	for range d.urls {
+		<-done
+	}
+}
+
+func (d *WebhookDispatcher) deliverAll(ctx context.Context, url string, events <-chan *Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			d.deliver(ctx, url, e)
+		}
+	}
+}
+
+// deliver sends one event, retrying until it is accepted, the endpoint
+// rejects it permanently or the retries are used up
+func (d *WebhookDispatcher) deliver(ctx context.Context, url string, e *Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.logger.WithError(err).WithField("event", e.ID).Error("Failed to encode event")
+		return
+	}
+
+	logger := d.logger.WithFields(logrus.Fields{"url": url, "event": e.ID, "type": e.Type})
+	backoff := d.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(ctx, url, e, body)
+		if err == nil {
+			prometheus.WebhookDeliveriesTotal.WithLabelValues("success").Inc()
+			return
+		}
+		if !retry || attempt >= d.maxRetries {
+			prometheus.WebhookDeliveriesTotal.WithLabelValues("failed").Inc()
+			logger.WithError(err).WithField("attempts", attempt+1).Error("Webhook delivery failed")
+			return
+		}
+
+		prometheus.WebhookDeliveriesTotal.WithLabelValues("retry").Inc()
+		logger.WithError(err).WithField("retryIn", backoff).Warn("Webhook delivery failed, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying
+func (d *WebhookDispatcher) post(ctx context.Context, url string, e *Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, e.Type)
+	req.Header.Set(HeaderDelivery, e.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(d.secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(d.secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+}
+
+// Sign computes the signature header value for a delivery
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
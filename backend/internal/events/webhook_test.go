@@ -0,0 +1,250 @@
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const testSecret = "webhook-secret"
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// receiver is a webhook endpoint answering with statuses in turn, then
+// 200, and checking every delivery the way a consumer should
+type receiver struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	events   []*Event
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{t: t, statuses: statuses, received: make(chan struct{}, 16)}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) handle(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("reading delivery: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(req.Header.Get(HeaderTimestamp) + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.Header.Get(HeaderSignature); !hmac.Equal([]byte(got), []byte(want)) {
+		r.t.Errorf("signature = %q, want %q", got, want)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		r.t.Errorf("stale or missing timestamp %q", req.Header.Get(HeaderTimestamp))
+	}
+
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		r.t.Errorf("delivery is not an event: %v", err)
+	}
+	if req.Header.Get(HeaderEvent) != e.Type || req.Header.Get(HeaderDelivery) != e.ID {
+		r.t.Errorf("headers %v do not match event %s %s", req.Header, e.Type, e.ID)
+	}
+
+	r.mu.Lock()
+	status := http.StatusOK
+	if r.attempts < len(r.statuses) {
+		status = r.statuses[r.attempts]
+	}
+	r.attempts++
+	if status < 300 {
+		r.events = append(r.events, &e)
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+	if status < 300 {
+		r.received <- struct{}{}
+	}
+}
+
+func (r *receiver) attemptCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts
+}
+
+func newTestDispatcher(broker *Broker, maxRetries int, urls ...string) *WebhookDispatcher {
+	cfg := &config.Config{
+		WebhookURLs:       urls,
+		WebhookSecret:     testSecret,
+		WebhookMaxRetries: maxRetries,
+		WebhookTimeout:    5 * time.Second,
+	}
+	d := NewWebhookDispatcher(broker, cfg, testLogger())
+	d.backoff = time.Millisecond
+	return d
+}
+
+func testEvent(id string) *Event {
+	return &Event{ID: id, Type: TypeGroupMemberAdded, Entity: "group", Key: "developers", Member: "jdoe", MemberEntity: "user"}
+}
+
+func TestSign(t *testing.T) {
+	// printf '1700000000.{}' | openssl dgst -sha256 -hmac webhook-secret
+	want := "sha256=1ca80a9e18507c4d07ef99f9a0e139c940cafc2e9520b9c3545e1574139a91f9"
+
+	if got := Sign([]byte(testSecret), "1700000000", []byte("{}")); got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+	if Sign([]byte(testSecret), "1700000001", []byte("{}")) == want {
+		t.Error("Sign() does not cover the timestamp")
+	}
+}
+
+func TestWebhookDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxRetries   int
+		wantAttempts int
+		wantAccepted bool
+	}{
+		{name: "accepted first time", maxRetries: 3, wantAttempts: 1, wantAccepted: true},
+		{name: "server errors are retried", statuses: []int{500, 503}, maxRetries: 3, wantAttempts: 3, wantAccepted: true},
+		{name: "rate limiting is retried", statuses: []int{429}, maxRetries: 3, wantAttempts: 2, wantAccepted: true},
+		{name: "retries run out", statuses: []int{500, 500, 500, 500}, maxRetries: 2, wantAttempts: 3},
+		{name: "client errors are not retried", statuses: []int{400}, maxRetries: 3, wantAttempts: 1},
+		{name: "no retries configured", statuses: []int{502}, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			d := newTestDispatcher(NewBroker(testLogger()), tt.maxRetries, r.URL)
+
+			d.deliver(context.Background(), r.URL, testEvent("evt-1"))
+
+			if got := r.attemptCount(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if accepted := len(r.events) == 1; accepted != tt.wantAccepted {
+				t.Errorf("accepted = %v, want %v", accepted, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliverBackoff(t *testing.T) {
+	r := newReceiver(t, 500, 500, 500)
+	d := newTestDispatcher(NewBroker(testLogger()), 3, r.URL)
+	d.backoff = 20 * time.Millisecond
+
+	// 20ms, 40ms and 80ms between the four attempts
+	start := time.Now()
+	d.deliver(context.Background(), r.URL, testEvent("evt-1"))
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("four attempts took %v, want at least 140ms of backoff", elapsed)
+	}
+	if got := r.attemptCount(); got != 4 {
+		t.Errorf("attempts = %d, want 4", got)
+	}
+}
+
+func TestWebhookDeliverCanceled(t *testing.T) {
+	r := newReceiver(t, 500, 500, 500)
+	d := newTestDispatcher(NewBroker(testLogger()), 3, r.URL)
+	d.backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.deliver(ctx, r.URL, testEvent("evt-1"))
+		close(done)
+	}()
+
+	for r.attemptCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver() kept waiting to retry after the context was canceled")
+	}
+}
+
+func TestWebhookDispatcherRun(t *testing.T) {
+	broker := NewBroker(testLogger())
+	first := newReceiver(t)
+	second := newReceiver(t, 503)
+	d := newTestDispatcher(broker, 3, first.URL, second.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(stopped)
+	}()
+
+	// Run subscribes before it starts delivering
+	for {
+		broker.mu.RLock()
+		subscribed := len(broker.subs)
+		broker.mu.RUnlock()
+		if subscribed == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	broker.Publish(testEvent("evt-1"))
+	broker.Publish(testEvent("evt-2"))
+
+	// Every endpoint gets every event, in order, despite one retrying
+	for _, r := range []*receiver{first, second} {
+		for i := 0; i < 2; i++ {
+			select {
+			case <-r.received:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s received %d of 2 events", r.URL, i)
+			}
+		}
+		r.mu.Lock()
+		if len(r.events) != 2 || r.events[0].ID != "evt-1" || r.events[1].ID != "evt-2" {
+			t.Errorf("%s received %v, want evt-1 then evt-2", r.URL, r.events)
+		}
+		r.mu.Unlock()
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the context was canceled")
+	}
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	if len(broker.subs) != 0 {
+		t.Errorf("Run() left %d subscriptions behind", len(broker.subs))
+	}
+}
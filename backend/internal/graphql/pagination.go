@@ -0,0 +1,196 @@
+package graphql
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 100
+)
+
+// pageArgs are the arguments shared by every paginated listing. Offset
+// paging (limit/offset) and Relay cursor paging (first/after) can be mixed;
+// first takes precedence over limit.
+func pageArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: defaultPageLimit,
+			Description:  "Number of items per page (default: 10, max: 100)",
+		},
+		"offset": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 0,
+			Description:  "Number of items to skip",
+		},
+		"first": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "Number of items to return after the cursor (max: 100)",
+		},
+		"after": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Return items after this cursor (pageInfo.endCursor of the previous page)",
+		},
+	}
+}
+
+// withPageArgs adds the pagination arguments to a field's own arguments
+func withPageArgs(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	for name, arg := range pageArgs() {
+		args[name] = arg
+	}
+	return args
+}
+
+// parsePageRequest reads the pagination arguments of a listing of kind
+func parsePageRequest(p graphql.ResolveParams, kind string) (*models.PageRequest, error) {
+	limit, _ := p.Args["limit"].(int)
+	if first, ok := p.Args["first"].(int); ok {
+		limit = first
+	}
+	offset, _ := p.Args["offset"].(int)
+
+	// Enforce limit constraints
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	page := &models.PageRequest{Limit: limit, Offset: offset}
+	if after, ok := p.Args["after"].(string); ok && after != "" {
+		key, err := decodeCursor(kind, after)
+		if err != nil {
+			return nil, err
+		}
+		page.After = key
+	}
+
+	return page, nil
+}
+
+// encodeCursor returns an opaque cursor for the entry with the given key
+func encodeCursor(kind, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + key))
+}
+
+// decodeCursor returns the key in a cursor issued for a listing of kind
+func decodeCursor(kind, cursor string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor")
+	}
+	prefix, key, ok := strings.Cut(string(raw), ":")
+	if !ok || prefix != kind || key == "" {
+		return "", fmt.Errorf("invalid cursor for %s", kind)
+	}
+	return key, nil
+}
+
+// connection builds the result of a paginated listing. listKey names the
+// legacy list field ("users", "groups", ...) which mirrors items.
+func connection(kind, listKey string, nodes []interface{}, keys []string, page *models.PageRequest, info models.PageInfo) map[string]interface{} {
+	edges := make([]map[string]interface{}, len(nodes))
+	for i, node := range nodes {
+		edges[i] = map[string]interface{}{
+			"cursor": encodeCursor(kind, keys[i]),
+			"node":   node,
+		}
+	}
+
+	pageInfo := map[string]interface{}{
+		"hasNextPage":     info.HasNextPage,
+		"hasPreviousPage": info.HasPreviousPage,
+	}
+	if len(keys) > 0 {
+		pageInfo["startCursor"] = encodeCursor(kind, keys[0])
+		pageInfo["endCursor"] = encodeCursor(kind, keys[len(keys)-1])
+	}
+
+	return map[string]interface{}{
+		"items":    nodes,
+		listKey:    nodes,
+		"edges":    edges,
+		"pageInfo": pageInfo,
+		"total":    info.Total,
+		"limit":    page.Limit,
+		"offset":   page.Offset,
+		"page":     page.Offset/page.Limit + 1,
+		"hasMore":  info.HasNextPage,
+	}
+}
+
+// definePageInfoType defines the PageInfo GraphQL type
+func (s *Schema) definePageInfoType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage":     &graphql.Field{Type: graphql.Boolean},
+			"hasPreviousPage": &graphql.Field{Type: graphql.Boolean},
+			"startCursor":     &graphql.Field{Type: graphql.String},
+			"endCursor":       &graphql.Field{Type: graphql.String, Description: "Pass as after to fetch the next page"},
+		},
+	})
+}
+
+// defineConnectionType defines a paginated listing of nodeType. The list is
+// exposed both as items and, for older clients, under listField.
+func (s *Schema) defineConnectionType(name, listField, description string, nodeType, pageInfoType *graphql.Object) *graphql.Object {
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: nodeType.Name() + "Edge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.String},
+			"node":   &graphql.Field{Type: nodeType},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.Fields{
+			listField: &graphql.Field{
+				Type:        graphql.NewList(nodeType),
+				Description: "List of " + description,
+			},
+			"items": &graphql.Field{
+				Type:        graphql.NewList(nodeType),
+				Description: "List of " + description,
+			},
+			"edges": &graphql.Field{
+				Type: graphql.NewList(edgeType),
+			},
+			"pageInfo": &graphql.Field{
+				Type: pageInfoType,
+			},
+			"total": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Total number of " + description,
+			},
+			"page": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Current page number",
+			},
+			"limit": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Items per page",
+			},
+			"offset": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of items skipped",
+			},
+			"hasMore": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether more items follow this page",
+			},
+		},
+	})
+}
@@ -0,0 +1,447 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+// Keycloak realm roles understood by the policy layer.
+// Every authenticated user is implicitly allowed "self" operations.
+const (
+	RoleAdmin             = "admin"
+	RoleDepartmentManager = "department-manager"
+)
+
+// Error codes returned in the GraphQL "extensions.code" field
+const (
+	ErrCodeUnauthenticated = "UNAUTHENTICATED"
+	ErrCodeForbidden       = "FORBIDDEN"
+)
+
+// AccessError is a GraphQL error carrying a machine-readable code so
+// clients can tell "log in" apart from "not allowed"
+type AccessError struct {
+	Code      string
+	Operation string
+	Reason    string
+}
+
+// Error implements error
+func (e *AccessError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Operation, e.Reason)
+}
+
+// Extensions implements gqlerrors.ExtendedError
+func (e *AccessError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":      e.Code,
+		"operation": e.Operation,
+		"reason":    e.Reason,
+	}
+}
+
+func unauthenticated(operation string) error {
+	return &AccessError{Code: ErrCodeUnauthenticated, Operation: operation, Reason: "a valid bearer token is required"}
+}
+
+func forbidden(operation, reason string) error {
+	return &AccessError{Code: ErrCodeForbidden, Operation: operation, Reason: reason}
+}
+
+// Principal is the authenticated caller of a resolver
+type Principal struct {
+	UID   string
+	Roles []string
+}
+
+// HasRole reports whether the principal holds the given realm role
+func (pr *Principal) HasRole(role string) bool {
+	for _, r := range pr.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the principal may perform any operation
+func (pr *Principal) IsAdmin() bool {
+	return pr.HasRole(RoleAdmin)
+}
+
+// policyRule decides whether the principal may run the operation with the
+// given arguments. It returns nil to allow, or an AccessError to deny.
+type policyRule func(s *Schema, p graphql.ResolveParams, principal *Principal) error
+
+// mutationPolicies lists who besides admins may run each mutation.
+// Mutations missing from this map are admin-only.
+var mutationPolicies = map[string]policyRule{
+	"createUser":               policyCreateUser,
+	"updateUser":               policyUpdateUser,
+	"deleteUser":               policyManageUserArg("uid"),
+	"assignRepoToUser":         policyAssignRepoToUser,
+	"assignRepoToDepartment":   policyAssignRepoToDepartment,
+	"addRepoToUser":            policyAssignRepoToUser,
+	"removeRepoFromUser":       policyManageUserArg("uid"),
+	"addRepoToDepartment":      policyAssignRepoToDepartment,
+	"removeRepoFromDepartment": policyManageDepartmentArg("ou"),
+	"setDepartmentManager":     policyOwnDepartmentArg("ou"),
+	"addDepartmentDeputy":      policyOwnDepartmentArg("ou"),
+	"removeDepartmentDeputy":   policyOwnDepartmentArg("ou"),
+	"changeMyPassword":         policySelf,
+	"resetPassword":            policyManageUserArg("uid"),
+	"disableUser":              policyManageUserArg("uid"),
+	"enableUser":               policyManageUserArg("uid"),
+	"setUserExpiry":            policyManageUserArg("uid"),
+	"deprovisionUser":          policyManageUserArg("uid"),
+	"addSSHKey":                policySelfOrManageUserArg("uid"),
+	"removeSSHKey":             policySelfOrManageUserArg("uid"),
+	"requestRepositoryAccess":  policySelf,
+	"approveAccessRequest":     policyReviewAccessRequest,
+	"denyAccessRequest":        policyReviewAccessRequest,
+}
+
+// authorize wraps a mutation resolver with authentication and the policy
+// registered for it in mutationPolicies
+func (s *Schema) authorize(operation string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		uid := auth.GetUserFromContext(p.Context)
+		if uid == "" {
+			s.logger.WithField("operation", operation).Warn("Rejected unauthenticated mutation")
+			return nil, unauthenticated(operation)
+		}
+
+		principal := &Principal{UID: uid, Roles: auth.GetRolesFromContext(p.Context)}
+
+		if !principal.IsAdmin() {
+			rule, ok := mutationPolicies[operation]
+			if !ok {
+				return nil, s.deny(operation, principal, forbidden(operation, "requires the "+RoleAdmin+" role"))
+			}
+			if err := rule(s, p, principal); err != nil {
+				return nil, s.deny(operation, principal, err)
+			}
+		}
+
+		return resolve(p)
+	}
+}
+
+// requireRole checks that the caller of a query holds the given role
+func (s *Schema) requireRole(p graphql.ResolveParams, role string) error {
+	operation := p.Info.FieldName
+
+	uid := auth.GetUserFromContext(p.Context)
+	if uid == "" {
+		return unauthenticated(operation)
+	}
+
+	principal := &Principal{UID: uid, Roles: auth.GetRolesFromContext(p.Context)}
+	if !principal.HasRole(role) {
+		return s.deny(operation, principal, forbidden(operation, "requires the "+role+" role"))
+	}
+	return nil
+}
+
+// deny logs a rejected operation and returns the error to the client
+func (s *Schema) deny(operation string, principal *Principal, err error) error {
+	s.logger.WithFields(logrus.Fields{
+		"operation": operation,
+		"user":      principal.UID,
+		"roles":     principal.Roles,
+	}).WithError(err).Warn("Permission denied")
+	return err
+}
+
+// managesDepartment reports whether the principal is the department-manager
+// recorded as Manager or a deputy of the given department
+func (s *Schema) managesDepartment(p graphql.ResolveParams, principal *Principal, ou string) bool {
+	dept := s.principalDepartment(p, principal, ou)
+	return dept != nil && dept.ManagedBy(principal.UID)
+}
+
+// ownsDepartment reports whether the principal is the department-manager
+// recorded as Manager of the given department; deputies do not own it
+func (s *Schema) ownsDepartment(p graphql.ResolveParams, principal *Principal, ou string) bool {
+	dept := s.principalDepartment(p, principal, ou)
+	return dept != nil && dept.Manager == principal.UID
+}
+
+// principalDepartment loads a department for a department-manager
+// principal, or returns nil
+func (s *Schema) principalDepartment(p graphql.ResolveParams, principal *Principal, ou string) *models.Department {
+	if ou == "" || !principal.HasRole(RoleDepartmentManager) {
+		return nil
+	}
+
+	dept, err := s.ldapMgr.GetDepartment(p.Context, ou)
+	if err != nil {
+		return nil
+	}
+	return dept
+}
+
+// managesUser reports whether the principal manages the department the
+// given user currently belongs to
+func (s *Schema) managesUser(p graphql.ResolveParams, principal *Principal, uid string) bool {
+	if !principal.HasRole(RoleDepartmentManager) {
+		return false
+	}
+
+	user, err := s.ldapMgr.GetUser(p.Context, uid)
+	if err != nil {
+		return false
+	}
+	return s.managesDepartment(p, principal, user.Department)
+}
+
+// checkGrantableRepos refuses grants a department manager may not hand
+// out: each repository only up to the level a department they manage owns
+// it at, or the target already holds it at, so a manager cannot escalate
+// access beyond their departments. requested is completed against direct,
+// the target's current grants, as the manager will complete it.
+func (s *Schema) checkGrantableRepos(p graphql.ResolveParams, principal *Principal, operation string, requested, direct, held []models.RepositoryGrant) error {
+	if len(requested) == 0 {
+		return nil
+	}
+
+	depts, err := s.ldapMgr.ListDepartments(p.Context)
+	if err != nil {
+		return forbidden(operation, "cannot verify repository ownership")
+	}
+	limits := make(map[string]int)
+	allow := func(grants []models.RepositoryGrant) {
+		for _, grant := range grants {
+			key := strings.ToLower(grant.Repository)
+			if rank := models.PermissionRank(grant.Permission); rank > limits[key] {
+				limits[key] = rank
+			}
+		}
+	}
+	allow(held)
+	for _, dept := range depts {
+		if dept.ManagedBy(principal.UID) {
+			allow(models.EffectiveGrants(time.Now(), dept.Grants))
+		}
+	}
+
+	for _, grant := range models.ResolveGrants(requested, direct) {
+		limit, ok := limits[strings.ToLower(grant.Repository)]
+		if !ok {
+			return forbidden(operation, "repository '"+grant.Repository+"' is not owned by a department you manage")
+		}
+		if models.PermissionRank(grant.Permission) > limit {
+			return forbidden(operation, "cannot grant "+grant.Permission+" on '"+grant.Repository+"' beyond what a department you manage holds")
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// POLICY RULES
+// ============================================================================
+
+func policyCreateUser(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+	input, _ := p.Args["input"].(map[string]interface{})
+	dept, _ := input["department"].(string)
+
+	if !s.managesDepartment(p, principal, dept) {
+		return forbidden("createUser", "only the manager of department '"+dept+"' may create users in it")
+	}
+	requested, err := inputGrants(input)
+	if err != nil {
+		return err
+	}
+	return s.checkGrantableRepos(p, principal, "createUser", requested, nil, nil)
+}
+
+func policyUpdateUser(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+	input, _ := p.Args["input"].(map[string]interface{})
+	uid, _ := input["uid"].(string)
+
+	// Department managers may edit anything on users of their department,
+	// as long as they don't move the user to a department they don't manage
+	if s.managesUser(p, principal, uid) {
+		if dept, ok := input["department"].(string); ok && !s.managesDepartment(p, principal, dept) {
+			return forbidden("updateUser", "cannot move a user into department '"+dept+"'")
+		}
+		requested, err := inputGrants(input)
+		if err != nil {
+			return err
+		}
+		direct, held := s.userGrants(p, uid)
+		return s.checkGrantableRepos(p, principal, "updateUser", requested, direct, held)
+	}
+
+	if uid != principal.UID {
+		return forbidden("updateUser", "users may only update their own profile")
+	}
+
+	if _, ok := input["password"]; ok {
+		return forbidden("updateUser", "use changeMyPassword to change your own password")
+	}
+
+	// Self-service: profile fields only, never access-granting ones
+	for _, field := range []string{"department", "repositories", "grants"} {
+		if _, ok := input[field]; ok {
+			return forbidden("updateUser", "field '"+field+"' can only be changed by an administrator")
+		}
+	}
+	for _, def := range s.config.UserAttributes {
+		if _, ok := input[def.Field]; ok && !def.EditableBySelf {
+			return forbidden("updateUser", "field '"+def.Field+"' can only be changed by an administrator")
+		}
+	}
+	return nil
+}
+
+// policySelf allows any authenticated user; the resolver only ever acts on
+// the caller's own entry
+func policySelf(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+	return nil
+}
+
+// policyManageUserArg allows department managers to act on users of their
+// own department, identified by the named argument
+func policyManageUserArg(arg string) policyRule {
+	return func(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+		uid, _ := p.Args[arg].(string)
+		if !s.managesUser(p, principal, uid) {
+			return forbidden(p.Info.FieldName, "only an administrator or the user's department manager may do this")
+		}
+		return nil
+	}
+}
+
+// policySelfOrManageUserArg allows users to act on their own entry, and
+// department managers on users of their department, identified by the
+// named argument; an omitted argument means the caller
+func policySelfOrManageUserArg(arg string) policyRule {
+	manage := policyManageUserArg(arg)
+	return func(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+		if uid, _ := p.Args[arg].(string); uid == "" || uid == principal.UID {
+			return nil
+		}
+		return manage(s, p, principal)
+	}
+}
+
+// policyAssignRepoToUser allows department managers to grant users of their
+// department the repositories their departments own, by assigning or adding
+// them
+func policyAssignRepoToUser(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+	if err := policyManageUserArg("uid")(s, p, principal); err != nil {
+		return err
+	}
+	uid, _ := p.Args["uid"].(string)
+	requested, err := assignedGrants(p)
+	if err != nil {
+		return err
+	}
+	direct, held := s.userGrants(p, uid)
+	return s.checkGrantableRepos(p, principal, p.Info.FieldName, requested, direct, held)
+}
+
+// userGrants returns the grants a user holds directly, and one grant per
+// repository the user holds by any route
+func (s *Schema) userGrants(p graphql.ResolveParams, uid string) (direct, held []models.RepositoryGrant) {
+	user, err := s.ldapMgr.GetUser(p.Context, uid)
+	if err != nil {
+		return nil, nil
+	}
+	access, err := s.ldapMgr.GetEffectiveAccess(p.Context, uid)
+	if err != nil {
+		return user.Grants, user.Grants
+	}
+	return user.Grants, access.Grants
+}
+
+// inputGrants reads the repositories and grants fields of a user or
+// department input
+func inputGrants(input map[string]interface{}) ([]models.RepositoryGrant, error) {
+	grants, err := grantInputs(input["grants"])
+	if err != nil {
+		return nil, err
+	}
+	return append(models.RepositoryGrants(stringList(input["repositories"])), grants...), nil
+}
+
+// policyAssignRepoToDepartment allows a department's manager to drop its
+// repositories or move ones their departments already own onto it, but not
+// to bring in new ones
+func policyAssignRepoToDepartment(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+	if err := policyManageDepartmentArg("ou")(s, p, principal); err != nil {
+		return err
+	}
+	requested, err := assignedGrants(p)
+	if err != nil {
+		return err
+	}
+	var direct []models.RepositoryGrant
+	if ou, _ := p.Args["ou"].(string); ou != "" {
+		if dept, err := s.ldapMgr.GetDepartment(p.Context, ou); err == nil {
+			direct = dept.Grants
+		}
+	}
+	return s.checkGrantableRepos(p, principal, p.Info.FieldName, requested, direct, nil)
+}
+
+// policyManageDepartmentArg allows a department's manager to act on the
+// department identified by the named argument
+func policyManageDepartmentArg(arg string) policyRule {
+	return func(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+		ou, _ := p.Args[arg].(string)
+		if !s.managesDepartment(p, principal, ou) {
+			return forbidden(p.Info.FieldName, "only an administrator or the manager of department '"+ou+"' may do this")
+		}
+		return nil
+	}
+}
+
+// policyOwnDepartmentArg allows a department's manager, but not its
+// deputies, to hand the department identified by the named argument over
+// or change who deputises for them
+func policyOwnDepartmentArg(arg string) policyRule {
+	return func(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+		ou, _ := p.Args[arg].(string)
+		if !s.ownsDepartment(p, principal, ou) {
+			return forbidden(p.Info.FieldName, "only an administrator or the manager of department '"+ou+"' may do this")
+		}
+		return nil
+	}
+}
+
+// policyReviewAccessRequest allows users holding admin on the requested
+// repository to review any request for it, and the requester's department
+// manager to deny it or approve what they could grant themselves. Nobody
+// reviews their own request.
+func policyReviewAccessRequest(s *Schema, p graphql.ResolveParams, principal *Principal) error {
+	operation := p.Info.FieldName
+	id, _ := p.Args["id"].(string)
+
+	request, err := s.ldapMgr.GetAccessRequest(p.Context, id)
+	if err != nil {
+		return forbidden(operation, "cannot verify access request '"+id+"'")
+	}
+	if request.Requester == principal.UID {
+		return forbidden(operation, "users may not review their own access requests")
+	}
+	if s.administeredRepos(p, principal)[strings.ToLower(request.Repository)] {
+		return nil
+	}
+	if !s.managesUser(p, principal, request.Requester) {
+		return forbidden(operation, "only the requester's department manager or an admin of '"+request.Repository+"' may review this request")
+	}
+	if operation != "approveAccessRequest" {
+		return nil
+	}
+	direct, held := s.userGrants(p, request.Requester)
+	requested := []models.RepositoryGrant{{Repository: request.Repository, Permission: request.Permission}}
+	return s.checkGrantableRepos(p, principal, operation, requested, direct, held)
+}
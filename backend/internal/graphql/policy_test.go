@@ -0,0 +1,399 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeLDAP serves the lookups the policy rules make from fixed data.
+// Methods the policies never call panic through the nil embedded interface.
+type fakeLDAP struct {
+	prometheus.LDAPInterface
+	users       map[string]*models.User
+	departments map[string]*models.Department
+	access      map[string][]models.RepositoryGrant
+	requests    map[string]*models.AccessRequest
+	deleted     []string
+}
+
+func (f *fakeLDAP) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	if user, ok := f.users[uid]; ok {
+		return user, nil
+	}
+	return nil, fmt.Errorf("user %s not found", uid)
+}
+
+func (f *fakeLDAP) GetDepartment(ctx context.Context, ou string) (*models.Department, error) {
+	if dept, ok := f.departments[ou]; ok {
+		return dept, nil
+	}
+	return nil, fmt.Errorf("department %s not found", ou)
+}
+
+func (f *fakeLDAP) ListDepartments(ctx context.Context) ([]*models.Department, error) {
+	depts := make([]*models.Department, 0, len(f.departments))
+	for _, dept := range f.departments {
+		depts = append(depts, dept)
+	}
+	sort.Slice(depts, func(i, j int) bool { return depts[i].OU < depts[j].OU })
+	return depts, nil
+}
+
+func (f *fakeLDAP) GetEffectiveAccess(ctx context.Context, uid string) (*models.EffectiveAccess, error) {
+	if _, ok := f.users[uid]; !ok {
+		return nil, fmt.Errorf("user %s not found", uid)
+	}
+	return &models.EffectiveAccess{UID: uid, Grants: f.access[uid]}, nil
+}
+
+func (f *fakeLDAP) GetAccessRequest(ctx context.Context, id string) (*models.AccessRequest, error) {
+	if request, ok := f.requests[id]; ok {
+		return request, nil
+	}
+	return nil, fmt.Errorf("access request %s not found", id)
+}
+
+func (f *fakeLDAP) DeleteUser(ctx context.Context, uid string) error {
+	f.deleted = append(f.deleted, uid)
+	return nil
+}
+
+func grant(repository, permission string) models.RepositoryGrant {
+	return models.RepositoryGrant{Repository: repository, Permission: permission}
+}
+
+// newFakeLDAP returns two departments: eng, managed by alice with dave as
+// deputy, and sales, managed by mallory. bob and carol are plain members;
+// olivia administers the crm repository without managing anything.
+func newFakeLDAP() *fakeLDAP {
+	return &fakeLDAP{
+		users: map[string]*models.User{
+			"alice":   {UID: "alice", Department: "eng"},
+			"dave":    {UID: "dave", Department: "eng"},
+			"bob":     {UID: "bob", Department: "eng", Grants: []models.RepositoryGrant{grant("web", models.PermissionRead)}},
+			"carol":   {UID: "carol", Department: "sales"},
+			"mallory": {UID: "mallory", Department: "sales"},
+			"olivia":  {UID: "olivia", Department: "sales"},
+		},
+		departments: map[string]*models.Department{
+			"eng": {
+				OU:       "eng",
+				Manager:  "alice",
+				Deputies: []string{"dave"},
+				Grants:   []models.RepositoryGrant{grant("api", models.PermissionAdmin), grant("web", models.PermissionWrite)},
+			},
+			"sales": {
+				OU:      "sales",
+				Manager: "mallory",
+				Grants:  []models.RepositoryGrant{grant("crm", models.PermissionWrite)},
+			},
+		},
+		access: map[string][]models.RepositoryGrant{
+			"alice":  {grant("web", models.PermissionWrite)},
+			"bob":    {grant("web", models.PermissionRead)},
+			"olivia": {grant("crm", models.PermissionAdmin)},
+		},
+		requests: map[string]*models.AccessRequest{
+			"bob-api":   {ID: "bob-api", Requester: "bob", Repository: "api", Permission: models.PermissionAdmin},
+			"bob-web":   {ID: "bob-web", Requester: "bob", Repository: "web", Permission: models.PermissionWrite},
+			"bob-crm":   {ID: "bob-crm", Requester: "bob", Repository: "crm", Permission: models.PermissionRead},
+			"carol-crm": {ID: "carol-crm", Requester: "carol", Repository: "CRM", Permission: models.PermissionWrite},
+			// Only refused because they are their own reviewers
+			"alice-api":  {ID: "alice-api", Requester: "alice", Repository: "api", Permission: models.PermissionRead},
+			"olivia-crm": {ID: "olivia-crm", Requester: "olivia", Repository: "crm", Permission: models.PermissionAdmin},
+		},
+	}
+}
+
+func newTestSchema(t *testing.T, ldapMgr prometheus.LDAPInterface) *Schema {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{
+		UserAttributes: []models.AttributeDefinition{
+			{Field: "phone", LDAPAttribute: "telephoneNumber", EditableBySelf: true},
+			{Field: "costCenter", LDAPAttribute: "departmentNumber"},
+		},
+	}
+	return NewSchema(ldapMgr, nil, nil, nil, cfg, logger)
+}
+
+// principalContext returns a request context authenticated as uid
+func principalContext(uid string, roles ...string) context.Context {
+	ctx := context.WithValue(context.Background(), auth.ContextKeyUser, uid)
+	return context.WithValue(ctx, auth.ContextKeyRoles, roles)
+}
+
+var (
+	admin   = []string{"root", RoleAdmin}
+	alice   = []string{"alice", RoleDepartmentManager}
+	dave    = []string{"dave", RoleDepartmentManager}
+	mallory = []string{"mallory", RoleDepartmentManager}
+	bob     = []string{"bob"}
+	olivia  = []string{"olivia"}
+	nobody  = []string{""}
+)
+
+func grantArgs(grants ...models.RepositoryGrant) []interface{} {
+	args := make([]interface{}, 0, len(grants))
+	for _, g := range grants {
+		args = append(args, map[string]interface{}{"repository": g.Repository, "permission": g.Permission})
+	}
+	return args
+}
+
+func TestMutationPolicies(t *testing.T) {
+	s := newTestSchema(t, newFakeLDAP())
+
+	tests := []struct {
+		name      string
+		operation string
+		principal []string
+		args      map[string]interface{}
+		wantCode  string // empty when allowed
+	}{
+		// createUser
+		{"manager creates in own department", "createUser", alice,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "erin", "department": "eng", "grants": grantArgs(grant("api", models.PermissionWrite))}}, ""},
+		{"deputy creates in department", "createUser", dave,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "erin", "department": "eng"}}, ""},
+		{"manager creates in other department", "createUser", alice,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "erin", "department": "sales"}}, ErrCodeForbidden},
+		{"manager grants a repository not owned", "createUser", alice,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "erin", "department": "eng", "repositories": []interface{}{"crm"}}}, ErrCodeForbidden},
+		{"user creates", "createUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "erin", "department": "eng"}}, ErrCodeForbidden},
+
+		// updateUser
+		{"user updates own profile", "updateUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "email": "bob@example.com", "phone": "123"}}, ""},
+		{"user updates someone else", "updateUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "carol", "email": "x@example.com"}}, ErrCodeForbidden},
+		{"user changes own department", "updateUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "department": "sales"}}, ErrCodeForbidden},
+		{"user grants self repositories", "updateUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "repositories": []interface{}{"api"}}}, ErrCodeForbidden},
+		{"user sets own password", "updateUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "password": "secret"}}, ErrCodeForbidden},
+		{"user sets admin-only attribute", "updateUser", bob,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "costCenter": "42"}}, ErrCodeForbidden},
+		{"manager updates department member grants", "updateUser", alice,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "costCenter": "42", "grants": grantArgs(grant("api", models.PermissionAdmin))}}, ""},
+		{"manager escalates beyond department", "updateUser", alice,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "grants": grantArgs(grant("web", models.PermissionAdmin))}}, ErrCodeForbidden},
+		{"manager moves member out", "updateUser", alice,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "department": "sales"}}, ErrCodeForbidden},
+		{"manager updates other department member", "updateUser", mallory,
+			map[string]interface{}{"input": map[string]interface{}{"uid": "bob", "costCenter": "42"}}, ErrCodeForbidden},
+
+		// deleteUser
+		{"manager deletes member", "deleteUser", alice, map[string]interface{}{"uid": "bob"}, ""},
+		{"deputy deletes member", "deleteUser", dave, map[string]interface{}{"uid": "bob"}, ""},
+		{"manager deletes other department member", "deleteUser", mallory, map[string]interface{}{"uid": "bob"}, ErrCodeForbidden},
+		{"user deletes self", "deleteUser", bob, map[string]interface{}{"uid": "bob"}, ErrCodeForbidden},
+
+		// assignRepoToUser
+		{"manager assigns owned repositories", "assignRepoToUser", alice,
+			map[string]interface{}{"uid": "bob", "repositories": []interface{}{"web"}, "grants": grantArgs(grant("api", models.PermissionAdmin))}, ""},
+		{"manager assigns above department level", "assignRepoToUser", alice,
+			map[string]interface{}{"uid": "bob", "grants": grantArgs(grant("web", models.PermissionAdmin))}, ErrCodeForbidden},
+		{"manager assigns repository not owned", "assignRepoToUser", alice,
+			map[string]interface{}{"uid": "bob", "repositories": []interface{}{"crm"}}, ErrCodeForbidden},
+		{"manager assigns to other department member", "assignRepoToUser", mallory,
+			map[string]interface{}{"uid": "bob", "repositories": []interface{}{"crm"}}, ErrCodeForbidden},
+
+		// addRepoToUser
+		{"manager adds owned repository", "addRepoToUser", alice,
+			map[string]interface{}{"uid": "bob", "grants": grantArgs(grant("web", models.PermissionWrite))}, ""},
+		{"manager adds repository not owned", "addRepoToUser", alice,
+			map[string]interface{}{"uid": "bob", "grants": grantArgs(grant("crm", models.PermissionRead))}, ErrCodeForbidden},
+		{"user adds to self", "addRepoToUser", bob,
+			map[string]interface{}{"uid": "bob", "repositories": []interface{}{"web"}}, ErrCodeForbidden},
+
+		// removeRepoFromUser
+		{"manager removes member repository", "removeRepoFromUser", alice,
+			map[string]interface{}{"uid": "bob", "repositories": []interface{}{"web"}}, ""},
+		{"manager removes other department member repository", "removeRepoFromUser", mallory,
+			map[string]interface{}{"uid": "bob", "repositories": []interface{}{"web"}}, ErrCodeForbidden},
+
+		// assignRepoToDepartment
+		{"manager reassigns owned repositories", "assignRepoToDepartment", alice,
+			map[string]interface{}{"ou": "eng", "grants": grantArgs(grant("web", models.PermissionWrite))}, ""},
+		{"manager brings in a new repository", "assignRepoToDepartment", alice,
+			map[string]interface{}{"ou": "eng", "repositories": []interface{}{"crm"}}, ErrCodeForbidden},
+		{"manager assigns to other department", "assignRepoToDepartment", mallory,
+			map[string]interface{}{"ou": "eng", "repositories": []interface{}{"crm"}}, ErrCodeForbidden},
+
+		// addRepoToDepartment
+		{"manager adds owned repository to department", "addRepoToDepartment", alice,
+			map[string]interface{}{"ou": "eng", "repositories": []interface{}{"api"}}, ""},
+		{"manager raises department level", "addRepoToDepartment", alice,
+			map[string]interface{}{"ou": "eng", "grants": grantArgs(grant("web", models.PermissionAdmin))}, ErrCodeForbidden},
+
+		// removeRepoFromDepartment
+		{"deputy removes department repository", "removeRepoFromDepartment", dave,
+			map[string]interface{}{"ou": "eng", "repositories": []interface{}{"web"}}, ""},
+		{"manager removes other department repository", "removeRepoFromDepartment", mallory,
+			map[string]interface{}{"ou": "eng", "repositories": []interface{}{"web"}}, ErrCodeForbidden},
+
+		// setDepartmentManager, addDepartmentDeputy, removeDepartmentDeputy
+		{"manager hands department over", "setDepartmentManager", alice, map[string]interface{}{"ou": "eng", "uid": "dave"}, ""},
+		{"deputy hands department over", "setDepartmentManager", dave, map[string]interface{}{"ou": "eng", "uid": "dave"}, ErrCodeForbidden},
+		{"manager adds deputy", "addDepartmentDeputy", alice, map[string]interface{}{"ou": "eng", "uid": "bob"}, ""},
+		{"deputy adds deputy", "addDepartmentDeputy", dave, map[string]interface{}{"ou": "eng", "uid": "bob"}, ErrCodeForbidden},
+		{"manager removes deputy", "removeDepartmentDeputy", alice, map[string]interface{}{"ou": "eng", "uid": "dave"}, ""},
+		{"other manager removes deputy", "removeDepartmentDeputy", mallory, map[string]interface{}{"ou": "eng", "uid": "dave"}, ErrCodeForbidden},
+
+		// changeMyPassword and requestRepositoryAccess act on the caller
+		{"user changes own password", "changeMyPassword", bob, map[string]interface{}{"oldPassword": "a", "newPassword": "b"}, ""},
+		{"anonymous changes password", "changeMyPassword", nobody, map[string]interface{}{"oldPassword": "a", "newPassword": "b"}, ErrCodeUnauthenticated},
+		{"user requests access", "requestRepositoryAccess", bob, map[string]interface{}{"repository": "api"}, ""},
+		{"anonymous requests access", "requestRepositoryAccess", nobody, map[string]interface{}{"repository": "api"}, ErrCodeUnauthenticated},
+
+		// account lifecycle
+		{"manager resets member password", "resetPassword", alice, map[string]interface{}{"uid": "bob"}, ""},
+		{"user resets own password", "resetPassword", bob, map[string]interface{}{"uid": "bob"}, ErrCodeForbidden},
+		{"manager disables member", "disableUser", alice, map[string]interface{}{"uid": "bob"}, ""},
+		{"manager disables other department member", "disableUser", mallory, map[string]interface{}{"uid": "bob"}, ErrCodeForbidden},
+		{"manager enables member", "enableUser", dave, map[string]interface{}{"uid": "bob"}, ""},
+		{"user enables self", "enableUser", bob, map[string]interface{}{"uid": "bob"}, ErrCodeForbidden},
+		{"manager sets member expiry", "setUserExpiry", alice, map[string]interface{}{"uid": "bob"}, ""},
+		{"manager sets unknown user expiry", "setUserExpiry", alice, map[string]interface{}{"uid": "ghost"}, ErrCodeForbidden},
+		{"manager deprovisions member", "deprovisionUser", alice, map[string]interface{}{"uid": "bob"}, ""},
+		{"manager deprovisions other department member", "deprovisionUser", mallory, map[string]interface{}{"uid": "bob"}, ErrCodeForbidden},
+
+		// SSH keys
+		{"user adds own key", "addSSHKey", bob, map[string]interface{}{"key": "ssh-ed25519 AAAA"}, ""},
+		{"user adds key for someone else", "addSSHKey", bob, map[string]interface{}{"uid": "carol", "key": "ssh-ed25519 AAAA"}, ErrCodeForbidden},
+		{"manager adds member key", "addSSHKey", alice, map[string]interface{}{"uid": "bob", "key": "ssh-ed25519 AAAA"}, ""},
+		{"user removes own key by uid", "removeSSHKey", bob, map[string]interface{}{"uid": "bob", "fingerprint": "SHA256:x"}, ""},
+		{"manager removes other department member key", "removeSSHKey", mallory, map[string]interface{}{"uid": "bob", "fingerprint": "SHA256:x"}, ErrCodeForbidden},
+
+		// approveAccessRequest
+		{"repository admin approves", "approveAccessRequest", olivia, map[string]interface{}{"id": "bob-crm"}, ""},
+		{"repository admin approves despite case", "approveAccessRequest", olivia, map[string]interface{}{"id": "carol-crm"}, ""},
+		{"manager approves what they could grant", "approveAccessRequest", alice, map[string]interface{}{"id": "bob-api"}, ""},
+		{"deputy approves what they could grant", "approveAccessRequest", dave, map[string]interface{}{"id": "bob-web"}, ""},
+		{"manager approves repository not owned", "approveAccessRequest", alice, map[string]interface{}{"id": "bob-crm"}, ErrCodeForbidden},
+		{"other manager approves", "approveAccessRequest", mallory, map[string]interface{}{"id": "bob-web"}, ErrCodeForbidden},
+		{"requester approves own request", "approveAccessRequest", bob, map[string]interface{}{"id": "bob-web"}, ErrCodeForbidden},
+		{"manager approves own request", "approveAccessRequest", alice, map[string]interface{}{"id": "alice-api"}, ErrCodeForbidden},
+		{"repository admin approves own request", "approveAccessRequest", olivia, map[string]interface{}{"id": "olivia-crm"}, ErrCodeForbidden},
+		{"approve unknown request", "approveAccessRequest", alice, map[string]interface{}{"id": "missing"}, ErrCodeForbidden},
+
+		// denyAccessRequest: a manager may deny what they could not approve
+		{"manager denies repository not owned", "denyAccessRequest", alice, map[string]interface{}{"id": "bob-crm"}, ""},
+		{"repository admin denies", "denyAccessRequest", olivia, map[string]interface{}{"id": "carol-crm"}, ""},
+		{"repository admin denies own department request", "denyAccessRequest", mallory, map[string]interface{}{"id": "carol-crm"}, ""},
+		{"user denies someone else's request", "denyAccessRequest", bob, map[string]interface{}{"id": "carol-crm"}, ErrCodeForbidden},
+		{"manager denies own request", "denyAccessRequest", alice, map[string]interface{}{"id": "alice-api"}, ErrCodeForbidden},
+
+		// Mutations without a policy are admin-only
+		{"admin runs admin-only mutation", "deleteGroup", admin, map[string]interface{}{"cn": "developers"}, ""},
+		{"manager runs admin-only mutation", "deleteGroup", alice, map[string]interface{}{"cn": "developers"}, ErrCodeForbidden},
+		{"admin bypasses policies", "setDepartmentManager", admin, map[string]interface{}{"ou": "eng", "uid": "bob"}, ""},
+	}
+
+	covered := make(map[string]map[bool]bool)
+	for _, tt := range tests {
+		t.Run(tt.operation+"/"+tt.name, func(t *testing.T) {
+			called := false
+			resolve := s.authorize(tt.operation, func(p graphql.ResolveParams) (interface{}, error) {
+				called = true
+				return true, nil
+			})
+
+			_, err := resolve(graphql.ResolveParams{
+				Context: principalContext(tt.principal[0], tt.principal[1:]...),
+				Args:    tt.args,
+				Info:    graphql.ResolveInfo{FieldName: tt.operation},
+			})
+
+			if tt.wantCode == "" {
+				if err != nil || !called {
+					t.Fatalf("denied: %v", err)
+				}
+				return
+			}
+			var accessErr *AccessError
+			if !errors.As(err, &accessErr) || accessErr.Code != tt.wantCode {
+				t.Fatalf("error = %v, want %s", err, tt.wantCode)
+			}
+			if called {
+				t.Error("resolver ran for a denied mutation")
+			}
+		})
+
+		if covered[tt.operation] == nil {
+			covered[tt.operation] = make(map[bool]bool)
+		}
+		covered[tt.operation][tt.wantCode == ""] = true
+	}
+
+	for operation := range mutationPolicies {
+		if !covered[operation][true] || !covered[operation][false] {
+			t.Errorf("%s needs both an allowed and a denied case", operation)
+		}
+	}
+}
+
+func TestMutationPoliciesExist(t *testing.T) {
+	s := newTestSchema(t, newFakeLDAP())
+	fields := s.schema.MutationType().Fields()
+	for operation := range mutationPolicies {
+		if _, ok := fields[operation]; !ok {
+			t.Errorf("mutationPolicies has %s, which is not a mutation", operation)
+		}
+	}
+}
+
+func TestMutationsAreAuthorized(t *testing.T) {
+	ldapMgr := newFakeLDAP()
+	s := newTestSchema(t, ldapMgr)
+
+	tests := []struct {
+		name        string
+		principal   []string
+		wantCode    string
+		wantDeleted bool
+	}{
+		{name: "anonymous", principal: nobody, wantCode: ErrCodeUnauthenticated},
+		{name: "other department manager", principal: mallory, wantCode: ErrCodeForbidden},
+		{name: "department manager", principal: alice, wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ldapMgr.deleted = nil
+			result := graphql.Do(graphql.Params{
+				Schema:        s.GetSchema(),
+				RequestString: `mutation { deleteUser(uid: "bob") }`,
+				Context:       principalContext(tt.principal[0], tt.principal[1:]...),
+			})
+
+			if deleted := len(ldapMgr.deleted) > 0; deleted != tt.wantDeleted {
+				t.Errorf("DeleteUser called = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if tt.wantCode == "" {
+				if len(result.Errors) > 0 {
+					t.Fatalf("errors = %v", result.Errors)
+				}
+				return
+			}
+			if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != tt.wantCode {
+				t.Fatalf("errors = %v, want code %s", result.Errors, tt.wantCode)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package graphql
 
 import (
+        "github.com/devplatform/ldap-manager/internal/audit"
+        "github.com/devplatform/ldap-manager/internal/backup"
+        "github.com/devplatform/ldap-manager/internal/bulk"
         "github.com/devplatform/ldap-manager/internal/config"
+        "github.com/devplatform/ldap-manager/internal/events"
         "github.com/devplatform/ldap-manager/internal/prometheus"
         "github.com/graphql-go/graphql"
         "github.com/sirupsen/logrus"
@@ -9,39 +13,69 @@ import (
 
 // Schema represents the GraphQL schema
 type Schema struct {
-        schema  graphql.Schema
-        ldapMgr prometheus.LDAPInterface
-        config  *config.Config
-        logger  *logrus.Logger
+        schema     graphql.Schema
+        ldapMgr    prometheus.LDAPInterface
+        auditStore audit.Store
+        importer   *bulk.Importer
+        broker     *events.Broker
+        backups    *backup.Snapshotter
+        config     *config.Config
+        logger     *logrus.Logger
 }
 
-// NewSchema creates a new GraphQL schema
-func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *logrus.Logger) *Schema {
+// NewSchema creates a new GraphQL schema. broker may be nil when directory
+// events are disabled.
+func NewSchema(ldapMgr prometheus.LDAPInterface, auditStore audit.Store, broker *events.Broker, backups *backup.Snapshotter, cfg *config.Config, logger *logrus.Logger) *Schema {
         s := &Schema{
-                ldapMgr: ldapMgr,
-                config:  cfg,
-                logger:  logger,
+                ldapMgr:    ldapMgr,
+                auditStore: auditStore,
+                importer:   bulk.NewImporter(ldapMgr, logger),
+                broker:     broker,
+                backups:    backups,
+                config:     cfg,
+                logger:     logger,
         }
 
         // Define types
-        userType := s.defineUserType()
-        departmentType := s.defineDepartmentType()
-        groupType := s.defineGroupType()
+        sshKeyType := s.defineSSHKeyType()
+        repositoryPermissionEnum := s.defineRepositoryPermissionEnum()
+        repositoryGrantType := s.defineRepositoryGrantType(repositoryPermissionEnum)
+        userType := s.defineUserType(sshKeyType, repositoryGrantType)
+        passwordResetType := s.definePasswordResetType()
+        deprovisionResultType := s.defineDeprovisionResultType(userType)
+        departmentType := s.defineDepartmentType(repositoryGrantType)
+        groupType := s.defineGroupType(repositoryGrantType)
+        grantMigrationResultType := s.defineGrantMigrationResultType()
+        backupSnapshotType := s.defineBackupSnapshotType()
+        accessRequestStatusEnum := s.defineAccessRequestStatusEnum()
+        accessRequestType := s.defineAccessRequestType(accessRequestStatusEnum, repositoryPermissionEnum)
         statsType := s.defineStatsType()
         healthType := s.defineHealthType()
+        attributeChangeType := s.defineAttributeChangeType()
+        auditRecordType := s.defineAuditRecordType(attributeChangeType)
+        directoryEventType := s.defineDirectoryEventType(attributeChangeType)
+        importReportType := s.defineImportReportType(s.defineImportRowResultType())
+        directorySearchResultType := s.defineDirectorySearchResultType(userType, groupType, departmentType)
+        bulkFormatEnum := s.defineBulkFormatEnum()
 
         // Define paginated types
-        paginatedUsersType := s.definePaginatedUsersType(userType)
-        paginatedDepartmentsType := s.definePaginatedDepartmentsType(departmentType)
-        paginatedGroupsType := s.definePaginatedGroupsType(groupType)
+        pageInfoType := s.definePageInfoType()
+        paginatedUsersType := s.definePaginatedUsersType(userType, pageInfoType)
+        paginatedDepartmentsType := s.definePaginatedDepartmentsType(departmentType, pageInfoType)
+        paginatedGroupsType := s.definePaginatedGroupsType(groupType, pageInfoType)
+        paginatedAuditLogType := s.definePaginatedAuditLogType(auditRecordType)
 
         // Define input types
-        createUserInputType := s.defineCreateUserInput()
-        updateUserInputType := s.defineUpdateUserInput()
-        createDepartmentInputType := s.defineCreateDepartmentInput()
-        searchFilterInputType := s.defineSearchFilterInput()
+        repositoryGrantInputType := s.defineRepositoryGrantInput(repositoryPermissionEnum)
+        createUserInputType := s.defineCreateUserInput(repositoryGrantInputType)
+        updateUserInputType := s.defineUpdateUserInput(repositoryGrantInputType)
+        createDepartmentInputType := s.defineCreateDepartmentInput(repositoryGrantInputType)
+        filterConditionInputType := s.defineFilterConditionInput(s.defineMatchOperatorEnum())
+        filterExpressionInputType := s.defineFilterExpressionInput(filterConditionInputType, s.defineLogicalOperatorEnum())
+        searchFilterInputType := s.defineSearchFilterInput(filterExpressionInputType)
         departmentFilterInputType := s.defineDepartmentFilterInput()
         groupFilterInputType := s.defineGroupFilterInput()
+        auditLogFilterInputType := s.defineAuditLogFilterInput()
 
         // Define root query
         queryType := graphql.NewObject(graphql.ObjectConfig{
@@ -51,6 +85,11 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                                 Type:    userType,
                                 Resolve: s.resolveMe,
                         },
+                        "mySSHKeys": &graphql.Field{
+                                Type:        graphql.NewList(sshKeyType),
+                                Description: "The caller's SSH public keys",
+                                Resolve:     s.resolveMySSHKeys,
+                        },
                         "user": &graphql.Field{
                                 Type: userType,
                                 Args: graphql.FieldConfigArgument{
@@ -62,22 +101,12 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                         },
                         "users": &graphql.Field{
                                 Type: paginatedUsersType,
-                                Args: graphql.FieldConfigArgument{
+                                Args: withPageArgs(graphql.FieldConfigArgument{
                                         "filter": &graphql.ArgumentConfig{
                                                 Type:        searchFilterInputType,
                                                 Description: "Search filter for users",
                                         },
-                                        "limit": &graphql.ArgumentConfig{
-                                                Type:         graphql.Int,
-                                                DefaultValue: 10,
-                                                Description:  "Number of items per page (default: 10, max: 100)",
-                                        },
-                                        "offset": &graphql.ArgumentConfig{
-                                                Type:         graphql.Int,
-                                                DefaultValue: 0,
-                                                Description:  "Number of items to skip",
-                                        },
-                                },
+                                }),
                                 Resolve: s.resolveUsers,
                         },
                         "usersAll": &graphql.Field{
@@ -85,6 +114,35 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                                 Description: "Get all users without pagination (for microservice calls)",
                                 Resolve:     s.resolveUsersAll,
                         },
+                        "exportUsers": &graphql.Field{
+                                Type:        graphql.String,
+                                Description: "Export users as CSV or LDIF, without passwords (admin only)",
+                                Args: graphql.FieldConfigArgument{
+                                        "format": &graphql.ArgumentConfig{
+                                                Type: graphql.NewNonNull(bulkFormatEnum),
+                                        },
+                                        "filter": &graphql.ArgumentConfig{
+                                                Type:        searchFilterInputType,
+                                                Description: "Search filter for users",
+                                        },
+                                },
+                                Resolve: s.resolveExportUsers,
+                        },
+                        "searchDirectory": &graphql.Field{
+                                Type:        graphql.NewList(directorySearchResultType),
+                                Description: "Users, groups and departments matching every term of the query, best matches first",
+                                Args: graphql.FieldConfigArgument{
+                                        "query": &graphql.ArgumentConfig{
+                                                Type: graphql.NewNonNull(graphql.String),
+                                        },
+                                        "limit": &graphql.ArgumentConfig{
+                                                Type:         graphql.Int,
+                                                DefaultValue: 20,
+                                                Description:  "Maximum number of results (default: 20, max: 100)",
+                                        },
+                                },
+                                Resolve: s.resolveSearchDirectory,
+                        },
                         "department": &graphql.Field{
                                 Type: departmentType,
                                 Args: graphql.FieldConfigArgument{
@@ -96,22 +154,12 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                         },
                         "departments": &graphql.Field{
                                 Type: paginatedDepartmentsType,
-                                Args: graphql.FieldConfigArgument{
+                                Args: withPageArgs(graphql.FieldConfigArgument{
                                         "filter": &graphql.ArgumentConfig{
                                                 Type:        departmentFilterInputType,
                                                 Description: "Filter departments by name or description",
                                         },
-                                        "limit": &graphql.ArgumentConfig{
-                                                Type:         graphql.Int,
-                                                DefaultValue: 10,
-                                                Description:  "Number of items per page (default: 10, max: 100)",
-                                        },
-                                        "offset": &graphql.ArgumentConfig{
-                                                Type:         graphql.Int,
-                                                DefaultValue: 0,
-                                                Description:  "Number of items to skip",
-                                        },
-                                },
+                                }),
                                 Resolve: s.resolveDepartments,
                         },
                         "departmentsAll": &graphql.Field{
@@ -119,24 +167,19 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                                 Description: "Get all departments without pagination (for microservice calls)",
                                 Resolve:     s.resolveDepartmentsAll,
                         },
+                        "myManagedDepartments": &graphql.Field{
+                                Type:        graphql.NewList(departmentType),
+                                Description: "Departments the caller manages or is a deputy of",
+                                Resolve:     s.resolveMyManagedDepartments,
+                        },
                         "departmentUsers": &graphql.Field{
                                 Type: paginatedUsersType,
-                                Args: graphql.FieldConfigArgument{
+                                Args: withPageArgs(graphql.FieldConfigArgument{
                                         "department": &graphql.ArgumentConfig{
                                                 Type:        graphql.NewNonNull(graphql.String),
                                                 Description: "Department name to filter by",
                                         },
-                                        "limit": &graphql.ArgumentConfig{
-                                                Type:         graphql.Int,
-                                                DefaultValue: 10,
-                                                Description:  "Number of items per page (default: 10, max: 100)",
-                                        },
-                                        "offset": &graphql.ArgumentConfig{
-                                                Type:         graphql.Int,
-                                                DefaultValue: 0,
-                                                Description:  "Number of items to skip",
-                                        },
-                                },
+                                }),
                                 Resolve: s.resolveDepartmentUsers,
                         },
                         "group": &graphql.Field{
@@ -150,11 +193,53 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                         },
                         "groups": &graphql.Field{
                                 Type: paginatedGroupsType,
-                                Args: graphql.FieldConfigArgument{
+                                Args: withPageArgs(graphql.FieldConfigArgument{
                                         "filter": &graphql.ArgumentConfig{
                                                 Type:        groupFilterInputType,
                                                 Description: "Filter groups by name",
                                         },
+                                }),
+                                Resolve: s.resolveGroups,
+                        },
+                        "groupsAll": &graphql.Field{
+                                Type:        graphql.NewList(groupType),
+                                Description: "Get all groups without pagination (for microservice calls)",
+                                Resolve:     s.resolveGroupsAll,
+                        },
+                        "accessRequests": &graphql.Field{
+                                Type:        graphql.NewList(accessRequestType),
+                                Description: "Repository access requests, newest first: all of them for admins, otherwise your own and those you may review",
+                                Args: graphql.FieldConfigArgument{
+                                        "status": &graphql.ArgumentConfig{
+                                                Type: accessRequestStatusEnum,
+                                        },
+                                        "requester": &graphql.ArgumentConfig{
+                                                Type: graphql.String,
+                                        },
+                                        "repository": &graphql.ArgumentConfig{
+                                                Type: graphql.String,
+                                        },
+                                },
+                                Resolve: s.resolveAccessRequests,
+                        },
+                        "myAccessRequests": &graphql.Field{
+                                Type:        graphql.NewList(accessRequestType),
+                                Description: "The caller's repository access requests, newest first",
+                                Args: graphql.FieldConfigArgument{
+                                        "status": &graphql.ArgumentConfig{
+                                                Type: accessRequestStatusEnum,
+                                        },
+                                },
+                                Resolve: s.resolveMyAccessRequests,
+                        },
+                        "auditLog": &graphql.Field{
+                                Type:        paginatedAuditLogType,
+                                Description: "History of directory changes (admin only)",
+                                Args: graphql.FieldConfigArgument{
+                                        "filter": &graphql.ArgumentConfig{
+                                                Type:        auditLogFilterInputType,
+                                                Description: "Filter audit records",
+                                        },
                                         "limit": &graphql.ArgumentConfig{
                                                 Type:         graphql.Int,
                                                 DefaultValue: 10,
@@ -166,12 +251,12 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
                                                 Description:  "Number of items to skip",
                                         },
                                 },
-                                Resolve: s.resolveGroups,
+                                Resolve: s.resolveAuditLog,
                         },
-                        "groupsAll": &graphql.Field{
-                                Type:        graphql.NewList(groupType),
-                                Description: "Get all groups without pagination (for microservice calls)",
-                                Resolve:     s.resolveGroupsAll,
+                        "backups": &graphql.Field{
+                                Type:        graphql.NewList(backupSnapshotType),
+                                Description: "LDIF snapshots of the directory, newest first (admin only)",
+                                Resolve:     s.resolveBackups,
                         },
                         "health": &graphql.Field{
                                 Type:    healthType,
@@ -185,142 +270,523 @@ func NewSchema(ldapMgr prometheus.LDAPInterface, cfg *config.Config, logger *log
         })
 
         // Define root mutation
-        mutationType := graphql.NewObject(graphql.ObjectConfig{
-                Name: "Mutation",
-                Fields: graphql.Fields{
-                        "createUser": &graphql.Field{
-                                Type: userType,
-                                Args: graphql.FieldConfigArgument{
-                                        "input": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(createUserInputType),
-                                        },
+        mutationFields := graphql.Fields{
+                "createUser": &graphql.Field{
+                        Type: userType,
+                        Args: graphql.FieldConfigArgument{
+                                "input": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(createUserInputType),
                                 },
-                                Resolve: s.resolveCreateUser,
                         },
-                        "updateUser": &graphql.Field{
-                                Type: userType,
-                                Args: graphql.FieldConfigArgument{
-                                        "input": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(updateUserInputType),
-                                        },
+                        Resolve: s.resolveCreateUser,
+                },
+                "importUsers": &graphql.Field{
+                        Type:        importReportType,
+                        Description: "Create or upsert users from CSV or LDIF; all rows are validated first and partial writes are rolled back",
+                        Args: graphql.FieldConfigArgument{
+                                "format": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(bulkFormatEnum),
+                                },
+                                "data": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewNonNull(graphql.String),
+                                        Description: "File contents",
+                                },
+                                "dryRun": &graphql.ArgumentConfig{
+                                        Type:         graphql.Boolean,
+                                        DefaultValue: false,
+                                        Description:  "Validate and report without writing",
+                                },
+                                "upsert": &graphql.ArgumentConfig{
+                                        Type:         graphql.Boolean,
+                                        DefaultValue: false,
+                                        Description:  "Update users that already exist",
                                 },
-                                Resolve: s.resolveUpdateUser,
                         },
-                        "deleteUser": &graphql.Field{
-                                Type: graphql.Boolean,
-                                Args: graphql.FieldConfigArgument{
-                                        "uid": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
+                        Resolve: s.resolveImportUsers,
+                },
+                "updateUser": &graphql.Field{
+                        Type: userType,
+                        Args: graphql.FieldConfigArgument{
+                                "input": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(updateUserInputType),
                                 },
-                                Resolve: s.resolveDeleteUser,
                         },
-                        "createDepartment": &graphql.Field{
-                                Type: departmentType,
-                                Args: graphql.FieldConfigArgument{
-                                        "input": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(createDepartmentInputType),
-                                        },
+                        Resolve: s.resolveUpdateUser,
+                },
+                "deleteUser": &graphql.Field{
+                        Type: graphql.Boolean,
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
                                 },
-                                Resolve: s.resolveCreateDepartment,
                         },
-                        "deleteDepartment": &graphql.Field{
-                                Type: graphql.Boolean,
-                                Args: graphql.FieldConfigArgument{
-                                        "ou": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
+                        Resolve: s.resolveDeleteUser,
+                },
+                "changeMyPassword": &graphql.Field{
+                        Type:        graphql.Boolean,
+                        Description: "Change the caller's own password; the current password is verified first",
+                        Args: graphql.FieldConfigArgument{
+                                "oldPassword": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "newPassword": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
                                 },
-                                Resolve: s.resolveDeleteDepartment,
                         },
-                        "assignRepoToDepartment": &graphql.Field{
-                                Type: departmentType,
-                                Args: graphql.FieldConfigArgument{
-                                        "ou": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
-                                        "repositories": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
-                                        },
+                        Resolve: s.resolveChangeMyPassword,
+                },
+                "resetPassword": &graphql.Field{
+                        Type:        passwordResetType,
+                        Description: "Replace a user's password with a one-time temporary password",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
                                 },
-                                Resolve: s.resolveAssignRepoToDepartment,
                         },
-                        "assignRepoToUser": &graphql.Field{
-                                Type: userType,
-                                Args: graphql.FieldConfigArgument{
-                                        "uid": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
-                                        "repositories": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
-                                        },
+                        Resolve: s.resolveResetPassword,
+                },
+                "disableUser": &graphql.Field{
+                        Type:        userType,
+                        Description: "Disable an account; its password no longer works",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
                                 },
-                                Resolve: s.resolveAssignRepoToUser,
                         },
-                        "assignRepoToGroup": &graphql.Field{
-                                Type: groupType,
-                                Args: graphql.FieldConfigArgument{
-                                        "groupCn": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
-                                        "repositories": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
-                                        },
+                        Resolve: s.resolveDisableUser,
+                },
+                "enableUser": &graphql.Field{
+                        Type:        userType,
+                        Description: "Re-enable an account and cancel a pending deletion",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
                                 },
-                                Resolve: s.resolveAssignRepoToGroup,
                         },
-                        "createGroup": &graphql.Field{
-                                Type: groupType,
-                                Args: graphql.FieldConfigArgument{
-                                        "cn": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
-                                        "description": &graphql.ArgumentConfig{
-                                                Type: graphql.String,
-                                        },
+                        Resolve: s.resolveEnableUser,
+                },
+                "setUserExpiry": &graphql.Field{
+                        Type:        userType,
+                        Description: "Set when an account is disabled automatically; null clears it",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "expiresAt": &graphql.ArgumentConfig{
+                                        Type:        graphql.String,
+                                        Description: "RFC3339 timestamp",
                                 },
-                                Resolve: s.resolveCreateGroup,
                         },
-                        "addUserToGroup": &graphql.Field{
-                                Type: graphql.Boolean,
-                                Args: graphql.FieldConfigArgument{
-                                        "uid": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
-                                        "groupCn": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
+                        Resolve: s.resolveSetUserExpiry,
+                },
+                "deprovisionUser": &graphql.Field{
+                        Type:        deprovisionResultType,
+                        Description: "Disable an account, remove its group memberships and repository grants, and delete it after the grace period",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
                                 },
-                                Resolve: s.resolveAddUserToGroup,
                         },
-                        "removeUserFromGroup": &graphql.Field{
-                                Type: graphql.Boolean,
-                                Args: graphql.FieldConfigArgument{
-                                        "uid": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
-                                        "groupCn": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
-                                        },
+                        Resolve: s.resolveDeprovisionUser,
+                },
+                "addSSHKey": &graphql.Field{
+                        Type:        sshKeyType,
+                        Description: "Add an SSH public key; a key can only be registered to one user",
+                        Args: graphql.FieldConfigArgument{
+                                "key": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewNonNull(graphql.String),
+                                        Description: "authorized_keys line, e.g. the contents of id_ed25519.pub",
+                                },
+                                "uid": &graphql.ArgumentConfig{
+                                        Type:        graphql.String,
+                                        Description: "User to add the key to; the caller when omitted",
+                                },
+                        },
+                        Resolve: s.resolveAddSSHKey,
+                },
+                "removeSSHKey": &graphql.Field{
+                        Type:        graphql.Boolean,
+                        Description: "Remove an SSH public key by its SHA256 fingerprint",
+                        Args: graphql.FieldConfigArgument{
+                                "fingerprint": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "uid": &graphql.ArgumentConfig{
+                                        Type:        graphql.String,
+                                        Description: "User to remove the key from; the caller when omitted",
+                                },
+                        },
+                        Resolve: s.resolveRemoveSSHKey,
+                },
+                "createDepartment": &graphql.Field{
+                        Type: departmentType,
+                        Args: graphql.FieldConfigArgument{
+                                "input": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(createDepartmentInputType),
+                                },
+                        },
+                        Resolve: s.resolveCreateDepartment,
+                },
+                "setDepartmentParent": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Move a department under another one; omit parent to make it top-level",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "parent": &graphql.ArgumentConfig{
+                                        Type: graphql.String,
+                                },
+                        },
+                        Resolve: s.resolveSetDepartmentParent,
+                },
+                "setDepartmentManager": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Hand a department over to another active user; omit manager to leave it without one",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "manager": &graphql.ArgumentConfig{
+                                        Type: graphql.String,
                                 },
-                                Resolve: s.resolveRemoveUserFromGroup,
                         },
-                        "deleteGroup": &graphql.Field{
-                                Type: graphql.Boolean,
+                        Resolve: s.resolveSetDepartmentManager,
+                },
+                "addDepartmentDeputy": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Let an active user manage a department besides its manager",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveAddDepartmentDeputy,
+                },
+                "removeDepartmentDeputy": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Take a department away from one of its deputies",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveRemoveDepartmentDeputy,
+                },
+                "deleteDepartment": &graphql.Field{
+                        Type: graphql.Boolean,
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveDeleteDepartment,
+                },
+                "assignRepoToDepartment": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Replace a department's repository grants with repositories and grants",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                        Description: "Listed repositories keep their permission, or get WRITE",
+                                },
+                                "grants": &graphql.ArgumentConfig{
+                                        Type: graphql.NewList(graphql.NewNonNull(repositoryGrantInputType)),
+                                },
+                        },
+                        Resolve: s.resolveAssignRepoToDepartment,
+                },
+                "assignRepoToUser": &graphql.Field{
+                        Type:        userType,
+                        Description: "Replace a user's direct repository grants with repositories and grants",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                        Description: "Listed repositories keep their permission, or get WRITE",
+                                },
+                                "grants": &graphql.ArgumentConfig{
+                                        Type: graphql.NewList(graphql.NewNonNull(repositoryGrantInputType)),
+                                },
+                        },
+                        Resolve: s.resolveAssignRepoToUser,
+                },
+                "assignRepoToGroup": &graphql.Field{
+                        Type:        groupType,
+                        Description: "Replace a group's repository grants with repositories and grants",
+                        Args: graphql.FieldConfigArgument{
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                        Description: "Listed repositories keep their permission, or get WRITE",
+                                },
+                                "grants": &graphql.ArgumentConfig{
+                                        Type: graphql.NewList(graphql.NewNonNull(repositoryGrantInputType)),
+                                },
+                        },
+                        Resolve: s.resolveAssignRepoToGroup,
+                },
+                "addRepoToDepartment": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Add repositories and grants to a department, keeping its other grants",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                        Description: "Listed repositories keep their permission, or get WRITE",
+                                },
+                                "grants": &graphql.ArgumentConfig{
+                                        Type: graphql.NewList(graphql.NewNonNull(repositoryGrantInputType)),
+                                },
+                        },
+                        Resolve: s.resolveAddRepoToDepartment,
+                },
+                "removeRepoFromDepartment": &graphql.Field{
+                        Type:        departmentType,
+                        Description: "Remove a department's grants for repositories",
+                        Args: graphql.FieldConfigArgument{
+                                "ou": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+                                },
+                        },
+                        Resolve: s.resolveRemoveRepoFromDepartment,
+                },
+                "addRepoToUser": &graphql.Field{
+                        Type:        userType,
+                        Description: "Add repositories and grants to a user's direct grants, keeping the others",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                        Description: "Listed repositories keep their permission, or get WRITE",
+                                },
+                                "grants": &graphql.ArgumentConfig{
+                                        Type: graphql.NewList(graphql.NewNonNull(repositoryGrantInputType)),
+                                },
+                        },
+                        Resolve: s.resolveAddRepoToUser,
+                },
+                "removeRepoFromUser": &graphql.Field{
+                        Type:        userType,
+                        Description: "Remove a user's direct grants for repositories; what their groups grant stays",
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+                                },
+                        },
+                        Resolve: s.resolveRemoveRepoFromUser,
+                },
+                "addRepoToGroup": &graphql.Field{
+                        Type:        groupType,
+                        Description: "Add repositories and grants to a group, keeping its other grants",
+                        Args: graphql.FieldConfigArgument{
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                        Description: "Listed repositories keep their permission, or get WRITE",
+                                },
+                                "grants": &graphql.ArgumentConfig{
+                                        Type: graphql.NewList(graphql.NewNonNull(repositoryGrantInputType)),
+                                },
+                        },
+                        Resolve: s.resolveAddRepoToGroup,
+                },
+                "removeRepoFromGroup": &graphql.Field{
+                        Type:        groupType,
+                        Description: "Remove a group's grants for repositories",
+                        Args: graphql.FieldConfigArgument{
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "repositories": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+                                },
+                        },
+                        Resolve: s.resolveRemoveRepoFromGroup,
+                },
+                "migrateRepositoryGrants": &graphql.Field{
+                        Type:        grantMigrationResultType,
+                        Description: "Turn repositories assigned before grants existed into WRITE grants (admin only)",
+                        Resolve:     s.resolveMigrateRepositoryGrants,
+                },
+                "triggerBackup": &graphql.Field{
+                        Type:        backupSnapshotType,
+                        Description: "Take an LDIF snapshot of the directory now (admin only)",
+                        Resolve:     s.resolveTriggerBackup,
+                },
+                "requestRepositoryAccess": &graphql.Field{
+                        Type:        accessRequestType,
+                        Description: "Ask for time-bound access to a repository; the requester's department manager or a repository admin reviews it",
+                        Args: graphql.FieldConfigArgument{
+                                "repository": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "permission": &graphql.ArgumentConfig{
+                                        Type:        repositoryPermissionEnum,
+                                        Description: "WRITE when omitted",
+                                },
+                                "reason": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "duration": &graphql.ArgumentConfig{
+                                        Type:        graphql.NewNonNull(graphql.String),
+                                        Description: "How long access should last once approved, e.g. 72h",
+                                },
+                        },
+                        Resolve: s.resolveRequestRepositoryAccess,
+                },
+                "approveAccessRequest": &graphql.Field{
+                        Type:        accessRequestType,
+                        Description: "Approve a pending access request, granting the repository until the requested duration has passed",
+                        Args: graphql.FieldConfigArgument{
+                                "id": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveApproveAccessRequest,
+                },
+                "denyAccessRequest": &graphql.Field{
+                        Type:        accessRequestType,
+                        Description: "Deny a pending access request",
+                        Args: graphql.FieldConfigArgument{
+                                "id": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveDenyAccessRequest,
+                },
+                "createGroup": &graphql.Field{
+                        Type: groupType,
+                        Args: graphql.FieldConfigArgument{
+                                "cn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "description": &graphql.ArgumentConfig{
+                                        Type: graphql.String,
+                                },
+                        },
+                        Resolve: s.resolveCreateGroup,
+                },
+                "addUserToGroup": &graphql.Field{
+                        Type: graphql.Boolean,
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveAddUserToGroup,
+                },
+                "removeUserFromGroup": &graphql.Field{
+                        Type: graphql.Boolean,
+                        Args: graphql.FieldConfigArgument{
+                                "uid": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveRemoveUserFromGroup,
+                },
+                "addGroupToGroup": &graphql.Field{
+                        Type:        graphql.Boolean,
+                        Description: "Nest a group inside another group; its members inherit the parent's repositories",
+                        Args: graphql.FieldConfigArgument{
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "parentGroupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveAddGroupToGroup,
+                },
+                "removeGroupFromGroup": &graphql.Field{
+                        Type: graphql.Boolean,
+                        Args: graphql.FieldConfigArgument{
+                                "groupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                                "parentGroupCn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveRemoveGroupFromGroup,
+                },
+                "deleteGroup": &graphql.Field{
+                        Type: graphql.Boolean,
+                        Args: graphql.FieldConfigArgument{
+                                "cn": &graphql.ArgumentConfig{
+                                        Type: graphql.NewNonNull(graphql.String),
+                                },
+                        },
+                        Resolve: s.resolveDeleteGroup,
+                },
+        }
+
+        // Every mutation changes the directory: authenticate and apply the
+        // role policy (see policy.go) before the resolver runs
+        for name, field := range mutationFields {
+                field.Resolve = s.authorize(name, field.Resolve)
+        }
+
+        mutationType := graphql.NewObject(graphql.ObjectConfig{
+                Name:   "Mutation",
+                Fields: mutationFields,
+        })
+
+        // Define root subscription
+        subscriptionType := graphql.NewObject(graphql.ObjectConfig{
+                Name: "Subscription",
+                Fields: graphql.Fields{
+                        "directoryEvents": &graphql.Field{
+                                Type:        directoryEventType,
+                                Description: "Directory changes as they happen; served over /graphql/stream",
                                 Args: graphql.FieldConfigArgument{
-                                        "cn": &graphql.ArgumentConfig{
-                                                Type: graphql.NewNonNull(graphql.String),
+                                        "types": &graphql.ArgumentConfig{
+                                                Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+                                                Description: "Event types to receive, e.g. group.member_added or group.*; all when omitted",
                                         },
                                 },
-                                Resolve: s.resolveDeleteGroup,
+                                Subscribe: s.subscribeDirectoryEvents,
+                                Resolve:   s.resolveDirectoryEvent,
                         },
                 },
         })
 
         // Create schema
         schemaConfig := graphql.SchemaConfig{
-                Query:    queryType,
-                Mutation: mutationType,
+                Query:        queryType,
+                Mutation:     mutationType,
+                Subscription: subscriptionType,
         }
 
         schema, err := graphql.NewSchema(schemaConfig)
@@ -0,0 +1,189 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// defineAccessRequestStatusEnum defines the AccessRequestStatus GraphQL enum
+func (s *Schema) defineAccessRequestStatusEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "AccessRequestStatus",
+		Values: graphql.EnumValueConfigMap{
+			"PENDING":  &graphql.EnumValueConfig{Value: models.AccessRequestPending, Description: "Waiting for review"},
+			"APPROVED": &graphql.EnumValueConfig{Value: models.AccessRequestApproved, Description: "Granted until expiresAt"},
+			"DENIED":   &graphql.EnumValueConfig{Value: models.AccessRequestDenied},
+			"EXPIRED":  &graphql.EnumValueConfig{Value: models.AccessRequestExpired, Description: "Approved, and the grant has since been revoked"},
+		},
+	})
+}
+
+// defineAccessRequestType defines the AccessRequest GraphQL type
+func (s *Schema) defineAccessRequestType(statusEnum, permissionEnum *graphql.Enum) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccessRequest",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.String},
+			"requester":  &graphql.Field{Type: graphql.String, Description: "uid of the user asking for access"},
+			"repository": &graphql.Field{Type: graphql.String},
+			"permission": &graphql.Field{Type: permissionEnum},
+			"reason":     &graphql.Field{Type: graphql.String},
+			"duration": &graphql.Field{
+				Type:        graphql.String,
+				Description: "How long access lasts once approved, e.g. 72h0m0s",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.AccessRequest).Duration.String(), nil
+				},
+			},
+			"status":     &graphql.Field{Type: statusEnum},
+			"reviewedBy": &graphql.Field{Type: graphql.String},
+			"requestedAt": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.AccessRequest).RequestedAt.UTC().Format(time.RFC3339), nil
+				},
+			},
+			"reviewedAt": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatOptionalTime(p.Source.(*models.AccessRequest).ReviewedAt), nil
+				},
+			},
+			"expiresAt": &graphql.Field{
+				Type:        graphql.String,
+				Description: "RFC3339 time the approved grant runs out",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatOptionalTime(p.Source.(*models.AccessRequest).ExpiresAt), nil
+				},
+			},
+		},
+	})
+}
+
+// formatOptionalTime formats t as RFC3339, or null when unset
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// resolveAccessRequests lists the access requests the caller may see:
+// all of them for admins, otherwise their own, those of users in
+// departments they manage and those for repositories they administer
+func (s *Schema) resolveAccessRequests(p graphql.ResolveParams) (interface{}, error) {
+	uid := auth.GetUserFromContext(p.Context)
+	if uid == "" {
+		return nil, unauthenticated(p.Info.FieldName)
+	}
+	principal := &Principal{UID: uid, Roles: auth.GetRolesFromContext(p.Context)}
+
+	filter := &models.AccessRequestFilter{}
+	filter.Status, _ = p.Args["status"].(string)
+	filter.Requester, _ = p.Args["requester"].(string)
+	filter.Repository, _ = p.Args["repository"].(string)
+
+	requests, err := s.ldapMgr.ListAccessRequests(p.Context, filter)
+	if err != nil || principal.IsAdmin() {
+		return requests, err
+	}
+
+	visible := s.accessRequestScope(p, principal)
+	shown := []*models.AccessRequest{}
+	for _, request := range requests {
+		if visible(request) {
+			shown = append(shown, request)
+		}
+	}
+	return shown, nil
+}
+
+func (s *Schema) resolveMyAccessRequests(p graphql.ResolveParams) (interface{}, error) {
+	uid := auth.GetUserFromContext(p.Context)
+	if uid == "" {
+		return nil, unauthenticated(p.Info.FieldName)
+	}
+	status, _ := p.Args["status"].(string)
+	return s.ldapMgr.ListAccessRequests(p.Context, &models.AccessRequestFilter{Requester: uid, Status: status})
+}
+
+// accessRequestScope returns whether a non-admin principal may see a
+// request, loading what the principal manages and administers once
+func (s *Schema) accessRequestScope(p graphql.ResolveParams, principal *Principal) func(*models.AccessRequest) bool {
+	owned := s.administeredRepos(p, principal)
+
+	managed := make(map[string]bool)
+	if principal.HasRole(RoleDepartmentManager) {
+		if depts, err := s.ldapMgr.ListDepartments(p.Context); err == nil {
+			for _, dept := range depts {
+				if dept.ManagedBy(principal.UID) {
+					managed[dept.OU] = true
+				}
+			}
+		}
+	}
+
+	departments := make(map[string]string)
+	return func(request *models.AccessRequest) bool {
+		if request.Requester == principal.UID || owned[strings.ToLower(request.Repository)] {
+			return true
+		}
+		if len(managed) == 0 {
+			return false
+		}
+		dept, ok := departments[request.Requester]
+		if !ok {
+			if user, err := s.ldapMgr.GetUser(p.Context, request.Requester); err == nil {
+				dept = user.Department
+			}
+			departments[request.Requester] = dept
+		}
+		return managed[dept]
+	}
+}
+
+// administeredRepos returns the lower-cased repositories the principal
+// currently holds admin on
+func (s *Schema) administeredRepos(p graphql.ResolveParams, principal *Principal) map[string]bool {
+	owned := make(map[string]bool)
+	access, err := s.ldapMgr.GetEffectiveAccess(p.Context, principal.UID)
+	if err != nil {
+		return owned
+	}
+	for _, grant := range access.Grants {
+		if grant.Permission == models.PermissionAdmin {
+			owned[strings.ToLower(grant.Repository)] = true
+		}
+	}
+	return owned
+}
+
+func (s *Schema) resolveRequestRepositoryAccess(p graphql.ResolveParams) (interface{}, error) {
+	duration, err := time.ParseDuration(p.Args["duration"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration: %w", err)
+	}
+
+	input := &models.AccessRequestInput{
+		Requester:  auth.GetUserFromContext(p.Context),
+		Repository: p.Args["repository"].(string),
+		Reason:     p.Args["reason"].(string),
+		Duration:   duration,
+	}
+	input.Permission, _ = p.Args["permission"].(string)
+
+	return s.ldapMgr.CreateAccessRequest(p.Context, input)
+}
+
+func (s *Schema) resolveApproveAccessRequest(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.ReviewAccessRequest(p.Context, p.Args["id"].(string), auth.GetUserFromContext(p.Context), true)
+}
+
+func (s *Schema) resolveDenyAccessRequest(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.ReviewAccessRequest(p.Context, p.Args["id"].(string), auth.GetUserFromContext(p.Context), false)
+}
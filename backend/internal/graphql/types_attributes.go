@@ -0,0 +1,123 @@
+package graphql
+
+import (
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// Custom user attributes from USER_ATTRIBUTES_FILE become fields of the
+// User type, UpdateUserInput and SearchFilterInput
+
+// customAttributeScalar returns the GraphQL scalar for an attribute type
+func customAttributeScalar(def models.AttributeDefinition) *graphql.Scalar {
+	switch def.Type {
+	case models.AttributeTypeInt:
+		return graphql.Int
+	case models.AttributeTypeBoolean:
+		return graphql.Boolean
+	}
+	return graphql.String
+}
+
+// addCustomUserFields adds a field to the User type for every custom
+// attribute
+func (s *Schema) addCustomUserFields(fields graphql.Fields) {
+	for _, def := range s.config.UserAttributes {
+		def := def
+		var fieldType graphql.Output = customAttributeScalar(def)
+		if def.MultiValued {
+			fieldType = graphql.NewList(fieldType)
+		}
+		fields[def.Field] = &graphql.Field{
+			Type:        fieldType,
+			Description: def.Description,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				user, ok := p.Source.(*models.User)
+				if !ok {
+					return nil, nil
+				}
+				var values []interface{}
+				for _, raw := range user.Attributes[def.Field] {
+					if v, ok := def.ParseValue(raw); ok {
+						values = append(values, v)
+					}
+				}
+				if def.MultiValued {
+					return values, nil
+				}
+				if len(values) == 0 {
+					return nil, nil
+				}
+				return values[0], nil
+			},
+		}
+	}
+}
+
+// addCustomUserInputFields adds an input field for every custom
+// attribute. Multi-valued attributes take lists when updating but a
+// single value to match when filtering.
+func (s *Schema) addCustomUserInputFields(fields graphql.InputObjectConfigFieldMap, filter bool) {
+	for _, def := range s.config.UserAttributes {
+		var fieldType graphql.Input = customAttributeScalar(def)
+		if def.MultiValued && !filter {
+			fieldType = graphql.NewList(fieldType)
+		}
+		fields[def.Field] = &graphql.InputObjectFieldConfig{Type: fieldType, Description: def.Description}
+	}
+}
+
+// parseCustomAttributes collects the custom attribute values of an update
+// input, keyed by field name. An empty list or empty string clears the
+// attribute.
+func (s *Schema) parseCustomAttributes(inputMap map[string]interface{}) (map[string][]string, error) {
+	var attrs map[string][]string
+	for _, def := range s.config.UserAttributes {
+		raw, ok := inputMap[def.Field]
+		if !ok || raw == nil {
+			continue
+		}
+
+		items, isList := raw.([]interface{})
+		if !isList {
+			items = []interface{}{raw}
+		}
+		values := []string{}
+		for _, item := range items {
+			value, err := def.FormatValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+
+		if attrs == nil {
+			attrs = make(map[string][]string)
+		}
+		attrs[def.Field] = values
+	}
+	return attrs, nil
+}
+
+// parseCustomFilters collects the custom attribute values of a search
+// filter input, keyed by field name
+func (s *Schema) parseCustomFilters(filterInput map[string]interface{}) map[string]string {
+	var filters map[string]string
+	for _, def := range s.config.UserAttributes {
+		raw, ok := filterInput[def.Field]
+		if !ok || raw == nil {
+			continue
+		}
+		value, err := def.FormatValue(raw)
+		if err != nil || value == "" {
+			continue
+		}
+		if filters == nil {
+			filters = make(map[string]string)
+		}
+		filters[def.Field] = value
+	}
+	return filters
+}
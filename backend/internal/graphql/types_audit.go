@@ -0,0 +1,163 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/audit"
+	"github.com/graphql-go/graphql"
+)
+
+// defineAttributeChangeType defines the AttributeChange GraphQL type
+func (s *Schema) defineAttributeChangeType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "AttributeChange",
+		Fields: graphql.Fields{
+			"attribute": &graphql.Field{Type: graphql.String},
+			"before":    &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"after":     &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+}
+
+// defineAuditRecordType defines the AuditRecord GraphQL type
+func (s *Schema) defineAuditRecordType(changeType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "AuditRecord",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.String},
+			"timestamp": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*audit.Record).Timestamp.Format(time.RFC3339Nano), nil
+				},
+			},
+			"actor":     &graphql.Field{Type: graphql.String},
+			"action":    &graphql.Field{Type: graphql.String},
+			"targetDn":  &graphql.Field{Type: graphql.String},
+			"changes":   &graphql.Field{Type: graphql.NewList(changeType)},
+			"requestId": &graphql.Field{Type: graphql.String},
+			"success":   &graphql.Field{Type: graphql.Boolean},
+			"error":     &graphql.Field{Type: graphql.String},
+		},
+	})
+}
+
+// definePaginatedAuditLogType defines the PaginatedAuditLog GraphQL type
+func (s *Schema) definePaginatedAuditLogType(recordType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "PaginatedAuditLog",
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type:        graphql.NewList(recordType),
+				Description: "Audit records, newest first",
+			},
+			"total": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Total number of matching records",
+			},
+			"limit": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Items per page",
+			},
+			"offset": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of items skipped",
+			},
+			"hasMore": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether more records follow",
+			},
+		},
+	})
+}
+
+// defineAuditLogFilterInput defines the AuditLogFilterInput GraphQL input type
+func (s *Schema) defineAuditLogFilterInput() *graphql.InputObject {
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "AuditLogFilterInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"actor":     &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "User who performed the change"},
+			"action":    &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Action, e.g. group.member_add"},
+			"target":    &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Target DN (partial match)"},
+			"attribute": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Only records changing this attribute, e.g. githubRepository"},
+			"value":     &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Attribute value before or after the change (partial match)"},
+			"requestId": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Request ID"},
+			"since":     &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "RFC3339 lower bound"},
+			"until":     &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "RFC3339 upper bound"},
+		},
+	})
+}
+
+// ============================================================================
+// AUDIT QUERY RESOLVERS
+// ============================================================================
+
+func (s *Schema) resolveAuditLog(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.requireRole(p, RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	// Get pagination parameters
+	limit := p.Args["limit"].(int)
+	offset := p.Args["offset"].(int)
+
+	// Enforce limit constraints
+	if limit > 100 {
+		limit = 100
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+
+	// Parse filter
+	filter := &audit.Filter{}
+	if filterInput, ok := p.Args["filter"].(map[string]interface{}); ok {
+		if actor, ok := filterInput["actor"].(string); ok {
+			filter.Actor = actor
+		}
+		if action, ok := filterInput["action"].(string); ok {
+			filter.Action = action
+		}
+		if target, ok := filterInput["target"].(string); ok {
+			filter.Target = target
+		}
+		if attribute, ok := filterInput["attribute"].(string); ok {
+			filter.Attribute = attribute
+		}
+		if value, ok := filterInput["value"].(string); ok {
+			filter.Value = value
+		}
+		if requestID, ok := filterInput["requestId"].(string); ok {
+			filter.RequestID = requestID
+		}
+		if since, ok := filterInput["since"].(string); ok {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				return nil, fmt.Errorf("invalid since: %w", err)
+			}
+			filter.Since = t
+		}
+		if until, ok := filterInput["until"].(string); ok {
+			t, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				return nil, fmt.Errorf("invalid until: %w", err)
+			}
+			filter.Until = t
+		}
+	}
+
+	records, total, err := s.auditStore.Query(p.Context, filter, limit, offset)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to query audit log")
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	return map[string]interface{}{
+		"items":   records,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"hasMore": offset+len(records) < total,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package graphql
+
+import (
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// defineBackupSnapshotType defines the BackupSnapshot GraphQL type
+func (s *Schema) defineBackupSnapshotType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "BackupSnapshot",
+		Description: "LDIF snapshot of the directory in the backup store",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.String},
+			"createdAt": &graphql.Field{
+				Type:        graphql.String,
+				Description: "RFC3339 time the snapshot was taken",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.BackupSnapshot).CreatedAt.Format(time.RFC3339), nil
+				},
+			},
+			"size":    &graphql.Field{Type: graphql.Int, Description: "Size in bytes"},
+			"sha256":  &graphql.Field{Type: graphql.String, Description: "Checksum of the LDIF file"},
+			"entries": &graphql.Field{Type: graphql.Int, Description: "Number of entries, only set by triggerBackup"},
+		},
+	})
+}
+
+func (s *Schema) resolveBackups(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.requireRole(p, RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.backups.Snapshots()
+}
+
+func (s *Schema) resolveTriggerBackup(p graphql.ResolveParams) (interface{}, error) {
+	snapshot, err := s.backups.Backup(p.Context)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to back up directory")
+		return nil, err
+	}
+	return snapshot, nil
+}
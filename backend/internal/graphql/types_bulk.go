@@ -0,0 +1,88 @@
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/bulk"
+	"github.com/graphql-go/graphql"
+)
+
+// defineBulkFormatEnum defines the BulkFormat GraphQL enum
+func (s *Schema) defineBulkFormatEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "BulkFormat",
+		Values: graphql.EnumValueConfigMap{
+			"CSV":  &graphql.EnumValueConfig{Value: string(bulk.FormatCSV), Description: "Comma-separated values with a header row"},
+			"LDIF": &graphql.EnumValueConfig{Value: string(bulk.FormatLDIF), Description: "LDAP Data Interchange Format content records"},
+		},
+	})
+}
+
+// defineImportRowResultType defines the ImportRowResult GraphQL type
+func (s *Schema) defineImportRowResultType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "ImportRowResult",
+		Fields: graphql.Fields{
+			"line":   &graphql.Field{Type: graphql.Int, Description: "Line number in the input"},
+			"uid":    &graphql.Field{Type: graphql.String},
+			"action": &graphql.Field{Type: graphql.String, Description: "create, update, skip, invalid or failed"},
+			"errors": &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+}
+
+// defineImportReportType defines the ImportReport GraphQL type
+func (s *Schema) defineImportReportType(rowType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "ImportReport",
+		Fields: graphql.Fields{
+			"dryRun":     &graphql.Field{Type: graphql.Boolean},
+			"total":      &graphql.Field{Type: graphql.Int},
+			"created":    &graphql.Field{Type: graphql.Int},
+			"updated":    &graphql.Field{Type: graphql.Int},
+			"failed":     &graphql.Field{Type: graphql.Int},
+			"rolledBack": &graphql.Field{Type: graphql.Boolean, Description: "Whether applied rows were undone after a failure"},
+			"rows":       &graphql.Field{Type: graphql.NewList(rowType)},
+		},
+	})
+}
+
+// ============================================================================
+// BULK RESOLVERS
+// ============================================================================
+
+func (s *Schema) resolveImportUsers(p graphql.ResolveParams) (interface{}, error) {
+	format := bulk.Format(p.Args["format"].(string))
+	data := p.Args["data"].(string)
+
+	opts := bulk.ImportOptions{}
+	if dryRun, ok := p.Args["dryRun"].(bool); ok {
+		opts.DryRun = dryRun
+	}
+	if upsert, ok := p.Args["upsert"].(bool); ok {
+		opts.Upsert = upsert
+	}
+
+	report, err := s.importer.Import(p.Context, format, []byte(data), opts)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to import users")
+		return nil, fmt.Errorf("failed to import users: %w", err)
+	}
+	return report, nil
+}
+
+func (s *Schema) resolveExportUsers(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.requireRole(p, RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	format := bulk.Format(p.Args["format"].(string))
+	filter := s.parseSearchFilter(p.Args["filter"])
+
+	data, err := s.importer.Export(p.Context, format, filter)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to export users")
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -9,12 +9,51 @@ import (
 
 // defineStatsType defines the Stats GraphQL type
 func (s *Schema) defineStatsType() *graphql.Object {
+	backendType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "LDAPBackend",
+		Description: "An LDAP server and its connection pool",
+		Fields: graphql.Fields{
+			"url":       &graphql.Field{Type: graphql.String},
+			"role":      &graphql.Field{Type: graphql.String, Description: "provider or consumer"},
+			"healthy":   &graphql.Field{Type: graphql.Boolean, Description: "False while the circuit breaker is open"},
+			"failures":  &graphql.Field{Type: graphql.Int, Description: "Consecutive connection failures"},
+			"requests":  &graphql.Field{Type: graphql.Int, Description: "Operations served"},
+			"poolSize":  &graphql.Field{Type: graphql.Int},
+			"available": &graphql.Field{Type: graphql.Int},
+			"inUse":     &graphql.Field{Type: graphql.Int},
+		},
+	})
+
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: "Stats",
 		Fields: graphql.Fields{
-			"totalConnections":  &graphql.Field{Type: graphql.Int},
-			"activeConnections": &graphql.Field{Type: graphql.Int},
-			"poolSize":          &graphql.Field{Type: graphql.Int},
+			"totalConnections": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Open connections",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.Stats).PoolSize, nil
+				},
+			},
+			"activeConnections": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Connections currently checked out",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.Stats).InUse, nil
+				},
+			},
+			"poolSize":            &graphql.Field{Type: graphql.Int},
+			"minSize":             &graphql.Field{Type: graphql.Int},
+			"maxSize":             &graphql.Field{Type: graphql.Int},
+			"available":           &graphql.Field{Type: graphql.Int},
+			"inUse":               &graphql.Field{Type: graphql.Int},
+			"totalRequests":       &graphql.Field{Type: graphql.Int},
+			"waits":               &graphql.Field{Type: graphql.Int, Description: "Checkouts that waited for a free connection"},
+			"waitSeconds":         &graphql.Field{Type: graphql.Float},
+			"timeouts":            &graphql.Field{Type: graphql.Int},
+			"connectionsCreated":  &graphql.Field{Type: graphql.Int},
+			"connectionsRecycled": &graphql.Field{Type: graphql.Int, Description: "Closed after LDAP_MAX_CONN_LIFETIME"},
+			"connectionsReaped":   &graphql.Field{Type: graphql.Int, Description: "Closed while idle or broken"},
+			"backends":            &graphql.Field{Type: graphql.NewList(backendType)},
 		},
 	})
 }
@@ -1,67 +1,63 @@
 package graphql
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 
+	"github.com/devplatform/ldap-manager/internal/auth"
 	"github.com/devplatform/ldap-manager/internal/models"
 	"github.com/graphql-go/graphql"
 )
 
-// containsIgnoreCase checks if s contains substr (case-insensitive)
-func containsIgnoreCase(s, substr string) bool {
-	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
-}
-
 // defineDepartmentType defines the Department GraphQL type
-func (s *Schema) defineDepartmentType() *graphql.Object {
-	return graphql.NewObject(graphql.ObjectConfig{
+func (s *Schema) defineDepartmentType(grantType *graphql.Object) *graphql.Object {
+	departmentType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Department",
 		Fields: graphql.Fields{
 			"ou":           &graphql.Field{Type: graphql.String},
 			"description":  &graphql.Field{Type: graphql.String},
 			"manager":      &graphql.Field{Type: graphql.String},
+			"deputies":     &graphql.Field{Type: graphql.NewList(graphql.String), Description: "Users managing the department besides its manager"},
 			"members":      &graphql.Field{Type: graphql.NewList(graphql.String)},
 			"repositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"grants":       &graphql.Field{Type: graphql.NewList(grantType)},
 			"dn":           &graphql.Field{Type: graphql.String},
 		},
 	})
+
+	// The hierarchy fields refer back to Department, so they are added once
+	// the type exists
+	departmentType.AddFieldConfig("parent", &graphql.Field{
+		Type:        departmentType,
+		Description: "Parent department, null for top-level departments",
+		Resolve:     s.resolveDepartmentParent,
+	})
+	departmentType.AddFieldConfig("children", &graphql.Field{
+		Type:        graphql.NewList(departmentType),
+		Description: "Direct child departments",
+		Resolve:     s.resolveDepartmentChildren,
+	})
+
+	return departmentType
 }
 
 // definePaginatedDepartmentsType defines the PaginatedDepartments GraphQL type
-func (s *Schema) definePaginatedDepartmentsType(departmentType *graphql.Object) *graphql.Object {
-	return graphql.NewObject(graphql.ObjectConfig{
-		Name: "PaginatedDepartments",
-		Fields: graphql.Fields{
-			"departments": &graphql.Field{
-				Type:        graphql.NewList(departmentType),
-				Description: "List of departments",
-			},
-			"total": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Total number of departments",
-			},
-			"page": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Current page number",
-			},
-			"limit": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Items per page",
-			},
-		},
-	})
+func (s *Schema) definePaginatedDepartmentsType(departmentType, pageInfoType *graphql.Object) *graphql.Object {
+	return s.defineConnectionType("PaginatedDepartments", "departments", "departments", departmentType, pageInfoType)
 }
 
 // defineCreateDepartmentInput defines the CreateDepartmentInput GraphQL input type
-func (s *Schema) defineCreateDepartmentInput() *graphql.InputObject {
+func (s *Schema) defineCreateDepartmentInput(grantInput *graphql.InputObject) *graphql.InputObject {
 	return graphql.NewInputObject(graphql.InputObjectConfig{
 		Name: "CreateDepartmentInput",
 		Fields: graphql.InputObjectConfigFieldMap{
 			"ou":           &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
 			"description":  &graphql.InputObjectFieldConfig{Type: graphql.String},
 			"manager":      &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String)},
+			"deputies":     &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"parent":       &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "OU of the parent department"},
+			"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String), Description: "Granted with WRITE permission"},
+			"grants":       &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(grantInput))},
 		},
 	})
 }
@@ -86,76 +82,57 @@ func (s *Schema) resolveDepartment(p graphql.ResolveParams) (interface{}, error)
 	return s.ldapMgr.GetDepartment(p.Context, ou)
 }
 
-func (s *Schema) resolveDepartments(p graphql.ResolveParams) (interface{}, error) {
-	// Get pagination parameters
-	limit := p.Args["limit"].(int)
-	offset := p.Args["offset"].(int)
+func (s *Schema) resolveDepartmentParent(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok || dept.Parent == "" {
+		return nil, nil
+	}
+	parent, err := s.ldapMgr.GetDepartment(p.Context, dept.Parent)
+	if errors.Is(err, models.ErrDepartmentNotFound) {
+		return nil, nil
+	}
+	return parent, err
+}
 
-	// Enforce limit constraints
-	if limit > 100 {
-		limit = 100
+func (s *Schema) resolveDepartmentChildren(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok {
+		return nil, nil
 	}
-	if limit <= 0 {
-		limit = 10
+	return s.ldapMgr.GetDepartmentChildren(p.Context, dept.OU)
+}
+
+func (s *Schema) resolveDepartments(p graphql.ResolveParams) (interface{}, error) {
+	page, err := parsePageRequest(p, "departments")
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse filter
-	var filterOU, filterDesc string
+	var filter *models.DepartmentFilter
 	if filterInput, ok := p.Args["filter"].(map[string]interface{}); ok {
+		filter = &models.DepartmentFilter{}
 		if ou, ok := filterInput["ou"].(string); ok {
-			filterOU = ou
+			filter.OU = ou
 		}
 		if desc, ok := filterInput["description"].(string); ok {
-			filterDesc = desc
+			filter.Description = desc
 		}
 	}
 
-	// Get all departments
-	allDepartments, err := s.ldapMgr.ListDepartments(p.Context)
+	result, err := s.ldapMgr.ListDepartmentsPage(p.Context, filter, page)
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to list departments")
 		return nil, fmt.Errorf("failed to list departments: %w", err)
 	}
 
-	// Apply filters
-	var filteredDepartments []*models.Department
-	for _, dept := range allDepartments {
-		// Filter by OU (partial match, case-insensitive)
-		if filterOU != "" {
-			if !containsIgnoreCase(dept.OU, filterOU) {
-				continue
-			}
-		}
-		// Filter by description (partial match, case-insensitive)
-		if filterDesc != "" {
-			if !containsIgnoreCase(dept.Description, filterDesc) {
-				continue
-			}
-		}
-		filteredDepartments = append(filteredDepartments, dept)
-	}
-
-	// Apply pagination
-	total := len(filteredDepartments)
-	start := offset
-	end := offset + limit
-
-	if start > total {
-		start = total
+	nodes := make([]interface{}, len(result.Departments))
+	keys := make([]string, len(result.Departments))
+	for i, dept := range result.Departments {
+		nodes[i] = dept
+		keys[i] = dept.OU
 	}
-	if end > total {
-		end = total
-	}
-
-	paginatedDepartments := filteredDepartments[start:end]
-
-	return map[string]interface{}{
-		"items":   paginatedDepartments,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
-		"hasMore": end < total,
-	}, nil
+	return connection("departments", "departments", nodes, keys, page, result.PageInfo), nil
 }
 
 func (s *Schema) resolveDepartmentsAll(p graphql.ResolveParams) (interface{}, error) {
@@ -171,46 +148,18 @@ func (s *Schema) resolveDepartmentsAll(p graphql.ResolveParams) (interface{}, er
 func (s *Schema) resolveDepartmentUsers(p graphql.ResolveParams) (interface{}, error) {
 	department := p.Args["department"].(string)
 
-	// Get pagination parameters
-	limit := p.Args["limit"].(int)
-	offset := p.Args["offset"].(int)
-
-	// Enforce limit constraints
-	if limit > 100 {
-		limit = 100
-	}
-	if limit <= 0 {
-		limit = 10
+	page, err := parsePageRequest(p, "users")
+	if err != nil {
+		return nil, err
 	}
 
-	// Get all users in department
-	allUsers, err := s.ldapMgr.GetUsersByDepartment(p.Context, department)
+	result, err := s.ldapMgr.ListUsersPage(p.Context, &models.SearchFilter{Department: department}, page)
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to get department users")
 		return nil, fmt.Errorf("failed to get department users: %w", err)
 	}
 
-	// Apply pagination
-	total := len(allUsers)
-	start := offset
-	end := offset + limit
-
-	if start > total {
-		start = total
-	}
-	if end > total {
-		end = total
-	}
-
-	paginatedUsers := allUsers[start:end]
-
-	return map[string]interface{}{
-		"items":   paginatedUsers,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
-		"hasMore": end < total,
-	}, nil
+	return userConnection(result, page), nil
 }
 
 // ============================================================================
@@ -230,16 +179,52 @@ func (s *Schema) resolveCreateDepartment(p graphql.ResolveParams) (interface{},
 	if mgr, ok := inputMap["manager"].(string); ok {
 		input.Manager = mgr
 	}
+	input.Deputies = stringList(inputMap["deputies"])
+	if parent, ok := inputMap["parent"].(string); ok {
+		input.Parent = parent
+	}
 	if repos, ok := inputMap["repositories"].([]interface{}); ok {
 		input.Repositories = make([]string, len(repos))
 		for i, r := range repos {
 			input.Repositories[i] = r.(string)
 		}
 	}
+	grants, err := grantInputs(inputMap["grants"])
+	if err != nil {
+		return nil, err
+	}
+	input.Grants = grants
 
 	return s.ldapMgr.CreateDepartment(p.Context, input)
 }
 
+func (s *Schema) resolveSetDepartmentParent(p graphql.ResolveParams) (interface{}, error) {
+	ou := p.Args["ou"].(string)
+	parent, _ := p.Args["parent"].(string)
+	return s.ldapMgr.SetDepartmentParent(p.Context, ou, parent)
+}
+
+func (s *Schema) resolveSetDepartmentManager(p graphql.ResolveParams) (interface{}, error) {
+	manager, _ := p.Args["manager"].(string)
+	return s.ldapMgr.SetDepartmentManager(p.Context, p.Args["ou"].(string), manager)
+}
+
+func (s *Schema) resolveAddDepartmentDeputy(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.AddDepartmentDeputy(p.Context, p.Args["ou"].(string), p.Args["uid"].(string))
+}
+
+func (s *Schema) resolveRemoveDepartmentDeputy(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.RemoveDepartmentDeputy(p.Context, p.Args["ou"].(string), p.Args["uid"].(string))
+}
+
+func (s *Schema) resolveMyManagedDepartments(p graphql.ResolveParams) (interface{}, error) {
+	uid := auth.GetUserFromContext(p.Context)
+	if uid == "" {
+		return nil, fmt.Errorf("unauthorized")
+	}
+	return s.ldapMgr.GetManagedDepartments(p.Context, uid)
+}
+
 func (s *Schema) resolveDeleteDepartment(p graphql.ResolveParams) (interface{}, error) {
 	ou := p.Args["ou"].(string)
 	err := s.ldapMgr.DeleteDepartment(p.Context, ou)
@@ -248,16 +233,26 @@ func (s *Schema) resolveDeleteDepartment(p graphql.ResolveParams) (interface{},
 
 func (s *Schema) resolveAssignRepoToDepartment(p graphql.ResolveParams) (interface{}, error) {
 	ou := p.Args["ou"].(string)
-	repoInterfaces := p.Args["repositories"].([]interface{})
-
-	repos := make([]string, len(repoInterfaces))
-	for i, r := range repoInterfaces {
-		repos[i] = r.(string)
+	grants, err := assignedGrants(p)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.ldapMgr.AssignRepositoryToDepartment(p.Context, ou, repos); err != nil {
+	if err := s.ldapMgr.AssignRepositoryToDepartment(p.Context, ou, grants); err != nil {
 		return nil, err
 	}
 
 	return s.ldapMgr.GetDepartment(p.Context, ou)
 }
+
+func (s *Schema) resolveAddRepoToDepartment(p graphql.ResolveParams) (interface{}, error) {
+	grants, err := assignedGrants(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.ldapMgr.AddDepartmentRepositories(p.Context, p.Args["ou"].(string), grants)
+}
+
+func (s *Schema) resolveRemoveRepoFromDepartment(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.RemoveDepartmentRepositories(p.Context, p.Args["ou"].(string), stringList(p.Args["repositories"]))
+}
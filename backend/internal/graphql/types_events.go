@@ -0,0 +1,80 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/events"
+	"github.com/graphql-go/graphql"
+)
+
+// subscriptionBuffer is how many events a subscription may fall behind
+// before events are dropped for it
+const subscriptionBuffer = 256
+
+// defineDirectoryEventType defines the DirectoryEvent GraphQL type
+func (s *Schema) defineDirectoryEventType(changeType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "DirectoryEvent",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.String, Description: "Stable across replicas; use it to deduplicate"},
+			"type": &graphql.Field{Type: graphql.String, Description: "e.g. user.created, group.member_added, repo.assigned"},
+			"timestamp": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*events.Event).Timestamp.Format(time.RFC3339Nano), nil
+				},
+			},
+			"entity":       &graphql.Field{Type: graphql.String, Description: "user, group or department"},
+			"key":          &graphql.Field{Type: graphql.String, Description: "uid, cn or ou of the entity"},
+			"dn":           &graphql.Field{Type: graphql.String},
+			"member":       &graphql.Field{Type: graphql.String, Description: "uid or group cn, on group.member_* events"},
+			"memberEntity": &graphql.Field{Type: graphql.String, Description: "user or group, on group.member_* events"},
+			"repositories": &graphql.Field{Type: graphql.NewList(graphql.String), Description: "Set on repo.* events"},
+			"changes":      &graphql.Field{Type: graphql.NewList(changeType)},
+		},
+	})
+}
+
+// subscribeDirectoryEvents streams broker events to one subscription until
+// the client goes away
+func (s *Schema) subscribeDirectoryEvents(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.requireRole(p, RoleAdmin); err != nil {
+		return nil, err
+	}
+	if s.broker == nil {
+		return nil, fmt.Errorf("directory events are disabled")
+	}
+
+	var types []string
+	if list, ok := p.Args["types"].([]interface{}); ok {
+		for _, t := range list {
+			types = append(types, t.(string))
+		}
+	}
+
+	source, cancel := s.broker.Subscribe("subscription", types, subscriptionBuffer)
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case e := <-source:
+				select {
+				case out <- e:
+				case <-p.Context.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// resolveDirectoryEvent returns the event the subscription was fed
+func (s *Schema) resolveDirectoryEvent(p graphql.ResolveParams) (interface{}, error) {
+	return p.Source, nil
+}
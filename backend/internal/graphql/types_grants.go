@@ -0,0 +1,120 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// defineRepositoryPermissionEnum defines the RepositoryPermission GraphQL enum
+func (s *Schema) defineRepositoryPermissionEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "RepositoryPermission",
+		Values: graphql.EnumValueConfigMap{
+			"READ":  &graphql.EnumValueConfig{Value: models.PermissionRead, Description: "Clone and pull"},
+			"WRITE": &graphql.EnumValueConfig{Value: models.PermissionWrite, Description: "Push as well"},
+			"ADMIN": &graphql.EnumValueConfig{Value: models.PermissionAdmin, Description: "Manage the repository's settings as well"},
+		},
+	})
+}
+
+// defineRepositoryGrantType defines the RepositoryGrant GraphQL type
+func (s *Schema) defineRepositoryGrantType(permissionEnum *graphql.Enum) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "RepositoryGrant",
+		Fields: graphql.Fields{
+			"repository": &graphql.Field{Type: graphql.String},
+			"permission": &graphql.Field{Type: permissionEnum},
+			"expiresAt": &graphql.Field{
+				Type:        graphql.String,
+				Description: "RFC3339 time after which the grant no longer applies; null if it never expires",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					grant, ok := p.Source.(models.RepositoryGrant)
+					if !ok || grant.ExpiresAt == nil {
+						return nil, nil
+					}
+					return grant.ExpiresAt.UTC().Format(time.RFC3339), nil
+				},
+			},
+		},
+	})
+}
+
+// defineRepositoryGrantInput defines the RepositoryGrantInput GraphQL input type
+func (s *Schema) defineRepositoryGrantInput(permissionEnum *graphql.Enum) *graphql.InputObject {
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "RepositoryGrantInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"repository": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"permission": &graphql.InputObjectFieldConfig{
+				Type:        permissionEnum,
+				Description: "Omit to keep the current permission, or WRITE for new grants",
+			},
+			"expiresAt": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "RFC3339 expiry; omit for a permanent grant"},
+		},
+	})
+}
+
+// defineGrantMigrationResultType defines the GrantMigrationResult GraphQL type
+func (s *Schema) defineGrantMigrationResultType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        "GrantMigrationResult",
+		Description: "Number of entries whose bare repositories were turned into grants",
+		Fields: graphql.Fields{
+			"users":       &graphql.Field{Type: graphql.Int},
+			"groups":      &graphql.Field{Type: graphql.Int},
+			"departments": &graphql.Field{Type: graphql.Int},
+		},
+	})
+}
+
+// grantInputs reads a [RepositoryGrantInput!] argument; nil when it was
+// not given
+func grantInputs(arg interface{}) ([]models.RepositoryGrant, error) {
+	items, ok := arg.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	grants := make([]models.RepositoryGrant, 0, len(items))
+	for _, item := range items {
+		fields, _ := item.(map[string]interface{})
+		grant := models.RepositoryGrant{}
+		grant.Repository, _ = fields["repository"].(string)
+		grant.Permission, _ = fields["permission"].(string)
+		if v, ok := fields["expiresAt"].(string); ok && v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiresAt for %s: %w", grant.Repository, err)
+			}
+			grant.ExpiresAt = &t
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// assignedGrants combines the repositories and grants arguments of the
+// assignRepoTo* and addRepoTo* mutations, at least one of which is
+// required. Repositories
+// listed without a grant keep their current permission.
+func assignedGrants(p graphql.ResolveParams) ([]models.RepositoryGrant, error) {
+	_, hasRepos := p.Args["repositories"].([]interface{})
+	_, hasGrants := p.Args["grants"].([]interface{})
+	if !hasRepos && !hasGrants {
+		return nil, errors.New("repositories or grants is required")
+	}
+
+	grants, err := grantInputs(p.Args["grants"])
+	if err != nil {
+		return nil, err
+	}
+	return append(models.RepositoryGrants(stringList(p.Args["repositories"])), grants...), nil
+}
+
+func (s *Schema) resolveMigrateRepositoryGrants(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.MigrateRepositoryGrants(p.Context)
+}
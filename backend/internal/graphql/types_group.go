@@ -2,7 +2,6 @@ package graphql
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/devplatform/ldap-manager/internal/models"
 	"github.com/graphql-go/graphql"
@@ -19,43 +18,36 @@ func (s *Schema) defineGroupFilterInput() *graphql.InputObject {
 }
 
 // defineGroupType defines the Group GraphQL type
-func (s *Schema) defineGroupType() *graphql.Object {
+func (s *Schema) defineGroupType(grantType *graphql.Object) *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: "Group",
 		Fields: graphql.Fields{
-			"cn":           &graphql.Field{Type: graphql.String},
-			"description":  &graphql.Field{Type: graphql.String},
-			"gidNumber":    &graphql.Field{Type: graphql.Int},
-			"members":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"cn":          &graphql.Field{Type: graphql.String},
+			"description": &graphql.Field{Type: graphql.String},
+			"gidNumber":   &graphql.Field{Type: graphql.Int},
+			"members":     &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"subgroups":   &graphql.Field{Type: graphql.NewList(graphql.String), Description: "Groups nested in this one"},
+			"effectiveMembers": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Direct members plus the members of all nested groups",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					group, ok := p.Source.(*models.Group)
+					if !ok {
+						return nil, nil
+					}
+					return s.ldapMgr.GetGroupEffectiveMembers(p.Context, group.CN)
+				},
+			},
 			"repositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"grants":       &graphql.Field{Type: graphql.NewList(grantType)},
 			"dn":           &graphql.Field{Type: graphql.String},
 		},
 	})
 }
 
 // definePaginatedGroupsType defines the PaginatedGroups GraphQL type
-func (s *Schema) definePaginatedGroupsType(groupType *graphql.Object) *graphql.Object {
-	return graphql.NewObject(graphql.ObjectConfig{
-		Name: "PaginatedGroups",
-		Fields: graphql.Fields{
-			"groups": &graphql.Field{
-				Type:        graphql.NewList(groupType),
-				Description: "List of groups",
-			},
-			"total": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Total number of groups",
-			},
-			"page": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Current page number",
-			},
-			"limit": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Items per page",
-			},
-		},
-	})
+func (s *Schema) definePaginatedGroupsType(groupType, pageInfoType *graphql.Object) *graphql.Object {
+	return s.defineConnectionType("PaginatedGroups", "groups", "groups", groupType, pageInfoType)
 }
 
 // ============================================================================
@@ -68,65 +60,33 @@ func (s *Schema) resolveGroup(p graphql.ResolveParams) (interface{}, error) {
 }
 
 func (s *Schema) resolveGroups(p graphql.ResolveParams) (interface{}, error) {
-	// Get pagination parameters
-	limit := p.Args["limit"].(int)
-	offset := p.Args["offset"].(int)
-
-	// Enforce limit constraints
-	if limit > 100 {
-		limit = 100
-	}
-	if limit <= 0 {
-		limit = 10
+	page, err := parsePageRequest(p, "groups")
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse filter
-	var filterCN string
+	var filter *models.GroupFilter
 	if filterInput, ok := p.Args["filter"].(map[string]interface{}); ok {
+		filter = &models.GroupFilter{}
 		if cn, ok := filterInput["cn"].(string); ok {
-			filterCN = cn
+			filter.CN = cn
 		}
 	}
 
-	// Get all groups
-	allGroups, err := s.ldapMgr.ListGroups(p.Context)
+	result, err := s.ldapMgr.ListGroupsPage(p.Context, filter, page)
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to list groups")
 		return nil, fmt.Errorf("failed to list groups: %w", err)
 	}
 
-	// Apply filters
-	var filteredGroups []*models.Group
-	for _, group := range allGroups {
-		if filterCN != "" {
-			if !strings.Contains(strings.ToLower(group.CN), strings.ToLower(filterCN)) {
-				continue
-			}
-		}
-		filteredGroups = append(filteredGroups, group)
-	}
-
-	// Apply pagination
-	total := len(filteredGroups)
-	start := offset
-	end := offset + limit
-
-	if start > total {
-		start = total
-	}
-	if end > total {
-		end = total
+	nodes := make([]interface{}, len(result.Groups))
+	keys := make([]string, len(result.Groups))
+	for i, group := range result.Groups {
+		nodes[i] = group
+		keys[i] = group.CN
 	}
-
-	paginatedGroups := filteredGroups[start:end]
-
-	return map[string]interface{}{
-		"items":   paginatedGroups,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
-		"hasMore": end < total,
-	}, nil
+	return connection("groups", "groups", nodes, keys, page, result.PageInfo), nil
 }
 
 // ============================================================================
@@ -151,16 +111,42 @@ func (s *Schema) resolveAddUserToGroup(p graphql.ResolveParams) (interface{}, er
 	return err == nil, err
 }
 
+func (s *Schema) resolveAddGroupToGroup(p graphql.ResolveParams) (interface{}, error) {
+	groupCn := p.Args["groupCn"].(string)
+	parentCn := p.Args["parentGroupCn"].(string)
+
+	err := s.ldapMgr.AddGroupToGroup(p.Context, groupCn, parentCn)
+	return err == nil, err
+}
+
+func (s *Schema) resolveRemoveGroupFromGroup(p graphql.ResolveParams) (interface{}, error) {
+	groupCn := p.Args["groupCn"].(string)
+	parentCn := p.Args["parentGroupCn"].(string)
+
+	err := s.ldapMgr.RemoveGroupFromGroup(p.Context, groupCn, parentCn)
+	return err == nil, err
+}
+
 func (s *Schema) resolveAssignRepoToGroup(p graphql.ResolveParams) (interface{}, error) {
 	groupCn := p.Args["groupCn"].(string)
-	repoInterfaces := p.Args["repositories"].([]interface{})
+	grants, err := assignedGrants(p)
+	if err != nil {
+		return nil, err
+	}
 
-	repos := make([]string, len(repoInterfaces))
-	for i, r := range repoInterfaces {
-		repos[i] = r.(string)
+	return s.ldapMgr.AssignRepositoriesToGroup(p.Context, groupCn, grants)
+}
+
+func (s *Schema) resolveAddRepoToGroup(p graphql.ResolveParams) (interface{}, error) {
+	grants, err := assignedGrants(p)
+	if err != nil {
+		return nil, err
 	}
+	return s.ldapMgr.AddGroupRepositories(p.Context, p.Args["groupCn"].(string), grants)
+}
 
-	return s.ldapMgr.AssignRepositoriesToGroup(p.Context, groupCn, repos)
+func (s *Schema) resolveRemoveRepoFromGroup(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.RemoveGroupRepositories(p.Context, p.Args["groupCn"].(string), stringList(p.Args["repositories"]))
 }
 
 func (s *Schema) resolveGroupsAll(p graphql.ResolveParams) (interface{}, error) {
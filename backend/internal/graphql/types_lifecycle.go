@@ -0,0 +1,57 @@
+package graphql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// defineDeprovisionResultType defines the DeprovisionResult GraphQL type
+func (s *Schema) defineDeprovisionResultType(userType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "DeprovisionResult",
+		Fields: graphql.Fields{
+			"user":                &graphql.Field{Type: userType},
+			"removedGroups":       &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"removedRepositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"removedDepartment":   &graphql.Field{Type: graphql.String},
+			"releasedDepartments": &graphql.Field{Type: graphql.NewList(graphql.String), Description: "Departments the user managed or was a deputy of"},
+			"deleteAfter": &graphql.Field{
+				Type:        graphql.String,
+				Description: "RFC3339 time after which the entry is deleted",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.DeprovisionResult).DeleteAfter.Format(time.RFC3339), nil
+				},
+			},
+		},
+	})
+}
+
+func (s *Schema) resolveDisableUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.DisableUser(p.Context, p.Args["uid"].(string))
+}
+
+func (s *Schema) resolveEnableUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.EnableUser(p.Context, p.Args["uid"].(string))
+}
+
+func (s *Schema) resolveSetUserExpiry(p graphql.ResolveParams) (interface{}, error) {
+	uid := p.Args["uid"].(string)
+
+	var expiresAt *time.Time
+	if v, ok := p.Args["expiresAt"].(string); ok && v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiresAt: %w", err)
+		}
+		expiresAt = &t
+	}
+
+	return s.ldapMgr.SetUserExpiry(p.Context, uid, expiresAt)
+}
+
+func (s *Schema) resolveDeprovisionUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.DeprovisionUser(p.Context, p.Args["uid"].(string))
+}
@@ -0,0 +1,162 @@
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// defineMatchOperatorEnum defines the MatchOperator GraphQL enum
+func (s *Schema) defineMatchOperatorEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "MatchOperator",
+		Values: graphql.EnumValueConfigMap{
+			"EXACT":     &graphql.EnumValueConfig{Value: models.MatchExact, Description: "The whole value matches"},
+			"PREFIX":    &graphql.EnumValueConfig{Value: models.MatchPrefix, Description: "The value starts with the given text"},
+			"SUBSTRING": &graphql.EnumValueConfig{Value: models.MatchSubstring, Description: "The value contains the given text"},
+			"PRESENT":   &graphql.EnumValueConfig{Value: models.MatchPresent, Description: "The field has any value"},
+		},
+	})
+}
+
+// defineLogicalOperatorEnum defines the LogicalOperator GraphQL enum
+func (s *Schema) defineLogicalOperatorEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "LogicalOperator",
+		Values: graphql.EnumValueConfigMap{
+			"AND": &graphql.EnumValueConfig{Value: models.LogicalAnd},
+			"OR":  &graphql.EnumValueConfig{Value: models.LogicalOr},
+		},
+	})
+}
+
+// defineFilterConditionInput defines the FilterConditionInput GraphQL type
+func (s *Schema) defineFilterConditionInput(matchOperatorEnum *graphql.Enum) *graphql.InputObject {
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "FilterConditionInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"field": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "uid, cn, sn, givenName, mail, department, repository, status, uidNumber, gidNumber or a custom field",
+			},
+			"match": &graphql.InputObjectFieldConfig{
+				Type:        matchOperatorEnum,
+				Description: "Default SUBSTRING, or EXACT for numeric fields, which support nothing else",
+			},
+			"values": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+				Description: "Matches when any of the values does",
+			},
+			"not": &graphql.InputObjectFieldConfig{Type: graphql.Boolean, Description: "Negate the condition"},
+		},
+	})
+}
+
+// defineFilterExpressionInput defines the recursive FilterExpressionInput
+// GraphQL type
+func (s *Schema) defineFilterExpressionInput(conditionInput *graphql.InputObject, logicalOperatorEnum *graphql.Enum) *graphql.InputObject {
+	var expressionInput *graphql.InputObject
+	expressionInput = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "FilterExpressionInput",
+		Description: "Conditions and nested groups combined with AND or OR",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			return graphql.InputObjectConfigFieldMap{
+				"op": &graphql.InputObjectFieldConfig{
+					Type:         logicalOperatorEnum,
+					DefaultValue: models.LogicalAnd,
+				},
+				"not":        &graphql.InputObjectFieldConfig{Type: graphql.Boolean, Description: "Negate the whole expression"},
+				"conditions": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(conditionInput))},
+				"groups":     &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(expressionInput))},
+			}
+		}),
+	})
+	return expressionInput
+}
+
+// defineDirectorySearchResultType defines the DirectorySearchResult GraphQL
+// type
+func (s *Schema) defineDirectorySearchResultType(userType, groupType, departmentType *graphql.Object) *graphql.Object {
+	kindEnum := graphql.NewEnum(graphql.EnumConfig{
+		Name: "DirectoryEntryKind",
+		Values: graphql.EnumValueConfigMap{
+			"USER":       &graphql.EnumValueConfig{Value: models.DirectoryKindUser},
+			"GROUP":      &graphql.EnumValueConfig{Value: models.DirectoryKindGroup},
+			"DEPARTMENT": &graphql.EnumValueConfig{Value: models.DirectoryKindDepartment},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "DirectorySearchResult",
+		Fields: graphql.Fields{
+			"kind":       &graphql.Field{Type: kindEnum},
+			"id":         &graphql.Field{Type: graphql.String, Description: "uid, group cn or department ou"},
+			"title":      &graphql.Field{Type: graphql.String},
+			"subtitle":   &graphql.Field{Type: graphql.String},
+			"score":      &graphql.Field{Type: graphql.Int, Description: "Relevance; results are ordered by it"},
+			"user":       &graphql.Field{Type: userType},
+			"group":      &graphql.Field{Type: groupType},
+			"department": &graphql.Field{Type: departmentType},
+		},
+	})
+}
+
+func (s *Schema) resolveSearchDirectory(p graphql.ResolveParams) (interface{}, error) {
+	query := p.Args["query"].(string)
+	limit, _ := p.Args["limit"].(int)
+
+	results, err := s.ldapMgr.SearchDirectory(p.Context, query, limit)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to search directory")
+		return nil, fmt.Errorf("failed to search directory: %w", err)
+	}
+	return results, nil
+}
+
+// parseFilterExpression converts a FilterExpressionInput argument into a
+// FilterExpression
+func parseFilterExpression(arg interface{}) *models.FilterExpression {
+	input, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	expr := &models.FilterExpression{}
+	expr.Op, _ = input["op"].(string)
+	expr.Not, _ = input["not"].(bool)
+
+	conditions, _ := input["conditions"].([]interface{})
+	for _, raw := range conditions {
+		c, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cond := models.FilterCondition{}
+		cond.Field, _ = c["field"].(string)
+		cond.Match, _ = c["match"].(string)
+		cond.Not, _ = c["not"].(bool)
+		cond.Values = stringList(c["values"])
+		expr.Conditions = append(expr.Conditions, cond)
+	}
+
+	groups, _ := input["groups"].([]interface{})
+	for _, raw := range groups {
+		if group := parseFilterExpression(raw); group != nil {
+			expr.Groups = append(expr.Groups, group)
+		}
+	}
+	return expr
+}
+
+// stringList converts a list argument into strings
+func stringList(arg interface{}) []string {
+	items, _ := arg.([]interface{})
+	var values []string
+	for _, item := range items {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,59 @@
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// defineSSHKeyType defines the SSHKey GraphQL type
+func (s *Schema) defineSSHKeyType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "SSHKey",
+		Fields: graphql.Fields{
+			"fingerprint": &graphql.Field{Type: graphql.String, Description: "SHA256 fingerprint, as printed by ssh-keygen -l"},
+			"type":        &graphql.Field{Type: graphql.String, Description: "Key algorithm, e.g. ssh-ed25519"},
+			"bits":        &graphql.Field{Type: graphql.Int},
+			"comment":     &graphql.Field{Type: graphql.String},
+			"key":         &graphql.Field{Type: graphql.String, Description: "The key as an authorized_keys line"},
+		},
+	})
+}
+
+// resolveUserSSHKeys lists the SSH keys of the user being resolved
+func (s *Schema) resolveUserSSHKeys(p graphql.ResolveParams) (interface{}, error) {
+	user, ok := p.Source.(*models.User)
+	if !ok {
+		return nil, nil
+	}
+	return s.ldapMgr.ListSSHKeys(p.Context, user.UID)
+}
+
+func (s *Schema) resolveMySSHKeys(p graphql.ResolveParams) (interface{}, error) {
+	uid := auth.GetUserFromContext(p.Context)
+	if uid == "" {
+		return nil, fmt.Errorf("unauthorized")
+	}
+	return s.ldapMgr.ListSSHKeys(p.Context, uid)
+}
+
+func (s *Schema) resolveAddSSHKey(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.AddSSHKey(p.Context, sshKeyOwnerArg(p), p.Args["key"].(string))
+}
+
+func (s *Schema) resolveRemoveSSHKey(p graphql.ResolveParams) (interface{}, error) {
+	if err := s.ldapMgr.RemoveSSHKey(p.Context, sshKeyOwnerArg(p), p.Args["fingerprint"].(string)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sshKeyOwnerArg returns the uid argument, defaulting to the caller
+func sshKeyOwnerArg(p graphql.ResolveParams) string {
+	if uid, ok := p.Args["uid"].(string); ok && uid != "" {
+		return uid
+	}
+	return auth.GetUserFromContext(p.Context)
+}
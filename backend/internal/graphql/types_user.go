@@ -2,55 +2,117 @@ package graphql
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/devplatform/ldap-manager/internal/auth"
 	"github.com/devplatform/ldap-manager/internal/models"
 	"github.com/graphql-go/graphql"
 )
 
 // defineUserType defines the User GraphQL type
-func (s *Schema) defineUserType() *graphql.Object {
-	return graphql.NewObject(graphql.ObjectConfig{
-		Name: "User",
-		Fields: graphql.Fields{
-			"uid":          &graphql.Field{Type: graphql.String},
-			"cn":           &graphql.Field{Type: graphql.String},
-			"givenName":    &graphql.Field{Type: graphql.String},
-			"sn":           &graphql.Field{Type: graphql.String},
-			"mail":         &graphql.Field{Type: graphql.String},
-			"department":   &graphql.Field{Type: graphql.String},
-			"repositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
-			"dn":           &graphql.Field{Type: graphql.String},
+func (s *Schema) defineUserType(sshKeyType, grantType *graphql.Object) *graphql.Object {
+	fields := graphql.Fields{
+		"uid":          &graphql.Field{Type: graphql.String},
+		"cn":           &graphql.Field{Type: graphql.String},
+		"givenName":    &graphql.Field{Type: graphql.String},
+		"sn":           &graphql.Field{Type: graphql.String},
+		"mail":         &graphql.Field{Type: graphql.String},
+		"department":   &graphql.Field{Type: graphql.String},
+		"repositories": &graphql.Field{Type: graphql.NewList(graphql.String), Description: "Own and group repositories"},
+		"grants":       &graphql.Field{Type: graphql.NewList(grantType), Description: "Repositories granted to the user directly"},
+		"dn":           &graphql.Field{Type: graphql.String},
+		"status":       &graphql.Field{Type: graphql.String, Description: "active, disabled or deprovisioned"},
+		"effectiveGroups": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Direct groups plus every group they are nested in",
+			Resolve:     s.resolveEffectiveAccess(func(a *models.EffectiveAccess) interface{} { return a.Groups }),
+		},
+		"effectiveDepartments": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "The user's department followed by its ancestors",
+			Resolve:     s.resolveEffectiveAccess(func(a *models.EffectiveAccess) interface{} { return a.Departments }),
+		},
+		"effectiveRepositories": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Repositories granted directly, through groups, or through departments",
+			Resolve:     s.resolveEffectiveAccess(func(a *models.EffectiveAccess) interface{} { return a.Repositories }),
+		},
+		"effectiveGrants": &graphql.Field{
+			Type:        graphql.NewList(grantType),
+			Description: "One grant per effective repository, at the highest level granted directly, through groups, or through departments",
+			Resolve:     s.resolveEffectiveAccess(func(a *models.EffectiveAccess) interface{} { return a.Grants }),
+		},
+		"expiresAt": &graphql.Field{
+			Type:        graphql.String,
+			Description: "RFC3339 time after which the account is disabled",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return formatUserTime(p.Source, func(u *models.User) *time.Time { return u.ExpiresAt }), nil
+			},
 		},
+		"deprovisionAt": &graphql.Field{
+			Type:        graphql.String,
+			Description: "RFC3339 time after which a deprovisioned account is deleted",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return formatUserTime(p.Source, func(u *models.User) *time.Time { return u.DeprovisionAt }), nil
+			},
+		},
+		"sshKeys": &graphql.Field{
+			Type:        graphql.NewList(sshKeyType),
+			Description: "SSH public keys stored on the entry",
+			Resolve:     s.resolveUserSSHKeys,
+		},
+	}
+	s.addCustomUserFields(fields)
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:   "User",
+		Fields: fields,
 	})
 }
 
+// resolveEffectiveAccess returns a resolver for one part of the user's
+// effective access
+func (s *Schema) resolveEffectiveAccess(field func(*models.EffectiveAccess) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		user, ok := p.Source.(*models.User)
+		if !ok {
+			return nil, nil
+		}
+		access, err := s.ldapMgr.GetEffectiveAccess(p.Context, user.UID)
+		if err != nil {
+			return nil, err
+		}
+		return field(access), nil
+	}
+}
+
+// formatUserTime renders an optional user timestamp as RFC3339
+func formatUserTime(source interface{}, field func(*models.User) *time.Time) interface{} {
+	user, ok := source.(*models.User)
+	if !ok || field(user) == nil {
+		return nil
+	}
+	return field(user).UTC().Format(time.RFC3339)
+}
+
 // definePaginatedUsersType defines the PaginatedUsers GraphQL type
-func (s *Schema) definePaginatedUsersType(userType *graphql.Object) *graphql.Object {
+func (s *Schema) definePaginatedUsersType(userType, pageInfoType *graphql.Object) *graphql.Object {
+	return s.defineConnectionType("PaginatedUsers", "users", "users", userType, pageInfoType)
+}
+
+// definePasswordResetType defines the PasswordReset GraphQL type
+func (s *Schema) definePasswordResetType() *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
-		Name: "PaginatedUsers",
+		Name: "PasswordReset",
 		Fields: graphql.Fields{
-			"users": &graphql.Field{
-				Type:        graphql.NewList(userType),
-				Description: "List of users",
-			},
-			"total": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Total number of users",
-			},
-			"page": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Current page number",
-			},
-			"limit": &graphql.Field{
-				Type:        graphql.Int,
-				Description: "Items per page",
-			},
+			"uid":               &graphql.Field{Type: graphql.String},
+			"temporaryPassword": &graphql.Field{Type: graphql.String, Description: "Must be changed at first login"},
 		},
 	})
 }
 
 // defineCreateUserInput defines the CreateUserInput GraphQL input type
-func (s *Schema) defineCreateUserInput() *graphql.InputObject {
+func (s *Schema) defineCreateUserInput(grantInput *graphql.InputObject) *graphql.InputObject {
 	return graphql.NewInputObject(graphql.InputObjectConfig{
 		Name: "CreateUserInput",
 		Fields: graphql.InputObjectConfigFieldMap{
@@ -61,43 +123,63 @@ func (s *Schema) defineCreateUserInput() *graphql.InputObject {
 			"mail":         &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
 			"password":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
 			"department":   &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String)},
+			"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String), Description: "Granted with WRITE permission"},
+			"grants":       &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(grantInput))},
 		},
 	})
 }
 
 // defineUpdateUserInput defines the UpdateUserInput GraphQL input type
-func (s *Schema) defineUpdateUserInput() *graphql.InputObject {
+func (s *Schema) defineUpdateUserInput(grantInput *graphql.InputObject) *graphql.InputObject {
+	fields := graphql.InputObjectConfigFieldMap{
+		"uid":          &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"cn":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"givenName":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"sn":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"mail":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"password":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"department":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String), Description: "With grants, replaces the direct grants; listed repositories keep their permission"},
+		"grants":       &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(grantInput))},
+	}
+	s.addCustomUserInputFields(fields, false)
+
 	return graphql.NewInputObject(graphql.InputObjectConfig{
-		Name: "UpdateUserInput",
-		Fields: graphql.InputObjectConfigFieldMap{
-			"uid":          &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
-			"cn":           &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"givenName":    &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"sn":           &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"mail":         &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"password":     &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"department":   &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String)},
-		},
+		Name:   "UpdateUserInput",
+		Fields: fields,
 	})
 }
 
 // defineSearchFilterInput defines the SearchFilterInput GraphQL input type
-func (s *Schema) defineSearchFilterInput() *graphql.InputObject {
-	return graphql.NewInputObject(graphql.InputObjectConfig{
-		Name: "SearchFilterInput",
-		Fields: graphql.InputObjectConfigFieldMap{
-			"uid":        &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by user ID (partial match)"},
-			"cn":         &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by common name (partial match)"},
-			"sn":         &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by surname (partial match)"},
-			"givenName":  &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by first name (partial match)"},
-			"mail":       &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by email (partial match)"},
-			"department": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by department"},
-			"uidNumber":  &graphql.InputObjectFieldConfig{Type: graphql.Int, Description: "Filter by UID number"},
-			"gidNumber":  &graphql.InputObjectFieldConfig{Type: graphql.Int, Description: "Filter by GID number"},
-			"repository": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by repository access"},
+func (s *Schema) defineSearchFilterInput(expressionInput *graphql.InputObject) *graphql.InputObject {
+	fields := graphql.InputObjectConfigFieldMap{
+		"uid":        &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by user ID (partial match)"},
+		"cn":         &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by common name (partial match)"},
+		"sn":         &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by surname (partial match)"},
+		"givenName":  &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by first name (partial match)"},
+		"mail":       &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by email (partial match)"},
+		"department": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by department"},
+		"uidNumber":  &graphql.InputObjectFieldConfig{Type: graphql.Int, Description: "Filter by UID number"},
+		"gidNumber":  &graphql.InputObjectFieldConfig{Type: graphql.Int, Description: "Filter by GID number"},
+		"repository": &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Filter by repository access"},
+		"departments": &graphql.InputObjectFieldConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Users in any of these departments",
 		},
+		"repositories": &graphql.InputObjectFieldConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Users with direct access to any of these repositories (exact match)",
+		},
+		"expression": &graphql.InputObjectFieldConfig{
+			Type:        expressionInput,
+			Description: "Boolean filter, ANDed with the other fields",
+		},
+	}
+	s.addCustomUserInputFields(fields, true)
+
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   "SearchFilterInput",
+		Fields: fields,
 	})
 }
 
@@ -106,11 +188,11 @@ func (s *Schema) defineSearchFilterInput() *graphql.InputObject {
 // ============================================================================
 
 func (s *Schema) resolveMe(p graphql.ResolveParams) (interface{}, error) {
-	user, ok := p.Context.Value("user").(*models.User)
-	if !ok {
+	uid := auth.GetUserFromContext(p.Context)
+	if uid == "" {
 		return nil, fmt.Errorf("unauthorized")
 	}
-	return user, nil
+	return s.ldapMgr.GetUser(p.Context, uid)
 }
 
 func (s *Schema) resolveUser(p graphql.ResolveParams) (interface{}, error) {
@@ -119,79 +201,32 @@ func (s *Schema) resolveUser(p graphql.ResolveParams) (interface{}, error) {
 }
 
 func (s *Schema) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
-	// Get pagination parameters
-	limit := p.Args["limit"].(int)
-	offset := p.Args["offset"].(int)
-
-	// Enforce limit constraints
-	if limit > 100 {
-		limit = 100
-	}
-	if limit <= 0 {
-		limit = 10
+	page, err := parsePageRequest(p, "users")
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse filter
-	var filter *models.SearchFilter
-	if filterInput, ok := p.Args["filter"].(map[string]interface{}); ok {
-		filter = &models.SearchFilter{}
-		if uid, ok := filterInput["uid"].(string); ok {
-			filter.UID = uid
-		}
-		if cn, ok := filterInput["cn"].(string); ok {
-			filter.CN = cn
-		}
-		if sn, ok := filterInput["sn"].(string); ok {
-			filter.SN = sn
-		}
-		if givenName, ok := filterInput["givenName"].(string); ok {
-			filter.GivenName = givenName
-		}
-		if mail, ok := filterInput["mail"].(string); ok {
-			filter.Mail = mail
-		}
-		if dept, ok := filterInput["department"].(string); ok {
-			filter.Department = dept
-		}
-		if uidNumber, ok := filterInput["uidNumber"].(int); ok {
-			filter.UIDNumber = uidNumber
-		}
-		if gidNumber, ok := filterInput["gidNumber"].(int); ok {
-			filter.GIDNumber = gidNumber
-		}
-		if repo, ok := filterInput["repository"].(string); ok {
-			filter.Repository = repo
-		}
-	}
+	filter := s.parseSearchFilter(p.Args["filter"])
 
-	// Get all users matching filter
-	allUsers, err := s.ldapMgr.ListUsers(p.Context, filter)
+	result, err := s.ldapMgr.ListUsersPage(p.Context, filter, page)
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to list users")
 		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
 
-	// Apply pagination
-	total := len(allUsers)
-	start := offset
-	end := offset + limit
+	return userConnection(result, page), nil
+}
 
-	if start > total {
-		start = total
-	}
-	if end > total {
-		end = total
+// userConnection builds the paginated result for a page of users
+func userConnection(result *models.UserPage, page *models.PageRequest) map[string]interface{} {
+	nodes := make([]interface{}, len(result.Users))
+	keys := make([]string, len(result.Users))
+	for i, user := range result.Users {
+		nodes[i] = user
+		keys[i] = user.UID
 	}
-
-	paginatedUsers := allUsers[start:end]
-
-	return map[string]interface{}{
-		"items":   paginatedUsers,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
-		"hasMore": end < total,
-	}, nil
+	return connection("users", "users", nodes, keys, page, result.PageInfo)
 }
 
 func (s *Schema) resolveUsersAll(p graphql.ResolveParams) (interface{}, error) {
@@ -204,6 +239,48 @@ func (s *Schema) resolveUsersAll(p graphql.ResolveParams) (interface{}, error) {
 	return allUsers, nil
 }
 
+// parseSearchFilter converts a SearchFilterInput argument into a SearchFilter
+func (s *Schema) parseSearchFilter(arg interface{}) *models.SearchFilter {
+	filterInput, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	filter := &models.SearchFilter{}
+	if uid, ok := filterInput["uid"].(string); ok {
+		filter.UID = uid
+	}
+	if cn, ok := filterInput["cn"].(string); ok {
+		filter.CN = cn
+	}
+	if sn, ok := filterInput["sn"].(string); ok {
+		filter.SN = sn
+	}
+	if givenName, ok := filterInput["givenName"].(string); ok {
+		filter.GivenName = givenName
+	}
+	if mail, ok := filterInput["mail"].(string); ok {
+		filter.Mail = mail
+	}
+	if dept, ok := filterInput["department"].(string); ok {
+		filter.Department = dept
+	}
+	if uidNumber, ok := filterInput["uidNumber"].(int); ok {
+		filter.UIDNumber = uidNumber
+	}
+	if gidNumber, ok := filterInput["gidNumber"].(int); ok {
+		filter.GIDNumber = gidNumber
+	}
+	if repo, ok := filterInput["repository"].(string); ok {
+		filter.Repository = repo
+	}
+	filter.Departments = stringList(filterInput["departments"])
+	filter.Repositories = stringList(filterInput["repositories"])
+	filter.Attributes = s.parseCustomFilters(filterInput)
+	filter.Expression = parseFilterExpression(filterInput["expression"])
+	return filter
+}
+
 // ============================================================================
 // USER MUTATION RESOLVERS
 // ============================================================================
@@ -227,6 +304,11 @@ func (s *Schema) resolveCreateUser(p graphql.ResolveParams) (interface{}, error)
 			input.Repositories[i] = r.(string)
 		}
 	}
+	grants, err := grantInputs(inputMap["grants"])
+	if err != nil {
+		return nil, err
+	}
+	input.Grants = grants
 
 	return s.ldapMgr.CreateUser(p.Context, input)
 }
@@ -262,6 +344,16 @@ func (s *Schema) resolveUpdateUser(p graphql.ResolveParams) (interface{}, error)
 			input.Repositories[i] = r.(string)
 		}
 	}
+	grants, err := grantInputs(inputMap["grants"])
+	if err != nil {
+		return nil, err
+	}
+	input.Grants = grants
+	attrs, err := s.parseCustomAttributes(inputMap)
+	if err != nil {
+		return nil, err
+	}
+	input.Attributes = attrs
 
 	return s.ldapMgr.UpdateUser(p.Context, input)
 }
@@ -274,17 +366,52 @@ func (s *Schema) resolveDeleteUser(p graphql.ResolveParams) (interface{}, error)
 
 func (s *Schema) resolveAssignRepoToUser(p graphql.ResolveParams) (interface{}, error) {
 	uid := p.Args["uid"].(string)
-	repoInterfaces := p.Args["repositories"].([]interface{})
-
-	repos := make([]string, len(repoInterfaces))
-	for i, r := range repoInterfaces {
-		repos[i] = r.(string)
+	grants, err := assignedGrants(p)
+	if err != nil {
+		return nil, err
 	}
 
 	input := &models.UpdateUserInput{
-		UID:          uid,
-		Repositories: repos,
+		UID:    uid,
+		Grants: grants,
 	}
 
 	return s.ldapMgr.UpdateUser(p.Context, input)
 }
+
+func (s *Schema) resolveAddRepoToUser(p graphql.ResolveParams) (interface{}, error) {
+	grants, err := assignedGrants(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.ldapMgr.AddUserRepositories(p.Context, p.Args["uid"].(string), grants)
+}
+
+func (s *Schema) resolveRemoveRepoFromUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.ldapMgr.RemoveUserRepositories(p.Context, p.Args["uid"].(string), stringList(p.Args["repositories"]))
+}
+
+func (s *Schema) resolveChangeMyPassword(p graphql.ResolveParams) (interface{}, error) {
+	uid := auth.GetUserFromContext(p.Context)
+	oldPassword := p.Args["oldPassword"].(string)
+	newPassword := p.Args["newPassword"].(string)
+
+	if err := s.ldapMgr.ChangePassword(p.Context, uid, oldPassword, newPassword); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveResetPassword(p graphql.ResolveParams) (interface{}, error) {
+	uid := p.Args["uid"].(string)
+
+	password, err := s.ldapMgr.ResetPassword(p.Context, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"uid":               uid,
+		"temporaryPassword": password,
+	}, nil
+}
@@ -0,0 +1,489 @@
+package ldap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Access request attributes (devplatform schema). The requested grant is
+// kept in repositoryGrant, with its expiry once approved, and the reason
+// in description.
+const (
+	accessRequestStatusAttr   = "accessRequestStatus"
+	accessRequesterAttr       = "accessRequester"
+	accessRequestDurationAttr = "accessRequestDuration"
+	accessRequestedAtAttr     = "accessRequestedAt"
+	accessReviewerAttr        = "accessReviewer"
+	accessReviewedAtAttr      = "accessReviewedAt"
+)
+
+// accessRequestAttributes are the attributes read for every access request
+var accessRequestAttributes = []string{
+	"cn", "description", repositoryGrantAttr, accessRequestStatusAttr, accessRequesterAttr,
+	accessRequestDurationAttr, accessRequestedAtAttr, accessReviewerAttr, accessReviewedAtAttr,
+}
+
+// CreateAccessRequest records a pending request by input.Requester for
+// time-bound access to a repository. A user has at most one pending
+// request per repository.
+func (m *Manager) CreateAccessRequest(ctx context.Context, input *models.AccessRequestInput) (*models.AccessRequest, error) {
+	if err := input.Validate(m.config.AccessRequestMaxDuration); err != nil {
+		return nil, err
+	}
+
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	if _, err := m.userDirectGrants(conn, input.Requester); err != nil {
+		return nil, err
+	}
+
+	pending, err := m.searchAccessRequests(conn, &models.AccessRequestFilter{Requester: input.Requester, Status: models.AccessRequestPending})
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range pending {
+		if strings.EqualFold(request.Repository, input.Repository) {
+			return nil, &models.ValidationError{Problems: []string{
+				fmt.Sprintf("request %s for %s is already pending", request.ID, request.Repository),
+			}}
+		}
+	}
+
+	id, err := newAccessRequestID()
+	if err != nil {
+		return nil, err
+	}
+	grant := models.RepositoryGrant{Repository: input.Repository, Permission: input.Permission}
+
+	addRequest := ldap.NewAddRequest(m.config.AccessRequestDN(id), nil)
+	addRequest.Attribute("objectClass", []string{"applicationProcess", "extensibleObject"})
+	addRequest.Attribute("cn", []string{id})
+	addRequest.Attribute("description", []string{input.Reason})
+	addRequest.Attribute(repositoryGrantAttr, []string{grant.String()})
+	addRequest.Attribute(accessRequesterAttr, []string{input.Requester})
+	addRequest.Attribute(accessRequestDurationAttr, []string{strconv.FormatInt(int64(input.Duration/time.Second), 10)})
+	addRequest.Attribute(accessRequestStatusAttr, []string{models.AccessRequestPending})
+	addRequest.Attribute(accessRequestedAtAttr, []string{formatGeneralizedTime(time.Now())})
+
+	err = conn.Add(addRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		// First request on this directory
+		if err = m.ensureAccessRequestsOU(conn); err == nil {
+			err = conn.Add(addRequest)
+		}
+	}
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to create access request")
+		return nil, fmt.Errorf("failed to add access request: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"id":         id,
+		"requester":  input.Requester,
+		"repository": input.Repository,
+		"permission": input.Permission,
+		"duration":   input.Duration,
+	}).Info("Access request created")
+	return m.accessRequest(conn, id)
+}
+
+// GetAccessRequest retrieves an access request by ID
+func (m *Manager) GetAccessRequest(ctx context.Context, id string) (*models.AccessRequest, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	return m.accessRequest(conn, id)
+}
+
+// ListAccessRequests lists the access requests matching filter, newest
+// first
+func (m *Manager) ListAccessRequests(ctx context.Context, filter *models.AccessRequestFilter) ([]*models.AccessRequest, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	return m.searchAccessRequests(conn, filter)
+}
+
+// ReviewAccessRequest approves or denies a pending access request.
+// Approval adds the requested grant, expiring after the requested
+// duration, to the requester's direct grants. The review is a
+// compare-and-swap on the status, so of two concurrent reviews one fails
+// with ErrAccessRequestReviewed.
+func (m *Manager) ReviewAccessRequest(ctx context.Context, id, reviewer string, approve bool) (*models.AccessRequest, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	request, err := m.accessRequest(conn, id)
+	if err != nil {
+		return nil, err
+	}
+	if request.Status != models.AccessRequestPending {
+		return nil, fmt.Errorf("%w: %s is %s", models.ErrAccessRequestReviewed, id, request.Status)
+	}
+
+	now := time.Now()
+	status := models.AccessRequestDenied
+	grant := models.RepositoryGrant{Repository: request.Repository, Permission: request.Permission}
+	if approve {
+		status = models.AccessRequestApproved
+		grant = request.Grant(now)
+	}
+
+	modifyRequest := ldap.NewModifyRequest(m.config.AccessRequestDN(id), nil)
+	modifyRequest.Delete(accessRequestStatusAttr, []string{models.AccessRequestPending})
+	modifyRequest.Add(accessRequestStatusAttr, []string{status})
+	modifyRequest.Replace(accessReviewerAttr, []string{reviewer})
+	modifyRequest.Replace(accessReviewedAtAttr, []string{formatGeneralizedTime(now)})
+	modifyRequest.Replace(repositoryGrantAttr, []string{grant.String()})
+	if err := conn.Modify(modifyRequest); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			return nil, fmt.Errorf("%w: %s", models.ErrAccessRequestReviewed, id)
+		}
+		return nil, fmt.Errorf("failed to review access request: %w", err)
+	}
+
+	if approve {
+		if err := m.addUserGrant(conn, request.Requester, grant); err != nil {
+			// Put the request back so it can be reviewed again
+			rollback := ldap.NewModifyRequest(m.config.AccessRequestDN(id), nil)
+			rollback.Replace(accessRequestStatusAttr, []string{models.AccessRequestPending})
+			rollback.Replace(accessReviewerAttr, []string{})
+			rollback.Replace(accessReviewedAtAttr, []string{})
+			rollback.Replace(repositoryGrantAttr, []string{models.RepositoryGrant{Repository: request.Repository, Permission: request.Permission}.String()})
+			if rbErr := conn.Modify(rollback); rbErr != nil {
+				m.logger.WithError(rbErr).WithField("id", id).Error("Failed to reset access request after a failed approval")
+			}
+			return nil, err
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"id":       id,
+		"status":   status,
+		"reviewer": reviewer,
+	}).Info("Access request reviewed")
+	return m.accessRequest(conn, id)
+}
+
+// addUserGrant adds a grant to a user's direct grants next to any grant
+// the user already holds for the repository, so a permanent grant
+// survives a time-bound one expiring, and resyncs githubRepository
+func (m *Manager) addUserGrant(conn *ldap.Conn, uid string, grant models.RepositoryGrant) error {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UserDN(uid),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"objectClass"},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil || len(result.Entries) == 0 {
+		if err == nil || ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+		}
+		return fmt.Errorf("search failed: %w", err)
+	}
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	if !hasObjectClass(result.Entries[0], "extensibleObject") {
+		modifyRequest.Add("objectClass", []string{"extensibleObject"})
+	}
+	modifyRequest.Add(repositoryGrantAttr, []string{grant.String()})
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		return fmt.Errorf("failed to grant access: %w", err)
+	}
+
+	return m.syncUserReposFromGroups(conn, uid)
+}
+
+// RevokeExpiredGrants removes the grants that expired by now from users,
+// groups and departments, refreshes the githubRepository values derived
+// from them, and marks the approved access requests whose grant ran out
+// as expired. The result lists what was revoked, also when it fails
+// part-way.
+func (m *Manager) RevokeExpiredGrants(ctx context.Context, now time.Time) (*models.GrantSweepResult, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	result := &models.GrantSweepResult{}
+
+	groups, err := m.revokeEntryGrants(conn, m.config.GroupsDN(), "groupOfNames", now, true)
+	result.Groups = len(groups)
+	result.Revoked = append(result.Revoked, groups...)
+	if err != nil {
+		return result, err
+	}
+
+	departments, err := m.revokeEntryGrants(conn, m.config.DepartmentsDN(), "organizationalUnit", now, true)
+	result.Departments = len(departments)
+	result.Revoked = append(result.Revoked, departments...)
+	if err != nil {
+		return result, err
+	}
+
+	users, err := m.revokeEntryGrants(conn, m.config.UsersDN(), "inetOrgPerson", now, false)
+	result.Users = len(users)
+	result.Revoked = append(result.Revoked, users...)
+	if err != nil {
+		return result, err
+	}
+
+	// Members of changed groups lose the expired repositories too
+	if len(groups) > 0 || len(users) > 0 {
+		graph, err := m.loadGroupGraph(conn)
+		if err != nil {
+			return result, err
+		}
+		affected := make(map[string]string)
+		for _, revoked := range users {
+			affected[strings.ToLower(revoked.DN)] = revoked.DN
+		}
+		for _, revoked := range groups {
+			for _, userDN := range groupUserDNs(graph, rdnValue(revoked.DN, "cn")) {
+				affected[strings.ToLower(userDN)] = userDN
+			}
+		}
+		userDNs := make([]string, 0, len(affected))
+		for _, dn := range affected {
+			userDNs = append(userDNs, dn)
+		}
+		sort.Strings(userDNs)
+		if err := m.syncUsersRepos(conn, graph, userDNs); err != nil {
+			return result, err
+		}
+	}
+
+	approved, err := m.searchAccessRequests(conn, &models.AccessRequestFilter{Status: models.AccessRequestApproved})
+	if err != nil {
+		return result, err
+	}
+	for _, request := range approved {
+		if request.ExpiresAt == nil || request.ExpiresAt.After(now) {
+			continue
+		}
+		modifyRequest := ldap.NewModifyRequest(m.config.AccessRequestDN(request.ID), nil)
+		modifyRequest.Delete(accessRequestStatusAttr, []string{models.AccessRequestApproved})
+		modifyRequest.Add(accessRequestStatusAttr, []string{models.AccessRequestExpired})
+		if err := conn.Modify(modifyRequest); err != nil {
+			// Another replica's sweep got there first
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+				continue
+			}
+			return result, fmt.Errorf("failed to expire access request %s: %w", request.ID, err)
+		}
+		result.ExpiredRequests++
+		result.ExpiredRequestIDs = append(result.ExpiredRequestIDs, request.ID)
+	}
+
+	if result.Users+result.Groups+result.Departments+result.ExpiredRequests > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"users":           result.Users,
+			"groups":          result.Groups,
+			"departments":     result.Departments,
+			"expiredRequests": result.ExpiredRequests,
+		}).Info("Expired repository grants revoked")
+	}
+	return result, nil
+}
+
+// revokeEntryGrants deletes the grants expired at now from the entries
+// below baseDN and returns what it removed from each entry, up to the
+// first failure. With mirror
+// set, githubRepository is reset to the repositories of the remaining
+// grants, as groups and departments keep it; user values also reflect
+// group grants and are resynced by the caller.
+func (m *Manager) revokeEntryGrants(conn *ldap.Conn, baseDN, objectClass string, now time.Time, mirror bool) ([]models.RevokedGrants, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=%s)(%s=*))", objectClass, repositoryGrantAttr),
+		[]string{"githubRepository", repositoryGrantAttr},
+		nil,
+	)
+
+	// Modify once the search is done rather than between its pages
+	var modifyRequests []*ldap.ModifyRequest
+	var pending []models.RevokedGrants
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		var expired []string
+		for _, value := range entry.GetAttributeValues(repositoryGrantAttr) {
+			if grant, err := models.ParseRepositoryGrant(value); err == nil && !grant.Active(now) {
+				expired = append(expired, value)
+			}
+		}
+		if len(expired) == 0 {
+			return
+		}
+
+		modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+		modifyRequest.Delete(repositoryGrantAttr, expired)
+		if mirror {
+			modifyRequest.Replace("githubRepository", models.GrantedRepositories(m.entryGrants(entry, true), now))
+		}
+		modifyRequests = append(modifyRequests, modifyRequest)
+		pending = append(pending, models.RevokedGrants{DN: entry.DN, Grants: expired})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", baseDN, err)
+	}
+
+	var revoked []models.RevokedGrants
+	for i, modifyRequest := range modifyRequests {
+		if err := conn.Modify(modifyRequest); err != nil {
+			// Another replica's sweep revoked them first
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+				continue
+			}
+			return revoked, fmt.Errorf("failed to revoke grants of %s: %w", modifyRequest.DN, err)
+		}
+		revoked = append(revoked, pending[i])
+	}
+	return revoked, nil
+}
+
+// accessRequest reads one access request
+func (m *Manager) accessRequest(conn *ldap.Conn, id string) (*models.AccessRequest, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.AccessRequestDN(id),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=applicationProcess)",
+		accessRequestAttributes,
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("%w: %s", models.ErrAccessRequestNotFound, id)
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", models.ErrAccessRequestNotFound, id)
+	}
+	return m.entryToAccessRequest(result.Entries[0]), nil
+}
+
+// searchAccessRequests lists the access requests matching filter, newest
+// first. A directory without requests yet has no ou=accessRequests.
+func (m *Manager) searchAccessRequests(conn *ldap.Conn, filter *models.AccessRequestFilter) ([]*models.AccessRequest, error) {
+	conditions := []string{"(objectClass=applicationProcess)"}
+	if filter != nil {
+		if filter.Status != "" {
+			conditions = append(conditions, fmt.Sprintf("(%s=%s)", accessRequestStatusAttr, ldap.EscapeFilter(filter.Status)))
+		}
+		if filter.Requester != "" {
+			conditions = append(conditions, fmt.Sprintf("(%s=%s)", accessRequesterAttr, ldap.EscapeFilter(filter.Requester)))
+		}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.AccessRequestsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(&"+strings.Join(conditions, "")+")",
+		accessRequestAttributes,
+		nil,
+	)
+
+	requests := []*models.AccessRequest{}
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		request := m.entryToAccessRequest(entry)
+		// Repositories are matched here: the grant value also holds the permission
+		if filter != nil && filter.Repository != "" && !strings.EqualFold(request.Repository, filter.Repository) {
+			return
+		}
+		requests = append(requests, request)
+	})
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return requests, nil
+		}
+		return nil, fmt.Errorf("failed to search access requests: %w", err)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].RequestedAt.Equal(requests[j].RequestedAt) {
+			return requests[i].RequestedAt.After(requests[j].RequestedAt)
+		}
+		return requests[i].ID > requests[j].ID
+	})
+	return requests, nil
+}
+
+// ensureAccessRequestsOU creates ou=accessRequests if it does not exist
+func (m *Manager) ensureAccessRequestsOU(conn *ldap.Conn) error {
+	addRequest := ldap.NewAddRequest(m.config.AccessRequestsDN(), nil)
+	addRequest.Attribute("objectClass", []string{"organizationalUnit"})
+	addRequest.Attribute("ou", []string{"accessRequests"})
+	if err := conn.Add(addRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+		return fmt.Errorf("failed to create %s: %w", m.config.AccessRequestsDN(), err)
+	}
+	return nil
+}
+
+func (m *Manager) entryToAccessRequest(entry *ldap.Entry) *models.AccessRequest {
+	request := &models.AccessRequest{
+		ID:         entry.GetAttributeValue("cn"),
+		Requester:  entry.GetAttributeValue(accessRequesterAttr),
+		Reason:     entry.GetAttributeValue("description"),
+		Status:     entry.GetAttributeValue(accessRequestStatusAttr),
+		ReviewedBy: entry.GetAttributeValue(accessReviewerAttr),
+		ReviewedAt: parseGeneralizedTime(entry.GetAttributeValue(accessReviewedAtAttr)),
+	}
+	if seconds, err := strconv.ParseInt(entry.GetAttributeValue(accessRequestDurationAttr), 10, 64); err == nil {
+		request.Duration = time.Duration(seconds) * time.Second
+	}
+	if requestedAt := parseGeneralizedTime(entry.GetAttributeValue(accessRequestedAtAttr)); requestedAt != nil {
+		request.RequestedAt = *requestedAt
+	}
+	if grants := m.entryGrants(entry, false); len(grants) > 0 {
+		request.Repository = grants[0].Repository
+		request.Permission = grants[0].Permission
+		if request.Status == models.AccessRequestApproved || request.Status == models.AccessRequestExpired {
+			request.ExpiresAt = grants[0].ExpiresAt
+		}
+	}
+	return request
+}
+
+// newAccessRequestID returns a random access request ID
+func newAccessRequestID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate access request ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
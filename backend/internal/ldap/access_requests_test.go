@@ -0,0 +1,207 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func TestAccessRequestInputValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   models.AccessRequestInput
+		wantErr bool
+	}{
+		{name: "valid", input: models.AccessRequestInput{Repository: "repo/a", Permission: "read", Reason: "on call", Duration: time.Hour}},
+		{name: "default permission", input: models.AccessRequestInput{Repository: "repo/a", Reason: "on call", Duration: time.Hour}},
+		{name: "missing reason", input: models.AccessRequestInput{Repository: "repo/a", Duration: time.Hour}, wantErr: true},
+		{name: "unknown permission", input: models.AccessRequestInput{Repository: "repo/a", Permission: "owner", Reason: "x", Duration: time.Hour}, wantErr: true},
+		{name: "no duration", input: models.AccessRequestInput{Repository: "repo/a", Reason: "x"}, wantErr: true},
+		{name: "too long", input: models.AccessRequestInput{Repository: "repo/a", Reason: "x", Duration: 31 * 24 * time.Hour}, wantErr: true},
+		{name: "empty repository", input: models.AccessRequestInput{Reason: "x", Duration: time.Hour}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.input.Validate(30 * 24 * time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.input.Permission == "" {
+				t.Error("Validate() left the permission empty")
+			}
+		})
+	}
+}
+
+func TestAccessRequestWorkflow(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+	aliceDN := "uid=alice,ou=users," + testBaseDN
+
+	request, err := m.CreateAccessRequest(ctx, &models.AccessRequestInput{
+		Requester:  "alice",
+		Repository: "repo/a",
+		Permission: "admin",
+		Reason:     "release",
+		Duration:   2 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("CreateAccessRequest() error = %v", err)
+	}
+	if request.Status != models.AccessRequestPending || request.Permission != "admin" || request.Duration != 2*time.Hour {
+		t.Errorf("CreateAccessRequest() = %+v", request)
+	}
+
+	_, err = m.CreateAccessRequest(ctx, &models.AccessRequestInput{Requester: "alice", Repository: "repo/a", Reason: "again", Duration: time.Hour})
+	var validation *models.ValidationError
+	if !errors.As(err, &validation) {
+		t.Errorf("second pending request error = %v, want a ValidationError", err)
+	}
+	_, err = m.CreateAccessRequest(ctx, &models.AccessRequestInput{Requester: "nobody", Repository: "repo/a", Reason: "x", Duration: time.Hour})
+	if !errors.Is(err, models.ErrUserNotFound) {
+		t.Errorf("request by unknown user error = %v, want ErrUserNotFound", err)
+	}
+
+	approved, err := m.ReviewAccessRequest(ctx, request.ID, "carol", true)
+	if err != nil {
+		t.Fatalf("ReviewAccessRequest() error = %v", err)
+	}
+	if approved.Status != models.AccessRequestApproved || approved.ReviewedBy != "carol" || approved.ExpiresAt == nil {
+		t.Fatalf("approved request = %+v", approved)
+	}
+	if until := time.Until(*approved.ExpiresAt); until < time.Hour || until > 2*time.Hour {
+		t.Errorf("grant expires in %s, want about 2h", until)
+	}
+
+	if _, err := m.ReviewAccessRequest(ctx, request.ID, "carol", false); !errors.Is(err, models.ErrAccessRequestReviewed) {
+		t.Errorf("second review error = %v, want ErrAccessRequestReviewed", err)
+	}
+
+	// The time-bound admin grant sits next to alice's permanent read grant
+	user, err := m.GetUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if len(user.Grants) != 2 {
+		t.Fatalf("grants after approval = %v, want the permanent and the approved one", user.Grants)
+	}
+	access, err := m.GetEffectiveAccess(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetEffectiveAccess() error = %v", err)
+	}
+	if access.Grants[0].Repository != "repo/a" || access.Grants[0].Permission != "admin" {
+		t.Errorf("effective grant = %v, want admin on repo/a", access.Grants[0])
+	}
+
+	listed, err := m.ListAccessRequests(ctx, &models.AccessRequestFilter{Requester: "alice", Status: models.AccessRequestApproved})
+	if err != nil || len(listed) != 1 || listed[0].ID != request.ID {
+		t.Errorf("ListAccessRequests() = %v, %v, want the approved request", listed, err)
+	}
+
+	var approvedGrant string
+	for _, value := range dir.entry(aliceDN).GetAttributeValues(repositoryGrantAttr) {
+		if strings.HasPrefix(value, "repo/a admin ") {
+			approvedGrant = value
+		}
+	}
+
+	// A sweep after the grant ran out revokes it and expires the request
+	result, err := m.RevokeExpiredGrants(ctx, approved.ExpiresAt.Add(time.Second))
+	if err != nil {
+		t.Fatalf("RevokeExpiredGrants() error = %v", err)
+	}
+	if result.Users != 1 || result.ExpiredRequests != 1 {
+		t.Errorf("RevokeExpiredGrants() = %+v, want one user and one request", *result)
+	}
+	// developers' expired grant goes in the same sweep
+	want := models.RevokedGrants{DN: aliceDN, Grants: []string{approvedGrant}}
+	if n := len(result.Revoked); n != 2 || !reflect.DeepEqual(result.Revoked[n-1], want) {
+		t.Errorf("RevokeExpiredGrants() revoked %+v, want the group's grant then %+v", result.Revoked, want)
+	}
+	if !reflect.DeepEqual(result.ExpiredRequestIDs, []string{request.ID}) {
+		t.Errorf("RevokeExpiredGrants() expired requests %v, want %s", result.ExpiredRequestIDs, request.ID)
+	}
+	if got, want := dir.entry(aliceDN).GetAttributeValues(repositoryGrantAttr), []string{"repo/a read"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice grants after the sweep = %v, want %v", got, want)
+	}
+	expired, err := m.GetAccessRequest(ctx, request.ID)
+	if err != nil || expired.Status != models.AccessRequestExpired {
+		t.Errorf("GetAccessRequest() = %+v, %v, want an expired request", expired, err)
+	}
+}
+
+func TestAccessRequestDenied(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+
+	request, err := m.CreateAccessRequest(ctx, &models.AccessRequestInput{Requester: "bob", Repository: "repo/z", Reason: "curious", Duration: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateAccessRequest() error = %v", err)
+	}
+	denied, err := m.ReviewAccessRequest(ctx, request.ID, "carol", false)
+	if err != nil {
+		t.Fatalf("ReviewAccessRequest() error = %v", err)
+	}
+	if denied.Status != models.AccessRequestDenied || denied.ExpiresAt != nil {
+		t.Errorf("denied request = %+v", denied)
+	}
+	if got := dir.entry("uid=bob,ou=users," + testBaseDN).GetAttributeValues(repositoryGrantAttr); len(got) != 0 {
+		t.Errorf("bob grants after denial = %v, want none", got)
+	}
+
+	// Once reviewed, the same repository can be requested again
+	if _, err := m.CreateAccessRequest(ctx, &models.AccessRequestInput{Requester: "bob", Repository: "repo/z", Reason: "really", Duration: time.Hour}); err != nil {
+		t.Errorf("new request after denial error = %v", err)
+	}
+}
+
+func TestRevokeExpiredGroupGrants(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	groupDN := "cn=developers,ou=groups," + testBaseDN
+
+	// developers holds an expired admin grant on repo/gone
+	var gone string
+	for _, value := range dir.entry(groupDN).GetAttributeValues(repositoryGrantAttr) {
+		if strings.HasPrefix(value, "repo/gone ") {
+			gone = value
+		}
+	}
+	result, err := m.RevokeExpiredGrants(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("RevokeExpiredGrants() error = %v", err)
+	}
+	want := &models.GrantSweepResult{Groups: 1, Revoked: []models.RevokedGrants{{DN: groupDN, Grants: []string{gone}}}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("RevokeExpiredGrants() = %+v, want %+v", *result, *want)
+	}
+
+	grants := dir.entry(groupDN).GetAttributeValues(repositoryGrantAttr)
+	if !reflect.DeepEqual(grants, []string{"repo/a write"}) {
+		t.Errorf("group grants = %v, want the expired one gone", grants)
+	}
+	// The legacy repo/b value stays until migrated
+	repos := dir.entry(groupDN).GetAttributeValues("githubRepository")
+	sort.Strings(repos)
+	if want := []string{"repo/a", "repo/b"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("group githubRepository = %v, want %v", repos, want)
+	}
+	alice := dir.entry("uid=alice,ou=users," + testBaseDN).GetAttributeValues("githubRepository")
+	if want := []string{"repo/a", "repo/b"}; !reflect.DeepEqual(alice, want) {
+		t.Errorf("alice githubRepository = %v, want %v", alice, want)
+	}
+
+	again, err := m.RevokeExpiredGrants(context.Background(), time.Now())
+	if err != nil || !reflect.DeepEqual(again, &models.GrantSweepResult{}) {
+		t.Errorf("second RevokeExpiredGrants() = %+v, %v, want nothing revoked", again, err)
+	}
+}
@@ -0,0 +1,70 @@
+package ldap
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// userSearchAttributes returns the attributes read for every user entry,
+// including the custom ones from USER_ATTRIBUTES_FILE
+func (m *Manager) userSearchAttributes() []string {
+	attrs := append([]string{}, userAttributes...)
+	for _, def := range m.config.UserAttributes {
+		attrs = append(attrs, def.LDAPAttribute)
+	}
+	return attrs
+}
+
+// entryCustomAttributes reads the custom attributes of a user entry,
+// keyed by field name
+func (m *Manager) entryCustomAttributes(entry *ldap.Entry) map[string][]string {
+	if len(m.config.UserAttributes) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string][]string)
+	for _, def := range m.config.UserAttributes {
+		if values := entry.GetEqualFoldAttributeValues(def.LDAPAttribute); len(values) > 0 {
+			attrs[def.Field] = values
+		}
+	}
+	return attrs
+}
+
+// customAttributeFilters returns the LDAP filter components matching the
+// custom attributes of a search filter
+func (m *Manager) customAttributeFilters(attrs map[string]string) ([]string, error) {
+	var filters []string
+	for field, value := range attrs {
+		def, ok := m.config.UserAttribute(field)
+		if !ok {
+			return nil, fmt.Errorf("unknown user attribute %q", field)
+		}
+		if def.Type == models.AttributeTypeString {
+			filters = append(filters, fmt.Sprintf("(%s=*%s*)", def.LDAPAttribute, ldap.EscapeFilter(value)))
+		} else {
+			filters = append(filters, fmt.Sprintf("(%s=%s)", def.LDAPAttribute, ldap.EscapeFilter(value)))
+		}
+	}
+	return filters, nil
+}
+
+// replaceCustomAttributes adds the changes to custom attributes to a
+// modify request, validating the values first
+func (m *Manager) replaceCustomAttributes(modifyRequest *ldap.ModifyRequest, attrs map[string][]string) error {
+	for field, values := range attrs {
+		def, ok := m.config.UserAttribute(field)
+		if !ok {
+			return &models.ValidationError{Problems: []string{fmt.Sprintf("unknown user attribute %q", field)}}
+		}
+		if err := def.ValidateValues(values); err != nil {
+			return err
+		}
+		// Replacing with no values removes the attribute, and unlike a
+		// delete does not fail when it is absent
+		modifyRequest.Replace(def.LDAPAttribute, values)
+	}
+	return nil
+}
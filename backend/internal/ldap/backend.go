@@ -0,0 +1,179 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Backend roles
+const (
+	BackendProvider = "provider" // writable server, also serves reads when needed
+	BackendConsumer = "consumer" // read-only replica
+)
+
+// errBackendDown marks errors caused by a backend being unreachable, as
+// opposed to its pool being exhausted, so callers know to fail over
+var errBackendDown = errors.New("backend unreachable")
+
+// isBackendDown reports whether err means the backend could not be reached
+func isBackendDown(err error) bool {
+	return errors.Is(err, errBackendDown)
+}
+
+// backend is one LDAP server with its own connection pool and circuit
+// breaker. After LDAP_BREAKER_THRESHOLD consecutive failures the breaker
+// opens and the backend is skipped for LDAP_BREAKER_COOLDOWN; the next
+// attempt after that either closes it again or reopens it.
+type backend struct {
+	m       *Manager
+	url     string
+	role    string
+	pool    chan *pooledConn // idle connections, oldest first
+	minSize int
+	maxSize int
+
+	// conns tracks every open pooled connection, idle or checked out;
+	// dialing counts connections being created. Both are guarded by poolMu.
+	poolMu  sync.Mutex
+	conns   map[*ldap.Conn]*pooledConn
+	dialing int
+	// freed wakes a waiting get when a slot becomes free
+	freed    chan struct{}
+	counters poolCounters
+	requests int64 // connections handed out
+
+	breakerMu sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// newBackend creates a backend with an empty pool
+func newBackend(m *Manager, url, role string) *backend {
+	maxSize := m.config.LDAPPoolSize
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	minSize := m.config.LDAPPoolMinSize
+	if minSize < 0 {
+		minSize = 0
+	}
+	if minSize > maxSize {
+		minSize = maxSize
+	}
+
+	return &backend{
+		m:       m,
+		url:     url,
+		role:    role,
+		pool:    make(chan *pooledConn, maxSize),
+		minSize: minSize,
+		maxSize: maxSize,
+		conns:   make(map[*ldap.Conn]*pooledConn),
+		freed:   make(chan struct{}, 1),
+	}
+}
+
+// available reports whether the breaker lets requests through
+func (b *backend) available(now time.Time) bool {
+	b.breakerMu.Lock()
+	defer b.breakerMu.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+// recordFailure counts a failure to reach the backend and opens the
+// breaker once the threshold is reached
+func (b *backend) recordFailure(err error) {
+	threshold := b.m.config.LDAPBreakerThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	b.breakerMu.Lock()
+	b.failures++
+	open := b.failures >= threshold
+	if open {
+		b.openUntil = time.Now().Add(b.m.config.LDAPBreakerCooldown)
+	}
+	failures := b.failures
+	b.breakerMu.Unlock()
+
+	if open {
+		b.m.logger.WithError(err).WithFields(logrus.Fields{
+			"backend":  b.url,
+			"role":     b.role,
+			"failures": failures,
+			"cooldown": b.m.config.LDAPBreakerCooldown,
+		}).Warn("LDAP backend marked down")
+	}
+}
+
+// recordSuccess closes the breaker
+func (b *backend) recordSuccess() {
+	b.breakerMu.Lock()
+	recovered := !b.openUntil.IsZero()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.breakerMu.Unlock()
+
+	if recovered {
+		b.m.logger.WithFields(logrus.Fields{"backend": b.url, "role": b.role}).Info("LDAP backend recovered")
+	}
+}
+
+// stats returns the backend's share of the pool statistics
+func (b *backend) stats() models.BackendStats {
+	b.poolMu.Lock()
+	open := len(b.conns)
+	b.poolMu.Unlock()
+	idle := len(b.pool)
+
+	b.breakerMu.Lock()
+	healthy := !time.Now().Before(b.openUntil)
+	failures := b.failures
+	b.breakerMu.Unlock()
+
+	return models.BackendStats{
+		URL:       b.url,
+		Role:      b.role,
+		Healthy:   healthy,
+		Failures:  failures,
+		Requests:  int(atomic.LoadInt64(&b.requests)),
+		PoolSize:  open,
+		Available: idle,
+		InUse:     open - idle,
+	}
+}
+
+// fill opens connections until the pool holds its minimum size
+func (b *backend) fill() error {
+	for b.size() < b.minSize {
+		pc, err := b.grow()
+		if err != nil {
+			return fmt.Errorf("%s: %w", b.url, err)
+		}
+		if pc == nil {
+			return nil
+		}
+		b.putIdle(pc)
+	}
+	return nil
+}
+
+// close closes the idle queue and every idle connection in it, returning
+// how many it closed. Waiting callers of get fail immediately.
+func (b *backend) close() int {
+	close(b.pool)
+	count := 0
+	for pc := range b.pool {
+		b.discard(pc, nil)
+		count++
+	}
+	return count
+}
@@ -0,0 +1,223 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+)
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		cooldown  time.Duration
+		events    []bool // true for a success, false for a failure
+		wantOpen  bool
+	}{
+		{
+			name:      "stays closed below the threshold",
+			threshold: 3,
+			cooldown:  time.Minute,
+			events:    []bool{false, false},
+		},
+		{
+			name:      "opens at the threshold",
+			threshold: 3,
+			cooldown:  time.Minute,
+			events:    []bool{false, false, false},
+			wantOpen:  true,
+		},
+		{
+			name:      "a success resets the count",
+			threshold: 3,
+			cooldown:  time.Minute,
+			events:    []bool{false, false, true, false, false},
+		},
+		{
+			name:      "a success closes an open breaker",
+			threshold: 1,
+			cooldown:  time.Minute,
+			events:    []bool{false, true},
+		},
+		{
+			name:      "thresholds below one open on the first failure",
+			threshold: 0,
+			cooldown:  time.Minute,
+			events:    []bool{false},
+			wantOpen:  true,
+		},
+		{
+			name:      "lets requests through after the cooldown",
+			threshold: 1,
+			events:    []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetLevel(logrus.PanicLevel)
+			m := &Manager{
+				config: &config.Config{LDAPPoolSize: 1, LDAPBreakerThreshold: tt.threshold, LDAPBreakerCooldown: tt.cooldown},
+				logger: logger,
+			}
+			b := newBackend(m, "ldap://ldap.example.com", BackendProvider)
+
+			for _, success := range tt.events {
+				if success {
+					b.recordSuccess()
+				} else {
+					b.recordFailure(errors.New("connection refused"))
+				}
+			}
+
+			if open := !b.available(time.Now().Add(time.Millisecond)); open != tt.wantOpen {
+				t.Errorf("breaker open = %v, want %v", open, tt.wantOpen)
+			}
+			if healthy := b.stats().Healthy; healthy == tt.wantOpen {
+				t.Errorf("stats healthy = %v, want %v", healthy, !tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		// providers and consumers are true for a reachable server
+		providers []bool
+		consumers []bool
+		write     bool
+		wantRole  string
+		wantDown  int // backends whose breaker opened
+		wantErr   bool
+	}{
+		{
+			name:      "writes fail over to the next provider",
+			providers: []bool{false, true},
+			write:     true,
+			wantRole:  BackendProvider,
+			wantDown:  1,
+		},
+		{
+			name:      "reads go to a consumer",
+			providers: []bool{true},
+			consumers: []bool{true},
+			wantRole:  BackendConsumer,
+		},
+		{
+			name:      "reads fall back to the provider when consumers are down",
+			providers: []bool{true},
+			consumers: []bool{false, false},
+			wantRole:  BackendProvider,
+			wantDown:  2,
+		},
+		{
+			name:      "writes never go to a consumer",
+			providers: []bool{true},
+			consumers: []bool{true},
+			write:     true,
+			wantRole:  BackendProvider,
+		},
+		{
+			name:      "fails when every provider is down",
+			providers: []bool{false, false},
+			write:     true,
+			wantDown:  2,
+			wantErr:   true,
+		},
+	}
+
+	url := func(t *testing.T, up bool) string {
+		if up {
+			return newFakeDirectory(t, testEntry(testBaseDN)).URL()
+		}
+		return closedURL(t)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			for _, up := range tt.providers {
+				cfg.LDAPURLs = append(cfg.LDAPURLs, url(t, up))
+			}
+			for _, up := range tt.consumers {
+				cfg.LDAPReadURLs = append(cfg.LDAPReadURLs, url(t, up))
+			}
+			// Start with empty pools so the first checkout dials
+			cfg.LDAPPoolMinSize = 0
+			cfg.LDAPReadAfterWriteWindow = 0
+			m := newTestManager(t, cfg)
+
+			// Twice: the second checkout must skip backends marked down
+			for i := 0; i < 2; i++ {
+				getConnection := m.getReadConnection
+				if tt.write {
+					getConnection = m.getConnection
+				}
+				conn, err := getConnection(context.Background())
+				if tt.wantErr {
+					if err == nil {
+						t.Fatal("checkout succeeded, want an error")
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("checkout error = %v", err)
+				}
+
+				var role string
+				for _, b := range m.backends() {
+					if b.lookup(conn) != nil {
+						role = b.role
+					}
+				}
+				m.returnConnection(conn)
+				if role != tt.wantRole {
+					t.Errorf("checkout %d went to a %s, want %s", i+1, role, tt.wantRole)
+				}
+			}
+
+			down := 0
+			for _, b := range m.GetStats().Backends {
+				if !b.Healthy {
+					down++
+					if b.Failures != 1 {
+						t.Errorf("%s recorded %d failures, want 1", b.URL, b.Failures)
+					}
+				}
+			}
+			if down != tt.wantDown {
+				t.Errorf("%d backends marked down, want %d", down, tt.wantDown)
+			}
+		})
+	}
+}
+
+func TestReadsStayOnProviderAfterWrite(t *testing.T) {
+	cfg := testConfig(newFakeDirectory(t, testEntry(testBaseDN)).URL())
+	cfg.LDAPReadURLs = []string{newFakeDirectory(t, testEntry(testBaseDN)).URL()}
+	cfg.LDAPReadAfterWriteWindow = time.Minute
+	m := newTestManager(t, cfg)
+
+	write, err := m.getConnection(context.Background())
+	if err != nil {
+		t.Fatalf("write checkout error = %v", err)
+	}
+	m.returnConnection(write)
+
+	read, err := m.getReadConnection(context.Background())
+	if err != nil {
+		t.Fatalf("read checkout error = %v", err)
+	}
+	defer m.returnConnection(read)
+
+	if m.writers[0].lookup(read) == nil {
+		t.Error("read after a write did not go to the provider")
+	}
+}
@@ -0,0 +1,272 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/pkg/ldapschema"
+)
+
+// ExportDirectory returns every entry under the base DN with its user
+// attributes, parents before their children. Operational attributes such
+// as entryUUID are left out, as the server sets them itself on restore.
+func (m *Manager) ExportDirectory(ctx context.Context) ([]*models.DirectoryEntry, error) {
+	// Consumers may lag, a backup has to see every write made before it
+	conn, err := m.getProviderConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	return m.readSubtree(conn)
+}
+
+// RestoreDirectory makes the directory match entries, typically a backup
+// snapshot: missing entries are added, differing attributes rewritten and
+// entries the snapshot does not have deleted. With dryRun nothing is
+// written and the result only lists the changes.
+//
+// The ID allocator, the bind DN and the schema version marker are never
+// restored: an older allocator would hand out uidNumbers again, an older
+// bind password would lock the manager out, and the marker describes the
+// schema in cn=config, which snapshots do not hold.
+func (m *Manager) RestoreDirectory(ctx context.Context, entries []*models.DirectoryEntry, dryRun bool) (*models.RestoreResult, error) {
+	baseDN := strings.ToLower(m.config.LDAPBaseDN)
+	for _, entry := range entries {
+		dn := strings.ToLower(entry.DN)
+		if dn != baseDN && !strings.HasSuffix(dn, ","+baseDN) {
+			return nil, fmt.Errorf("entry %s is outside %s", entry.DN, m.config.LDAPBaseDN)
+		}
+	}
+
+	var conn *ldap.Conn
+	var err error
+	if dryRun {
+		conn, err = m.getProviderConnection(ctx)
+	} else {
+		conn, err = m.getConnection(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	live, err := m.readSubtree(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := map[string]bool{
+		strings.ToLower(m.config.IDAllocatorDN()):                 true,
+		strings.ToLower(m.config.LDAPBindDN):                      true,
+		strings.ToLower(ldapschema.MarkerDN(m.config.LDAPBaseDN)): true,
+	}
+	result := &models.RestoreResult{
+		DryRun:  dryRun,
+		Changes: planRestore(live, entries, skip),
+	}
+	for _, change := range result.Changes {
+		switch change.Operation {
+		case models.RestoreAdd:
+			result.Added++
+		case models.RestoreModify:
+			result.Modified++
+		case models.RestoreDelete:
+			result.Deleted++
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	for _, change := range result.Changes {
+		if err := applyRestoreChange(conn, change); err != nil {
+			return nil, fmt.Errorf("failed to %s %s: %w", change.Operation, change.DN, err)
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"added":    result.Added,
+		"modified": result.Modified,
+		"deleted":  result.Deleted,
+	}).Info("Directory restored")
+	return result, nil
+}
+
+// readSubtree reads every entry under the base DN, parents first
+func (m *Manager) readSubtree(conn *ldap.Conn) ([]*models.DirectoryEntry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.LDAPBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{"*"},
+		nil,
+	)
+
+	var entries []*models.DirectoryEntry
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		attrs := make(map[string][]string, len(entry.Attributes))
+		for _, attr := range entry.Attributes {
+			attrs[attr.Name] = append(attrs[attr.Name], attr.Values...)
+		}
+		entries = append(entries, &models.DirectoryEntry{DN: entry.DN, Attributes: attrs})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	sortByDepth(entries)
+	return entries, nil
+}
+
+// planRestore lists the writes that turn live into snapshot: additions
+// parents first, then modifications, then deletions children first.
+// Entries whose lower-cased DN is in skip are left as they are.
+func planRestore(live, snapshot []*models.DirectoryEntry, skip map[string]bool) []*models.RestoreChange {
+	liveByDN := make(map[string]*models.DirectoryEntry, len(live))
+	for _, entry := range live {
+		liveByDN[strings.ToLower(entry.DN)] = entry
+	}
+	wanted := make(map[string]bool, len(snapshot))
+
+	ordered := append([]*models.DirectoryEntry(nil), snapshot...)
+	sortByDepth(ordered)
+
+	var adds, modifies, deletes []*models.RestoreChange
+	for _, entry := range ordered {
+		dn := strings.ToLower(entry.DN)
+		wanted[dn] = true
+		if skip[dn] {
+			continue
+		}
+
+		current, ok := liveByDN[dn]
+		if !ok {
+			adds = append(adds, &models.RestoreChange{Operation: models.RestoreAdd, DN: entry.DN, After: entry.Attributes})
+			continue
+		}
+		if before, after := attributeDiff(current.Attributes, entry.Attributes); len(before)+len(after) > 0 {
+			modifies = append(modifies, &models.RestoreChange{Operation: models.RestoreModify, DN: current.DN, Before: before, After: after})
+		}
+	}
+
+	for i := len(live) - 1; i >= 0; i-- {
+		dn := strings.ToLower(live[i].DN)
+		if !wanted[dn] && !skip[dn] {
+			deletes = append(deletes, &models.RestoreChange{Operation: models.RestoreDelete, DN: live[i].DN, Before: live[i].Attributes})
+		}
+	}
+
+	return append(append(adds, modifies...), deletes...)
+}
+
+// attributeDiff returns the attributes whose values differ between current
+// and wanted, keyed by the name wanted uses when it has the attribute
+func attributeDiff(current, wanted map[string][]string) (before, after map[string][]string) {
+	currentByName := make(map[string]string, len(current))
+	for name := range current {
+		currentByName[strings.ToLower(name)] = name
+	}
+	seen := make(map[string]bool, len(wanted))
+
+	before = make(map[string][]string)
+	after = make(map[string][]string)
+	for name, values := range wanted {
+		seen[strings.ToLower(name)] = true
+		currentValues := current[currentByName[strings.ToLower(name)]]
+		if sameValueSet(currentValues, values) {
+			continue
+		}
+		if len(currentValues) > 0 {
+			before[name] = currentValues
+		}
+		after[name] = values
+	}
+	for name, values := range current {
+		if !seen[strings.ToLower(name)] {
+			before[name] = values
+		}
+	}
+	return before, after
+}
+
+// sameValueSet reports whether a and b hold the same values in any order
+func sameValueSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, value := range a {
+		counts[value]++
+	}
+	for _, value := range b {
+		if counts[value] == 0 {
+			return false
+		}
+		counts[value]--
+	}
+	return true
+}
+
+// applyRestoreChange writes one planned change
+func applyRestoreChange(conn *ldap.Conn, change *models.RestoreChange) error {
+	switch change.Operation {
+	case models.RestoreAdd:
+		addRequest := ldap.NewAddRequest(change.DN, nil)
+		for _, name := range sortedNames(change.After) {
+			addRequest.Attribute(name, change.After[name])
+		}
+		return conn.Add(addRequest)
+
+	case models.RestoreModify:
+		modifyRequest := ldap.NewModifyRequest(change.DN, nil)
+		for _, name := range sortedNames(change.After) {
+			modifyRequest.Replace(name, change.After[name])
+		}
+		for _, name := range sortedNames(change.Before) {
+			if _, ok := change.After[name]; !ok {
+				modifyRequest.Delete(name, nil)
+			}
+		}
+		return conn.Modify(modifyRequest)
+
+	case models.RestoreDelete:
+		return conn.Del(ldap.NewDelRequest(change.DN, nil))
+	}
+	return fmt.Errorf("unknown operation %q", change.Operation)
+}
+
+// sortByDepth orders entries parents first and by DN within a level
+func sortByDepth(entries []*models.DirectoryEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		di, dj := dnDepth(entries[i].DN), dnDepth(entries[j].DN)
+		if di != dj {
+			return di < dj
+		}
+		return strings.ToLower(entries[i].DN) < strings.ToLower(entries[j].DN)
+	})
+}
+
+// dnDepth counts the RDNs of dn
+func dnDepth(dn string) int {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.Count(dn, ",") + 1
+	}
+	return len(parsed.RDNs)
+}
+
+func sortedNames(attrs map[string][]string) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
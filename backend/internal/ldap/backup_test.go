@@ -0,0 +1,105 @@
+package ldap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func TestPlanRestore(t *testing.T) {
+	usersDN := "ou=users," + testBaseDN
+	live := []*models.DirectoryEntry{
+		{DN: testBaseDN, Attributes: map[string][]string{"dc": {"devplatform"}}},
+		{DN: usersDN, Attributes: map[string][]string{"ou": {"users"}}},
+		{DN: "uid=alice," + usersDN, Attributes: map[string][]string{"uid": {"alice"}, "mail": {"alice@new.example"}, "title": {"Lead"}}},
+		{DN: "uid=mallory," + usersDN, Attributes: map[string][]string{"uid": {"mallory"}}},
+		{DN: "ou=tmp,uid=mallory," + usersDN, Attributes: map[string][]string{"ou": {"tmp"}}},
+		{DN: "cn=idAllocator," + testBaseDN, Attributes: map[string][]string{"uidNumber": {"10005"}}},
+	}
+	snapshot := []*models.DirectoryEntry{
+		{DN: "uid=bob,ou=people," + testBaseDN, Attributes: map[string][]string{"uid": {"bob"}}},
+		{DN: "ou=people," + testBaseDN, Attributes: map[string][]string{"ou": {"people"}}},
+		{DN: testBaseDN, Attributes: map[string][]string{"dc": {"devplatform"}}},
+		{DN: usersDN, Attributes: map[string][]string{"ou": {"users"}}},
+		{DN: "UID=Alice," + usersDN, Attributes: map[string][]string{"uid": {"alice"}, "Mail": {"alice@old.example"}}},
+		{DN: "cn=idAllocator," + testBaseDN, Attributes: map[string][]string{"uidNumber": {"10001"}}},
+	}
+
+	got := planRestore(live, snapshot, map[string]bool{"cn=idallocator," + testBaseDN: true})
+	want := []*models.RestoreChange{
+		{Operation: models.RestoreAdd, DN: "ou=people," + testBaseDN, After: map[string][]string{"ou": {"people"}}},
+		{Operation: models.RestoreAdd, DN: "uid=bob,ou=people," + testBaseDN, After: map[string][]string{"uid": {"bob"}}},
+		{
+			Operation: models.RestoreModify,
+			DN:        "uid=alice," + usersDN,
+			Before:    map[string][]string{"Mail": {"alice@new.example"}, "title": {"Lead"}},
+			After:     map[string][]string{"Mail": {"alice@old.example"}},
+		},
+		{Operation: models.RestoreDelete, DN: "ou=tmp,uid=mallory," + usersDN, Before: map[string][]string{"ou": {"tmp"}}},
+		{Operation: models.RestoreDelete, DN: "uid=mallory," + usersDN, Before: map[string][]string{"uid": {"mallory"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, change := range got {
+			t.Logf("%+v", *change)
+		}
+		t.Errorf("planRestore() differs from the expected plan")
+	}
+}
+
+func TestRestoreDirectory(t *testing.T) {
+	usersDN := "ou=users," + testBaseDN
+	dir := newFakeDirectory(t,
+		testEntry(testBaseDN, "objectClass", "dcObject", "dc", "devplatform"),
+		testEntry(usersDN, "objectClass", "organizationalUnit", "ou", "users"),
+		testEntry("uid=alice,"+usersDN, "objectClass", "inetOrgPerson", "uid", "alice", "mail", "alice@example.com"),
+		testEntry("cn=admin,"+testBaseDN, "objectClass", "organizationalRole", "cn", "admin", "userPassword", "old"),
+	)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+
+	snapshot, err := m.ExportDirectory(ctx)
+	if err != nil {
+		t.Fatalf("ExportDirectory() error = %v", err)
+	}
+	if len(snapshot) != 4 || snapshot[0].DN != testBaseDN {
+		t.Fatalf("ExportDirectory() = %d entries starting at %s, want 4 parents first", len(snapshot), snapshot[0].DN)
+	}
+
+	// Changes made after the snapshot
+	dir.mu.Lock()
+	dir.entries["uid=alice,"+usersDN] = testEntry("uid=alice,"+usersDN, "objectClass", "inetOrgPerson", "uid", "alice", "mail", "alice@new.example")
+	dir.entries["uid=bob,"+usersDN] = testEntry("uid=bob,"+usersDN, "objectClass", "inetOrgPerson", "uid", "bob")
+	dir.entries["cn=admin,"+testBaseDN] = testEntry("cn=admin,"+testBaseDN, "objectClass", "organizationalRole", "cn", "admin", "userPassword", "rotated")
+	dir.mu.Unlock()
+
+	result, err := m.RestoreDirectory(ctx, snapshot, true)
+	if err != nil {
+		t.Fatalf("RestoreDirectory() dry run error = %v", err)
+	}
+	if result.Added != 0 || result.Modified != 1 || result.Deleted != 1 {
+		t.Errorf("RestoreDirectory() dry run = %d added, %d modified, %d deleted, want 0, 1, 1", result.Added, result.Modified, result.Deleted)
+	}
+	if dir.entry("uid=bob,"+usersDN) == nil {
+		t.Fatal("dry run deleted bob")
+	}
+
+	if _, err := m.RestoreDirectory(ctx, snapshot, false); err != nil {
+		t.Fatalf("RestoreDirectory() error = %v", err)
+	}
+	if dir.entry("uid=bob,"+usersDN) != nil {
+		t.Error("bob was not deleted")
+	}
+	if mail := dir.entry("uid=alice," + usersDN).GetAttributeValue("mail"); mail != "alice@example.com" {
+		t.Errorf("alice mail = %q, want alice@example.com", mail)
+	}
+	// The bind DN keeps its current password
+	if password := dir.entry("cn=admin," + testBaseDN).GetAttributeValue("userPassword"); password != "rotated" {
+		t.Errorf("admin password = %q, want rotated", password)
+	}
+
+	if _, err := m.RestoreDirectory(ctx, []*models.DirectoryEntry{{DN: "dc=other"}}, true); err == nil {
+		t.Error("RestoreDirectory() accepted an entry outside the base DN")
+	}
+}
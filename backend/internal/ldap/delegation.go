@@ -0,0 +1,273 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// departmentDeputyAttr lists the DNs of users who manage a department
+// besides its manager (devplatform schema)
+const departmentDeputyAttr = "departmentDeputy"
+
+// SetDepartmentManager hands a department over to another user, who must
+// exist and be active. A deputy becoming the manager stops being a deputy.
+// An empty uid leaves the department without a manager.
+func (m *Manager) SetDepartmentManager(ctx context.Context, ou, uid string) (*models.Department, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithFields(logrus.Fields{
+		"ou":      ou,
+		"manager": uid,
+	}).Info("Setting department manager")
+
+	entry, err := m.departmentEntry(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	if uid == "" {
+		modifyRequest.Replace("manager", []string{})
+	} else {
+		if err := m.checkDepartmentManager(conn, uid); err != nil {
+			return nil, err
+		}
+		managerDN := m.config.UserDN(uid)
+		modifyRequest.Replace("manager", []string{managerDN})
+		for _, deputyDN := range entry.GetAttributeValues(departmentDeputyAttr) {
+			if strings.EqualFold(rdnValue(deputyDN, "uid"), uid) {
+				modifyRequest.Delete(departmentDeputyAttr, []string{deputyDN})
+			}
+		}
+	}
+
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to set department manager")
+		return nil, fmt.Errorf("failed to set department manager: %w", err)
+	}
+
+	m.logger.WithField("ou", ou).Info("Department manager set")
+	return m.GetDepartment(ctx, ou)
+}
+
+// AddDepartmentDeputy lets a user, who must exist and be active, manage a
+// department besides its manager. Adding a deputy twice changes nothing.
+func (m *Manager) AddDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithFields(logrus.Fields{
+		"ou":     ou,
+		"deputy": uid,
+	}).Info("Adding department deputy")
+
+	entry, err := m.departmentEntry(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkDepartmentManager(conn, uid); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(rdnValue(entry.GetAttributeValue("manager"), "uid"), uid) {
+		return nil, &models.ValidationError{Problems: []string{fmt.Sprintf("user '%s' already manages department '%s'", uid, ou)}}
+	}
+
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	modifyRequest.Add(departmentDeputyAttr, []string{m.config.UserDN(uid)})
+	if err := conn.Modify(modifyRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) {
+		m.logger.WithError(err).Error("Failed to add department deputy")
+		return nil, fmt.Errorf("failed to add department deputy: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{"ou": ou, "deputy": uid}).Info("Department deputy added")
+	return m.GetDepartment(ctx, ou)
+}
+
+// RemoveDepartmentDeputy takes a department away from one of its deputies.
+// Removing a user who is no deputy changes nothing.
+func (m *Manager) RemoveDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithFields(logrus.Fields{
+		"ou":     ou,
+		"deputy": uid,
+	}).Info("Removing department deputy")
+
+	entry, err := m.departmentEntry(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, deputyDN := range entry.GetAttributeValues(departmentDeputyAttr) {
+		if strings.EqualFold(rdnValue(deputyDN, "uid"), uid) {
+			values = append(values, deputyDN)
+		}
+	}
+	if len(values) > 0 {
+		modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+		modifyRequest.Delete(departmentDeputyAttr, values)
+		if err := conn.Modify(modifyRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			m.logger.WithError(err).Error("Failed to remove department deputy")
+			return nil, fmt.Errorf("failed to remove department deputy: %w", err)
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{"ou": ou, "deputy": uid}).Info("Department deputy removed")
+	return m.GetDepartment(ctx, ou)
+}
+
+// GetManagedDepartments returns the departments a user manages or is a
+// deputy of, ordered by ou
+func (m *Manager) GetManagedDepartments(ctx context.Context, uid string) ([]*models.Department, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	var depts []*models.Department
+	err = m.searchManagedDepartments(conn, m.config.UserDN(uid), departmentAttributes, func(entry *ldap.Entry) {
+		depts = append(depts, m.entryToDepartment(entry))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dept := range depts {
+		if members, err := m.departmentMembers(conn, dept.OU); err != nil {
+			m.logger.WithError(err).Warn("Failed to get department members")
+		} else {
+			dept.Members = members
+		}
+	}
+	sort.Slice(depts, func(i, j int) bool { return depts[i].OU < depts[j].OU })
+	return depts, nil
+}
+
+// releaseDepartments removes a user as manager and deputy from every
+// department and returns their OUs, sorted. Used when the user is deleted or
+// deprovisioned, so no department keeps pointing at them.
+func (m *Manager) releaseDepartments(conn *ldap.Conn, uid string) ([]string, error) {
+	userDN := m.config.UserDN(uid)
+
+	// Modify once the search is done rather than between its pages
+	var modifyRequests []*ldap.ModifyRequest
+	var released []string
+	err := m.searchManagedDepartments(conn, userDN, []string{"ou", "manager", departmentDeputyAttr}, func(entry *ldap.Entry) {
+		modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+		if manager := entry.GetAttributeValue("manager"); strings.EqualFold(manager, userDN) {
+			modifyRequest.Delete("manager", []string{manager})
+		}
+		for _, deputyDN := range entry.GetAttributeValues(departmentDeputyAttr) {
+			if strings.EqualFold(deputyDN, userDN) {
+				modifyRequest.Delete(departmentDeputyAttr, []string{deputyDN})
+			}
+		}
+		modifyRequests = append(modifyRequests, modifyRequest)
+		released = append(released, entry.GetAttributeValue("ou"))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, modifyRequest := range modifyRequests {
+		// Someone else releasing the department first is fine
+		if err := conn.Modify(modifyRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			return nil, fmt.Errorf("failed to release department %s: %w", modifyRequest.DN, err)
+		}
+	}
+	sort.Strings(released)
+	if len(released) > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"uid":         uid,
+			"departments": released,
+		}).Info("Released departments of user")
+	}
+	return released, nil
+}
+
+// searchManagedDepartments calls fn for every department userDN manages or
+// is a deputy of
+func (m *Manager) searchManagedDepartments(conn *ldap.Conn, userDN string, attributes []string, fn func(*ldap.Entry)) error {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=organizationalUnit)(|(manager=%s)(%s=%s)))",
+			ldap.EscapeFilter(userDN), departmentDeputyAttr, ldap.EscapeFilter(userDN)),
+		attributes,
+		nil,
+	)
+	if err := m.pagedSearch(conn, searchRequest, "", fn); err != nil {
+		return fmt.Errorf("failed to search managed departments: %w", err)
+	}
+	return nil
+}
+
+// departmentEntry reads the manager and deputies of a department
+func (m *Manager) departmentEntry(conn *ldap.Conn, ou string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentDN(ou),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=organizationalUnit)",
+		[]string{"manager", departmentDeputyAttr},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou)
+		}
+		return nil, fmt.Errorf("failed to search department: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou)
+	}
+	return result.Entries[0], nil
+}
+
+// checkDepartmentManager refuses users who do not exist or whose account
+// is not active as department managers and deputies
+func (m *Manager) checkDepartmentManager(conn *ldap.Conn, uid string) error {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UserDN(uid),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=inetOrgPerson)",
+		[]string{accountStatusAttr},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return fmt.Errorf("failed to look up user %s: %w", uid, err)
+	}
+	if err != nil || len(result.Entries) == 0 {
+		return &models.ValidationError{Problems: []string{fmt.Sprintf("user '%s' does not exist", uid)}}
+	}
+	if status := result.Entries[0].GetAttributeValue(accountStatusAttr); status != "" && status != models.UserStatusActive {
+		return &models.ValidationError{Problems: []string{fmt.Sprintf("user '%s' is %s", uid, status)}}
+	}
+	return nil
+}
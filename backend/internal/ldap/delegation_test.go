@@ -0,0 +1,173 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func delegationDirectory(t *testing.T) *fakeDirectory {
+	usersDN := "ou=users," + testBaseDN
+	departmentsDN := "ou=departments," + testBaseDN
+
+	return newFakeDirectory(t,
+		testEntry(testBaseDN),
+		testEntry(usersDN),
+		testEntry("ou=groups,"+testBaseDN),
+		testEntry(departmentsDN),
+		testEntry("uid=alice,"+usersDN, "objectClass", "inetOrgPerson", "uid", "alice"),
+		testEntry("uid=bob,"+usersDN, "objectClass", "inetOrgPerson", "uid", "bob"),
+		testEntry("uid=carol,"+usersDN, "objectClass", "inetOrgPerson", "uid", "carol", accountStatusAttr, models.UserStatusActive),
+		testEntry("uid=dave,"+usersDN, "objectClass", "inetOrgPerson", "uid", "dave", accountStatusAttr, models.UserStatusDisabled),
+		testEntry("ou=engineering,"+departmentsDN,
+			"objectClass", "organizationalUnit",
+			"ou", "engineering",
+			"manager", "uid=alice,"+usersDN,
+			departmentDeputyAttr, "uid=bob,"+usersDN,
+		),
+		testEntry("ou=sales,"+departmentsDN,
+			"objectClass", "organizationalUnit",
+			"ou", "sales",
+			"manager", "uid=bob,"+usersDN,
+		),
+	)
+}
+
+func TestSetDepartmentManager(t *testing.T) {
+	dir := delegationDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+
+	// Promoting the deputy hands the department over and drops the deputyship
+	dept, err := m.SetDepartmentManager(ctx, "engineering", "bob")
+	if err != nil {
+		t.Fatalf("SetDepartmentManager() error = %v", err)
+	}
+	if dept.Manager != "bob" || len(dept.Deputies) != 0 {
+		t.Errorf("SetDepartmentManager() = manager %q deputies %v, want bob and none", dept.Manager, dept.Deputies)
+	}
+
+	var validation *models.ValidationError
+	for _, uid := range []string{"nobody", "dave"} {
+		if _, err := m.SetDepartmentManager(ctx, "engineering", uid); !errors.As(err, &validation) {
+			t.Errorf("SetDepartmentManager(%s) error = %v, want a ValidationError", uid, err)
+		}
+	}
+	if _, err := m.SetDepartmentManager(ctx, "missing", "bob"); !errors.Is(err, models.ErrDepartmentNotFound) {
+		t.Errorf("SetDepartmentManager() on a missing department error = %v, want ErrDepartmentNotFound", err)
+	}
+
+	dept, err = m.SetDepartmentManager(ctx, "engineering", "")
+	if err != nil {
+		t.Fatalf("SetDepartmentManager() clearing error = %v", err)
+	}
+	if dept.Manager != "" {
+		t.Errorf("manager after clearing = %q, want none", dept.Manager)
+	}
+}
+
+func TestDepartmentDeputies(t *testing.T) {
+	dir := delegationDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+
+	// Adding twice is a no-op
+	for i := 0; i < 2; i++ {
+		dept, err := m.AddDepartmentDeputy(ctx, "engineering", "carol")
+		if err != nil {
+			t.Fatalf("AddDepartmentDeputy() error = %v", err)
+		}
+		if want := []string{"bob", "carol"}; !reflect.DeepEqual(dept.Deputies, want) {
+			t.Errorf("deputies = %v, want %v", dept.Deputies, want)
+		}
+	}
+
+	var validation *models.ValidationError
+	for _, uid := range []string{"alice", "dave", "nobody"} {
+		if _, err := m.AddDepartmentDeputy(ctx, "engineering", uid); !errors.As(err, &validation) {
+			t.Errorf("AddDepartmentDeputy(%s) error = %v, want a ValidationError", uid, err)
+		}
+	}
+
+	// Removing someone who is no deputy is a no-op
+	for i := 0; i < 2; i++ {
+		dept, err := m.RemoveDepartmentDeputy(ctx, "engineering", "bob")
+		if err != nil {
+			t.Fatalf("RemoveDepartmentDeputy() error = %v", err)
+		}
+		if want := []string{"carol"}; !reflect.DeepEqual(dept.Deputies, want) {
+			t.Errorf("deputies = %v, want %v", dept.Deputies, want)
+		}
+	}
+}
+
+func TestGetManagedDepartments(t *testing.T) {
+	dir := delegationDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+
+	tests := []struct {
+		uid  string
+		want []string
+	}{
+		{uid: "alice", want: []string{"engineering"}},
+		{uid: "bob", want: []string{"engineering", "sales"}},
+		{uid: "carol"},
+	}
+	for _, tt := range tests {
+		depts, err := m.GetManagedDepartments(ctx, tt.uid)
+		if err != nil {
+			t.Fatalf("GetManagedDepartments(%s) error = %v", tt.uid, err)
+		}
+		var got []string
+		for _, dept := range depts {
+			got = append(got, dept.OU)
+			if !dept.ManagedBy(tt.uid) {
+				t.Errorf("department %s is not managed by %s", dept.OU, tt.uid)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetManagedDepartments(%s) = %v, want %v", tt.uid, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteUserReleasesDepartments(t *testing.T) {
+	dir := delegationDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	departmentsDN := "ou=departments," + testBaseDN
+
+	if err := m.DeleteUser(context.Background(), "bob"); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if got := dir.entry("ou=engineering," + departmentsDN).GetAttributeValues(departmentDeputyAttr); len(got) != 0 {
+		t.Errorf("engineering deputies = %v, want none", got)
+	}
+	if got := dir.entry("ou=sales," + departmentsDN).GetAttributeValues("manager"); len(got) != 0 {
+		t.Errorf("sales manager = %v, want none", got)
+	}
+	if got := dir.entry("ou=engineering," + departmentsDN).GetAttributeValue("manager"); got != "uid=alice,ou=users,"+testBaseDN {
+		t.Errorf("engineering manager = %q, want alice untouched", got)
+	}
+}
+
+func TestDeprovisionUserReleasesDepartments(t *testing.T) {
+	dir := delegationDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+
+	result, err := m.DeprovisionUser(context.Background(), "bob")
+	if err != nil {
+		t.Fatalf("DeprovisionUser() error = %v", err)
+	}
+	if want := []string{"engineering", "sales"}; !reflect.DeepEqual(result.ReleasedDepartments, want) {
+		t.Errorf("ReleasedDepartments = %v, want %v", result.ReleasedDepartments, want)
+	}
+
+	depts, err := m.GetManagedDepartments(context.Background(), "bob")
+	if err != nil || len(depts) != 0 {
+		t.Errorf("GetManagedDepartments() after deprovisioning = %v, %v, want none", depts, err)
+	}
+}
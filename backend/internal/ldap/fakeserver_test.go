@@ -0,0 +1,446 @@
+package ldap
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+)
+
+const testBaseDN = "dc=example,dc=com"
+
+// LDAP protocol operations handled by fakeDirectory
+const (
+	opBindRequest    = 0
+	opBindResponse   = 1
+	opUnbindRequest  = 2
+	opSearchRequest  = 3
+	opSearchEntry    = 4
+	opSearchDone     = 5
+	opModifyRequest  = 6
+	opModifyResponse = 7
+	opAddRequest     = 8
+	opAddResponse    = 9
+	opDelRequest     = 10
+	opDelResponse    = 11
+)
+
+// fakeDirectory is a minimal in-memory LDAP server. It answers simple
+// binds, searches with and/or/not/equality/presence filters, adds,
+// modifies and deletes, which is enough to drive the manager through a
+// real *ldap.Conn. Modifies are applied atomically, like slapd does.
+type fakeDirectory struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries map[string]*ldap.Entry // keyed by lower-case DN
+	binds   int
+	// beforeModify, when set, runs with the lock held before a modify is
+	// applied, to simulate a concurrent writer
+	beforeModify func(dir *fakeDirectory, dn string)
+}
+
+// newFakeDirectory starts a server holding the given entries, stopped when
+// the test ends
+func newFakeDirectory(t *testing.T, entries ...*ldap.Entry) *fakeDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	dir := &fakeDirectory{listener: listener, entries: make(map[string]*ldap.Entry)}
+	for _, entry := range entries {
+		dir.entries[strings.ToLower(entry.DN)] = entry
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go dir.serve()
+	return dir
+}
+
+// URL returns the ldap:// URL the server listens on
+func (d *fakeDirectory) URL() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+// entry returns the entry with the given DN, or nil
+func (d *fakeDirectory) entry(dn string) *ldap.Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.entries[strings.ToLower(dn)]
+}
+
+func (d *fakeDirectory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		envelope, err := ber.ReadPacket(conn)
+		if err != nil || len(envelope.Children) < 2 {
+			return
+		}
+		messageID := envelope.Children[0].Value.(int64)
+		request := envelope.Children[1]
+
+		var responses []*ber.Packet
+		switch request.Tag {
+		case opBindRequest:
+			d.mu.Lock()
+			d.binds++
+			d.mu.Unlock()
+			responses = append(responses, ldapResult(opBindResponse, ldap.LDAPResultSuccess))
+		case opUnbindRequest:
+			return
+		case opSearchRequest:
+			responses = d.search(request)
+		case opModifyRequest:
+			responses = append(responses, ldapResult(opModifyResponse, d.modify(request)))
+		case opAddRequest:
+			responses = append(responses, ldapResult(opAddResponse, d.add(request)))
+		case opDelRequest:
+			responses = append(responses, ldapResult(opDelResponse, d.delete(packetString(request))))
+		default:
+			continue
+		}
+
+		for _, response := range responses {
+			message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			message.AppendChild(response)
+			if _, err := conn.Write(message.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *fakeDirectory) search(request *ber.Packet) []*ber.Packet {
+	base := strings.ToLower(packetString(request.Children[0]))
+	scope := int(request.Children[1].Value.(int64))
+	filter := request.Children[6]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[base]; !ok && base != "" {
+		return []*ber.Packet{ldapResult(opSearchDone, ldap.LDAPResultNoSuchObject)}
+	}
+
+	var responses []*ber.Packet
+	for key, entry := range d.entries {
+		if !inScope(key, base, scope) || !matchFilter(entry, filter) {
+			continue
+		}
+		packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "Search Result Entry")
+		packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+		attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+		for _, attr := range entry.Attributes {
+			attributes.AppendChild(attributePacket(attr.Name, attr.Values))
+		}
+		packet.AppendChild(attributes)
+		responses = append(responses, packet)
+	}
+	return append(responses, ldapResult(opSearchDone, ldap.LDAPResultSuccess))
+}
+
+func (d *fakeDirectory) modify(request *ber.Packet) uint16 {
+	dn := packetString(request.Children[0])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.beforeModify != nil {
+		d.beforeModify(d, dn)
+	}
+	entry, ok := d.entries[strings.ToLower(dn)]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject
+	}
+
+	// Work on a copy so a failed change leaves the entry untouched
+	updated := copyEntry(entry)
+	for _, change := range request.Children[1].Children {
+		operation := change.Children[0].Value.(int64)
+		name, values := decodeAttribute(change.Children[1])
+		if code := applyChange(updated, operation, name, values); code != ldap.LDAPResultSuccess {
+			return code
+		}
+	}
+	d.entries[strings.ToLower(dn)] = updated
+	return ldap.LDAPResultSuccess
+}
+
+func (d *fakeDirectory) add(request *ber.Packet) uint16 {
+	entry := &ldap.Entry{DN: packetString(request.Children[0])}
+	for _, attr := range request.Children[1].Children {
+		name, values := decodeAttribute(attr)
+		entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: name, Values: values})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := strings.ToLower(entry.DN)
+	if _, ok := d.entries[key]; ok {
+		return ldap.LDAPResultEntryAlreadyExists
+	}
+	if i := strings.IndexByte(key, ','); i >= 0 {
+		if _, ok := d.entries[key[i+1:]]; !ok {
+			return ldap.LDAPResultNoSuchObject
+		}
+	}
+	d.entries[key] = entry
+	return ldap.LDAPResultSuccess
+}
+
+func (d *fakeDirectory) delete(dn string) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := strings.ToLower(dn)
+	if _, ok := d.entries[key]; !ok {
+		return ldap.LDAPResultNoSuchObject
+	}
+	delete(d.entries, key)
+	return ldap.LDAPResultSuccess
+}
+
+// applyChange applies one modify operation (0 add, 1 delete, 2 replace)
+func applyChange(entry *ldap.Entry, operation int64, name string, values []string) uint16 {
+	attr := findAttribute(entry, name)
+	switch operation {
+	case 0:
+		if attr == nil {
+			attr = &ldap.EntryAttribute{Name: name}
+			entry.Attributes = append(entry.Attributes, attr)
+		}
+		for _, value := range values {
+			if containsFold(attr.Values, value) {
+				return ldap.LDAPResultAttributeOrValueExists
+			}
+			attr.Values = append(attr.Values, value)
+		}
+	case 1:
+		if attr == nil {
+			return ldap.LDAPResultNoSuchAttribute
+		}
+		if len(values) == 0 {
+			attr.Values = nil
+		}
+		for _, value := range values {
+			if !containsFold(attr.Values, value) {
+				return ldap.LDAPResultNoSuchAttribute
+			}
+			attr.Values = removeFold(attr.Values, value)
+		}
+	case 2:
+		if attr == nil {
+			attr = &ldap.EntryAttribute{Name: name}
+			entry.Attributes = append(entry.Attributes, attr)
+		}
+		attr.Values = values
+	}
+
+	kept := entry.Attributes[:0]
+	for _, a := range entry.Attributes {
+		if len(a.Values) > 0 {
+			kept = append(kept, a)
+		}
+	}
+	entry.Attributes = kept
+	return ldap.LDAPResultSuccess
+}
+
+// inScope reports whether the entry key lies within scope of base
+func inScope(key, base string, scope int) bool {
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return key == base
+	case ldap.ScopeSingleLevel:
+		i := strings.Index(key, ",")
+		return i >= 0 && key[i+1:] == base
+	default:
+		return base == "" || key == base || strings.HasSuffix(key, ","+base)
+	}
+}
+
+// matchFilter evaluates an RFC 4511 filter against entry, comparing values
+// case-insensitively. Unsupported filter types match nothing.
+func matchFilter(entry *ldap.Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(entry, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		attr := findAttribute(entry, packetString(filter.Children[0]))
+		return attr != nil && containsFold(attr.Values, packetString(filter.Children[1]))
+	case ldap.FilterPresent:
+		name := packetString(filter)
+		return strings.EqualFold(name, "objectClass") || findAttribute(entry, name) != nil
+	}
+	return false
+}
+
+// ldapResult builds an LDAPResult response for the given operation
+func ldapResult(op ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return packet
+}
+
+func attributePacket(name string, values []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+	set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+	for _, value := range values {
+		set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+	}
+	packet.AppendChild(set)
+	return packet
+}
+
+func decodeAttribute(packet *ber.Packet) (string, []string) {
+	var values []string
+	for _, value := range packet.Children[1].Children {
+		values = append(values, packetString(value))
+	}
+	return packetString(packet.Children[0]), values
+}
+
+// packetString returns a primitive packet's content; only universal
+// strings are decoded into Value by the ber package
+func packetString(packet *ber.Packet) string {
+	if s, ok := packet.Value.(string); ok {
+		return s
+	}
+	return packet.Data.String()
+}
+
+func findAttribute(entry *ldap.Entry, name string) *ldap.EntryAttribute {
+	for _, attr := range entry.Attributes {
+		if strings.EqualFold(attr.Name, name) {
+			return attr
+		}
+	}
+	return nil
+}
+
+func copyEntry(entry *ldap.Entry) *ldap.Entry {
+	copied := &ldap.Entry{DN: entry.DN}
+	for _, attr := range entry.Attributes {
+		copied.Attributes = append(copied.Attributes, &ldap.EntryAttribute{
+			Name:   attr.Name,
+			Values: append([]string(nil), attr.Values...),
+		})
+	}
+	return copied
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFold(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if !strings.EqualFold(v, value) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// testEntry builds an entry from alternating attribute names and values
+func testEntry(dn string, attrs ...string) *ldap.Entry {
+	entry := &ldap.Entry{DN: dn}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attr := findAttribute(entry, attrs[i]); attr != nil {
+			attr.Values = append(attr.Values, attrs[i+1])
+			continue
+		}
+		entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: attrs[i], Values: []string{attrs[i+1]}})
+	}
+	return entry
+}
+
+// testConfig returns a configuration for a manager talking to urls
+func testConfig(urls ...string) *config.Config {
+	return &config.Config{
+		LDAPURLs:             urls,
+		LDAPBaseDN:           testBaseDN,
+		LDAPBindDN:           "cn=admin," + testBaseDN,
+		LDAPBindPassword:     "secret",
+		LDAPConnTimeout:      time.Second,
+		LDAPPageSize:         500,
+		LDAPPoolSize:         4,
+		LDAPPoolMinSize:      1,
+		LDAPPoolTimeout:      time.Second,
+		LDAPBreakerThreshold: 1,
+		LDAPBreakerCooldown:  time.Minute,
+		LDAPTLSMode:          "none",
+		StartingUID:          10000,
+		StartingGID:          10000,
+	}
+}
+
+// newTestManager starts a manager against cfg, closed when the test ends
+func newTestManager(t *testing.T, cfg *config.Config) *Manager {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	m, err := NewManager(cfg, logger)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// closedURL returns the URL of a port nothing listens on
+func closedURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	url := "ldap://" + listener.Addr().String()
+	listener.Close()
+	return url
+}
@@ -0,0 +1,550 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// repositoryGrantAttr holds repository grants (devplatform schema), one
+// value per repository as encoded by models.RepositoryGrant.String.
+// githubRepository keeps listing the granted repositories for Gitea,
+// Keycloak mappers and filters that only know plain URLs.
+const repositoryGrantAttr = "repositoryGrant"
+
+// entryGrants parses an entry's repository grants, skipping values that do
+// not parse. With legacy set, githubRepository values no grant covers,
+// written before grants existed, count as DefaultPermission grants. That
+// only holds for groups and departments: a user's githubRepository also
+// lists what their groups grant.
+func (m *Manager) entryGrants(entry *ldap.Entry, legacy bool) []models.RepositoryGrant {
+	grants := []models.RepositoryGrant{}
+	for _, value := range entry.GetAttributeValues(repositoryGrantAttr) {
+		grant, err := models.ParseRepositoryGrant(value)
+		if err != nil {
+			m.logger.WithError(err).WithField("dn", entry.DN).Warn("Ignoring malformed repository grant")
+			continue
+		}
+		grants = append(grants, grant)
+	}
+	if legacy {
+		grants = append(grants, legacyGrants(entry.GetAttributeValues("githubRepository"), grants, nil)...)
+	}
+	return grants
+}
+
+// legacyGrants returns DefaultPermission grants for the repos covered
+// neither by grants nor by inherited
+func legacyGrants(repos []string, grants []models.RepositoryGrant, inherited map[string]struct{}) []models.RepositoryGrant {
+	covered := make(map[string]struct{}, len(grants))
+	for _, grant := range grants {
+		covered[grant.Repository] = struct{}{}
+	}
+
+	var legacy []models.RepositoryGrant
+	for _, repo := range repos {
+		if _, ok := covered[repo]; ok {
+			continue
+		}
+		if _, ok := inherited[repo]; ok {
+			continue
+		}
+		covered[repo] = struct{}{}
+		legacy = append(legacy, models.RepositoryGrant{Repository: repo, Permission: models.DefaultPermission})
+	}
+	return legacy
+}
+
+// resolveGrantInput validates repositories and grants about to replace the
+// current ones and completes them with models.ResolveGrants
+func resolveGrantInput(repos []string, grants, current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+	requested := append(models.RepositoryGrants(repos), grants...)
+	if problems := models.GrantProblems(requested); len(problems) > 0 {
+		return nil, &models.ValidationError{Problems: problems}
+	}
+	return models.ResolveGrants(requested, current), nil
+}
+
+// groupGrantRepos returns every repository the user's groups in graph
+// grant, including expired grants
+func groupGrantRepos(graph groupGraph, userDN string) map[string]struct{} {
+	repos := make(map[string]struct{})
+	for _, cn := range userGroups(graph, userDN) {
+		node, _ := graph.node(cn)
+		for _, grant := range node.grants {
+			repos[grant.Repository] = struct{}{}
+		}
+	}
+	return repos
+}
+
+// userDirectGrants reads the grants stored on a user entry
+func (m *Manager) userDirectGrants(conn *ldap.Conn, uid string) ([]models.RepositoryGrant, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UserDN(uid),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{repositoryGrantAttr},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+	}
+	return m.entryGrants(result.Entries[0], false), nil
+}
+
+// loadUserGrants reads the direct grants of every user holding any, keyed
+// by lower-cased uid
+func (m *Manager) loadUserGrants(conn *ldap.Conn) (map[string][]models.RepositoryGrant, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(%s=*)", repositoryGrantAttr),
+		[]string{"uid", repositoryGrantAttr},
+		nil,
+	)
+
+	grants := make(map[string][]models.RepositoryGrant)
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		grants[strings.ToLower(entry.GetAttributeValue("uid"))] = m.entryGrants(entry, false)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user grants: %w", err)
+	}
+	return grants, nil
+}
+
+// AssignRepositoryToDepartment replaces the repository grants of a
+// department. Grants without a permission keep their current one, or get
+// DefaultPermission.
+func (m *Manager) AssignRepositoryToDepartment(ctx context.Context, ou string, grants []models.RepositoryGrant) error {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithFields(logrus.Fields{
+		"ou":    ou,
+		"repos": len(grants),
+	}).Info("Assigning repositories to department")
+
+	_, err = m.updateGrants(conn, m.config.DepartmentDN(ou), fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou), true,
+		func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+			return resolveGrantInput(nil, grants, current)
+		})
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to assign repositories")
+		return err
+	}
+
+	m.logger.WithField("ou", ou).Info("Repositories assigned successfully")
+	return nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// ADDING AND REMOVING REPOSITORIES
+// ═══════════════════════════════════════════════════════════════════════════
+
+// AddUserRepositories adds grants to a user's direct grants, leaving the
+// user's other repositories alone, and resyncs githubRepository
+func (m *Manager) AddUserRepositories(ctx context.Context, uid string, grants []models.RepositoryGrant) (*models.User, error) {
+	if err := addedGrantProblems(grants); err != nil {
+		return nil, err
+	}
+	return m.changeUserGrants(ctx, uid, "Adding repositories to user", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return models.AddGrants(grants, current), nil
+	})
+}
+
+// RemoveUserRepositories removes every direct grant a user holds for the
+// repositories and resyncs githubRepository, which keeps the repositories
+// the user's groups grant. Repositories the user holds no grant for are
+// ignored.
+func (m *Manager) RemoveUserRepositories(ctx context.Context, uid string, repos []string) (*models.User, error) {
+	if err := removedRepoProblems(repos); err != nil {
+		return nil, err
+	}
+	return m.changeUserGrants(ctx, uid, "Removing repositories from user", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return models.RemoveGrants(repos, current), nil
+	})
+}
+
+// AddGroupRepositories adds grants to a group, leaving its other
+// repositories alone, and resyncs its members
+func (m *Manager) AddGroupRepositories(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error) {
+	if err := addedGrantProblems(grants); err != nil {
+		return nil, err
+	}
+	return m.changeGroupGrants(ctx, cn, "Adding repositories to group", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return models.AddGrants(grants, current), nil
+	})
+}
+
+// RemoveGroupRepositories removes a group's grants for the repositories
+// and resyncs its members. Repositories the group holds no grant for are
+// ignored.
+func (m *Manager) RemoveGroupRepositories(ctx context.Context, cn string, repos []string) (*models.Group, error) {
+	if err := removedRepoProblems(repos); err != nil {
+		return nil, err
+	}
+	return m.changeGroupGrants(ctx, cn, "Removing repositories from group", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return models.RemoveGrants(repos, current), nil
+	})
+}
+
+// AddDepartmentRepositories adds grants to a department, leaving its other
+// repositories alone
+func (m *Manager) AddDepartmentRepositories(ctx context.Context, ou string, grants []models.RepositoryGrant) (*models.Department, error) {
+	if err := addedGrantProblems(grants); err != nil {
+		return nil, err
+	}
+	return m.changeDepartmentGrants(ctx, ou, "Adding repositories to department", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return models.AddGrants(grants, current), nil
+	})
+}
+
+// RemoveDepartmentRepositories removes a department's grants for the
+// repositories. Repositories the department holds no grant for are
+// ignored.
+func (m *Manager) RemoveDepartmentRepositories(ctx context.Context, ou string, repos []string) (*models.Department, error) {
+	if err := removedRepoProblems(repos); err != nil {
+		return nil, err
+	}
+	return m.changeDepartmentGrants(ctx, ou, "Removing repositories from department", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return models.RemoveGrants(repos, current), nil
+	})
+}
+
+func (m *Manager) changeUserGrants(ctx context.Context, uid, message string, change grantChange) (*models.User, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithField("uid", uid).Info(message)
+
+	changed, err := m.updateGrants(conn, m.config.UserDN(uid), fmt.Errorf("%w: %s", models.ErrUserNotFound, uid), false, change)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := m.syncUserReposFromGroups(conn, uid); err != nil {
+			return nil, err
+		}
+	}
+	return m.GetUser(ctx, uid)
+}
+
+func (m *Manager) changeGroupGrants(ctx context.Context, cn, message string, change grantChange) (*models.Group, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithField("group", cn).Info(message)
+
+	changed, err := m.updateGrants(conn, m.config.GroupDN(cn), fmt.Errorf("%w: %s", models.ErrGroupNotFound, cn), true, change)
+	if err != nil {
+		return nil, err
+	}
+	// Cascade: sync repos for all members, including those of nested groups
+	if changed {
+		if err := m.syncGroupMemberRepos(conn, cn); err != nil {
+			m.logger.WithError(err).WithField("group", cn).Warn("Failed to cascade repos to group members")
+		}
+	}
+	return m.GetGroup(ctx, cn)
+}
+
+func (m *Manager) changeDepartmentGrants(ctx context.Context, ou, message string, change grantChange) (*models.Department, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithField("ou", ou).Info(message)
+
+	// Department grants reach users through GetEffectiveAccess, so there
+	// is nothing stored to cascade
+	if _, err := m.updateGrants(conn, m.config.DepartmentDN(ou), fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou), true, change); err != nil {
+		return nil, err
+	}
+	return m.GetDepartment(ctx, ou)
+}
+
+// addedGrantProblems validates grants about to be added
+func addedGrantProblems(grants []models.RepositoryGrant) error {
+	if len(grants) == 0 {
+		return &models.ValidationError{Problems: []string{"at least one repository is required"}}
+	}
+	if problems := models.GrantProblems(grants); len(problems) > 0 {
+		return &models.ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// removedRepoProblems validates repositories about to be removed
+func removedRepoProblems(repos []string) error {
+	if len(repos) == 0 {
+		return &models.ValidationError{Problems: []string{"at least one repository is required"}}
+	}
+	return addedGrantProblems(models.RepositoryGrants(repos))
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// COMPARE-AND-SWAP GRANT UPDATES
+// ═══════════════════════════════════════════════════════════════════════════
+
+// maxGrantUpdateRetries bounds how often a grant update is retried when
+// another editor changes the entry between our read and our write
+const maxGrantUpdateRetries = 10
+
+// grantChange computes the grants an entry should hold from those it holds
+type grantChange func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error)
+
+// updateGrants stores change(current) as the grants of the entry at dn and
+// reports whether anything changed. notFound is returned if the entry does
+// not exist. With mirror set, as for groups and departments, legacy
+// githubRepository values count as grants and githubRepository is kept
+// listing the active ones.
+//
+// The modify deletes exactly the values that go and adds exactly those
+// that come, never replacing the attribute. Values added or removed
+// concurrently by another editor are left alone, and if another editor
+// removed a value we delete or added one we add, LDAP rejects the modify
+// as a whole and we re-read and try again after a jittered backoff.
+func (m *Manager) updateGrants(conn *ldap.Conn, dn string, notFound error, mirror bool, change grantChange) (bool, error) {
+	for attempt := 0; attempt < maxGrantUpdateRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(config.ConflictRetryDelay(attempt))
+		}
+
+		entry, err := m.grantEntry(conn, dn, notFound)
+		if err != nil {
+			return false, err
+		}
+		next, err := change(m.entryGrants(entry, mirror))
+		if err != nil {
+			return false, err
+		}
+
+		modifyRequest := ldap.NewModifyRequest(dn, nil)
+		swapGrants(modifyRequest, entry, next, mirror)
+		if len(modifyRequest.Changes) == 0 {
+			return false, nil
+		}
+
+		if err := conn.Modify(modifyRequest); err != nil {
+			if isGrantConflict(err) {
+				m.logger.WithFields(logrus.Fields{
+					"dn":      dn,
+					"attempt": attempt + 1,
+				}).Debug("Repository grant conflict, retrying")
+				continue
+			}
+			return false, fmt.Errorf("failed to update repository grants: %w", err)
+		}
+		return true, nil
+	}
+
+	return false, fmt.Errorf("failed to update repository grants of %s: too many concurrent changes", dn)
+}
+
+// grantEntry reads the object classes, repositories and grants of an entry
+func (m *Manager) grantEntry(conn *ldap.Conn, dn string, notFound error) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"objectClass", "githubRepository", repositoryGrantAttr},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, notFound
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, notFound
+	}
+	return result.Entries[0], nil
+}
+
+// swapGrants adds to modifyRequest the deletes and adds that turn the
+// grants stored on entry, as read by grantEntry, into next
+func swapGrants(modifyRequest *ldap.ModifyRequest, entry *ldap.Entry, next []models.RepositoryGrant, mirror bool) {
+	removed, added := valueChanges(entry.GetAttributeValues(repositoryGrantAttr), models.GrantValues(next))
+	if len(added) > 0 && !hasObjectClass(entry, "extensibleObject") {
+		modifyRequest.Add("objectClass", []string{"extensibleObject"})
+	}
+	swapValues(modifyRequest, repositoryGrantAttr, removed, added)
+
+	if mirror {
+		removed, added = valueChanges(entry.GetAttributeValues("githubRepository"), models.GrantedRepositories(next, time.Now()))
+		swapValues(modifyRequest, "githubRepository", removed, added)
+	}
+}
+
+func swapValues(modifyRequest *ldap.ModifyRequest, attr string, removed, added []string) {
+	if len(removed) > 0 {
+		modifyRequest.Delete(attr, removed)
+	}
+	if len(added) > 0 {
+		modifyRequest.Add(attr, added)
+	}
+}
+
+// valueChanges returns the stored values missing from wanted and the
+// wanted values missing from stored, compared case-insensitively like the
+// directory does
+func valueChanges(stored, wanted []string) (removed, added []string) {
+	keep := make(map[string]struct{}, len(wanted))
+	for _, value := range wanted {
+		keep[strings.ToLower(value)] = struct{}{}
+	}
+	have := make(map[string]struct{}, len(stored))
+	for _, value := range stored {
+		have[strings.ToLower(value)] = struct{}{}
+		if _, ok := keep[strings.ToLower(value)]; !ok {
+			removed = append(removed, value)
+		}
+	}
+	for _, value := range wanted {
+		if _, ok := have[strings.ToLower(value)]; ok {
+			continue
+		}
+		have[strings.ToLower(value)] = struct{}{}
+		added = append(added, value)
+	}
+	return removed, added
+}
+
+// isGrantConflict reports whether a compare-and-swap modify lost to a
+// concurrent change
+func isGrantConflict(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists)
+}
+
+// MigrateRepositoryGrants turns githubRepository values written before
+// repository grants existed into DefaultPermission grants. On users, values
+// one of their groups grants are left alone, since the group cascade put
+// them there. Running it again changes nothing.
+func (m *Manager) MigrateRepositoryGrants(ctx context.Context) (*models.GrantMigrationResult, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.Info("Migrating repositories to repository grants")
+
+	result := &models.GrantMigrationResult{}
+
+	// Groups first, so the graph below holds their migrated grants
+	result.Groups, err = m.migrateEntryGrants(conn, m.config.GroupsDN(), "groupOfNames", nil)
+	if err != nil {
+		return nil, err
+	}
+	result.Departments, err = m.migrateEntryGrants(conn, m.config.DepartmentsDN(), "organizationalUnit", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return nil, err
+	}
+	result.Users, err = m.migrateEntryGrants(conn, m.config.UsersDN(), "inetOrgPerson", func(entry *ldap.Entry) map[string]struct{} {
+		return groupGrantRepos(graph, entry.DN)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"users":       result.Users,
+		"groups":      result.Groups,
+		"departments": result.Departments,
+	}).Info("Repository grants migrated")
+	return result, nil
+}
+
+// migrateEntryGrants adds DefaultPermission grants for the githubRepository
+// values of the entries below baseDN that neither their grants nor, when
+// given, inherited(entry) cover. It returns how many entries it changed.
+func (m *Manager) migrateEntryGrants(conn *ldap.Conn, baseDN, objectClass string, inherited func(*ldap.Entry) map[string]struct{}) (int, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=%s)(githubRepository=*))", objectClass),
+		[]string{"objectClass", "githubRepository", repositoryGrantAttr},
+		nil,
+	)
+
+	// Modify once the search is done rather than between its pages
+	var modifyRequests []*ldap.ModifyRequest
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		var covered map[string]struct{}
+		if inherited != nil {
+			covered = inherited(entry)
+		}
+		legacy := legacyGrants(entry.GetAttributeValues("githubRepository"), m.entryGrants(entry, false), covered)
+		if len(legacy) == 0 {
+			return
+		}
+		modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+		if !hasObjectClass(entry, "extensibleObject") {
+			modifyRequest.Add("objectClass", []string{"extensibleObject"})
+		}
+		modifyRequest.Add(repositoryGrantAttr, models.GrantValues(legacy))
+		modifyRequests = append(modifyRequests, modifyRequest)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to search %s: %w", baseDN, err)
+	}
+
+	for _, modifyRequest := range modifyRequests {
+		if err := conn.Modify(modifyRequest); err != nil {
+			return 0, fmt.Errorf("failed to migrate %s: %w", modifyRequest.DN, err)
+		}
+	}
+	return len(modifyRequests), nil
+}
+
+// hasObjectClass reports whether entry lists the object class
+func hasObjectClass(entry *ldap.Entry, objectClass string) bool {
+	for _, oc := range entry.GetAttributeValues("objectClass") {
+		if strings.EqualFold(oc, objectClass) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,500 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestEffectiveGrants(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	soon, later, past := now.Add(time.Hour), now.Add(48*time.Hour), now.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		sources [][]models.RepositoryGrant
+		want    []models.RepositoryGrant
+	}{
+		{
+			name: "highest level wins across sources",
+			sources: [][]models.RepositoryGrant{
+				{{Repository: "a", Permission: "read"}, {Repository: "b", Permission: "admin"}},
+				{{Repository: "a", Permission: "write"}, {Repository: "b", Permission: "read"}},
+			},
+			want: []models.RepositoryGrant{{Repository: "a", Permission: "write"}, {Repository: "b", Permission: "admin"}},
+		},
+		{
+			name: "expired grants are ignored",
+			sources: [][]models.RepositoryGrant{
+				{{Repository: "a", Permission: "admin", ExpiresAt: &past}, {Repository: "a", Permission: "read"}},
+				{{Repository: "b", Permission: "write", ExpiresAt: &past}},
+			},
+			want: []models.RepositoryGrant{{Repository: "a", Permission: "read"}},
+		},
+		{
+			name: "longest-lived grant at the same level",
+			sources: [][]models.RepositoryGrant{
+				{{Repository: "a", Permission: "write", ExpiresAt: &soon}},
+				{{Repository: "a", Permission: "write", ExpiresAt: &later}},
+				{{Repository: "b", Permission: "read", ExpiresAt: &later}, {Repository: "b", Permission: "read"}},
+			},
+			want: []models.RepositoryGrant{
+				{Repository: "a", Permission: "write", ExpiresAt: &later},
+				{Repository: "b", Permission: "read"},
+			},
+		},
+		{
+			name: "an expiring higher level still wins while active",
+			sources: [][]models.RepositoryGrant{
+				{{Repository: "a", Permission: "read"}, {Repository: "a", Permission: "admin", ExpiresAt: &soon}},
+			},
+			want: []models.RepositoryGrant{{Repository: "a", Permission: "admin", ExpiresAt: &soon}},
+		},
+		{
+			name: "no grants",
+			want: []models.RepositoryGrant{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := models.EffectiveGrants(now, tt.sources...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EffectiveGrants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveGrants(t *testing.T) {
+	expiry := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	current := []models.RepositoryGrant{
+		{Repository: "a", Permission: "read"},
+		{Repository: "b", Permission: "admin", ExpiresAt: &expiry},
+	}
+	// A permanent grant next to the time-bound one an access request gave
+	withPermanent := append(append([]models.RepositoryGrant{}, current...), models.RepositoryGrant{Repository: "b", Permission: "read"})
+
+	tests := []struct {
+		name   string
+		grants []models.RepositoryGrant
+		want   []models.RepositoryGrant
+	}{
+		{
+			name:   "bare repositories keep their grants",
+			grants: models.RepositoryGrants([]string{"a", "b"}),
+			want:   withPermanent,
+		},
+		{
+			name:   "new bare repositories get the default",
+			grants: models.RepositoryGrants([]string{"c"}),
+			want:   []models.RepositoryGrant{{Repository: "c", Permission: models.DefaultPermission}},
+		},
+		{
+			name:   "explicit permission replaces the current one",
+			grants: []models.RepositoryGrant{{Repository: "b", Permission: "read"}},
+			want:   []models.RepositoryGrant{{Repository: "b", Permission: "read"}},
+		},
+		{
+			name:   "bare repositories keep every grant they hold",
+			grants: models.RepositoryGrants([]string{"b"}),
+			want: []models.RepositoryGrant{
+				{Repository: "b", Permission: "admin", ExpiresAt: &expiry},
+				{Repository: "b", Permission: "read"},
+			},
+		},
+		{
+			name:   "later grant for a repository wins",
+			grants: append(models.RepositoryGrants([]string{"a"}), models.RepositoryGrant{Repository: "a", Permission: "admin"}),
+			want:   []models.RepositoryGrant{{Repository: "a", Permission: "admin"}},
+		},
+		{
+			name:   "bare repositories match their grants in any case",
+			grants: models.RepositoryGrants([]string{"B"}),
+			want: []models.RepositoryGrant{
+				{Repository: "b", Permission: "admin", ExpiresAt: &expiry},
+				{Repository: "b", Permission: "read"},
+			},
+		},
+		{
+			name:   "later grant wins in any case",
+			grants: []models.RepositoryGrant{{Repository: "C", Permission: "read"}, {Repository: "c", Permission: "write"}},
+			want:   []models.RepositoryGrant{{Repository: "c", Permission: "write"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := models.ResolveGrants(tt.grants, withPermanent); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveGrants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddGrants(t *testing.T) {
+	current := []models.RepositoryGrant{{Repository: "Repo/A", Permission: "read"}, {Repository: "repo/b", Permission: "write"}}
+
+	got := models.AddGrants([]models.RepositoryGrant{{Repository: "repo/a", Permission: "admin"}}, current)
+	want := []models.RepositoryGrant{{Repository: "repo/b", Permission: "write"}, {Repository: "repo/a", Permission: "admin"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AddGrants() = %v, want %v", got, want)
+	}
+}
+
+func TestParseRepositoryGrant(t *testing.T) {
+	expiry := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    models.RepositoryGrant
+		wantErr bool
+	}{
+		{value: "https://git/a write", want: models.RepositoryGrant{Repository: "https://git/a", Permission: "write"}},
+		{value: "https://git/a ADMIN 2027-01-01T00:00:00Z", want: models.RepositoryGrant{Repository: "https://git/a", Permission: "admin", ExpiresAt: &expiry}},
+		{value: "https://git/a", wantErr: true},
+		{value: "https://git/a owner", wantErr: true},
+		{value: "https://git/a read tomorrow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := models.ParseRepositoryGrant(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRepositoryGrant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Repository != tt.want.Repository || got.Permission != tt.want.Permission ||
+				(got.ExpiresAt == nil) != (tt.want.ExpiresAt == nil) || (got.ExpiresAt != nil && !got.ExpiresAt.Equal(*tt.want.ExpiresAt)) {
+				t.Errorf("ParseRepositoryGrant() = %v, want %v", got, tt.want)
+			}
+			if round, _ := models.ParseRepositoryGrant(got.String()); round.String() != got.String() {
+				t.Errorf("String() = %q does not round-trip", got.String())
+			}
+		})
+	}
+}
+
+// grantsDirectory holds alice in engineering and the developers group, plus
+// bob who holds a repository only through githubRepository
+func grantsDirectory(t *testing.T) *fakeDirectory {
+	usersDN := "ou=users," + testBaseDN
+	groupsDN := "ou=groups," + testBaseDN
+	departmentsDN := "ou=departments," + testBaseDN
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	return newFakeDirectory(t,
+		testEntry(testBaseDN),
+		testEntry(usersDN),
+		testEntry(groupsDN),
+		testEntry(departmentsDN),
+		testEntry("uid=alice,"+usersDN,
+			"objectClass", "inetOrgPerson",
+			"uid", "alice",
+			"departmentNumber", "engineering",
+			repositoryGrantAttr, "repo/a read",
+			"githubRepository", "repo/a",
+			"githubRepository", "repo/b",
+		),
+		testEntry("uid=bob,"+usersDN,
+			"objectClass", "inetOrgPerson",
+			"uid", "bob",
+			"githubRepository", "repo/legacy",
+		),
+		testEntry("cn=developers,"+groupsDN,
+			"objectClass", "groupOfNames",
+			"objectClass", "extensibleObject",
+			"cn", "developers",
+			"member", "uid=alice,"+usersDN,
+			repositoryGrantAttr, "repo/a write",
+			repositoryGrantAttr, "repo/gone admin "+expired,
+			"githubRepository", "repo/a",
+			"githubRepository", "repo/b",
+		),
+		testEntry("ou=engineering,"+departmentsDN,
+			"objectClass", "organizationalUnit",
+			"ou", "engineering",
+			"githubRepository", "repo/c",
+		),
+	)
+}
+
+func TestGetEffectiveAccessGrants(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+
+	tests := []struct {
+		uid  string
+		want []models.RepositoryGrant
+	}{
+		{
+			// repo/b counts as a legacy group grant, repo/c as a legacy
+			// department grant; the expired admin grant is gone
+			uid: "alice",
+			want: []models.RepositoryGrant{
+				{Repository: "repo/a", Permission: "write"},
+				{Repository: "repo/b", Permission: models.DefaultPermission},
+				{Repository: "repo/c", Permission: models.DefaultPermission},
+			},
+		},
+		{
+			uid:  "bob",
+			want: []models.RepositoryGrant{{Repository: "repo/legacy", Permission: models.DefaultPermission}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uid, func(t *testing.T) {
+			access, err := m.GetEffectiveAccess(context.Background(), tt.uid)
+			if err != nil {
+				t.Fatalf("GetEffectiveAccess() error = %v", err)
+			}
+			if !reflect.DeepEqual(access.Grants, tt.want) {
+				t.Errorf("Grants = %v, want %v", access.Grants, tt.want)
+			}
+			if want := models.GrantedRepositories(tt.want, time.Now()); !reflect.DeepEqual(access.Repositories, want) {
+				t.Errorf("Repositories = %v, want %v", access.Repositories, want)
+			}
+		})
+	}
+}
+
+func TestMigrateRepositoryGrants(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+
+	result, err := m.MigrateRepositoryGrants(context.Background())
+	if err != nil {
+		t.Fatalf("MigrateRepositoryGrants() error = %v", err)
+	}
+	if want := (models.GrantMigrationResult{Users: 1, Groups: 1, Departments: 1}); *result != want {
+		t.Errorf("MigrateRepositoryGrants() = %+v, want %+v", *result, want)
+	}
+
+	grantsOf := func(dn string) []string {
+		values := append([]string{}, dir.entry(dn).GetAttributeValues(repositoryGrantAttr)...)
+		sort.Strings(values)
+		return values
+	}
+	// alice's repo/b comes from developers and stays out of her grants
+	if got, want := grantsOf("uid=alice,ou=users,"+testBaseDN), []string{"repo/a read"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice grants = %v, want %v", got, want)
+	}
+	if got, want := grantsOf("uid=bob,ou=users,"+testBaseDN), []string{"repo/legacy write"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bob grants = %v, want %v", got, want)
+	}
+	if got := grantsOf("cn=developers,ou=groups," + testBaseDN); len(got) != 3 || got[1] != "repo/b write" {
+		t.Errorf("developers grants = %v, want repo/b added", got)
+	}
+	if got, want := grantsOf("ou=engineering,ou=departments,"+testBaseDN), []string{"repo/c write"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("engineering grants = %v, want %v", got, want)
+	}
+	if !hasObjectClass(dir.entry("uid=bob,ou=users,"+testBaseDN), "extensibleObject") {
+		t.Error("bob was not made an extensibleObject")
+	}
+
+	again, err := m.MigrateRepositoryGrants(context.Background())
+	if err != nil {
+		t.Fatalf("second MigrateRepositoryGrants() error = %v", err)
+	}
+	if *again != (models.GrantMigrationResult{}) {
+		t.Errorf("second MigrateRepositoryGrants() = %+v, want no changes", *again)
+	}
+}
+
+func TestAssignRepositoriesToGroupGrants(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+
+	grants := append(models.RepositoryGrants([]string{"repo/a"}), models.RepositoryGrant{Repository: "repo/d", Permission: "admin"})
+	group, err := m.AssignRepositoriesToGroup(context.Background(), "developers", grants)
+	if err != nil {
+		t.Fatalf("AssignRepositoriesToGroup() error = %v", err)
+	}
+
+	want := []models.RepositoryGrant{{Repository: "repo/a", Permission: "write"}, {Repository: "repo/d", Permission: "admin"}}
+	if !reflect.DeepEqual(group.Grants, want) {
+		t.Errorf("group grants = %v, want %v", group.Grants, want)
+	}
+	if got, want := group.Repositories, []string{"repo/a", "repo/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("group repositories = %v, want %v", got, want)
+	}
+
+	// alice's githubRepository lists her own grant and the group's
+	alice := dir.entry("uid=alice,ou=users," + testBaseDN)
+	if got, want := alice.GetAttributeValues("githubRepository"), []string{"repo/a", "repo/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice githubRepository = %v, want %v", got, want)
+	}
+
+	_, err = m.AssignRepositoriesToGroup(context.Background(), "developers", []models.RepositoryGrant{{Repository: "repo/e", Permission: "owner"}})
+	if _, ok := err.(*models.ValidationError); !ok {
+		t.Errorf("AssignRepositoriesToGroup() with an unknown permission error = %v, want a ValidationError", err)
+	}
+}
+
+func TestUpdateUserGrants(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+
+	user, err := m.UpdateUser(context.Background(), &models.UpdateUserInput{
+		UID:    "alice",
+		Grants: []models.RepositoryGrant{{Repository: "repo/a"}, {Repository: "repo/x", Permission: "read"}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+
+	want := []models.RepositoryGrant{{Repository: "repo/a", Permission: "read"}, {Repository: "repo/x", Permission: "read"}}
+	if !reflect.DeepEqual(user.Grants, want) {
+		t.Errorf("grants = %v, want %v", user.Grants, want)
+	}
+	// The group's repositories stay listed next to the new grant
+	if got, want := user.Repositories, []string{"repo/a", "repo/b", "repo/x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("repositories = %v, want %v", got, want)
+	}
+}
+
+func TestAddRemoveRepositories(t *testing.T) {
+	dir := grantsDirectory(t)
+	m := newTestManager(t, testConfig(dir.URL()))
+	ctx := context.Background()
+	aliceDN := "uid=alice,ou=users," + testBaseDN
+
+	user, err := m.AddUserRepositories(ctx, "alice", []models.RepositoryGrant{{Repository: "repo/x", Permission: "admin"}, {Repository: "repo/a"}})
+	if err != nil {
+		t.Fatalf("AddUserRepositories() error = %v", err)
+	}
+	want := []models.RepositoryGrant{{Repository: "repo/a", Permission: "read"}, {Repository: "repo/x", Permission: "admin"}}
+	if !reflect.DeepEqual(user.Grants, want) {
+		t.Errorf("grants after adding = %v, want %v", user.Grants, want)
+	}
+
+	// What the group grants stays listed after the direct grant goes
+	user, err = m.RemoveUserRepositories(ctx, "alice", []string{"REPO/A"})
+	if err != nil {
+		t.Fatalf("RemoveUserRepositories() error = %v", err)
+	}
+	if want := []models.RepositoryGrant{{Repository: "repo/x", Permission: "admin"}}; !reflect.DeepEqual(user.Grants, want) {
+		t.Errorf("grants after removing = %v, want %v", user.Grants, want)
+	}
+	if got, want := user.Repositories, []string{"repo/a", "repo/b", "repo/x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("repositories after removing = %v, want %v", got, want)
+	}
+
+	group, err := m.AddGroupRepositories(ctx, "developers", []models.RepositoryGrant{{Repository: "repo/y", Permission: "read"}})
+	if err != nil {
+		t.Fatalf("AddGroupRepositories() error = %v", err)
+	}
+	if got, want := group.Repositories, []string{"repo/a", "repo/b", "repo/y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("group repositories after adding = %v, want %v", got, want)
+	}
+	group, err = m.RemoveGroupRepositories(ctx, "developers", []string{"repo/b"})
+	if err != nil {
+		t.Fatalf("RemoveGroupRepositories() error = %v", err)
+	}
+	if got, want := group.Repositories, []string{"repo/a", "repo/y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("group repositories after removing = %v, want %v", got, want)
+	}
+	// Members follow the group
+	if got, want := dir.entry(aliceDN).GetAttributeValues("githubRepository"), []string{"repo/a", "repo/x", "repo/y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice githubRepository = %v, want %v", got, want)
+	}
+
+	dept, err := m.AddDepartmentRepositories(ctx, "engineering", []models.RepositoryGrant{{Repository: "repo/z", Permission: "read"}})
+	if err != nil {
+		t.Fatalf("AddDepartmentRepositories() error = %v", err)
+	}
+	if got, want := dept.Repositories, []string{"repo/c", "repo/z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("department repositories after adding = %v, want %v", got, want)
+	}
+	dept, err = m.RemoveDepartmentRepositories(ctx, "engineering", []string{"repo/c", "repo/missing"})
+	if err != nil {
+		t.Fatalf("RemoveDepartmentRepositories() error = %v", err)
+	}
+	if want := []models.RepositoryGrant{{Repository: "repo/z", Permission: "read"}}; !reflect.DeepEqual(dept.Grants, want) {
+		t.Errorf("department grants after removing = %v, want %v", dept.Grants, want)
+	}
+
+	if _, err := m.AddGroupRepositories(ctx, "developers", nil); !errors.As(err, new(*models.ValidationError)) {
+		t.Errorf("AddGroupRepositories() without repositories error = %v, want a ValidationError", err)
+	}
+	if _, err := m.RemoveUserRepositories(ctx, "nobody", []string{"repo/a"}); !errors.Is(err, models.ErrUserNotFound) {
+		t.Errorf("RemoveUserRepositories() of an unknown user error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUpdateGrantsConcurrentEditors(t *testing.T) {
+	groupDN := "cn=developers,ou=groups," + testBaseDN
+
+	tests := []struct {
+		name string
+		// concurrent runs once, just before our first modify of the group
+		concurrent func(entry *ldap.Entry)
+		add        models.RepositoryGrant
+		want       []string
+	}{
+		{
+			name: "keeps a repository added in between",
+			concurrent: func(entry *ldap.Entry) {
+				applyChange(entry, 0, repositoryGrantAttr, []string{"repo/q read"})
+				applyChange(entry, 0, "githubRepository", []string{"repo/q"})
+			},
+			add:  models.RepositoryGrant{Repository: "repo/y", Permission: "read"},
+			want: []string{"repo/a", "repo/b", "repo/q", "repo/y"},
+		},
+		{
+			name: "retries when a grant it replaces was removed in between",
+			concurrent: func(entry *ldap.Entry) {
+				applyChange(entry, 1, repositoryGrantAttr, []string{"repo/a write"})
+				applyChange(entry, 1, "githubRepository", []string{"repo/a"})
+			},
+			add:  models.RepositoryGrant{Repository: "repo/a", Permission: "admin"},
+			want: []string{"repo/a", "repo/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := grantsDirectory(t)
+			m := newTestManager(t, testConfig(dir.URL()))
+			done := false
+			dir.mu.Lock()
+			dir.beforeModify = func(dir *fakeDirectory, dn string) {
+				if !done && strings.EqualFold(dn, groupDN) {
+					done = true
+					tt.concurrent(dir.entries[strings.ToLower(dn)])
+				}
+			}
+			dir.mu.Unlock()
+
+			group, err := m.AddGroupRepositories(context.Background(), "developers", []models.RepositoryGrant{tt.add})
+			if err != nil {
+				t.Fatalf("AddGroupRepositories() error = %v", err)
+			}
+			sort.Strings(group.Repositories)
+			if !reflect.DeepEqual(group.Repositories, tt.want) {
+				t.Errorf("repositories = %v, want %v", group.Repositories, tt.want)
+			}
+			held := 0
+			for _, grant := range group.Grants {
+				if grant.Repository == tt.add.Repository {
+					held++
+					if grant.Permission != tt.add.Permission {
+						t.Errorf("grant = %v, want %v", grant, tt.add)
+					}
+				}
+			}
+			if held != 1 {
+				t.Errorf("grants = %v, want one for %s", group.Grants, tt.add.Repository)
+			}
+		})
+	}
+}
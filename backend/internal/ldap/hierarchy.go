@@ -0,0 +1,518 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// parentDepartmentAttr holds the DN of a department's parent (devplatform
+// schema). Departments stay flat under ou=departments so their DNs never
+// change when they are moved in the hierarchy.
+const parentDepartmentAttr = "parentDepartment"
+
+// departmentNode is one department of the in-memory hierarchy
+type departmentNode struct {
+	parent string // parent OU, "" for top-level departments
+	grants []models.RepositoryGrant
+}
+
+// groupNode is one group of the in-memory nesting graph
+type groupNode struct {
+	name      string   // cn as stored
+	users     []string // member user DNs
+	subgroups []string // keys of nested groups
+	grants    []models.RepositoryGrant
+}
+
+// groupGraph is the nesting graph keyed by lower-cased cn, since member DNs
+// may spell a group's cn differently from the group entry
+type groupGraph map[string]*groupNode
+
+// groupKey returns the graph key of a group cn
+func groupKey(cn string) string {
+	return strings.ToLower(cn)
+}
+
+// node returns the group with the given cn, in any case
+func (g groupGraph) node(cn string) (*groupNode, bool) {
+	node, ok := g[groupKey(cn)]
+	return node, ok
+}
+
+// SetDepartmentParent moves a department under another one, or to the top
+// level when parent is empty. Moves that would make a department its own
+// ancestor are rejected with ErrHierarchyCycle.
+func (m *Manager) SetDepartmentParent(ctx context.Context, ou, parent string) (*models.Department, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{"ou": ou, "parent": parent}).Info("Setting department parent")
+
+	modifyRequest := ldap.NewModifyRequest(m.config.DepartmentDN(ou), nil)
+	if parent == "" {
+		modifyRequest.Replace(parentDepartmentAttr, []string{})
+	} else {
+		tree, err := m.loadDepartmentTree(conn)
+		if err != nil {
+			m.returnConnection(conn)
+			return nil, err
+		}
+		if _, ok := tree[ou]; !ok {
+			m.returnConnection(conn)
+			return nil, fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou)
+		}
+		if _, ok := tree[parent]; !ok {
+			m.returnConnection(conn)
+			return nil, fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, parent)
+		}
+		for _, ancestor := range departmentChain(tree, parent, m.logger) {
+			if strings.EqualFold(ancestor, ou) {
+				m.returnConnection(conn)
+				return nil, fmt.Errorf("%w: %s is an ancestor of %s", models.ErrHierarchyCycle, ou, parent)
+			}
+		}
+		modifyRequest.Replace(parentDepartmentAttr, []string{m.config.DepartmentDN(parent)})
+	}
+
+	err = conn.Modify(modifyRequest)
+	m.returnConnection(conn)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou)
+		}
+		m.logger.WithError(err).Error("Failed to set department parent")
+		return nil, fmt.Errorf("failed to set department parent: %w", err)
+	}
+
+	return m.GetDepartment(ctx, ou)
+}
+
+// GetDepartmentChildren returns the direct child departments of a department
+func (m *Manager) GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=organizationalUnit)(%s=%s))", parentDepartmentAttr, ldap.EscapeFilter(m.config.DepartmentDN(ou))),
+		departmentAttributes,
+		nil,
+	)
+
+	window := newPageWindow(nil)
+	err = m.pagedSearch(conn, searchRequest, "ou", func(entry *ldap.Entry) {
+		window.add(entry.GetAttributeValue("ou"), entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	entries, _ := window.result()
+	children := make([]*models.Department, 0, len(entries))
+	for _, entry := range entries {
+		dept := m.entryToDepartment(entry)
+		if members, err := m.departmentMembers(conn, dept.OU); err == nil {
+			dept.Members = members
+		}
+		children = append(children, dept)
+	}
+	return children, nil
+}
+
+// AddGroupToGroup nests a group inside another one. Its members, including
+// those of groups nested in it, then inherit the parent group's
+// repositories. Nesting that would make a group contain itself is rejected
+// with ErrHierarchyCycle.
+func (m *Manager) AddGroupToGroup(ctx context.Context, childCN, parentCN string) error {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithFields(logrus.Fields{"group": childCN, "parent": parentCN}).Info("Nesting group")
+
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return err
+	}
+	if _, ok := graph.node(childCN); !ok {
+		return fmt.Errorf("%w: %s", models.ErrGroupNotFound, childCN)
+	}
+	if _, ok := graph.node(parentCN); !ok {
+		return fmt.Errorf("%w: %s", models.ErrGroupNotFound, parentCN)
+	}
+	for _, nested := range append([]string{groupKey(childCN)}, nestedGroups(graph, childCN)...) {
+		if nested == groupKey(parentCN) {
+			return fmt.Errorf("%w: %s already contains %s", models.ErrHierarchyCycle, childCN, parentCN)
+		}
+	}
+
+	modifyRequest := ldap.NewModifyRequest(m.config.GroupDN(parentCN), nil)
+	modifyRequest.Add("member", []string{m.config.GroupDN(childCN)})
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to nest group")
+		return fmt.Errorf("failed to add group to group: %w", err)
+	}
+
+	if err := m.syncGroupMemberRepos(conn, childCN); err != nil {
+		m.logger.WithError(err).Warn("Failed to cascade repos after nesting group")
+	}
+	return nil
+}
+
+// RemoveGroupFromGroup undoes AddGroupToGroup, resyncing the repositories of
+// the child group's members
+func (m *Manager) RemoveGroupFromGroup(ctx context.Context, childCN, parentCN string) error {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithFields(logrus.Fields{"group": childCN, "parent": parentCN}).Info("Un-nesting group")
+
+	if err := m.removeGroupMember(conn, parentCN, m.config.GroupDN(childCN)); err != nil {
+		m.logger.WithError(err).Error("Failed to un-nest group")
+		return fmt.Errorf("failed to remove group from group: %w", err)
+	}
+
+	if err := m.syncGroupMemberRepos(conn, childCN); err != nil {
+		m.logger.WithError(err).Warn("Failed to cascade repos after un-nesting group")
+	}
+	return nil
+}
+
+// GetEffectiveAccess resolves the groups, departments and repositories a
+// user has directly or through the group and department hierarchies. Each
+// repository is granted at the highest level any active grant gives.
+// Disabled, deprovisioned and expired accounts have no effective access.
+// Under WithHierarchySnapshot the hierarchy is loaded once per request.
+func (m *Manager) GetEffectiveAccess(ctx context.Context, uid string) (*models.EffectiveAccess, error) {
+	snapshot := snapshotFrom(ctx)
+	if access := snapshot.cachedAccess(uid); access != nil {
+		return access, nil
+	}
+
+	user, err := m.GetUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if checkAccountUsable(user, time.Now()) != nil {
+		return &models.EffectiveAccess{UID: uid, Groups: []string{}, Departments: []string{}, Repositories: []string{}, Grants: []models.RepositoryGrant{}}, nil
+	}
+
+	graph, tree, err := m.loadHierarchy(ctx, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	userDN := m.config.UserDN(uid)
+	access := &models.EffectiveAccess{
+		UID:         uid,
+		Groups:      userGroups(graph, userDN),
+		Departments: []string{},
+	}
+
+	// Until MigrateRepositoryGrants has run, repositories assigned to the
+	// user before grants existed are only listed in githubRepository
+	sources := [][]models.RepositoryGrant{
+		user.Grants,
+		legacyGrants(user.Repositories, user.Grants, groupGrantRepos(graph, userDN)),
+	}
+	for _, cn := range access.Groups {
+		node, _ := graph.node(cn)
+		sources = append(sources, node.grants)
+	}
+	if user.Department != "" {
+		access.Departments = departmentChain(tree, user.Department, m.logger)
+		for _, ou := range access.Departments {
+			if node, ok := tree[ou]; ok {
+				sources = append(sources, node.grants)
+			}
+		}
+	}
+
+	now := time.Now()
+	access.Grants = models.EffectiveGrants(now, sources...)
+	access.Repositories = models.GrantedRepositories(access.Grants, now)
+
+	snapshot.storeAccess(uid, access)
+	return access, nil
+}
+
+// GetGroupEffectiveMembers returns the UIDs of a group's direct members and
+// of the members of every group nested in it
+func (m *Manager) GetGroupEffectiveMembers(ctx context.Context, cn string) ([]string, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := graph.node(cn); !ok {
+		return nil, fmt.Errorf("%w: %s", models.ErrGroupNotFound, cn)
+	}
+
+	members := []string{}
+	for _, userDN := range groupUserDNs(graph, cn) {
+		if uid := rdnValue(userDN, "uid"); uid != "" {
+			members = append(members, uid)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// HIERARCHY HELPERS
+// ═══════════════════════════════════════════════════════════════════════════
+
+// loadDepartmentTree reads every department's parent and repository grants
+func (m *Manager) loadDepartmentTree(conn *ldap.Conn) (map[string]*departmentNode, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=organizationalUnit)",
+		[]string{"ou", parentDepartmentAttr, "githubRepository", repositoryGrantAttr},
+		nil,
+	)
+
+	tree := make(map[string]*departmentNode)
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		tree[entry.GetAttributeValue("ou")] = &departmentNode{
+			parent: rdnValue(entry.GetAttributeValue(parentDepartmentAttr), "ou"),
+			grants: m.entryGrants(entry, true),
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load departments: %w", err)
+	}
+	return tree, nil
+}
+
+// loadGroupGraph reads every group's members, nested groups and repository
+// grants
+func (m *Manager) loadGroupGraph(conn *ldap.Conn) (groupGraph, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.GroupsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=groupOfNames)",
+		[]string{"cn", "member", "githubRepository", repositoryGrantAttr},
+		nil,
+	)
+
+	graph := make(groupGraph)
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		node := &groupNode{
+			name:   entry.GetAttributeValue("cn"),
+			grants: m.entryGrants(entry, true),
+		}
+		for _, memberDN := range entry.GetAttributeValues("member") {
+			if strings.Contains(memberDN, "placeholder") {
+				continue
+			}
+			if cn := rdnValue(memberDN, "cn"); cn != "" {
+				node.subgroups = append(node.subgroups, groupKey(cn))
+			} else {
+				node.users = append(node.users, memberDN)
+			}
+		}
+		graph[groupKey(node.name)] = node
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load groups: %w", err)
+	}
+	return graph, nil
+}
+
+// departmentChain returns ou followed by its ancestors, nearest first. A
+// cycle (possible only through edits made outside this service) ends the
+// walk and is logged.
+func departmentChain(tree map[string]*departmentNode, ou string, logger *logrus.Logger) []string {
+	chain := []string{}
+	seen := make(map[string]struct{})
+	for current := ou; current != ""; {
+		key := strings.ToLower(current)
+		if _, ok := seen[key]; ok {
+			logger.WithFields(logrus.Fields{"department": ou, "repeated": current}).Warn("Department hierarchy cycle detected")
+			break
+		}
+		seen[key] = struct{}{}
+		chain = append(chain, current)
+
+		node, ok := tree[current]
+		if !ok {
+			break
+		}
+		current = node.parent
+	}
+	return chain
+}
+
+// nestedGroups returns the keys of every group nested in cn, directly or
+// transitively
+func nestedGroups(graph groupGraph, cn string) []string {
+	var nested []string
+	seen := map[string]struct{}{groupKey(cn): {}}
+	queue := []string{groupKey(cn)}
+	for len(queue) > 0 {
+		node, ok := graph[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, sub := range node.subgroups {
+			if _, ok := seen[sub]; ok {
+				continue
+			}
+			seen[sub] = struct{}{}
+			nested = append(nested, sub)
+			queue = append(queue, sub)
+		}
+	}
+	return nested
+}
+
+// groupUserDNs returns the DNs of the users in cn, directly or through a
+// nested group, without duplicates
+func groupUserDNs(graph groupGraph, cn string) []string {
+	seen := make(map[string]struct{})
+	var users []string
+	for _, group := range append([]string{groupKey(cn)}, nestedGroups(graph, cn)...) {
+		node, ok := graph[group]
+		if !ok {
+			continue
+		}
+		for _, userDN := range node.users {
+			key := strings.ToLower(userDN)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			users = append(users, userDN)
+		}
+	}
+	return users
+}
+
+// userGroups returns the cns of the groups a user belongs to directly, plus
+// every group those are nested in, sorted
+func userGroups(graph groupGraph, userDN string) []string {
+	parents := make(map[string][]string)
+	queue := []string{}
+	for key, node := range graph {
+		for _, sub := range node.subgroups {
+			parents[sub] = append(parents[sub], key)
+		}
+		for _, member := range node.users {
+			if strings.EqualFold(member, userDN) {
+				queue = append(queue, key)
+				break
+			}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	groups := []string{}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		groups = append(groups, graph[key].name)
+		queue = append(queue, parents[key]...)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// departmentExists returns the DN of a department, or ErrDepartmentNotFound
+func (m *Manager) departmentExists(conn *ldap.Conn, ou string) (string, error) {
+	deptDN := m.config.DepartmentDN(ou)
+	searchRequest := ldap.NewSearchRequest(
+		deptDN,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=organizationalUnit)",
+		[]string{"ou"},
+		nil,
+	)
+	if _, err := conn.Search(searchRequest); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return "", fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou)
+		}
+		return "", fmt.Errorf("search failed: %w", err)
+	}
+	return deptDN, nil
+}
+
+// childDepartments returns the OUs of a department's direct children
+func (m *Manager) childDepartments(conn *ldap.Conn, ou string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(%s=%s)", parentDepartmentAttr, ldap.EscapeFilter(m.config.DepartmentDN(ou))),
+		[]string{"ou"},
+		nil,
+	)
+
+	var children []string
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		children = append(children, entry.GetAttributeValue("ou"))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search child departments: %w", err)
+	}
+	return children, nil
+}
+
+// removeGroupMember deletes one member value from a group
+func (m *Manager) removeGroupMember(conn *ldap.Conn, cn, memberDN string) error {
+	modifyRequest := ldap.NewModifyRequest(m.config.GroupDN(cn), nil)
+	modifyRequest.Delete("member", []string{memberDN})
+	return conn.Modify(modifyRequest)
+}
+
+// rdnValue returns the value of the first RDN of dn if its attribute is
+// attr, and "" otherwise
+func rdnValue(dn, attr string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, ava := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(ava.Type, attr) {
+			return ava.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// nextUID reserves the next available UID number
+func (m *Manager) nextUID(conn *ldap.Conn) (int, error) {
+	return m.reserveID(conn, "uidNumber")
+}
+
+// nextGID reserves the next available GID number
+func (m *Manager) nextGID(conn *ldap.Conn) (int, error) {
+	return m.reserveID(conn, "gidNumber")
+}
+
+// reserveID reserves the next value of the given counter attribute with
+// config.ReserveID, seeding the allocator entry first if it does not exist
+// yet
+func (m *Manager) reserveID(conn *ldap.Conn, attr string) (int, error) {
+	id, err := config.ReserveID(conn, m.config.IDAllocatorDN(), attr)
+	if errors.Is(err, config.ErrIDAllocatorMissing) {
+		if err := m.seedIDAllocator(conn); err != nil {
+			return 0, err
+		}
+		id, err = config.ReserveID(conn, m.config.IDAllocatorDN(), attr)
+	}
+	return id, err
+}
+
+// seedIDAllocator creates the allocator entry, starting each counter after
+// the highest number already present in the directory so that entries
+// created before the allocator existed are never re-issued
+func (m *Manager) seedIDAllocator(conn *ldap.Conn) error {
+	maxUID, err := m.scanMaxAttribute(conn, m.config.UsersDN(), "(objectClass=posixAccount)", "uidNumber")
+	if err != nil {
+		return err
+	}
+	maxUserGID, err := m.scanMaxAttribute(conn, m.config.UsersDN(), "(objectClass=posixAccount)", "gidNumber")
+	if err != nil {
+		return err
+	}
+	maxGroupGID, err := m.scanMaxAttribute(conn, m.config.GroupsDN(), "(objectClass=posixGroup)", "gidNumber")
+	if err != nil {
+		return err
+	}
+
+	uidStart := maxInt(m.config.StartingUID, maxUID)
+	gidStart := maxInt(m.config.StartingGID, maxInt(maxUserGID, maxGroupGID))
+
+	addRequest := ldap.NewAddRequest(m.config.IDAllocatorDN(), nil)
+	addRequest.Attribute("objectClass", []string{"applicationProcess", "extensibleObject"})
+	addRequest.Attribute("cn", []string{config.IDAllocatorCN})
+	addRequest.Attribute("description", []string{"Last issued POSIX uidNumber/gidNumber"})
+	addRequest.Attribute("uidNumber", []string{strconv.Itoa(uidStart)})
+	addRequest.Attribute("gidNumber", []string{strconv.Itoa(gidStart)})
+
+	if err := conn.Add(addRequest); err != nil {
+		// Another replica seeded it concurrently; its values are just as valid
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return nil
+		}
+		return fmt.Errorf("failed to create ID allocator: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uidNumber": uidStart,
+		"gidNumber": gidStart,
+	}).Info("ID allocator seeded from directory")
+	return nil
+}
+
+// scanMaxAttribute returns the highest numeric value of attr below baseDN
+func (m *Manager) scanMaxAttribute(conn *ldap.Conn, baseDN, filter, attr string) (int, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		filter,
+		[]string{attr},
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to scan %s: %w", attr, err)
+	}
+
+	highest := 0
+	for _, entry := range result.Entries {
+		if value, err := strconv.Atoi(entry.GetAttributeValue(attr)); err == nil && value > highest {
+			highest = value
+		}
+	}
+
+	return highest, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,180 @@
+package ldap
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestReserveID(t *testing.T) {
+	allocatorDN := "cn=idAllocator," + testBaseDN
+	usersDN := "ou=users," + testBaseDN
+	groupsDN := "ou=groups," + testBaseDN
+
+	// bump simulates another replica reserving the next value just before
+	// our modify lands
+	bump := func(dir *fakeDirectory, dn string) {
+		entry := dir.entries[strings.ToLower(dn)]
+		attr := findAttribute(entry, "uidNumber")
+		value, _ := strconv.Atoi(attr.Values[0])
+		attr.Values = []string{strconv.Itoa(value + 1)}
+	}
+
+	tests := []struct {
+		name         string
+		entries      []*ldap.Entry
+		attr         string
+		beforeModify func(dir *fakeDirectory, dn string)
+		want         int
+		wantErr      string
+	}{
+		{
+			name:    "seeds an empty directory from the starting number",
+			entries: []*ldap.Entry{testEntry(testBaseDN)},
+			attr:    "uidNumber",
+			want:    10001,
+		},
+		{
+			name: "seeds above users created before the allocator",
+			entries: []*ldap.Entry{
+				testEntry(testBaseDN),
+				testEntry(usersDN),
+				testEntry("uid=alice,"+usersDN, "objectClass", "posixAccount", "uidNumber", "10500", "gidNumber", "10000"),
+				testEntry("uid=bob,"+usersDN, "objectClass", "posixAccount", "uidNumber", "10042", "gidNumber", "10000"),
+			},
+			attr: "uidNumber",
+			want: 10501,
+		},
+		{
+			name: "seeds gid numbers above users and groups alike",
+			entries: []*ldap.Entry{
+				testEntry(testBaseDN),
+				testEntry(usersDN),
+				testEntry(groupsDN),
+				testEntry("uid=alice,"+usersDN, "objectClass", "posixAccount", "uidNumber", "10500", "gidNumber", "10300"),
+				testEntry("cn=devs,"+groupsDN, "objectClass", "posixGroup", "gidNumber", "10700"),
+			},
+			attr: "gidNumber",
+			want: 10701,
+		},
+		{
+			name: "continues from an existing allocator",
+			entries: []*ldap.Entry{
+				testEntry(testBaseDN),
+				testEntry(allocatorDN, "cn", "idAllocator", "uidNumber", "12000", "gidNumber", "15000"),
+			},
+			attr: "gidNumber",
+			want: 15001,
+		},
+		{
+			name: "retries after losing the compare-and-swap",
+			entries: []*ldap.Entry{
+				testEntry(testBaseDN),
+				testEntry(allocatorDN, "cn", "idAllocator", "uidNumber", "12000", "gidNumber", "15000"),
+			},
+			attr: "uidNumber",
+			beforeModify: func() func(*fakeDirectory, string) {
+				conflicts := 2
+				return func(dir *fakeDirectory, dn string) {
+					if conflicts > 0 {
+						conflicts--
+						bump(dir, dn)
+					}
+				}
+			}(),
+			want: 12003,
+		},
+		{
+			name: "gives up when every attempt conflicts",
+			entries: []*ldap.Entry{
+				testEntry(testBaseDN),
+				testEntry(allocatorDN, "cn", "idAllocator", "uidNumber", "12000", "gidNumber", "15000"),
+			},
+			attr:         "uidNumber",
+			beforeModify: bump,
+			wantErr:      "too many concurrent allocations",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newFakeDirectory(t, tt.entries...)
+			m := newTestManager(t, testConfig(dir.URL()))
+			dir.mu.Lock()
+			dir.beforeModify = tt.beforeModify
+			dir.mu.Unlock()
+
+			conn, err := m.getConnection(context.Background())
+			if err != nil {
+				t.Fatalf("getConnection() error = %v", err)
+			}
+			defer m.returnConnection(conn)
+
+			got, err := m.reserveID(conn, tt.attr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("reserveID() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reserveID() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("reserveID() = %d, want %d", got, tt.want)
+			}
+			if stored := dir.entry(allocatorDN).GetAttributeValue(tt.attr); stored != strconv.Itoa(tt.want) {
+				t.Errorf("allocator %s = %s, want %d", tt.attr, stored, tt.want)
+			}
+		})
+	}
+}
+
+func TestReserveIDConcurrentReplicas(t *testing.T) {
+	dir := newFakeDirectory(t, testEntry(testBaseDN))
+	// Two managers stand in for two backend replicas sharing the directory
+	replicas := []*Manager{
+		newTestManager(t, testConfig(dir.URL())),
+		newTestManager(t, testConfig(dir.URL())),
+	}
+
+	const perWorker = 5
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 6; worker++ {
+		m := replicas[worker%len(replicas)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				conn, err := m.getConnection(context.Background())
+				if err != nil {
+					t.Errorf("getConnection() error = %v", err)
+					return
+				}
+				uid, err := m.nextUID(conn)
+				m.returnConnection(conn)
+				if err != nil {
+					t.Errorf("nextUID() error = %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[uid] {
+					t.Errorf("uidNumber %d issued twice", uid)
+				}
+				seen[uid] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 6*perWorker {
+		t.Errorf("issued %d distinct uidNumbers, want %d", len(seen), 6*perWorker)
+	}
+}
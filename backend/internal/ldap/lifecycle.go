@@ -0,0 +1,314 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Lifecycle attributes (devplatform schema)
+const (
+	accountStatusAttr  = "accountStatus"
+	accountExpiresAttr = "accountExpires"
+	deprovisionAtAttr  = "deprovisionAt"
+
+	// pwdAccountLockedTime of the ppolicy overlay; this value locks the
+	// account until an administrator removes it. The attribute is only
+	// defined where the overlay is loaded.
+	pwdLockedAttr      = "pwdAccountLockedTime"
+	pwdLockedPermanent = "000001010000Z"
+)
+
+// activeAccountFilter matches active accounts; entries without
+// accountStatus predate the lifecycle attributes and are active
+var activeAccountFilter = fmt.Sprintf("(|(%s=%s)(!(%s=*)))", accountStatusAttr, models.UserStatusActive, accountStatusAttr)
+
+// generalizedTimeLayout is the GeneralizedTime form we write (always UTC)
+const generalizedTimeLayout = "20060102150405Z"
+
+// DisableUser marks an account disabled and locks it with
+// pwdAccountLockedTime, so binds through this service and every other LDAP
+// client, such as Keycloak's federation, are refused. Locking needs the
+// ppolicy overlay; without it the user is left unchanged and
+// ErrPPolicyRequired is returned.
+func (m *Manager) DisableUser(ctx context.Context, uid string) (*models.User, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Replace(accountStatusAttr, []string{models.UserStatusDisabled})
+	m.lockRequest(modifyRequest, true)
+
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		return nil, fmt.Errorf("failed to disable user: %w", lockError(err))
+	}
+
+	m.logger.WithField("uid", uid).Info("User disabled")
+	return m.GetUser(ctx, uid)
+}
+
+// EnableUser reactivates a disabled or deprovisioned account and cancels a
+// pending deletion. The department and memberships removed by
+// deprovisioning are not restored.
+func (m *Manager) EnableUser(ctx context.Context, uid string) (*models.User, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Replace(accountStatusAttr, []string{models.UserStatusActive})
+	modifyRequest.Replace(deprovisionAtAttr, []string{})
+	m.lockRequest(modifyRequest, false)
+
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		return nil, fmt.Errorf("failed to enable user: %w", lockError(err))
+	}
+
+	m.logger.WithField("uid", uid).Info("User enabled")
+	return m.GetUser(ctx, uid)
+}
+
+// SetUserExpiry sets the time after which the account is disabled by the
+// lifecycle sweeper. A nil time removes the expiry.
+func (m *Manager) SetUserExpiry(ctx context.Context, uid string, expiresAt *time.Time) (*models.User, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	if expiresAt != nil {
+		modifyRequest.Replace(accountExpiresAttr, []string{formatGeneralizedTime(*expiresAt)})
+	} else {
+		modifyRequest.Replace(accountExpiresAttr, []string{})
+	}
+
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		return nil, fmt.Errorf("failed to set user expiry: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{"uid": uid, "expiresAt": expiresAt}).Info("User expiry set")
+	return m.GetUser(ctx, uid)
+}
+
+// DeprovisionUser strips a user of all access: the account is disabled, it
+// leaves its department, it is removed from every group and its repository
+// grants are cleared. The
+// entry itself is kept for DEPROVISION_GRACE_PERIOD so the step can be
+// undone, and deleted by the lifecycle sweeper afterwards.
+func (m *Manager) DeprovisionUser(ctx context.Context, uid string) (*models.DeprovisionResult, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	user, err := m.GetUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	userDN := m.config.UserDN(uid)
+
+	m.logger.WithField("uid", uid).Info("Deprovisioning user")
+
+	// Disable first, so the account is unusable even if a later step fails
+	deleteAfter := time.Now().UTC().Add(m.config.DeprovisionGracePeriod)
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Replace(accountStatusAttr, []string{models.UserStatusDeprovisioned})
+	modifyRequest.Replace(deprovisionAtAttr, []string{formatGeneralizedTime(deleteAfter)})
+	if user.Department != "" {
+		modifyRequest.Replace("departmentNumber", []string{})
+	}
+	m.lockRequest(modifyRequest, true)
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		return nil, fmt.Errorf("failed to disable user: %w", lockError(err))
+	}
+
+	result := &models.DeprovisionResult{
+		RemovedGroups:       []string{},
+		RemovedRepositories: user.Repositories,
+		RemovedDepartment:   user.Department,
+		DeleteAfter:         deleteAfter,
+	}
+	if result.RemovedRepositories == nil {
+		result.RemovedRepositories = []string{}
+	}
+
+	groups, err := m.groupsWithMember(conn, userDN)
+	if err != nil {
+		return nil, err
+	}
+	for _, cn := range groups {
+		if err := m.removeGroupMember(conn, cn, userDN); err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{"uid": uid, "group": cn}).Error("Failed to remove user from group")
+			return nil, fmt.Errorf("failed to remove user from group %s: %w", cn, err)
+		}
+		result.RemovedGroups = append(result.RemovedGroups, cn)
+	}
+
+	result.ReleasedDepartments, err = m.releaseDepartments(conn, uid)
+	if err != nil {
+		return nil, err
+	}
+	if result.ReleasedDepartments == nil {
+		result.ReleasedDepartments = []string{}
+	}
+
+	if len(user.Repositories) > 0 || len(user.Grants) > 0 {
+		repoModify := ldap.NewModifyRequest(userDN, nil)
+		repoModify.Replace("githubRepository", []string{})
+		repoModify.Replace(repositoryGrantAttr, []string{})
+		if err := conn.Modify(repoModify); err != nil {
+			return nil, fmt.Errorf("failed to clear repositories: %w", err)
+		}
+	}
+
+	result.User, err = m.GetUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":         uid,
+		"groups":      len(result.RemovedGroups),
+		"deleteAfter": deleteAfter,
+	}).Info("User deprovisioned")
+	return result, nil
+}
+
+// FindUsersDue returns the active users whose expiry has passed and the
+// deprovisioned users whose grace period is over
+func (m *Manager) FindUsersDue(ctx context.Context, now time.Time) (*models.LifecycleDue, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	cutoff := ldap.EscapeFilter(formatGeneralizedTime(now))
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(|(%s<=%s)(%s<=%s)))", accountExpiresAttr, cutoff, deprovisionAtAttr, cutoff),
+		[]string{"uid", accountStatusAttr, accountExpiresAttr, deprovisionAtAttr},
+		nil,
+	)
+
+	due := &models.LifecycleDue{Expired: []string{}, Purgeable: []string{}}
+	err = m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		user := m.entryToUser(entry)
+		switch {
+		case user.Status == models.UserStatusDeprovisioned:
+			if user.DeprovisionAt != nil && !user.DeprovisionAt.After(now) {
+				due.Purgeable = append(due.Purgeable, user.UID)
+			}
+		case user.Status == models.UserStatusActive:
+			if user.ExpiresAt != nil && !user.ExpiresAt.After(now) {
+				due.Expired = append(due.Expired, user.UID)
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	return due, nil
+}
+
+// checkAccountUsable refuses disabled, deprovisioned and expired accounts
+func checkAccountUsable(user *models.User, now time.Time) error {
+	if user.Status != models.UserStatusActive {
+		return models.ErrAccountDisabled
+	}
+	if user.ExpiresAt != nil && !user.ExpiresAt.After(now) {
+		return models.ErrAccountExpired
+	}
+	return nil
+}
+
+// lockRequest adds or clears the ppolicy lock
+func (m *Manager) lockRequest(modifyRequest *ldap.ModifyRequest, locked bool) {
+	if locked {
+		modifyRequest.Replace(pwdLockedAttr, []string{pwdLockedPermanent})
+	} else {
+		modifyRequest.Replace(pwdLockedAttr, []string{})
+	}
+}
+
+// lockError maps the server refusing pwdAccountLockedTime, because the
+// ppolicy overlay is not loaded, to ErrPPolicyRequired
+func lockError(err error) error {
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultUndefinedAttributeType) {
+		return fmt.Errorf("%w: %v", models.ErrPPolicyRequired, err)
+	}
+	return err
+}
+
+// modifyUser applies a modification to a user entry, mapping a missing
+// entry to ErrUserNotFound
+func (m *Manager) modifyUser(conn *ldap.Conn, uid string, modifyRequest *ldap.ModifyRequest) error {
+	if err := conn.Modify(modifyRequest); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+		}
+		m.logger.WithError(err).WithField("uid", uid).Error("Failed to modify user")
+		return err
+	}
+	return nil
+}
+
+// groupsWithMember returns the CNs of all groups listing the given DN
+func (m *Manager) groupsWithMember(conn *ldap.Conn, memberDN string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.GroupsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(memberDN)),
+		[]string{"cn"},
+		nil,
+	)
+
+	var groups []string
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		groups = append(groups, entry.GetAttributeValue("cn"))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search groups: %w", err)
+	}
+	return groups, nil
+}
+
+func formatGeneralizedTime(t time.Time) string {
+	return t.UTC().Format(generalizedTimeLayout)
+}
+
+// parseGeneralizedTime parses the GeneralizedTime values we store, returning
+// nil for empty or malformed values
+func parseGeneralizedTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	// Drop fractional seconds some servers add ("20240101000000.000Z")
+	if i := strings.IndexByte(value, '.'); i >= 0 && strings.HasSuffix(value, "Z") {
+		value = value[:i] + "Z"
+	}
+	t, err := time.Parse(generalizedTimeLayout, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -2,76 +2,150 @@ package ldap
 
 import (
 	"context"
-	"net"
 	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/devplatform/ldap-manager/internal/config"
 	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/pkg/ldaptls"
 	ldap "github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
 )
 
 // Manager handles LDAP connections and operations
 type Manager struct {
-	config         *config.Config
-	pool           chan *ldap.Conn
-	poolSize       int
-	mu             sync.RWMutex
-	closed         bool
-	logger         *logrus.Logger
-	uidCounter     int32
-	gidCounter     int32
-	totalRequests  int64
-	createdAt      time.Time
-}
-
-// NewManager creates a new LDAP manager with connection pool
+	config        *config.Config
+	tls           *ldaptls.Source
+	writers       []*backend // providers, in failover order
+	readers       []*backend // consumers; reads go to the provider when empty
+	nextReader    atomic.Uint32
+	mu            sync.RWMutex
+	closed        bool
+	logger        *logrus.Logger
+	totalRequests int64
+	createdAt     time.Time
+	// sortUnsupported is set once the server refuses the server-side sort
+	// control, so later searches stop sending it
+	sortUnsupported atomic.Bool
+
+	// Reads go to the provider while a write is in progress and for
+	// LDAP_READ_AFTER_WRITE_WINDOW afterwards, so callers (including the
+	// manager itself, which re-reads entries after changing them) see their
+	// own writes despite replication lag
+	writesInFlight atomic.Int64
+	lastWrite      atomic.Int64 // UnixNano
+
+	stopReaper chan struct{}
+	statsHook  func(*models.Stats)
+}
+
+// NewManager creates a new LDAP manager with a connection pool per
+// backend. Each pool starts with LDAP_POOL_MIN_SIZE connections and grows
+// on demand up to LDAP_POOL_SIZE.
 func NewManager(cfg *config.Config, logger *logrus.Logger) (*Manager, error) {
+	if len(cfg.LDAPURLs) == 0 {
+		return nil, fmt.Errorf("no LDAP URL configured")
+	}
+
+	tlsSource, err := ldaptls.NewSource(ldaptls.Options{
+		Mode:               cfg.LDAPTLSMode,
+		CAFile:             cfg.LDAPTLSCAFile,
+		CertFile:           cfg.LDAPTLSCertFile,
+		KeyFile:            cfg.LDAPTLSKeyFile,
+		ServerName:         cfg.LDAPTLSServerName,
+		InsecureSkipVerify: cfg.LDAPTLSInsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP TLS configuration: %w", err)
+	}
+	if cfg.LDAPSASLExternal && !tlsSource.HasClientCertificate() {
+		return nil, fmt.Errorf("LDAP_SASL_EXTERNAL needs LDAP_TLS_CERT_FILE and LDAP_TLS_KEY_FILE")
+	}
+	if !cfg.LDAPSASLExternal && cfg.LDAPBindPassword == "" {
+		return nil, fmt.Errorf("LDAP_BIND_PASSWORD is required unless LDAP_SASL_EXTERNAL is set")
+	}
+
 	m := &Manager{
 		config:     cfg,
-		pool:       make(chan *ldap.Conn, cfg.LDAPPoolSize),
-		poolSize:   cfg.LDAPPoolSize,
+		tls:        tlsSource,
 		logger:     logger,
-		uidCounter: int32(cfg.StartingUID),
-		gidCounter: int32(cfg.StartingGID),
 		createdAt:  time.Now(),
+		stopReaper: make(chan struct{}),
+	}
+	for _, url := range cfg.LDAPURLs {
+		m.writers = append(m.writers, newBackend(m, url, BackendProvider))
+	}
+	for _, url := range cfg.LDAPReadURLs {
+		m.readers = append(m.readers, newBackend(m, url, BackendConsumer))
 	}
 
-	// Pre-populate the connection pool
-	for i := 0; i < cfg.LDAPPoolSize; i++ {
-		conn, err := m.createConnection()
-		if err != nil {
-			m.logger.WithError(err).Error("Failed to create initial connection")
-			// Close existing connections
-			close(m.pool)
-			for c := range m.pool {
-				c.Close()
+	// Pre-populate the connection pools. Only the preferred provider has to
+	// be reachable; the others are filled by the reaper once they are up.
+	for i, b := range m.backends() {
+		if err := b.fill(); err != nil {
+			if i == 0 {
+				m.logger.WithError(err).Error("Failed to create initial connection")
+				b.close()
+				return nil, fmt.Errorf("failed to initialize connection pool: %w", err)
 			}
-			return nil, fmt.Errorf("failed to initialize connection pool: %w", err)
+			m.logger.WithError(err).WithField("backend", b.url).Warn("LDAP backend unavailable at startup")
 		}
-		m.pool <- conn
 	}
 
-	m.logger.WithField("pool_size", cfg.LDAPPoolSize).Info("LDAP connection pool initialized")
+	if cfg.LDAPPoolReapInterval > 0 {
+		go m.reapLoop(cfg.LDAPPoolReapInterval)
+	}
+	if cfg.LDAPTLSReloadInterval > 0 && tlsSource.Mode() != ldaptls.ModeNone {
+		go m.tlsReloadLoop(cfg.LDAPTLSReloadInterval)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"providers":    cfg.LDAPURLs,
+		"consumers":    cfg.LDAPReadURLs,
+		"tls_mode":     tlsSource.Mode(),
+		"min_size":     m.writers[0].minSize,
+		"max_size":     m.writers[0].maxSize,
+		"max_lifetime": cfg.LDAPMaxConnLifetime,
+		"max_idle":     cfg.LDAPConnMaxIdle,
+	}).Info("LDAP connection pool initialized")
 	return m, nil
 }
 
-// createConnection creates a new LDAP connection
-func (m *Manager) createConnection() (*ldap.Conn, error) {
-	m.logger.WithField("url", m.config.LDAPURL).Debug("Creating new LDAP connection")
+// backends returns every backend, providers first
+func (m *Manager) backends() []*backend {
+	return append(append([]*backend{}, m.writers...), m.readers...)
+}
 
-	conn, err := ldap.DialURL(m.config.LDAPURL, ldap.DialWithDialer(&net.Dialer{
+// dial opens an unauthenticated connection to url, secured according to
+// LDAP_TLS_MODE
+func (m *Manager) dial(url string) (*ldap.Conn, error) {
+	conn, err := m.tls.Dial(url, ldap.DialWithDialer(&net.Dialer{
 		Timeout: m.config.LDAPConnTimeout,
 	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial LDAP: %w", err)
 	}
+	return conn, nil
+}
 
-	// Bind with admin credentials
-	err = conn.Bind(m.config.LDAPBindDN, m.config.LDAPBindPassword)
+// createConnection creates a new LDAP connection to url
+func (m *Manager) createConnection(url string) (*ldap.Conn, error) {
+	m.logger.WithField("url", url).Debug("Creating new LDAP connection")
+
+	conn, err := m.dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bind with admin credentials, or as the client certificate's identity
+	if m.config.LDAPSASLExternal {
+		err = conn.ExternalBind()
+	} else {
+		err = conn.Bind(m.config.LDAPBindDN, m.config.LDAPBindPassword)
+	}
 	if err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to bind: %w", err)
@@ -80,8 +154,49 @@ func (m *Manager) createConnection() (*ldap.Conn, error) {
 	return conn, nil
 }
 
-// getConnection retrieves a connection from the pool
+// getConnection retrieves a connection to the provider for a write, or for
+// a read-modify-write that must see the latest state
 func (m *Manager) getConnection(ctx context.Context) (*ldap.Conn, error) {
+	// A write may change the hierarchy a request has cached
+	snapshotFrom(ctx).reset()
+	return m.acquire(ctx, m.writers, true)
+}
+
+// getProviderConnection retrieves a connection to the provider for a read
+// that must not depend on replication, without holding back later reads
+func (m *Manager) getProviderConnection(ctx context.Context) (*ldap.Conn, error) {
+	return m.acquire(ctx, m.writers, false)
+}
+
+// getReadConnection retrieves a connection for a read. Consumers take
+// turns; the provider is used when there are none, when none is available,
+// or while the caller's own writes may not have replicated yet.
+func (m *Manager) getReadConnection(ctx context.Context) (*ldap.Conn, error) {
+	if len(m.readers) == 0 || m.recentlyWritten() {
+		return m.acquire(ctx, m.writers, false)
+	}
+
+	start := int(m.nextReader.Add(1))
+	ordered := make([]*backend, 0, len(m.readers)+len(m.writers))
+	for i := range m.readers {
+		ordered = append(ordered, m.readers[(start+i)%len(m.readers)])
+	}
+	ordered = append(ordered, m.writers...)
+	return m.acquire(ctx, ordered, false)
+}
+
+// recentlyWritten reports whether reads should stay on the provider
+func (m *Manager) recentlyWritten() bool {
+	if m.writesInFlight.Load() > 0 {
+		return true
+	}
+	return time.Since(time.Unix(0, m.lastWrite.Load())) < m.config.LDAPReadAfterWriteWindow
+}
+
+// acquire checks a connection out of the first available backend, failing
+// over to the next one when a backend cannot be reached. A pool that is
+// merely exhausted is not a reason to fail over.
+func (m *Manager) acquire(ctx context.Context, backends []*backend, write bool) (*ldap.Conn, error) {
 	atomic.AddInt64(&m.totalRequests, 1)
 
 	m.mu.RLock()
@@ -91,48 +206,130 @@ func (m *Manager) getConnection(ctx context.Context) (*ldap.Conn, error) {
 	}
 	m.mu.RUnlock()
 
-	select {
-	case conn := <-m.pool:
-		// Test the connection
-		if !m.testConnection(conn) {
-			m.logger.Debug("Connection test failed, creating new connection")
-			conn.Close()
-			newConn, err := m.createConnection()
-			if err != nil {
-				return nil, fmt.Errorf("failed to create new connection: %w", err)
+	var lastErr error
+	for _, b := range backends {
+		if !b.available(time.Now()) {
+			continue
+		}
+
+		pc, err := b.get(ctx)
+		if err != nil {
+			if !isBackendDown(err) {
+				return nil, err
 			}
-			return newConn, nil
+			lastErr = err
+			m.logger.WithError(err).WithFields(logrus.Fields{"backend": b.url, "role": b.role}).Warn("LDAP backend unavailable, failing over")
+			continue
 		}
-		return conn, nil
-	case <-time.After(m.config.LDAPPoolTimeout):
-		return nil, fmt.Errorf("timeout waiting for connection from pool")
-	case <-ctx.Done():
-		return nil, ctx.Err()
+
+		pc.write = write
+		if write {
+			m.writesInFlight.Add(1)
+		}
+		atomic.AddInt64(&b.requests, 1)
+		m.logger.WithFields(logrus.Fields{"backend": b.url, "role": b.role, "write": write}).Debug("LDAP connection checked out")
+		return pc.conn, nil
 	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("every backend is marked down")
+	}
+	return nil, fmt.Errorf("no LDAP backend available: %w", lastErr)
 }
 
-// returnConnection returns a connection to the pool
+// returnConnection returns a connection to its pool. Broken connections
+// and connections past LDAP_MAX_CONN_LIFETIME are closed instead.
 func (m *Manager) returnConnection(conn *ldap.Conn) {
 	if conn == nil {
 		return
 	}
 
+	var b *backend
+	var pc *pooledConn
+	for _, candidate := range m.backends() {
+		if pc = candidate.lookup(conn); pc != nil {
+			b = candidate
+			break
+		}
+	}
+	if pc == nil {
+		// Not a pooled connection
+		conn.Close()
+		return
+	}
+
+	if pc.write {
+		pc.write = false
+		m.lastWrite.Store(time.Now().UnixNano())
+		m.writesInFlight.Add(-1)
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if m.closed {
-		conn.Close()
+		b.discard(pc, nil)
+		return
+	}
+	if conn.IsClosing() {
+		b.recordFailure(fmt.Errorf("connection closed"))
+		b.discard(pc, nil)
+		return
+	}
+	if b.expired(pc, time.Now()) {
+		m.logger.WithField("age", time.Since(pc.createdAt).Round(time.Second)).Debug("Recycling connection past its lifetime")
+		b.discard(pc, &b.counters.recycled)
 		return
 	}
 
-	// Non-blocking send
-	select {
-	case m.pool <- conn:
-	default:
-		// Pool is full, close the connection
-		m.logger.Warn("Connection pool full, closing connection")
-		conn.Close()
+	pc.lastUsed = time.Now()
+	b.putIdle(pc)
+}
+
+// dedicatedConnection opens an unpooled admin connection to the first
+// available provider, for long-running operations such as the watcher
+func (m *Manager) dedicatedConnection() (*ldap.Conn, error) {
+	var lastErr error
+	for _, b := range m.writers {
+		if !b.available(time.Now()) {
+			continue
+		}
+		conn, err := m.createConnection(b.url)
+		if err != nil {
+			b.recordFailure(err)
+			lastErr = err
+			continue
+		}
+		b.recordSuccess()
+		return conn, nil
 	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("every provider is marked down")
+	}
+	return nil, fmt.Errorf("no LDAP provider available: %w", lastErr)
+}
+
+// dialProvider opens an unauthenticated connection to the first available
+// provider, for binds with user credentials. Binds go to the provider so
+// password policy state is updated where it is writable.
+func (m *Manager) dialProvider() (*ldap.Conn, error) {
+	var lastErr error
+	for _, b := range m.writers {
+		if !b.available(time.Now()) {
+			continue
+		}
+		conn, err := m.dial(b.url)
+		if err != nil {
+			b.recordFailure(err)
+			lastErr = err
+			continue
+		}
+		return conn, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("every provider is marked down")
+	}
+	return nil, fmt.Errorf("no LDAP provider available: %w", lastErr)
 }
 
 // testConnection tests if a connection is still alive
@@ -160,7 +357,7 @@ func (m *Manager) testConnection(conn *ldap.Conn) bool {
 
 // HealthCheck performs a health check on the LDAP connection
 func (m *Manager) HealthCheck(ctx context.Context) error {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getProviderConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
@@ -173,20 +370,30 @@ func (m *Manager) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-// GetStats returns connection pool statistics
+// GetStats returns connection pool statistics, summed over all backends
+// and broken down per backend
 func (m *Manager) GetStats() *models.Stats {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	available := len(m.pool)
-	inUse := m.poolSize - available
-
-	return &models.Stats{
-		PoolSize:      m.poolSize,
-		Available:     available,
-		InUse:         inUse,
+	stats := &models.Stats{
+		MinSize:       m.writers[0].minSize,
+		MaxSize:       m.writers[0].maxSize,
 		TotalRequests: int(atomic.LoadInt64(&m.totalRequests)),
 	}
+
+	for _, b := range m.backends() {
+		bs := b.stats()
+		stats.Backends = append(stats.Backends, bs)
+		stats.PoolSize += bs.PoolSize
+		stats.Available += bs.Available
+		stats.InUse += bs.InUse
+		stats.Waits += int(atomic.LoadInt64(&b.counters.waits))
+		stats.WaitSeconds += time.Duration(atomic.LoadInt64(&b.counters.waitNanos)).Seconds()
+		stats.Timeouts += int(atomic.LoadInt64(&b.counters.timeouts))
+		stats.ConnectionsCreated += int(atomic.LoadInt64(&b.counters.created))
+		stats.ConnectionsRecycled += int(atomic.LoadInt64(&b.counters.recycled))
+		stats.ConnectionsReaped += int(atomic.LoadInt64(&b.counters.reaped))
+	}
+
+	return stats
 }
 
 // Close closes all connections in the pool
@@ -199,25 +406,14 @@ func (m *Manager) Close() error {
 	}
 
 	m.closed = true
-	close(m.pool)
+	close(m.stopReaper)
 
-	// Close all connections
+	// Close idle connections; checked-out ones are closed when returned
 	count := 0
-	for conn := range m.pool {
-		conn.Close()
-		count++
+	for _, b := range m.backends() {
+		count += b.close()
 	}
 
 	m.logger.WithField("connections_closed", count).Info("LDAP connection pool closed")
 	return nil
 }
-
-// nextUID returns the next available UID number
-func (m *Manager) nextUID() int {
-	return int(atomic.AddInt32(&m.uidCounter, 1))
-}
-
-// nextGID returns the next available GID number
-func (m *Manager) nextGID() int {
-	return int(atomic.AddInt32(&m.gidCounter, 1))
-}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/devplatform/ldap-manager/internal/models"
 	ldap "github.com/go-ldap/ldap/v3"
@@ -12,14 +13,27 @@ import (
 
 // CreateUser creates a new user in LDAP
 func (m *Manager) CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	if err := m.checkPasswordPolicy(input.UID, input.Password); err != nil {
+		return nil, err
+	}
+
 	conn, err := m.getConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	uidNumber := m.nextUID()
-	gidNumber := m.nextGID()
+	uidNumber, err := m.nextUID(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate uidNumber: %w", err)
+	}
+	gidNumber, err := m.nextGID(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate gidNumber: %w", err)
+	}
 	userDN := m.config.UserDN(input.UID)
 
 	m.logger.WithFields(logrus.Fields{
@@ -39,10 +53,12 @@ func (m *Manager) CreateUser(ctx context.Context, input *models.CreateUserInput)
 	addRequest.Attribute("uidNumber", []string{fmt.Sprintf("%d", uidNumber)})
 	addRequest.Attribute("gidNumber", []string{fmt.Sprintf("%d", gidNumber)})
 	addRequest.Attribute("homeDirectory", []string{fmt.Sprintf("/home/%s", input.UID)})
-	addRequest.Attribute("userPassword", []string{input.Password})
 
-	if len(input.Repositories) > 0 {
-		addRequest.Attribute("githubRepository", input.Repositories)
+	// A new user is in no group yet, so githubRepository lists just the
+	// grants
+	if grants := models.ResolveGrants(append(models.RepositoryGrants(input.Repositories), input.Grants...), nil); len(grants) > 0 {
+		addRequest.Attribute(repositoryGrantAttr, models.GrantValues(grants))
+		addRequest.Attribute("githubRepository", models.GrantedRepositories(grants, time.Now()))
 	}
 
 	if err := conn.Add(addRequest); err != nil {
@@ -50,13 +66,28 @@ func (m *Manager) CreateUser(ctx context.Context, input *models.CreateUserInput)
 		return nil, fmt.Errorf("failed to add user: %w", err)
 	}
 
+	// The password is set separately so the server hashes it
+	if err := m.setPassword(conn, input.UID, input.Password, false); err != nil {
+		m.logger.WithError(err).Error("Failed to set password, removing user")
+		if delErr := conn.Del(ldap.NewDelRequest(userDN, nil)); delErr != nil {
+			m.logger.WithError(delErr).WithField("uid", input.UID).Error("Failed to remove user without password")
+		}
+		return nil, err
+	}
+
 	m.logger.WithField("uid", input.UID).Info("User created successfully")
 	return m.GetUser(ctx, input.UID)
 }
 
+// userAttributes are the attributes read for every user entry
+var userAttributes = []string{
+	"uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber", "homeDirectory", "githubRepository",
+	repositoryGrantAttr, accountStatusAttr, accountExpiresAttr, deprovisionAtAttr,
+}
+
 // GetUser retrieves a user by UID
 func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -70,7 +101,7 @@ func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error)
 		0,
 		false,
 		fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)),
-		[]string{"uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber", "homeDirectory", "githubRepository"},
+		m.userSearchAttributes(),
 		nil,
 	)
 
@@ -80,7 +111,7 @@ func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error)
 	}
 
 	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("user not found: %s", uid)
+		return nil, fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
 	}
 
 	return m.entryToUser(result.Entries[0]), nil
@@ -88,12 +119,57 @@ func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error)
 
 // ListUsers lists users with optional filtering
 func (m *Manager) ListUsers(ctx context.Context, filter *models.SearchFilter) ([]*models.User, error) {
-	conn, err := m.getConnection(ctx)
+	page, err := m.ListUsersPage(ctx, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	return page.Users, nil
+}
+
+// ListUsersPage returns one page of users ordered by uid
+func (m *Manager) ListUsersPage(ctx context.Context, filter *models.SearchFilter, page *models.PageRequest) (*models.UserPage, error) {
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
+	searchFilter, err := m.userSearchFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		searchFilter,
+		m.userSearchAttributes(),
+		nil,
+	)
+
+	window := newPageWindow(page)
+	err = m.pagedSearch(conn, searchRequest, "uid", func(entry *ldap.Entry) {
+		window.add(entry.GetAttributeValue("uid"), entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	entries, info := window.result()
+	users := make([]*models.User, 0, len(entries))
+	for _, entry := range entries {
+		users = append(users, m.entryToUser(entry))
+	}
+
+	return &models.UserPage{Users: users, PageInfo: info}, nil
+}
+
+// userSearchFilter builds the LDAP filter for a user search
+func (m *Manager) userSearchFilter(filter *models.SearchFilter) (string, error) {
 	filterStr := "(objectClass=inetOrgPerson)"
 	if filter != nil {
 		filters := []string{"(objectClass=inetOrgPerson)"}
@@ -124,34 +200,29 @@ func (m *Manager) ListUsers(ctx context.Context, filter *models.SearchFilter) ([
 		if filter.Repository != "" {
 			filters = append(filters, fmt.Sprintf("(githubRepository=*%s*)", ldap.EscapeFilter(filter.Repository)))
 		}
+		if f := anyOfFilter("departmentNumber", filter.Departments); f != "" {
+			filters = append(filters, f)
+		}
+		if f := anyOfFilter("githubRepository", filter.Repositories); f != "" {
+			filters = append(filters, f)
+		}
+		custom, err := m.customAttributeFilters(filter.Attributes)
+		if err != nil {
+			return "", err
+		}
+		filters = append(filters, custom...)
+		expression, err := m.compileUserExpression(filter.Expression)
+		if err != nil {
+			return "", err
+		}
+		if expression != "" {
+			filters = append(filters, expression)
+		}
 		if len(filters) > 1 {
 			filterStr = fmt.Sprintf("(&%s)", strings.Join(filters, ""))
 		}
 	}
-
-	searchRequest := ldap.NewSearchRequest(
-		m.config.UsersDN(),
-		ldap.ScopeSingleLevel,
-		ldap.NeverDerefAliases,
-		0,
-		0,
-		false,
-		filterStr,
-		[]string{"uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber", "homeDirectory", "githubRepository"},
-		nil,
-	)
-
-	result, err := conn.Search(searchRequest)
-	if err != nil {
-		return nil, fmt.Errorf("search failed: %w", err)
-	}
-
-	users := make([]*models.User, 0, len(result.Entries))
-	for _, entry := range result.Entries {
-		users = append(users, m.entryToUser(entry))
-	}
-
-	return users, nil
+	return filterStr, nil
 }
 
 // UpdateUser updates user attributes
@@ -162,10 +233,57 @@ func (m *Manager) UpdateUser(ctx context.Context, input *models.UpdateUserInput)
 	}
 	defer m.returnConnection(conn)
 
-	userDN := m.config.UserDN(input.UID)
+	if input.Password != nil {
+		if err := m.checkPasswordPolicy(input.UID, *input.Password); err != nil {
+			return nil, err
+		}
+	}
 
 	m.logger.WithField("uid", input.UID).Info("Updating user")
 
+	// Grants are swapped like updateGrants does; a swap that loses to a
+	// concurrent editor is rebuilt from a fresh read
+	grantsChanged := input.Repositories != nil || input.Grants != nil
+	for attempt := 1; ; attempt++ {
+		modifyRequest, err := m.userModifyRequest(conn, input, grantsChanged)
+		if err != nil {
+			return nil, err
+		}
+		if len(modifyRequest.Changes) == 0 {
+			break
+		}
+		err = conn.Modify(modifyRequest)
+		if err == nil {
+			break
+		}
+		if grantsChanged && isGrantConflict(err) && attempt < maxGrantUpdateRetries {
+			continue
+		}
+		m.logger.WithError(err).Error("Failed to update user")
+		return nil, fmt.Errorf("failed to modify user: %w", err)
+	}
+
+	// githubRepository lists the new grants plus what the groups grant
+	if grantsChanged {
+		if err := m.syncUserReposFromGroups(conn, input.UID); err != nil {
+			return nil, err
+		}
+	}
+
+	if input.Password != nil {
+		if err := m.setPassword(conn, input.UID, *input.Password, false); err != nil {
+			return nil, err
+		}
+	}
+
+	m.logger.WithField("uid", input.UID).Info("User updated successfully")
+	return m.GetUser(ctx, input.UID)
+}
+
+// userModifyRequest builds the modify applying an UpdateUserInput, reading
+// the user's current grants when they change
+func (m *Manager) userModifyRequest(conn *ldap.Conn, input *models.UpdateUserInput, grantsChanged bool) (*ldap.ModifyRequest, error) {
+	userDN := m.config.UserDN(input.UID)
 	modifyRequest := ldap.NewModifyRequest(userDN, nil)
 
 	if input.CN != nil {
@@ -183,24 +301,66 @@ func (m *Manager) UpdateUser(ctx context.Context, input *models.UpdateUserInput)
 	if input.Department != nil {
 		modifyRequest.Replace("departmentNumber", []string{*input.Department})
 	}
-	if input.Password != nil {
-		modifyRequest.Replace("userPassword", []string{*input.Password})
-	}
-	if input.Repositories != nil {
-		if len(input.Repositories) > 0 {
-			modifyRequest.Replace("githubRepository", input.Repositories)
-		} else {
-			modifyRequest.Delete("githubRepository", nil)
+	if grantsChanged {
+		entry, err := m.grantEntry(conn, userDN, fmt.Errorf("%w: %s", models.ErrUserNotFound, input.UID))
+		if err != nil {
+			return nil, err
 		}
+		grants, err := resolveGrantInput(input.Repositories, input.Grants, m.entryGrants(entry, false))
+		if err != nil {
+			return nil, err
+		}
+		swapGrants(modifyRequest, entry, grants, false)
+	}
+	if err := m.replaceCustomAttributes(modifyRequest, input.Attributes); err != nil {
+		return nil, err
 	}
+	return modifyRequest, nil
+}
 
-	if err := conn.Modify(modifyRequest); err != nil {
-		m.logger.WithError(err).Error("Failed to update user")
-		return nil, fmt.Errorf("failed to modify user: %w", err)
+// RestoreUser writes back the profile, repositories, grants and custom attributes
+// of a user read earlier, exactly: attributes that were empty then are
+// removed. Passwords, group memberships and lifecycle state are left alone.
+func (m *Manager) RestoreUser(ctx context.Context, snapshot *models.User) (*models.User, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
+	defer m.returnConnection(conn)
 
-	m.logger.WithField("uid", input.UID).Info("User updated successfully")
-	return m.GetUser(ctx, input.UID)
+	m.logger.WithField("uid", snapshot.UID).Info("Restoring user")
+
+	// Replacing with no values removes the attribute, and unlike a delete
+	// does not fail when it is absent
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(snapshot.UID), nil)
+	modifyRequest.Replace("cn", nonEmpty(snapshot.CN))
+	modifyRequest.Replace("sn", nonEmpty(snapshot.SN))
+	modifyRequest.Replace("givenName", nonEmpty(snapshot.GivenName))
+	modifyRequest.Replace("mail", nonEmpty(snapshot.Mail))
+	modifyRequest.Replace("departmentNumber", nonEmpty(snapshot.Department))
+	modifyRequest.Replace("githubRepository", nonEmpty(snapshot.Repositories...))
+	modifyRequest.Replace(repositoryGrantAttr, models.GrantValues(snapshot.Grants))
+	for _, def := range m.config.UserAttributes {
+		modifyRequest.Replace(def.LDAPAttribute, nonEmpty(snapshot.Attributes[def.Field]...))
+	}
+
+	if err := m.modifyUser(conn, snapshot.UID, modifyRequest); err != nil {
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	m.logger.WithField("uid", snapshot.UID).Info("User restored")
+	return m.GetUser(ctx, snapshot.UID)
+}
+
+// nonEmpty returns the values that are not empty strings
+func nonEmpty(values ...string) []string {
+	kept := []string{}
+	for _, value := range values {
+		if value != "" {
+			kept = append(kept, value)
+		}
+	}
+	return kept
 }
 
 // DeleteUser deletes a user from LDAP
@@ -221,6 +381,11 @@ func (m *Manager) DeleteUser(ctx context.Context, uid string) error {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
+	// Departments must not keep pointing at a user who is gone
+	if _, err := m.releaseDepartments(conn, uid); err != nil {
+		m.logger.WithError(err).WithField("uid", uid).Warn("Failed to release departments of deleted user")
+	}
+
 	m.logger.WithField("uid", uid).Info("User deleted successfully")
 	return nil
 }
@@ -234,7 +399,7 @@ func (m *Manager) Authenticate(ctx context.Context, uid, password string) (*mode
 	}
 
 	// Create a new connection for authentication (don't use pool)
-	conn, err := ldap.DialURL(m.config.LDAPURL)
+	conn, err := m.dialProvider()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
@@ -246,13 +411,43 @@ func (m *Manager) Authenticate(ctx context.Context, uid, password string) (*mode
 		m.logger.WithFields(logrus.Fields{
 			"uid": uid,
 		}).Warn("Authentication failed")
-		return nil, fmt.Errorf("authentication failed")
+		return nil, fmt.Errorf("authentication failed: %w", models.ErrInvalidCredentials)
+	}
+
+	// Checked only after the bind, so the account state is not revealed to
+	// callers who do not know the password
+	if err := checkAccountUsable(user, time.Now()); err != nil {
+		m.logger.WithField("uid", uid).WithError(err).Warn("Authentication refused")
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	// The password is correct; refuse it if it is temporary or too old.
+	// Ageing attributes are read with the service account, since users may
+	// not be allowed to read them on their own entry.
+	adminConn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	state, err := m.readPasswordState(adminConn, uid)
+	m.returnConnection(adminConn)
+	if err != nil {
+		return nil, err
+	}
+	if err := state.checkPasswordAge(time.Now()); err != nil {
+		m.logger.WithField("uid", uid).WithError(err).Warn("Authentication refused")
+		return nil, fmt.Errorf("authentication failed: %w", err)
 	}
 
 	m.logger.WithField("uid", uid).Info("User authenticated successfully")
 	return user, nil
 }
 
+// departmentAttributes are the attributes read for every department entry
+var departmentAttributes = []string{"ou", "description", "manager", departmentDeputyAttr, parentDepartmentAttr, "githubRepository", repositoryGrantAttr}
+
+// groupAttributes are the attributes read for every group entry
+var groupAttributes = []string{"cn", "gidNumber", "description", "member", "githubRepository", repositoryGrantAttr}
+
 // CreateDepartment creates a new department
 func (m *Manager) CreateDepartment(ctx context.Context, input *models.CreateDepartmentInput) (*models.Department, error) {
 	conn, err := m.getConnection(ctx)
@@ -273,11 +468,37 @@ func (m *Manager) CreateDepartment(ctx context.Context, input *models.CreateDepa
 		addRequest.Attribute("description", []string{input.Description})
 	}
 	if input.Manager != "" {
+		if err := m.checkDepartmentManager(conn, input.Manager); err != nil {
+			return nil, err
+		}
 		managerDN := m.config.UserDN(input.Manager)
 		addRequest.Attribute("manager", []string{managerDN})
 	}
-	if len(input.Repositories) > 0 {
-		addRequest.Attribute("githubRepository", input.Repositories)
+	if len(input.Deputies) > 0 {
+		deputyDNs := make([]string, 0, len(input.Deputies))
+		for _, uid := range input.Deputies {
+			if err := m.checkDepartmentManager(conn, uid); err != nil {
+				return nil, err
+			}
+			deputyDNs = append(deputyDNs, m.config.UserDN(uid))
+		}
+		addRequest.Attribute(departmentDeputyAttr, deputyDNs)
+	}
+	if input.Parent != "" {
+		// A new department has no children, so any existing parent is safe
+		parentDN, err := m.departmentExists(conn, input.Parent)
+		if err != nil {
+			return nil, err
+		}
+		addRequest.Attribute(parentDepartmentAttr, []string{parentDN})
+	}
+	grants, err := resolveGrantInput(input.Repositories, input.Grants, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(grants) > 0 {
+		addRequest.Attribute(repositoryGrantAttr, models.GrantValues(grants))
+		addRequest.Attribute("githubRepository", models.GrantedRepositories(grants, time.Now()))
 	}
 
 	if err := conn.Add(addRequest); err != nil {
@@ -291,7 +512,7 @@ func (m *Manager) CreateDepartment(ctx context.Context, input *models.CreateDepa
 
 // GetDepartment retrieves a department by OU
 func (m *Manager) GetDepartment(ctx context.Context, ou string) (*models.Department, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -305,7 +526,7 @@ func (m *Manager) GetDepartment(ctx context.Context, ou string) (*models.Departm
 		0,
 		false,
 		fmt.Sprintf("(ou=%s)", ldap.EscapeFilter(ou)),
-		[]string{"ou", "description", "manager", "githubRepository"},
+		departmentAttributes,
 		nil,
 	)
 
@@ -315,13 +536,24 @@ func (m *Manager) GetDepartment(ctx context.Context, ou string) (*models.Departm
 	}
 
 	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("department not found: %s", ou)
+		return nil, fmt.Errorf("%w: %s", models.ErrDepartmentNotFound, ou)
 	}
 
 	dept := m.entryToDepartment(result.Entries[0])
 
 	// Get members using the same connection (avoid pool exhaustion)
-	memberFilter := fmt.Sprintf("(&(objectClass=inetOrgPerson)(departmentNumber=%s))", ldap.EscapeFilter(ou))
+	if members, err := m.departmentMembers(conn, ou); err != nil {
+		m.logger.WithError(err).Warn("Failed to get department members")
+	} else {
+		dept.Members = members
+	}
+
+	return dept, nil
+}
+
+// departmentMembers returns the UIDs of the active users in a department
+func (m *Manager) departmentMembers(conn *ldap.Conn, ou string) ([]string, error) {
+	memberFilter := fmt.Sprintf("(&(objectClass=inetOrgPerson)(departmentNumber=%s)%s)", ldap.EscapeFilter(ou), activeAccountFilter)
 	memberSearch := ldap.NewSearchRequest(
 		m.config.UsersDN(),
 		ldap.ScopeSingleLevel,
@@ -333,28 +565,48 @@ func (m *Manager) GetDepartment(ctx context.Context, ou string) (*models.Departm
 		[]string{"uid"},
 		nil,
 	)
-	memberResult, err := conn.Search(memberSearch)
+	memberUIDs := make([]string, 0)
+	err := m.pagedSearch(conn, memberSearch, "", func(mEntry *ldap.Entry) {
+		memberUIDs = append(memberUIDs, mEntry.GetAttributeValue("uid"))
+	})
 	if err != nil {
-		m.logger.WithError(err).Warn("Failed to get department members")
-	} else {
-		memberUIDs := make([]string, 0, len(memberResult.Entries))
-		for _, mEntry := range memberResult.Entries {
-			memberUIDs = append(memberUIDs, mEntry.GetAttributeValue("uid"))
-		}
-		dept.Members = memberUIDs
+		return nil, err
 	}
-
-	return dept, nil
+	return memberUIDs, nil
 }
 
 // ListDepartments lists all departments
 func (m *Manager) ListDepartments(ctx context.Context) ([]*models.Department, error) {
-	conn, err := m.getConnection(ctx)
+	page, err := m.ListDepartmentsPage(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return page.Departments, nil
+}
+
+// ListDepartmentsPage returns one page of departments ordered by ou.
+// Members are only looked up for the departments on the page.
+func (m *Manager) ListDepartmentsPage(ctx context.Context, filter *models.DepartmentFilter, page *models.PageRequest) (*models.DepartmentPage, error) {
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
+	filters := []string{"(objectClass=organizationalUnit)"}
+	if filter != nil {
+		if filter.OU != "" {
+			filters = append(filters, fmt.Sprintf("(ou=*%s*)", ldap.EscapeFilter(filter.OU)))
+		}
+		if filter.Description != "" {
+			filters = append(filters, fmt.Sprintf("(description=*%s*)", ldap.EscapeFilter(filter.Description)))
+		}
+	}
+	filterStr := filters[0]
+	if len(filters) > 1 {
+		filterStr = fmt.Sprintf("(&%s)", strings.Join(filters, ""))
+	}
+
 	searchRequest := ldap.NewSearchRequest(
 		m.config.DepartmentsDN(),
 		ldap.ScopeSingleLevel,
@@ -362,47 +614,33 @@ func (m *Manager) ListDepartments(ctx context.Context) ([]*models.Department, er
 		0,
 		0,
 		false,
-		"(objectClass=organizationalUnit)",
-		[]string{"ou", "description", "manager", "githubRepository"},
+		filterStr,
+		departmentAttributes,
 		nil,
 	)
 
-	result, err := conn.Search(searchRequest)
+	window := newPageWindow(page)
+	err = m.pagedSearch(conn, searchRequest, "ou", func(entry *ldap.Entry) {
+		window.add(entry.GetAttributeValue("ou"), entry)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("search failed: %w", err)
 	}
 
-	departments := make([]*models.Department, 0, len(result.Entries))
-	for _, entry := range result.Entries {
+	entries, info := window.result()
+	departments := make([]*models.Department, 0, len(entries))
+	for _, entry := range entries {
 		dept := m.entryToDepartment(entry)
 
 		// Get members using the same connection (avoid pool exhaustion)
-		ou := entry.GetAttributeValue("ou")
-		memberFilter := fmt.Sprintf("(&(objectClass=inetOrgPerson)(departmentNumber=%s))", ldap.EscapeFilter(ou))
-		memberSearch := ldap.NewSearchRequest(
-			m.config.UsersDN(),
-			ldap.ScopeSingleLevel,
-			ldap.NeverDerefAliases,
-			0,
-			0,
-			false,
-			memberFilter,
-			[]string{"uid"},
-			nil,
-		)
-		memberResult, err := conn.Search(memberSearch)
-		if err == nil {
-			memberUIDs := make([]string, 0, len(memberResult.Entries))
-			for _, mEntry := range memberResult.Entries {
-				memberUIDs = append(memberUIDs, mEntry.GetAttributeValue("uid"))
-			}
-			dept.Members = memberUIDs
+		if members, err := m.departmentMembers(conn, dept.OU); err == nil {
+			dept.Members = members
 		}
 
 		departments = append(departments, dept)
 	}
 
-	return departments, nil
+	return &models.DepartmentPage{Departments: departments, PageInfo: info}, nil
 }
 
 // DeleteDepartment deletes a department
@@ -415,6 +653,17 @@ func (m *Manager) DeleteDepartment(ctx context.Context, ou string) error {
 
 	deptDN := m.config.DepartmentDN(ou)
 
+	// Orphaning children would silently cut them off from inherited access
+	children, err := m.childDepartments(conn, ou)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return &models.ValidationError{Problems: []string{
+			fmt.Sprintf("department %s has child departments (%s); move or delete them first", ou, strings.Join(children, ", ")),
+		}}
+	}
+
 	m.logger.WithField("ou", ou).Info("Deleting department")
 
 	deleteRequest := ldap.NewDelRequest(deptDN, nil)
@@ -427,33 +676,6 @@ func (m *Manager) DeleteDepartment(ctx context.Context, ou string) error {
 	return nil
 }
 
-// AssignRepositoryToDepartment assigns repositories to a department
-func (m *Manager) AssignRepositoryToDepartment(ctx context.Context, ou string, repos []string) error {
-	conn, err := m.getConnection(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get connection: %w", err)
-	}
-	defer m.returnConnection(conn)
-
-	deptDN := m.config.DepartmentDN(ou)
-
-	m.logger.WithFields(logrus.Fields{
-		"ou":    ou,
-		"repos": len(repos),
-	}).Info("Assigning repositories to department")
-
-	modifyRequest := ldap.NewModifyRequest(deptDN, nil)
-	modifyRequest.Replace("githubRepository", repos)
-
-	if err := conn.Modify(modifyRequest); err != nil {
-		m.logger.WithError(err).Error("Failed to assign repositories")
-		return fmt.Errorf("failed to assign repositories: %w", err)
-	}
-
-	m.logger.WithField("ou", ou).Info("Repositories assigned successfully")
-	return nil
-}
-
 // GetUsersByDepartment retrieves all users in a department
 func (m *Manager) GetUsersByDepartment(ctx context.Context, department string) ([]*models.User, error) {
 	filter := &models.SearchFilter{
@@ -470,7 +692,10 @@ func (m *Manager) CreateGroup(ctx context.Context, cn, description string) (*mod
 	}
 	defer m.returnConnection(conn)
 
-	gidNumber := m.nextGID()
+	gidNumber, err := m.nextGID(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate gidNumber: %w", err)
+	}
 	groupDN := m.config.GroupDN(cn)
 
 	m.logger.WithField("cn", cn).Info("Creating group")
@@ -497,7 +722,7 @@ func (m *Manager) CreateGroup(ctx context.Context, cn, description string) (*mod
 
 // GetGroup retrieves a group by CN
 func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -511,7 +736,7 @@ func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error
 		0,
 		false,
 		fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(cn)),
-		[]string{"cn", "gidNumber", "description", "member", "githubRepository"},
+		groupAttributes,
 		nil,
 	)
 
@@ -521,7 +746,7 @@ func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error
 	}
 
 	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("group not found: %s", cn)
+		return nil, fmt.Errorf("%w: %s", models.ErrGroupNotFound, cn)
 	}
 
 	return m.entryToGroup(result.Entries[0]), nil
@@ -529,12 +754,26 @@ func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error
 
 // ListGroups lists all groups
 func (m *Manager) ListGroups(ctx context.Context) ([]*models.Group, error) {
-	conn, err := m.getConnection(ctx)
+	page, err := m.ListGroupsPage(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return page.Groups, nil
+}
+
+// ListGroupsPage returns one page of groups ordered by cn
+func (m *Manager) ListGroupsPage(ctx context.Context, filter *models.GroupFilter, page *models.PageRequest) (*models.GroupPage, error) {
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
+	filterStr := "(objectClass=groupOfNames)"
+	if filter != nil && filter.CN != "" {
+		filterStr = fmt.Sprintf("(&(objectClass=groupOfNames)(cn=*%s*))", ldap.EscapeFilter(filter.CN))
+	}
+
 	searchRequest := ldap.NewSearchRequest(
 		m.config.GroupsDN(),
 		ldap.ScopeSingleLevel,
@@ -542,22 +781,26 @@ func (m *Manager) ListGroups(ctx context.Context) ([]*models.Group, error) {
 		0,
 		0,
 		false,
-		"(objectClass=groupOfNames)",
-		[]string{"cn", "gidNumber", "description", "member", "githubRepository"},
+		filterStr,
+		groupAttributes,
 		nil,
 	)
 
-	result, err := conn.Search(searchRequest)
+	window := newPageWindow(page)
+	err = m.pagedSearch(conn, searchRequest, "cn", func(entry *ldap.Entry) {
+		window.add(entry.GetAttributeValue("cn"), entry)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("search failed: %w", err)
 	}
 
-	groups := make([]*models.Group, 0, len(result.Entries))
-	for _, entry := range result.Entries {
+	entries, info := window.result()
+	groups := make([]*models.Group, 0, len(entries))
+	for _, entry := range entries {
 		groups = append(groups, m.entryToGroup(entry))
 	}
 
-	return groups, nil
+	return &models.GroupPage{Groups: groups, PageInfo: info}, nil
 }
 
 // AddUserToGroup adds a user to a group
@@ -596,137 +839,51 @@ func (m *Manager) AddUserToGroup(ctx context.Context, uid, groupCN string) error
 	return nil
 }
 
-// AssignRepositoriesToGroup assigns repositories to a group
-func (m *Manager) AssignRepositoriesToGroup(ctx context.Context, cn string, repositories []string) (*models.Group, error) {
-	conn, err := m.getConnection(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get connection: %w", err)
-	}
-	defer m.returnConnection(conn)
-
-	groupDN := m.config.GroupDN(cn)
-
-	m.logger.WithFields(logrus.Fields{
-		"group":        cn,
-		"repositories": repositories,
-	}).Info("Assigning repositories to group")
-
-	// First, check if group needs extensibleObject class for custom attributes
-	searchRequest := ldap.NewSearchRequest(
-		groupDN,
-		ldap.ScopeBaseObject,
-		ldap.NeverDerefAliases,
-		0, 0, false,
-		"(objectClass=*)",
-		[]string{"objectClass"},
-		nil,
-	)
-
-	searchResult, err := conn.Search(searchRequest)
+// AssignRepositoriesToGroup replaces the repository grants of a group and
+// resyncs its members. Grants without a permission keep their current one,
+// or get DefaultPermission.
+func (m *Manager) AssignRepositoriesToGroup(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error) {
+	group, err := m.changeGroupGrants(ctx, cn, "Assigning repositories to group", func(current []models.RepositoryGrant) ([]models.RepositoryGrant, error) {
+		return resolveGrantInput(nil, grants, current)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to search group: %w", err)
-	}
-
-	if len(searchResult.Entries) == 0 {
-		return nil, fmt.Errorf("group not found: %s", cn)
-	}
-
-	// Check if group has extensibleObject class
-	objectClasses := searchResult.Entries[0].GetAttributeValues("objectClass")
-	hasExtensible := false
-	for _, oc := range objectClasses {
-		if oc == "extensibleObject" {
-			hasExtensible = true
-			break
-		}
-	}
-
-	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
-
-	// Add extensibleObject if not present (allows custom attributes)
-	if !hasExtensible {
-		modifyRequest.Add("objectClass", []string{"extensibleObject"})
-	}
-
-	// Replace githubRepository attribute with new values
-	modifyRequest.Replace("githubRepository", repositories)
-
-	if err := conn.Modify(modifyRequest); err != nil {
 		m.logger.WithError(err).Error("Failed to assign repositories to group")
-		return nil, fmt.Errorf("failed to modify group: %w", err)
-	}
-
-	// Cascade: sync repos for all group members
-	memberSearch := ldap.NewSearchRequest(
-		m.config.GroupDN(cn),
-		ldap.ScopeBaseObject,
-		ldap.NeverDerefAliases,
-		0, 0, false,
-		"(objectClass=*)",
-		[]string{"member"},
-		nil,
-	)
-
-	memberResult, err := conn.Search(memberSearch)
-	if err == nil && len(memberResult.Entries) > 0 {
-		for _, memberDN := range memberResult.Entries[0].GetAttributeValues("member") {
-			if strings.Contains(memberDN, "placeholder") {
-				continue
-			}
-			parts := strings.Split(memberDN, ",")
-			if len(parts) > 0 {
-				memberUID := strings.TrimPrefix(parts[0], "uid=")
-				if err := m.syncUserReposFromGroups(conn, memberUID); err != nil {
-					m.logger.WithFields(logrus.Fields{
-						"uid":   memberUID,
-						"group": cn,
-					}).WithError(err).Warn("Failed to cascade repos to group member")
-				}
-			}
-		}
+		return nil, err
 	}
 
 	m.logger.WithField("group", cn).Info("Repositories assigned to group successfully")
-	return m.GetGroup(ctx, cn)
+	return group, nil
 }
 
 // syncUserReposFromGroups recalculates a user's githubRepository attribute
-// as the union of all repos from groups the user belongs to.
+// as the union of the user's own grants and those of all groups the user
+// belongs to, directly or through nesting.
 // Must be called with an existing connection (not from pool).
 func (m *Manager) syncUserReposFromGroups(conn *ldap.Conn, uid string) error {
-	userDN := m.config.UserDN(uid)
-
-	// Find all groups this user belongs to
-	groupSearch := ldap.NewSearchRequest(
-		m.config.GroupsDN(),
-		ldap.ScopeSingleLevel,
-		ldap.NeverDerefAliases,
-		0, 0, false,
-		fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(userDN)),
-		[]string{"cn", "githubRepository"},
-		nil,
-	)
-
-	groupResult, err := conn.Search(groupSearch)
+	direct, err := m.userDirectGrants(conn, uid)
 	if err != nil {
-		return fmt.Errorf("failed to search user groups: %w", err)
+		return err
 	}
-
-	// Collect union of all group repos
-	repoSet := make(map[string]struct{})
-	for _, entry := range groupResult.Entries {
-		for _, repo := range entry.GetAttributeValues("githubRepository") {
-			repoSet[repo] = struct{}{}
-		}
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return err
 	}
+	return m.syncUserRepos(conn, graph, uid, direct)
+}
 
-	repos := make([]string, 0, len(repoSet))
-	for repo := range repoSet {
-		repos = append(repos, repo)
+// syncUserRepos stores the active repositories of the user's direct grants
+// and of the user's groups in graph
+func (m *Manager) syncUserRepos(conn *ldap.Conn, graph groupGraph, uid string, direct []models.RepositoryGrant) error {
+	sources := [][]models.RepositoryGrant{direct}
+	for _, cn := range userGroups(graph, m.config.UserDN(uid)) {
+		node, _ := graph.node(cn)
+		sources = append(sources, node.grants)
 	}
+	now := time.Now()
+	repos := models.GrantedRepositories(models.EffectiveGrants(now, sources...), now)
 
 	// Update user's githubRepository
-	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
 	modifyRequest.Replace("githubRepository", repos)
 
 	if err := conn.Modify(modifyRequest); err != nil {
@@ -741,6 +898,38 @@ func (m *Manager) syncUserReposFromGroups(conn *ldap.Conn, uid string) error {
 	return nil
 }
 
+// syncGroupMemberRepos resyncs the repos of every user in a group, directly
+// or through a nested group. Failures are logged per user.
+func (m *Manager) syncGroupMemberRepos(conn *ldap.Conn, cn string) error {
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return err
+	}
+	return m.syncUsersRepos(conn, graph, groupUserDNs(graph, cn))
+}
+
+// syncUsersRepos resyncs the repos of the given users against graph.
+// Failures are logged per user.
+func (m *Manager) syncUsersRepos(conn *ldap.Conn, graph groupGraph, userDNs []string) error {
+	if len(userDNs) == 0 {
+		return nil
+	}
+	grants, err := m.loadUserGrants(conn)
+	if err != nil {
+		return err
+	}
+	for _, userDN := range userDNs {
+		uid := rdnValue(userDN, "uid")
+		if uid == "" {
+			continue
+		}
+		if err := m.syncUserRepos(conn, graph, uid, grants[strings.ToLower(uid)]); err != nil {
+			m.logger.WithError(err).WithField("uid", uid).Warn("Failed to cascade repos to group member")
+		}
+	}
+	return nil
+}
+
 // Helper functions to convert LDAP entries to models
 
 func (m *Manager) entryToUser(entry *ldap.Entry) *models.User {
@@ -749,18 +938,28 @@ func (m *Manager) entryToUser(entry *ldap.Entry) *models.User {
 	fmt.Sscanf(entry.GetAttributeValue("uidNumber"), "%d", &uidNumber)
 	fmt.Sscanf(entry.GetAttributeValue("gidNumber"), "%d", &gidNumber)
 
+	status := entry.GetAttributeValue(accountStatusAttr)
+	if status == "" {
+		status = models.UserStatusActive
+	}
+
 	return &models.User{
-		UID:          entry.GetAttributeValue("uid"),
-		CN:           entry.GetAttributeValue("cn"),
-		SN:           entry.GetAttributeValue("sn"),
-		GivenName:    entry.GetAttributeValue("givenName"),
-		Mail:         entry.GetAttributeValue("mail"),
-		Department:   entry.GetAttributeValue("departmentNumber"),
-		UIDNumber:    uidNumber,
-		GIDNumber:    gidNumber,
-		HomeDir:      entry.GetAttributeValue("homeDirectory"),
-		Repositories: entry.GetAttributeValues("githubRepository"),
-		DN:           entry.DN,
+		UID:           entry.GetAttributeValue("uid"),
+		CN:            entry.GetAttributeValue("cn"),
+		SN:            entry.GetAttributeValue("sn"),
+		GivenName:     entry.GetAttributeValue("givenName"),
+		Mail:          entry.GetAttributeValue("mail"),
+		Department:    entry.GetAttributeValue("departmentNumber"),
+		UIDNumber:     uidNumber,
+		GIDNumber:     gidNumber,
+		HomeDir:       entry.GetAttributeValue("homeDirectory"),
+		Repositories:  entry.GetAttributeValues("githubRepository"),
+		Grants:        m.entryGrants(entry, false),
+		DN:            entry.DN,
+		Status:        status,
+		ExpiresAt:     parseGeneralizedTime(entry.GetAttributeValue(accountExpiresAttr)),
+		DeprovisionAt: parseGeneralizedTime(entry.GetAttributeValue(deprovisionAtAttr)),
+		Attributes:    m.entryCustomAttributes(entry),
 	}
 }
 
@@ -775,13 +974,23 @@ func (m *Manager) entryToDepartment(entry *ldap.Entry) *models.Department {
 		}
 	}
 
+	deputies := []string{}
+	for _, deputyDN := range entry.GetAttributeValues(departmentDeputyAttr) {
+		if uid := rdnValue(deputyDN, "uid"); uid != "" {
+			deputies = append(deputies, uid)
+		}
+	}
+
 	return &models.Department{
 		OU:           entry.GetAttributeValue("ou"),
 		Description:  entry.GetAttributeValue("description"),
 		Manager:      manager,
+		Deputies:     deputies,
+		Parent:       rdnValue(entry.GetAttributeValue(parentDepartmentAttr), "ou"),
 		Members:      []string{}, // Will be populated by caller
 		Repositories: entry.GetAttributeValues("githubRepository"),
 		DN:           entry.DN,
+		Grants:       m.entryGrants(entry, true),
 	}
 }
 
@@ -791,12 +1000,18 @@ func (m *Manager) entryToGroup(entry *ldap.Entry) *models.Group {
 
 	members := entry.GetAttributeValues("member")
 	memberUIDs := make([]string, 0, len(members))
+	subgroups := make([]string, 0)
 
 	for _, memberDN := range members {
 		// Skip placeholder
 		if strings.Contains(memberDN, "placeholder") {
 			continue
 		}
+		// Nested groups are members by their group DN
+		if cn := rdnValue(memberDN, "cn"); cn != "" {
+			subgroups = append(subgroups, cn)
+			continue
+		}
 		// Extract UID from DN
 		parts := strings.Split(memberDN, ",")
 		if len(parts) > 0 {
@@ -810,8 +1025,10 @@ func (m *Manager) entryToGroup(entry *ldap.Entry) *models.Group {
 		Description:  entry.GetAttributeValue("description"),
 		GIDNumber:    gidNumber,
 		Members:      memberUIDs,
+		Subgroups:    subgroups,
 		Repositories: entry.GetAttributeValues("githubRepository"),
 		DN:           entry.DN,
+		Grants:       m.entryGrants(entry, true),
 	}
 }
 
@@ -827,12 +1044,40 @@ func (m *Manager) DeleteGroup(ctx context.Context, cn string) error {
 
 	m.logger.WithField("cn", cn).Info("Deleting group")
 
+	// Remember who loses the group's repos, directly or through nesting
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return err
+	}
+	affected := groupUserDNs(graph, cn)
+
+	// Unlink the group from the groups it is nested in first
+	parents, err := m.groupsWithMember(conn, groupDN)
+	if err != nil {
+		return err
+	}
+	for _, parent := range parents {
+		if err := m.removeGroupMember(conn, parent, groupDN); err != nil {
+			return fmt.Errorf("failed to remove group from %s: %w", parent, err)
+		}
+	}
+
 	delRequest := ldap.NewDelRequest(groupDN, nil)
 	if err := conn.Del(delRequest); err != nil {
 		m.logger.WithError(err).Error("Failed to delete group")
 		return fmt.Errorf("failed to delete group: %w", err)
 	}
 
+	if len(affected) > 0 {
+		graph, err := m.loadGroupGraph(conn)
+		if err == nil {
+			err = m.syncUsersRepos(conn, graph, affected)
+		}
+		if err != nil {
+			m.logger.WithError(err).Warn("Failed to cascade repos after deleting group")
+		}
+	}
+
 	m.logger.WithField("cn", cn).Info("Group deleted successfully")
 	return nil
 }
@@ -0,0 +1,179 @@
+package ldap
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// defaultPageSize is used when LDAP_PAGE_SIZE is invalid; it matches
+// OpenLDAP's default sizelimit
+const defaultPageSize = 500
+
+// pagedSearch runs searchRequest with the Simple Paged Results control
+// (RFC 2696) and hands every entry to fn as it arrives, so listings never
+// trip the server size limit. What fn retains is up to the caller.
+//
+// When sortAttr is set a non-critical server-side sort control (RFC 2891)
+// is attached as well. Servers without the sssvlv overlay ignore it, which
+// is fine: callers never rely on the order entries arrive in.
+func (m *Manager) pagedSearch(conn *ldap.Conn, searchRequest *ldap.SearchRequest, sortAttr string, fn func(*ldap.Entry)) error {
+	pageSize := m.config.LDAPPageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	paging := ldap.NewControlPaging(uint32(pageSize))
+	controls := []ldap.Control{paging}
+	if sortAttr != "" && !m.sortUnsupported.Load() {
+		controls = append(controls, ldap.NewControlServerSideSortingWithSortKeys([]*ldap.SortKey{
+			{AttributeType: sortAttr, MatchingRule: "caseIgnoreOrderingMatch"},
+		}))
+	}
+	searchRequest.Controls = controls
+
+	for pages := 0; ; pages++ {
+		result, err := conn.Search(searchRequest)
+		if err != nil {
+			if pages == 0 && len(controls) > 1 && isSortRejected(err) {
+				m.logger.WithError(err).Warn("Server-side sort not supported, sorting in the manager instead")
+				m.sortUnsupported.Store(true)
+				return m.pagedSearch(conn, searchRequest, "", fn)
+			}
+			return err
+		}
+
+		for _, entry := range result.Entries {
+			fn(entry)
+		}
+
+		response := ldap.FindControl(result.Controls, ldap.ControlTypePaging)
+		if response == nil {
+			return nil
+		}
+		cookie := response.(*ldap.ControlPaging).Cookie
+		if len(cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(cookie)
+
+		m.logger.WithFields(logrus.Fields{
+			"base":    searchRequest.BaseDN,
+			"entries": len(result.Entries),
+		}).Debug("Fetching next result page")
+	}
+}
+
+// isSortRejected reports whether a search failed because of the sort control
+func isSortRejected(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailableCriticalExtension) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultInappropriateMatching) ||
+		ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform)
+}
+
+// pageWindow keeps the entries of one page of a listing ordered by naming
+// attribute while the full result streams past.
+//
+// Every page request rescans the whole subtree, since LDAP offers no
+// cursor to resume from; only the window is held in memory. With a limit,
+// a max-heap retains the offset+limit+1 smallest keys, the extra one
+// telling us whether a next page exists, so each entry costs O(log n).
+// Keys compare case-insensitively, matching the directory's
+// caseIgnoreMatch on uid, cn and ou.
+type pageWindow struct {
+	page  models.PageRequest
+	after string
+	items windowHeap
+	total int
+}
+
+// windowItem is an entry retained by a pageWindow with its sort key
+type windowItem struct {
+	key   string
+	entry *ldap.Entry
+}
+
+// windowHeap is a max-heap of window items by key, so the entry to evict
+// when a smaller key arrives is at the root
+type windowHeap []windowItem
+
+func (h windowHeap) Len() int            { return len(h) }
+func (h windowHeap) Less(i, j int) bool  { return h[i].key > h[j].key }
+func (h windowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *windowHeap) Push(x interface{}) { *h = append(*h, x.(windowItem)) }
+func (h *windowHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func newPageWindow(page *models.PageRequest) *pageWindow {
+	w := &pageWindow{}
+	if page != nil {
+		w.page = *page
+	}
+	if w.page.Offset < 0 {
+		w.page.Offset = 0
+	}
+	w.after = strings.ToLower(w.page.After)
+	return w
+}
+
+// add offers an entry with the given sort key to the window
+func (w *pageWindow) add(key string, entry *ldap.Entry) {
+	w.total++
+
+	key = strings.ToLower(key)
+	if w.after != "" && key <= w.after {
+		return
+	}
+	item := windowItem{key: key, entry: entry}
+
+	// Without a limit every entry is kept and sorted once at the end
+	if w.page.Limit <= 0 {
+		w.items = append(w.items, item)
+		return
+	}
+
+	capacity := w.page.Offset + w.page.Limit + 1
+	if len(w.items) < capacity {
+		heap.Push(&w.items, item)
+		return
+	}
+	if key < w.items[0].key {
+		w.items[0] = item
+		heap.Fix(&w.items, 0)
+	}
+}
+
+// result returns the entries of the requested page and its position. The
+// window is consumed: no entries may be added afterwards.
+func (w *pageWindow) result() ([]*ldap.Entry, models.PageInfo) {
+	sort.Slice(w.items, func(i, j int) bool { return w.items[i].key < w.items[j].key })
+
+	start := w.page.Offset
+	if start > len(w.items) {
+		start = len(w.items)
+	}
+	end := len(w.items)
+	if w.page.Limit > 0 && start+w.page.Limit < end {
+		end = start + w.page.Limit
+	}
+
+	entries := make([]*ldap.Entry, 0, end-start)
+	for _, item := range w.items[start:end] {
+		entries = append(entries, item.entry)
+	}
+
+	return entries, models.PageInfo{
+		Total:           w.total,
+		HasNextPage:     end < len(w.items),
+		HasPreviousPage: w.after != "" || start > 0,
+	}
+}
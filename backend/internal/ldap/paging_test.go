@@ -0,0 +1,97 @@
+package ldap
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func TestPageWindow(t *testing.T) {
+	keys := []string{"delta", "Alpha", "echo", "charlie", "bravo"}
+
+	tests := []struct {
+		name string
+		page *models.PageRequest
+		want []string
+		info models.PageInfo
+	}{
+		{
+			name: "no page returns everything sorted",
+			want: []string{"alpha", "bravo", "charlie", "delta", "echo"},
+			info: models.PageInfo{Total: 5},
+		},
+		{
+			name: "first page",
+			page: &models.PageRequest{Limit: 2},
+			want: []string{"alpha", "bravo"},
+			info: models.PageInfo{Total: 5, HasNextPage: true},
+		},
+		{
+			name: "middle page",
+			page: &models.PageRequest{Offset: 2, Limit: 2},
+			want: []string{"charlie", "delta"},
+			info: models.PageInfo{Total: 5, HasNextPage: true, HasPreviousPage: true},
+		},
+		{
+			name: "last page",
+			page: &models.PageRequest{Offset: 4, Limit: 2},
+			want: []string{"echo"},
+			info: models.PageInfo{Total: 5, HasPreviousPage: true},
+		},
+		{
+			name: "offset past the end",
+			page: &models.PageRequest{Offset: 10, Limit: 2},
+			want: []string{},
+			info: models.PageInfo{Total: 5, HasPreviousPage: true},
+		},
+		{
+			name: "after cursor compares case-insensitively",
+			page: &models.PageRequest{After: "BRAVO", Limit: 2},
+			want: []string{"charlie", "delta"},
+			info: models.PageInfo{Total: 5, HasNextPage: true, HasPreviousPage: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newPageWindow(tt.page)
+			for _, key := range keys {
+				w.add(key, &ldap.Entry{DN: key})
+			}
+			entries, info := w.result()
+
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, strings.ToLower(entry.DN))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+			if info != tt.info {
+				t.Errorf("info = %+v, want %+v", info, tt.info)
+			}
+		})
+	}
+}
+
+func TestPageWindowRetainsOnlyThePage(t *testing.T) {
+	w := newPageWindow(&models.PageRequest{Offset: 3, Limit: 2})
+	for i := 0; i < 1000; i++ {
+		w.add(fmt.Sprintf("user%04d", 999-i), &ldap.Entry{})
+		if len(w.items) > 6 {
+			t.Fatalf("window holds %d entries, want at most 6", len(w.items))
+		}
+	}
+	entries, info := w.result()
+	if len(entries) != 2 || info.Total != 1000 || !info.HasNextPage {
+		t.Errorf("got %d entries, info %+v", len(entries), info)
+	}
+	if w.items[3].key != "user0003" || w.items[4].key != "user0004" {
+		t.Errorf("page = %s, %s, want user0003, user0004", w.items[3].key, w.items[4].key)
+	}
+}
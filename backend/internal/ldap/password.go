@@ -0,0 +1,424 @@
+package ldap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/argon2"
+)
+
+// passwordHistoryAttr holds hashes of previous passwords (devplatform schema)
+const passwordHistoryAttr = "passwordHistory"
+
+// temporaryPasswordLength is the minimum length of generated passwords
+const temporaryPasswordLength = 16
+
+// passwordState is what the directory knows about a user's password
+type passwordState struct {
+	current    string   // userPassword as stored (hashed by the server)
+	history    []string // previous hashes, newest first
+	lastChange int      // shadowLastChange in days since epoch, -1 if unset
+	maxAge     int      // shadowMax in days, 0 if unset
+}
+
+// ChangePassword replaces a user's password after proving knowledge of the
+// current one by binding with it. Expired and temporary passwords are
+// accepted here, since changing them is the only way forward.
+func (m *Manager) ChangePassword(ctx context.Context, uid, oldPassword, newPassword string) error {
+	if _, err := m.Authenticate(ctx, uid, oldPassword); err != nil &&
+		!errors.Is(err, models.ErrPasswordExpired) && !errors.Is(err, models.ErrPasswordChangeRequired) {
+		return err
+	}
+
+	if oldPassword == newPassword {
+		return &models.ValidationError{Problems: []string{"new password must differ from the current one"}}
+	}
+	if err := m.checkPasswordPolicy(uid, newPassword); err != nil {
+		return err
+	}
+
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	if err := m.setPassword(conn, uid, newPassword, false); err != nil {
+		return err
+	}
+
+	m.logger.WithField("uid", uid).Info("Password changed")
+	return nil
+}
+
+// ResetPassword sets a generated one-time password that must be changed at
+// the next login and returns it
+func (m *Manager) ResetPassword(ctx context.Context, uid string) (string, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	length := temporaryPasswordLength
+	if m.config.PasswordMinLength > length {
+		length = m.config.PasswordMinLength
+	}
+	password, err := generatePassword(length)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	if err := m.setPassword(conn, uid, password, true); err != nil {
+		return "", err
+	}
+
+	m.logger.WithField("uid", uid).Info("Password reset to a temporary password")
+	return password, nil
+}
+
+// ValidatePassword checks a password against the policy without storing
+// it. For an existing user it is also checked against the password history.
+func (m *Manager) ValidatePassword(ctx context.Context, uid, password string) error {
+	if err := m.checkPasswordPolicy(uid, password); err != nil {
+		return err
+	}
+	if m.config.PasswordHistory <= 0 {
+		return nil
+	}
+
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	state, err := m.readPasswordState(conn, uid)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.checkPasswordReuse(state, password)
+}
+
+// checkPasswordPolicy returns a ValidationError listing every rule the
+// password breaks
+func (m *Manager) checkPasswordPolicy(uid, password string) error {
+	var problems []string
+
+	if n := len([]rune(password)); n < m.config.PasswordMinLength {
+		problems = append(problems, fmt.Sprintf("password must be at least %d characters", m.config.PasswordMinLength))
+	}
+	if n := passwordClasses(password); n < m.config.PasswordMinClasses {
+		problems = append(problems, fmt.Sprintf("password must mix at least %d of: lowercase, uppercase, digits, symbols", m.config.PasswordMinClasses))
+	}
+	if len(uid) >= 3 && strings.Contains(strings.ToLower(password), strings.ToLower(uid)) {
+		problems = append(problems, "password must not contain the username")
+	}
+
+	if len(problems) > 0 {
+		return &models.ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// setPassword stores a password through the Password Modify extended
+// operation (RFC 3062), so the server hashes it with its configured scheme
+// (olcPasswordHash: {SSHA} by default, {ARGON2} with the argon2 module)
+// instead of us writing a cleartext userPassword.
+//
+// Afterwards the previous hash is pushed onto passwordHistory and the
+// shadowAccount ageing attributes are updated. A temporary password gets
+// shadowLastChange=0, the shadow convention for "change at next login".
+func (m *Manager) setPassword(conn *ldap.Conn, uid, password string, temporary bool) error {
+	userDN := m.config.UserDN(uid)
+
+	state, err := m.readPasswordState(conn, uid)
+	if err != nil {
+		return err
+	}
+
+	if !temporary {
+		if err := m.checkPasswordReuse(state, password); err != nil {
+			return err
+		}
+	}
+
+	if _, err := conn.PasswordModify(ldap.NewPasswordModifyRequest(userDN, "", password)); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+
+	// Keep PasswordHistory-1 old hashes: together with the current password
+	// that makes PasswordHistory passwords that cannot be reused
+	var history []string
+	if keep := m.config.PasswordHistory - 1; keep > 0 {
+		if state.current != "" {
+			history = append(history, state.current)
+		}
+		history = append(history, state.history...)
+		if len(history) > keep {
+			history = history[:keep]
+		}
+	}
+
+	lastChange := daysSinceEpoch(time.Now())
+	if temporary {
+		lastChange = 0
+	}
+	maxAge := int(m.config.PasswordMaxAge.Hours() / 24)
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Replace("shadowLastChange", []string{strconv.Itoa(lastChange)})
+	if maxAge > 0 {
+		modifyRequest.Replace("shadowMax", []string{strconv.Itoa(maxAge)})
+	} else {
+		modifyRequest.Replace("shadowMax", []string{})
+	}
+	if m.config.PasswordHistory > 1 {
+		modifyRequest.Replace(passwordHistoryAttr, history)
+	}
+
+	if err := conn.Modify(modifyRequest); err != nil {
+		// The password itself is already changed; only ageing is stale
+		m.logger.WithError(err).WithField("uid", uid).Error("Failed to update password ageing attributes")
+		return fmt.Errorf("password set but failed to update password history: %w", err)
+	}
+
+	return nil
+}
+
+// checkPasswordReuse refuses the current password and those in the history
+func (m *Manager) checkPasswordReuse(state *passwordState, password string) error {
+	if m.config.PasswordHistory <= 0 {
+		return nil
+	}
+	for _, stored := range append([]string{state.current}, state.history...) {
+		if stored != "" && passwordMatches(stored, password) {
+			return &models.ValidationError{Problems: []string{
+				fmt.Sprintf("password must not match any of the last %d passwords", m.config.PasswordHistory),
+			}}
+		}
+	}
+	return nil
+}
+
+// readPasswordState loads the stored password hash and ageing attributes
+func (m *Manager) readPasswordState(conn *ldap.Conn, uid string) (*passwordState, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UserDN(uid),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(objectClass=*)",
+		[]string{"userPassword", passwordHistoryAttr, "shadowLastChange", "shadowMax"},
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+		}
+		return nil, fmt.Errorf("failed to read password state: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+	}
+
+	entry := result.Entries[0]
+	state := &passwordState{
+		current:    entry.GetAttributeValue("userPassword"),
+		history:    entry.GetAttributeValues(passwordHistoryAttr),
+		lastChange: -1,
+	}
+	if v, err := strconv.Atoi(entry.GetAttributeValue("shadowLastChange")); err == nil {
+		state.lastChange = v
+	}
+	if v, err := strconv.Atoi(entry.GetAttributeValue("shadowMax")); err == nil {
+		state.maxAge = v
+	}
+	return state, nil
+}
+
+// checkPasswordAge returns ErrPasswordChangeRequired for temporary passwords
+// and ErrPasswordExpired once shadowMax days have passed since the change
+func (s *passwordState) checkPasswordAge(now time.Time) error {
+	switch {
+	case s.lastChange == 0:
+		return models.ErrPasswordChangeRequired
+	case s.lastChange > 0 && s.maxAge > 0 && daysSinceEpoch(now) > s.lastChange+s.maxAge:
+		return models.ErrPasswordExpired
+	}
+	return nil
+}
+
+func daysSinceEpoch(t time.Time) int {
+	return int(t.Unix() / 86400)
+}
+
+// passwordClasses counts the character classes used in a password
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			n++
+		}
+	}
+	return n
+}
+
+// generatePassword returns a random password containing every character class
+func generatePassword(length int) (string, error) {
+	classes := []string{
+		"abcdefghijkmnopqrstuvwxyz",
+		"ABCDEFGHJKLMNPQRSTUVWXYZ",
+		"23456789",
+		"!#%+-=?@_",
+	}
+	all := strings.Join(classes, "")
+
+	pick := func(set string) (byte, error) {
+		i, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+		if err != nil {
+			return 0, err
+		}
+		return set[i.Int64()], nil
+	}
+
+	password := make([]byte, length)
+	for i := range password {
+		set := all
+		if i < len(classes) {
+			set = classes[i]
+		}
+		c, err := pick(set)
+		if err != nil {
+			return "", err
+		}
+		password[i] = c
+	}
+
+	// Shuffle so the guaranteed classes are not always in front
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+// passwordMatches verifies a password against a stored userPassword value.
+// Schemes we cannot verify locally never match, so history checks are
+// skipped for them rather than failing.
+func passwordMatches(stored, password string) bool {
+	scheme, encoded := "", stored
+	if strings.HasPrefix(stored, "{") {
+		if end := strings.Index(stored, "}"); end > 0 {
+			scheme, encoded = strings.ToUpper(stored[1:end]), stored[end+1:]
+		}
+	}
+
+	switch scheme {
+	case "":
+		// Entries written before hashing was enforced
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	case "SHA":
+		return saltedDigestMatches(sha1.New, sha1.Size, encoded, password, false)
+	case "SSHA":
+		return saltedDigestMatches(sha1.New, sha1.Size, encoded, password, true)
+	case "SHA256":
+		return saltedDigestMatches(sha256.New, sha256.Size, encoded, password, false)
+	case "SSHA256":
+		return saltedDigestMatches(sha256.New, sha256.Size, encoded, password, true)
+	case "SHA512":
+		return saltedDigestMatches(sha512.New, sha512.Size, encoded, password, false)
+	case "SSHA512":
+		return saltedDigestMatches(sha512.New, sha512.Size, encoded, password, true)
+	case "ARGON2":
+		return argon2Matches(encoded, password)
+	default:
+		return false
+	}
+}
+
+// saltedDigestMatches checks {SHA}/{SSHA}-style values: base64(digest+salt)
+func saltedDigestMatches(newHash func() hash.Hash, size int, encoded, password string, salted bool) bool {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < size || (!salted && len(raw) != size) {
+		return false
+	}
+
+	h := newHash()
+	h.Write([]byte(password))
+	h.Write(raw[size:])
+	return subtle.ConstantTimeCompare(h.Sum(nil), raw[:size]) == 1
+}
+
+// argon2Matches checks PHC-formatted argon2 values as written by OpenLDAP's
+// argon2 module: $argon2id$v=19$m=65536,t=2,p=1$<salt>$<hash>
+func argon2Matches(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	var actual []byte
+	switch parts[1] {
+	case "argon2id":
+		actual = argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	case "argon2i":
+		actual = argon2.Key([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
@@ -0,0 +1,237 @@
+package ldap
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	m := &Manager{config: &config.Config{PasswordMinLength: 12, PasswordMinClasses: 3}}
+
+	tests := []struct {
+		name     string
+		uid      string
+		password string
+		want     []string
+	}{
+		{
+			name:     "compliant",
+			uid:      "alice",
+			password: "Correct-Horse-9",
+		},
+		{
+			name:     "too short",
+			uid:      "alice",
+			password: "Sh0rt!",
+			want:     []string{"password must be at least 12 characters"},
+		},
+		{
+			name:     "length counts characters, not bytes",
+			uid:      "alice",
+			password: "ÄÖÜäöü12345",
+			want:     []string{"password must be at least 12 characters"},
+		},
+		{
+			name:     "too few classes",
+			uid:      "alice",
+			password: "lowercaseonly123",
+			want:     []string{"password must mix at least 3 of: lowercase, uppercase, digits, symbols"},
+		},
+		{
+			name:     "contains the username in any case",
+			uid:      "alice",
+			password: "xxALICE-2024yy",
+			want:     []string{"password must not contain the username"},
+		},
+		{
+			name:     "short usernames are not checked",
+			uid:      "al",
+			password: "al-Password-2024",
+		},
+		{
+			name:     "every problem is reported",
+			uid:      "bob",
+			password: "bob",
+			want: []string{
+				"password must be at least 12 characters",
+				"password must mix at least 3 of: lowercase, uppercase, digits, symbols",
+				"password must not contain the username",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.checkPasswordPolicy(tt.uid, tt.password)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("checkPasswordPolicy() error = %v, want nil", err)
+				}
+				return
+			}
+			var validation *models.ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("checkPasswordPolicy() error = %v, want a ValidationError", err)
+			}
+			if !reflect.DeepEqual(validation.Problems, tt.want) {
+				t.Errorf("problems = %q, want %q", validation.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordClasses(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", 0},
+		{"abc", 1},
+		{"abcDEF", 2},
+		{"abcDEF123", 3},
+		{"abcDEF123!", 4},
+		{"ñÑ٣ ", 4},
+	}
+
+	for _, tt := range tests {
+		if got := passwordClasses(tt.password); got != tt.want {
+			t.Errorf("passwordClasses(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	for _, length := range []int{4, temporaryPasswordLength, 64} {
+		password, err := generatePassword(length)
+		if err != nil {
+			t.Fatalf("generatePassword(%d) error = %v", length, err)
+		}
+		if len(password) != length {
+			t.Errorf("generatePassword(%d) has length %d", length, len(password))
+		}
+		if n := passwordClasses(password); n != 4 {
+			t.Errorf("generatePassword(%d) = %q uses %d classes, want 4", length, password, n)
+		}
+	}
+}
+
+func TestPasswordMatches(t *testing.T) {
+	salt := []byte("saltsalt")
+	ssha := func(password string) string {
+		h := sha1.New()
+		h.Write([]byte(password))
+		h.Write(salt)
+		return "{SSHA}" + base64.StdEncoding.EncodeToString(append(h.Sum(nil), salt...))
+	}
+	sha256Digest := sha256.Sum256([]byte("secret-one"))
+	argon2id := "{ARGON2}$argon2id$v=19$m=1024,t=1,p=1$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("secret-one"), salt, 1, 1024, 1, 32))
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		want     bool
+	}{
+		{"cleartext", "secret-one", "secret-one", true},
+		{"cleartext mismatch", "secret-one", "secret-two", false},
+		{"ssha", ssha("secret-one"), "secret-one", true},
+		{"ssha mismatch", ssha("secret-one"), "secret-two", false},
+		{"scheme is case-insensitive", "{ssha}" + ssha("secret-one")[6:], "secret-one", true},
+		{"sha256", "{SHA256}" + base64.StdEncoding.EncodeToString(sha256Digest[:]), "secret-one", true},
+		{"unsalted digest with trailing bytes", "{SHA256}" + base64.StdEncoding.EncodeToString(append(sha256Digest[:], 'x')), "secret-one", false},
+		{"argon2id", argon2id, "secret-one", true},
+		{"argon2id mismatch", argon2id, "secret-two", false},
+		{"malformed argon2", "{ARGON2}$argon2id$v=19$m=1024", "secret-one", false},
+		{"invalid base64", "{SSHA}not base64", "secret-one", false},
+		{"unknown scheme never matches", "{CRYPT}secret-one", "secret-one", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := passwordMatches(tt.stored, tt.password); got != tt.want {
+				t.Errorf("passwordMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	today := daysSinceEpoch(now)
+
+	tests := []struct {
+		name  string
+		state passwordState
+		want  error
+	}{
+		{"never changed", passwordState{lastChange: -1}, nil},
+		{"temporary", passwordState{lastChange: 0, maxAge: 90}, models.ErrPasswordChangeRequired},
+		{"within max age", passwordState{lastChange: today - 90, maxAge: 90}, nil},
+		{"past max age", passwordState{lastChange: today - 91, maxAge: 90}, models.ErrPasswordExpired},
+		{"no max age", passwordState{lastChange: 1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.checkPasswordAge(now); !errors.Is(got, tt.want) {
+				t.Errorf("checkPasswordAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	usersDN := "ou=users," + testBaseDN
+	dir := newFakeDirectory(t,
+		testEntry(testBaseDN),
+		testEntry(usersDN),
+		testEntry("uid=alice,"+usersDN,
+			"userPassword", "Current-Secret-1",
+			passwordHistoryAttr, "Older-Secret-22",
+		),
+	)
+	cfg := testConfig(dir.URL())
+	cfg.PasswordMinLength = 12
+	cfg.PasswordMinClasses = 3
+	cfg.PasswordHistory = 3
+	m := newTestManager(t, cfg)
+
+	tests := []struct {
+		name     string
+		uid      string
+		password string
+		wantErr  bool
+	}{
+		{"fresh password", "alice", "Brand-New-Secret-3", false},
+		{"current password", "alice", "Current-Secret-1", true},
+		{"password from the history", "alice", "Older-Secret-22", true},
+		{"policy violation", "alice", "short", true},
+		{"new user has no history", "bob", "Current-Secret-1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.ValidatePassword(context.Background(), tt.uid, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidatePassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var validation *models.ValidationError
+			if err != nil && !errors.As(err, &validation) {
+				t.Errorf("ValidatePassword() error = %v, want a ValidationError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// pooledConn is a pooled connection with the times used to retire it.
+// Its fields are only touched by whoever holds it: the pool, a caller
+// between getConnection and returnConnection, or the reaper.
+type pooledConn struct {
+	conn      *ldap.Conn
+	createdAt time.Time
+	lastUsed  time.Time
+	write     bool   // checked out for a write
+	tlsGen    uint64 // generation of the TLS material it was opened with
+}
+
+// poolCounters are cumulative pool statistics, updated atomically
+type poolCounters struct {
+	waits     int64 // checkouts that had to wait for a connection
+	waitNanos int64 // total time spent waiting
+	timeouts  int64 // checkouts that gave up after LDAP_POOL_TIMEOUT
+	created   int64 // connections opened
+	recycled  int64 // connections closed for exceeding their lifetime
+	reaped    int64 // idle or broken connections closed by the reaper
+}
+
+// SetStatsHook registers a function receiving pool statistics after every
+// reaper pass, so metrics stay current without anyone calling GetStats
+func (m *Manager) SetStatsHook(fn func(*models.Stats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statsHook = fn
+}
+
+// get retrieves a connection from the backend's pool. It prefers an idle
+// connection, opens a new one while the pool is below its maximum, and
+// otherwise waits up to LDAP_POOL_TIMEOUT for one to be returned. Errors
+// wrapping errBackendDown mean the server could not be reached.
+func (b *backend) get(ctx context.Context) (*pooledConn, error) {
+	var waitStart time.Time
+	var timeout <-chan time.Time
+	for {
+		select {
+		case pc, ok := <-b.pool:
+			if !ok {
+				return nil, fmt.Errorf("connection pool is closed")
+			}
+			if b.checkout(pc) {
+				b.recordWait(waitStart)
+				return pc, nil
+			}
+			continue
+		default:
+		}
+
+		pc, err := b.grow()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new connection: %w: %w", errBackendDown, err)
+		}
+		if pc != nil {
+			b.recordWait(waitStart)
+			return pc, nil
+		}
+
+		// The pool is at its maximum: wait for a connection to come back
+		// or for a slot to free up
+		if timeout == nil {
+			waitStart = time.Now()
+			timer := time.NewTimer(b.m.config.LDAPPoolTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case pc, ok := <-b.pool:
+			if !ok {
+				return nil, fmt.Errorf("connection pool is closed")
+			}
+			if b.checkout(pc) {
+				b.recordWait(waitStart)
+				return pc, nil
+			}
+		case <-b.freed:
+		case <-timeout:
+			atomic.AddInt64(&b.counters.timeouts, 1)
+			b.recordWait(waitStart)
+			return nil, fmt.Errorf("timeout waiting for connection from pool")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// grow opens a new pooled connection, or returns nil if the pool is
+// already at its maximum size
+func (b *backend) grow() (*pooledConn, error) {
+	b.poolMu.Lock()
+	if len(b.conns)+b.dialing >= b.maxSize {
+		b.poolMu.Unlock()
+		return nil, nil
+	}
+	b.dialing++
+	b.poolMu.Unlock()
+
+	tlsGen := b.m.tls.Generation()
+	conn, err := b.m.createConnection(b.url)
+	if err != nil {
+		b.recordFailure(err)
+	} else {
+		b.recordSuccess()
+	}
+
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+	b.dialing--
+	if err != nil {
+		b.notifyFreed()
+		return nil, err
+	}
+
+	now := time.Now()
+	pc := &pooledConn{conn: conn, createdAt: now, lastUsed: now, tlsGen: tlsGen}
+	b.conns[conn] = pc
+	atomic.AddInt64(&b.counters.created, 1)
+	return pc, nil
+}
+
+// lookup returns the pooled connection for conn, or nil if it does not
+// belong to this backend
+func (b *backend) lookup(conn *ldap.Conn) *pooledConn {
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+	return b.conns[conn]
+}
+
+// checkout validates an idle connection before handing it out, closing it
+// if it is too old or no longer answers
+func (b *backend) checkout(pc *pooledConn) bool {
+	if b.expired(pc, time.Now()) {
+		b.discard(pc, &b.counters.recycled)
+		return false
+	}
+	if pc.conn.IsClosing() || !b.m.testConnection(pc.conn) {
+		b.m.logger.WithField("backend", b.url).Debug("Connection test failed, discarding connection")
+		b.discard(pc, nil)
+		return false
+	}
+	return true
+}
+
+// putIdle hands a connection back to the idle queue, closing it if the
+// queue is full. Callers have checked the pool is open.
+func (b *backend) putIdle(pc *pooledConn) {
+	select {
+	case b.pool <- pc:
+	default:
+		// Pool is full, close the connection
+		b.m.logger.Warn("Connection pool full, closing connection")
+		b.discard(pc, nil)
+	}
+}
+
+// discard closes a pooled connection and frees its slot, counting it in
+// counter when one is given
+func (b *backend) discard(pc *pooledConn, counter *int64) {
+	b.poolMu.Lock()
+	delete(b.conns, pc.conn)
+	b.poolMu.Unlock()
+
+	pc.conn.Close()
+	if counter != nil {
+		atomic.AddInt64(counter, 1)
+	}
+	b.notifyFreed()
+}
+
+// notifyFreed wakes one get waiting for a free slot
+func (b *backend) notifyFreed() {
+	select {
+	case b.freed <- struct{}{}:
+	default:
+	}
+}
+
+// recordWait accounts for a checkout that had to wait since start; a zero
+// start means it did not wait
+func (b *backend) recordWait(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	atomic.AddInt64(&b.counters.waits, 1)
+	atomic.AddInt64(&b.counters.waitNanos, int64(time.Since(start)))
+}
+
+// expired reports whether a connection has outlived LDAP_MAX_CONN_LIFETIME
+// or was opened with certificates that have since been rotated
+func (b *backend) expired(pc *pooledConn, now time.Time) bool {
+	if pc.tlsGen != b.m.tls.Generation() {
+		return true
+	}
+	return b.m.config.LDAPMaxConnLifetime > 0 && now.Sub(pc.createdAt) >= b.m.config.LDAPMaxConnLifetime
+}
+
+// size returns the number of open and opening pooled connections
+func (b *backend) size() int {
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+	return len(b.conns) + b.dialing
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// REAPER
+// ═══════════════════════════════════════════════════════════════════════════
+
+// reapLoop runs the reaper every interval until the pool is closed
+func (m *Manager) reapLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopReaper:
+			return
+		case <-ticker.C:
+			m.reap()
+		}
+	}
+}
+
+// reap runs a reaper pass over every backend and publishes the resulting
+// statistics
+func (m *Manager) reap() {
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return
+	}
+
+	for _, b := range m.backends() {
+		b.reap()
+	}
+
+	hook := m.statsHook
+	m.mu.RUnlock()
+
+	if hook != nil {
+		hook(m.GetStats())
+	}
+}
+
+// reap retires idle connections that are too old, idle for longer than
+// LDAP_CONN_MAX_IDLE while the pool is above its minimum, or broken. The
+// pool is then topped back up to its minimum size, which also probes a
+// backend whose breaker has cooled down. Callers hold m.mu.
+func (b *backend) reap() {
+	// Connections are taken one at a time and put back at the end of the
+	// queue, so at most one idle connection is unavailable at once
+	for n := len(b.pool); n > 0; n-- {
+		var pc *pooledConn
+		select {
+		case pc = <-b.pool:
+		default:
+		}
+		if pc == nil {
+			break
+		}
+
+		now := time.Now()
+		switch {
+		case b.expired(pc, now):
+			b.discard(pc, &b.counters.recycled)
+		case b.m.config.LDAPConnMaxIdle > 0 && now.Sub(pc.lastUsed) >= b.m.config.LDAPConnMaxIdle && b.size() > b.minSize:
+			b.discard(pc, &b.counters.reaped)
+		case pc.conn.IsClosing() || !b.m.testConnection(pc.conn):
+			b.m.logger.WithField("backend", b.url).Debug("Idle connection failed health check, discarding")
+			b.discard(pc, &b.counters.reaped)
+		default:
+			b.putIdle(pc)
+		}
+	}
+
+	if !b.available(time.Now()) {
+		return
+	}
+	if err := b.fill(); err != nil {
+		b.m.logger.WithError(err).Warn("Failed to refill connection pool to its minimum size")
+	}
+}
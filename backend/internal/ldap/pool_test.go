@@ -0,0 +1,208 @@
+package ldap
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestPoolSizing(t *testing.T) {
+	tests := []struct {
+		name        string
+		minSize     int
+		maxSize     int
+		checkouts   int
+		wantCreated int
+		wantErr     string
+	}{
+		{
+			name:        "starts with the minimum",
+			minSize:     2,
+			maxSize:     4,
+			wantCreated: 2,
+		},
+		{
+			name:        "reuses idle connections before growing",
+			minSize:     2,
+			maxSize:     4,
+			checkouts:   2,
+			wantCreated: 2,
+		},
+		{
+			name:        "grows on demand",
+			minSize:     1,
+			maxSize:     4,
+			checkouts:   3,
+			wantCreated: 3,
+		},
+		{
+			name:        "times out at the maximum",
+			minSize:     1,
+			maxSize:     2,
+			checkouts:   3,
+			wantCreated: 2,
+			wantErr:     "timeout waiting for connection from pool",
+		},
+		{
+			name:        "minimum is capped by the maximum",
+			minSize:     5,
+			maxSize:     2,
+			wantCreated: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newFakeDirectory(t, testEntry(testBaseDN))
+			cfg := testConfig(dir.URL())
+			cfg.LDAPPoolMinSize = tt.minSize
+			cfg.LDAPPoolSize = tt.maxSize
+			cfg.LDAPPoolTimeout = 50 * time.Millisecond
+			m := newTestManager(t, cfg)
+
+			var conns []*ldap.Conn
+			var err error
+			for i := 0; i < tt.checkouts; i++ {
+				var conn *ldap.Conn
+				if conn, err = m.getReadConnection(context.Background()); err != nil {
+					break
+				}
+				conns = append(conns, conn)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("checkout error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("checkout error = %v", err)
+			}
+
+			stats := m.GetStats()
+			if stats.ConnectionsCreated != tt.wantCreated {
+				t.Errorf("created %d connections, want %d", stats.ConnectionsCreated, tt.wantCreated)
+			}
+			if stats.InUse != len(conns) {
+				t.Errorf("%d connections in use, want %d", stats.InUse, len(conns))
+			}
+			if tt.wantErr != "" && stats.Timeouts != 1 {
+				t.Errorf("counted %d timeouts, want 1", stats.Timeouts)
+			}
+
+			for _, conn := range conns {
+				m.returnConnection(conn)
+			}
+			if stats := m.GetStats(); stats.InUse != 0 || stats.Available != tt.wantCreated {
+				t.Errorf("after return: %d in use, %d available, want 0 and %d", stats.InUse, stats.Available, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestPoolWaiterGetsReturnedConnection(t *testing.T) {
+	dir := newFakeDirectory(t, testEntry(testBaseDN))
+	cfg := testConfig(dir.URL())
+	cfg.LDAPPoolSize = 1
+	m := newTestManager(t, cfg)
+
+	held, err := m.getReadConnection(context.Background())
+	if err != nil {
+		t.Fatalf("checkout error = %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		m.returnConnection(held)
+	}()
+
+	conn, err := m.getReadConnection(context.Background())
+	if err != nil {
+		t.Fatalf("waiting checkout error = %v", err)
+	}
+	defer m.returnConnection(conn)
+
+	if conn != held {
+		t.Error("waiter did not receive the returned connection")
+	}
+	if stats := m.GetStats(); stats.Waits != 1 || stats.ConnectionsCreated != 1 {
+		t.Errorf("waits = %d, created = %d, want 1 and 1", stats.Waits, stats.ConnectionsCreated)
+	}
+}
+
+func TestPoolRetiresConnections(t *testing.T) {
+	tests := []struct {
+		name         string
+		lifetime     time.Duration
+		maxIdle      time.Duration
+		minSize      int
+		extra        int // connections opened beyond the minimum
+		wantRecycled int
+		wantReaped   int
+		wantOpen     int
+	}{
+		{
+			name:     "healthy idle connections are kept",
+			minSize:  1,
+			extra:    1,
+			wantOpen: 2,
+		},
+		{
+			name:         "connections past their lifetime are recycled and refilled",
+			lifetime:     time.Nanosecond,
+			minSize:      2,
+			wantRecycled: 2,
+			wantOpen:     2,
+		},
+		{
+			name:       "idle connections above the minimum are reaped",
+			maxIdle:    time.Nanosecond,
+			minSize:    1,
+			extra:      2,
+			wantReaped: 2,
+			wantOpen:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newFakeDirectory(t, testEntry(testBaseDN))
+			cfg := testConfig(dir.URL())
+			cfg.LDAPPoolMinSize = tt.minSize
+			cfg.LDAPMaxConnLifetime = 0
+			cfg.LDAPConnMaxIdle = 0
+			m := newTestManager(t, cfg)
+
+			// Open extra connections by holding them all, then return them
+			var conns []*ldap.Conn
+			for i := 0; i < tt.minSize+tt.extra; i++ {
+				conn, err := m.getReadConnection(context.Background())
+				if err != nil {
+					t.Fatalf("checkout error = %v", err)
+				}
+				conns = append(conns, conn)
+			}
+			for _, conn := range conns {
+				m.returnConnection(conn)
+			}
+
+			// Tighten the limits only now, so the checkouts above keep their
+			// connections
+			cfg.LDAPMaxConnLifetime = tt.lifetime
+			cfg.LDAPConnMaxIdle = tt.maxIdle
+			time.Sleep(time.Millisecond)
+			m.reap()
+
+			stats := m.GetStats()
+			if stats.ConnectionsRecycled != tt.wantRecycled {
+				t.Errorf("recycled %d, want %d", stats.ConnectionsRecycled, tt.wantRecycled)
+			}
+			if stats.ConnectionsReaped != tt.wantReaped {
+				t.Errorf("reaped %d, want %d", stats.ConnectionsReaped, tt.wantReaped)
+			}
+			if stats.PoolSize != tt.wantOpen {
+				t.Errorf("pool holds %d connections, want %d", stats.PoolSize, tt.wantOpen)
+			}
+		})
+	}
+}
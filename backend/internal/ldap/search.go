@@ -0,0 +1,432 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Limits on filter expressions, so a single request cannot make the
+// directory evaluate an arbitrarily large filter
+const (
+	maxFilterDepth      = 5
+	maxFilterConditions = 50
+)
+
+// Limits of SearchDirectory
+const (
+	defaultDirectorySearchLimit = 20
+	maxDirectorySearchLimit     = 100
+	maxDirectorySearchTerms     = 5
+)
+
+// filterField describes how a built-in user field is matched
+type filterField struct {
+	attr    string
+	numeric bool
+}
+
+// userFilterFields maps the built-in fields of a FilterCondition to their
+// LDAP attributes
+var userFilterFields = map[string]filterField{
+	"uid":        {attr: "uid"},
+	"cn":         {attr: "cn"},
+	"sn":         {attr: "sn"},
+	"givenName":  {attr: "givenName"},
+	"mail":       {attr: "mail"},
+	"department": {attr: "departmentNumber"},
+	"repository": {attr: "githubRepository"},
+	"status":     {attr: accountStatusAttr},
+	"uidNumber":  {attr: "uidNumber", numeric: true},
+	"gidNumber":  {attr: "gidNumber", numeric: true},
+}
+
+// anyOfFilter returns a filter matching attr against any of the values
+// exactly, or "" when there are none
+func anyOfFilter(attr string, values []string) string {
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("(%s=%s)", attr, ldap.EscapeFilter(v)))
+	}
+	switch len(parts) {
+	case 0:
+		return ""
+	case 1:
+		return parts[0]
+	}
+	return "(|" + strings.Join(parts, "") + ")"
+}
+
+// filterCompiler turns a FilterExpression into an LDAP filter. Every value
+// is escaped, so user input can never change the structure of the filter.
+type filterCompiler struct {
+	m          *Manager
+	conditions int
+}
+
+// compileUserExpression compiles a user filter expression, returning ""
+// for an empty expression
+func (m *Manager) compileUserExpression(expr *models.FilterExpression) (string, error) {
+	if expr == nil {
+		return "", nil
+	}
+	c := &filterCompiler{m: m}
+	return c.expression(expr, 1)
+}
+
+func (c *filterCompiler) expression(expr *models.FilterExpression, depth int) (string, error) {
+	if depth > maxFilterDepth {
+		return "", &models.ValidationError{Problems: []string{fmt.Sprintf("filter expressions may be nested at most %d levels deep", maxFilterDepth)}}
+	}
+
+	op := strings.ToUpper(expr.Op)
+	switch op {
+	case "", models.LogicalAnd:
+		op = "&"
+	case models.LogicalOr:
+		op = "|"
+	default:
+		return "", &models.ValidationError{Problems: []string{fmt.Sprintf("unknown logical operator %q (want AND or OR)", expr.Op)}}
+	}
+
+	var parts []string
+	for i := range expr.Conditions {
+		part, err := c.condition(&expr.Conditions[i])
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	for _, group := range expr.Groups {
+		if group == nil {
+			continue
+		}
+		part, err := c.expression(group, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	var filter string
+	switch len(parts) {
+	case 0:
+		if expr.Not {
+			return "", &models.ValidationError{Problems: []string{"cannot negate an empty filter expression"}}
+		}
+		return "", nil
+	case 1:
+		filter = parts[0]
+	default:
+		filter = "(" + op + strings.Join(parts, "") + ")"
+	}
+
+	if expr.Not {
+		filter = "(!" + filter + ")"
+	}
+	return filter, nil
+}
+
+func (c *filterCompiler) condition(cond *models.FilterCondition) (string, error) {
+	attr, numeric, err := c.field(cond.Field)
+	if err != nil {
+		return "", err
+	}
+
+	match := strings.ToUpper(cond.Match)
+	if match == "" {
+		match = models.MatchSubstring
+		if numeric {
+			match = models.MatchExact
+		}
+	}
+
+	var filter string
+	switch match {
+	case models.MatchPresent:
+		c.conditions++
+		filter = fmt.Sprintf("(%s=*)", attr)
+	case models.MatchExact, models.MatchPrefix, models.MatchSubstring:
+		if len(cond.Values) == 0 {
+			return "", &models.ValidationError{Problems: []string{fmt.Sprintf("condition on %q needs at least one value", cond.Field)}}
+		}
+		if numeric && match != models.MatchExact {
+			return "", &models.ValidationError{Problems: []string{fmt.Sprintf("%q is numeric and only supports EXACT matches", cond.Field)}}
+		}
+
+		var parts []string
+		for _, value := range cond.Values {
+			c.conditions++
+			part, err := c.match(cond.Field, attr, match, value, numeric)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		if len(parts) == 1 {
+			filter = parts[0]
+		} else {
+			filter = "(|" + strings.Join(parts, "") + ")"
+		}
+	default:
+		return "", &models.ValidationError{Problems: []string{fmt.Sprintf("unknown match operator %q (want EXACT, PREFIX, SUBSTRING or PRESENT)", cond.Match)}}
+	}
+
+	if c.conditions > maxFilterConditions {
+		return "", &models.ValidationError{Problems: []string{fmt.Sprintf("filter expressions may test at most %d values", maxFilterConditions)}}
+	}
+
+	if cond.Not {
+		filter = "(!" + filter + ")"
+	}
+	return filter, nil
+}
+
+// field resolves a condition's field to its LDAP attribute
+func (c *filterCompiler) field(name string) (string, bool, error) {
+	if f, ok := userFilterFields[name]; ok {
+		return f.attr, f.numeric, nil
+	}
+	if def, ok := c.m.config.UserAttribute(name); ok {
+		return def.LDAPAttribute, def.Type == models.AttributeTypeInt, nil
+	}
+	return "", false, &models.ValidationError{Problems: []string{fmt.Sprintf("unknown user field %q", name)}}
+}
+
+// match builds the filter for a single value
+func (c *filterCompiler) match(field, attr, match, value string, numeric bool) (string, error) {
+	if value == "" {
+		return "", &models.ValidationError{Problems: []string{fmt.Sprintf("condition on %q has an empty value", field)}}
+	}
+	if numeric {
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", &models.ValidationError{Problems: []string{fmt.Sprintf("%q is not a valid number for %q", value, field)}}
+		}
+	}
+
+	escaped := ldap.EscapeFilter(value)
+	switch match {
+	case models.MatchPrefix:
+		return fmt.Sprintf("(%s=%s*)", attr, escaped), nil
+	case models.MatchSubstring:
+		return fmt.Sprintf("(%s=*%s*)", attr, escaped), nil
+	}
+
+	// Entries without accountStatus are active
+	if attr == accountStatusAttr && strings.EqualFold(value, models.UserStatusActive) {
+		return activeAccountFilter, nil
+	}
+	return fmt.Sprintf("(%s=%s)", attr, escaped), nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// DIRECTORY SEARCH
+// ═══════════════════════════════════════════════════════════════════════════
+
+// searchField is an attribute searched by SearchDirectory, with the weight
+// of a match on it
+type searchField struct {
+	attr   string
+	weight int
+}
+
+var (
+	userSearchFields = []searchField{
+		{attr: "uid", weight: 3}, {attr: "cn", weight: 3}, {attr: "mail", weight: 2},
+		{attr: "givenName", weight: 1}, {attr: "sn", weight: 1},
+	}
+	groupSearchFields      = []searchField{{attr: "cn", weight: 3}, {attr: "description", weight: 1}}
+	departmentSearchFields = []searchField{{attr: "ou", weight: 3}, {attr: "description", weight: 1}}
+)
+
+// Scores of how well a search term matches a value
+const (
+	scoreExact      = 10
+	scorePrefix     = 6
+	scoreWordPrefix = 4
+	scoreSubstring  = 2
+)
+
+// directoryKindOrder breaks ties between results of equal score
+var directoryKindOrder = map[string]int{
+	models.DirectoryKindUser:       0,
+	models.DirectoryKindGroup:      1,
+	models.DirectoryKindDepartment: 2,
+}
+
+// SearchDirectory looks up users, groups and departments matching every
+// whitespace-separated term of query and returns them ranked together:
+// exact matches before prefix matches before substring matches, and
+// matches on names before matches on descriptions.
+func (m *Manager) SearchDirectory(ctx context.Context, query string, limit int) ([]*models.DirectorySearchResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, &models.ValidationError{Problems: []string{"search query is empty"}}
+	}
+	if len(terms) > maxDirectorySearchTerms {
+		return nil, &models.ValidationError{Problems: []string{fmt.Sprintf("search queries may have at most %d terms", maxDirectorySearchTerms)}}
+	}
+	if limit <= 0 {
+		limit = defaultDirectorySearchLimit
+	}
+	if limit > maxDirectorySearchLimit {
+		limit = maxDirectorySearchLimit
+	}
+
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	var results []*models.DirectorySearchResult
+
+	err = m.searchKind(conn, m.config.UsersDN(), "inetOrgPerson", userSearchFields, terms, m.userSearchAttributes(),
+		func(entry *ldap.Entry, score int) {
+			user := m.entryToUser(entry)
+			results = append(results, &models.DirectorySearchResult{
+				Kind: models.DirectoryKindUser, ID: user.UID, Title: firstNonEmpty(user.CN, user.UID),
+				Subtitle: user.Mail, Score: score, User: user,
+			})
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	err = m.searchKind(conn, m.config.GroupsDN(), "groupOfNames", groupSearchFields, terms,
+		groupAttributes,
+		func(entry *ldap.Entry, score int) {
+			group := m.entryToGroup(entry)
+			results = append(results, &models.DirectorySearchResult{
+				Kind: models.DirectoryKindGroup, ID: group.CN, Title: group.CN,
+				Subtitle: group.Description, Score: score, Group: group,
+			})
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search groups: %w", err)
+	}
+
+	err = m.searchKind(conn, m.config.DepartmentsDN(), "organizationalUnit", departmentSearchFields, terms, departmentAttributes,
+		func(entry *ldap.Entry, score int) {
+			dept := m.entryToDepartment(entry)
+			results = append(results, &models.DirectorySearchResult{
+				Kind: models.DirectoryKindDepartment, ID: dept.OU, Title: dept.OU,
+				Subtitle: dept.Description, Score: score, Department: dept,
+			})
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search departments: %w", err)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Kind != b.Kind {
+			return directoryKindOrder[a.Kind] < directoryKindOrder[b.Kind]
+		}
+		return a.ID < b.ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	// Members are only looked up for the departments returned
+	for _, result := range results {
+		if result.Department == nil {
+			continue
+		}
+		if members, err := m.departmentMembers(conn, result.Department.OU); err == nil {
+			result.Department.Members = members
+		}
+	}
+
+	return results, nil
+}
+
+// searchKind searches baseDN for entries of objectClass in which every
+// term matches one of fields, and passes each with its score to fn
+func (m *Manager) searchKind(conn *ldap.Conn, baseDN, objectClass string, fields []searchField, terms []string, attrs []string, fn func(*ldap.Entry, int)) error {
+	filters := []string{fmt.Sprintf("(objectClass=%s)", objectClass)}
+	for _, term := range terms {
+		escaped := ldap.EscapeFilter(term)
+		var alternatives []string
+		for _, f := range fields {
+			alternatives = append(alternatives, fmt.Sprintf("(%s=*%s*)", f.attr, escaped))
+		}
+		filters = append(filters, "(|"+strings.Join(alternatives, "")+")")
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		"(&"+strings.Join(filters, "")+")",
+		attrs,
+		nil,
+	)
+
+	return m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		fn(entry, scoreEntry(entry, fields, terms))
+	})
+}
+
+// scoreEntry adds up, for every term, its best weighted match on the entry
+func scoreEntry(entry *ldap.Entry, fields []searchField, terms []string) int {
+	total := 0
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		best := 0
+		for _, f := range fields {
+			for _, value := range entry.GetAttributeValues(f.attr) {
+				if score := f.weight * scoreMatch(strings.ToLower(value), term); score > best {
+					best = score
+				}
+			}
+		}
+		total += best
+	}
+	return total
+}
+
+// scoreMatch rates how well term matches value; both are lower case
+func scoreMatch(value, term string) int {
+	switch {
+	case value == term:
+		return scoreExact
+	case strings.HasPrefix(value, term):
+		return scorePrefix
+	}
+	for i := strings.Index(value, term); i >= 0; {
+		if strings.ContainsRune(" .-_@", rune(value[i-1])) {
+			return scoreWordPrefix
+		}
+		next := strings.Index(value[i+1:], term)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	if strings.Contains(value, term) {
+		return scoreSubstring
+	}
+	return 0
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+func TestCompileUserExpression(t *testing.T) {
+	m := &Manager{config: &config.Config{UserAttributes: []models.AttributeDefinition{
+		{Field: "phone", LDAPAttribute: "telephoneNumber"},
+		{Field: "floor", LDAPAttribute: "roomFloor", Type: models.AttributeTypeInt},
+	}}}
+
+	cond := func(field, match string, values ...string) models.FilterCondition {
+		return models.FilterCondition{Field: field, Match: match, Values: values}
+	}
+
+	tooMany := make([]string, maxFilterConditions+1)
+	for i := range tooMany {
+		tooMany[i] = "x"
+	}
+	deep := &models.FilterExpression{Conditions: []models.FilterCondition{cond("uid", models.MatchExact, "a")}}
+	for i := 0; i < maxFilterDepth; i++ {
+		deep = &models.FilterExpression{Groups: []*models.FilterExpression{deep}}
+	}
+
+	tests := []struct {
+		name    string
+		expr    *models.FilterExpression
+		want    string
+		wantErr string
+	}{
+		{
+			name: "nil expression",
+		},
+		{
+			name: "empty expression",
+			expr: &models.FilterExpression{},
+		},
+		{
+			name: "substring is the default match",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{cond("cn", "", "ann")}},
+			want: "(cn=*ann*)",
+		},
+		{
+			name: "numeric fields default to exact",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{cond("uidNumber", "", "10001")}},
+			want: "(uidNumber=10001)",
+		},
+		{
+			name: "several values match any of them",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{cond("department", "exact", "eng", "ops")}},
+			want: "(|(departmentNumber=eng)(departmentNumber=ops))",
+		},
+		{
+			name: "conditions are ANDed by default",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{
+				cond("uid", models.MatchPrefix, "a"),
+				cond("mail", models.MatchPresent),
+			}},
+			want: "(&(uid=a*)(mail=*))",
+		},
+		{
+			name: "nested groups and negation",
+			expr: &models.FilterExpression{
+				Op:         models.LogicalOr,
+				Conditions: []models.FilterCondition{{Field: "sn", Match: models.MatchExact, Values: []string{"smith"}, Not: true}},
+				Groups: []*models.FilterExpression{{
+					Not:        true,
+					Conditions: []models.FilterCondition{cond("repository", models.MatchSubstring, "infra")},
+				}},
+			},
+			want: "(|(!(sn=smith))(!(githubRepository=*infra*)))",
+		},
+		{
+			name: "values are escaped",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{cond("cn", models.MatchExact, "a*)(uid=*")}},
+			want: `(cn=a\2a\29\28uid=\2a)`,
+		},
+		{
+			name: "active status includes entries without a status",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{cond("status", models.MatchExact, "active")}},
+			want: activeAccountFilter,
+		},
+		{
+			name: "custom attributes",
+			expr: &models.FilterExpression{Conditions: []models.FilterCondition{
+				cond("phone", models.MatchPrefix, "+49"),
+				cond("floor", "", "3"),
+			}},
+			want: "(&(telephoneNumber=+49*)(roomFloor=3))",
+		},
+		{
+			name:    "unknown field",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("salary", "", "1")}},
+			wantErr: `unknown user field "salary"`,
+		},
+		{
+			name:    "unknown operator",
+			expr:    &models.FilterExpression{Op: "XOR", Conditions: []models.FilterCondition{cond("uid", "", "a")}},
+			wantErr: `unknown logical operator "XOR"`,
+		},
+		{
+			name:    "unknown match",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("uid", "REGEX", "a")}},
+			wantErr: `unknown match operator "REGEX"`,
+		},
+		{
+			name:    "missing values",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("uid", models.MatchExact)}},
+			wantErr: `condition on "uid" needs at least one value`,
+		},
+		{
+			name:    "empty value",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("uid", models.MatchExact, "")}},
+			wantErr: `condition on "uid" has an empty value`,
+		},
+		{
+			name:    "substring on a numeric field",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("gidNumber", models.MatchSubstring, "1")}},
+			wantErr: `"gidNumber" is numeric and only supports EXACT matches`,
+		},
+		{
+			name:    "non-numeric value for a numeric field",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("floor", "", "three")}},
+			wantErr: `"three" is not a valid number for "floor"`,
+		},
+		{
+			name:    "negated empty expression",
+			expr:    &models.FilterExpression{Not: true},
+			wantErr: "cannot negate an empty filter expression",
+		},
+		{
+			name:    "too deep",
+			expr:    deep,
+			wantErr: "nested at most 5 levels deep",
+		},
+		{
+			name:    "too many values",
+			expr:    &models.FilterExpression{Conditions: []models.FilterCondition{cond("uid", "", tooMany...)}},
+			wantErr: "may test at most 50 values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.compileUserExpression(tt.expr)
+			if tt.wantErr != "" {
+				var validation *models.ValidationError
+				if !errors.As(err, &validation) || !strings.Contains(validation.Error(), tt.wantErr) {
+					t.Fatalf("compileUserExpression() error = %v, want a ValidationError containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileUserExpression() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("compileUserExpression() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoreMatch(t *testing.T) {
+	tests := []struct {
+		value string
+		term  string
+		want  int
+	}{
+		{"alice", "alice", scoreExact},
+		{"alice", "ali", scorePrefix},
+		{"alice smith", "smi", scoreWordPrefix},
+		{"alice.smith@example.com", "smith", scoreWordPrefix},
+		{"mary-ann", "ann", scoreWordPrefix},
+		{"joanna", "ann", scoreSubstring},
+		{"annann ann", "ann", scorePrefix},
+		{"banana ann", "ann", scoreWordPrefix},
+		{"bob", "alice", 0},
+	}
+
+	for _, tt := range tests {
+		if got := scoreMatch(tt.value, tt.term); got != tt.want {
+			t.Errorf("scoreMatch(%q, %q) = %d, want %d", tt.value, tt.term, got, tt.want)
+		}
+	}
+}
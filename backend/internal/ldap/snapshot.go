@@ -0,0 +1,100 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// hierarchySnapshot holds the group graph and department tree for the
+// lifetime of one request, plus the effective access already resolved from
+// them. Listing users with their effective fields then loads the hierarchy
+// once instead of once per user and field.
+type hierarchySnapshot struct {
+	mu     sync.Mutex
+	graph  groupGraph
+	tree   map[string]*departmentNode
+	access map[string]*models.EffectiveAccess
+}
+
+type snapshotKey struct{}
+
+// WithHierarchySnapshot returns a context under which GetEffectiveAccess
+// loads the group and department hierarchy at most once and remembers each
+// user's result. Any write made with the context drops the snapshot, so
+// reads after a mutation see its effect.
+func WithHierarchySnapshot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, &hierarchySnapshot{})
+}
+
+// snapshotFrom returns the request's snapshot, or nil outside
+// WithHierarchySnapshot. The methods below accept a nil snapshot.
+func snapshotFrom(ctx context.Context) *hierarchySnapshot {
+	snapshot, _ := ctx.Value(snapshotKey{}).(*hierarchySnapshot)
+	return snapshot
+}
+
+func (s *hierarchySnapshot) cachedAccess(uid string) *models.EffectiveAccess {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.access[uid]
+}
+
+func (s *hierarchySnapshot) storeAccess(uid string, access *models.EffectiveAccess) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.access == nil {
+		s.access = make(map[string]*models.EffectiveAccess)
+	}
+	s.access[uid] = access
+}
+
+// reset forgets everything loaded so far
+func (s *hierarchySnapshot) reset() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graph, s.tree, s.access = nil, nil, nil
+}
+
+// loadHierarchy returns the group graph and department tree, from the
+// snapshot when there is one
+func (m *Manager) loadHierarchy(ctx context.Context, snapshot *hierarchySnapshot) (groupGraph, map[string]*departmentNode, error) {
+	if snapshot != nil {
+		snapshot.mu.Lock()
+		defer snapshot.mu.Unlock()
+		if snapshot.graph != nil && snapshot.tree != nil {
+			return snapshot.graph, snapshot.tree, nil
+		}
+	}
+
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	graph, err := m.loadGroupGraph(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := m.loadDepartmentTree(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if snapshot != nil {
+		snapshot.graph, snapshot.tree = graph, tree
+	}
+	return graph, tree, nil
+}
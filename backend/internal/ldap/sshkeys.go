@@ -0,0 +1,248 @@
+package ldap
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshPublicKeyAttr holds a user's keys, one authorized_keys line per value
+// (openssh-lpk schema, read by Gitea and sssd)
+const sshPublicKeyAttr = "sshPublicKey"
+
+// minRSAKeyBits is the smallest RSA modulus accepted
+const minRSAKeyBits = 2048
+
+// ListSSHKeys returns the SSH public keys registered for a user
+func (m *Manager) ListSSHKeys(ctx context.Context, uid string) ([]*models.SSHKey, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	return m.userSSHKeys(conn, uid)
+}
+
+// AddSSHKey validates an authorized_keys line and adds it to a user. A key
+// is registered to at most one user, so the same fingerprint is refused
+// anywhere in the directory.
+func (m *Manager) AddSSHKey(ctx context.Context, uid, authorizedKey string) (*models.SSHKey, error) {
+	key, err := parseSSHKey(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	if _, err := m.userSSHKeys(conn, uid); err != nil {
+		return nil, err
+	}
+
+	owner, err := m.sshKeyOwner(conn, key.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if owner == uid {
+		return nil, fmt.Errorf("%w: %s is already registered to %s", models.ErrSSHKeyExists, key.Fingerprint, uid)
+	}
+	if owner != "" {
+		return nil, fmt.Errorf("%w: %s belongs to another user", models.ErrSSHKeyExists, key.Fingerprint)
+	}
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Add(sshPublicKeyAttr, []string{key.Key})
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) {
+			return nil, fmt.Errorf("%w: %s", models.ErrSSHKeyExists, key.Fingerprint)
+		}
+		return nil, fmt.Errorf("failed to add SSH key: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{"uid": uid, "fingerprint": key.Fingerprint}).Info("SSH key added")
+	return key, nil
+}
+
+// RemoveSSHKey removes the key with the given SHA256 fingerprint from a user
+func (m *Manager) RemoveSSHKey(ctx context.Context, uid, fingerprint string) error {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	keys, err := m.userSSHKeys(conn, uid)
+	if err != nil {
+		return err
+	}
+
+	fingerprint = normalizeFingerprint(fingerprint)
+	var values []string
+	for _, key := range keys {
+		if key.Fingerprint == fingerprint {
+			values = append(values, key.Key)
+		}
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("%w: %s", models.ErrSSHKeyNotFound, fingerprint)
+	}
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Delete(sshPublicKeyAttr, values)
+	if err := m.modifyUser(conn, uid, modifyRequest); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			return fmt.Errorf("%w: %s", models.ErrSSHKeyNotFound, fingerprint)
+		}
+		return fmt.Errorf("failed to remove SSH key: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{"uid": uid, "fingerprint": fingerprint}).Info("SSH key removed")
+	return nil
+}
+
+// userSSHKeys reads the keys on a user's entry. Values that no longer parse,
+// e.g. written by another tool, are skipped with a warning.
+func (m *Manager) userSSHKeys(conn *ldap.Conn, uid string) ([]*models.SSHKey, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(uid=%s))", ldap.EscapeFilter(uid)),
+		[]string{"uid", sshPublicKeyAttr},
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", models.ErrUserNotFound, uid)
+	}
+
+	keys := []*models.SSHKey{}
+	for _, value := range result.Entries[0].GetAttributeValues(sshPublicKeyAttr) {
+		key, err := parseSSHKey(value)
+		if err != nil {
+			m.logger.WithError(err).WithField("uid", uid).Warn("Ignoring unparseable SSH key")
+			continue
+		}
+		key.Key = value
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sshKeyOwner returns the uid of the user holding the key with the given
+// fingerprint, or "" if nobody does. Values are compared by fingerprint
+// because the same key can be stored with different comments.
+func (m *Manager) sshKeyOwner(conn *ldap.Conn, fingerprint string) (string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(%s=*))", sshPublicKeyAttr),
+		[]string{"uid", sshPublicKeyAttr},
+		nil,
+	)
+
+	var owner string
+	err := m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		if owner != "" {
+			return
+		}
+		for _, value := range entry.GetAttributeValues(sshPublicKeyAttr) {
+			if key, err := parseSSHKey(value); err == nil && key.Fingerprint == fingerprint {
+				owner = entry.GetAttributeValue("uid")
+				return
+			}
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to search SSH keys: %w", err)
+	}
+	return owner, nil
+}
+
+// parseSSHKey parses a single authorized_keys line. Keys with options, DSA
+// keys and RSA keys shorter than minRSAKeyBits are refused. The returned
+// Key is normalized to "type base64 comment".
+func parseSSHKey(line string) (*models.SSHKey, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, &models.ValidationError{Problems: []string{"SSH key is empty"}}
+	}
+	if strings.ContainsAny(line, "\r\n") {
+		return nil, &models.ValidationError{Problems: []string{"SSH key must be a single line"}}
+	}
+
+	publicKey, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, &models.ValidationError{Problems: []string{"not a valid OpenSSH public key: " + err.Error()}}
+	}
+	if len(options) > 0 {
+		return nil, &models.ValidationError{Problems: []string{"SSH key options such as " + options[0] + " are not allowed"}}
+	}
+	if len(rest) > 0 {
+		return nil, &models.ValidationError{Problems: []string{"only one SSH key may be given"}}
+	}
+
+	key := &models.SSHKey{
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+		Type:        publicKey.Type(),
+		Comment:     comment,
+	}
+
+	switch key.Type {
+	case ssh.KeyAlgoDSA:
+		return nil, &models.ValidationError{Problems: []string{"DSA keys are not accepted"}}
+	case ssh.KeyAlgoRSA:
+		cryptoKey, ok := publicKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, &models.ValidationError{Problems: []string{"unreadable RSA key"}}
+		}
+		rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok {
+			return nil, &models.ValidationError{Problems: []string{"unreadable RSA key"}}
+		}
+		key.Bits = rsaKey.N.BitLen()
+		if key.Bits < minRSAKeyBits {
+			return nil, &models.ValidationError{Problems: []string{fmt.Sprintf("RSA keys must be at least %d bits, got %d", minRSAKeyBits, key.Bits)}}
+		}
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519:
+		key.Bits = 256
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoSKECDSA256:
+		key.Bits = 256
+	case ssh.KeyAlgoECDSA384:
+		key.Bits = 384
+	case ssh.KeyAlgoECDSA521:
+		key.Bits = 521
+	default:
+		return nil, &models.ValidationError{Problems: []string{fmt.Sprintf("unsupported SSH key type %q", key.Type)}}
+	}
+
+	key.Key = strings.TrimSpace(strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(publicKey)), "\n") + " " + comment)
+	return key, nil
+}
+
+// normalizeFingerprint accepts fingerprints with or without the SHA256:
+// prefix and with base64 padding
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimRight(strings.TrimSpace(fingerprint), "=")
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		fingerprint = "SHA256:" + fingerprint
+	}
+	return fingerprint
+}
@@ -0,0 +1,169 @@
+package ldap
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// authorizedKey returns the authorized_keys line of a public key, without
+// the trailing newline
+func authorizedKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	publicKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatalf("ssh.NewPublicKey() error = %v", err)
+	}
+	return strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(publicKey)), "\n")
+}
+
+func TestParseSSHKey(t *testing.T) {
+	ed25519Key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public()
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsa384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dsaKey dsa.PrivateKey
+	if err := dsa.GenerateParameters(&dsaKey.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsa.GenerateKey(&dsaKey, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	ed25519Line := authorizedKey(t, ed25519Key)
+
+	tests := []struct {
+		name     string
+		line     string
+		wantType string
+		wantBits int
+		wantKey  string
+		wantErr  string
+	}{
+		{
+			name:     "ed25519 with comment",
+			line:     ed25519Line + " alice@laptop",
+			wantType: ssh.KeyAlgoED25519,
+			wantBits: 256,
+			wantKey:  ed25519Line + " alice@laptop",
+		},
+		{
+			name:     "surrounding whitespace is trimmed",
+			line:     "  " + ed25519Line + "\t",
+			wantType: ssh.KeyAlgoED25519,
+			wantBits: 256,
+			wantKey:  ed25519Line,
+		},
+		{
+			name:     "rsa 2048",
+			line:     authorizedKey(t, &rsa2048.PublicKey),
+			wantType: ssh.KeyAlgoRSA,
+			wantBits: 2048,
+		},
+		{
+			name:     "ecdsa p-384",
+			line:     authorizedKey(t, &ecdsa384.PublicKey),
+			wantType: ssh.KeyAlgoECDSA384,
+			wantBits: 384,
+		},
+		{
+			name:    "empty",
+			line:    "   ",
+			wantErr: "SSH key is empty",
+		},
+		{
+			name:    "several lines",
+			line:    ed25519Line + "\n" + ed25519Line,
+			wantErr: "SSH key must be a single line",
+		},
+		{
+			name:    "garbage",
+			line:    "ssh-ed25519 not-base64",
+			wantErr: "not a valid OpenSSH public key",
+		},
+		{
+			name:    "options",
+			line:    `command="/bin/true" ` + ed25519Line,
+			wantErr: "SSH key options such as command=\"/bin/true\" are not allowed",
+		},
+		{
+			name:    "short rsa",
+			line:    authorizedKey(t, &rsa1024.PublicKey),
+			wantErr: "RSA keys must be at least 2048 bits, got 1024",
+		},
+		{
+			name:    "dsa",
+			line:    authorizedKey(t, &dsaKey.PublicKey),
+			wantErr: "DSA keys are not accepted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseSSHKey(tt.line)
+			if tt.wantErr != "" {
+				var validation *models.ValidationError
+				if !errors.As(err, &validation) {
+					t.Fatalf("parseSSHKey() error = %v, want a ValidationError", err)
+				}
+				if !strings.Contains(validation.Error(), tt.wantErr) {
+					t.Errorf("parseSSHKey() error = %q, want %q", validation.Error(), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSSHKey() error = %v", err)
+			}
+			if key.Type != tt.wantType || key.Bits != tt.wantBits {
+				t.Errorf("parseSSHKey() = %s/%d, want %s/%d", key.Type, key.Bits, tt.wantType, tt.wantBits)
+			}
+			if tt.wantKey != "" && key.Key != tt.wantKey {
+				t.Errorf("Key = %q, want %q", key.Key, tt.wantKey)
+			}
+			if !strings.HasPrefix(key.Fingerprint, "SHA256:") {
+				t.Errorf("Fingerprint = %q, want a SHA256 fingerprint", key.Fingerprint)
+			}
+		})
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	const want = "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"
+
+	tests := []struct {
+		name        string
+		fingerprint string
+	}{
+		{"canonical", want},
+		{"without prefix", "uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"},
+		{"with padding", want + "="},
+		{"with whitespace", "  " + want + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeFingerprint(tt.fingerprint); got != want {
+				t.Errorf("normalizeFingerprint(%q) = %q, want %q", tt.fingerprint, got, want)
+			}
+		})
+	}
+}
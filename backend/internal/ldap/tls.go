@@ -0,0 +1,28 @@
+package ldap
+
+import "time"
+
+// tlsReloadLoop checks the TLS certificate files every interval until the
+// pool is closed. Once they change, new connections use the new material
+// and pooled connections are replaced as they are next checked out,
+// returned or reaped.
+func (m *Manager) tlsReloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopReaper:
+			return
+		case <-ticker.C:
+			reloaded, err := m.tls.Reload()
+			if err != nil {
+				m.logger.WithError(err).Warn("Failed to reload LDAP TLS certificates, keeping the current ones")
+				continue
+			}
+			if reloaded {
+				m.logger.WithField("generation", m.tls.Generation()).Info("LDAP TLS certificates reloaded")
+			}
+		}
+	}
+}
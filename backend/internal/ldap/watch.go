@@ -0,0 +1,452 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Sources for the directory watcher (EVENTS_SOURCE)
+const (
+	WatchSourceAuto     = "auto"
+	WatchSourceSyncrepl = "syncrepl"
+	WatchSourcePoll     = "poll"
+)
+
+// watchFilter selects the entries the watcher reports on; anything that is
+// not directly below the users, groups or departments OU is dropped later
+const watchFilter = "(|(objectClass=inetOrgPerson)(objectClass=groupOfNames)(objectClass=organizationalUnit))"
+
+// Operational attributes the watcher needs but never reports
+const (
+	entryUUIDAttr       = "entryUUID"
+	entryCSNAttr        = "entryCSN"
+	modifyTimestampAttr = "modifyTimestamp"
+)
+
+const (
+	watchMinBackoff = time.Second
+	watchMaxBackoff = time.Minute
+)
+
+// watchedEntry is the last known state of one entry, keyed by entryUUID
+type watchedEntry struct {
+	dn    string
+	csn   string
+	attrs map[string][]string
+}
+
+// watcher keeps the snapshot that changes are diffed against. It survives
+// reconnects, so a resync after an outage reports only what changed.
+type watcher struct {
+	m        *Manager
+	fn       func(*models.DirectoryChange)
+	snapshot map[string]*watchedEntry
+	primed   bool
+	logger   *logrus.Entry
+}
+
+// Watch reports every change to users, groups and departments to fn until
+// ctx is done. source is one of the WatchSource constants: syncrepl runs an
+// RFC 4533 refreshAndPersist search (the server needs the syncprov
+// overlay), poll searches for modifyTimestamp changes every pollInterval,
+// and auto uses syncrepl unless the server refuses it.
+//
+// The first load of the directory only primes the snapshot. After a lost
+// connection the directory is reloaded and compared with the snapshot, so
+// changes made in the meantime are still reported, at most once each.
+func (m *Manager) Watch(ctx context.Context, source string, pollInterval time.Duration, fn func(*models.DirectoryChange)) {
+	w := &watcher{
+		m:        m,
+		fn:       fn,
+		snapshot: make(map[string]*watchedEntry),
+		logger:   m.logger.WithField("component", "watcher"),
+	}
+
+	backoff := watchMinBackoff
+	for ctx.Err() == nil {
+		started := time.Now()
+		w.logger.WithField("source", source).Info("Watching directory for changes")
+
+		var err error
+		if source == WatchSourcePoll {
+			err = w.poll(ctx, pollInterval)
+		} else {
+			err = w.syncrepl(ctx)
+			if source == WatchSourceAuto && isSyncreplRefused(err) {
+				w.logger.WithError(err).Warn("Syncrepl not available, falling back to modifyTimestamp polling")
+				source = WatchSourcePoll
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > watchMaxBackoff {
+			backoff = watchMinBackoff
+		}
+		w.logger.WithError(err).WithField("retryIn", backoff).Warn("Directory watch interrupted")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > watchMaxBackoff {
+			backoff = watchMaxBackoff
+		}
+	}
+}
+
+// watchAttributes are requested for every watched entry
+func (w *watcher) watchAttributes() []string {
+	attrs := []string{"objectClass", entryUUIDAttr, entryCSNAttr, modifyTimestampAttr, "member"}
+	seen := make(map[string]bool)
+	for _, attr := range append(append(w.m.userSearchAttributes(), departmentAttributes...), "gidNumber", "description", sshPublicKeyAttr) {
+		if !seen[attr] {
+			seen[attr] = true
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// SYNCREPL
+// ═══════════════════════════════════════════════════════════════════════════
+
+// syncrepl runs one refreshAndPersist session on a dedicated connection.
+// It only returns on error or when ctx is done.
+func (w *watcher) syncrepl(ctx context.Context) error {
+	conn, err := w.m.dedicatedConnection()
+	if err != nil {
+		return err
+	}
+
+	// The search only checks ctx between messages; closing the connection
+	// is what unblocks it while it waits for the next change
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-runCtx.Done()
+		conn.Close()
+	}()
+
+	searchRequest := ldap.NewSearchRequest(
+		w.m.config.LDAPBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		watchFilter,
+		w.watchAttributes(),
+		nil,
+	)
+	response := conn.Syncrepl(runCtx, searchRequest, 64, ldap.SyncRequestModeRefreshAndPersist, nil, false)
+
+	// Entries seen during the refresh phase; whatever is left in the
+	// snapshot when it ends was deleted while we were not listening
+	refreshing := true
+	seen := make(map[string]bool)
+
+	for response.Next() {
+		if entry := response.Entry(); entry != nil {
+			state, ok := ldap.FindControl(response.Controls(), ldap.ControlTypeSyncState).(*ldap.ControlSyncState)
+			if !ok {
+				continue
+			}
+			uuid := state.EntryUUID.String()
+			if refreshing {
+				seen[uuid] = true
+			}
+			if state.State == ldap.SyncStateDelete {
+				w.remove(uuid)
+				continue
+			}
+			if state.State != ldap.SyncStatePresent {
+				w.update(uuid, entry, refreshing && !w.primed)
+			}
+			continue
+		}
+
+		info, ok := ldap.FindControl(response.Controls(), ldap.ControlTypeSyncInfo).(*ldap.ControlSyncInfo)
+		if !ok || !refreshing || !refreshDone(info) {
+			continue
+		}
+		if w.primed {
+			for uuid := range w.snapshot {
+				if !seen[uuid] {
+					w.remove(uuid)
+				}
+			}
+		}
+		refreshing = false
+		w.primed = true
+		w.logger.WithField("entries", len(w.snapshot)).Info("Syncrepl refresh complete, listening for changes")
+	}
+
+	if err := response.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("syncrepl search ended")
+}
+
+// refreshDone reports whether a syncInfo message ends the refresh phase
+func refreshDone(info *ldap.ControlSyncInfo) bool {
+	switch {
+	case info.RefreshDelete != nil:
+		return info.RefreshDelete.RefreshDone
+	case info.RefreshPresent != nil:
+		return info.RefreshPresent.RefreshDone
+	}
+	return false
+}
+
+// isSyncreplRefused reports whether the server does not offer syncrepl
+func isSyncreplRefused(err error) bool {
+	return ldap.IsErrorAnyOf(err, ldap.LDAPResultUnavailableCriticalExtension, ldap.LDAPResultUnwillingToPerform)
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// MODIFYTIMESTAMP POLLER
+// ═══════════════════════════════════════════════════════════════════════════
+
+// poll compares the directory with the snapshot every interval. Changed
+// entries are found by modifyTimestamp; deletions by listing entryUUIDs.
+func (w *watcher) poll(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	since, err := w.load(ctx)
+	if err != nil {
+		return err
+	}
+	w.logger.WithFields(logrus.Fields{"entries": len(w.snapshot), "interval": interval}).Info("Polling directory for changes")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if since, err = w.pollOnce(ctx, since); err != nil {
+			return err
+		}
+	}
+}
+
+// load reads every watched entry into the snapshot and returns the newest
+// modifyTimestamp seen
+func (w *watcher) load(ctx context.Context) (string, error) {
+	seen := make(map[string]bool)
+	since, err := w.search(ctx, watchFilter, w.watchAttributes(), func(uuid string, entry *ldap.Entry) {
+		seen[uuid] = true
+		w.update(uuid, entry, !w.primed)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if w.primed {
+		for uuid := range w.snapshot {
+			if !seen[uuid] {
+				w.remove(uuid)
+			}
+		}
+	}
+	w.primed = true
+	return since, nil
+}
+
+// pollOnce reports the entries modified at or after since and the entries
+// gone from the directory, and returns the new high-water mark
+func (w *watcher) pollOnce(ctx context.Context, since string) (string, error) {
+	filter := watchFilter
+	if since != "" {
+		filter = fmt.Sprintf("(&%s(%s>=%s))", watchFilter, modifyTimestampAttr, ldap.EscapeFilter(since))
+	}
+
+	latest, err := w.search(ctx, filter, w.watchAttributes(), func(uuid string, entry *ldap.Entry) {
+		w.update(uuid, entry, false)
+	})
+	if err != nil {
+		return since, err
+	}
+	if latest < since {
+		latest = since
+	}
+
+	present := make(map[string]bool, len(w.snapshot))
+	if _, err := w.search(ctx, watchFilter, []string{entryUUIDAttr}, func(uuid string, _ *ldap.Entry) {
+		present[uuid] = true
+	}); err != nil {
+		return latest, err
+	}
+	for uuid := range w.snapshot {
+		if !present[uuid] {
+			w.remove(uuid)
+		}
+	}
+
+	return latest, nil
+}
+
+// search runs a paged subtree search below the base DN, handing fn each
+// entry with its entryUUID, and returns the newest modifyTimestamp seen
+func (w *watcher) search(ctx context.Context, filter string, attributes []string, fn func(string, *ldap.Entry)) (string, error) {
+	conn, err := w.m.getProviderConnection(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer w.m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		w.m.config.LDAPBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	// GeneralizedTime in the same format compares correctly as a string
+	var latest string
+	err = w.m.pagedSearch(conn, searchRequest, "", func(entry *ldap.Entry) {
+		uuid := entry.GetAttributeValue(entryUUIDAttr)
+		if uuid == "" {
+			return
+		}
+		if ts := entry.GetAttributeValue(modifyTimestampAttr); ts > latest {
+			latest = ts
+		}
+		fn(strings.ToLower(uuid), entry)
+	})
+	if err != nil {
+		return "", fmt.Errorf("search failed: %w", err)
+	}
+	return latest, nil
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// SNAPSHOT
+// ═══════════════════════════════════════════════════════════════════════════
+
+// update stores the new state of an entry and reports the change, unless
+// quiet is set or nothing we report on changed
+func (w *watcher) update(uuid string, entry *ldap.Entry, quiet bool) {
+	next := &watchedEntry{
+		dn:    entry.DN,
+		csn:   entry.GetAttributeValue(entryCSNAttr),
+		attrs: reportedAttributes(entry),
+	}
+	prev := w.snapshot[uuid]
+	w.snapshot[uuid] = next
+
+	if quiet {
+		return
+	}
+	if prev != nil && prev.csn != "" && prev.csn == next.csn {
+		return
+	}
+
+	// A rename is reported as the old entry going away and a new one appearing
+	if prev != nil && !strings.EqualFold(prev.dn, next.dn) {
+		w.emit(uuid, next.csn, prev, nil)
+		prev = nil
+	}
+	w.emit(uuid, next.csn, prev, next)
+}
+
+// remove drops an entry from the snapshot and reports its deletion
+func (w *watcher) remove(uuid string) {
+	prev, ok := w.snapshot[uuid]
+	if !ok {
+		return
+	}
+	delete(w.snapshot, uuid)
+	w.emit(uuid, prev.csn, prev, nil)
+}
+
+// emit hands a change to the callback if the entry is a user, group or
+// department
+func (w *watcher) emit(uuid, csn string, before, after *watchedEntry) {
+	dn := ""
+	change := &models.DirectoryChange{EntryUUID: uuid, CSN: csn}
+	if before != nil {
+		dn = before.dn
+		change.Before = before.attrs
+	}
+	if after != nil {
+		dn = after.dn
+		change.After = after.attrs
+	}
+
+	entity, key, ok := w.classify(dn)
+	if !ok {
+		return
+	}
+	change.Entity = entity
+	change.Key = key
+	change.DN = dn
+
+	w.logger.WithFields(logrus.Fields{
+		"entity": entity,
+		"key":    key,
+		"added":  before == nil,
+		"gone":   after == nil,
+	}).Debug("Directory change observed")
+	w.fn(change)
+}
+
+// classify maps a DN to the entity it represents and its key, based on
+// the OU it sits directly under
+func (w *watcher) classify(dn string) (string, string, bool) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) < 2 || len(parsed.RDNs[0].Attributes) != 1 {
+		return "", "", false
+	}
+	rdn := parsed.RDNs[0].Attributes[0]
+	parent := &ldap.DN{RDNs: parsed.RDNs[1:]}
+
+	containers := []struct {
+		base   string
+		entity string
+		attr   string
+	}{
+		{w.m.config.UsersDN(), models.EntityUser, "uid"},
+		{w.m.config.GroupsDN(), models.EntityGroup, "cn"},
+		{w.m.config.DepartmentsDN(), models.EntityDepartment, "ou"},
+	}
+	for _, c := range containers {
+		base, err := ldap.ParseDN(c.base)
+		if err != nil || !parent.EqualFold(base) {
+			continue
+		}
+		if !strings.EqualFold(rdn.Type, c.attr) {
+			return "", "", false
+		}
+		return c.entity, rdn.Value, true
+	}
+	return "", "", false
+}
+
+// reportedAttributes returns the entry's attributes without the
+// operational ones the watcher uses for bookkeeping
+func reportedAttributes(entry *ldap.Entry) map[string][]string {
+	attrs := make(map[string][]string, len(entry.Attributes))
+	for _, attr := range entry.Attributes {
+		switch strings.ToLower(attr.Name) {
+		case "objectclass", "entryuuid", "entrycsn", "modifytimestamp":
+			continue
+		}
+		attrs[attr.Name] = attr.Values
+	}
+	return attrs
+}
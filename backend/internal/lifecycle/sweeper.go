@@ -0,0 +1,102 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/auth"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Actor is the audit actor recorded for changes made by the sweeper
+const Actor = "lifecycle-sweeper"
+
+// Sweeper periodically disables users past their expiry, deletes
+// deprovisioned users whose grace period is over and revokes repository
+// grants that have expired, such as those approved access requests gave.
+// It works through the LDAP interface, so every change is metered and
+// audited like any other.
+//
+// Every replica runs a sweeper; the operations are idempotent and each
+// user is re-checked before deletion, so concurrent sweeps are harmless.
+type Sweeper struct {
+	ldapMgr  prometheus.LDAPInterface
+	interval time.Duration
+	logger   *logrus.Logger
+}
+
+// NewSweeper creates a sweeper that runs every interval
+func NewSweeper(ldapMgr prometheus.LDAPInterface, interval time.Duration, logger *logrus.Logger) *Sweeper {
+	return &Sweeper{
+		ldapMgr:  ldapMgr,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Run sweeps once immediately and then on every tick until ctx is done
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.logger.WithField("interval", s.interval).Info("Starting account lifecycle sweeper")
+
+	for {
+		s.Sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep performs one pass over the users that are due and the grants that
+// have expired
+func (s *Sweeper) Sweep(ctx context.Context) {
+	ctx = context.WithValue(ctx, auth.ContextKeyUser, Actor)
+	now := time.Now()
+
+	due, err := s.ldapMgr.FindUsersDue(ctx, now)
+	if err != nil {
+		s.logger.WithError(err).Error("Lifecycle sweep failed to list users")
+		return
+	}
+
+	for _, uid := range due.Expired {
+		if _, err := s.ldapMgr.DisableUser(ctx, uid); err != nil {
+			s.logger.WithError(err).WithField("uid", uid).Error("Failed to disable expired user")
+			continue
+		}
+		s.logger.WithField("uid", uid).Info("Disabled expired user")
+	}
+
+	for _, uid := range due.Purgeable {
+		// Another replica may have purged the user, or an admin re-enabled it
+		user, err := s.ldapMgr.GetUser(ctx, uid)
+		if errors.Is(err, models.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			s.logger.WithError(err).WithField("uid", uid).Error("Failed to re-check deprovisioned user")
+			continue
+		}
+		if user.Status != models.UserStatusDeprovisioned || user.DeprovisionAt == nil || user.DeprovisionAt.After(now) {
+			continue
+		}
+
+		if err := s.ldapMgr.DeleteUser(ctx, uid); err != nil {
+			s.logger.WithError(err).WithField("uid", uid).Error("Failed to delete deprovisioned user")
+			continue
+		}
+		s.logger.WithField("uid", uid).Info("Deleted deprovisioned user after grace period")
+	}
+
+	if _, err := s.ldapMgr.RevokeExpiredGrants(ctx, now); err != nil {
+		s.logger.WithError(err).Error("Lifecycle sweep failed to revoke expired grants")
+	}
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sentinel errors of the access request workflow
+var (
+	ErrAccessRequestNotFound = errors.New("access request not found")
+	ErrAccessRequestReviewed = errors.New("access request already reviewed")
+)
+
+// Access request states. A request is pending until reviewed; an approved
+// request becomes expired once the sweeper has revoked its grant.
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestDenied   = "denied"
+	AccessRequestExpired  = "expired"
+)
+
+// AccessRequest is a user's request for time-bound access to a repository
+type AccessRequest struct {
+	ID          string        `json:"id"`
+	Requester   string        `json:"requester"`
+	Repository  string        `json:"repository"`
+	Permission  string        `json:"permission"`
+	Reason      string        `json:"reason"`
+	Duration    time.Duration `json:"duration"`
+	Status      string        `json:"status"`
+	RequestedAt time.Time     `json:"requestedAt"`
+	ReviewedBy  string        `json:"reviewedBy,omitempty"`
+	ReviewedAt  *time.Time    `json:"reviewedAt,omitempty"`
+	ExpiresAt   *time.Time    `json:"expiresAt,omitempty"` // when the approved grant runs out
+}
+
+// Grant returns the grant approving the request at now gives
+func (r *AccessRequest) Grant(now time.Time) RepositoryGrant {
+	expiresAt := now.Add(r.Duration).UTC().Truncate(time.Second)
+	return RepositoryGrant{Repository: r.Repository, Permission: r.Permission, ExpiresAt: &expiresAt}
+}
+
+// AccessRequestInput contains fields for requesting repository access
+type AccessRequestInput struct {
+	Requester  string        `json:"requester"`
+	Repository string        `json:"repository"`
+	Permission string        `json:"permission"`
+	Reason     string        `json:"reason"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Validate checks the request against the longest duration that may be
+// asked for, filling in DefaultPermission
+func (in *AccessRequestInput) Validate(maxDuration time.Duration) error {
+	if in.Permission == "" {
+		in.Permission = DefaultPermission
+	}
+	in.Permission = strings.ToLower(in.Permission)
+
+	problems := GrantProblems([]RepositoryGrant{{Repository: in.Repository, Permission: in.Permission}})
+	if strings.TrimSpace(in.Reason) == "" {
+		problems = append(problems, "reason is required")
+	}
+	switch {
+	case in.Duration <= 0:
+		problems = append(problems, "duration must be positive")
+	case in.Duration < time.Minute:
+		problems = append(problems, "duration must be at least a minute")
+	case maxDuration > 0 && in.Duration > maxDuration:
+		problems = append(problems, fmt.Sprintf("duration must not exceed %s", maxDuration))
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// AccessRequestFilter selects access requests; empty fields match all
+type AccessRequestFilter struct {
+	Status     string `json:"status,omitempty"`
+	Requester  string `json:"requester,omitempty"`
+	Repository string `json:"repository,omitempty"`
+}
+
+// GrantSweepResult counts what a sweep of expired grants revoked, and
+// lists it for the audit log
+type GrantSweepResult struct {
+	Users             int             `json:"users"`
+	Groups            int             `json:"groups"`
+	Departments       int             `json:"departments"`
+	ExpiredRequests   int             `json:"expiredRequests"`
+	Revoked           []RevokedGrants `json:"revoked,omitempty"`
+	ExpiredRequestIDs []string        `json:"expiredRequestIds,omitempty"`
+}
+
+// RevokedGrants are the expired repositoryGrant values a sweep removed from
+// one user, group or department
+type RevokedGrants struct {
+	DN     string   `json:"dn"`
+	Grants []string `json:"grants"`
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Value types of custom user attributes
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeInt     = "int"
+	AttributeTypeBoolean = "boolean"
+)
+
+// AttributeDefinition maps an LDAP attribute onto a custom field of the
+// User type. Definitions are loaded from USER_ATTRIBUTES_FILE, a JSON array.
+type AttributeDefinition struct {
+	Field          string `json:"field"`         // GraphQL field name, e.g. "phone"
+	LDAPAttribute  string `json:"ldapAttribute"` // e.g. "telephoneNumber"
+	Type           string `json:"type"`          // string, int or boolean; default string
+	MultiValued    bool   `json:"multiValued"`
+	EditableBySelf bool   `json:"editableBySelf"` // users may change it on their own entry
+	Description    string `json:"description,omitempty"`
+
+	// OID registers LDAPAttribute in the devplatform schema. Leave it empty
+	// for attributes the server already knows, such as telephoneNumber.
+	OID string `json:"oid,omitempty"`
+}
+
+var (
+	graphQLNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+	attributePattern   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+	oidPattern         = regexp.MustCompile(`^[0-9]+(\.[0-9]+)+$`)
+)
+
+// reservedUserFields are the built-in fields of User and its input and
+// filter types, which custom fields may not shadow
+var reservedUserFields = []string{
+	"uid", "cn", "givenName", "sn", "mail", "department", "repositories", "dn", "status",
+	"effectiveGroups", "effectiveDepartments", "effectiveRepositories", "expiresAt", "deprovisionAt",
+	"uidNumber", "gidNumber", "homeDirectory", "password", "repository", "attributes", "sshKeys",
+	"departments", "expression", "grants", "effectiveGrants",
+}
+
+// reservedUserAttributes are managed by the service itself and may not be
+// exposed as custom fields, so they cannot be edited around its checks
+var reservedUserAttributes = []string{
+	"objectClass", "uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber",
+	"homeDirectory", "githubRepository", "userPassword", "passwordHistory", "accountStatus",
+	"accountExpires", "deprovisionAt", "sshPublicKey", "repositoryGrant", "memberOf", "entryUUID", "entryCSN",
+}
+
+// ValidateAttributeDefinitions checks a set of custom attribute
+// definitions and fills in defaults
+func ValidateAttributeDefinitions(defs []AttributeDefinition) error {
+	var problems []string
+	fields := make(map[string]bool)
+	attributes := make(map[string]bool)
+
+	for i := range defs {
+		d := &defs[i]
+		if d.Type == "" {
+			d.Type = AttributeTypeString
+		}
+
+		if !graphQLNamePattern.MatchString(d.Field) {
+			problems = append(problems, fmt.Sprintf("field %q is not a valid GraphQL name", d.Field))
+		}
+		if !attributePattern.MatchString(d.LDAPAttribute) {
+			problems = append(problems, fmt.Sprintf("field %q: ldapAttribute %q is not a valid attribute name", d.Field, d.LDAPAttribute))
+		}
+		switch d.Type {
+		case AttributeTypeString, AttributeTypeInt, AttributeTypeBoolean:
+		default:
+			problems = append(problems, fmt.Sprintf("field %q: unknown type %q (want string, int or boolean)", d.Field, d.Type))
+		}
+		if d.OID != "" && !oidPattern.MatchString(d.OID) {
+			problems = append(problems, fmt.Sprintf("field %q: %q is not a valid OID", d.Field, d.OID))
+		}
+
+		for _, reserved := range reservedUserFields {
+			if d.Field == reserved {
+				problems = append(problems, fmt.Sprintf("field %q is a built-in user field", d.Field))
+			}
+		}
+		for _, reserved := range reservedUserAttributes {
+			if strings.EqualFold(d.LDAPAttribute, reserved) {
+				problems = append(problems, fmt.Sprintf("field %q: attribute %q is managed by the service", d.Field, d.LDAPAttribute))
+			}
+		}
+
+		if fields[d.Field] {
+			problems = append(problems, fmt.Sprintf("field %q is defined twice", d.Field))
+		}
+		if attributes[strings.ToLower(d.LDAPAttribute)] {
+			problems = append(problems, fmt.Sprintf("attribute %q is mapped twice", d.LDAPAttribute))
+		}
+		fields[d.Field] = true
+		attributes[strings.ToLower(d.LDAPAttribute)] = true
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateValues checks values about to be written to the attribute
+func (d *AttributeDefinition) ValidateValues(values []string) error {
+	if !d.MultiValued && len(values) > 1 {
+		return &ValidationError{Problems: []string{fmt.Sprintf("%s takes a single value", d.Field)}}
+	}
+	for _, v := range values {
+		if _, ok := d.ParseValue(v); !ok {
+			return &ValidationError{Problems: []string{fmt.Sprintf("%s: %q is not a valid %s", d.Field, v, d.Type)}}
+		}
+	}
+	return nil
+}
+
+// ParseValue converts an LDAP value to the attribute's type
+func (d *AttributeDefinition) ParseValue(v string) (interface{}, bool) {
+	switch d.Type {
+	case AttributeTypeInt:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	case AttributeTypeBoolean:
+		switch strings.ToUpper(v) {
+		case "TRUE":
+			return true, true
+		case "FALSE":
+			return false, true
+		}
+		return nil, false
+	}
+	return v, v != ""
+}
+
+// FormatValue converts a typed value to its LDAP form
+func (d *AttributeDefinition) FormatValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case int:
+		return strconv.Itoa(value), nil
+	case bool:
+		if value {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+	return "", fmt.Errorf("%s: unsupported value %v", d.Field, v)
+}
+
+// SchemaDefinition returns the RFC 4512 attribute type description used to
+// register the attribute when it has an OID
+func (d *AttributeDefinition) SchemaDefinition() string {
+	desc := d.Description
+	if desc == "" {
+		desc = d.Field
+	}
+	desc = strings.NewReplacer(`\`, `\5C`, `'`, `\27`).Replace(desc)
+
+	var matching string
+	switch d.Type {
+	case AttributeTypeInt:
+		matching = "EQUALITY integerMatch ORDERING integerOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27"
+	case AttributeTypeBoolean:
+		matching = "EQUALITY booleanMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.7"
+	default:
+		matching = "EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15"
+	}
+
+	definition := fmt.Sprintf("( %s NAME '%s' DESC '%s' %s", d.OID, d.LDAPAttribute, desc, matching)
+	if !d.MultiValued {
+		definition += " SINGLE-VALUE"
+	}
+	return definition + " )"
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// DirectoryEntry is one LDAP entry with its user attributes, as written to
+// and read from a backup snapshot
+type DirectoryEntry struct {
+	DN         string              `json:"dn"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+// BackupSnapshot describes one LDIF snapshot of the directory
+type BackupSnapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Entries   int       `json:"entries,omitempty"` // only known right after the backup
+}
+
+// Operations in a RestoreChange
+const (
+	RestoreAdd    = "add"
+	RestoreModify = "modify"
+	RestoreDelete = "delete"
+)
+
+// RestoreChange is one write a restore makes. For modifications Before and
+// After hold only the attributes that differ, an attribute missing from
+// After is removed. Additions have no Before and deletions no After.
+type RestoreChange struct {
+	Operation string              `json:"operation"`
+	DN        string              `json:"dn"`
+	Before    map[string][]string `json:"before,omitempty"`
+	After     map[string][]string `json:"after,omitempty"`
+}
+
+// RestoreResult reports what a restore changed, or with DryRun would change
+type RestoreResult struct {
+	Snapshot string           `json:"snapshot,omitempty"`
+	DryRun   bool             `json:"dryRun"`
+	Added    int              `json:"added"`
+	Modified int              `json:"modified"`
+	Deleted  int              `json:"deleted"`
+	Changes  []*RestoreChange `json:"changes"`
+}
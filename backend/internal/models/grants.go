@@ -0,0 +1,244 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Repository permission levels, lowest first
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionAdmin = "admin"
+)
+
+// DefaultPermission is the level of repositories assigned without one,
+// including githubRepository values written before grants existed
+const DefaultPermission = PermissionWrite
+
+// RepositoryGrant gives access to a repository at a permission level,
+// optionally only until ExpiresAt
+type RepositoryGrant struct {
+	Repository string     `json:"repository"`
+	Permission string     `json:"permission"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// PermissionRank orders permission levels; unknown levels rank 0
+func PermissionRank(permission string) int {
+	switch permission {
+	case PermissionRead:
+		return 1
+	case PermissionWrite:
+		return 2
+	case PermissionAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Active reports whether the grant has not expired at now
+func (g RepositoryGrant) Active(now time.Time) bool {
+	return g.ExpiresAt == nil || now.Before(*g.ExpiresAt)
+}
+
+// String encodes the grant as stored in repositoryGrant: the repository,
+// the permission and, for expiring grants, the RFC3339 expiry, separated
+// by spaces
+func (g RepositoryGrant) String() string {
+	value := g.Repository + " " + g.Permission
+	if g.ExpiresAt != nil {
+		value += " " + g.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return value
+}
+
+// GrantValues encodes grants as repositoryGrant values
+func GrantValues(grants []RepositoryGrant) []string {
+	values := make([]string, len(grants))
+	for i, grant := range grants {
+		values[i] = grant.String()
+	}
+	return values
+}
+
+// ParseRepositoryGrant decodes a repositoryGrant value
+func ParseRepositoryGrant(value string) (RepositoryGrant, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || len(fields) > 3 {
+		return RepositoryGrant{}, fmt.Errorf("grant %q is not '<repository> <permission> [<expiry>]'", value)
+	}
+	grant := RepositoryGrant{Repository: fields[0], Permission: strings.ToLower(fields[1])}
+	if PermissionRank(grant.Permission) == 0 {
+		return RepositoryGrant{}, fmt.Errorf("grant %q has unknown permission %q", value, fields[1])
+	}
+	if len(fields) == 3 {
+		expiresAt, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return RepositoryGrant{}, fmt.Errorf("grant %q has invalid expiry: %w", value, err)
+		}
+		grant.ExpiresAt = &expiresAt
+	}
+	return grant, nil
+}
+
+// RepositoryGrants returns grants for repos without a permission, which
+// ResolveGrants fills in
+func RepositoryGrants(repos []string) []RepositoryGrant {
+	grants := make([]RepositoryGrant, 0, len(repos))
+	for _, repo := range repos {
+		grants = append(grants, RepositoryGrant{Repository: repo})
+	}
+	return grants
+}
+
+// ResolveGrants completes grants about to replace current ones. A grant
+// without a permission keeps the current grants for its repository, e.g. a
+// permanent one next to a time-bound one, or gets DefaultPermission. A
+// later grant for the same repository replaces an earlier one. Repository
+// names are compared case-insensitively.
+func ResolveGrants(grants, current []RepositoryGrant) []RepositoryGrant {
+	held := make(map[string][]RepositoryGrant, len(current))
+	for _, grant := range current {
+		key := strings.ToLower(grant.Repository)
+		held[key] = append(held[key], grant)
+	}
+
+	index := make(map[string]int, len(grants))
+	byRepo := make([][]RepositoryGrant, 0, len(grants))
+	for _, grant := range grants {
+		key := strings.ToLower(grant.Repository)
+		resolved := []RepositoryGrant{grant}
+		if grant.Permission == "" {
+			if existing, ok := held[key]; ok {
+				resolved = existing
+			} else {
+				resolved[0].Permission = DefaultPermission
+			}
+		}
+		if i, ok := index[key]; ok {
+			byRepo[i] = resolved
+			continue
+		}
+		index[key] = len(byRepo)
+		byRepo = append(byRepo, resolved)
+	}
+
+	flat := make([]RepositoryGrant, 0, len(byRepo))
+	for _, resolved := range byRepo {
+		flat = append(flat, resolved...)
+	}
+	return flat
+}
+
+// AddGrants returns current with grants added. A grant with a permission
+// replaces the current grants for its repository; one without keeps them,
+// or gets DefaultPermission. Other repositories, compared
+// case-insensitively, are left alone.
+func AddGrants(grants, current []RepositoryGrant) []RepositoryGrant {
+	resolved := ResolveGrants(grants, current)
+	added := make(map[string]struct{}, len(resolved))
+	for _, grant := range resolved {
+		added[strings.ToLower(grant.Repository)] = struct{}{}
+	}
+
+	next := make([]RepositoryGrant, 0, len(current)+len(resolved))
+	for _, grant := range current {
+		if _, ok := added[strings.ToLower(grant.Repository)]; !ok {
+			next = append(next, grant)
+		}
+	}
+	return append(next, resolved...)
+}
+
+// RemoveGrants returns current without any grant for repos, compared
+// case-insensitively
+func RemoveGrants(repos []string, current []RepositoryGrant) []RepositoryGrant {
+	removed := make(map[string]struct{}, len(repos))
+	for _, repo := range repos {
+		removed[strings.ToLower(repo)] = struct{}{}
+	}
+
+	next := make([]RepositoryGrant, 0, len(current))
+	for _, grant := range current {
+		if _, ok := removed[strings.ToLower(grant.Repository)]; !ok {
+			next = append(next, grant)
+		}
+	}
+	return next
+}
+
+// GrantProblems lists what is wrong with grants about to be stored
+func GrantProblems(grants []RepositoryGrant) []string {
+	var problems []string
+	empty := false
+	for _, grant := range grants {
+		switch {
+		case strings.TrimSpace(grant.Repository) == "":
+			if !empty {
+				problems = append(problems, "repositories must not contain empty values")
+			}
+			empty = true
+		case strings.ContainsAny(grant.Repository, " \t\n"):
+			problems = append(problems, fmt.Sprintf("repository %q must not contain whitespace", grant.Repository))
+		case grant.Permission != "" && PermissionRank(grant.Permission) == 0:
+			problems = append(problems, fmt.Sprintf("permission %q of %s must be read, write or admin", grant.Permission, grant.Repository))
+		}
+	}
+	return problems
+}
+
+// EffectiveGrants merges grants from several sources into one grant per
+// repository at the highest level any active grant gives, sorted by
+// repository. Among grants at that level the longest-lived one is kept.
+func EffectiveGrants(now time.Time, sources ...[]RepositoryGrant) []RepositoryGrant {
+	best := make(map[string]RepositoryGrant)
+	for _, grants := range sources {
+		for _, grant := range grants {
+			if !grant.Active(now) {
+				continue
+			}
+			current, ok := best[grant.Repository]
+			if !ok || outranks(grant, current) {
+				best[grant.Repository] = grant
+			}
+		}
+	}
+
+	effective := make([]RepositoryGrant, 0, len(best))
+	for _, grant := range best {
+		effective = append(effective, grant)
+	}
+	sort.Slice(effective, func(i, j int) bool { return effective[i].Repository < effective[j].Repository })
+	return effective
+}
+
+// outranks reports whether a gives more access than b to the same
+// repository
+func outranks(a, b RepositoryGrant) bool {
+	if rankA, rankB := PermissionRank(a.Permission), PermissionRank(b.Permission); rankA != rankB {
+		return rankA > rankB
+	}
+	if a.ExpiresAt == nil || b.ExpiresAt == nil {
+		return a.ExpiresAt == nil && b.ExpiresAt != nil
+	}
+	return a.ExpiresAt.After(*b.ExpiresAt)
+}
+
+// GrantedRepositories returns the repositories of the grants active at
+// now, sorted and without duplicates
+func GrantedRepositories(grants []RepositoryGrant, now time.Time) []string {
+	seen := make(map[string]struct{})
+	repos := []string{}
+	for _, grant := range grants {
+		if _, ok := seen[grant.Repository]; ok || !grant.Active(now) {
+			continue
+		}
+		seen[grant.Repository] = struct{}{}
+		repos = append(repos, grant.Repository)
+	}
+	sort.Strings(repos)
+	return repos
+}
@@ -1,5 +1,29 @@
 package models
 
+import (
+	"errors"
+	"time"
+)
+
+// Sentinel errors returned (wrapped) by the LDAP manager
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrDepartmentNotFound = errors.New("department not found")
+
+	ErrInvalidCredentials     = errors.New("invalid credentials")
+	ErrPasswordExpired        = errors.New("password expired")
+	ErrPasswordChangeRequired = errors.New("password must be changed")
+	ErrAccountDisabled        = errors.New("account disabled")
+	ErrAccountExpired         = errors.New("account expired")
+	ErrPPolicyRequired        = errors.New("locking accounts requires the ppolicy overlay")
+
+	ErrHierarchyCycle = errors.New("hierarchy cycle")
+
+	ErrSSHKeyExists   = errors.New("SSH key already registered")
+	ErrSSHKeyNotFound = errors.New("SSH key not found")
+)
+
 // User represents an LDAP user with all attributes
 type User struct {
 	UID          string   `json:"uid"`
@@ -11,18 +35,102 @@ type User struct {
 	UIDNumber    int      `json:"uidNumber"`
 	GIDNumber    int      `json:"gidNumber"`
 	HomeDir      string   `json:"homeDirectory"`
-	Repositories []string `json:"repositories"`
+	Repositories []string `json:"repositories"` // own and group repositories
 	DN           string   `json:"dn"`
+
+	// Repositories granted to the user directly
+	Grants []RepositoryGrant `json:"grants"`
+
+	// Lifecycle
+	Status        string     `json:"status"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	DeprovisionAt *time.Time `json:"deprovisionAt,omitempty"` // entry is deleted after this time
+
+	// Custom attributes from USER_ATTRIBUTES_FILE, keyed by field name
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// SSHKey is an OpenSSH public key stored in a user's sshPublicKey attribute
+type SSHKey struct {
+	Fingerprint string `json:"fingerprint"` // SHA256:..., as printed by ssh-keygen -l
+	Type        string `json:"type"`        // e.g. ssh-ed25519
+	Bits        int    `json:"bits"`
+	Comment     string `json:"comment"`
+	Key         string `json:"key"` // authorized_keys line: type, base64 key and comment
+}
+
+// User account statuses
+const (
+	UserStatusActive        = "active"
+	UserStatusDisabled      = "disabled"
+	UserStatusDeprovisioned = "deprovisioned"
+)
+
+// DeprovisionResult describes what deprovisioning took away from a user
+type DeprovisionResult struct {
+	User                *User     `json:"user"`
+	RemovedGroups       []string  `json:"removedGroups"`
+	RemovedRepositories []string  `json:"removedRepositories"`
+	RemovedDepartment   string    `json:"removedDepartment,omitempty"`
+	ReleasedDepartments []string  `json:"releasedDepartments"` // managed or deputized for
+	DeleteAfter         time.Time `json:"deleteAfter"`
 }
 
+// LifecycleDue lists users the lifecycle sweeper has to act on
+type LifecycleDue struct {
+	Expired   []string `json:"expired"`   // active users past expiresAt
+	Purgeable []string `json:"purgeable"` // deprovisioned users past their grace period
+}
+
+// DirectoryChange is one change to a user, group or department entry, as
+// observed by the directory watcher. Before is nil for additions and After
+// is nil for deletions.
+type DirectoryChange struct {
+	Entity    string              `json:"entity"` // user, group or department
+	Key       string              `json:"key"`    // uid, cn or ou
+	DN        string              `json:"dn"`
+	EntryUUID string              `json:"entryUuid"`
+	CSN       string              `json:"csn,omitempty"` // entryCSN after the change
+	Before    map[string][]string `json:"before,omitempty"`
+	After     map[string][]string `json:"after,omitempty"`
+}
+
+// Directory entities reported in DirectoryChange.Entity
+const (
+	EntityUser       = "user"
+	EntityGroup      = "group"
+	EntityDepartment = "department"
+)
+
 // Department represents an organizational unit in LDAP
 type Department struct {
 	OU           string   `json:"ou"`
 	Description  string   `json:"description"`
 	Manager      string   `json:"manager,omitempty"`
+	Deputies     []string `json:"deputies"`         // uids managing the department besides Manager
+	Parent       string   `json:"parent,omitempty"` // OU of the parent department
 	Members      []string `json:"members"`
 	Repositories []string `json:"repositories"`
 	DN           string   `json:"dn"`
+
+	Grants []RepositoryGrant `json:"grants"`
+}
+
+// ManagedBy reports whether uid is the department's manager or one of its
+// deputies
+func (d *Department) ManagedBy(uid string) bool {
+	if uid == "" {
+		return false
+	}
+	if d.Manager == uid {
+		return true
+	}
+	for _, deputy := range d.Deputies {
+		if deputy == uid {
+			return true
+		}
+	}
+	return false
 }
 
 // Group represents an LDAP group
@@ -31,8 +139,31 @@ type Group struct {
 	Description  string   `json:"description,omitempty"`
 	GIDNumber    int      `json:"gidNumber"`
 	Members      []string `json:"members"`
+	Subgroups    []string `json:"subgroups"` // CNs of groups nested in this one
 	Repositories []string `json:"repositories"`
 	DN           string   `json:"dn"`
+
+	Grants []RepositoryGrant `json:"grants"`
+}
+
+// EffectiveAccess is what a user inherits through the group and department
+// hierarchies
+type EffectiveAccess struct {
+	UID          string   `json:"uid"`
+	Groups       []string `json:"groups"`       // direct and inherited groups
+	Departments  []string `json:"departments"`  // own department, then its ancestors
+	Repositories []string `json:"repositories"` // own, group and department repositories
+
+	// One grant per repository, at the highest level any source gives
+	Grants []RepositoryGrant `json:"grants"`
+}
+
+// GrantMigrationResult counts the entries whose bare githubRepository
+// values were turned into repository grants
+type GrantMigrationResult struct {
+	Users       int `json:"users"`
+	Groups      int `json:"groups"`
+	Departments int `json:"departments"`
 }
 
 // CreateUserInput contains fields for creating a new user
@@ -45,6 +176,9 @@ type CreateUserInput struct {
 	Department   string   `json:"department"`
 	Password     string   `json:"password"`
 	Repositories []string `json:"repositories"`
+
+	// Grants are added to Repositories, which get DefaultPermission
+	Grants []RepositoryGrant `json:"grants,omitempty"`
 }
 
 // UpdateUserInput contains fields for updating a user
@@ -57,6 +191,14 @@ type UpdateUserInput struct {
 	Department   *string  `json:"department,omitempty"`
 	Password     *string  `json:"password,omitempty"`
 	Repositories []string `json:"repositories,omitempty"`
+
+	// Grants and Repositories together replace the direct grants when
+	// either is set; repositories keep their current permission
+	Grants []RepositoryGrant `json:"grants,omitempty"`
+
+	// Custom attributes to replace, keyed by field name; an empty list
+	// removes the attribute
+	Attributes map[string][]string `json:"attributes,omitempty"`
 }
 
 // CreateDepartmentInput contains fields for creating a department
@@ -64,7 +206,11 @@ type CreateDepartmentInput struct {
 	OU           string   `json:"ou"`
 	Description  string   `json:"description"`
 	Manager      string   `json:"manager,omitempty"`
+	Deputies     []string `json:"deputies,omitempty"`
+	Parent       string   `json:"parent,omitempty"`
 	Repositories []string `json:"repositories,omitempty"`
+
+	Grants []RepositoryGrant `json:"grants,omitempty"`
 }
 
 // SearchFilter contains optional filters for user searches
@@ -78,6 +224,62 @@ type SearchFilter struct {
 	UIDNumber  int    `json:"uidNumber,omitempty"`
 	GIDNumber  int    `json:"gidNumber,omitempty"`
 	Repository string `json:"repository,omitempty"`
+
+	// Departments and Repositories match users in any of the departments,
+	// or with access to any of the repositories (exact match)
+	Departments  []string `json:"departments,omitempty"`
+	Repositories []string `json:"repositories,omitempty"`
+
+	// Custom attributes keyed by field name: strings match as substrings,
+	// other types exactly
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// Expression is ANDed with the fields above
+	Expression *FilterExpression `json:"expression,omitempty"`
+}
+
+// DepartmentFilter contains optional filters for department searches
+type DepartmentFilter struct {
+	OU          string `json:"ou,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// GroupFilter contains optional filters for group searches
+type GroupFilter struct {
+	CN string `json:"cn,omitempty"`
+}
+
+// PageRequest selects one page of a listing ordered by naming attribute
+// (uid, cn or ou). A zero Limit returns every remaining entry.
+type PageRequest struct {
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	After  string `json:"after,omitempty"` // naming attribute of the last entry already seen
+}
+
+// PageInfo describes where a page sits within the full listing
+type PageInfo struct {
+	Total           int  `json:"total"`
+	HasNextPage     bool `json:"hasNextPage"`
+	HasPreviousPage bool `json:"hasPreviousPage"`
+}
+
+// UserPage is one page of users
+type UserPage struct {
+	Users []*User `json:"users"`
+	PageInfo
+}
+
+// GroupPage is one page of groups
+type GroupPage struct {
+	Groups []*Group `json:"groups"`
+	PageInfo
+}
+
+// DepartmentPage is one page of departments
+type DepartmentPage struct {
+	Departments []*Department `json:"departments"`
+	PageInfo
 }
 
 // AuthPayload is returned after successful authentication
@@ -86,12 +288,39 @@ type AuthPayload struct {
 	User  *User  `json:"user"`
 }
 
-// Stats contains connection pool statistics
+// Stats contains connection pool statistics summed over every backend.
+// PoolSize is the number of open connections; each backend's pool holds
+// between MinSize and MaxSize. The counters below TotalRequests are
+// cumulative since startup.
 type Stats struct {
 	PoolSize      int `json:"poolSize"`
+	MinSize       int `json:"minSize"`
+	MaxSize       int `json:"maxSize"`
 	Available     int `json:"available"`
 	InUse         int `json:"inUse"`
 	TotalRequests int `json:"totalRequests"`
+
+	Waits               int     `json:"waits"`       // checkouts that had to wait
+	WaitSeconds         float64 `json:"waitSeconds"` // total time spent waiting
+	Timeouts            int     `json:"timeouts"`
+	ConnectionsCreated  int     `json:"connectionsCreated"`
+	ConnectionsRecycled int     `json:"connectionsRecycled"` // closed after LDAP_MAX_CONN_LIFETIME
+	ConnectionsReaped   int     `json:"connectionsReaped"`   // closed idle or broken
+
+	Backends []BackendStats `json:"backends"`
+}
+
+// BackendStats describes one LDAP server: its role (provider or consumer),
+// whether its circuit breaker lets requests through, and its pool
+type BackendStats struct {
+	URL       string `json:"url"`
+	Role      string `json:"role"`
+	Healthy   bool   `json:"healthy"`
+	Failures  int    `json:"failures"` // consecutive connection failures
+	Requests  int    `json:"requests"` // connections handed out
+	PoolSize  int    `json:"poolSize"`
+	Available int    `json:"available"`
+	InUse     int    `json:"inUse"`
 }
 
 // HealthStatus represents the health status of the service
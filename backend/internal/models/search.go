@@ -0,0 +1,56 @@
+package models
+
+// Match operators of a FilterCondition
+const (
+	MatchExact     = "EXACT"
+	MatchPrefix    = "PREFIX"
+	MatchSubstring = "SUBSTRING"
+	MatchPresent   = "PRESENT" // the attribute has any value; Values is ignored
+)
+
+// Logical operators of a FilterExpression
+const (
+	LogicalAnd = "AND"
+	LogicalOr  = "OR"
+)
+
+// FilterCondition matches one user field. A condition with several values
+// matches when any of them does, e.g. users in either of two departments.
+type FilterCondition struct {
+	// Field is a built-in user field (uid, cn, sn, givenName, mail,
+	// department, repository, status, uidNumber, gidNumber) or a custom
+	// attribute field
+	Field  string   `json:"field"`
+	Match  string   `json:"match"` // default SUBSTRING; numeric fields only support EXACT
+	Values []string `json:"values"`
+	Not    bool     `json:"not,omitempty"`
+}
+
+// FilterExpression combines conditions and nested expressions with AND or
+// OR, optionally negated
+type FilterExpression struct {
+	Op         string              `json:"op"` // default AND
+	Not        bool                `json:"not,omitempty"`
+	Conditions []FilterCondition   `json:"conditions,omitempty"`
+	Groups     []*FilterExpression `json:"groups,omitempty"`
+}
+
+// Kinds of directory search results
+const (
+	DirectoryKindUser       = "USER"
+	DirectoryKindGroup      = "GROUP"
+	DirectoryKindDepartment = "DEPARTMENT"
+)
+
+// DirectorySearchResult is one ranked match of a directory-wide search.
+// Exactly one of User, Group and Department is set, according to Kind.
+type DirectorySearchResult struct {
+	Kind       string      `json:"kind"`
+	ID         string      `json:"id"` // uid, group cn or department ou
+	Title      string      `json:"title"`
+	Subtitle   string      `json:"subtitle,omitempty"`
+	Score      int         `json:"score"`
+	User       *User       `json:"user,omitempty"`
+	Group      *Group      `json:"group,omitempty"`
+	Department *Department `json:"department,omitempty"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// uidPattern restricts UIDs to characters that are safe in DNs, POSIX
+// usernames and home directory paths
+var uidPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// ValidationError lists every problem found in an input
+type ValidationError struct {
+	Problems []string
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	return "invalid input: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the input against the rules enforced for new users
+func (in *CreateUserInput) Validate() error {
+	problems := in.profileProblems()
+	if in.Password == "" {
+		problems = append(problems, "password is required")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateProfile checks everything except the password, for inputs that
+// update an existing user and may keep its current password
+func (in *CreateUserInput) ValidateProfile() error {
+	if problems := in.profileProblems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (in *CreateUserInput) profileProblems() []string {
+	var problems []string
+
+	if !uidPattern.MatchString(in.UID) {
+		problems = append(problems, fmt.Sprintf("uid %q must be 1-64 lowercase letters, digits, '.', '_' or '-'", in.UID))
+	}
+	if strings.TrimSpace(in.CN) == "" {
+		problems = append(problems, "cn is required")
+	}
+	if strings.TrimSpace(in.SN) == "" {
+		problems = append(problems, "sn is required")
+	}
+	if _, err := mail.ParseAddress(in.Mail); err != nil || strings.Contains(in.Mail, " ") {
+		problems = append(problems, fmt.Sprintf("mail %q is not a valid address", in.Mail))
+	}
+	return append(problems, GrantProblems(append(RepositoryGrants(in.Repositories), in.Grants...))...)
+}
@@ -2,6 +2,7 @@ package prometheus
 
 import (
 	"context"
+	"time"
 
 	"github.com/devplatform/ldap-manager/internal/models"
 )
@@ -22,15 +23,63 @@ type LDAPInterface interface {
 	// ListUsers lists users with optional filtering
 	ListUsers(ctx context.Context, filter *models.SearchFilter) ([]*models.User, error)
 
+	// ListUsersPage returns one page of users ordered by uid
+	ListUsersPage(ctx context.Context, filter *models.SearchFilter, page *models.PageRequest) (*models.UserPage, error)
+
+	// SearchDirectory ranks users, groups and departments matching a query
+	SearchDirectory(ctx context.Context, query string, limit int) ([]*models.DirectorySearchResult, error)
+
 	// UpdateUser updates user attributes
 	UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error)
 
+	// RestoreUser writes back a user read earlier, removing attributes that were empty
+	RestoreUser(ctx context.Context, snapshot *models.User) (*models.User, error)
+
 	// DeleteUser deletes a user from LDAP
 	DeleteUser(ctx context.Context, uid string) error
 
 	// Authenticate authenticates a user with their password
 	Authenticate(ctx context.Context, uid, password string) (*models.User, error)
 
+	// ChangePassword replaces a password after verifying the current one
+	ChangePassword(ctx context.Context, uid, oldPassword, newPassword string) error
+
+	// ValidatePassword checks a password against the policy without storing it
+	ValidatePassword(ctx context.Context, uid, password string) error
+
+	// ResetPassword sets and returns a one-time temporary password
+	ResetPassword(ctx context.Context, uid string) (string, error)
+
+	// DisableUser marks an account disabled
+	DisableUser(ctx context.Context, uid string) (*models.User, error)
+
+	// EnableUser reactivates an account and cancels a pending deletion
+	EnableUser(ctx context.Context, uid string) (*models.User, error)
+
+	// SetUserExpiry sets or clears the time an account expires
+	SetUserExpiry(ctx context.Context, uid string, expiresAt *time.Time) (*models.User, error)
+
+	// DeprovisionUser removes all access and schedules the entry for deletion
+	DeprovisionUser(ctx context.Context, uid string) (*models.DeprovisionResult, error)
+
+	// FindUsersDue lists users to expire or purge as of now
+	FindUsersDue(ctx context.Context, now time.Time) (*models.LifecycleDue, error)
+
+	// ListSSHKeys returns a user's SSH public keys
+	ListSSHKeys(ctx context.Context, uid string) ([]*models.SSHKey, error)
+
+	// AddSSHKey validates and adds an SSH public key to a user
+	AddSSHKey(ctx context.Context, uid, authorizedKey string) (*models.SSHKey, error)
+
+	// RemoveSSHKey removes a user's SSH public key by fingerprint
+	RemoveSSHKey(ctx context.Context, uid, fingerprint string) error
+
+	// AddUserRepositories adds grants to a user's direct grants
+	AddUserRepositories(ctx context.Context, uid string, grants []models.RepositoryGrant) (*models.User, error)
+
+	// RemoveUserRepositories removes a user's direct grants for repositories
+	RemoveUserRepositories(ctx context.Context, uid string, repos []string) (*models.User, error)
+
 	// ═══════════════════════════════════════════════════════════════════════════
 	// GROUP OPERATIONS
 	// ═══════════════════════════════════════════════════════════════════════════
@@ -44,6 +93,9 @@ type LDAPInterface interface {
 	// ListGroups lists all groups
 	ListGroups(ctx context.Context) ([]*models.Group, error)
 
+	// ListGroupsPage returns one page of groups ordered by cn
+	ListGroupsPage(ctx context.Context, filter *models.GroupFilter, page *models.PageRequest) (*models.GroupPage, error)
+
 	// DeleteGroup deletes a group by CN
 	DeleteGroup(ctx context.Context, cn string) error
 
@@ -53,8 +105,23 @@ type LDAPInterface interface {
 	// RemoveUserFromGroup removes a user from a group
 	RemoveUserFromGroup(ctx context.Context, uid, groupCN string) error
 
-	// AssignRepositoriesToGroup assigns repositories to a group
-	AssignRepositoriesToGroup(ctx context.Context, cn string, repositories []string) (*models.Group, error)
+	// AssignRepositoriesToGroup replaces a group's repository grants
+	AssignRepositoriesToGroup(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error)
+
+	// AddGroupRepositories adds grants to a group's repository grants
+	AddGroupRepositories(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error)
+
+	// RemoveGroupRepositories removes a group's grants for repositories
+	RemoveGroupRepositories(ctx context.Context, cn string, repos []string) (*models.Group, error)
+
+	// AddGroupToGroup nests a group inside another one
+	AddGroupToGroup(ctx context.Context, childCN, parentCN string) error
+
+	// RemoveGroupFromGroup removes a nested group from its parent
+	RemoveGroupFromGroup(ctx context.Context, childCN, parentCN string) error
+
+	// GetGroupEffectiveMembers returns direct and nested members of a group
+	GetGroupEffectiveMembers(ctx context.Context, cn string) ([]string, error)
 
 	// ═══════════════════════════════════════════════════════════════════════════
 	// DEPARTMENT OPERATIONS
@@ -69,15 +136,81 @@ type LDAPInterface interface {
 	// ListDepartments lists all departments
 	ListDepartments(ctx context.Context) ([]*models.Department, error)
 
+	// ListDepartmentsPage returns one page of departments ordered by ou
+	ListDepartmentsPage(ctx context.Context, filter *models.DepartmentFilter, page *models.PageRequest) (*models.DepartmentPage, error)
+
 	// DeleteDepartment deletes a department
 	DeleteDepartment(ctx context.Context, ou string) error
 
-	// AssignRepositoryToDepartment assigns repositories to a department
-	AssignRepositoryToDepartment(ctx context.Context, ou string, repos []string) error
+	// AssignRepositoryToDepartment replaces a department's repository grants
+	AssignRepositoryToDepartment(ctx context.Context, ou string, grants []models.RepositoryGrant) error
+
+	// AddDepartmentRepositories adds grants to a department's repository grants
+	AddDepartmentRepositories(ctx context.Context, ou string, grants []models.RepositoryGrant) (*models.Department, error)
+
+	// RemoveDepartmentRepositories removes a department's grants for repositories
+	RemoveDepartmentRepositories(ctx context.Context, ou string, repos []string) (*models.Department, error)
 
 	// GetUsersByDepartment retrieves all users in a department
 	GetUsersByDepartment(ctx context.Context, department string) ([]*models.User, error)
 
+	// SetDepartmentParent moves a department in the hierarchy
+	SetDepartmentParent(ctx context.Context, ou, parent string) (*models.Department, error)
+
+	// GetDepartmentChildren returns the direct child departments
+	GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error)
+
+	// SetDepartmentManager hands a department over to another user
+	SetDepartmentManager(ctx context.Context, ou, uid string) (*models.Department, error)
+
+	// AddDepartmentDeputy lets a user manage a department besides its manager
+	AddDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error)
+
+	// RemoveDepartmentDeputy takes a department away from one of its deputies
+	RemoveDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error)
+
+	// GetManagedDepartments returns the departments a user manages or deputizes for
+	GetManagedDepartments(ctx context.Context, uid string) ([]*models.Department, error)
+
+	// ═══════════════════════════════════════════════════════════════════════════
+	// EFFECTIVE ACCESS
+	// ═══════════════════════════════════════════════════════════════════════════
+
+	// GetEffectiveAccess resolves a user's access through the hierarchies
+	GetEffectiveAccess(ctx context.Context, uid string) (*models.EffectiveAccess, error)
+
+	// MigrateRepositoryGrants turns bare githubRepository values into grants
+	MigrateRepositoryGrants(ctx context.Context) (*models.GrantMigrationResult, error)
+
+	// ═══════════════════════════════════════════════════════════════════════════
+	// ACCESS REQUESTS
+	// ═══════════════════════════════════════════════════════════════════════════
+
+	// CreateAccessRequest records a pending request for repository access
+	CreateAccessRequest(ctx context.Context, input *models.AccessRequestInput) (*models.AccessRequest, error)
+
+	// GetAccessRequest retrieves an access request by ID
+	GetAccessRequest(ctx context.Context, id string) (*models.AccessRequest, error)
+
+	// ListAccessRequests lists access requests, newest first
+	ListAccessRequests(ctx context.Context, filter *models.AccessRequestFilter) ([]*models.AccessRequest, error)
+
+	// ReviewAccessRequest approves or denies a pending access request
+	ReviewAccessRequest(ctx context.Context, id, reviewer string, approve bool) (*models.AccessRequest, error)
+
+	// RevokeExpiredGrants removes grants that expired by now
+	RevokeExpiredGrants(ctx context.Context, now time.Time) (*models.GrantSweepResult, error)
+
+	// ═══════════════════════════════════════════════════════════════════════════
+	// BACKUP & RESTORE
+	// ═══════════════════════════════════════════════════════════════════════════
+
+	// ExportDirectory returns every entry under the base DN, parents first
+	ExportDirectory(ctx context.Context) ([]*models.DirectoryEntry, error)
+
+	// RestoreDirectory makes the directory match entries, or with dryRun only reports how
+	RestoreDirectory(ctx context.Context, entries []*models.DirectoryEntry, dryRun bool) (*models.RestoreResult, error)
+
 	// ═══════════════════════════════════════════════════════════════════════════
 	// HEALTH & STATS
 	// ═══════════════════════════════════════════════════════════════════════════
@@ -37,27 +37,45 @@ func updatePoolMetrics(stats *models.Stats) {
         PoolIdleConnections.Set(float64(stats.Available))
         PoolActiveConnections.Set(float64(stats.InUse))
         PoolTotalRequests.Set(float64(stats.TotalRequests))
+        PoolMinSize.Set(float64(stats.MinSize))
+        PoolMaxSize.Set(float64(stats.MaxSize))
+        PoolWaits.Set(float64(stats.Waits))
+        PoolWaitSeconds.Set(stats.WaitSeconds)
+        PoolTimeouts.Set(float64(stats.Timeouts))
+        PoolConnectionsCreated.Set(float64(stats.ConnectionsCreated))
+        PoolConnectionsClosed.WithLabelValues("lifetime").Set(float64(stats.ConnectionsRecycled))
+        PoolConnectionsClosed.WithLabelValues("idle").Set(float64(stats.ConnectionsReaped))
+
+        for _, b := range stats.Backends {
+                up := 0.0
+                if b.Healthy {
+                        up = 1
+                }
+                BackendUp.WithLabelValues(b.URL, b.Role).Set(up)
+                BackendRequests.WithLabelValues(b.URL, b.Role).Set(float64(b.Requests))
+                BackendConnections.WithLabelValues(b.URL, b.Role).Set(float64(b.PoolSize))
+        }
 }
 
 // updateEntityCounts updates user, group, department counts
 // This is called after mutations to keep gauges up-to-date
 func (c *LDAPCollector) updateEntityCounts(ctx context.Context) {
+        // Only the totals are needed, so ask for the smallest page
+        page := &models.PageRequest{Limit: 1}
+
         // Update users count
-        users, _ := c.next.ListUsers(ctx, nil)
-        if users != nil {
-                UsersTotal.Set(float64(len(users)))
+        if users, err := c.next.ListUsersPage(ctx, nil, page); err == nil {
+                UsersTotal.Set(float64(users.Total))
         }
 
         // Update groups count
-        groups, _ := c.next.ListGroups(ctx)
-        if groups != nil {
-                GroupsTotal.Set(float64(len(groups)))
+        if groups, err := c.next.ListGroupsPage(ctx, nil, page); err == nil {
+                GroupsTotal.Set(float64(groups.Total))
         }
 
         // Update departments count
-        depts, _ := c.next.ListDepartments(ctx)
-        if depts != nil {
-                DepartmentsTotal.Set(float64(len(depts)))
+        if depts, err := c.next.ListDepartmentsPage(ctx, nil, page); err == nil {
+                DepartmentsTotal.Set(float64(depts.Total))
         }
 }
 
@@ -105,6 +123,26 @@ func (c *LDAPCollector) ListUsers(ctx context.Context, filter *models.SearchFilt
         return users, err
 }
 
+func (c *LDAPCollector) ListUsersPage(ctx context.Context, filter *models.SearchFilter, page *models.PageRequest) (*models.UserPage, error) {
+        start := time.Now()
+        result, err := c.next.ListUsersPage(ctx, filter, page)
+        recordOperation("list_users", start, err)
+
+        // Total covers the whole directory, not just the page
+        if err == nil && filter == nil {
+                UsersTotal.Set(float64(result.Total))
+        }
+
+        return result, err
+}
+
+func (c *LDAPCollector) SearchDirectory(ctx context.Context, query string, limit int) ([]*models.DirectorySearchResult, error) {
+        start := time.Now()
+        results, err := c.next.SearchDirectory(ctx, query, limit)
+        recordOperation("search_directory", start, err)
+        return results, err
+}
+
 func (c *LDAPCollector) UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error) {
         start := time.Now()
         user, err := c.next.UpdateUser(ctx, input)
@@ -117,6 +155,13 @@ func (c *LDAPCollector) UpdateUser(ctx context.Context, input *models.UpdateUser
         return user, err
 }
 
+func (c *LDAPCollector) RestoreUser(ctx context.Context, snapshot *models.User) (*models.User, error) {
+        start := time.Now()
+        user, err := c.next.RestoreUser(ctx, snapshot)
+        recordOperation("restore_user", start, err)
+        return user, err
+}
+
 func (c *LDAPCollector) DeleteUser(ctx context.Context, uid string) error {
         start := time.Now()
         err := c.next.DeleteUser(ctx, uid)
@@ -149,6 +194,102 @@ func (c *LDAPCollector) Authenticate(ctx context.Context, uid, password string)
         return user, err
 }
 
+func (c *LDAPCollector) ChangePassword(ctx context.Context, uid, oldPassword, newPassword string) error {
+        start := time.Now()
+        err := c.next.ChangePassword(ctx, uid, oldPassword, newPassword)
+        recordOperation("change_password", start, err)
+        return err
+}
+
+func (c *LDAPCollector) ValidatePassword(ctx context.Context, uid, password string) error {
+        start := time.Now()
+        err := c.next.ValidatePassword(ctx, uid, password)
+        recordOperation("validate_password", start, err)
+        return err
+}
+
+func (c *LDAPCollector) ResetPassword(ctx context.Context, uid string) (string, error) {
+        start := time.Now()
+        password, err := c.next.ResetPassword(ctx, uid)
+        recordOperation("reset_password", start, err)
+        return password, err
+}
+
+func (c *LDAPCollector) DisableUser(ctx context.Context, uid string) (*models.User, error) {
+        start := time.Now()
+        user, err := c.next.DisableUser(ctx, uid)
+        recordOperation("disable_user", start, err)
+        return user, err
+}
+
+func (c *LDAPCollector) EnableUser(ctx context.Context, uid string) (*models.User, error) {
+        start := time.Now()
+        user, err := c.next.EnableUser(ctx, uid)
+        recordOperation("enable_user", start, err)
+        return user, err
+}
+
+func (c *LDAPCollector) SetUserExpiry(ctx context.Context, uid string, expiresAt *time.Time) (*models.User, error) {
+        start := time.Now()
+        user, err := c.next.SetUserExpiry(ctx, uid, expiresAt)
+        recordOperation("set_user_expiry", start, err)
+        return user, err
+}
+
+func (c *LDAPCollector) DeprovisionUser(ctx context.Context, uid string) (*models.DeprovisionResult, error) {
+        start := time.Now()
+        result, err := c.next.DeprovisionUser(ctx, uid)
+        recordOperation("deprovision_user", start, err)
+        return result, err
+}
+
+func (c *LDAPCollector) FindUsersDue(ctx context.Context, now time.Time) (*models.LifecycleDue, error) {
+        start := time.Now()
+        due, err := c.next.FindUsersDue(ctx, now)
+        recordOperation("find_users_due", start, err)
+        return due, err
+}
+
+func (c *LDAPCollector) ListSSHKeys(ctx context.Context, uid string) ([]*models.SSHKey, error) {
+        start := time.Now()
+        keys, err := c.next.ListSSHKeys(ctx, uid)
+        recordOperation("list_ssh_keys", start, err)
+        return keys, err
+}
+
+func (c *LDAPCollector) AddSSHKey(ctx context.Context, uid, authorizedKey string) (*models.SSHKey, error) {
+        start := time.Now()
+        key, err := c.next.AddSSHKey(ctx, uid, authorizedKey)
+        recordOperation("add_ssh_key", start, err)
+        return key, err
+}
+
+func (c *LDAPCollector) RemoveSSHKey(ctx context.Context, uid, fingerprint string) error {
+        start := time.Now()
+        err := c.next.RemoveSSHKey(ctx, uid, fingerprint)
+        recordOperation("remove_ssh_key", start, err)
+        return err
+}
+
+func (c *LDAPCollector) AddUserRepositories(ctx context.Context, uid string, grants []models.RepositoryGrant) (*models.User, error) {
+        start := time.Now()
+        user, err := c.next.AddUserRepositories(ctx, uid, grants)
+        recordOperation("add_user_repos", start, err)
+
+        if err == nil {
+                RepoAssignmentsTotal.WithLabelValues("user").Add(float64(len(grants)))
+        }
+
+        return user, err
+}
+
+func (c *LDAPCollector) RemoveUserRepositories(ctx context.Context, uid string, repos []string) (*models.User, error) {
+        start := time.Now()
+        user, err := c.next.RemoveUserRepositories(ctx, uid, repos)
+        recordOperation("remove_user_repos", start, err)
+        return user, err
+}
+
 // ═══════════════════════════════════════════════════════════════════════════
 // GROUP OPERATIONS
 // ═══════════════════════════════════════════════════════════════════════════
@@ -186,6 +327,19 @@ func (c *LDAPCollector) ListGroups(ctx context.Context) ([]*models.Group, error)
         return groups, err
 }
 
+func (c *LDAPCollector) ListGroupsPage(ctx context.Context, filter *models.GroupFilter, page *models.PageRequest) (*models.GroupPage, error) {
+        start := time.Now()
+        result, err := c.next.ListGroupsPage(ctx, filter, page)
+        recordOperation("list_groups", start, err)
+
+        // Update gauge
+        if err == nil && filter == nil {
+                GroupsTotal.Set(float64(result.Total))
+        }
+
+        return result, err
+}
+
 func (c *LDAPCollector) DeleteGroup(ctx context.Context, cn string) error {
         start := time.Now()
         err := c.next.DeleteGroup(ctx, cn)
@@ -223,18 +377,58 @@ func (c *LDAPCollector) RemoveUserFromGroup(ctx context.Context, uid, groupCN st
         return err
 }
 
-func (c *LDAPCollector) AssignRepositoriesToGroup(ctx context.Context, cn string, repositories []string) (*models.Group, error) {
+func (c *LDAPCollector) AssignRepositoriesToGroup(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error) {
         start := time.Now()
-        group, err := c.next.AssignRepositoriesToGroup(ctx, cn, repositories)
+        group, err := c.next.AssignRepositoriesToGroup(ctx, cn, grants)
         recordOperation("assign_repos_to_group", start, err)
 
         if err == nil {
-                RepoAssignmentsTotal.WithLabelValues("group").Add(float64(len(repositories)))
+                RepoAssignmentsTotal.WithLabelValues("group").Add(float64(len(grants)))
         }
 
         return group, err
 }
 
+func (c *LDAPCollector) AddGroupRepositories(ctx context.Context, cn string, grants []models.RepositoryGrant) (*models.Group, error) {
+        start := time.Now()
+        group, err := c.next.AddGroupRepositories(ctx, cn, grants)
+        recordOperation("add_group_repos", start, err)
+
+        if err == nil {
+                RepoAssignmentsTotal.WithLabelValues("group").Add(float64(len(grants)))
+        }
+
+        return group, err
+}
+
+func (c *LDAPCollector) RemoveGroupRepositories(ctx context.Context, cn string, repos []string) (*models.Group, error) {
+        start := time.Now()
+        group, err := c.next.RemoveGroupRepositories(ctx, cn, repos)
+        recordOperation("remove_group_repos", start, err)
+        return group, err
+}
+
+func (c *LDAPCollector) AddGroupToGroup(ctx context.Context, childCN, parentCN string) error {
+        start := time.Now()
+        err := c.next.AddGroupToGroup(ctx, childCN, parentCN)
+        recordOperation("add_group_to_group", start, err)
+        return err
+}
+
+func (c *LDAPCollector) RemoveGroupFromGroup(ctx context.Context, childCN, parentCN string) error {
+        start := time.Now()
+        err := c.next.RemoveGroupFromGroup(ctx, childCN, parentCN)
+        recordOperation("remove_group_from_group", start, err)
+        return err
+}
+
+func (c *LDAPCollector) GetGroupEffectiveMembers(ctx context.Context, cn string) ([]string, error) {
+        start := time.Now()
+        members, err := c.next.GetGroupEffectiveMembers(ctx, cn)
+        recordOperation("get_group_effective_members", start, err)
+        return members, err
+}
+
 // ═══════════════════════════════════════════════════════════════════════════
 // DEPARTMENT OPERATIONS
 // ═══════════════════════════════════════════════════════════════════════════
@@ -272,6 +466,19 @@ func (c *LDAPCollector) ListDepartments(ctx context.Context) ([]*models.Departme
         return depts, err
 }
 
+func (c *LDAPCollector) ListDepartmentsPage(ctx context.Context, filter *models.DepartmentFilter, page *models.PageRequest) (*models.DepartmentPage, error) {
+        start := time.Now()
+        result, err := c.next.ListDepartmentsPage(ctx, filter, page)
+        recordOperation("list_departments", start, err)
+
+        // Update gauge
+        if err == nil && filter == nil {
+                DepartmentsTotal.Set(float64(result.Total))
+        }
+
+        return result, err
+}
+
 func (c *LDAPCollector) DeleteDepartment(ctx context.Context, ou string) error {
         start := time.Now()
         err := c.next.DeleteDepartment(ctx, ou)
@@ -285,18 +492,37 @@ func (c *LDAPCollector) DeleteDepartment(ctx context.Context, ou string) error {
         return err
 }
 
-func (c *LDAPCollector) AssignRepositoryToDepartment(ctx context.Context, ou string, repos []string) error {
+func (c *LDAPCollector) AssignRepositoryToDepartment(ctx context.Context, ou string, grants []models.RepositoryGrant) error {
         start := time.Now()
-        err := c.next.AssignRepositoryToDepartment(ctx, ou, repos)
+        err := c.next.AssignRepositoryToDepartment(ctx, ou, grants)
         recordOperation("assign_repos_to_department", start, err)
 
         if err == nil {
-                RepoAssignmentsTotal.WithLabelValues("department").Add(float64(len(repos)))
+                RepoAssignmentsTotal.WithLabelValues("department").Add(float64(len(grants)))
         }
 
         return err
 }
 
+func (c *LDAPCollector) AddDepartmentRepositories(ctx context.Context, ou string, grants []models.RepositoryGrant) (*models.Department, error) {
+        start := time.Now()
+        dept, err := c.next.AddDepartmentRepositories(ctx, ou, grants)
+        recordOperation("add_department_repos", start, err)
+
+        if err == nil {
+                RepoAssignmentsTotal.WithLabelValues("department").Add(float64(len(grants)))
+        }
+
+        return dept, err
+}
+
+func (c *LDAPCollector) RemoveDepartmentRepositories(ctx context.Context, ou string, repos []string) (*models.Department, error) {
+        start := time.Now()
+        dept, err := c.next.RemoveDepartmentRepositories(ctx, ou, repos)
+        recordOperation("remove_department_repos", start, err)
+        return dept, err
+}
+
 func (c *LDAPCollector) GetUsersByDepartment(ctx context.Context, department string) ([]*models.User, error) {
         start := time.Now()
         users, err := c.next.GetUsersByDepartment(ctx, department)
@@ -304,6 +530,123 @@ func (c *LDAPCollector) GetUsersByDepartment(ctx context.Context, department str
         return users, err
 }
 
+func (c *LDAPCollector) SetDepartmentParent(ctx context.Context, ou, parent string) (*models.Department, error) {
+        start := time.Now()
+        dept, err := c.next.SetDepartmentParent(ctx, ou, parent)
+        recordOperation("set_department_parent", start, err)
+        return dept, err
+}
+
+func (c *LDAPCollector) GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error) {
+        start := time.Now()
+        children, err := c.next.GetDepartmentChildren(ctx, ou)
+        recordOperation("get_department_children", start, err)
+        return children, err
+}
+
+func (c *LDAPCollector) SetDepartmentManager(ctx context.Context, ou, uid string) (*models.Department, error) {
+        start := time.Now()
+        dept, err := c.next.SetDepartmentManager(ctx, ou, uid)
+        recordOperation("set_department_manager", start, err)
+        return dept, err
+}
+
+func (c *LDAPCollector) AddDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error) {
+        start := time.Now()
+        dept, err := c.next.AddDepartmentDeputy(ctx, ou, uid)
+        recordOperation("add_department_deputy", start, err)
+        return dept, err
+}
+
+func (c *LDAPCollector) RemoveDepartmentDeputy(ctx context.Context, ou, uid string) (*models.Department, error) {
+        start := time.Now()
+        dept, err := c.next.RemoveDepartmentDeputy(ctx, ou, uid)
+        recordOperation("remove_department_deputy", start, err)
+        return dept, err
+}
+
+func (c *LDAPCollector) GetManagedDepartments(ctx context.Context, uid string) ([]*models.Department, error) {
+        start := time.Now()
+        depts, err := c.next.GetManagedDepartments(ctx, uid)
+        recordOperation("get_managed_departments", start, err)
+        return depts, err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// EFFECTIVE ACCESS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (c *LDAPCollector) GetEffectiveAccess(ctx context.Context, uid string) (*models.EffectiveAccess, error) {
+        start := time.Now()
+        access, err := c.next.GetEffectiveAccess(ctx, uid)
+        recordOperation("get_effective_access", start, err)
+        return access, err
+}
+
+func (c *LDAPCollector) MigrateRepositoryGrants(ctx context.Context) (*models.GrantMigrationResult, error) {
+        start := time.Now()
+        result, err := c.next.MigrateRepositoryGrants(ctx)
+        recordOperation("migrate_repository_grants", start, err)
+        return result, err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// ACCESS REQUESTS
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (c *LDAPCollector) CreateAccessRequest(ctx context.Context, input *models.AccessRequestInput) (*models.AccessRequest, error) {
+        start := time.Now()
+        request, err := c.next.CreateAccessRequest(ctx, input)
+        recordOperation("create_access_request", start, err)
+        return request, err
+}
+
+func (c *LDAPCollector) GetAccessRequest(ctx context.Context, id string) (*models.AccessRequest, error) {
+        start := time.Now()
+        request, err := c.next.GetAccessRequest(ctx, id)
+        recordOperation("get_access_request", start, err)
+        return request, err
+}
+
+func (c *LDAPCollector) ListAccessRequests(ctx context.Context, filter *models.AccessRequestFilter) ([]*models.AccessRequest, error) {
+        start := time.Now()
+        requests, err := c.next.ListAccessRequests(ctx, filter)
+        recordOperation("list_access_requests", start, err)
+        return requests, err
+}
+
+func (c *LDAPCollector) ReviewAccessRequest(ctx context.Context, id, reviewer string, approve bool) (*models.AccessRequest, error) {
+        start := time.Now()
+        request, err := c.next.ReviewAccessRequest(ctx, id, reviewer, approve)
+        recordOperation("review_access_request", start, err)
+        return request, err
+}
+
+func (c *LDAPCollector) RevokeExpiredGrants(ctx context.Context, now time.Time) (*models.GrantSweepResult, error) {
+        start := time.Now()
+        result, err := c.next.RevokeExpiredGrants(ctx, now)
+        recordOperation("revoke_expired_grants", start, err)
+        return result, err
+}
+
+// ═══════════════════════════════════════════════════════════════════════════
+// BACKUP & RESTORE
+// ═══════════════════════════════════════════════════════════════════════════
+
+func (c *LDAPCollector) ExportDirectory(ctx context.Context) ([]*models.DirectoryEntry, error) {
+        start := time.Now()
+        entries, err := c.next.ExportDirectory(ctx)
+        recordOperation("export_directory", start, err)
+        return entries, err
+}
+
+func (c *LDAPCollector) RestoreDirectory(ctx context.Context, entries []*models.DirectoryEntry, dryRun bool) (*models.RestoreResult, error) {
+        start := time.Now()
+        result, err := c.next.RestoreDirectory(ctx, entries, dryRun)
+        recordOperation("restore_directory", start, err)
+        return result, err
+}
+
 // ═══════════════════════════════════════════════════════════════════════════
 // HEALTH & STATS
 // ═══════════════════════════════════════════════════════════════════════════
@@ -183,14 +183,98 @@ var (
 		},
 	)
 
-	// PoolSize - Gauge of pool size
+	// PoolSize - Gauge of pool size (open connections)
 	PoolSize = promclient.NewGauge(
 		promclient.GaugeOpts{
 			Name: "ldap_pool_size",
-			Help: "Size of the LDAP connection pool",
+			Help: "Number of open connections in the LDAP connection pool",
 		},
 	)
 
+	// PoolMinSize - Gauge of the configured minimum pool size
+	PoolMinSize = promclient.NewGauge(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_min_size",
+			Help: "Minimum number of connections kept in the LDAP connection pool",
+		},
+	)
+
+	// PoolMaxSize - Gauge of the configured maximum pool size
+	PoolMaxSize = promclient.NewGauge(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_max_size",
+			Help: "Maximum number of connections in the LDAP connection pool",
+		},
+	)
+
+	// PoolWaits - Gauge of checkouts that had to wait for a connection
+	PoolWaits = promclient.NewGauge(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_waits",
+			Help: "Total number of connection checkouts that waited for a free connection",
+		},
+	)
+
+	// PoolWaitSeconds - Gauge of total time spent waiting for connections
+	PoolWaitSeconds = promclient.NewGauge(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_wait_seconds",
+			Help: "Total time spent waiting for a free LDAP connection in seconds",
+		},
+	)
+
+	// PoolTimeouts - Gauge of checkouts that timed out
+	PoolTimeouts = promclient.NewGauge(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_timeouts",
+			Help: "Total number of connection checkouts that timed out",
+		},
+	)
+
+	// PoolConnectionsClosed - Gauge of connections retired by the pool
+	PoolConnectionsClosed = promclient.NewGaugeVec(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_connections_closed",
+			Help: "Total number of LDAP connections retired by the pool",
+		},
+		[]string{"reason"}, // "lifetime" or "idle"
+	)
+
+	// PoolConnectionsCreated - Gauge of connections opened by the pool
+	PoolConnectionsCreated = promclient.NewGauge(
+		promclient.GaugeOpts{
+			Name: "ldap_pool_connections_created",
+			Help: "Total number of LDAP connections opened by the pool",
+		},
+	)
+
+	// BackendUp - Gauge of whether each LDAP server's circuit breaker is closed
+	BackendUp = promclient.NewGaugeVec(
+		promclient.GaugeOpts{
+			Name: "ldap_backend_up",
+			Help: "Whether the LDAP server is accepting requests (1) or marked down (0)",
+		},
+		[]string{"backend", "role"}, // role is "provider" or "consumer"
+	)
+
+	// BackendRequests - Gauge of connections handed out per LDAP server
+	BackendRequests = promclient.NewGaugeVec(
+		promclient.GaugeOpts{
+			Name: "ldap_backend_requests",
+			Help: "Total number of operations served by each LDAP server",
+		},
+		[]string{"backend", "role"},
+	)
+
+	// BackendConnections - Gauge of open connections per LDAP server
+	BackendConnections = promclient.NewGaugeVec(
+		promclient.GaugeOpts{
+			Name: "ldap_backend_connections",
+			Help: "Number of open pooled connections to each LDAP server",
+		},
+		[]string{"backend", "role"},
+	)
+
 	// ═══════════════════════════════════════════════════════════════════════════
 	// REPOSITORY ASSIGNMENT METRICS
 	// ═══════════════════════════════════════════════════════════════════════════
@@ -203,6 +287,37 @@ var (
 		},
 		[]string{"entity_type"}, // "user", "group", "department"
 	)
+
+	// ═══════════════════════════════════════════════════════════════════════════
+	// EVENT METRICS
+	// ═══════════════════════════════════════════════════════════════════════════
+
+	// EventsPublishedTotal - Counter of directory change events published
+	EventsPublishedTotal = promclient.NewCounterVec(
+		promclient.CounterOpts{
+			Name: "ldap_events_published_total",
+			Help: "Total number of directory change events published",
+		},
+		[]string{"type"}, // event type, e.g. "group.member_added"
+	)
+
+	// EventsDroppedTotal - Counter of events dropped for slow subscribers
+	EventsDroppedTotal = promclient.NewCounterVec(
+		promclient.CounterOpts{
+			Name: "ldap_events_dropped_total",
+			Help: "Total number of events dropped because a consumer fell behind",
+		},
+		[]string{"consumer"}, // "subscription" or "webhook"
+	)
+
+	// WebhookDeliveriesTotal - Counter of webhook delivery attempts
+	WebhookDeliveriesTotal = promclient.NewCounterVec(
+		promclient.CounterOpts{
+			Name: "ldap_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts",
+		},
+		[]string{"status"}, // "success", "retry" or "failed"
+	)
 )
 
 // Init registers all metrics with Prometheus
@@ -227,6 +342,19 @@ func Init() {
 		PoolIdleConnections,
 		PoolTotalRequests,
 		PoolSize,
+		PoolMinSize,
+		PoolMaxSize,
+		PoolWaits,
+		PoolWaitSeconds,
+		PoolTimeouts,
+		PoolConnectionsClosed,
+		PoolConnectionsCreated,
+		BackendUp,
+		BackendRequests,
+		BackendConnections,
 		RepoAssignmentsTotal,
+		EventsPublishedTotal,
+		EventsDroppedTotal,
+		WebhookDeliveriesTotal,
 	)
 }
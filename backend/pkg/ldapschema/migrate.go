@@ -0,0 +1,289 @@
+package ldapschema
+
+import (
+	"fmt"
+	"strconv"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// MarkerCN names the entry directly below the base DN that records the
+// version of the newest migration applied to the directory
+const MarkerCN = "schemaVersion"
+
+// versionAttribute holds the version on the marker entry
+const versionAttribute = "devplatformSchemaVersion"
+
+// Migration is one step in the history of the devplatform directory.
+// Steps must be idempotent: a directory is migrated from its recorded
+// version, and installations that predate the marker start from 0 even
+// though most of the schema is already loaded.
+type Migration struct {
+	Version     int
+	Description string
+	// AttributeTypes are registered in cn=config, skipping those already
+	// defined
+	AttributeTypes []AttributeType
+	// Data, when set, runs after AttributeTypes on a connection bound as
+	// the directory admin
+	Data func(conn *ldap.Conn, baseDN string) error
+}
+
+// Migrations are the steps taking an empty directory to the current
+// schema, in version order. Append a migration, never edit a released
+// one: adding an attribute type to AttributeTypes and to a new migration
+// is what upgrades existing installations.
+var Migrations = []Migration{
+	{
+		Version:        1,
+		Description:    "repository attribute and schema version marker",
+		AttributeTypes: attributeTypes("githubRepository", versionAttribute),
+	},
+	{
+		Version:        2,
+		Description:    "account lifecycle",
+		AttributeTypes: attributeTypes("passwordHistory", "accountStatus", "accountExpires", "deprovisionAt"),
+	},
+	{
+		Version:        3,
+		Description:    "department hierarchy",
+		AttributeTypes: attributeTypes("parentDepartment"),
+	},
+	{
+		Version:        4,
+		Description:    "repository grants with permission levels",
+		AttributeTypes: attributeTypes("repositoryGrant"),
+	},
+	{
+		Version:     5,
+		Description: "access requests",
+		AttributeTypes: attributeTypes("accessRequestStatus", "accessRequester", "accessRequestDuration",
+			"accessRequestedAt", "accessReviewer", "accessReviewedAt"),
+	},
+	{
+		Version:        6,
+		Description:    "department deputies",
+		AttributeTypes: attributeTypes("departmentDeputy"),
+	},
+	{
+		Version:        7,
+		Description:    "SSH public keys",
+		AttributeTypes: attributeTypes("sshPublicKey"),
+	},
+	{
+		Version:     8,
+		Description: "extensible departments and groups",
+		Data:        extendDepartmentsAndGroups,
+	},
+}
+
+// Latest returns the version a fully migrated directory records
+func Latest() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Pending returns the migrations newer than version, in order
+func Pending(version int) []Migration {
+	for i, migration := range Migrations {
+		if migration.Version > version {
+			return Migrations[i:]
+		}
+	}
+	return nil
+}
+
+// Migrator brings a directory up to the Latest version
+type Migrator struct {
+	// Config is bound as the cn=config admin. It may be nil, in which case
+	// migrating stops at the first migration registering attribute types.
+	Config *ldap.Conn
+	// Data is bound as the directory admin
+	Data   *ldap.Conn
+	BaseDN string
+	// Extra attribute types, such as custom user attributes, are registered
+	// on every run once the migrations are applied, since they come from
+	// configuration rather than from a release
+	Extra []AttributeType
+}
+
+// MigrationResult reports what Migrate changed
+type MigrationResult struct {
+	// From and To are the recorded versions before and after
+	From, To int
+	Applied  []Migration
+	// Extra are the names of the extra attribute types added
+	Extra []string
+}
+
+// Migrate applies the pending migrations in order, recording the version
+// after each, so a failed run resumes where it stopped. A directory
+// recording a version newer than Latest, migrated by a newer release, is
+// left alone. The result is never nil, so the migrations applied before a
+// failure can be reported.
+func (m *Migrator) Migrate() (*MigrationResult, error) {
+	result := &MigrationResult{}
+	version, err := Version(m.Data, m.BaseDN)
+	if err != nil {
+		return result, err
+	}
+	result.From, result.To = version, version
+
+	for _, migration := range Pending(version) {
+		if len(migration.AttributeTypes) > 0 {
+			if m.Config == nil {
+				return result, fmt.Errorf("migration %d (%s) needs the cn=config admin", migration.Version, migration.Description)
+			}
+			if _, err := ensureTypes(m.Config, migration.AttributeTypes); err != nil {
+				return result, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+			}
+		}
+		if migration.Data != nil {
+			if err := migration.Data(m.Data, m.BaseDN); err != nil {
+				return result, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+			}
+		}
+		if err := setVersion(m.Data, m.BaseDN, migration.Version); err != nil {
+			return result, err
+		}
+		result.To = migration.Version
+		result.Applied = append(result.Applied, migration)
+	}
+
+	if len(m.Extra) > 0 && m.Config != nil && result.To >= Latest() {
+		added, err := ensureTypes(m.Config, m.Extra)
+		if err != nil {
+			return result, fmt.Errorf("failed to register extra attribute types: %w", err)
+		}
+		result.Extra = added
+	}
+	return result, nil
+}
+
+// MarkerDN returns the DN of the version marker below baseDN
+func MarkerDN(baseDN string) string {
+	return fmt.Sprintf("cn=%s,%s", MarkerCN, baseDN)
+}
+
+// Version reads the version recorded in the directory, 0 when it has never
+// been migrated
+func Version(conn *ldap.Conn, baseDN string) (int, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		MarkerDN(baseDN),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{versionAttribute},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return 0, nil
+	}
+	return parseVersion(result.Entries[0].GetAttributeValue(versionAttribute))
+}
+
+// parseVersion parses a recorded version; a marker without one is at 0
+func parseVersion(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid schema version %q", value)
+	}
+	return version, nil
+}
+
+// setVersion records version on the marker entry, creating it when missing
+func setVersion(conn *ldap.Conn, baseDN string, version int) error {
+	value := []string{strconv.Itoa(version)}
+
+	modifyReq := ldap.NewModifyRequest(MarkerDN(baseDN), nil)
+	modifyReq.Replace(versionAttribute, value)
+	err := conn.Modify(modifyReq)
+	if err == nil {
+		return nil
+	}
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	addReq := ldap.NewAddRequest(MarkerDN(baseDN), nil)
+	addReq.Attribute("objectClass", []string{"applicationProcess", "extensibleObject"})
+	addReq.Attribute("cn", []string{MarkerCN})
+	addReq.Attribute(versionAttribute, value)
+	if err := conn.Add(addReq); err != nil {
+		// Another entry point created the marker concurrently
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			if err := conn.Modify(modifyReq); err != nil {
+				return fmt.Errorf("failed to record schema version: %w", err)
+			}
+			return nil
+		}
+		return fmt.Errorf("failed to create schema version marker: %w", err)
+	}
+	return nil
+}
+
+// extendDepartmentsAndGroups adds extensibleObject to the departments and
+// groups created without it, e.g. by older init-ldap runs, so they can
+// hold the devplatform attributes
+func extendDepartmentsAndGroups(conn *ldap.Conn, baseDN string) error {
+	containers := []struct{ dn, objectClass string }{
+		{"ou=departments," + baseDN, "organizationalUnit"},
+		{"ou=groups," + baseDN, "groupOfNames"},
+	}
+	for _, container := range containers {
+		result, err := conn.Search(ldap.NewSearchRequest(
+			container.dn,
+			ldap.ScopeSingleLevel,
+			ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf("(&(objectClass=%s)(!(objectClass=extensibleObject)))", container.objectClass),
+			[]string{"dn"},
+			nil,
+		))
+		if err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+				continue
+			}
+			return fmt.Errorf("failed to search %s: %w", container.dn, err)
+		}
+		for _, entry := range result.Entries {
+			modifyReq := ldap.NewModifyRequest(entry.DN, nil)
+			modifyReq.Add("objectClass", []string{"extensibleObject"})
+			err := conn.Modify(modifyReq)
+			if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) {
+				return fmt.Errorf("failed to extend %s: %w", entry.DN, err)
+			}
+		}
+	}
+	return nil
+}
+
+// attributeTypes looks up AttributeTypes by name. Migrations are declared
+// at init, so an unknown name is a programming error.
+func attributeTypes(names ...string) []AttributeType {
+	types := make([]AttributeType, 0, len(names))
+	for _, name := range names {
+		attr, ok := attributeType(name)
+		if !ok {
+			panic("ldapschema: migration uses unknown attribute type " + name)
+		}
+		types = append(types, attr)
+	}
+	return types
+}
+
+func attributeType(name string) (AttributeType, bool) {
+	for _, attr := range AttributeTypes {
+		if attr.Name == name {
+			return attr, true
+		}
+	}
+	return AttributeType{}, false
+}
@@ -0,0 +1,82 @@
+package ldapschema
+
+import (
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	introduced := make(map[string]int)
+	for i, migration := range Migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d has version %d, want versions numbered from 1 without gaps", i, migration.Version)
+		}
+		if migration.Description == "" {
+			t.Errorf("migration %d has no description", migration.Version)
+		}
+		if len(migration.AttributeTypes) == 0 && migration.Data == nil {
+			t.Errorf("migration %d changes nothing", migration.Version)
+		}
+		for _, attr := range migration.AttributeTypes {
+			if version, ok := introduced[attr.Name]; ok {
+				t.Errorf("%s is introduced by migrations %d and %d", attr.Name, version, migration.Version)
+			}
+			introduced[attr.Name] = migration.Version
+		}
+	}
+
+	// Otherwise existing installations never get the attribute
+	for _, attr := range AttributeTypes {
+		if _, ok := introduced[attr.Name]; !ok {
+			t.Errorf("%s is not introduced by any migration", attr.Name)
+		}
+	}
+
+	// The marker can only be written once its attribute is registered
+	if introduced[versionAttribute] != 1 {
+		t.Errorf("%s is introduced by migration %d, want 1", versionAttribute, introduced[versionAttribute])
+	}
+}
+
+func TestPending(t *testing.T) {
+	tests := []struct {
+		version   int
+		wantFirst int
+		wantCount int
+	}{
+		{version: 0, wantFirst: 1, wantCount: len(Migrations)},
+		{version: 3, wantFirst: 4, wantCount: len(Migrations) - 3},
+		{version: Latest(), wantCount: 0},
+		{version: Latest() + 1, wantCount: 0},
+	}
+
+	for _, tt := range tests {
+		pending := Pending(tt.version)
+		if len(pending) != tt.wantCount {
+			t.Errorf("Pending(%d) returned %d migrations, want %d", tt.version, len(pending), tt.wantCount)
+			continue
+		}
+		if tt.wantCount > 0 && pending[0].Version != tt.wantFirst {
+			t.Errorf("Pending(%d) starts at %d, want %d", tt.version, pending[0].Version, tt.wantFirst)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "7", want: 7},
+		{value: "-1", wantErr: true},
+		{value: "seven", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseVersion(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseVersion(%q) = %d, %v, want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Package ldapschema migrates a directory to the devplatform schema: it
+// registers the devplatform attribute types in an OpenLDAP cn=config and
+// upgrades existing entries, one versioned migration at a time. It is
+// shared by the controller's initializer, init-ldap.go and the openldap
+// chart's init container, so every entry point upgrades existing
+// installations the same way.
+package ldapschema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// SchemaDN is the cn=config entry holding the devplatform attribute types
+const SchemaDN = "cn=devplatform,cn=schema,cn=config"
+
+// AttributeType is an attribute type registered in cn=config
+type AttributeType struct {
+	Name       string
+	Definition string
+}
+
+// AttributeTypes are the devplatform schema attributes, in OID order. New
+// attributes are appended and introduced by a new entry in Migrations.
+var AttributeTypes = []AttributeType{
+	{
+		Name: "githubRepository",
+		Definition: "( 1.3.6.1.4.1.99999.1.1 NAME 'githubRepository' " +
+			"DESC 'Repository URL (GitHub/Gitea)' " +
+			"EQUALITY caseIgnoreMatch " +
+			"SUBSTR caseIgnoreSubstringsMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	},
+	{
+		Name: "passwordHistory",
+		Definition: "( 1.3.6.1.4.1.99999.1.2 NAME 'passwordHistory' " +
+			"DESC 'Hashes of previous passwords, newest first' " +
+			"EQUALITY octetStringMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.40 )",
+	},
+	{
+		Name: "accountStatus",
+		Definition: "( 1.3.6.1.4.1.99999.1.3 NAME 'accountStatus' " +
+			"DESC 'Account lifecycle state: active, disabled or deprovisioned' " +
+			"EQUALITY caseIgnoreMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	},
+	{
+		Name: "accountExpires",
+		Definition: "( 1.3.6.1.4.1.99999.1.4 NAME 'accountExpires' " +
+			"DESC 'Time after which the account is disabled' " +
+			"EQUALITY generalizedTimeMatch " +
+			"ORDERING generalizedTimeOrderingMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE )",
+	},
+	{
+		Name: "deprovisionAt",
+		Definition: "( 1.3.6.1.4.1.99999.1.5 NAME 'deprovisionAt' " +
+			"DESC 'Time after which a deprovisioned account is deleted' " +
+			"EQUALITY generalizedTimeMatch " +
+			"ORDERING generalizedTimeOrderingMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE )",
+	},
+	{
+		Name: "parentDepartment",
+		Definition: "( 1.3.6.1.4.1.99999.1.6 NAME 'parentDepartment' " +
+			"DESC 'DN of the parent department' " +
+			"EQUALITY distinguishedNameMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 SINGLE-VALUE )",
+	},
+	{
+		Name: "repositoryGrant",
+		Definition: "( 1.3.6.1.4.1.99999.1.7 NAME 'repositoryGrant' " +
+			"DESC 'Repository grant: URL, permission and optional RFC3339 expiry' " +
+			"EQUALITY caseIgnoreMatch " +
+			"SUBSTR caseIgnoreSubstringsMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	},
+	{
+		Name: "accessRequestStatus",
+		Definition: "( 1.3.6.1.4.1.99999.1.8 NAME 'accessRequestStatus' " +
+			"DESC 'Access request state: pending, approved, denied or expired' " +
+			"EQUALITY caseIgnoreMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	},
+	{
+		Name: "accessRequester",
+		Definition: "( 1.3.6.1.4.1.99999.1.9 NAME 'accessRequester' " +
+			"DESC 'uid of the user requesting access' " +
+			"EQUALITY caseIgnoreMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	},
+	{
+		Name: "accessRequestDuration",
+		Definition: "( 1.3.6.1.4.1.99999.1.10 NAME 'accessRequestDuration' " +
+			"DESC 'Requested access duration in seconds' " +
+			"EQUALITY integerMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
+	},
+	{
+		Name: "accessRequestedAt",
+		Definition: "( 1.3.6.1.4.1.99999.1.11 NAME 'accessRequestedAt' " +
+			"DESC 'Time the access request was made' " +
+			"EQUALITY generalizedTimeMatch " +
+			"ORDERING generalizedTimeOrderingMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE )",
+	},
+	{
+		Name: "accessReviewer",
+		Definition: "( 1.3.6.1.4.1.99999.1.12 NAME 'accessReviewer' " +
+			"DESC 'uid of the user who approved or denied the access request' " +
+			"EQUALITY caseIgnoreMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	},
+	{
+		Name: "accessReviewedAt",
+		Definition: "( 1.3.6.1.4.1.99999.1.13 NAME 'accessReviewedAt' " +
+			"DESC 'Time the access request was approved or denied' " +
+			"EQUALITY generalizedTimeMatch " +
+			"ORDERING generalizedTimeOrderingMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE )",
+	},
+	{
+		Name: "departmentDeputy",
+		Definition: "( 1.3.6.1.4.1.99999.1.14 NAME 'departmentDeputy' " +
+			"DESC 'DN of a user who manages the department besides its manager' " +
+			"EQUALITY distinguishedNameMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 )",
+	},
+	{
+		// openssh-lpk's attribute, under its usual OID, so Gitea and sssd
+		// find keys where they expect them. Servers that already load
+		// openssh-lpk keep their definition: Ensure skips loaded names.
+		Name: "sshPublicKey",
+		Definition: "( 1.3.6.1.4.1.24552.500.1.1.1.13 NAME 'sshPublicKey' " +
+			"DESC 'MANDATORY: OpenSSH Public key' " +
+			"EQUALITY octetStringMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.40 )",
+	},
+	{
+		Name: versionAttribute,
+		Definition: "( 1.3.6.1.4.1.99999.1.15 NAME '" + versionAttribute + "' " +
+			"DESC 'Version of the newest devplatform migration applied' " +
+			"EQUALITY integerMatch " +
+			"SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
+	},
+}
+
+// Ensure registers all of AttributeTypes followed by extra on a connection
+// bound as the cn=config admin, regardless of the recorded version. Entry
+// points migrate with a Migrator instead. It returns the names of the
+// types added.
+func Ensure(conn *ldap.Conn, extra ...AttributeType) ([]string, error) {
+	return ensureTypes(conn, append(append([]AttributeType{}, AttributeTypes...), extra...))
+}
+
+// ensureTypes registers types on a connection bound as the cn=config
+// admin. The devplatform schema entry is created when missing; otherwise
+// the attribute types it lacks are added to it. Types whose name any
+// loaded schema already defines, e.g. sshPublicKey from openssh-lpk, are
+// skipped rather than redefined. It returns the names of the types added.
+func ensureTypes(conn *ldap.Conn, types []AttributeType) ([]string, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		"cn=schema,cn=config",
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=olcSchemaConfig)",
+		[]string{"cn", "olcAttributeTypes"},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read loaded schemas: %w", err)
+	}
+
+	var own *ldap.Entry
+	var definitions []string
+	for _, entry := range result.Entries {
+		if isDevplatformEntry(entry) {
+			own = entry
+		}
+		definitions = append(definitions, entry.GetAttributeValues("olcAttributeTypes")...)
+	}
+
+	missing := Missing(definitions, types)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(missing))
+	values := make([]string, len(missing))
+	for i, attr := range missing {
+		names[i] = attr.Name
+		values[i] = attr.Definition
+	}
+
+	if own == nil {
+		addReq := ldap.NewAddRequest(SchemaDN, nil)
+		addReq.Attribute("objectClass", []string{"olcSchemaConfig"})
+		addReq.Attribute("cn", []string{"devplatform"})
+		addReq.Attribute("olcAttributeTypes", values)
+		if err := conn.Add(addReq); err != nil {
+			return nil, fmt.Errorf("failed to add custom schema: %w", err)
+		}
+		return names, nil
+	}
+
+	modifyReq := ldap.NewModifyRequest(own.DN, nil)
+	modifyReq.Add("olcAttributeTypes", values)
+	if err := conn.Modify(modifyReq); err != nil {
+		return nil, fmt.Errorf("failed to upgrade custom schema: %w", err)
+	}
+	return names, nil
+}
+
+// Missing returns the types whose name is not defined by any of the loaded
+// attribute type definitions. Names compare case-insensitively, as LDAP
+// attribute descriptions do.
+func Missing(loaded []string, types []AttributeType) []AttributeType {
+	defined := make(map[string]bool)
+	for _, definition := range loaded {
+		for _, name := range DefinedNames(definition) {
+			defined[strings.ToLower(name)] = true
+		}
+	}
+
+	var missing []AttributeType
+	for _, attr := range types {
+		key := strings.ToLower(attr.Name)
+		if defined[key] {
+			continue
+		}
+		defined[key] = true
+		missing = append(missing, attr)
+	}
+	return missing
+}
+
+// nameClause matches the NAME of an attribute type description, either a
+// single 'name' or a parenthesised list of them
+var nameClause = regexp.MustCompile(`\bNAME\s+(\([^)]*\)|'[^']*')`)
+
+var quotedName = regexp.MustCompile(`'([^']*)'`)
+
+// DefinedNames returns the names an RFC 4512 attribute type description
+// defines. cn=config values may carry an ordering prefix such as {3}.
+func DefinedNames(definition string) []string {
+	clause := nameClause.FindStringSubmatch(definition)
+	if clause == nil {
+		return nil
+	}
+	var names []string
+	for _, match := range quotedName.FindAllStringSubmatch(clause[1], -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// isDevplatformEntry reports whether entry is the devplatform schema, whose
+// cn cn=config prefixes with its load order, e.g. {4}devplatform
+func isDevplatformEntry(entry *ldap.Entry) bool {
+	cn := entry.GetAttributeValue("cn")
+	if i := strings.Index(cn, "}"); strings.HasPrefix(cn, "{") && i > 0 {
+		cn = cn[i+1:]
+	}
+	return strings.EqualFold(cn, "devplatform")
+}
@@ -0,0 +1,104 @@
+package ldapschema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefinedNames(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       []string
+	}{
+		{
+			name:       "single name",
+			definition: "( 1.3.6.1.4.1.99999.1.1 NAME 'githubRepository' DESC 'Repository URL' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+			want:       []string{"githubRepository"},
+		},
+		{
+			name:       "cn=config ordering prefix",
+			definition: "{3}( 1.3.6.1.4.1.24552.500.1.1.1.13 NAME 'sshPublicKey' DESC 'MANDATORY: OpenSSH Public key' )",
+			want:       []string{"sshPublicKey"},
+		},
+		{
+			name:       "name list",
+			definition: "( 2.5.4.3 NAME ( 'cn' 'commonName' ) DESC 'RFC4519: common name(s)' SUP name )",
+			want:       []string{"cn", "commonName"},
+		},
+		{
+			name:       "description is not a name",
+			definition: "( 1.2.3 NAME 'a' DESC 'sshPublicKey' )",
+			want:       []string{"a"},
+		},
+		{
+			name:       "no name",
+			definition: "( 1.2.3 DESC 'anonymous' )",
+			want:       nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefinedNames(tt.definition); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DefinedNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissing(t *testing.T) {
+	types := []AttributeType{
+		{Name: "githubRepository", Definition: "( 1 NAME 'githubRepository' )"},
+		{Name: "sshPublicKey", Definition: "( 2 NAME 'sshPublicKey' )"},
+		{Name: "team", Definition: "( 3 NAME 'team' )"},
+	}
+
+	tests := []struct {
+		name   string
+		loaded []string
+		types  []AttributeType
+		want   []string
+	}{
+		{
+			name:  "fresh server",
+			types: types,
+			want:  []string{"githubRepository", "sshPublicKey", "team"},
+		},
+		{
+			name:   "older devplatform schema",
+			loaded: []string{"{0}( 1 NAME 'githubRepository' )"},
+			types:  types,
+			want:   []string{"sshPublicKey", "team"},
+		},
+		{
+			name:   "openssh-lpk already loaded",
+			loaded: []string{"{0}( 1 NAME 'githubRepository' )", "{0}( 1.3.6.1.4.1.24552.500.1.1.1.13 NAME 'sshPublicKey' )"},
+			types:  types,
+			want:   []string{"team"},
+		},
+		{
+			name:   "names compare case-insensitively",
+			loaded: []string{"( 1 NAME 'GITHUBREPOSITORY' )", "( 2 NAME 'sshpublickey' )", "( 3 NAME 'Team' )"},
+			types:  types,
+			want:   nil,
+		},
+		{
+			name:  "duplicates are added once",
+			types: append(append([]AttributeType{}, types...), AttributeType{Name: "Team", Definition: "( 4 NAME 'Team' )"}),
+			want:  []string{"githubRepository", "sshPublicKey", "team"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, attr := range Missing(tt.loaded, tt.types) {
+				got = append(got, attr.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Missing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Package ldaptls secures LDAP connections with StartTLS or LDAPS, using
+// an optional CA bundle and client certificate that are reloaded from disk
+// when they are rotated.
+package ldaptls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// TLS modes
+const (
+	ModeNone     = "none"     // plaintext, unless the URL is ldaps://
+	ModeStartTLS = "starttls" // upgrade ldap:// connections with StartTLS
+	ModeLDAPS    = "ldaps"    // require ldaps:// URLs
+)
+
+// Options configures how connections are secured
+type Options struct {
+	Mode string
+	// CAFile is a PEM bundle used instead of the system roots
+	CAFile string
+	// CertFile and KeyFile are the client certificate presented to the
+	// server, e.g. for SASL EXTERNAL binds
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name the server certificate is
+	// verified against
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// Source hands out TLS configurations built from the current certificate
+// files. Reload re-reads the files when they have changed; connections
+// opened afterwards use the new material, and Generation lets callers
+// retire connections opened before.
+type Source struct {
+	opts Options
+
+	mu         sync.RWMutex
+	roots      *x509.CertPool
+	cert       *tls.Certificate
+	modTimes   map[string]time.Time
+	generation uint64
+}
+
+// NewSource validates opts and loads the certificate files
+func NewSource(opts Options) (*Source, error) {
+	opts.Mode = strings.ToLower(strings.TrimSpace(opts.Mode))
+	if opts.Mode == "" {
+		opts.Mode = ModeNone
+	}
+	switch opts.Mode {
+	case ModeNone, ModeStartTLS, ModeLDAPS:
+	default:
+		return nil, fmt.Errorf("unknown LDAP TLS mode %q (want none, starttls or ldaps)", opts.Mode)
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+	}
+
+	s := &Source{opts: opts}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Mode returns the configured TLS mode
+func (s *Source) Mode() string {
+	return s.opts.Mode
+}
+
+// HasClientCertificate reports whether a client certificate is configured
+func (s *Source) HasClientCertificate() bool {
+	return s.opts.CertFile != ""
+}
+
+// Generation is incremented every time new certificate material is loaded
+func (s *Source) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// Reload re-reads the certificate files if any of them changed since they
+// were last loaded, and reports whether it did. On error the previous
+// material stays in use.
+func (s *Source) Reload() (bool, error) {
+	s.mu.RLock()
+	changed := false
+	for _, path := range s.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			s.mu.RUnlock()
+			return false, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			changed = true
+		}
+	}
+	s.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	if err := s.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// files returns the certificate files in use
+func (s *Source) files() []string {
+	var files []string
+	for _, path := range []string{s.opts.CAFile, s.opts.CertFile, s.opts.KeyFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// load reads the certificate files and swaps them in
+func (s *Source) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, path := range s.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	var roots *x509.CertPool
+	if s.opts.CAFile != "" {
+		pem, err := os.ReadFile(s.opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", s.opts.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if s.opts.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(s.opts.CertFile, s.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roots = roots
+	s.cert = cert
+	s.modTimes = modTimes
+	s.generation++
+	return nil
+}
+
+// Config returns a TLS configuration for connecting to host
+func (s *Source) Config(host string) *tls.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	serverName := s.opts.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		RootCAs:            s.roots,
+		InsecureSkipVerify: s.opts.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if s.cert != nil {
+		cfg.Certificates = []tls.Certificate{*s.cert}
+	}
+	return cfg
+}
+
+// Dial connects to rawURL and secures the connection according to the
+// mode: ldaps:// URLs are dialled over TLS, ldap:// URLs are upgraded with
+// StartTLS in starttls mode and refused in ldaps mode. A nil Source dials
+// in plaintext.
+func (s *Source) Dial(rawURL string, opts ...ldap.DialOpt) (*ldap.Conn, error) {
+	if s == nil {
+		return ldap.DialURL(rawURL, opts...)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL %q: %w", rawURL, err)
+	}
+	cfg := s.Config(u.Hostname())
+
+	switch {
+	case u.Scheme == "ldaps":
+		return ldap.DialURL(rawURL, append(opts, ldap.DialWithTLSConfig(cfg))...)
+	case s.opts.Mode == ModeLDAPS:
+		return nil, fmt.Errorf("LDAP TLS mode is ldaps but %s is not an ldaps:// URL", rawURL)
+	}
+
+	conn, err := ldap.DialURL(rawURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Mode == ModeStartTLS {
+		if err := conn.StartTLS(cfg); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
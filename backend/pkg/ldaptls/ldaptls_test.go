@@ -0,0 +1,471 @@
+package ldaptls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	serverName = "ldap.test"
+
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+)
+
+// testCA issues certificates signed by a throwaway key
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for cn, valid for serverName when it is a
+// server certificate
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if usage == x509.ExtKeyUsageServerAuth {
+		template.DNSNames = []string{serverName}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, certPEM, keyPEM
+}
+
+// writeFile writes data to name in dir and moves its modification time
+// forward, so rewrites within the file system's timestamp resolution are
+// still seen as changes
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	} else {
+		modTime = time.Now()
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// session is what the server saw of a connection by the time of its bind
+type session struct {
+	transport  string // plain, starttls or ldaps
+	serverName string
+	clientCN   string
+}
+
+// testServer answers StartTLS and bind requests, over LDAPS when ldaps is
+// set, and records every bound session
+type testServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	ldaps    bool
+
+	mu       sync.Mutex
+	sessions []session
+}
+
+func newTestServer(t *testing.T, ca *testCA, ldaps bool) *testServer {
+	t.Helper()
+	cert, _, _ := ca.issue(t, serverName, x509.ExtKeyUsageServerAuth)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		listener: listener,
+		tls: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    clientCAs,
+		},
+		ldaps: ldaps,
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *testServer) URL(scheme string) string {
+	return scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *testServer) bound() []session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]session(nil), s.sessions...)
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	current := session{transport: "plain"}
+	secure := func(transport string) bool {
+		tlsConn := tls.Server(conn, s.tls)
+		if err := tlsConn.Handshake(); err != nil {
+			return false
+		}
+		conn = tlsConn
+		state := tlsConn.ConnectionState()
+		current = session{transport: transport, serverName: state.ServerName}
+		if len(state.PeerCertificates) > 0 {
+			current.clientCN = state.PeerCertificates[0].Subject.CommonName
+		}
+		return true
+	}
+	if s.ldaps && !secure("ldaps") {
+		return
+	}
+
+	for {
+		envelope, err := ber.ReadPacket(conn)
+		if err != nil || len(envelope.Children) < 2 {
+			return
+		}
+		messageID := envelope.Children[0].Value.(int64)
+
+		var response *ber.Packet
+		switch envelope.Children[1].Tag {
+		case opExtendedRequest:
+			response = result(opExtendedResponse)
+		case opBindRequest:
+			s.mu.Lock()
+			s.sessions = append(s.sessions, current)
+			s.mu.Unlock()
+			response = result(opBindResponse)
+		case opUnbindRequest:
+			return
+		default:
+			continue
+		}
+
+		message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+		message.AppendChild(response)
+		if _, err := conn.Write(message.Bytes()); err != nil {
+			return
+		}
+		if envelope.Children[1].Tag == opExtendedRequest && !secure("starttls") {
+			return
+		}
+	}
+}
+
+func result(op ber.Tag) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(ldap.LDAPResultSuccess), "resultCode"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return packet
+}
+
+func TestNewSource(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Test CA")
+	_, certPEM, keyPEM := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	caFile := writeFile(t, dir, "ca.crt", ca.pem)
+	certFile := writeFile(t, dir, "tls.crt", certPEM)
+	keyFile := writeFile(t, dir, "tls.key", keyPEM)
+	garbage := writeFile(t, dir, "garbage.crt", []byte("not a certificate"))
+
+	tests := []struct {
+		name     string
+		opts     Options
+		wantMode string
+		wantErr  string
+	}{
+		{name: "defaults to none", wantMode: ModeNone},
+		{name: "mode is normalised", opts: Options{Mode: " StartTLS "}, wantMode: ModeStartTLS},
+		{name: "everything", opts: Options{Mode: ModeLDAPS, CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, wantMode: ModeLDAPS},
+		{name: "unknown mode", opts: Options{Mode: "ssl"}, wantErr: "unknown LDAP TLS mode"},
+		{name: "certificate without key", opts: Options{CertFile: certFile}, wantErr: "both a certificate and a key file"},
+		{name: "missing CA bundle", opts: Options{CAFile: filepath.Join(dir, "missing.crt")}, wantErr: "failed to stat"},
+		{name: "empty CA bundle", opts: Options{CAFile: garbage}, wantErr: "no certificates found"},
+		{name: "mismatched key", opts: Options{CertFile: caFile, KeyFile: keyFile}, wantErr: "failed to load client certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSource(tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewSource() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSource() error = %v", err)
+			}
+			if s.Mode() != tt.wantMode {
+				t.Errorf("Mode() = %q, want %q", s.Mode(), tt.wantMode)
+			}
+			if s.HasClientCertificate() != (tt.opts.CertFile != "") {
+				t.Errorf("HasClientCertificate() = %v", s.HasClientCertificate())
+			}
+		})
+	}
+}
+
+func TestDial(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Test CA")
+	other := newTestCA(t, "Other CA")
+	_, certPEM, keyPEM := ca.issue(t, "ldap-manager", x509.ExtKeyUsageClientAuth)
+	caFile := writeFile(t, dir, "ca.crt", ca.pem)
+	otherFile := writeFile(t, dir, "other.crt", other.pem)
+	certFile := writeFile(t, dir, "tls.crt", certPEM)
+	keyFile := writeFile(t, dir, "tls.key", keyPEM)
+
+	plain := newTestServer(t, ca, false)
+	ldaps := newTestServer(t, ca, true)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		server  *testServer
+		scheme  string
+		want    session
+		wantErr string
+	}{
+		{
+			name:   "none stays plaintext",
+			opts:   Options{Mode: ModeNone},
+			server: plain, scheme: "ldap",
+			want: session{transport: "plain"},
+		},
+		{
+			name:   "starttls upgrades ldap URLs",
+			opts:   Options{Mode: ModeStartTLS, CAFile: caFile, ServerName: serverName},
+			server: plain, scheme: "ldap",
+			want: session{transport: "starttls", serverName: serverName},
+		},
+		{
+			name:   "ldaps",
+			opts:   Options{Mode: ModeLDAPS, CAFile: caFile, ServerName: serverName},
+			server: ldaps, scheme: "ldaps",
+			want: session{transport: "ldaps", serverName: serverName},
+		},
+		{
+			name:   "ldaps URLs use TLS in mode none",
+			opts:   Options{Mode: ModeNone, CAFile: caFile, ServerName: serverName},
+			server: ldaps, scheme: "ldaps",
+			want: session{transport: "ldaps", serverName: serverName},
+		},
+		{
+			name:   "client certificate",
+			opts:   Options{Mode: ModeStartTLS, CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: serverName},
+			server: plain, scheme: "ldap",
+			want: session{transport: "starttls", serverName: serverName, clientCN: "ldap-manager"},
+		},
+		{
+			name:   "ldaps mode refuses ldap URLs",
+			opts:   Options{Mode: ModeLDAPS, CAFile: caFile, ServerName: serverName},
+			server: plain, scheme: "ldap",
+			wantErr: "is not an ldaps:// URL",
+		},
+		{
+			name:   "server certificate from another CA",
+			opts:   Options{Mode: ModeStartTLS, CAFile: otherFile, ServerName: serverName},
+			server: plain, scheme: "ldap",
+			wantErr: "StartTLS failed",
+		},
+		{
+			name:   "server certificate for another name",
+			opts:   Options{Mode: ModeLDAPS, CAFile: caFile},
+			server: ldaps, scheme: "ldaps",
+			wantErr: "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSource(tt.opts)
+			if err != nil {
+				t.Fatalf("NewSource() error = %v", err)
+			}
+			before := len(tt.server.bound())
+
+			conn, err := s.Dial(tt.server.URL(tt.scheme))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Dial() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+			if err := conn.Bind("cn=admin,dc=example,dc=com", "secret"); err != nil {
+				t.Fatalf("Bind() error = %v", err)
+			}
+
+			sessions := tt.server.bound()
+			if len(sessions) != before+1 || sessions[before] != tt.want {
+				t.Errorf("server saw %+v, want %+v", sessions[before:], tt.want)
+			}
+		})
+	}
+}
+
+func TestDialNilSource(t *testing.T) {
+	server := newTestServer(t, newTestCA(t, "Test CA"), false)
+
+	var s *Source
+	conn, err := s.Dial(server.URL("ldap"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	if err := conn.Bind("cn=admin,dc=example,dc=com", "secret"); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if got := server.bound(); len(got) != 1 || got[0].transport != "plain" {
+		t.Errorf("server saw %+v, want a plaintext bind", got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newTestCA(t, "Old CA")
+	newCA := newTestCA(t, "New CA")
+	caFile := writeFile(t, dir, "ca.crt", oldCA.pem)
+	server := newTestServer(t, newCA, true)
+
+	s, err := NewSource(Options{Mode: ModeLDAPS, CAFile: caFile, ServerName: serverName})
+	if err != nil {
+		t.Fatalf("NewSource() error = %v", err)
+	}
+	dial := func() error {
+		conn, err := s.Dial(server.URL("ldaps"))
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if dial() == nil {
+		t.Fatal("Dial() trusted a server signed by a CA that is not in the bundle yet")
+	}
+
+	if reloaded, err := s.Reload(); reloaded || err != nil {
+		t.Fatalf("Reload() of unchanged files = %v, %v, want false", reloaded, err)
+	}
+	if s.Generation() != 1 {
+		t.Errorf("Generation() = %d, want 1", s.Generation())
+	}
+
+	// The bundle is rotated to the server's CA
+	writeFile(t, dir, "ca.crt", newCA.pem)
+	if reloaded, err := s.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload() of a rotated bundle = %v, %v, want true", reloaded, err)
+	}
+	if s.Generation() != 2 {
+		t.Errorf("Generation() = %d, want 2", s.Generation())
+	}
+	if err := dial(); err != nil {
+		t.Fatalf("Dial() after reload error = %v", err)
+	}
+
+	// A broken rotation keeps the material in use
+	writeFile(t, dir, "ca.crt", []byte("truncated"))
+	if _, err := s.Reload(); err == nil {
+		t.Fatal("Reload() accepted a bundle without certificates")
+	}
+	if s.Generation() != 2 {
+		t.Errorf("Generation() after a failed reload = %d, want 2", s.Generation())
+	}
+	if err := dial(); err != nil {
+		t.Errorf("Dial() after a failed reload error = %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -34,6 +35,28 @@ type User struct {
 	DN           string   `json:"dn"`
 }
 
+// Grant represents a repository grant from LDAP Manager service.
+// Permission is the RepositoryPermission enum name (READ, WRITE or ADMIN).
+type Grant struct {
+	Repository string `json:"repository"`
+	Permission string `json:"permission"`
+	ExpiresAt  string `json:"expiresAt,omitempty"`
+}
+
+// Level returns the grant's permission as Gitea names it
+func (g Grant) Level() string {
+	return strings.ToLower(g.Permission)
+}
+
+// Active reports whether the grant has not expired at now
+func (g Grant) Active(now time.Time) bool {
+	if g.ExpiresAt == "" {
+		return true
+	}
+	expiresAt, err := time.Parse(time.RFC3339, g.ExpiresAt)
+	return err == nil && now.Before(expiresAt)
+}
+
 // Department represents a department from LDAP Manager service
 type Department struct {
 	OU           string   `json:"ou"`
@@ -41,6 +64,7 @@ type Department struct {
 	Manager      string   `json:"manager,omitempty"`
 	Members      []string `json:"members"`
 	Repositories []string `json:"repositories"`
+	Grants       []Grant  `json:"grants"`
 	DN           string   `json:"dn"`
 }
 
@@ -51,6 +75,7 @@ type Group struct {
 	Description  string   `json:"description,omitempty"`
 	Members      []string `json:"members"`
 	Repositories []string `json:"repositories"`
+	Grants       []Grant  `json:"grants"`
 	DN           string   `json:"dn"`
 }
 
@@ -178,6 +203,7 @@ func (c *Client) GetDepartment(ctx context.Context, ou string, token string) (*D
 				manager
 				members
 				repositories
+				grants { repository permission expiresAt }
 				dn
 			}
 		}
@@ -275,6 +301,7 @@ func (c *Client) GetGroup(ctx context.Context, cn string) (*Group, error) {
 				description
 				members
 				repositories
+				grants { repository permission expiresAt }
 				dn
 			}
 		}
@@ -389,6 +416,7 @@ func (c *Client) ListAllGroups(ctx context.Context, token string) ([]*Group, err
 				gidNumber
 				members
 				repositories
+				grants { repository permission expiresAt }
 				dn
 			}
 		}
@@ -439,6 +467,7 @@ func (c *Client) ListAllDepartments(ctx context.Context, token string) ([]*Depar
 				manager
 				members
 				repositories
+				grants { repository permission expiresAt }
 				dn
 			}
 		}
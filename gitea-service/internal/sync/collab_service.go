@@ -3,6 +3,7 @@ package sync
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/devplatform/gitea-service/internal/gitea"
 	"github.com/devplatform/gitea-service/internal/ldap"
@@ -71,7 +72,7 @@ func (s *CollabService) AddRepoToGroup(ctx context.Context, groupCN, repo, token
 
 	// Immediate sync to Gitea
 	orgName := s.controller.cfg.GetDefaultOwner()
-	result, err := s.groupSyncService.SyncGroupToTeam(ctx, groupCN, orgName, groupCN, "write", "")
+	result, err := s.groupSyncService.SyncGroupToTeam(ctx, groupCN, orgName, groupCN, DefaultPermission, "")
 	if err != nil {
 		s.logger.WithError(err).Warn("Immediate group sync failed after adding repo")
 		return nil, fmt.Errorf("sync failed after adding repo: %w", err)
@@ -100,7 +101,7 @@ func (s *CollabService) RemoveRepoFromGroup(ctx context.Context, groupCN, repo,
 
 	// Sync to Gitea (will update team repos)
 	orgName := s.controller.cfg.GetDefaultOwner()
-	result, err := s.groupSyncService.SyncGroupToTeam(ctx, groupCN, orgName, groupCN, "write", "")
+	result, err := s.groupSyncService.SyncGroupToTeam(ctx, groupCN, orgName, groupCN, DefaultPermission, "")
 	if err != nil {
 		return nil, fmt.Errorf("sync failed after removing repo: %w", err)
 	}
@@ -133,7 +134,7 @@ func (s *CollabService) AddRepoToDepartment(ctx context.Context, ou, repo, token
 
 	// Immediate sync — manager gets admin access
 	orgName := s.controller.cfg.GetDefaultOwner()
-	result, err := s.groupSyncService.SyncDepartmentToTeam(ctx, ou, orgName, ou, "write", token)
+	result, err := s.groupSyncService.SyncDepartmentToTeam(ctx, ou, orgName, ou, DefaultPermission, token)
 	if err != nil {
 		return nil, fmt.Errorf("sync failed after adding repo to department: %w", err)
 	}
@@ -161,7 +162,7 @@ func (s *CollabService) RemoveRepoFromDepartment(ctx context.Context, ou, repo,
 	}
 
 	orgName := s.controller.cfg.GetDefaultOwner()
-	result, err := s.groupSyncService.SyncDepartmentToTeam(ctx, ou, orgName, ou, "write", token)
+	result, err := s.groupSyncService.SyncDepartmentToTeam(ctx, ou, orgName, ou, DefaultPermission, token)
 	if err != nil {
 		return nil, fmt.Errorf("sync failed after removing repo from department: %w", err)
 	}
@@ -231,7 +232,7 @@ func (s *CollabService) CreateCollabGroup(
 
 	// Immediate sync to Gitea
 	orgName := s.controller.cfg.GetDefaultOwner()
-	result, err := s.groupSyncService.SyncCollabGroup(ctx, name, meta, orgName, "write", token)
+	result, err := s.groupSyncService.SyncCollabGroup(ctx, name, meta, orgName, DefaultPermission, token)
 	if err != nil {
 		s.logger.WithError(err).Warn("Immediate collab group sync failed")
 		return nil, fmt.Errorf("sync failed after creating collab group: %w", err)
@@ -249,10 +250,13 @@ func (s *CollabService) CreateCollabGroup(
 
 // DeleteCollabGroup removes a collab group: deletes LDAP group + Gitea team
 func (s *CollabService) DeleteCollabGroup(ctx context.Context, groupCN, token string) error {
-	// Delete the Gitea team
+	// Delete the Gitea teams, one per permission level
 	orgName := s.controller.cfg.GetDefaultOwner()
-	if err := s.groupSyncService.DeleteTeamByName(ctx, orgName, groupCN); err != nil {
-		s.logger.WithError(err).Warn("Failed to delete Gitea team for collab group")
+	for _, permission := range permissionLevels {
+		teamName := permissionTeamName(groupCN, permission, DefaultPermission)
+		if err := s.groupSyncService.DeleteTeamByName(ctx, orgName, teamName); err != nil {
+			s.logger.WithError(err).Warnf("Failed to delete Gitea team %s for collab group", teamName)
+		}
 	}
 
 	// Delete the LDAP group
@@ -353,7 +357,7 @@ func (s *CollabService) ListRepoAccess(ctx context.Context, repo, token string)
 				CN:         dept.OU,
 				GroupType:  "department",
 				Members:    dept.Members,
-				Permission: "write",
+				Permission: grantPermission(dept.Grants, repo),
 			})
 		}
 	}
@@ -370,7 +374,7 @@ func (s *CollabService) ListRepoAccess(ctx context.Context, repo, token string)
 				CN:         group.CN,
 				GroupType:  "group",
 				Members:    group.Members,
-				Permission: "write",
+				Permission: grantPermission(group.Grants, repo),
 			}
 
 			// Check if this is a collab group
@@ -410,6 +414,17 @@ func removeFromSlice(slice []string, item string) []string {
 	return result
 }
 
+// grantPermission returns the permission an active grant gives on repo, or
+// DefaultPermission for a repository listed without a grant
+func grantPermission(grants []ldap.Grant, repo string) string {
+	for _, grant := range grants {
+		if grant.Repository == repo && grant.Active(time.Now()) {
+			return grant.Level()
+		}
+	}
+	return DefaultPermission
+}
+
 func containsString(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
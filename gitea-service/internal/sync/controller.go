@@ -783,7 +783,7 @@ func (c *Controller) runGroupSync() {
 			continue
 		}
 
-		result, err := c.groupSyncService.SyncDepartmentToTeam(ctx, dept.OU, orgName, dept.OU, "write", token)
+		result, err := c.groupSyncService.SyncDepartmentToTeam(ctx, dept.OU, orgName, dept.OU, DefaultPermission, token)
 		if err != nil {
 			c.logger.WithError(err).Errorf("Failed to sync department %s", dept.OU)
 			syncErrors++
@@ -817,7 +817,7 @@ func (c *Controller) runGroupSync() {
 
 		if isCollab {
 			// This is a dynamic collab group — resolve membership from dept + extras
-			result, err := c.groupSyncService.SyncCollabGroup(ctx, group.CN, meta, orgName, "write", token)
+			result, err := c.groupSyncService.SyncCollabGroup(ctx, group.CN, meta, orgName, DefaultPermission, token)
 			if err != nil {
 				c.logger.WithError(err).Errorf("Failed to sync collab group %s", group.CN)
 				syncErrors++
@@ -831,7 +831,7 @@ func (c *Controller) runGroupSync() {
 			}).Info("Collab group synced to Gitea team")
 		} else {
 			// Regular LDAP group — sync directly
-			result, err := c.groupSyncService.SyncGroupToTeam(ctx, group.CN, orgName, group.CN, "write", "")
+			result, err := c.groupSyncService.SyncGroupToTeam(ctx, group.CN, orgName, group.CN, DefaultPermission, "")
 			if err != nil {
 				c.logger.WithError(err).Errorf("Failed to sync group %s", group.CN)
 				syncErrors++
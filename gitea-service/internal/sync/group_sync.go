@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/devplatform/gitea-service/internal/gitea"
 	"github.com/devplatform/gitea-service/internal/ldap"
@@ -26,6 +27,14 @@ func NewGroupSyncService(giteaClient *gitea.Client, ldapClient *ldap.Client, log
 	}
 }
 
+// DefaultPermission is the Gitea permission of the team named after an LDAP
+// group or department. Repositories granted at another level go to a
+// companion team named <team>-<permission>.
+const DefaultPermission = "write"
+
+// permissionLevels lists the repository permission levels LDAP grants
+var permissionLevels = []string{"read", "write", "admin"}
+
 // SyncResult contains the result of a sync operation
 type SyncResult struct {
 	Team               *gitea.Team
@@ -83,29 +92,18 @@ func (s *GroupSyncService) SyncGroupToTeam(
 		teamName = group.CN
 	}
 
-	// STEP 2: Create or find Gitea team
-	team, err := s.createOrGetTeam(ctx, orgName, teamName, group.Description, permission)
+	// STEP 2-4: Sync a team per permission level with members and repositories
+	team, err := s.syncPermissionTeams(ctx, orgName, teamName, group.Description, permission, group.Members, group.Grants, group.Repositories, result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create/get team: %w", err)
+		return nil, err
 	}
 	result.Team = team
 
-	// STEP 3: Sync members
-	s.syncMembers(ctx, team, group.Members, result)
-
-	// STEP 4: Sync repositories
-	s.syncRepositories(ctx, team, group.Repositories, result)
-
 	// STEP 5: Grant manager admin access on all repos
 	if manager != "" {
 		s.grantManagerAdmin(ctx, manager, group.Repositories, result)
 	}
 
-	// STEP 6: Remove stale members from Gitea that are no longer in LDAP
-	if err := s.RemoveMembersNotInLDAP(ctx, team.ID, group.Members); err != nil {
-		s.logger.WithError(err).Warn("Failed to remove stale members from team")
-	}
-
 	s.logger.WithFields(logrus.Fields{
 		"teamId":         team.ID,
 		"teamName":       team.Name,
@@ -119,7 +117,8 @@ func (s *GroupSyncService) SyncGroupToTeam(
 }
 
 // SyncDepartmentToTeam syncs a department to a Gitea team.
-// Department members get the specified permission (typically "write").
+// Department members get the specified permission (typically DefaultPermission)
+// on the team named teamName, and other levels on its companion teams.
 // The department manager automatically gets admin collaborator access on all repos.
 func (s *GroupSyncService) SyncDepartmentToTeam(
 	ctx context.Context,
@@ -150,29 +149,18 @@ func (s *GroupSyncService) SyncDepartmentToTeam(
 		teamName = dept.OU
 	}
 
-	// STEP 2: Create or find Gitea team
-	team, err := s.createOrGetTeam(ctx, orgName, teamName, dept.Description, permission)
+	// STEP 2-4: Sync a team per permission level with members and repositories
+	team, err := s.syncPermissionTeams(ctx, orgName, teamName, dept.Description, permission, dept.Members, dept.Grants, dept.Repositories, result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create/get team: %w", err)
+		return nil, err
 	}
 	result.Team = team
 
-	// STEP 3: Sync members
-	s.syncMembers(ctx, team, dept.Members, result)
-
-	// STEP 4: Sync repositories
-	s.syncRepositories(ctx, team, dept.Repositories, result)
-
 	// STEP 5: Grant manager admin access on all repos
 	if dept.Manager != "" {
 		s.grantManagerAdmin(ctx, dept.Manager, dept.Repositories, result)
 	}
 
-	// STEP 6: Remove stale members
-	if err := s.RemoveMembersNotInLDAP(ctx, team.ID, dept.Members); err != nil {
-		s.logger.WithError(err).Warn("Failed to remove stale members from department team")
-	}
-
 	s.logger.WithFields(logrus.Fields{
 		"teamId":         team.ID,
 		"teamName":       team.Name,
@@ -259,29 +247,18 @@ func (s *GroupSyncService) SyncCollabGroup(
 		}
 	}
 
-	// STEP 5: Create or find Gitea team
-	team, err := s.createOrGetTeam(ctx, orgName, groupCN, group.Description, permission)
+	// STEP 5-7: Sync a team per permission level with members and repositories
+	team, err := s.syncPermissionTeams(ctx, orgName, groupCN, group.Description, permission, finalMembers, group.Grants, group.Repositories, result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create/get team: %w", err)
+		return nil, err
 	}
 	result.Team = team
 
-	// STEP 6: Sync members to Gitea team
-	s.syncMembers(ctx, team, finalMembers, result)
-
-	// STEP 7: Sync repositories
-	s.syncRepositories(ctx, team, group.Repositories, result)
-
 	// STEP 8: Grant department manager admin access
 	if dept.Manager != "" {
 		s.grantManagerAdmin(ctx, dept.Manager, group.Repositories, result)
 	}
 
-	// STEP 9: Remove stale Gitea team members
-	if err := s.RemoveMembersNotInLDAP(ctx, team.ID, finalMembers); err != nil {
-		s.logger.WithError(err).Warn("Failed to remove stale members from collab team")
-	}
-
 	s.logger.WithFields(logrus.Fields{
 		"teamId":         team.ID,
 		"groupCN":        groupCN,
@@ -294,6 +271,91 @@ func (s *GroupSyncService) SyncCollabGroup(
 	return result, nil
 }
 
+// syncPermissionTeams keeps one Gitea team per permission level in sync
+// with members and the repositories granted at that level. The team at
+// basePermission is named teamName and always exists; the others are named
+// by permissionTeamName and only created once something is granted at
+// their level. Returns the teamName team.
+func (s *GroupSyncService) syncPermissionTeams(
+	ctx context.Context,
+	orgName string,
+	teamName string,
+	description string,
+	basePermission string,
+	members []string,
+	grants []ldap.Grant,
+	repoURLs []string,
+	result *SyncResult,
+) (*gitea.Team, error) {
+	byPermission := reposByPermission(grants, repoURLs, basePermission)
+
+	var baseTeam *gitea.Team
+	for _, permission := range permissionLevels {
+		name := permissionTeamName(teamName, permission, basePermission)
+		repos := byPermission[permission]
+
+		if permission != basePermission && len(repos) == 0 {
+			// Nothing granted at this level any more: empty a leftover team
+			teams, err := s.giteaClient.SearchTeams(ctx, orgName, name)
+			if err == nil && len(teams) > 0 {
+				if err := s.RemoveMembersNotInLDAP(ctx, teams[0].ID, nil); err != nil {
+					s.logger.WithError(err).Warnf("Failed to empty team %s", name)
+				}
+			}
+			continue
+		}
+
+		team, err := s.createOrGetTeam(ctx, orgName, name, description, permission)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create/get team %s: %w", name, err)
+		}
+		if permission == basePermission {
+			baseTeam = team
+		}
+
+		s.syncMembers(ctx, team, members, result)
+		s.syncRepositories(ctx, team, repos, result)
+
+		// Remove stale members from Gitea that are no longer in LDAP
+		if err := s.RemoveMembersNotInLDAP(ctx, team.ID, members); err != nil {
+			s.logger.WithError(err).Warnf("Failed to remove stale members from team %s", name)
+		}
+	}
+
+	return baseTeam, nil
+}
+
+// permissionTeamName names the team holding the repositories granted at
+// permission: teamName itself at basePermission, teamName-<permission>
+// otherwise
+func permissionTeamName(teamName, permission, basePermission string) string {
+	if permission == basePermission {
+		return teamName
+	}
+	return teamName + "-" + permission
+}
+
+// reposByPermission groups the repositories of active grants by permission
+// level. Repositories without a grant, as listed by LDAP Managers predating
+// grants, go at basePermission.
+func reposByPermission(grants []ldap.Grant, repoURLs []string, basePermission string) map[string][]string {
+	byPermission := make(map[string][]string)
+	granted := make(map[string]bool)
+	now := time.Now()
+	for _, grant := range grants {
+		granted[grant.Repository] = true
+		if grant.Active(now) {
+			byPermission[grant.Level()] = append(byPermission[grant.Level()], grant.Repository)
+		}
+	}
+	for _, repoURL := range repoURLs {
+		if !granted[repoURL] {
+			byPermission[basePermission] = append(byPermission[basePermission], repoURL)
+		}
+	}
+	return byPermission
+}
+
 // syncMembers adds a list of UIDs to a Gitea team, tracking results in SyncResult
 func (s *GroupSyncService) syncMembers(ctx context.Context, team *gitea.Team, members []string, result *SyncResult) {
 	for _, memberUID := range members {
@@ -33,8 +33,8 @@ cd /d "%PROJECT_DIR%\codeserver-service"
 nerdctl build -t codeserver-service:latest . || (echo ERROR: codeserver-service build failed && exit /b 1)
 
 echo Building ldap-init...
-cd /d "%SCRIPT_DIR%devplatform\charts\openldap\init-container"
-nerdctl build -t ldap-init:latest . || (echo ERROR: ldap-init build failed && exit /b 1)
+cd /d "%PROJECT_DIR%"
+nerdctl build -t ldap-init:latest -f helm\devplatform\charts\openldap\init-container\Dockerfile . || (echo ERROR: ldap-init build failed && exit /b 1)
 
 echo.
 echo [2/5] Loading images into k8s namespace...
@@ -2,7 +2,10 @@ module github.com/devplatform/ldap-init
 
 go 1.21
 
-require github.com/go-ldap/ldap/v3 v3.4.6
+require (
+	github.com/devplatform/ldap-manager v0.0.0
+	github.com/go-ldap/ldap/v3 v3.4.6
+)
 
 require (
 	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
@@ -10,3 +13,7 @@ require (
 	github.com/google/uuid v1.3.1 // indirect
 	golang.org/x/crypto v0.17.0 // indirect
 )
+
+// The schema package is shared with the backend; the image is built from
+// the repository root so this path resolves
+replace github.com/devplatform/ldap-manager => ../../../../../backend
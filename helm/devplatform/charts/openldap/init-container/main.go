@@ -6,6 +6,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/devplatform/ldap-manager/pkg/ldapschema"
+	"github.com/devplatform/ldap-manager/pkg/ldaptls"
 	ldap "github.com/go-ldap/ldap/v3"
 )
 
@@ -24,11 +26,28 @@ func main() {
 	configDN := env("LDAP_CONFIG_DN", "cn=admin,cn=config")
 	configPW := env("LDAP_CONFIG_PASSWORD", "config123")
 
+	// LDAP_TLS_MODE is none, starttls or ldaps; ldaps:// URLs always use TLS
+	tlsSource, err := ldaptls.NewSource(ldaptls.Options{
+		Mode:               env("LDAP_TLS_MODE", ldaptls.ModeNone),
+		CAFile:             os.Getenv("LDAP_TLS_CA_FILE"),
+		CertFile:           os.Getenv("LDAP_TLS_CERT_FILE"),
+		KeyFile:            os.Getenv("LDAP_TLS_KEY_FILE"),
+		ServerName:         os.Getenv("LDAP_TLS_SERVER_NAME"),
+		InsecureSkipVerify: os.Getenv("LDAP_TLS_INSECURE_SKIP_VERIFY") == "true",
+	})
+	if err != nil {
+		log.Fatalf("Invalid LDAP TLS settings: %v", err)
+	}
+
 	// ─── Wait for OpenLDAP ───
 	fmt.Println("── Waiting for OpenLDAP to be ready ──")
 	maxRetries := 60
 	for i := 1; i <= maxRetries; i++ {
-		c, err := ldap.DialURL(ldapURL)
+		// Certificates rotated while OpenLDAP starts are picked up
+		if _, err := tlsSource.Reload(); err != nil {
+			log.Printf("Warning: Failed to reload LDAP certificates: %v", err)
+		}
+		c, err := tlsSource.Dial(ldapURL)
 		if err == nil {
 			if bindErr := c.Bind(adminDN, adminPW); bindErr == nil {
 				fmt.Printf("OpenLDAP ready after %d attempts\n", i)
@@ -44,58 +63,44 @@ func main() {
 		time.Sleep(5 * time.Second)
 	}
 
-	// ─── Register custom schema (githubRepository attribute) ───
-	fmt.Println("\n── Registering custom LDAP schema ──")
-	configConn, err := ldap.DialURL(ldapURL)
+	// ─── Connect as data admin ───
+	conn, err := tlsSource.Dial(ldapURL)
 	if err != nil {
-		log.Fatalf("Failed to connect for schema: %v", err)
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(adminDN, adminPW); err != nil {
+		log.Fatalf("Failed to bind: %v", err)
 	}
+	fmt.Println("Connected to LDAP as admin")
 
-	err = configConn.Bind(configDN, configPW)
+	// ─── Apply pending schema and data migrations ───
+	fmt.Println("\n── Migrating LDAP schema ──")
+	migrator := &ldapschema.Migrator{Data: conn, BaseDN: baseDN}
+	configConn, err := tlsSource.Dial(ldapURL)
 	if err != nil {
-		log.Printf("Warning: Failed to bind as config admin: %v (schema registration skipped)", err)
+		log.Printf("Warning: Failed to connect for schema: %v (schema migrations skipped)", err)
+	} else if err := configConn.Bind(configDN, configPW); err != nil {
+		log.Printf("Warning: Failed to bind as config admin: %v (schema migrations skipped)", err)
+		configConn.Close()
 	} else {
 		fmt.Println("Connected to cn=config as admin")
-
-		sr, err := configConn.Search(ldap.NewSearchRequest(
-			"cn=schema,cn=config", ldap.ScopeSingleLevel,
-			ldap.NeverDerefAliases, 0, 0, false,
-			"(cn=*devplatform)", []string{"cn"}, nil,
-		))
-		if err == nil && len(sr.Entries) > 0 {
-			fmt.Println("Custom schema (devplatform) already exists, skipping")
-		} else {
-			addSchemaReq := ldap.NewAddRequest("cn=devplatform,cn=schema,cn=config", nil)
-			addSchemaReq.Attribute("objectClass", []string{"olcSchemaConfig"})
-			addSchemaReq.Attribute("cn", []string{"devplatform"})
-			addSchemaReq.Attribute("olcAttributeTypes", []string{
-				"( 1.3.6.1.4.1.99999.1.1 NAME 'githubRepository' " +
-					"DESC 'Repository URL (GitHub/Gitea)' " +
-					"EQUALITY caseIgnoreMatch " +
-					"SUBSTR caseIgnoreSubstringsMatch " +
-					"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
-			})
-			if err := configConn.Add(addSchemaReq); err != nil {
-				log.Printf("Warning: Failed to register custom schema: %v", err)
-			} else {
-				fmt.Println("Registered custom schema (githubRepository attribute)")
-			}
-		}
+		migrator.Config = configConn
+		defer configConn.Close()
 	}
-	configConn.Close()
 
-	// ─── Connect as data admin ───
-	fmt.Println("\n── Initializing LDAP data ──")
-	conn, err := ldap.DialURL(ldapURL)
+	result, err := migrator.Migrate()
+	for _, migration := range result.Applied {
+		fmt.Printf("Applied migration %d: %s\n", migration.Version, migration.Description)
+	}
 	if err != nil {
-		log.Fatalf("Failed to connect: %v", err)
+		log.Printf("Warning: Failed to migrate schema: %v", err)
+	} else if result.From == result.To {
+		fmt.Printf("Schema already at version %d, skipping\n", result.To)
 	}
-	defer conn.Close()
 
-	if err := conn.Bind(adminDN, adminPW); err != nil {
-		log.Fatalf("Failed to bind: %v", err)
-	}
-	fmt.Println("Connected to LDAP as admin")
+	fmt.Println("\n── Initializing LDAP data ──")
 
 	// ─── Create base OUs ───
 	fmt.Println("\n── Creating OUs ──")
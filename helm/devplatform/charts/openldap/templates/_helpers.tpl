@@ -24,3 +24,14 @@ app.kubernetes.io/part-of: devplatform
 {{- define "openldap.selectorLabels" -}}
 app: {{ include "openldap.name" . }}
 {{- end }}
+
+{{/*
+URL of the first replica, on the LDAPS port when global.ldap.tls.mode is ldaps
+*/}}
+{{- define "openldap.url" -}}
+{{- if eq (.Values.global.ldap.tls.mode | default "none") "ldaps" -}}
+ldaps://openldap1.{{ include "openldap.namespace" . }}.svc.cluster.local:636
+{{- else -}}
+ldap://openldap1.{{ include "openldap.namespace" . }}.svc.cluster.local:389
+{{- end }}
+{{- end }}